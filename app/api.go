@@ -3,25 +3,155 @@ package app
 
 import (
 	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
 	"dnscoffee/server"
+	"dnscoffee/version"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// noImportedData names the routes whose responses are not built from imported data and carry no data age
+var noImportedData = map[string]bool{"version": true, "watchlists": true, "watchlist": true}
+
+// suspectPrefixes are the paths of the feed and statistics routes whose responses are flagged when they derive from
+// suspect imports, see importChecks
+var suspectPrefixes = []string{"/feeds/", "/stats/", "/counts", "/zones/{zone}/count", "/zones/{zone}/diff", "/zones/{zone}/stats/"}
+
+// flagsSuspect returns true if the responses of the route of path are flagged when they derive from suspect imports
+func flagsSuspect(path string) bool {
+	for _, prefix := range suspectPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // APIStart entry point for starting application
 // adds routes to the server so that the correct handlers are registered
 func APIStart(app *appContext, coffeeServer *server.Server) {
-	app.api = make(map[string]string)
+	app.api = make(map[int]map[string]string)
+	v1 := coffeeServer.Version(1)
+	app.api[1] = make(map[string]string)
+
+	// query parameters accepted by each route, parameters of other routes are ignored and reported in a header
+	feedQueries := params.Queries{"data_version": params.FormatInt}
+	domainFeedQueries := params.Queries{"data_version": params.FormatInt, "source": params.FormatText, "min_stability": params.FormatText}
+	queries := map[string]params.Queries{
+		"/zones/{zone}/diff": {
+			"from":   params.FormatDate,
+			"to":     params.FormatDate,
+			"set":    params.FormatText,
+			"limit":  params.FormatInt,
+			"cursor": params.FormatText,
+		},
+		"/nameservers/{domain}/stats": {
+			"from": params.FormatDate,
+			"to":   params.FormatDate,
+			"zone": params.FormatDomain,
+		},
+		"/nameservers/{domain}/changes": {
+			"start":  params.FormatDate,
+			"end":    params.FormatDate,
+			"limit":  params.FormatInt,
+			"cursor": params.FormatText,
+			"format": params.FormatText,
+		},
+		"/nameservers/suffix/{suffix}/stats": {
+			"limit": params.FormatInt,
+		},
+		"/watchlists/{id}/matches": {
+			"limit":  params.FormatInt,
+			"cursor": params.FormatText,
+		},
+		"/watchlists/{id}/new": {
+			"since":  params.FormatDate,
+			"limit":  params.FormatInt,
+			"cursor": params.FormatText,
+		},
+		"/zones/{zone}/inconsistencies": {
+			"type":   params.FormatText,
+			"limit":  params.FormatInt,
+			"cursor": params.FormatText,
+		},
+		"/zones/{zone}/domains": {
+			"active":       params.FormatText,
+			"limit":        params.FormatInt,
+			"cursor":       params.FormatText,
+			"format":       params.FormatText,
+			"data_version": params.FormatInt,
+		},
+		"/label/{label}": {
+			"format": params.FormatText,
+		},
+		"/stats/churn": {
+			"zone":   params.FormatDomain,
+			"window": params.FormatText,
+		},
+		"/stats/lifetimes": {
+			"zone":   params.FormatDomain,
+			"cohort": params.FormatMonth,
+		},
+		"/cohorts/{month}/sample": {
+			"size": params.FormatInt,
+			"seed": params.FormatText,
+			"zone": params.FormatDomain,
+		},
+		"/stats/keywords/{keyword}/timeseries": {
+			"granularity": params.FormatText,
+			"from":        params.FormatDate,
+			"to":          params.FormatDate,
+		},
+		"/zones/{zone}/infrastructure/history": {
+			"limit": params.FormatInt,
+		},
+		"/zones/{zone}/stats/labels": {
+			"as_of": params.FormatDate,
+		},
+		"/zones/{zone}/count": {
+			"date":  params.FormatDate,
+			"dates": params.FormatText,
+		},
+		"/nsset/{fingerprint}": {
+			"limit":         params.FormatInt,
+			"cursor":        params.FormatText,
+			"min_stability": params.FormatText,
+		},
+		"/feeds/new/since/{checkpoint}": {
+			"zone":   params.FormatDomain,
+			"source": params.FormatText,
+			"limit":  params.FormatInt,
+		},
+		"/feeds/new/{date}/download":   {"source": params.FormatText},
+		"/feeds/old/{date}/download":   {"source": params.FormatText},
+		"/feeds/moved/{date}/download": {"source": params.FormatText},
+		"/feeds/new/search/{search}":   feedQueries,
+		"/feeds/new/date/{date}":       domainFeedQueries,
+		"/feeds/ns/new/date/{date}":    feedQueries,
+		"/feeds/old/search/{search}":   feedQueries,
+		"/feeds/old/date/{date}":       domainFeedQueries,
+		"/feeds/ns/old/date/{date}":    feedQueries,
+		"/feeds/moved/search/{search}": feedQueries,
+		"/feeds/moved/date/{date}":     domainFeedQueries,
+		"/feeds/ns/moved/date/{date}":  feedQueries,
+	}
 
-	// Adds a method to the router's GET handler but also adds it to the API index map
-	// description is the API function description
-	addAPI := func(path, description string, fn http.HandlerFunc) {
+	// Adds a method to the router's GET handler of version 1 but also adds it to the API index map
+	// description is the API function description, deprecated routes are flagged in the index
+	// the query parameters are checked against the route's entry in queries before fn runs
+	// description also names the route for API.Disabled_Routes and the admin API, see server.Named
+	// the responses of the routes serving imported data carry its age, see freshness, and those of the feeds and
+	// statistics the suspect imports they derive from, see importChecks
+	addAPI := func(path, description string, fn http.HandlerFunc, opts ...server.RouteOption) {
 		re := regexp.MustCompile(":[a-zA-Z0-9_]*")
 		paramPath := re.ReplaceAllStringFunc(path, func(s string) string { return fmt.Sprintf("{%s}", s[1:]) })
 		if fn == nil { // hide WIP
@@ -29,12 +159,30 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 			//fn = server.HandlerNotImplemented
 			//description = fmt.Sprintf("[WIP] %s", description)
 		}
-		app.api[description] = paramPath
-		coffeeServer.Get("/api"+path, fn)
+		opts = append(opts, server.Named(description))
+		if server.IsDeprecated(opts...) {
+			description = fmt.Sprintf("[DEPRECATED] %s", description)
+		}
+		app.api[1][description] = paramPath
+		if flagsSuspect(path) {
+			fn = app.importChecks.handler(fn)
+		}
+		if !noImportedData[description] {
+			fn = app.freshness.handler(fn)
+		}
+		v1.Get(path, params.Check(queries[path], fn), opts...)
 	}
 
+	// routes registered with server.Expensive scan many rows and are shed first under load
+
 	// imports
 	addAPI("/stats/imports", "imports", app.apiImportStatusHandler)
+	addAPI("/stats/providers", "provider_counts", app.apiProviderStatsHandler)
+	addAPI("/stats/lifetimes", "domain_lifetimes", app.apiLifetimesHandler)
+	addAPI("/stats/churn", "zone_churn", app.apiZoneChurnHandler, server.Expensive())
+	addAPI("/stats/keywords/{keyword}/timeseries", "keyword_timeseries", app.apiKeywordTimeseriesHandler)
+	addAPI("/cohorts/{month}/sample", "cohort_sample", app.apiCohortSampleHandler, server.Expensive())
+	addAPI("/alerts", "import_alerts", app.apiImportAlertsHandler)
 	addAPI("/imports/{year}/{month}/{day}", "import_day_view", nil)
 	addAPI("/imports/{year}/{month}/{day}/{zone}", "import_day_view_zone", nil)
 
@@ -50,6 +198,13 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 	addAPI("/zones", "zones", app.apiLatestZonesHandler)
 	addAPI("/zones/{zone}", "zone_view", app.apiZoneHandler)
 	addAPI("/zones/{zone}/import", "zone_import", app.apiZoneImportHandler)
+	addAPI("/zones/{zone}/count", "zone_count", app.apiZoneCountHandler)
+	addAPI("/zones/{zone}/stats/labels", "label_stats", app.apiLabelStatsHandler)
+	addAPI("/zones/{zone}/infrastructure", "zone_infrastructure", app.apiZoneInfrastructureHandler)
+	addAPI("/zones/{zone}/infrastructure/history", "zone_infrastructure_history", app.apiZoneInfrastructureHistoryHandler)
+	addAPI("/zones/{zone}/diff", "zone_diff", app.apiZoneDiffHandler, server.Expensive())
+	addAPI("/zones/{zone}/domains", "zone_domains", app.dataVersion(app.apiZoneDomainsHandler), server.Expensive())
+	addAPI("/zones/{zone}/inconsistencies", "zone_inconsistencies", app.apiZoneInconsistenciesHandler)
 	addAPI("/zones/{zone}/nameservers", "zone_nameservers", nil)
 	addAPI("/zones/{zone}/nameservers/current", "zone_nameservers_current", nil)
 	addAPI("/zones/{zone}/nameservers/archive", "zone_nameservers_archive", nil)
@@ -58,6 +213,11 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 	// domains
 	addAPI("/random", "random_domain", app.apiRandomDomainHandler)
 	addAPI("/domains/{domain}", "domain", app.apiDomainHandler)
+	addAPI("/domains/{domain}/stability", "domain_stability", app.apiDomainStabilityHandler)
+	if app.liveDNS != nil {
+		addAPI("/domains/{domain}/live", "domain_live", coffeeServer.RouteRateLimit("live_dns", app.liveDNSRequestsPerMinute, app.liveDNSRequestsBurst, app.apiDomainLiveHandler))
+	}
+	addAPI("/label/{label}", "label_zones", app.apiLabelHandler)
 	addAPI("/domains/{domain}/nameservers", "domain_nameservers", nil)
 	addAPI("/domains/{domain}/nameservers/current", "domain_current_nameservers", nil)
 	addAPI("/domains/{domain}/nameservers/current/page/{page}", "domain_current_nameservers_paged", nil)
@@ -66,6 +226,9 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 
 	// nameservers
 	addAPI("/nameservers/{domain}", "nameserver", app.apiNameserverHandler)
+	addAPI("/nameservers/{domain}/stats", "nameserver_stats", app.nameServerStats.Handler(app.nameServerStatsTTL, app.apiNameServerStatsHandler))
+	addAPI("/nameservers/{domain}/changes", "nameserver_changes", app.apiNameServerChangesHandler, server.Expensive())
+	addAPI("/nameservers/suffix/{suffix}/stats", "nameserver_suffix_stats", app.nameServerStats.Handler(app.nameServerSuffixTTL, app.apiNameServerSuffixStatsHandler), server.Expensive())
 	addAPI("/nameservers/{domain}/domains", "nameserver_domains", nil)
 	addAPI("/nameservers/{domain}/domains/current", "nameserver_current_domains", nil)
 	addAPI("/nameservers/{domain}/domains/current/page/{page}", "nameserver_current_domains_paged", nil)
@@ -90,53 +253,123 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 	addAPI("/ip/{ip}/nameservers", "ip_nameservers", nil)
 	addAPI("/ip/{ip}/nameservers/current", "ip_nameservers_current", nil)
 	addAPI("/ip/{ip}/nameservers/archive", "ip_nameservers_archive", nil)
+	if app.routing != nil {
+		addAPI("/asn/{asn}", "asn", app.apiASNHandler, server.Expensive())
+	}
+
+	// nameserver sets
+	addAPI("/nsset/{fingerprint}", "nsset", app.apiNameServerSetHandler)
 
 	// feeds
 	addAPI("/feeds/new", "feeds_new", nil)
-	addAPI("/feeds/new/search/{search}", "feeds_new_search", app.apiFeedsSearchNewHandler)
-	addAPI("/feeds/new/date/{date}", "feeds_new_date", app.apiFeedsNewHandler)
-	addAPI("/feeds/ns/new/date/{date}", "feeds_ns_new_date", app.apiFeedsNsNewHandler)
+	addAPI("/feeds/new/search/{search}", "feeds_new_search", app.dataVersion(app.apiFeedsSearchNewHandler), server.Expensive())
+	addAPI("/feeds/new/since/{checkpoint}", "feeds_new_since", app.apiFeedsNewSinceHandler)
+	addAPI("/feeds/new/date/{date}", "feeds_new_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsNewHandler))))
+	addAPI("/feeds/ns/new/date/{date}", "feeds_ns_new_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsNsNewHandler))))
 	//addAPI("/feeds/new/page/{page}", "feeds_new_paged", nil)
 	//addAPI("/feeds/new/{year}/{month}/{day}", "feeds_new_date", app.apiFeedsNewHandler)
 	//addAPI("/feeds/new/{year}/{month}/{day}/page/{page}", "feeds_new_date_paged", nil)
 
 	addAPI("/feeds/old", "feeds_old", nil)
-	addAPI("/feeds/old/search/{search}", "feeds_old_search", app.apiFeedsSearchOldHandler)
-	addAPI("/feeds/old/date/{date}", "feeds_old_date", app.apiFeedsOldHandler)
-	addAPI("/feeds/ns/old/date/{date}", "feeds_ns_old_date", app.apiFeedsNsOldHandler)
+	addAPI("/feeds/old/search/{search}", "feeds_old_search", app.dataVersion(app.apiFeedsSearchOldHandler), server.Expensive())
+	addAPI("/feeds/old/date/{date}", "feeds_old_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsOldHandler))))
+	addAPI("/feeds/ns/old/date/{date}", "feeds_ns_old_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsNsOldHandler))))
 	//addAPI("/feeds/old/page/{page}", "feeds_old_paged", nil)
 	//addAPI("/feeds/old/{year}/{month}/{day}", "feeds_old_date", nil)
 	//addAPI("/feeds/old/{year}/{month}/{day}/page/{page}", "feeds_old_date_paged", nil)
 
 	addAPI("/feeds/moved", "feeds_moved", nil)
-	addAPI("/feeds/moved/search/{search}", "feeds_moved_search", app.apiFeedsSearchMovedHandler)
-	addAPI("/feeds/moved/date/{date}", "feeds_moved_date", app.apiFeedsMovedHandler)
-	addAPI("/feeds/ns/moved/date/{date}", "feeds_ns_moved_date", app.apiFeedsNsMovedHandler)
+	addAPI("/feeds/moved/search/{search}", "feeds_moved_search", app.dataVersion(app.apiFeedsSearchMovedHandler), server.Expensive())
+	addAPI("/feeds/moved/date/{date}", "feeds_moved_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsMovedHandler))))
+	addAPI("/feeds/ns/moved/date/{date}", "feeds_ns_moved_date", app.dataVersion(app.feedLastModified(app.feeds.Handler(app.feedTTL, app.apiFeedsNsMovedHandler))))
 	//addAPI("/feeds/moved/page/{page}", "feeds_moved_paged", nil)
 	//addAPI("/feeds/moved/{year}/{month}/{day}", "feeds_moved_date", nil)
 	//addAPI("/feeds/moved/{year}/{month}/{day}/page/{page}", "feeds_moved_date_paged", nil)
 
+	// downloads of whole feeds, streamed without the request timeout
+	for _, change := range feedChanges {
+		path, name := "/feeds/"+change+"/{date}/download", "feeds_"+change+"_download"
+		app.api[1][name] = path
+		v1.Stream(path, params.Check(queries[path], app.freshness.handler(app.importChecks.handler(app.feedDownloadHandler(change)))), server.Expensive(), server.Named(name))
+	}
+
+	// manifest of the pre-generated downloads
+	addAPI("/bulk/manifest", "bulk_manifest", app.apiBulkManifestHandler)
+	addAPI("/bulk/manifest/{date}", "bulk_manifest_date", app.apiBulkManifestDateHandler)
+
+	// saved queries of the API keys
+	addAPI("/watchlists", "watchlists", app.apiWatchlistsHandler)
+	v1.Handle(http.MethodPost, "/watchlists", server.Body(&watchlistBody{}, maxWatchlistBody, app.apiWatchlistCreateHandler), server.Named("watchlist_create"))
+	addAPI("/watchlists/{id}", "watchlist", app.apiWatchlistHandler)
+	v1.Handle(http.MethodDelete, "/watchlists/{id}", app.apiWatchlistDeleteHandler, server.Named("watchlist_delete"))
+	addAPI("/watchlists/{id}/matches", "watchlist_matches", app.apiWatchlistMatchesHandler)
+	addAPI("/watchlists/{id}/new", "watchlist_new_matches", app.apiWatchlistNewMatchesHandler)
+
+	// version
+	addAPI("/version", "version", app.apiVersionHandler)
+
 	// research
 	addAPI("/research/ipnszonecount/{ip}", "ip_ns_zone_count", app.apiIPNsZoneCount)
 	addAPI("/research/active_ips/{date}", "active_ips", app.apiActiveIPs)
 
+	// admin
+	coffeeServer.Admin(http.MethodPost, "/refresh/{view}", app.apiAdminRefreshViewHandler)
+	// before /imports/{id}, which would take running as an ID
+	coffeeServer.Admin(http.MethodGet, "/imports/running", app.apiAdminRunningImportsHandler)
+	coffeeServer.Admin(http.MethodGet, "/imports/{id}", app.apiAdminImportHandler)
+	coffeeServer.Admin(http.MethodPost, "/alerts/{id}/acknowledge", app.apiAdminImportAlertHandler(false))
+	coffeeServer.Admin(http.MethodPost, "/alerts/{id}/clear", app.apiAdminImportAlertHandler(true))
+
+	// zone importer
+	coffeeServer.Internal(http.MethodPost, "/import_complete", server.Body(&importNotificationBody{}, maxImportNotificationBody, app.apiImportCompleteHandler))
+
 	// API index
-	coffeeServer.Get("/api", app.apiIndex)
+	v1.Get("", app.apiIndex)
+
+	// representative requests of the -selftest mode, the zone and domain lookups are skipped without seed data
+	coffeeServer.AddSelfTest("index", "/api")
+	coffeeServer.AddSelfTest("version", "/api/version")
+	coffeeServer.AddSelfTest("zones", "/api/zones")
+	coffeeServer.AddSelfTest("root", "/api/root")
+	coffeeServer.AddSelfTest("zone", "/api/zones/com")
+	coffeeServer.AddSelfTest("domain", "/api/domains/example.com")
+	coffeeServer.AddSelfTest("random_domain", "/api/random")
+	coffeeServer.AddSelfTest("label", "/api/label/example")
+	coffeeServer.AddSelfTest("imports", "/api/stats/imports")
+	coffeeServer.AddSelfTest("counts", "/api/counts")
+	coffeeServer.AddSelfTest("providers", "/api/stats/providers")
+}
+
+func (app *appContext) apiVersionHandler(w http.ResponseWriter, r *http.Request) {
+	v := &model.Version{
+		Version:   version.Version,
+		GitHash:   version.GitHash,
+		GitBranch: version.GitBranch,
+		GitDate:   version.GitDate,
+		BuildDate: version.BuildDate,
+		GoVersion: version.GoVersion,
+		StartTime: model.NewTimestamp(version.StartTime),
+		Listen:    app.listenAddrs(),
+	}
+	server.WriteJSON(w, v)
 }
 
 func (app *appContext) apiImportStatusHandler(w http.ResponseWriter, r *http.Request) {
-	ip, err := app.ds.GetImportProgress(r.Context())
+	ip, err := app.importProgress(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
+	ip.ViewsRefreshed = app.views.lastRefreshed()
 
 	server.WriteJSON(w, ip)
 }
 
 func (app *appContext) apiLatestZonesHandler(w http.ResponseWriter, r *http.Request) {
-	zoneImportResults, err := app.ds.GetZoneImportResults(r.Context())
+	zoneImportResults, err := app.latestZones(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, zoneImportResults)
@@ -147,237 +380,339 @@ TODO
 func (app *appContext) apiTopZonesHandler(w http.ResponseWriter, r *http.Request) {
 	zoneImportResults, err := app.ds.GetZoneImportResults(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, zoneImportResults)
 }*/
 
 func (app *appContext) apiZoneImportHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	zone := cleanDomain(params["zone"])
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	zoneImportResult, err := app.ds.GetZoneImport(r.Context(), zone)
 	if err != nil {
 		// TODO handle error no rows found
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, zoneImportResult)
 }
 
 func (app *appContext) apiFeedsNewHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	sources, jsonErr := app.feedSources(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	stabilities, jsonErr := minStability(r)
+	if invalidParam(w, jsonErr) {
+		return
 	}
-	data, err := app.ds.GetFeedNew(r.Context(), date)
+	data, err := app.ds.GetFeedNew(r.Context(), date, sources, stabilities)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
+	data.Sources = sources
+	data.MinStability = r.URL.Query().Get("min_stability")
+	data.Domains = app.visibleDomains(r, data.Domains)
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsSearchMovedHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	search := params["search"]
+	search, jsonErr := params.Path(r, "search")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	data, err := app.ds.GetMovedFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsSearchOldHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	search := params["search"]
+	search, jsonErr := params.Path(r, "search")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	data, err := app.ds.GetOldFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsSearchNewHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	search := strings.ToLower(params["search"])
+	search, jsonErr := params.Path(r, "search")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	search = strings.ToLower(search)
 	data, err := app.ds.GetNewFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsMovedHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	sources, jsonErr := app.feedSources(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	stabilities, jsonErr := minStability(r)
+	if invalidParam(w, jsonErr) {
+		return
 	}
-	data, err := app.ds.GetFeedMoved(r.Context(), date)
+	data, err := app.ds.GetFeedMoved(r.Context(), date, sources, stabilities)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
+	data.Sources = sources
+	data.MinStability = r.URL.Query().Get("min_stability")
+	data.Domains = app.visibleDomains(r, data.Domains)
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsOldHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	sources, jsonErr := app.feedSources(r)
+	if invalidParam(w, jsonErr) {
+		return
 	}
-	data, err := app.ds.GetFeedOld(r.Context(), date)
+	stabilities, jsonErr := minStability(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data, err := app.ds.GetFeedOld(r.Context(), date, sources, stabilities)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
+	data.Sources = sources
+	data.MinStability = r.URL.Query().Get("min_stability")
+	data.Domains = app.visibleDomains(r, data.Domains)
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsNsNewHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
 	}
 	data, err := app.ds.GetFeedNsNew(r.Context(), date)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
 }
 func (app *appContext) apiFeedsNsMovedHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
 	}
 	data, err := app.ds.GetFeedNsMoved(r.Context(), date)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
 }
 func (app *appContext) apiFeedsNsOldHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
 	}
 	data, err := app.ds.GetFeedNsOld(r.Context(), date)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, data)
+}
+
+// dataVersionHeader carries the ID of the latest finished import on list responses
+const dataVersionHeader = "X-Data-Version"
+
+// dataVersion sets the data version header on list responses, and rejects requests carrying
+// a data_version query parameter with ErrDataChanged when an import has finished since,
+// so that clients walking several pages notice the data changing under them and start again
+// it runs outside the feed cache so that the header and check are never cached
+func (app *appContext) dataVersion(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want, jsonErr := params.Int(r, "data_version", 0, math.MaxInt, -1)
+		if invalidParam(w, jsonErr) {
 			return
 		}
-		panic(err)
+		current, err := app.ds.DataVersion(r.Context())
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		if want >= 0 && int64(want) != current {
+			server.WriteJSONError(w, server.ErrDataChanged)
+			return
+		}
+		w.Header().Set(dataVersionHeader, strconv.FormatInt(current, 10))
+		server.SetResponseMeta(w, server.DataVersion(current))
+		next(w, r)
 	}
+}
 
-	server.WriteJSON(w, data)
+// feedLastModified sends the time the latest import of the requested date finished in Last-Modified
+// and answers If-Modified-Since with a 304, only for past dates since feeds for today are still being imported
+func (app *appContext) feedLastModified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date, jsonErr := params.Date(r, "date")
+		if jsonErr != nil || !date.Before(server.Today()) {
+			next(w, r)
+			return
+		}
+		modified, err := app.ds.FeedLastModified(r.Context(), date)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		if modified != nil && server.NotModified(w, r, *modified) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// feedTTL is how long the feed for the requested date may be cached
+// feeds for past dates are complete and never change, feeds for today are still being imported
+func (app *appContext) feedTTL(r *http.Request) time.Duration {
+	date, jsonErr := server.ParseDateParam("date", mux.Vars(r)["date"])
+	if jsonErr != nil {
+		return -1
+	}
+	today := server.Today()
+	if date.Before(today) {
+		return 0
+	}
+	return app.ttls.Load().Feed
 }
 
 // domainHandler returns domain object for the queried domain
 func (app *appContext) apiDomainHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
-	data, err := app.ds.GetDomain(r.Context(), domain)
+	domain, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if neverDelegated(w, domain) {
+		return
+	}
+	if app.zoneForbidden(w, r, domain) {
+		return
+	}
+	absent, generation := app.negatives.absent(domainName, domain)
+	if absent {
+		app.writeLookupError(w, domain, datastore.ErrNoResource)
+		return
+	}
+	var data *model.Domain
+	err := app.ds.ReadTx(r.Context(), func(tx datastore.ReadQueries) (err error) {
+		data, err = tx.GetDomain(r.Context(), domain)
+		return err
+	})
 	if err != nil {
 		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
+			app.negatives.miss(domainName, domain, generation)
 		}
-		panic(err)
+		app.writeLookupError(w, domain, err)
+		return
 	}
+	app.classifyNameServers(data.NameServers, data.ArchiveNameServers)
+	data.SpecialUse = specialUse(domain)
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiIPHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
-	data, err := app.ds.GetIP(r.Context(), ip)
+	ip, jsonErr := params.IP(r, "ip")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	var data *model.IP
+	err := app.ds.ReadTx(r.Context(), func(tx datastore.ReadQueries) (err error) {
+		data, err = tx.GetIP(r.Context(), ip.String())
+		return err
+	})
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
+	app.routeIPs(data)
 
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiZoneHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["zone"])
-	data, err1 := app.ds.GetZone(r.Context(), domain)
-	if err1 != nil {
-		if err1 == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err1)
+	domain, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
 	}
-	// add some metadata to the zone response
-	importData, err := app.ds.GetZoneImport(r.Context(), domain)
-	if err == nil {
-		// TODO check for datastore.ErrNoResource and sql.NoRows
-		// TODO in fact, make ErrNoResource include? sql.NowRows as well
-		data.ImportData = importData
+	var data *model.Zone
+	err1 := app.ds.ReadTx(r.Context(), func(tx datastore.ReadQueries) (err error) {
+		data, err = tx.GetZone(r.Context(), domain)
+		if err != nil {
+			return err
+		}
+		// add some metadata to the zone response
+		importData, err := tx.GetZoneImport(r.Context(), domain)
+		if err == nil {
+			// TODO check for datastore.ErrNoResource and sql.NoRows
+			// TODO in fact, make ErrNoResource include? sql.NowRows as well
+			data.ImportData = importData
+		}
+		return nil
+	})
+	if err1 != nil {
+		app.writeError(w, err1)
+		return
 	}
 	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiZoneHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	zone := cleanDomain(params["zone"])
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	data, err1 := app.ds.GetZoneHistoryCounts(r.Context(), zone)
 	if err1 != nil {
-		if err1 == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err1)
+		app.writeError(w, err1)
+		return
 	}
 
 	server.WriteJSON(w, data)
@@ -386,11 +721,8 @@ func (app *appContext) apiZoneHistoryCountsHandler(w http.ResponseWriter, r *htt
 func (app *appContext) apiAllZoneHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetAllZoneHistoryCounts(r.Context())
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
@@ -399,11 +731,8 @@ func (app *appContext) apiAllZoneHistoryCountsHandler(w http.ResponseWriter, r *
 func (app *appContext) apiInternetHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetInternetHistoryCounts(r.Context())
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
@@ -413,36 +742,78 @@ func (app *appContext) apiInternetHistoryCountsHandler(w http.ResponseWriter, r
 func (app *appContext) apiRandomDomainHandler(w http.ResponseWriter, r *http.Request) {
 	domain, err := app.ds.GetRandomDomain(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
+	}
+	if app.zoneForbidden(w, r, domain.Name) {
+		return
 	}
 	server.WriteJSON(w, domain)
 }
 
 // nameserverHandler returns nameserver object for the queried domain
 func (app *appContext) apiNameserverHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
+	domain, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 
-	data, err1 := app.ds.GetNameServer(r.Context(), domain)
+	absent, generation := app.negatives.absent(nameServerName, domain)
+	if absent {
+		app.writeLookupError(w, domain, datastore.ErrNoResource)
+		return
+	}
+	var data *model.NameServer
+	err1 := app.ds.ReadTx(r.Context(), func(tx datastore.ReadQueries) (err error) {
+		data, err = tx.GetNameServer(r.Context(), domain)
+		return err
+	})
 	if err1 != nil {
-		//TODO combine common code below
 		if err1 == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
+			app.negatives.miss(nameServerName, domain, generation)
 		}
-
-		panic(err1)
+		app.writeLookupError(w, domain, err1)
+		return
 	}
+	app.classifyNameServers([]*model.NameServer{data})
+	data.SpecialUse = specialUse(domain)
+	app.routeNameServerIPs(data)
+	data.Domains = app.visibleDomains(r, data.Domains)
+	data.ArchiveDomains = app.visibleDomains(r, data.ArchiveDomains)
 
 	server.WriteJSON(w, data)
 }
 
+// writeError writes the JSON error for errors returned by the datastore
+// queries cut by the request's deadline, or its client going away, are answered with the timeout error
+// unexpected errors panic so that they are handled by the recovery handler
+func (app *appContext) writeError(w http.ResponseWriter, err error) {
+	switch err {
+	case datastore.ErrNoResource:
+		server.WriteJSONError(w, server.ErrResourceNotFound)
+	case datastore.ErrInvalidIP:
+		server.WriteJSONError(w, server.NewFieldError("ip", "is not a valid IP address"))
+	case datastore.ErrSearchTooShort:
+		server.WriteJSONError(w, server.NewFieldError("search", fmt.Sprintf("must be at least %d characters long", datastore.MinSearchLength)))
+	case datastore.ErrTooManyRows:
+		server.WriteJSONError(w, server.ErrResponseTooLarge)
+	case datastore.ErrDatabaseUnavailable:
+		server.WriteRetryError(w, server.ErrDatabaseUnavailable, app.ds.RetryAfter())
+	default:
+		if datastore.IsTimeout(err) {
+			server.WriteJSONError(w, server.ErrTimeout)
+			return
+		}
+		panic(err)
+	}
+}
+
 // API Index handler
 // Displays the map of the API methods available
 func (app *appContext) apiIndex(w http.ResponseWriter, req *http.Request) {
 	// TODO change to use mux.Get API documentation functions
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(app.api)
+	err := json.NewEncoder(w).Encode(app.api[server.RequestAPIVersion(req.Context())])
 	if err != nil && err != http.ErrHandlerTimeout {
 		panic(err)
 	}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+func TestWriteErrorTimeouts(t *testing.T) {
+	app := &appContext{}
+	for _, err := range []error{
+		context.DeadlineExceeded,
+		context.Canceled,
+		fmt.Errorf("query: %w", context.DeadlineExceeded),
+	} {
+		w := httptest.NewRecorder()
+		app.writeError(w, err)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%v: got status %d, want %d", err, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// TestWriteErrorDatabaseUnavailable gives the retry delay of the store in the header and the body
+func TestWriteErrorDatabaseUnavailable(t *testing.T) {
+	app := &appContext{ds: fakeStore{}}
+	w := httptest.NewRecorder()
+	app.writeError(w, datastore.ErrDatabaseUnavailable)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("got status %d, Retry-After %q, want %d after 30", w.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(w.Body.String(), `"code":"database_unavailable"`) || !strings.Contains(w.Body.String(), `"retry_after_seconds":"30"`) {
+		t.Errorf("got body %s, want database_unavailable with the retry after 30s", w.Body)
+	}
+}
+
+func TestWriteErrorPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unexpected error did not panic")
+		}
+	}()
+	(&appContext{}).writeError(httptest.NewRecorder(), fmt.Errorf("unexpected"))
+}
+
+func TestFeedsNewSinceWithoutSecret(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&appContext{}).apiFeedsNewSinceHandler(w, httptest.NewRequest(http.MethodGet, "/api/feeds/new/since/now", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "checkpoints_unavailable") {
+		t.Errorf("got %d %s, want a 503 checkpoints_unavailable", w.Code, w.Body)
+	}
+}
+
+// versionStore returns version as the data version, or err
+type versionStore struct {
+	fakeStore
+	version int64
+	err     error
+}
+
+func (s *versionStore) DataVersion(ctx context.Context) (int64, error) {
+	return s.version, s.err
+}
+
+func TestDataVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   error
+		want  int
+		// the error code of a rejected request
+		code string
+	}{
+		{name: "no version", want: http.StatusOK},
+		{name: "current version", query: "?data_version=7", want: http.StatusOK},
+		{name: "empty version", query: "?data_version=", want: http.StatusOK},
+		{name: "stale version", query: "?data_version=6", want: http.StatusConflict, code: "data_changed"},
+		{name: "negative version", query: "?data_version=-1", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "not a version", query: "?data_version=latest", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "database unavailable", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+		{name: "timeout", err: context.DeadlineExceeded, want: http.StatusServiceUnavailable, code: "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &appContext{ds: &versionStore{version: 7, err: tt.err}}
+			reached := false
+			handler := app.dataVersion(func(w http.ResponseWriter, r *http.Request) { reached = true })
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/api/feeds/new/date/2023-07-04"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if reached != (tt.want == http.StatusOK) {
+				t.Errorf("reached the feed: %t", reached)
+			}
+			if tt.want == http.StatusOK && w.Header().Get(dataVersionHeader) != "7" {
+				t.Errorf("got %s %q, want 7", dataVersionHeader, w.Header().Get(dataVersionHeader))
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+		})
+	}
+}
+
+// lastModifiedStore returns modified as the time the feed of every date was last imported, or err
+type lastModifiedStore struct {
+	fakeStore
+	modified *time.Time
+	err      error
+	queried  bool
+}
+
+func (s *lastModifiedStore) FeedLastModified(ctx context.Context, date time.Time) (*time.Time, error) {
+	s.queried = true
+	return s.modified, s.err
+}
+
+func TestFeedLastModified(t *testing.T) {
+	imported := time.Date(2023, 7, 5, 3, 0, 0, 0, time.UTC)
+	lastModified := imported.Format(http.TimeFormat)
+	today := server.Today().Format("2006-01-02")
+	tests := []struct {
+		name            string
+		date            string
+		ifModifiedSince string
+		modified        *time.Time
+		err             error
+		want            int
+		lastModified    string
+		queried         bool
+	}{
+		{name: "past date", date: "2023-07-04", modified: &imported, want: http.StatusOK, lastModified: lastModified, queried: true},
+		{name: "not modified", date: "2023-07-04", ifModifiedSince: lastModified, modified: &imported, want: http.StatusNotModified, lastModified: lastModified, queried: true},
+		{name: "modified since", date: "2023-07-04", ifModifiedSince: imported.Add(-time.Hour).Format(http.TimeFormat), modified: &imported, want: http.StatusOK, lastModified: lastModified, queried: true},
+		{name: "malformed condition", date: "2023-07-04", ifModifiedSince: "yesterday", modified: &imported, want: http.StatusOK, lastModified: lastModified, queried: true},
+		{name: "never imported", date: "2023-07-04", ifModifiedSince: lastModified, want: http.StatusOK, queried: true},
+		{name: "today", date: today, ifModifiedSince: lastModified, modified: &imported, want: http.StatusOK},
+		{name: "invalid date left to the feed", date: "someday", want: http.StatusOK},
+		{name: "database unavailable", date: "2023-07-04", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, queried: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &lastModifiedStore{modified: tt.modified, err: tt.err}
+			app := &appContext{ds: ds}
+			handler := app.feedLastModified(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+			r := varsRequest("/api/feeds/new/date/"+tt.date, map[string]string{"date": tt.date})
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if got := w.Header().Get("Last-Modified"); got != tt.lastModified {
+				t.Errorf("got Last-Modified %q, want %q", got, tt.lastModified)
+			}
+			if ds.queried != tt.queried {
+				t.Errorf("queried the database: %t, want %t", ds.queried, tt.queried)
+			}
+		})
+	}
+}
+
+// nsFeedStore returns an empty feed of new nameservers for every date and records the date queried, or returns err
+type nsFeedStore struct {
+	fakeStore
+	err     error
+	queried time.Time
+}
+
+func (s *nsFeedStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+	s.queried = date
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &model.NSFeed{Change: "new", Date: model.NewDate(date)}, nil
+}
+
+// TestFeedDate requests a feed with every date format, each is queried as the same UTC day
+func TestFeedDate(t *testing.T) {
+	day := time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		date string
+		err  error
+		want int
+		code string
+	}{
+		{name: "iso", date: "2023-07-04", want: http.StatusOK},
+		{name: "unpadded", date: "2023-7-4", want: http.StatusOK},
+		{name: "basic", date: "20230704", want: http.StatusOK},
+		{name: "time with offset", date: "2023-07-03T22:00:00-04:00", want: http.StatusOK},
+		{name: "epoch", date: "1688500000", want: http.StatusOK},
+		{name: "impossible day", date: "2023-02-29", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "before 1970", date: "1969-07-04", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "time without offset", date: "2023-07-04T12:00:00", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "database unavailable", date: "2023-07-04", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &nsFeedStore{err: tt.err}
+			app := &appContext{ds: ds}
+			w := httptest.NewRecorder()
+			app.apiFeedsNsNewHandler(w, varsRequest("/api/feeds/ns/new/date/"+url.PathEscape(tt.date), map[string]string{"date": tt.date}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" {
+				if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("got %s, want the error %s", w.Body, tt.code)
+				}
+				if tt.want == http.StatusBadRequest && !ds.queried.IsZero() {
+					t.Error("invalid date queried the database")
+				}
+				return
+			}
+			if !ds.queried.Equal(day) || ds.queried.Location() != time.UTC {
+				t.Errorf("queried %s, want %s", ds.queried, day)
+			}
+			if !strings.Contains(w.Body.String(), `"date":"2023-07-04"`) {
+				t.Errorf("got %s, want the feed of 2023-07-04", w.Body)
+			}
+		})
+	}
+}
+
+func TestFeedTTL(t *testing.T) {
+	app := &appContext{ttls: NewCacheTTLs(TTLs{Feed: time.Minute})}
+	now := time.Now().UTC()
+	tests := []struct {
+		date string
+		want time.Duration
+	}{
+		{date: "2023-07-04", want: 0},
+		{date: server.Today().Format("2006-01-02"), want: time.Minute},
+		// an offset time of today is still today in UTC
+		{date: now.In(time.FixedZone("", -2*60*60)).Format(time.RFC3339), want: time.Minute},
+		{date: now.Add(24 * time.Hour).Format("20060102"), want: time.Minute},
+		{date: "someday", want: -1},
+	}
+	for _, tt := range tests {
+		if got := app.feedTTL(varsRequest("/api/feeds/new/date/"+tt.date, map[string]string{"date": tt.date})); got != tt.want {
+			t.Errorf("%s: got TTL %s, want %s", tt.date, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"dnscoffee/asn"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// asnMaxIPs is how many nameserver IP addresses /asn/{asn} lists, the counts cover all of them
+const asnMaxIPs = 1000
+
+// routingTable is the routing table loaded from a prefix to AS file by the asn job
+type routingTable struct {
+	path        string
+	maxPrefixes int
+	// nil until the first load succeeded, replaced as a whole by the next loads
+	table atomic.Pointer[asn.Table]
+	// the file the table was loaded from, only used by the job
+	modTime time.Time
+	size    int64
+}
+
+// run is the asn job, it loads the file again when it changed since the last load; the current table is kept when
+// the file can not be loaded, so that a bad dump does not leave the addresses without routes
+func (rt *routingTable) run(ctx context.Context) error {
+	info, err := os.Stat(rt.path)
+	if err != nil {
+		return err
+	}
+	if rt.table.Load() != nil && info.ModTime().Equal(rt.modTime) && info.Size() == rt.size {
+		return nil
+	}
+	start := time.Now()
+	table, err := asn.LoadFile(rt.path, rt.maxPrefixes)
+	if err != nil {
+		return err
+	}
+	rt.table.Store(table)
+	rt.modTime, rt.size = info.ModTime(), info.Size()
+	logging.Infof("asn: loaded %s in %s, %s", rt.path, time.Since(start).Round(time.Millisecond), table.Stats())
+	return nil
+}
+
+// route returns the route of an address in table
+func route(table *asn.Table, ip *net.IP) *model.IPRoute {
+	addr, ok := netip.AddrFromSlice(*ip)
+	if !ok {
+		return &model.IPRoute{}
+	}
+	r, ok := table.Lookup(addr)
+	if !ok {
+		return &model.IPRoute{}
+	}
+	return &model.IPRoute{Routed: true, Prefix: r.Prefix.String(), Origins: r.Origins}
+}
+
+// routeIPs sets the route of the addresses, left nil when no routing table is loaded
+func (app *appContext) routeIPs(ips ...*model.IP) {
+	if app.routing == nil {
+		return
+	}
+	table := app.routing.table.Load()
+	if table == nil {
+		return
+	}
+	for _, ip := range ips {
+		if ip.IP != nil {
+			ip.ASN = route(table, ip.IP)
+		}
+	}
+}
+
+// routeNameServerIPs sets the route of the addresses of the nameservers
+func (app *appContext) routeNameServerIPs(ns *model.NameServer) {
+	var ips []*model.IP
+	for _, list := range [][]*model.IP4{ns.IP4, ns.ArchiveIP4} {
+		for _, ip := range list {
+			ips = append(ips, &ip.IP)
+		}
+	}
+	for _, list := range [][]*model.IP6{ns.IP6, ns.ArchiveIP6} {
+		for _, ip := range list {
+			ips = append(ips, &ip.IP)
+		}
+	}
+	app.routeIPs(ips...)
+}
+
+// apiASNHandler returns the nameserver IP addresses whose longest matching prefix of the routing table an ASN
+// originates, with the nameservers and domains using them
+func (app *appContext) apiASNHandler(w http.ResponseWriter, r *http.Request) {
+	number, jsonErr := params.ASN(r, "asn")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	table := app.routing.table.Load()
+	if table == nil {
+		server.WriteJSONError(w, server.ErrNoRoutingTable)
+		return
+	}
+	v4, v6 := table.Ranges(number)
+	if len(v4) == 0 && len(v6) == 0 {
+		server.WriteJSONError(w, server.ErrASNNotRouted)
+		return
+	}
+	data, err := app.ds.GetASNAddresses(r.Context(), v4, v6, asnMaxIPs)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data.ASN = number
+	data.PrefixCount = table.Prefixes(number)
+	app.routeIPs(data.IPs...)
+
+	server.WriteJSON(w, data)
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// maxBatchFailedShare is the share of the items of a batch that may fail with a transient error before the whole batch
+// is answered with a 503, a batch mostly made of errors is of no use to retry item by item
+const maxBatchFailedShare = 0.5
+
+// batchItemError returns the error of an item of a batch whose lookup failed with a transient error, see
+// datastore.IsTransient
+func batchItemError(err error) *model.JSONError {
+	if datastore.IsTimeout(err) {
+		return server.ErrTimeout
+	}
+	return server.ErrDatabaseUnavailable
+}
+
+// batchFailed answers a batch with a 503 and returns true when more than maxBatchFailedShare of its items failed,
+// meta.failed_items is how many did
+func batchFailed(w http.ResponseWriter, failed, items int, retry time.Duration) bool {
+	if failed == 0 || float64(failed) <= maxBatchFailedShare*float64(items) {
+		return false
+	}
+	server.WriteRetryError(w, server.ErrDatabaseUnavailable, retry, map[string]string{"failed_items": strconv.Itoa(failed)})
+	return true
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// parseFeedExportName returns the change and date of a pre-generated feed file name, ok is false for other files
+func parseFeedExportName(name string) (change string, date time.Time, ok bool) {
+	if !strings.HasPrefix(name, "feed-") || !strings.HasSuffix(name, ".csv.gz") {
+		return "", time.Time{}, false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(name, "feed-"), ".csv.gz"), "-", 2)
+	if len(parts) != 2 {
+		return "", time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", parts[1])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	for _, c := range feedChanges {
+		if c == parts[0] {
+			return c, date, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// readChecksum returns the hex SHA-256 of the checksum file of the file at path
+func readChecksum(path string) (string, error) {
+	b, err := os.ReadFile(checksumName(path))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 || len(fields[0]) != 2*32 {
+		return "", fmt.Errorf("%s: not a SHA-256 checksum", checksumName(filepath.Base(path)))
+	}
+	if _, err = hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("%s: %w", checksumName(filepath.Base(path)), err)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// url returns the download URL of the pre-generated file name, under baseURL when set
+func (fe *feedExports) url(change string, date time.Time, name string) string {
+	if fe.baseURL != "" {
+		return strings.TrimSuffix(fe.baseURL, "/") + "/" + name
+	}
+	return fmt.Sprintf("/feeds/%s/%s/download", change, date.Format("2006-01-02"))
+}
+
+// manifest lists the pre-generated files of every date, or only of date when it is not zero, by date and change
+// files without a readable checksum file are left out, they are being generated or were left by an older version
+func (fe *feedExports) manifest(date time.Time) ([]*model.BulkArtifact, error) {
+	artifacts := []*model.BulkArtifact{}
+	if fe.dir == "" {
+		return artifacts, nil
+	}
+	entries, err := os.ReadDir(fe.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		change, fileDate, ok := parseFeedExportName(e.Name())
+		if !ok || (!date.IsZero() && !fileDate.Equal(date)) {
+			continue
+		}
+		path := filepath.Join(fe.dir, e.Name())
+		sum, err := readChecksum(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logging.Warnf("bulk manifest: %s", err)
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// removed by the feed exports job since the directory was read
+			continue
+		}
+		artifacts = append(artifacts, &model.BulkArtifact{
+			Kind:        "feed",
+			Change:      change,
+			Name:        e.Name(),
+			URL:         fe.url(change, fileDate, e.Name()),
+			Size:        info.Size(),
+			SHA256:      sum,
+			Compression: "gzip",
+			Date:        model.NewDate(fileDate),
+			GeneratedAt: model.NewTimestamp(info.ModTime()),
+		})
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		if !artifacts[i].Date.Equal(artifacts[j].Date.Time) {
+			return artifacts[i].Date.Before(artifacts[j].Date.Time)
+		}
+		return artifacts[i].Change < artifacts[j].Change
+	})
+	return artifacts, nil
+}
+
+// apiBulkManifestHandler lists the pre-generated feed downloads with their size and SHA-256 for mirrors to verify them
+func (app *appContext) apiBulkManifestHandler(w http.ResponseWriter, r *http.Request) {
+	artifacts, err := app.exports.manifest(time.Time{})
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, &model.BulkManifest{Artifacts: artifacts})
+}
+
+// apiBulkManifestDateHandler lists the pre-generated downloads of the feeds of a date
+func (app *appContext) apiBulkManifestDateHandler(w http.ResponseWriter, r *http.Request) {
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	artifacts, err := app.exports.manifest(date)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, &model.BulkManifest{Date: model.NewDate(date), Artifacts: artifacts})
+}
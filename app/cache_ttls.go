@@ -0,0 +1,36 @@
+package app
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TTLs are how long the responses of the app caches are kept
+type TTLs struct {
+	// feeds and nameserver statistics for today
+	Feed time.Duration
+	// live NS sets
+	LiveDNS time.Duration
+}
+
+// CacheTTLs holds the current TTLs, they are replaced when the config is reloaded
+type CacheTTLs struct {
+	v atomic.Value
+}
+
+// NewCacheTTLs returns a CacheTTLs holding ttls
+func NewCacheTTLs(ttls TTLs) *CacheTTLs {
+	c := &CacheTTLs{}
+	c.Store(ttls)
+	return c
+}
+
+// Load returns the current TTLs
+func (c *CacheTTLs) Load() TTLs {
+	return c.v.Load().(TTLs)
+}
+
+// Store replaces the current TTLs, entries already cached expire with the new ones
+func (c *CacheTTLs) Store(ttls TTLs) {
+	c.v.Store(ttls)
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/reqstats"
+	"dnscoffee/server"
+)
+
+// churnWindows are the windows ?window= accepts in days, few so that the import pairs compared and cached stay few
+var churnWindows = map[string]int{"7d": 7, "30d": 30, "90d": 90, "365d": 365}
+
+const (
+	// defaultChurnWindow is the window without ?window=
+	defaultChurnWindow = "30d"
+	// churnCacheSize is the number of zone churns cached, a few windows of every zone
+	churnCacheSize = 4096
+)
+
+// zoneChurns caches the churn counts of import pairs, least recently used first out, and shares the computation of
+// the counts of a pair between concurrent requests for it, like zoneDiffs
+// a pair is computed once, the churn of a zone changes with its latest import and so with the key
+type zoneChurns struct {
+	ds      *datastore.DataStore
+	timeout time.Duration
+	// canceled when the server shuts down
+	ctx context.Context
+
+	mu       sync.Mutex
+	entries  map[diffKey]*list.Element
+	order    *list.List
+	inflight map[diffKey]*churnCall
+}
+
+// churnCall is a churn computation that other requests can wait on
+type churnCall struct {
+	done  chan struct{}
+	churn *datastore.ZoneChurn
+	err   error
+}
+
+// cachedChurn is an entry of the zoneChurns LRU
+type cachedChurn struct {
+	key   diffKey
+	churn *datastore.ZoneChurn
+}
+
+func newZoneChurns(ctx context.Context, ds *datastore.DataStore, timeout time.Duration) *zoneChurns {
+	return &zoneChurns{
+		ds:       ds,
+		timeout:  timeout,
+		ctx:      ctx,
+		entries:  make(map[diffKey]*list.Element),
+		order:    list.New(),
+		inflight: make(map[diffKey]*churnCall),
+	}
+}
+
+// get returns the churn between the imports from the cache, or computes it
+func (zc *zoneChurns) get(ctx context.Context, key diffKey, from, to datastore.Import) (*datastore.ZoneChurn, error) {
+	zc.mu.Lock()
+	elem, ok := zc.entries[key]
+	reqstats.FromContext(ctx).Cache(ok)
+	if ok {
+		zc.order.MoveToFront(elem)
+		zc.mu.Unlock()
+		return elem.Value.(*cachedChurn).churn, nil
+	}
+	call, running := zc.inflight[key]
+	if !running {
+		call = &churnCall{done: make(chan struct{})}
+		zc.inflight[key] = call
+		go zc.compute(key, from, to, call)
+	}
+	zc.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.churn, call.err
+	}
+}
+
+func (zc *zoneChurns) compute(key diffKey, from, to datastore.Import, call *churnCall) {
+	ctx, cancel := context.WithTimeout(zc.ctx, zc.timeout)
+	defer cancel()
+	start := time.Now()
+	call.churn, call.err = zc.ds.GetZoneChurn(ctx, key.zoneID, from, to)
+	took := time.Since(start).Round(time.Millisecond)
+	if call.err != nil {
+		logging.Warnf("zone churn %d of imports %d and %d failed after %s: %s", key.zoneID, key.from, key.to, took, call.err)
+	} else {
+		logging.Debugf("zone churn %d of imports %d and %d took %s", key.zoneID, key.from, key.to, took)
+	}
+
+	zc.mu.Lock()
+	delete(zc.inflight, key)
+	if call.err == nil {
+		zc.entries[key] = zc.order.PushFront(&cachedChurn{key: key, churn: call.churn})
+		for zc.order.Len() > churnCacheSize {
+			oldest := zc.order.Back()
+			zc.order.Remove(oldest)
+			delete(zc.entries, oldest.Value.(*cachedChurn).key)
+		}
+	}
+	zc.mu.Unlock()
+	close(call.done)
+}
+
+// flush empties the cache
+func (zc *zoneChurns) flush() {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	zc.entries = make(map[diffKey]*list.Element)
+	zc.order.Init()
+}
+
+// apiZoneChurnHandler compares the domains of ?zone= on its latest import with the latest import at or before its date
+// less ?window=, one of churnWindows; only counts are returned so restricted zones are served too
+// an earlier date before the first import of the zone, or in a gap of its imports, answers the errors of the zone counts
+func (app *appContext) apiZoneChurnHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.QueryDomain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = defaultChurnWindow
+	}
+	days, ok := churnWindows[window]
+	if !ok {
+		server.WriteJSONError(w, server.NewFieldError("window", "must be 7d, 30d, 90d or 365d"))
+		return
+	}
+
+	zoneID, err := app.zoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	latest, err := app.ds.GetZoneCountAt(r.Context(), zoneID, server.Today())
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if !latest.Found {
+		server.WriteJSONError(w, server.ErrBeforeFirstImport)
+		return
+	}
+	to := datastore.Import{ID: latest.ImportID, Date: latest.ImportDate}
+	earlier, err := app.ds.GetZoneCountAt(r.Context(), zoneID, to.Date.AddDate(0, 0, -days))
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if _, jsonErr := zoneCountAsOf(zone, earlier); jsonErr != nil {
+		server.WriteJSONError(w, jsonErr)
+		return
+	}
+	from := datastore.Import{ID: earlier.ImportID, Date: earlier.ImportDate}
+	churn, err := app.churns.get(r.Context(), diffKey{zoneID: zoneID, from: from.ID, to: to.ID}, from, to)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	data := &model.ZoneChurn{
+		Zone:         zone,
+		Window:       window,
+		FromImportID: from.ID,
+		FromDate:     model.NewDate(from.Date),
+		ToImportID:   to.ID,
+		ToDate:       model.NewDate(to.Date),
+		FromDomains:  churn.Stayed + churn.Removed,
+		ToDomains:    churn.Stayed + churn.Added,
+		Stayed:       churn.Stayed,
+		Added:        churn.Added,
+		Removed:      churn.Removed,
+		Changed:      churn.Changed,
+	}
+	if data.FromDomains > 0 {
+		population := float64(data.FromDomains)
+		data.Fractions = &model.ChurnFractions{
+			Stayed:  float64(churn.Stayed) / population,
+			Added:   float64(churn.Added) / population,
+			Removed: float64(churn.Removed) / population,
+			Changed: float64(churn.Changed) / population,
+		}
+	}
+	server.WriteJSON(w, data)
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// cohortSampleSize is the default and maxCohortSampleSize the largest number of domains of a cohort sample
+const (
+	cohortSampleSize    = 100
+	maxCohortSampleSize = 1000
+)
+
+// maxCohortSeed is the longest ?seed= of a cohort sample in bytes
+const maxCohortSeed = 64
+
+// defaultCohortSeed is the seed of the samples requested without one
+const defaultCohortSeed = "0"
+
+// apiCohortSampleHandler returns a pseudo-random sample of ?size= domains first seen in the month, with their current status
+// the sample is ordered by a hash of ?seed= and the names so that the same seed always returns the same panel,
+// ?zone= only samples the domains of a zone, the domains of restricted zones the request may not read are left out of the sample
+func (app *appContext) apiCohortSampleHandler(w http.ResponseWriter, r *http.Request) {
+	month, jsonErr := params.Month(r, "month")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if month.After(server.Today()) {
+		server.WriteJSONError(w, server.NewFieldError("month", "must not be in the future"))
+		return
+	}
+	size, jsonErr := params.Int(r, "size", 1, maxCohortSampleSize, cohortSampleSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	seed := r.URL.Query().Get("seed")
+	if seed == "" {
+		seed = defaultCohortSeed
+	}
+	if len(seed) > maxCohortSeed {
+		server.WriteJSONError(w, server.NewFieldError("seed", fmt.Sprintf("must be at most %d bytes", maxCohortSeed)))
+		return
+	}
+	data := &model.CohortSample{Cohort: month.Format("2006-01"), Seed: seed, Complete: cohortMonthComplete(month, server.Today())}
+	var zoneID int64
+	if r.URL.Query().Get("zone") != "" {
+		data.Zone, jsonErr = params.QueryDomain(r, "zone")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		if app.zoneForbidden(w, r, data.Zone) {
+			return
+		}
+		var err error
+		zoneID, err = app.zoneID(r.Context(), data.Zone)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+	}
+
+	domains, total, err := app.ds.GetCohortSample(r.Context(), zoneID, month, seed, size)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data.CohortSize = total
+	data.Domains = make([]*model.CohortDomain, 0, len(domains))
+	for _, d := range domains {
+		if app.zones.Check(r, d.Name) == nil {
+			data.Domains = append(data.Domains, d)
+		}
+	}
+	data.Size = len(data.Domains)
+	server.WriteJSON(w, data)
+}
+
+// cohortMonthComplete returns true if no domain can join the cohort of month anymore
+func cohortMonthComplete(month, today time.Time) bool {
+	return !month.AddDate(0, 1, 0).After(today)
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+	"dnscoffee/server"
+)
+
+// cohortStore samples its domains ordered by the md5 of the seed and the name, like the datastore
+type cohortStore struct {
+	fakeStore
+	domains []*model.CohortDomain
+	err     error
+	// arguments of the last sample
+	zoneID int64
+	month  time.Time
+}
+
+func (s *cohortStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	if name != "ORG" {
+		return 0, datastore.ErrNoResource
+	}
+	return 3, nil
+}
+
+func (s *cohortStore) GetZoneIDs(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{"ORG": 3}, nil
+}
+
+func (s *cohortStore) GetCohortSample(ctx context.Context, zoneID int64, month time.Time, seed string, size int) ([]*model.CohortDomain, int64, error) {
+	s.zoneID, s.month = zoneID, month
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	domains := append([]*model.CohortDomain{}, s.domains...)
+	order := func(d *model.CohortDomain) string {
+		sum := md5.Sum([]byte(seed + ":" + d.Name))
+		return string(sum[:])
+	}
+	sort.Slice(domains, func(i, j int) bool { return order(domains[i]) < order(domains[j]) })
+	if len(domains) > size {
+		domains = domains[:size]
+	}
+	return domains, int64(len(s.domains)), nil
+}
+
+func cohortApp(t *testing.T) (*appContext, *cohortStore) {
+	ds := &cohortStore{}
+	for _, name := range []string{"A.ORG", "B.ORG", "C.ORG", "D.ORG", "E.ORG", "F.ORG", "G.ORG", "H.COM"} {
+		ds.domains = append(ds.domains, &model.CohortDomain{Name: name, Active: true, FirstSeen: model.NewDate(time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC))})
+	}
+	return &appContext{
+		ds:      ds,
+		zones:   testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+		zoneIDs: refcache.New("test_cohort_zone_ids", ds.GetZoneIDs),
+	}, ds
+}
+
+func cohortSample(t *testing.T, app *appContext, month, query string) (*httptest.ResponseRecorder, *model.CohortSample) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	app.apiCohortSampleHandler(w, varsRequest("/api/cohorts/"+month+"/sample"+query, map[string]string{"month": month}))
+	var resp struct{ Data *model.CohortSample }
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, resp.Data
+}
+
+func sampleNames(sample *model.CohortSample) []string {
+	var names []string
+	for _, d := range sample.Domains {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// TestCohortSample draws the same panel for the same seed and another for another seed
+func TestCohortSample(t *testing.T) {
+	app, ds := cohortApp(t)
+	w, first := cohortSample(t, app, "2023-07", "?size=4&seed=panel")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s", w.Code, w.Body)
+	}
+	if first.Cohort != "2023-07" || first.Seed != "panel" || !first.Complete || first.CohortSize != 8 || ds.zoneID != 0 {
+		t.Errorf("got %s", w.Body)
+	}
+	if !ds.month.Equal(time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("sampled the month %s, want 2023-07-01", ds.month)
+	}
+	// the restricted H.COM is left out of the sample, size is the number of domains returned
+	if first.Size != len(first.Domains) || first.Size > 4 || first.Size < 3 {
+		t.Errorf("got size %d of %d domains", first.Size, len(first.Domains))
+	}
+	for _, d := range first.Domains {
+		if d.Name == "H.COM" {
+			t.Error("the sample has a domain of a restricted zone")
+		}
+	}
+	_, again := cohortSample(t, app, "2023-07", "?size=4&seed=panel")
+	if !reflect.DeepEqual(sampleNames(again), sampleNames(first)) {
+		t.Errorf("the same seed drew %v then %v", sampleNames(first), sampleNames(again))
+	}
+	_, other := cohortSample(t, app, "2023-07", "?size=4&seed=other")
+	if reflect.DeepEqual(sampleNames(other), sampleNames(first)) {
+		t.Errorf("seeds panel and other drew the same panel %v", sampleNames(first))
+	}
+	_, unseeded := cohortSample(t, app, "2023-07", "?size=4")
+	_, zeroSeed := cohortSample(t, app, "2023-07", "?size=4&seed=0")
+	if unseeded.Seed != defaultCohortSeed || !reflect.DeepEqual(sampleNames(unseeded), sampleNames(zeroSeed)) {
+		t.Errorf("without a seed: got seed %q and %v, want %v", unseeded.Seed, sampleNames(unseeded), sampleNames(zeroSeed))
+	}
+
+	// the month is not over yet
+	thisMonth := server.Today().Format("2006-01")
+	if _, current := cohortSample(t, app, thisMonth, ""); current.Complete {
+		t.Errorf("the cohort of %s is complete", thisMonth)
+	}
+
+	if w, zoned := cohortSample(t, app, "2023-07", "?zone=org"); w.Code != http.StatusOK || zoned.Zone != "ORG" || ds.zoneID != 3 {
+		t.Errorf("zone: got status %d %s, sampled zone %d", w.Code, w.Body, ds.zoneID)
+	}
+}
+
+func TestCohortSampleErrors(t *testing.T) {
+	next := server.Today().AddDate(0, 1, 0).Format("2006-01")
+	tests := []struct {
+		name  string
+		month string
+		query string
+		err   error
+		want  int
+		code  string
+	}{
+		{name: "invalid month", month: "2023-13", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "a day", month: "2023-07-04", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "future month", month: next, want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "size too small", month: "2023-07", query: "?size=0", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "size too large", month: "2023-07", query: "?size=1001", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "seed too long", month: "2023-07", query: "?seed=" + strings.Repeat("s", maxCohortSeed+1), want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid zone", month: "2023-07", query: "?zone=xn--bcher-kva%C3%BC", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "restricted zone", month: "2023-07", query: "?zone=com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "unknown zone", month: "2023-07", query: "?zone=example", want: http.StatusNotFound, code: "resource_not_found"},
+		{name: "database unavailable", month: "2023-07", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ds := cohortApp(t)
+			ds.err = tt.err
+			w, _ := cohortSample(t, app, tt.month, tt.query)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+		})
+	}
+}
+
+func TestCohortMonthComplete(t *testing.T) {
+	month := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		today time.Time
+		want  bool
+	}{
+		{today: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), want: false},
+		{today: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), want: true},
+		{today: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), want: true},
+	}
+	for _, tt := range tests {
+		if got := cohortMonthComplete(month, tt.today); got != tt.want {
+			t.Errorf("on %s: got complete %t, want %t", tt.today.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
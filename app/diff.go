@@ -0,0 +1,237 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/reqstats"
+	"dnscoffee/server"
+)
+
+// diffPageSize is the default and maxDiffPageSize the largest number of domains on a page of a zone diff
+const (
+	diffPageSize    = 100
+	maxDiffPageSize = 1000
+)
+
+// diffKey identifies a zone diff by the imports compared
+type diffKey struct {
+	zoneID   int64
+	from, to int64
+}
+
+// zoneDiffs caches computed zone diffs, least recently used first out, and shares the computation of a diff
+// between concurrent requests for it
+// diffs are computed outside of the requests with their own timeout, a request timing out leaves
+// the computation running so that the diff is cached when the client tries again
+type zoneDiffs struct {
+	ds      *datastore.DataStore
+	size    int
+	timeout time.Duration
+	// canceled when the server shuts down
+	ctx context.Context
+
+	mu       sync.Mutex
+	entries  map[diffKey]*list.Element
+	order    *list.List
+	inflight map[diffKey]*diffCall
+}
+
+// diffCall is a diff computation that other requests can wait on
+type diffCall struct {
+	done chan struct{}
+	diff *datastore.ZoneDiff
+	err  error
+}
+
+// cachedDiff is an entry of the zoneDiffs LRU
+type cachedDiff struct {
+	key  diffKey
+	diff *datastore.ZoneDiff
+}
+
+func newZoneDiffs(ctx context.Context, ds *datastore.DataStore, size int, timeout time.Duration) *zoneDiffs {
+	return &zoneDiffs{
+		ds:       ds,
+		size:     size,
+		timeout:  timeout,
+		ctx:      ctx,
+		entries:  make(map[diffKey]*list.Element),
+		order:    list.New(),
+		inflight: make(map[diffKey]*diffCall),
+	}
+}
+
+// get returns the diff between the imports from the cache, or computes it
+func (zd *zoneDiffs) get(ctx context.Context, key diffKey, from, to datastore.Import) (*datastore.ZoneDiff, error) {
+	zd.mu.Lock()
+	elem, ok := zd.entries[key]
+	reqstats.FromContext(ctx).Cache(ok)
+	if ok {
+		zd.order.MoveToFront(elem)
+		zd.mu.Unlock()
+		return elem.Value.(*cachedDiff).diff, nil
+	}
+	call, running := zd.inflight[key]
+	if !running {
+		call = &diffCall{done: make(chan struct{})}
+		zd.inflight[key] = call
+		go zd.compute(key, from, to, call)
+	}
+	zd.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.diff, call.err
+	}
+}
+
+func (zd *zoneDiffs) compute(key diffKey, from, to datastore.Import, call *diffCall) {
+	ctx, cancel := context.WithTimeout(zd.ctx, zd.timeout)
+	defer cancel()
+	start := time.Now()
+	call.diff, call.err = zd.ds.GetZoneDiff(ctx, key.zoneID, from, to)
+	took := time.Since(start).Round(time.Millisecond)
+	if call.err != nil {
+		logging.Warnf("zone diff %d of imports %d and %d failed after %s: %s", key.zoneID, key.from, key.to, took, call.err)
+	} else {
+		logging.Debugf("zone diff %d of imports %d and %d took %s", key.zoneID, key.from, key.to, took)
+	}
+
+	zd.mu.Lock()
+	delete(zd.inflight, key)
+	if call.err == nil && zd.size > 0 {
+		zd.entries[key] = zd.order.PushFront(&cachedDiff{key: key, diff: call.diff})
+		for zd.order.Len() > zd.size {
+			oldest := zd.order.Back()
+			zd.order.Remove(oldest)
+			delete(zd.entries, oldest.Value.(*cachedDiff).key)
+		}
+	}
+	zd.mu.Unlock()
+	close(call.done)
+}
+
+// flush empties the cache
+func (zd *zoneDiffs) flush() {
+	zd.mu.Lock()
+	defer zd.mu.Unlock()
+	zd.entries = make(map[diffKey]*list.Element)
+	zd.order.Init()
+}
+
+// apiZoneDiffHandler compares the domains of a zone on the imports closest to the from and to dates
+// ?set=added|removed|changed lists a page of the domains of that set
+func (app *appContext) apiZoneDiffHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	fromDate, jsonErr := params.QueryDate(r, "from")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	toDate, jsonErr := params.QueryDate(r, "to")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if toDate.Before(fromDate) {
+		server.WriteJSONError(w, server.NewFieldError("to", "must not be before from"))
+		return
+	}
+	if app.diffMaxDays > 0 && toDate.Sub(fromDate) > time.Duration(app.diffMaxDays)*24*time.Hour {
+		server.WriteJSONError(w, server.NewFieldError("to", fmt.Sprintf("must be at most %d days after from", app.diffMaxDays)))
+		return
+	}
+	set := r.URL.Query().Get("set")
+	switch set {
+	case "", "added", "removed", "changed":
+	default:
+		server.WriteJSONError(w, server.NewFieldError("set", "must be added, removed or changed"))
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxDiffPageSize, diffPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if set != "" && app.zoneForbidden(w, r, zone) {
+		return
+	}
+
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	from, err := app.ds.GetClosestImport(r.Context(), zoneID, fromDate)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	to, err := app.ds.GetClosestImport(r.Context(), zoneID, toDate)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	diff, err := app.diffs.get(r.Context(), diffKey{zoneID: zoneID, from: from.ID, to: to.ID}, from, to)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	data := &model.ZoneDiff{
+		Zone:         zone,
+		FromImportID: from.ID,
+		FromDate:     model.NewDate(from.Date),
+		ToImportID:   to.ID,
+		ToDate:       model.NewDate(to.Date),
+		Added:        len(diff.Added),
+		Removed:      len(diff.Removed),
+		Changed:      len(diff.Changed),
+		Set:          set,
+	}
+	if set != "" {
+		names := map[string][]string{"added": diff.Added, "removed": diff.Removed, "changed": diff.Changed}[set]
+		// cursors are bound to the compared imports, a diff of other imports starts again
+		filter := cursor.Filter("zone_diff", zone, strconv.FormatInt(from.ID, 10), strconv.FormatInt(to.ID, 10), set)
+		start := 0
+		if token := r.URL.Query().Get("cursor"); token != "" {
+			last, err := app.cursors.Decode(token, "domain", filter)
+			if err != nil || len(last) != 1 {
+				server.WriteJSONError(w, server.ErrInvalidCursor)
+				return
+			}
+			start = sort.Search(len(names), func(i int) bool { return names[i] > last[0] })
+		}
+		end := start + limit
+		if end > len(names) {
+			end = len(names)
+		}
+		data.Domains = make([]*model.Domain, 0, end-start)
+		for _, name := range names[start:end] {
+			data.Domains = append(data.Domains, &model.Domain{Name: name})
+		}
+		if end < len(names) {
+			data.NextCursor = app.cursors.Encode("domain", []string{names[end-1]}, filter)
+		}
+	}
+
+	if set == "" {
+		server.WriteJSONWithMeta(w, data, server.ImportID(to.ID))
+		return
+	}
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Domains)), server.NextCursor(data.NextCursor), server.ImportID(to.ID))
+}
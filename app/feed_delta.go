@@ -0,0 +1,129 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// feedDeltaPageSize is the default and maxFeedDeltaPageSize the largest number of domains on a page of a feed delta
+const (
+	feedDeltaPageSize    = 1000
+	maxFeedDeltaPageSize = 10000
+)
+
+// nowCheckpoint is the checkpoint starting a feed delta after the latest finished import
+const nowCheckpoint = "now"
+
+// apiFeedsNewSinceHandler returns the domains added by the imports finished after the checkpoint of the previous call
+// the checkpoint is now for the first call, which returns no domains, ?zone= only lists the domains of a zone and ?limit= sets the page size
+// checkpoints are signed cursors holding the last import read and how many of its domains were, a checkpoint can be used again
+// checkpoints do not expire, without a cursor secret they would not survive a restart and the route is not served
+func (app *appContext) apiFeedsNewSinceHandler(w http.ResponseWriter, r *http.Request) {
+	if app.checkpoints == nil {
+		server.WriteJSONError(w, server.ErrNoCheckpoints)
+		return
+	}
+	checkpoint, jsonErr := params.Path(r, "checkpoint")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxFeedDeltaPageSize, feedDeltaPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data := &model.FeedDelta{Change: "new", Domains: []*model.FeedDeltaDomain{}}
+	var zoneID int64
+	if r.URL.Query().Get("zone") != "" {
+		data.Zone, jsonErr = params.QueryDomain(r, "zone")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		if app.zoneForbidden(w, r, data.Zone) {
+			return
+		}
+		var err error
+		zoneID, err = app.zoneID(r.Context(), data.Zone)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+	}
+	sources, jsonErr := app.feedSources(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	// a checkpoint is only valid with the zone and sources it was issued for
+	data.Sources = sources
+	filter := cursor.Filter("feed_new_since", data.Zone)
+	if sources != nil {
+		filter = cursor.Filter("feed_new_since", data.Zone, strings.Join(sources, ","))
+	}
+
+	if checkpoint == nowCheckpoint {
+		pos, err := app.ds.GetLastFeedPosition(r.Context())
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		data.NextCheckpoint = app.encodeFeedPosition(pos, filter)
+		server.WriteJSON(w, data)
+		return
+	}
+	pos, ok := app.decodeFeedPosition(checkpoint, filter)
+	if !ok {
+		server.WriteJSONError(w, server.ErrInvalidCursor)
+		return
+	}
+	domains, err := app.ds.GetFeedNewSince(r.Context(), pos, zoneID, sources, limit)
+	if err == datastore.ErrNoResource {
+		// the import of the checkpoint was removed
+		server.WriteJSONError(w, server.ErrInvalidCursor)
+		return
+	}
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	for _, d := range domains {
+		if d.ImportID == pos.ImportID {
+			pos.Offset++
+		} else {
+			pos = datastore.FeedPosition{ImportID: d.ImportID, Offset: 1}
+		}
+		// the domains of restricted zones are skipped rather than ending the page, the checkpoint still moves past them
+		if app.zones.Check(r, d.Name) == nil {
+			data.Domains = append(data.Domains, d)
+		}
+	}
+	data.NextCheckpoint = app.encodeFeedPosition(pos, filter)
+	// the checkpoint is a path parameter, it has no next link
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Domains)))
+}
+
+func (app *appContext) encodeFeedPosition(pos datastore.FeedPosition, filter string) string {
+	return app.checkpoints.Encode("import", []string{strconv.FormatInt(pos.ImportID, 10), strconv.FormatInt(pos.Offset, 10)}, filter)
+}
+
+// decodeFeedPosition returns false for a tampered checkpoint, or one issued for another zone
+func (app *appContext) decodeFeedPosition(token, filter string) (datastore.FeedPosition, bool) {
+	last, err := app.checkpoints.Decode(token, "import", filter)
+	if err != nil || len(last) != 2 {
+		return datastore.FeedPosition{}, false
+	}
+	importID, err := strconv.ParseInt(last[0], 10, 64)
+	if err != nil {
+		return datastore.FeedPosition{}, false
+	}
+	offset, err := strconv.ParseInt(last[1], 10, 64)
+	if err != nil || offset < 0 {
+		return datastore.FeedPosition{}, false
+	}
+	return datastore.FeedPosition{ImportID: importID, Offset: offset}, true
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+	"dnscoffee/server"
+)
+
+// feedDeltaStore lists its domains in import order after a position, the imports in removed are gone
+type feedDeltaStore struct {
+	fakeStore
+	last    datastore.FeedPosition
+	domains []*model.FeedDeltaDomain
+	zoneIDs map[string]int64
+	removed map[int64]bool
+	err     error
+}
+
+func (s *feedDeltaStore) GetLastFeedPosition(ctx context.Context) (datastore.FeedPosition, error) {
+	return s.last, s.err
+}
+
+func (s *feedDeltaStore) GetZoneIDs(ctx context.Context) (map[string]int64, error) {
+	return s.zoneIDs, nil
+}
+
+func (s *feedDeltaStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	if id, ok := s.zoneIDs[name]; ok {
+		return id, nil
+	}
+	return 0, datastore.ErrNoResource
+}
+
+func (s *feedDeltaStore) GetFeedNewSince(ctx context.Context, pos datastore.FeedPosition, zoneID int64, sources []string, limit int) ([]*model.FeedDeltaDomain, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.removed[pos.ImportID] {
+		return nil, datastore.ErrNoResource
+	}
+	var domains []*model.FeedDeltaDomain
+	var offset int64
+	for _, d := range s.domains {
+		if d.ImportID < pos.ImportID {
+			continue
+		}
+		if d.ImportID == pos.ImportID {
+			offset++
+			if offset <= pos.Offset {
+				continue
+			}
+		}
+		if zoneID != 0 && s.zoneIDs[d.Zone] != zoneID {
+			continue
+		}
+		if len(domains) == limit {
+			break
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// feedDeltaApp returns an app serving the new domains of three imports, the first is read by the latest checkpoint
+func feedDeltaApp(t *testing.T) (*appContext, *feedDeltaStore) {
+	ds := &feedDeltaStore{
+		last: datastore.FeedPosition{ImportID: 1, Offset: 2},
+		domains: []*model.FeedDeltaDomain{
+			{Name: "x.org", Zone: "ORG", ImportID: 1},
+			{Name: "y.org", Zone: "ORG", ImportID: 1},
+			{Name: "a.org", Zone: "ORG", ImportID: 2},
+			{Name: "b.com", Zone: "COM", ImportID: 2},
+			{Name: "c.org", Zone: "ORG", ImportID: 2},
+			{Name: "d.net", Zone: "NET", ImportID: 3},
+		},
+		zoneIDs: map[string]int64{"COM": 1, "NET": 2, "ORG": 3},
+		removed: map[int64]bool{},
+	}
+	checkpoints, err := cursor.NewCodec("test secret", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &appContext{
+		ds:          ds,
+		zones:       testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+		checkpoints: checkpoints,
+		zoneIDs:     refcache.New("test_feed_delta_zone_ids", ds.GetZoneIDs),
+		sources:     map[string]bool{"czds": true, "axfr": true},
+	}, ds
+}
+
+// feedDelta requests the delta since checkpoint with query and returns the status and the delta
+func feedDelta(t *testing.T, app *appContext, checkpoint, query string) (int, *model.FeedDelta, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	app.apiFeedsNewSinceHandler(w, varsRequest("/api/feeds/new/since/"+checkpoint+query, map[string]string{"checkpoint": checkpoint}))
+	var body struct{ Data *model.FeedDelta }
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, body.Data, w.Body.String()
+}
+
+func names(delta *model.FeedDelta) []string {
+	names := []string{}
+	for _, d := range delta.Domains {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// TestFeedsNewSince pages through the imports after the latest checkpoint, a checkpoint can be used again
+func TestFeedsNewSince(t *testing.T) {
+	app, _ := feedDeltaApp(t)
+	status, delta, body := feedDelta(t, app, nowCheckpoint, "")
+	if status != http.StatusOK || len(delta.Domains) != 0 || delta.NextCheckpoint == "" {
+		t.Fatalf("now: got status %d %s, want no domains and a checkpoint", status, body)
+	}
+
+	// the restricted b.com is skipped, the checkpoint still moves past it
+	pages := []struct {
+		want []string
+	}{
+		{want: []string{"a.org"}},
+		{want: []string{"c.org", "d.net"}},
+		{want: []string{}},
+	}
+	checkpoint := delta.NextCheckpoint
+	var checkpoints []string
+	for i, page := range pages {
+		status, delta, body := feedDelta(t, app, checkpoint, "?limit=2")
+		if status != http.StatusOK {
+			t.Fatalf("page %d: got status %d %s", i, status, body)
+		}
+		if got := names(delta); !reflect.DeepEqual(got, page.want) {
+			t.Errorf("page %d: got %v, want %v", i, got, page.want)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+		checkpoint = delta.NextCheckpoint
+	}
+	// an empty page returns the checkpoint it was called with
+	if checkpoint != checkpoints[2] {
+		t.Error("an empty page moved the checkpoint")
+	}
+	if _, delta, _ := feedDelta(t, app, checkpoints[0], "?limit=2"); !reflect.DeepEqual(names(delta), pages[0].want) {
+		t.Errorf("reused checkpoint: got %v, want %v", names(delta), pages[0].want)
+	}
+}
+
+func TestFeedsNewSinceZone(t *testing.T) {
+	app, _ := feedDeltaApp(t)
+	_, delta, _ := feedDelta(t, app, nowCheckpoint, "?zone=org")
+	if delta.Zone != "ORG" {
+		t.Errorf("got zone %q, want ORG", delta.Zone)
+	}
+	status, zoned, body := feedDelta(t, app, delta.NextCheckpoint, "?zone=org")
+	if status != http.StatusOK || !reflect.DeepEqual(names(zoned), []string{"a.org", "c.org"}) {
+		t.Errorf("got status %d %s, want a.org and c.org", status, body)
+	}
+	// a checkpoint is bound to the zone and sources it was issued for
+	for _, query := range []string{"", "?zone=net", "?zone=org&source=czds"} {
+		if status, _, body := feedDelta(t, app, delta.NextCheckpoint, query); status != http.StatusBadRequest || !strings.Contains(body, `"code":"invalid_cursor"`) {
+			t.Errorf("%q: got status %d %s, want invalid_cursor", query, status, body)
+		}
+	}
+}
+
+func TestFeedsNewSinceErrors(t *testing.T) {
+	app, _ := feedDeltaApp(t)
+	_, delta, _ := feedDelta(t, app, nowCheckpoint, "")
+	valid := delta.NextCheckpoint
+	tests := []struct {
+		name       string
+		checkpoint string
+		query      string
+		removed    bool
+		err        error
+		want       int
+		code       string
+	}{
+		{name: "tampered checkpoint", checkpoint: valid[:len(valid)-2] + "xx", want: http.StatusBadRequest, code: "invalid_cursor"},
+		{name: "not a checkpoint", checkpoint: "yesterday", want: http.StatusBadRequest, code: "invalid_cursor"},
+		{name: "removed import", checkpoint: valid, removed: true, want: http.StatusBadRequest, code: "invalid_cursor"},
+		{name: "limit too small", checkpoint: valid, query: "?limit=0", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "limit too large", checkpoint: valid, query: "?limit=10001", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "unknown source", checkpoint: valid, query: "?source=ftp", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid zone", checkpoint: valid, query: "?zone=xn--bcher-kva%C3%BC", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "unknown zone", checkpoint: nowCheckpoint, query: "?zone=example", want: http.StatusNotFound, code: "resource_not_found"},
+		{name: "restricted zone", checkpoint: nowCheckpoint, query: "?zone=com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "database unavailable", checkpoint: nowCheckpoint, err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+		{name: "database unavailable reading", checkpoint: valid, err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ds := feedDeltaApp(t)
+			ds.removed[1] = tt.removed
+			ds.err = tt.err
+			status, _, body := feedDelta(t, app, tt.checkpoint, tt.query)
+			if status != tt.want {
+				t.Fatalf("got status %d %s, want %d", status, body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(body, `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", body, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// feedChanges are the feeds that can be downloaded
+var feedChanges = []string{"new", "old", "moved"}
+
+// feedExportName is the file name of the download of a feed
+func feedExportName(change string, date time.Time) string {
+	return fmt.Sprintf("feed-%s-%s.csv.gz", change, date.Format("2006-01-02"))
+}
+
+// checksumName is the file name of the SHA-256 checksum of the pre-generated file name, in the format of sha256sum
+func checksumName(name string) string {
+	return name + ".sha256"
+}
+
+// writeFeedCSV writes the domains of the feed kept by keep to w as a gzip compressed CSV with a header line,
+// only those of the imports from one of sources unless it is empty
+func writeFeedCSV(ctx context.Context, ds store, w io.Writer, change string, date time.Time, sources []string, keep func(domain string) bool) error {
+	gz := gzip.NewWriter(w)
+	cw := csv.NewWriter(gz)
+	err := cw.Write([]string{"domain"})
+	if err != nil {
+		return err
+	}
+	err = ds.StreamFeed(ctx, change, date, sources, func(domain string) error {
+		if !keep(domain) {
+			return nil
+		}
+		return cw.Write([]string{domain})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// feedExports pre-generates the downloads of the feeds of past dates into a directory
+// the files only contain the domains every client may read, requests with API key scopes are streamed from the database
+type feedExports struct {
+	ds    *datastore.DataStore
+	zones *server.ZoneAccess
+	// empty disables the pre-generated files
+	dir string
+	// number of past dates kept
+	days int
+	// URL the directory is also published at, the manifest points at the API downloads when empty
+	baseURL string
+}
+
+// path returns the pre-generated file of the feed, or "" if feeds of date are not pre-generated
+func (fe *feedExports) path(change string, date time.Time) string {
+	if fe.dir == "" || !date.Before(server.Today()) {
+		return ""
+	}
+	return filepath.Join(fe.dir, feedExportName(change, date))
+}
+
+// run generates the missing files of the past days and removes older ones
+// yesterday's files are always generated again, imports finishing late still change them
+func (fe *feedExports) run(ctx context.Context) error {
+	today := server.Today()
+	keep := make(map[string]bool)
+	for i := 1; i <= fe.days; i++ {
+		date := today.AddDate(0, 0, -i)
+		for _, change := range feedChanges {
+			name := feedExportName(change, date)
+			keep[name] = true
+			keep[checksumName(name)] = true
+			path := filepath.Join(fe.dir, name)
+			if exists(path) && exists(checksumName(path)) && i > 1 {
+				continue
+			}
+			if err := fe.generate(ctx, change, date, path); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(fe.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sha256")
+		if strings.HasPrefix(name, "feed-") && strings.HasSuffix(name, ".csv.gz") && !keep[e.Name()] {
+			if err = os.Remove(filepath.Join(fe.dir, e.Name())); err != nil {
+				return err
+			}
+			logging.Debugf("feed exports: removed %s", e.Name())
+		}
+	}
+	return nil
+}
+
+// exists returns true if there is a file at path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// generate writes the feed to path through a temporary file so that a partial file is never served
+// its checksum file is removed first and written last, the manifest never lists a file with the checksum of another
+func (fe *feedExports) generate(ctx context.Context, change string, date time.Time, path string) error {
+	start := time.Now()
+	f, err := os.CreateTemp(fe.dir, ".feed-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	err = writeFeedCSV(ctx, fe.ds, io.MultiWriter(f, h), change, date, nil, func(domain string) bool { return !fe.zones.Restricted(domain) })
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Remove(checksumName(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if err = fe.writeChecksum(path, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return err
+	}
+	logging.Infof("feed exports: generated %s in %s", filepath.Base(path), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// writeChecksum writes the checksum file of the file at path through a temporary file
+func (fe *feedExports) writeChecksum(path, sum string) error {
+	f, err := os.CreateTemp(fe.dir, ".sha256-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s  %s\n", sum, filepath.Base(path))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), checksumName(path))
+}
+
+// serveExport serves the pre-generated file at path, returns false and the error if it can not be read
+func serveExport(w http.ResponseWriter, r *http.Request, path, disposition string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", disposition)
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+	return true, nil
+}
+
+// feedDownloadHandler returns the handler of the gzip compressed CSV download of the change feed of a date
+// pre-generated files are served with range requests, other downloads are streamed from the database
+func (app *appContext) feedDownloadHandler(change string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date, jsonErr := params.Date(r, "date")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		sources, jsonErr := app.feedSources(r)
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		name := feedExportName(change, date)
+		disposition := fmt.Sprintf("attachment; filename=%q", name)
+
+		// the pre-generated files hold the domains of every source
+		if path := app.exports.path(change, date); path != "" && !app.zones.HasScopes(r) && sources == nil {
+			served, err := serveExport(w, r, path, disposition)
+			if served {
+				return
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				logging.Warnf("feed download %s: %s", name, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", disposition)
+		err := writeFeedCSV(r.Context(), app.ds, w, change, date, sources, func(domain string) bool {
+			return app.zones.Check(r, domain) == nil
+		})
+		if err != nil {
+			// an error before anything was sent is still reported, WriteJSONError skips committed responses
+			w.Header().Del("Content-Disposition")
+			app.writeError(w, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// dataAsOfHeader carries when the latest import of the data of a response finished
+const dataAsOfHeader = "X-Data-As-Of"
+
+// freshness knows when the latest import of every zone finished, refreshed by the freshness job and import notifications,
+// so that responses carry the age of their data without a query
+type freshness struct {
+	ds *datastore.DataStore
+	// data older than staleAfter is warned about, 0 never warns
+	staleAfter time.Duration
+	// *freshnessSnapshot, nil until the job first ran
+	snapshot atomic.Value
+}
+
+// freshnessSnapshot is the latest import of every zone by name, and the latest of them
+type freshnessSnapshot struct {
+	zones  map[string]time.Time
+	latest time.Time
+}
+
+func newFreshness(ds *datastore.DataStore, staleAfter time.Duration) *freshness {
+	return &freshness{ds: ds, staleAfter: staleAfter}
+}
+
+// run reads the latest import of every zone, it is the freshness job
+func (f *freshness) run(ctx context.Context) error {
+	zones, err := f.ds.GetZoneLastImported(ctx)
+	if err != nil {
+		return err
+	}
+	snap := &freshnessSnapshot{zones: zones}
+	for _, at := range zones {
+		if at.After(snap.latest) {
+			snap.latest = at
+		}
+	}
+	f.snapshot.Store(snap)
+	return nil
+}
+
+// asOf returns the zone of the data of the request and when its latest import finished:
+// the zone of a zone path parameter, the zone a domain path parameter belongs to, or no zone and the latest import
+// of any zone; the time is zero before the job first ran and for zones never imported
+func (f *freshness) asOf(r *http.Request) (string, bool, time.Time) {
+	snap, _ := f.snapshot.Load().(*freshnessSnapshot)
+	if snap == nil {
+		return "", false, time.Time{}
+	}
+	vars := mux.Vars(r)
+	if zone, ok := vars["zone"]; ok {
+		zone, err := params.CleanDomain(zone)
+		if err != nil {
+			return "", false, time.Time{}
+		}
+		return zone, true, snap.zones[zone]
+	}
+	if strings.HasSuffix(r.URL.Path, "/root") {
+		return "", true, snap.zones[""]
+	}
+	if domain, ok := vars["domain"]; ok {
+		domain, err := params.CleanDomain(domain)
+		if err != nil {
+			return "", false, time.Time{}
+		}
+		// the longest zone the domain is in, the root zone holds the TLDs
+		for {
+			i := strings.IndexByte(domain, '.')
+			if i < 0 {
+				return "", true, snap.zones[""]
+			}
+			domain = domain[i+1:]
+			if at, ok := snap.zones[domain]; ok {
+				return domain, true, at
+			}
+		}
+	}
+	return "", false, snap.latest
+}
+
+// handler sets X-Data-As-Of and the data_as_of meta on the responses of next, and when the data is older than
+// staleAfter a Warning header and a warning in the meta stating its age
+// it runs outside the response caches so that cached responses get the current age
+func (f *freshness) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zone, ok, at := f.asOf(r)
+		if !at.IsZero() {
+			w.Header().Set(dataAsOfHeader, at.UTC().Format(model.TimestampFormat))
+			server.SetResponseMeta(w, server.DataAsOf(at))
+			if age := time.Since(at); f.staleAfter > 0 && age > f.staleAfter {
+				msg := staleWarning(zone, ok, at, age)
+				w.Header().Add("Warning", fmt.Sprintf("199 dnscoffee %q", msg))
+				server.SetResponseMeta(w, server.Warning(msg))
+			}
+		}
+		next(w, r)
+	}
+}
+
+// staleWarning states the age of data whose latest import finished at at, of zone when ok
+func staleWarning(zone string, ok bool, at time.Time, age time.Duration) string {
+	of := "any zone"
+	switch {
+	case ok && zone == "":
+		of = "the root zone"
+	case ok:
+		of = "zone " + zone
+	}
+	return fmt.Sprintf("data is %.1f days old, the latest import of %s finished at %s",
+		age.Hours()/24, of, at.UTC().Format(model.TimestampFormat))
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+)
+
+func TestFreshnessHandler(t *testing.T) {
+	now := time.Now()
+	fresh, stale := now.Add(-time.Hour), now.Add(-72*time.Hour)
+	snap := &freshnessSnapshot{
+		zones:  map[string]time.Time{"": fresh, "COM": stale, "CO.UK": fresh, "UK": stale},
+		latest: fresh,
+	}
+	type handler func(app *appContext) http.HandlerFunc
+	zone := func(app *appContext) http.HandlerFunc { return app.apiZoneHandler }
+	nameserver := func(app *appContext) http.HandlerFunc { return app.apiNameserverHandler }
+	tests := []struct {
+		name    string
+		handler handler
+		target  string
+		vars    map[string]string
+		// no snapshot until the job ran, and the lookups fail with err
+		snap       *freshnessSnapshot
+		staleAfter time.Duration
+		err        error
+		want       int
+		// the X-Data-As-Of time, zero for none, and the zone the warning names, empty for none
+		wantAsOf time.Time
+		wantWarn string
+	}{
+		{name: "fresh zone", handler: zone, vars: map[string]string{"zone": "co.uk"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: fresh},
+		{name: "stale zone", handler: zone, vars: map[string]string{"zone": "com"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: stale, wantWarn: "zone COM"},
+		{name: "never warns", handler: zone, vars: map[string]string{"zone": "com"}, snap: snap, want: http.StatusOK, wantAsOf: stale},
+		{name: "zone never imported", handler: zone, vars: map[string]string{"zone": "org"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK},
+		{name: "before the job ran", handler: zone, vars: map[string]string{"zone": "com"}, staleAfter: 48 * time.Hour, want: http.StatusOK},
+		// the age is sent with the errors of the handler too
+		{name: "unknown zone", handler: zone, vars: map[string]string{"zone": "com"}, snap: snap, staleAfter: 48 * time.Hour, err: datastore.ErrNoResource, want: http.StatusNotFound, wantAsOf: stale, wantWarn: "zone COM"},
+		{name: "database unavailable", handler: zone, vars: map[string]string{"zone": "co.uk"}, snap: snap, staleAfter: 48 * time.Hour, err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, wantAsOf: fresh},
+		{name: "invalid zone", handler: zone, vars: map[string]string{"zone": "xn--bcher-kvaü"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusBadRequest},
+
+		// a domain is in the longest zone imported, and the TLDs in the root zone
+		{name: "domain of the longest zone", handler: nameserver, vars: map[string]string{"domain": "ns1.example.co.uk"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: fresh},
+		{name: "domain of a stale zone", handler: nameserver, vars: map[string]string{"domain": "ns1.example.com"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: stale, wantWarn: "zone COM"},
+		{name: "domain of no zone imported", handler: nameserver, vars: map[string]string{"domain": "ns1.example.org"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: fresh},
+		{name: "invalid domain", handler: nameserver, vars: map[string]string{"domain": "xn--bcher-kvaü"}, snap: snap, staleAfter: 48 * time.Hour, want: http.StatusBadRequest},
+
+		{name: "root zone", target: "/api/zones/root", snap: &freshnessSnapshot{zones: map[string]time.Time{"": stale}, latest: stale}, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: stale, wantWarn: "the root zone"},
+		{name: "any zone", target: "/api/research/stats", snap: &freshnessSnapshot{zones: map[string]time.Time{"COM": stale}, latest: stale}, staleAfter: 48 * time.Hour, want: http.StatusOK, wantAsOf: stale, wantWarn: "any zone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &freshness{staleAfter: tt.staleAfter}
+			if tt.snap != nil {
+				f.snapshot.Store(tt.snap)
+			}
+			next := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+			if tt.handler != nil {
+				next = tt.handler(&appContext{ds: &txStore{t: t, err: tt.err}, zones: testZoneAccess(t), providers: testProviders(t)})
+			}
+			target := tt.target
+			if target == "" {
+				target = "/api/lookup"
+			}
+			w := httptest.NewRecorder()
+			f.handler(next)(w, varsRequest(target, tt.vars))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			wantAsOf := ""
+			if !tt.wantAsOf.IsZero() {
+				wantAsOf = tt.wantAsOf.UTC().Format(model.TimestampFormat)
+			}
+			if got := w.Header().Get(dataAsOfHeader); got != wantAsOf {
+				t.Errorf("got %s %q, want %q", dataAsOfHeader, got, wantAsOf)
+			}
+			warning := w.Header().Get("Warning")
+			if tt.wantWarn == "" {
+				if warning != "" {
+					t.Errorf("got Warning %q, want none", warning)
+				}
+				return
+			}
+			want := fmt.Sprintf("199 dnscoffee \"data is 3.0 days old, the latest import of %s finished at %s\"", tt.wantWarn, wantAsOf)
+			if warning != want {
+				t.Errorf("got Warning %q, want %q", warning, want)
+			}
+		})
+	}
+}
+
+func TestStaleWarning(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		zone string
+		ok   bool
+		want string
+	}{
+		{zone: "COM", ok: true, want: "data is 2.5 days old, the latest import of zone COM finished at 2026-03-01T11:00:00Z"},
+		{zone: "", ok: true, want: "data is 2.5 days old, the latest import of the root zone finished at 2026-03-01T11:00:00Z"},
+		{zone: "", ok: false, want: "data is 2.5 days old, the latest import of any zone finished at 2026-03-01T11:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := staleWarning(tt.zone, tt.ok, at, 60*time.Hour); got != tt.want {
+			t.Errorf("staleWarning(%q, %t) = %q, want %q", tt.zone, tt.ok, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// gluePageSize is the default and maxGluePageSize the largest number of anomalies on a page of a glue report
+const (
+	gluePageSize    = 100
+	maxGluePageSize = 1000
+)
+
+// checkGlue finds the glue anomalies of the imports landed since the last run
+func (app *appContext) checkGlue(ctx context.Context) error {
+	n, err := app.ds.RefreshGlueInconsistencies(ctx)
+	if err != nil {
+		return err
+	}
+	logging.Debugf("glue: checked %d zones", n)
+	return nil
+}
+
+// apiZoneInconsistenciesHandler returns a page of the glue anomalies of ?type= found in the latest checked import of a zone
+// orphan_glue is glue no domain delegates to and missing_glue the nameservers under the zone delegated to without glue,
+// ?limit= sets the page size and ?cursor= continues from the next_cursor of the previous page
+// the anomalies are found by the glue job after every import, a zone is not found until it ran
+func (app *appContext) apiZoneInconsistenciesHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	kind := r.URL.Query().Get("type")
+	if kind != datastore.OrphanGlue && kind != datastore.MissingGlue {
+		server.WriteJSONError(w, server.NewFieldError("type", "must be orphan_glue or missing_glue"))
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxGluePageSize, gluePageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	// the anomalies name the domains delegating to a nameserver
+	if app.zoneForbidden(w, r, zone) {
+		return
+	}
+	filter := cursor.Filter("glue", zone, kind)
+	var after string
+	var importID int64
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		last, err := app.cursors.Decode(token, "nameserver", filter)
+		if err == nil && len(last) == 2 {
+			importID, err = strconv.ParseInt(last[1], 10, 64)
+		}
+		if err != nil || len(last) != 2 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+		after = last[0]
+	}
+
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	data, err := app.ds.GetGlueInconsistencies(r.Context(), zoneID, kind, after, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	// the pages of an import are gone once the next one is checked
+	if importID != 0 && importID != data.ImportID {
+		server.WriteJSONError(w, server.ErrDataChanged)
+		return
+	}
+	data.Zone = zone
+	if len(data.Items) > limit {
+		data.Items = data.Items[:limit]
+		last := []string{data.Items[limit-1].NameServer, strconv.FormatInt(data.ImportID, 10)}
+		data.NextCursor = app.cursors.Encode("nameserver", last, filter)
+	}
+
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Items)), server.NextCursor(data.NextCursor), server.ImportID(data.ImportID))
+}
@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// update rewrites the golden responses and adds new fields to the field lists, fields are never removed from them
+var update = flag.Bool("update", false, "rewrite the golden files of the response tests")
+
+// goldenEndpoint is a public route and the type of its response
+type goldenEndpoint struct {
+	// file name of the golden files
+	name string
+	// path of the route relative to /api, as registered with addAPI
+	path string
+	// API version of the route, a response can only lose fields in a new version
+	version int
+	// returns an empty response, filled by fillFixture
+	response func() model.APIData
+}
+
+// goldenEndpoints are the public JSON routes, TestGoldenEndpointsComplete fails when one is missing
+// the feed downloads are gzipped files, not JSON, and are left out
+var goldenEndpoints = []goldenEndpoint{
+	{"stats_imports", "/stats/imports", 1, func() model.APIData { return &model.ImportProgress{} }},
+	{"stats_providers", "/stats/providers", 1, func() model.APIData { return &model.ProviderCounts{} }},
+	{"stats_lifetimes", "/stats/lifetimes", 1, func() model.APIData { return &model.DomainLifetimes{} }},
+	{"stats_churn", "/stats/churn", 1, func() model.APIData { return &model.ZoneChurn{} }},
+	{"stats_keywords_timeseries", "/stats/keywords/{keyword}/timeseries", 1, func() model.APIData { return &model.KeywordTimeseries{} }},
+	{"cohorts_sample", "/cohorts/{month}/sample", 1, func() model.APIData { return &model.CohortSample{} }},
+	{"alerts", "/alerts", 1, func() model.APIData { return &model.ImportAlerts{} }},
+	{"counts", "/counts", 1, func() model.APIData { return &model.ZoneCount{} }},
+	{"counts_zone", "/counts/zone/{zone}", 1, func() model.APIData { return &model.ZoneCount{} }},
+	{"counts_root", "/counts/root", 1, func() model.APIData { return &model.ZoneCount{} }},
+	{"counts_all", "/counts/all", 1, func() model.APIData { return &model.AllZoneCounts{} }},
+	{"root", "/root", 1, func() model.APIData { return &model.Zone{} }},
+	{"zones", "/zones", 1, func() model.APIData { return &model.ZoneImportResults{} }},
+	{"zones_zone", "/zones/{zone}", 1, func() model.APIData { return &model.Zone{} }},
+	{"zones_import", "/zones/{zone}/import", 1, func() model.APIData { return &model.ZoneImportResult{} }},
+	{"zones_count", "/zones/{zone}/count", 1, func() model.APIData { return &model.ZoneCountAsOf{} }},
+	{"zones_count_dates", "/zones/{zone}/count", 1, func() model.APIData { return &model.ZoneCountAsOfSeries{} }},
+	{"zones_stats_labels", "/zones/{zone}/stats/labels", 1, func() model.APIData { return &model.LabelStats{} }},
+	{"zones_infrastructure", "/zones/{zone}/infrastructure", 1, func() model.APIData { return &model.ZoneInfrastructure{} }},
+	{"zones_infrastructure_history", "/zones/{zone}/infrastructure/history", 1, func() model.APIData { return &model.ZoneInfrastructureHistory{} }},
+	{"zones_diff", "/zones/{zone}/diff", 1, func() model.APIData { return &model.ZoneDiff{} }},
+	{"zones_domains", "/zones/{zone}/domains", 1, func() model.APIData { return &model.ZoneDomains{} }},
+	{"zones_inconsistencies", "/zones/{zone}/inconsistencies", 1, func() model.APIData { return &model.GlueInconsistencies{} }},
+	{"random", "/random", 1, func() model.APIData { return &model.Domain{} }},
+	{"domains", "/domains/{domain}", 1, func() model.APIData { return &model.Domain{} }},
+	{"domains_stability", "/domains/{domain}/stability", 1, func() model.APIData { return &model.DomainStability{} }},
+	{"domains_live", "/domains/{domain}/live", 1, func() model.APIData { return &model.LiveDomain{} }},
+	{"label", "/label/{label}", 1, func() model.APIData { return &model.LabelZones{} }},
+	{"nameservers", "/nameservers/{domain}", 1, func() model.APIData { return &model.NameServer{} }},
+	{"nameservers_stats", "/nameservers/{domain}/stats", 1, func() model.APIData { return &model.NameServerStats{} }},
+	{"nameservers_changes", "/nameservers/{domain}/changes", 1, func() model.APIData { return &model.NameServerChanges{} }},
+	{"nameservers_suffix_stats", "/nameservers/suffix/{suffix}/stats", 1, func() model.APIData { return &model.NameServerSuffixStats{} }},
+	{"ip", "/ip/{ip}", 1, func() model.APIData { return &model.IP{} }},
+	{"asn", "/asn/{asn}", 1, func() model.APIData { return &model.ASN{} }},
+	{"nsset", "/nsset/{fingerprint}", 1, func() model.APIData { return &model.NameServerSet{} }},
+	{"feeds_new_search", "/feeds/new/search/{search}", 1, func() model.APIData { return &model.FeedCountList{} }},
+	{"feeds_new_since", "/feeds/new/since/{checkpoint}", 1, func() model.APIData { return &model.FeedDelta{} }},
+	{"feeds_new_date", "/feeds/new/date/{date}", 1, func() model.APIData { return &model.Feed{} }},
+	{"feeds_ns_new_date", "/feeds/ns/new/date/{date}", 1, func() model.APIData { return &model.NSFeed{} }},
+	{"feeds_old_search", "/feeds/old/search/{search}", 1, func() model.APIData { return &model.FeedCountList{} }},
+	{"feeds_old_date", "/feeds/old/date/{date}", 1, func() model.APIData { return &model.Feed{} }},
+	{"feeds_ns_old_date", "/feeds/ns/old/date/{date}", 1, func() model.APIData { return &model.NSFeed{} }},
+	{"feeds_moved_search", "/feeds/moved/search/{search}", 1, func() model.APIData { return &model.FeedCountList{} }},
+	{"feeds_moved_date", "/feeds/moved/date/{date}", 1, func() model.APIData { return &model.Feed{} }},
+	{"feeds_ns_moved_date", "/feeds/ns/moved/date/{date}", 1, func() model.APIData { return &model.NSFeed{} }},
+	{"bulk_manifest", "/bulk/manifest", 1, func() model.APIData { return &model.BulkManifest{} }},
+	{"bulk_manifest_date", "/bulk/manifest/{date}", 1, func() model.APIData { return &model.BulkManifest{} }},
+	{"watchlists", "/watchlists", 1, func() model.APIData { return &model.Watchlists{} }},
+	{"watchlist", "/watchlists/{id}", 1, func() model.APIData { return &model.Watchlist{} }},
+	{"watchlist_matches", "/watchlists/{id}/matches", 1, func() model.APIData { return &model.WatchlistMatches{} }},
+	{"watchlist_new_matches", "/watchlists/{id}/new", 1, func() model.APIData { return &model.WatchlistMatches{} }},
+	{"version", "/version", 1, func() model.APIData { return &model.Version{} }},
+	{"research_ipnszonecount", "/research/ipnszonecount/{ip}", 1, func() model.APIData { return &model.ResearchIPNsZoneCount{} }},
+	{"research_active_ips", "/research/active_ips/{date}", 1, func() model.APIData { return &model.ActiveIPs{} }},
+}
+
+// fixtureTime is the time of every time field of the fixtures
+var fixtureTime = time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// fillFixture sets every exported field reachable from v to a fixed value, so that the omitempty fields are in the
+// golden responses too: strings to their field name, numbers to 1, slices and maps to a single element
+// types already being filled higher up are left empty, for the recursive ones
+func fillFixture(v reflect.Value, name string, filling map[reflect.Type]bool) {
+	t := v.Type()
+	if t == timeType {
+		v.Set(reflect.ValueOf(fixtureTime))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(strings.ToLower(name))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(0.5)
+	case reflect.Ptr:
+		if filling[baseType(t)] {
+			return
+		}
+		p := reflect.New(t.Elem())
+		fillFixture(p.Elem(), name, filling)
+		v.Set(p)
+	case reflect.Slice:
+		if filling[baseType(t.Elem())] {
+			return
+		}
+		s := reflect.MakeSlice(t, 1, 1)
+		fillFixture(s.Index(0), name, filling)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillFixture(v.Index(i), name, filling)
+		}
+	case reflect.Map:
+		if filling[baseType(t.Elem())] {
+			return
+		}
+		m := reflect.MakeMapWithSize(t, 1)
+		key := reflect.New(t.Key()).Elem()
+		fillFixture(key, "key", filling)
+		elem := reflect.New(t.Elem()).Elem()
+		fillFixture(elem, name, filling)
+		m.SetMapIndex(key, elem)
+		v.Set(m)
+	case reflect.Struct:
+		filling[t] = true
+		defer delete(filling, t)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				fillFixture(v.Field(i), f.Name, filling)
+			}
+		}
+	}
+}
+
+// baseType returns the type pointers of t point to
+func baseType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// goldenResponse returns the response of the endpoint to its fixture, written like the handlers write theirs
+func goldenResponse(t *testing.T, e goldenEndpoint) []byte {
+	t.Helper()
+	data := e.response()
+	fillFixture(reflect.ValueOf(data).Elem(), "", map[reflect.Type]bool{})
+	w := httptest.NewRecorder()
+	server.WriteJSON(w, data)
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, w.Body.Bytes(), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	out.WriteByte('\n')
+	return out.Bytes()
+}
+
+// TestGoldenResponses compares the response of every public endpoint to its fixture with the checked-in golden file
+// run with -update to rewrite them after a deliberate change
+func TestGoldenResponses(t *testing.T) {
+	for _, e := range goldenEndpoints {
+		t.Run(e.name, func(t *testing.T) {
+			got := goldenResponse(t, e)
+			file := filepath.Join("testdata", "golden", "v"+strconv.Itoa(e.version), e.name+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("%s, run the tests with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response of %s differs from %s, run with -update if the change is deliberate\ngot:\n%s", e.path, file, got)
+			}
+		})
+	}
+}
+
+// fieldPaths adds the paths of the fields of a decoded JSON value to paths, ex: data.zones[].name
+func fieldPaths(v interface{}, prefix string, paths map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			paths[p] = true
+			fieldPaths(e, p, paths)
+		}
+	case []interface{}:
+		for _, e := range v {
+			fieldPaths(e, prefix+"[]", paths)
+		}
+	}
+}
+
+func readFieldList(file string) (map[string]bool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			fields[line] = true
+		}
+	}
+	return fields, nil
+}
+
+func writeFieldList(file string, fields map[string]bool) error {
+	list := make([]string, 0, len(fields))
+	for f := range fields {
+		list = append(list, f)
+	}
+	sort.Strings(list)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(strings.Join(list, "\n")+"\n"), 0644)
+}
+
+// TestResponseFieldsOnlyGrow fails when a field of a response is removed or renamed, downstream parsers rely on them
+// the field lists of a version only grow, -update adds the new fields but keeps the removed ones, so a route
+// whose response loses a field has to be served from a new API version, whose fields are listed separately
+func TestResponseFieldsOnlyGrow(t *testing.T) {
+	for _, e := range goldenEndpoints {
+		t.Run(e.name, func(t *testing.T) {
+			var decoded interface{}
+			if err := json.Unmarshal(goldenResponse(t, e), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool)
+			fieldPaths(decoded, "", got)
+
+			file := filepath.Join("testdata", "fields", "v"+strconv.Itoa(e.version), e.name+".txt")
+			want, err := readFieldList(file)
+			if os.IsNotExist(err) && *update {
+				want, err = map[string]bool{}, nil
+			}
+			if err != nil {
+				t.Fatalf("%s, run the tests with -update to create it", err)
+			}
+			var removed, added []string
+			for f := range want {
+				if !got[f] {
+					removed = append(removed, f)
+				}
+			}
+			for f := range got {
+				if !want[f] {
+					added = append(added, f)
+				}
+			}
+			sort.Strings(removed)
+			if len(removed) > 0 {
+				t.Errorf("fields removed from the v%d response of %s, serve the change from a new API version: %s",
+					e.version, e.path, strings.Join(removed, ", "))
+			}
+			if len(added) == 0 {
+				return
+			}
+			if !*update {
+				sort.Strings(added)
+				t.Errorf("new fields in the response of %s, run with -update to add them to %s: %s", e.path, file, strings.Join(added, ", "))
+				return
+			}
+			for _, f := range added {
+				want[f] = true
+			}
+			if err := writeFieldList(file, want); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestGoldenEndpointsComplete checks that every route registered with addAPI has a golden response
+func TestGoldenEndpointsComplete(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "api.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	covered := make(map[string]bool)
+	for _, e := range goldenEndpoints {
+		covered[e.path] = true
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 3 {
+			return true
+		}
+		if fn, ok := call.Fun.(*ast.Ident); !ok || fn.Name != "addAPI" {
+			return true
+		}
+		// the routes without a handler are hidden
+		if fn, ok := call.Args[2].(*ast.Ident); ok && fn.Name == "nil" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			return true
+		}
+		path, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !covered[path] {
+			t.Errorf("route %s has no golden response, add it to goldenEndpoints", path)
+		}
+		return true
+	})
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// suspectImportsHeader lists the IDs of the suspect imports the data of a response derives from
+const suspectImportsHeader = "X-Suspect-Imports"
+
+// importCheckConfig is how the changes of an import are judged, see Config
+type importCheckConfig struct {
+	trailing         int
+	minHistory       int
+	addedThreshold   float64
+	removedThreshold float64
+	minChange        int64
+}
+
+// importChecks checks the domains every finished import added and removed against the previous imports of its zone,
+// it is the import_checks job, and keeps the suspect imports so that the responses derived from them are flagged without a query
+type importChecks struct {
+	ds   *datastore.DataStore
+	conf importCheckConfig
+	// []*model.ImportCheck of the suspect imports, nil until the job first ran
+	suspects atomic.Value
+}
+
+func newImportChecks(ds *datastore.DataStore, conf importCheckConfig) *importChecks {
+	return &importChecks{ds: ds, conf: conf}
+}
+
+// run checks the finished imports not checked yet, oldest first so that every import is judged by the checks before it,
+// then reads the suspect imports
+func (ic *importChecks) run(ctx context.Context) error {
+	imports, err := ic.ds.GetUncheckedImports(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ci := range imports {
+		check, err := ic.check(ctx, ci)
+		if err != nil {
+			return err
+		}
+		err = ic.ds.SaveImportCheck(ctx, ci, check)
+		if err != nil {
+			return err
+		}
+		if check.Suspect {
+			logging.Warnf("import_checks: import %d of zone %q on %s is suspect: %s", ci.ID, ci.Zone,
+				ci.Date.Format(model.DateFormat), strings.Join(check.Reasons, ", "))
+		}
+	}
+	return ic.load(ctx)
+}
+
+// load reads the suspect imports
+func (ic *importChecks) load(ctx context.Context) error {
+	suspects, err := ic.ds.GetSuspectImports(ctx)
+	if err != nil {
+		return err
+	}
+	ic.suspects.Store(suspects)
+	return nil
+}
+
+// check counts the domains an import added and removed and compares them with the averages of the previous imports of its zone
+func (ic *importChecks) check(ctx context.Context, ci datastore.CheckImport) (*model.ImportCheck, error) {
+	added, removed, err := ic.ds.CountImportChanges(ctx, ci)
+	if err != nil {
+		return nil, err
+	}
+	history, addedAverage, removedAverage, err := ic.ds.GetImportCheckAverages(ctx, ci, ic.conf.trailing)
+	if err != nil {
+		return nil, err
+	}
+	check := &model.ImportCheck{
+		ImportID:  ci.ID,
+		Zone:      ci.Zone,
+		Date:      ci.Date,
+		Added:     added,
+		Removed:   removed,
+		History:   history,
+		CheckedAt: model.Now(),
+	}
+	// the first imports of a zone set its averages
+	if history < ic.conf.minHistory {
+		return check, nil
+	}
+	check.AddedAverage, check.RemovedAverage = &addedAverage, &removedAverage
+	if reason := ic.deviation("added", added, addedAverage, ic.conf.addedThreshold, history); reason != "" {
+		check.Reasons = append(check.Reasons, reason)
+	}
+	if reason := ic.deviation("removed", removed, removedAverage, ic.conf.removedThreshold, history); reason != "" {
+		check.Reasons = append(check.Reasons, reason)
+	}
+	check.Suspect = len(check.Reasons) > 0
+	return check, nil
+}
+
+// deviation returns why count is suspect, empty if it is not: more than 1+threshold times the average or less than the
+// average divided by it, and off by at least minChange domains so that small zones are not flagged for a handful of domains
+// a threshold of 0 never flags the count
+func (ic *importChecks) deviation(what string, count int64, average, threshold float64, history int) string {
+	if threshold <= 0 || math.Abs(float64(count)-average) < float64(ic.conf.minChange) {
+		return ""
+	}
+	factor := 1 + threshold
+	if float64(count) <= average*factor && float64(count) >= average/factor {
+		return ""
+	}
+	return fmt.Sprintf("%s %d domains against an average of %.0f over the %d previous imports", what, count, average, history)
+}
+
+// derived returns the IDs of the suspect imports the data of the request derives from: those of the date of a date path
+// parameter, of the zone of a zone path parameter, the root zone or the zone query parameter, or every suspect import
+// for the data of every zone and date such as the statistics
+func (ic *importChecks) derived(r *http.Request) []int64 {
+	suspects, _ := ic.suspects.Load().([]*model.ImportCheck)
+	if len(suspects) == 0 {
+		return nil
+	}
+	vars := mux.Vars(r)
+	var date time.Time
+	if value, ok := vars["date"]; ok {
+		var jsonErr *model.JSONError
+		date, jsonErr = server.ParseDateParam("date", value)
+		if jsonErr != nil {
+			return nil
+		}
+	}
+	zone, byZone := vars["zone"]
+	if !byZone {
+		zone, byZone = r.URL.Query().Get("zone"), r.URL.Query().Get("zone") != ""
+	}
+	if byZone {
+		var err error
+		zone, err = params.CleanDomain(zone)
+		if err != nil {
+			return nil
+		}
+	}
+	if strings.HasSuffix(r.URL.Path, "/root") {
+		zone, byZone = "", true
+	}
+	var ids []int64
+	for _, check := range suspects {
+		if !date.IsZero() && !check.Date.Equal(date) || byZone && check.Zone != zone {
+			continue
+		}
+		ids = append(ids, check.ImportID)
+	}
+	return ids
+}
+
+// handler flags the responses of next whose data derives from suspect imports, in X-Suspect-Imports and the suspect
+// and suspect_imports of the meta, so that clients can skip the days of a truncated zone file
+// it runs outside the response caches so that a cached response is flagged once its import is found suspect or cleared
+func (ic *importChecks) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ids := ic.derived(r); len(ids) > 0 {
+			list := make([]string, len(ids))
+			for i, id := range ids {
+				list[i] = strconv.FormatInt(id, 10)
+			}
+			w.Header().Set(suspectImportsHeader, strings.Join(list, ", "))
+			server.SetResponseMeta(w, server.SuspectImports(ids))
+		}
+		next(w, r)
+	}
+}
+
+// apiImportAlertsHandler lists the suspect imports whose alert was not acknowledged yet
+func (app *appContext) apiImportAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	alerts, err := app.ds.GetImportAlerts(r.Context())
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, &model.ImportAlerts{Alerts: alerts})
+}
+
+// apiAdminImportAlertHandler acknowledges the alert of a suspect import, and when clear resets it as a false alarm
+// the suspect imports are read again so that the responses stop being flagged at once
+func (app *appContext) apiAdminImportAlertHandler(clear bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, jsonErr := params.ID(r, "id")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		by := server.ClientIP(r)
+		check, err := app.ds.AcknowledgeImportAlert(r.Context(), id, by, clear)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		if clear {
+			logging.Infof("admin: alert of import %d cleared by %s", id, by)
+		} else {
+			logging.Infof("admin: alert of import %d acknowledged by %s", id, by)
+		}
+		err = app.importChecks.load(r.Context())
+		if err != nil {
+			logging.Errorf("import_checks: %s", err)
+		}
+		server.WriteJSON(w, check)
+	}
+}
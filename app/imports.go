@@ -0,0 +1,148 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// maxImportNotificationBody is the largest import notification accepted in bytes
+const maxImportNotificationBody = 64 << 10
+
+// seenImportsSize is the number of notified imports remembered to ignore duplicate notifications
+const seenImportsSize = 4096
+
+// importJobs are the background jobs precomputing data that changes with every import
+var importJobs = []string{"stats", "providers", "feed_exports", "nssets", "glue", "keywords", "watchlists", "negative_cache", "freshness", "import_checks", "label_stats", "stability"}
+
+// importHooks handles the notifications of the zone importer
+// every import is handled once, repeated notifications for it are acknowledged and ignored
+type importHooks struct {
+	server *server.Server
+
+	mu    sync.Mutex
+	seen  map[int64]bool
+	order []int64
+}
+
+func newImportHooks(s *server.Server) *importHooks {
+	return &importHooks{
+		server: s,
+		seen:   make(map[int64]bool),
+	}
+}
+
+// first records the import and returns true if it was not notified before
+func (ih *importHooks) first(importID int64) bool {
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	if ih.seen[importID] {
+		return false
+	}
+	if len(ih.order) >= seenImportsSize {
+		delete(ih.seen, ih.order[0])
+		ih.order = ih.order[1:]
+	}
+	ih.seen[importID] = true
+	ih.order = append(ih.order, importID)
+	return true
+}
+
+// importNotificationBody is the body of an import notification, the root zone is "" or "."
+type importNotificationBody struct {
+	Zone     string           `json:"zone" validate:"max=255"`
+	ImportID int64            `json:"import_id" validate:"required,min=1"`
+	Source   string           `json:"source" validate:"max=32"`
+	Rows     map[string]int64 `json:"rows" validate:"max=1000"`
+}
+
+// apiImportCompleteHandler is called by the zone importer when an import finished
+// the caches are emptied, and the reference data refreshed and the precomputing jobs started in the background,
+// the response does not wait for them
+// the body is {"zone": "com", "import_id": 1234, "source": "czds", "rows": {"domains": 100}}, decoded by server.Body
+// the source is optional and recorded on the import first, even for repeated notifications, unless the importer already did
+func (app *appContext) apiImportCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	req := server.RequestBody(r).(*importNotificationBody)
+	data := &model.ImportNotification{ImportID: req.ImportID, Rows: req.Rows}
+	var jsonErr *model.JSONError
+	data.Zone, jsonErr = validImportNotification(req.Zone, req.Rows)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data.Source, jsonErr = app.validImportSource(req.Source)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if data.Source != "" {
+		// before the reference data is refreshed, so that the restrictions by source see it
+		err := app.ds.SetImportSource(r.Context(), data.ImportID, data.Source)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+	}
+
+	if !app.imports.first(data.ImportID) {
+		logging.Debugf("import %d of zone %q already notified", data.ImportID, data.Zone)
+		data.Duplicate = true
+		server.WriteJSON(w, data)
+		return
+	}
+	logging.Infof("import %d of zone %q complete, rows: %v", data.ImportID, data.Zone, data.Rows)
+	data.FlushedCaches = app.imports.server.FlushCaches()
+	app.imports.server.RefreshReferenceData()
+	for _, name := range importJobs {
+		if app.imports.server.RunJob(name) {
+			data.Jobs = append(data.Jobs, name)
+		}
+	}
+	server.WriteJSONStatus(w, http.StatusAccepted, data)
+}
+
+// validImportNotification checks the zone and rows of an import notification, beyond their validate rules,
+// and returns the cleaned zone name
+func validImportNotification(zone string, rows map[string]int64) (string, *model.JSONError) {
+	zone, err := params.CleanDomain(strings.TrimSuffix(zone, "."))
+	if err != nil || len(zone) > params.MaxLength {
+		return "", server.NewFieldError("zone", "must be a zone name")
+	}
+	for table, n := range rows {
+		if table == "" || !params.ValidText(table) || len(table) > params.MaxLength {
+			return "", server.NewFieldError("rows", "must be keyed by table name")
+		}
+		if n < 0 {
+			return "", server.NewFieldError("rows", "must not be negative")
+		}
+	}
+	return zone, nil
+}
+
+// apiAdminImportHandler returns an import with the state and timing of its stages
+// an unfinished import whose zone has a later finished import is failed in the stage after its last completed one
+func (app *appContext) apiAdminImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, jsonErr := params.ID(r, "id")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data, err := app.ds.GetImport(r.Context(), id)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, data)
+}
+
+// apiAdminRunningImportsHandler returns the imports in flight with the stage each is in
+func (app *appContext) apiAdminRunningImportsHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.ds.GetRunningImports(r.Context())
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, data)
+}
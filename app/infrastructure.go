@@ -0,0 +1,61 @@
+package app
+
+import (
+	"net/http"
+
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// delegationChangesPageSize is the default and maxDelegationChanges the largest number of delegation changes returned
+const (
+	delegationChangesPageSize = 100
+	maxDelegationChanges      = 1000
+)
+
+// apiZoneInfrastructureHandler returns the delegation of a zone in the root zone: its nameservers and their addresses
+// zones the root zone imports never delegated are answered with a null parent_data rather than a 404
+func (app *appContext) apiZoneInfrastructureHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	delegation, err := app.ds.GetZoneDelegation(r.Context(), zoneID)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if delegation != nil {
+		delegation.Parent = "."
+	}
+	server.WriteJSON(w, &model.ZoneInfrastructure{Zone: zone, ParentData: delegation})
+}
+
+// apiZoneInfrastructureHistoryHandler returns the ?limit= latest nameservers added to and removed from the delegation of a zone
+func (app *appContext) apiZoneInfrastructureHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxDelegationChanges, delegationChangesPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	changes, err := app.ds.GetZoneDelegationChanges(r.Context(), zoneID, limit)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	server.WriteJSON(w, &model.ZoneInfrastructureHistory{Zone: zone, Changes: changes})
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// keywordGranularities are the periods of a keyword timeseries, by their date_trunc field
+var keywordGranularities = map[string]bool{"day": true, "week": true, "month": true}
+
+// defaultKeywordSpan is how far back a keyword timeseries starts without ?from=
+const defaultKeywordSpan = 365 * 24 * time.Hour
+
+// countKeywords returns the keywords job, counting the tracked keywords in the imports not counted yet
+func (app *appContext) countKeywords(keywords []string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := app.ds.RefreshKeywordCounts(ctx, keywords)
+		if err != nil {
+			return err
+		}
+		logging.Debugf("keywords: counted %d imports", n)
+		return nil
+	}
+}
+
+// apiKeywordTimeseriesHandler returns the number of new domains containing a tracked keyword per ?granularity= period
+// day, week, the default, or month, from ?from= to ?to=, with a breakdown by zone
+// the counts are aggregates and also cover restricted zones, keywords that are not tracked are answered with a 404
+func (app *appContext) apiKeywordTimeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	keyword, jsonErr := params.Path(r, "keyword")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	keyword = strings.ToLower(keyword)
+	if !app.keywords[keyword] {
+		server.WriteJSONError(w, server.ErrKeywordNotTracked)
+		return
+	}
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = "week"
+	}
+	if !keywordGranularities[granularity] {
+		server.WriteJSONError(w, server.NewFieldError("granularity", "must be day, week or month"))
+		return
+	}
+	to := server.Today()
+	if r.URL.Query().Get("to") != "" {
+		to, jsonErr = params.QueryDate(r, "to")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+	}
+	from := server.UTCDay(to.Add(-defaultKeywordSpan))
+	if r.URL.Query().Get("from") != "" {
+		from, jsonErr = params.QueryDate(r, "from")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+	}
+	if from.After(to) {
+		server.WriteJSONError(w, server.NewFieldError("from", "must not be after to"))
+		return
+	}
+
+	periods, err := app.ds.GetKeywordCounts(r.Context(), keyword, granularity, from, to)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if periods == nil {
+		periods = []*model.KeywordPeriod{}
+	}
+	server.WriteJSON(w, &model.KeywordTimeseries{Keyword: keyword, Granularity: granularity, From: model.NewDate(from), To: model.NewDate(to), Periods: periods})
+}
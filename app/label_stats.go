@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// the lower bounds of the buckets of the label histograms, every bucket ends before the next one and the last has no end
+var (
+	labelLengthBuckets = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 31, 41, 51}
+	labelDigitBuckets  = []int{0, 1, 2, 3, 4, 5, 6, 11}
+	labelHyphenBuckets = []int{0, 1, 2, 3, 4}
+)
+
+// countLabels is the label_stats job, it counts the labels of the active domains of every finished import not counted yet,
+// oldest first, each import is a scan of its zone
+func (app *appContext) countLabels(ctx context.Context) error {
+	imports, err := app.ds.GetLabelStatsImports(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ci := range imports {
+		counts, err := app.ds.CountLabels(ctx, ci)
+		if err != nil {
+			return err
+		}
+		err = app.ds.SaveLabelCounts(ctx, ci, counts)
+		if err != nil {
+			return err
+		}
+		logging.Debugf("label_stats: counted the %d domains of import %d of zone %q", counts.Domains, ci.ID, ci.Zone)
+	}
+	return nil
+}
+
+// labelHistogram sums counts, whose element n is the number of labels with n characters, digits or hyphens, into
+// the buckets starting at bounds; values below the first bound are left out
+func labelHistogram(counts []int64, bounds []int) []*model.LabelBucket {
+	buckets := make([]*model.LabelBucket, len(bounds))
+	for i, min := range bounds {
+		bucket := &model.LabelBucket{Min: min}
+		end := len(counts)
+		if i+1 < len(bounds) {
+			max := bounds[i+1] - 1
+			bucket.Max = &max
+			if max+1 < end {
+				end = max + 1
+			}
+		}
+		switch {
+		case bucket.Max == nil:
+			bucket.Bucket = strconv.Itoa(min) + "+"
+		case *bucket.Max == min:
+			bucket.Bucket = strconv.Itoa(min)
+		default:
+			bucket.Bucket = strconv.Itoa(min) + "-" + strconv.Itoa(*bucket.Max)
+		}
+		for value := min; value < end; value++ {
+			bucket.Domains += counts[value]
+		}
+		buckets[i] = bucket
+	}
+	return buckets
+}
+
+// labelStats returns the histograms of the label statistics of an import
+func labelStats(zone string, asOf time.Time, lc *datastore.LabelCounts) *model.LabelStats {
+	ls := &model.LabelStats{
+		Zone:       zone,
+		AsOf:       model.NewDate(asOf),
+		ImportID:   lc.ImportID,
+		ImportDate: model.NewDate(lc.ImportDate),
+		Domains:    lc.Domains,
+		IDNDomains: lc.IDNDomains,
+		Lengths:    labelHistogram(lc.Lengths, labelLengthBuckets),
+		Digits:     labelHistogram(lc.Digits, labelDigitBuckets),
+		Hyphens:    labelHistogram(lc.Hyphens, labelHyphenBuckets),
+		ComputedAt: model.NewTimestamp(lc.ComputedAt),
+	}
+	if lc.Domains > 0 {
+		ls.IDNFraction = float64(lc.IDNDomains) / float64(lc.Domains)
+	}
+	return ls
+}
+
+// apiLabelStatsHandler returns the histograms of the length, digits and hyphens of the labels of the active domains of a
+// zone and the share of internationalized domains, as of its latest import counted by the label_stats job or of the
+// latest at or before ?as_of=; the histograms are aggregates and also served for restricted zones
+func (app *appContext) apiLabelStatsHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	var asOf time.Time
+	if r.URL.Query().Get("as_of") != "" {
+		asOf, jsonErr = params.QueryDate(r, "as_of")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		if asOf.After(server.Today()) {
+			server.WriteJSONError(w, server.NewFieldError("as_of", "must not be in the future"))
+			return
+		}
+	}
+	zoneID, err := app.zoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	counts, first, err := app.ds.GetLabelCounts(r.Context(), zoneID, asOf)
+	if err == datastore.ErrNoResource {
+		jsonErr := *server.ErrNoLabelStats
+		if first != nil {
+			jsonErr.Meta = map[string]string{"first_import": first.Format(model.DateFormat)}
+		}
+		server.WriteJSONError(w, &jsonErr)
+		return
+	}
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, labelStats(zone, asOf, counts))
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+)
+
+func TestLabelHistogram(t *testing.T) {
+	max := func(n int) *int { return &n }
+	tests := []struct {
+		name   string
+		counts []int64
+		bounds []int
+		want   []*model.LabelBucket
+	}{
+		{name: "single values and the last bucket", counts: []int64{0, 5, 7, 2, 1}, bounds: []int{1, 2, 3},
+			want: []*model.LabelBucket{{Bucket: "1", Min: 1, Max: max(1), Domains: 5}, {Bucket: "2", Min: 2, Max: max(2), Domains: 7}, {Bucket: "3+", Min: 3, Domains: 3}}},
+		{name: "ranges", counts: []int64{4, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 9}, bounds: []int{0, 1, 6, 11},
+			want: []*model.LabelBucket{{Bucket: "0", Min: 0, Max: max(0), Domains: 4}, {Bucket: "1-5", Min: 1, Max: max(5), Domains: 5}, {Bucket: "6-10", Min: 6, Max: max(10), Domains: 5}, {Bucket: "11+", Min: 11, Domains: 10}}},
+		// values below the first bound are left out, and the buckets past the counts are empty
+		{name: "below the first bound", counts: []int64{3, 2}, bounds: []int{1, 2, 3},
+			want: []*model.LabelBucket{{Bucket: "1", Min: 1, Max: max(1), Domains: 2}, {Bucket: "2", Min: 2, Max: max(2)}, {Bucket: "3+", Min: 3}}},
+		{name: "no counts", counts: []int64{}, bounds: []int{0, 1},
+			want: []*model.LabelBucket{{Bucket: "0", Min: 0, Max: max(0)}, {Bucket: "1+", Min: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := labelHistogram(tt.counts, tt.bounds)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// labelStatsStore has the label counts of an import of ORG on importDate, and counts the imports of the job
+type labelStatsStore struct {
+	fakeStore
+	importDate time.Time
+	// fails GetLabelCounts, and the first import counted before none was
+	err   error
+	first *time.Time
+	// asOf of the last GetLabelCounts
+	asOf time.Time
+	// imports the job has to count, the one CountLabels fails, and those saved
+	imports  []datastore.CheckImport
+	countErr int64
+	saved    []int64
+}
+
+func (s *labelStatsStore) GetZoneIDs(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{"ORG": 3}, nil
+}
+
+func (s *labelStatsStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	return 0, datastore.ErrNoResource
+}
+
+func (s *labelStatsStore) GetLabelCounts(ctx context.Context, zoneID int64, asOf time.Time) (*datastore.LabelCounts, *time.Time, error) {
+	s.asOf = asOf
+	if s.err != nil {
+		return nil, s.first, s.err
+	}
+	if !asOf.IsZero() && asOf.Before(s.importDate) {
+		return nil, s.first, datastore.ErrNoResource
+	}
+	return &datastore.LabelCounts{
+		ImportID:   42,
+		ImportDate: s.importDate,
+		Domains:    4,
+		IDNDomains: 1,
+		Lengths:    []int64{0, 0, 0, 1, 2, 1},
+		Digits:     []int64{3, 1},
+		Hyphens:    []int64{4},
+	}, nil, nil
+}
+
+func (s *labelStatsStore) GetLabelStatsImports(ctx context.Context) ([]datastore.CheckImport, error) {
+	return s.imports, nil
+}
+
+func (s *labelStatsStore) CountLabels(ctx context.Context, ci datastore.CheckImport) (*datastore.LabelCounts, error) {
+	if ci.ID == s.countErr {
+		return nil, datastore.ErrDatabaseUnavailable
+	}
+	return &datastore.LabelCounts{ImportID: ci.ID}, nil
+}
+
+func (s *labelStatsStore) SaveLabelCounts(ctx context.Context, ci datastore.CheckImport, lc *datastore.LabelCounts) error {
+	s.saved = append(s.saved, lc.ImportID)
+	return nil
+}
+
+func TestLabelStatsHandler(t *testing.T) {
+	importDate := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	first := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(model.DateFormat)
+	tests := []struct {
+		name  string
+		zone  string
+		query string
+		err   error
+		first *time.Time
+		want  int
+		code  string
+		// asOf passed to the datastore, and the first_import meta of the error
+		wantAsOf  string
+		wantFirst string
+	}{
+		{name: "latest import", zone: "org", want: http.StatusOK},
+		{name: "as of a date", zone: "org", query: "?as_of=2024-05-10", want: http.StatusOK, wantAsOf: "2024-05-10"},
+		{name: "invalid zone", zone: "xn--bcher-kvaü", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "invalid date", zone: "org", query: "?as_of=10/05/2024", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "future date", zone: "org", query: "?as_of=" + tomorrow, want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "unknown zone", zone: "xyz", want: http.StatusNotFound, code: "resource_not_found"},
+		{name: "before the first count", zone: "org", query: "?as_of=2024-05-02", first: &first, want: http.StatusNotFound, code: "label_stats_not_found",
+			wantAsOf: "2024-05-02", wantFirst: "2024-05-01"},
+		{name: "never counted", zone: "org", err: datastore.ErrNoResource, want: http.StatusNotFound, code: "label_stats_not_found"},
+		{name: "database unavailable", zone: "org", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &labelStatsStore{importDate: importDate, err: tt.err, first: tt.first}
+			app := &appContext{ds: ds, zoneIDs: refcache.New("test_label_stats_zone_ids", ds.GetZoneIDs)}
+			w := httptest.NewRecorder()
+			app.apiLabelStatsHandler(w, varsRequest("/api/zones/"+tt.zone+"/stats/labels"+tt.query, map[string]string{"zone": tt.zone}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.wantAsOf != "" && ds.asOf.Format(model.DateFormat) != tt.wantAsOf {
+				t.Errorf("got as_of %s, want %s", ds.asOf, tt.wantAsOf)
+			}
+			if tt.code != "" {
+				var body model.JSONErrors
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if len(body.Errors) != 1 || body.Errors[0].ID != tt.code || body.Errors[0].Meta["first_import"] != tt.wantFirst {
+					t.Errorf("got %s, want the error %s with first_import %q", w.Body, tt.code, tt.wantFirst)
+				}
+				return
+			}
+			var resp struct{ Data model.LabelStats }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := resp.Data
+			if got.Zone != "ORG" || got.ImportID != 42 || got.Domains != 4 || got.IDNFraction != 0.25 {
+				t.Errorf("got %s, want the counts of import 42 of ORG", w.Body)
+			}
+			if len(got.Lengths) != len(labelLengthBuckets) || got.Lengths[2].Domains != 1 || got.Lengths[3].Domains != 2 {
+				t.Errorf("got lengths %s", w.Body)
+			}
+			if wantLink := "/zones/ORG/stats/labels" + tt.query; got.Link != wantLink {
+				t.Errorf("got link %q, want %q", got.Link, wantLink)
+			}
+		})
+	}
+}
+
+// TestCountLabels counts the imports oldest first and stops at the first failure, those counted are kept
+func TestCountLabels(t *testing.T) {
+	imports := []datastore.CheckImport{{ID: 1, Zone: "ORG"}, {ID: 2, Zone: "COM"}, {ID: 3, Zone: "ORG"}}
+	tests := []struct {
+		name      string
+		countErr  int64
+		wantSaved []int64
+		wantErr   error
+	}{
+		{name: "every import", wantSaved: []int64{1, 2, 3}},
+		{name: "count fails", countErr: 2, wantSaved: []int64{1}, wantErr: datastore.ErrDatabaseUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &labelStatsStore{imports: imports, countErr: tt.countErr}
+			app := &appContext{ds: ds}
+			if err := app.countLabels(context.Background()); err != tt.wantErr {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ds.saved, tt.wantSaved) {
+				t.Errorf("saved %v, want %v", ds.saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+// TestLabelStatsSuspect flags the label statistics derived from suspect imports
+func TestLabelStatsSuspect(t *testing.T) {
+	if !flagsSuspect("/zones/{zone}/stats/labels") {
+		t.Error("the label statistics are not flagged")
+	}
+	if !strings.Contains(strings.Join(importJobs, " "), "label_stats") {
+		t.Errorf("import notifications do not start label_stats: %q", importJobs)
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// apiLabelHandler looks up a second-level label in every zone, ex: example is example.com, example.net...
+// zones the request may not read only say whether the domain exists, ?format= is json or csv
+// zones whose lookup failed are left out, named in failed_zones or for csv in the X-Failed-Zones header
+func (app *appContext) apiLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, jsonErr := params.Domain(r, "label")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if strings.Contains(label, ".") {
+		server.WriteJSONError(w, server.NewFieldError("label", "must be a single label"))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv":
+	default:
+		server.WriteJSONError(w, server.NewFieldError("format", "must be json or csv"))
+		return
+	}
+
+	zones, failed, err := app.ds.GetLabelZones(r.Context(), label)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data := &model.LabelZones{Label: label, Zones: zones, Partial: len(failed) > 0, FailedZones: failed}
+	for _, lz := range zones {
+		if lz.Exists {
+			data.Found++
+		}
+		if app.zones.Check(r, lz.Domain) != nil {
+			lz.Restricted = true
+			lz.FirstSeen, lz.LastSeen, lz.NameServers = model.Date{}, model.Date{}, nil
+		}
+	}
+
+	if format == "csv" {
+		// writes to the buffer do not fail
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		cw.Write([]string{"zone", "domain", "exists", "restricted", "firstseen", "lastseen", "nameservers"})
+		for _, lz := range zones {
+			cw.Write([]string{lz.Zone, lz.Domain, strconv.FormatBool(lz.Exists), strconv.FormatBool(lz.Restricted),
+				csvDate(lz.FirstSeen), csvDate(lz.LastSeen), strings.Join(lz.NameServers, " ")})
+		}
+		cw.Flush()
+		server.WriteBodyWithMeta(w, "text/csv; charset=utf-8", buf.Bytes(), server.Count(len(zones)), server.PartialResult(failed))
+		return
+	}
+	server.WriteJSONWithMeta(w, data, server.Count(len(zones)), server.PartialResult(failed))
+}
+
+// csvDate formats a YYYY-MM-DD date for a CSV field, empty when unset
+func csvDate(d model.Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(model.DateFormat)
+}
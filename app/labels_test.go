@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// labelStore finds its label in every zone but those of failed, or fails with err
+type labelStore struct {
+	fakeStore
+	zones, failed []string
+	err           error
+	queried       string
+}
+
+func (s *labelStore) GetLabelZones(ctx context.Context, label string) ([]*model.LabelZone, []string, error) {
+	s.queried = label
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	var zones []*model.LabelZone
+	for _, zone := range s.zones {
+		zones = append(zones, &model.LabelZone{
+			Zone: zone, Domain: label + "." + zone, Exists: zone != "org",
+			FirstSeen: model.NewDate(time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC)), NameServers: []string{"ns1.example.net"},
+		})
+	}
+	return zones, s.failed, nil
+}
+
+func TestLabelHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		query  string
+		failed []string
+		err    error
+		want   int
+		code   string
+		// zones in the response, and the number the label was found in
+		wantZones []string
+		wantFound int
+	}{
+		{name: "every zone", label: "example", want: http.StatusOK, wantZones: []string{"com", "net", "org"}, wantFound: 2},
+		{name: "cleaned label", label: "Example", want: http.StatusOK, wantZones: []string{"com", "net", "org"}, wantFound: 2},
+		{name: "failed zones", label: "example", failed: []string{"info", "xyz"}, want: http.StatusOK, wantZones: []string{"com", "net", "org"}, wantFound: 2},
+		{name: "csv", label: "example", query: "?format=csv", failed: []string{"info"}, want: http.StatusOK},
+		{name: "two labels", label: "example.com", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid label", label: "xn--bcher-kvaü", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "unknown format", label: "example", query: "?format=xml", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "every zone failed", label: "example", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &labelStore{zones: []string{"com", "net", "org"}, failed: tt.failed, err: tt.err}
+			app := &appContext{ds: ds, zones: testZoneAccess(t, server.RestrictedZone{Zone: "NET"})}
+			w := httptest.NewRecorder()
+			app.apiLabelHandler(w, varsRequest("/api/label/x"+tt.query, map[string]string{"label": tt.label}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" {
+				if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("got %s, want the error %s", w.Body, tt.code)
+				}
+				return
+			}
+			if tt.query == "?format=csv" {
+				if got := w.Header().Get("X-Failed-Zones"); got != strings.Join(tt.failed, " ") {
+					t.Errorf("got X-Failed-Zones %q, want %q", got, strings.Join(tt.failed, " "))
+				}
+				lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+				want := []string{
+					"zone,domain,exists,restricted,firstseen,lastseen,nameservers",
+					"com,EXAMPLE.com,true,false,2023-07-04,,ns1.example.net",
+					"net,EXAMPLE.net,true,true,,,",
+					"org,EXAMPLE.org,false,false,2023-07-04,,ns1.example.net",
+				}
+				if !reflect.DeepEqual(lines, want) {
+					t.Errorf("got csv %q, want %q", lines, want)
+				}
+				return
+			}
+			var resp struct{ Data model.LabelZones }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			// labels are cleaned like domains
+			if resp.Data.Label != "EXAMPLE" || ds.queried != "EXAMPLE" {
+				t.Errorf("got label %q, queried %q, want EXAMPLE", resp.Data.Label, ds.queried)
+			}
+			var zones []string
+			for _, lz := range resp.Data.Zones {
+				zones = append(zones, lz.Zone)
+				// the restricted zone only says whether the domain exists
+				if restricted := lz.Zone == "net"; lz.Restricted != restricted || restricted && (!lz.FirstSeen.IsZero() || lz.NameServers != nil) {
+					t.Errorf("zone %s: got %+v", lz.Zone, lz)
+				}
+			}
+			if !reflect.DeepEqual(zones, tt.wantZones) || resp.Data.Found != tt.wantFound {
+				t.Errorf("got zones %v found in %d, want %v found in %d", zones, resp.Data.Found, tt.wantZones, tt.wantFound)
+			}
+			if resp.Data.Partial != (len(tt.failed) > 0) || !reflect.DeepEqual(resp.Data.FailedZones, tt.failed) {
+				t.Errorf("got partial %t failed zones %v, want %v", resp.Data.Partial, resp.Data.FailedZones, tt.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// minLifetimeCohortDays is how many days ago a cohort month must have ended, younger cohorts have not had time to leave
+const minLifetimeCohortDays = 30
+
+// lifetimeKey identifies a precomputed lifetime histogram, zoneID 0 is all zones
+type lifetimeKey struct {
+	zoneID int64
+	cohort string
+}
+
+// lifetimeStats are the lifetime histograms precomputed by the lifetimes job
+type lifetimeStats struct {
+	computedAt time.Time
+	// the latest cohort computed, later cohorts are queried
+	latest     time.Time
+	histograms map[lifetimeKey]*model.DomainLifetimes
+}
+
+// lifetimeCohortComplete returns true if the cohort month ended at least minLifetimeCohortDays before today
+func lifetimeCohortComplete(cohort, today time.Time) bool {
+	return !cohort.AddDate(0, 1, -1).After(today.AddDate(0, 0, -minLifetimeCohortDays))
+}
+
+// latestLifetimeCohort returns the latest cohort month that is complete today
+func latestLifetimeCohort(today time.Time) time.Time {
+	cohort := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !lifetimeCohortComplete(cohort, today) {
+		cohort = cohort.AddDate(0, -1, 0)
+	}
+	return cohort
+}
+
+// emptyLifetimes returns the histogram of a cohort without domains
+func emptyLifetimes(cohort, computedAt time.Time) *model.DomainLifetimes {
+	dl := &model.DomainLifetimes{Cohort: cohort.Format("2006-01"), ComputedAt: model.NewTimestamp(computedAt)}
+	dl.Buckets = make([]*model.LifetimeBucket, len(datastore.LifetimeBuckets))
+	for i, bucket := range datastore.LifetimeBuckets {
+		dl.Buckets[i] = &model.LifetimeBucket{Bucket: bucket}
+	}
+	return dl
+}
+
+// precomputeLifetimes is the lifetimes job, it stores the histograms of every complete cohort of every zone
+func (app *appContext) precomputeLifetimes(ctx context.Context) error {
+	computedAt := time.Now().UTC()
+	latest := latestLifetimeCohort(computedAt)
+	histograms, err := app.ds.GetDomainLifetimes(ctx, 0, time.Time{}, latest)
+	if err != nil {
+		return err
+	}
+	stats := &lifetimeStats{
+		computedAt: computedAt,
+		latest:     latest,
+		histograms: make(map[lifetimeKey]*model.DomainLifetimes, len(histograms)),
+	}
+	for _, dl := range histograms {
+		stats.histograms[lifetimeKey{zoneID: dl.ZoneID, cohort: dl.Cohort}] = dl
+	}
+	app.lifetimes.Store(stats)
+	return nil
+}
+
+// domainLifetimes returns a copy of the precomputed histogram of the cohort, or queries it when the job has not computed it
+func (app *appContext) domainLifetimes(ctx context.Context, zoneID int64, cohort time.Time) (*model.DomainLifetimes, error) {
+	if stats, ok := app.lifetimes.Load().(*lifetimeStats); ok && !cohort.After(stats.latest) {
+		if dl, ok := stats.histograms[lifetimeKey{zoneID: zoneID, cohort: cohort.Format("2006-01")}]; ok {
+			histogram := *dl
+			return &histogram, nil
+		}
+		return emptyLifetimes(cohort, stats.computedAt), nil
+	}
+	histograms, err := app.ds.GetDomainLifetimes(ctx, zoneID, cohort, cohort)
+	if err != nil {
+		return nil, err
+	}
+	for _, dl := range histograms {
+		if dl.ZoneID == zoneID {
+			return dl, nil
+		}
+	}
+	return emptyLifetimes(cohort, time.Now().UTC()), nil
+}
+
+// apiLifetimesHandler returns the histogram of how long the domains first seen in the ?cohort= month stayed in their zone
+// ?zone= only counts the domains of a zone, the histograms are aggregates and also served for restricted zones
+func (app *appContext) apiLifetimesHandler(w http.ResponseWriter, r *http.Request) {
+	cohort, jsonErr := params.QueryMonth(r, "cohort")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if !lifetimeCohortComplete(cohort, time.Now().UTC()) {
+		server.WriteJSONError(w, server.NewFieldError("cohort", fmt.Sprintf("must be a month that ended at least %d days ago", minLifetimeCohortDays)))
+		return
+	}
+	var zone string
+	var zoneID int64
+	if r.URL.Query().Get("zone") != "" {
+		zone, jsonErr = params.QueryDomain(r, "zone")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		var err error
+		zoneID, err = app.zoneID(r.Context(), zone)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+	}
+
+	data, err := app.domainLifetimes(r.Context(), zoneID, cohort)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data.Zone = zone
+
+	server.WriteJSON(w, data)
+}
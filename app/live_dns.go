@@ -0,0 +1,204 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/reqstats"
+	"dnscoffee/server"
+)
+
+// liveDNSStats counts the live NS queries and cache lookups
+var liveDNSStats = expvar.NewMap("live_dns")
+
+// nsLookuper looks up the NS records of a name, *net.Resolver is one, tests can give a fake
+type nsLookuper interface {
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
+
+// newResolver returns a resolver sending its queries to the ip:port addr, or the system resolver when addr is empty
+func newResolver(addr string) *net.Resolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// liveDNS queries the live NS sets of domains, successful answers are cached least recently used first
+type liveDNS struct {
+	lookup  nsLookuper
+	timeout time.Duration
+	ttls    *CacheTTLs
+	size    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+// liveNS is a cached live NS set
+type liveNS struct {
+	domain      string
+	nameservers []string
+	checkedAt   time.Time
+}
+
+func newLiveDNS(lookup nsLookuper, timeout time.Duration, ttls *CacheTTLs, size int) *liveDNS {
+	return &liveDNS{
+		lookup:  lookup,
+		timeout: timeout,
+		ttls:    ttls,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (l *liveDNS) get(domain string) *liveNS {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	elem, ok := l.entries[domain]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*liveNS)
+	if time.Since(entry.checkedAt) > l.ttls.Load().LiveDNS {
+		l.order.Remove(elem)
+		delete(l.entries, domain)
+		return nil
+	}
+	l.order.MoveToFront(elem)
+	return entry
+}
+
+func (l *liveDNS) put(entry *liveNS) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.entries[entry.domain]; ok {
+		elem.Value = entry
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.entries[entry.domain] = l.order.PushFront(entry)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*liveNS).domain)
+	}
+}
+
+// flush removes every cached NS set
+func (l *liveDNS) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = make(map[string]*list.Element)
+	l.order.Init()
+}
+
+// nameservers returns the sorted, lower cased live nameservers of domain, queried within the timeout
+// failed queries are not cached
+func (l *liveDNS) nameservers(ctx context.Context, domain string) (*liveNS, error) {
+	entry := l.get(domain)
+	reqstats.FromContext(ctx).Cache(entry != nil)
+	if entry != nil {
+		liveDNSStats.Add("cache_hits", 1)
+		return entry, nil
+	}
+	liveDNSStats.Add("queries", 1)
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	records, err := l.lookup.LookupNS(ctx, domain+".")
+	if err != nil {
+		liveDNSStats.Add("errors", 1)
+		return nil, err
+	}
+	names := make([]string, len(records))
+	for i, ns := range records {
+		names[i] = canonicalName(ns.Host)
+	}
+	sort.Strings(names)
+	entry = &liveNS{domain: domain, nameservers: names, checkedAt: time.Now().UTC()}
+	if l.size > 0 {
+		l.put(entry)
+	}
+	return entry, nil
+}
+
+// canonicalName lower cases a name and removes its trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// nameServerDiff returns the names of live missing from zone and those of zone missing from live
+func nameServerDiff(zone, live []string) (added, removed []string) {
+	inZone := make(map[string]bool, len(zone))
+	for _, name := range zone {
+		inZone[name] = true
+	}
+	inLive := make(map[string]bool, len(live))
+	for _, name := range live {
+		inLive[name] = true
+		if !inZone[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range zone {
+		if !inLive[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// apiDomainLiveHandler compares the active nameservers of a domain in the zone files with a live NS query
+// when the query fails or times out the zone file nameservers are returned with the error in live_error
+func (app *appContext) apiDomainLiveHandler(w http.ResponseWriter, r *http.Request) {
+	domain, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if app.zoneForbidden(w, r, domain) {
+		return
+	}
+	d, err := app.ds.GetDomain(r.Context(), domain)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data := &model.LiveDomain{Name: d.Name, ZoneNameServers: make([]string, 0, len(d.NameServers))}
+	for _, ns := range d.NameServers {
+		data.ZoneNameServers = append(data.ZoneNameServers, canonicalName(ns.Name))
+	}
+	sort.Strings(data.ZoneNameServers)
+
+	live, err := app.liveDNS.nameservers(r.Context(), domain)
+	if err != nil {
+		data.LiveError = err.Error()
+		data.CheckedAt = model.Now()
+		server.WriteJSON(w, data)
+		return
+	}
+	data.LiveNameServers = live.nameservers
+	data.CheckedAt = model.NewTimestamp(live.checkedAt)
+	data.Added, data.Removed = nameServerDiff(data.ZoneNameServers, data.LiveNameServers)
+	matches := len(data.Added) == 0 && len(data.Removed) == 0
+	data.Matches = &matches
+
+	server.WriteJSON(w, data)
+}
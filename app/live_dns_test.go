@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// fakeResolver answers NS queries from its map, or fails with err
+type fakeResolver struct {
+	ns      map[string][]string
+	err     error
+	queries int
+}
+
+func (f *fakeResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	f.queries++
+	if f.err != nil {
+		return nil, f.err
+	}
+	var records []*net.NS
+	for _, host := range f.ns[name] {
+		records = append(records, &net.NS{Host: host})
+	}
+	return records, nil
+}
+
+// liveDNSApp returns an app comparing the nameservers of the zone files of example.org with resolver
+func liveDNSApp(t *testing.T, resolver nsLookuper, err error) (*appContext, *domainStore) {
+	ds := &domainStore{err: err, domains: map[string]*model.Domain{
+		"EXAMPLE.ORG": {Name: "EXAMPLE.ORG", NameServers: []*model.NameServer{{Name: "NS2.EXAMPLE.NET"}, {Name: "NS1.EXAMPLE.NET"}}},
+	}}
+	ttls := NewCacheTTLs(TTLs{LiveDNS: time.Minute})
+	return &appContext{
+		ds:      ds,
+		zones:   testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+		liveDNS: newLiveDNS(resolver, time.Second, ttls, 10),
+	}, ds
+}
+
+func TestDomainLiveHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   string
+		live     []string
+		lookup   error
+		dsErr    error
+		want     int
+		code     string
+		wantLive *model.LiveDomain
+	}{
+		{
+			name: "matches", domain: "example.org", live: []string{"ns1.example.net.", "NS2.example.net."}, want: http.StatusOK,
+			wantLive: &model.LiveDomain{Name: "EXAMPLE.ORG", ZoneNameServers: []string{"ns1.example.net", "ns2.example.net"}, LiveNameServers: []string{"ns1.example.net", "ns2.example.net"}},
+		},
+		{
+			name: "differs", domain: "Example.org", live: []string{"ns1.example.net.", "ns3.example.net."}, want: http.StatusOK,
+			wantLive: &model.LiveDomain{Name: "EXAMPLE.ORG", ZoneNameServers: []string{"ns1.example.net", "ns2.example.net"}, LiveNameServers: []string{"ns1.example.net", "ns3.example.net"}, Added: []string{"ns3.example.net"}, Removed: []string{"ns2.example.net"}},
+		},
+		{
+			name: "lookup fails", domain: "example.org", lookup: errors.New("i/o timeout"), want: http.StatusOK,
+			wantLive: &model.LiveDomain{Name: "EXAMPLE.ORG", ZoneNameServers: []string{"ns1.example.net", "ns2.example.net"}, LiveError: "i/o timeout"},
+		},
+		{name: "not found", domain: "missing.org", want: http.StatusNotFound, code: "resource_not_found"},
+		{name: "invalid name", domain: "exa\x00mple.org", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "restricted zone", domain: "example.com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "database unavailable", domain: "example.org", dsErr: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeResolver{ns: map[string][]string{"EXAMPLE.ORG.": tt.live}, err: tt.lookup}
+			app, _ := liveDNSApp(t, resolver, tt.dsErr)
+			w := httptest.NewRecorder()
+			app.apiDomainLiveHandler(w, varsRequest("/api/domains/"+url.PathEscape(tt.domain)+"/live", map[string]string{"domain": tt.domain}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" {
+				if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("got %s, want the error %s", w.Body, tt.code)
+				}
+				return
+			}
+			var resp struct{ Data model.LiveDomain }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := resp.Data
+			if got.CheckedAt.IsZero() {
+				t.Error("no checked_at")
+			}
+			matches := tt.lookup == nil && len(tt.wantLive.Added) == 0 && len(tt.wantLive.Removed) == 0
+			if (got.Matches != nil && *got.Matches) != matches || (got.Matches == nil) != (tt.lookup != nil) {
+				t.Errorf("got matches %v, want %t", got.Matches, matches)
+			}
+			got.Metadata, got.Matches, got.CheckedAt = model.Metadata{}, nil, model.Timestamp{}
+			if !reflect.DeepEqual(&got, tt.wantLive) {
+				t.Errorf("got %+v, want %+v", got, tt.wantLive)
+			}
+		})
+	}
+}
+
+// TestLiveDNSCache answers the repeated lookups of a domain from the cache until the TTL, failed lookups are not cached
+func TestLiveDNSCache(t *testing.T) {
+	resolver := &fakeResolver{ns: map[string][]string{"EXAMPLE.ORG.": {"ns1.example.net."}}}
+	app, _ := liveDNSApp(t, resolver, nil)
+	lookup := func() int {
+		w := httptest.NewRecorder()
+		app.apiDomainLiveHandler(w, varsRequest("/api/domains/example.org/live", map[string]string{"domain": "example.org"}))
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := lookup(); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+	}
+	if resolver.queries != 1 {
+		t.Errorf("sent %d queries, want 1", resolver.queries)
+	}
+
+	app.liveDNS.ttls.Store(TTLs{LiveDNS: 0})
+	lookup()
+	if resolver.queries != 2 {
+		t.Errorf("sent %d queries after the TTL, want 2", resolver.queries)
+	}
+
+	app.liveDNS.flush()
+	app.liveDNS.ttls.Store(TTLs{LiveDNS: time.Minute})
+	resolver.err = errors.New("SERVFAIL")
+	lookup()
+	lookup()
+	if resolver.queries != 4 {
+		t.Errorf("sent %d queries with failures, want 4", resolver.queries)
+	}
+}
+
+// TestLiveDNSRouteRateLimit limits the live route to its own quota
+func TestLiveDNSRouteRateLimit(t *testing.T) {
+	s, err := server.New([]string{"127.0.0.1:0"}, server.APIConfig{APITimeout: 5, APIRequestsPerMinute: 60, APIRequestsBurst: 10, APIMaxRequestHistory: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, _ := liveDNSApp(t, &fakeResolver{}, nil)
+	handler := s.RouteRateLimit("live_dns_test", 1, 0, app.apiDomainLiveHandler)
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := varsRequest("/api/domains/example.org/live", map[string]string{"domain": "example.org"})
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != want {
+			t.Errorf("request %d: got status %d, want %d", i, w.Code, want)
+		}
+	}
+}
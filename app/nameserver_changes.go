@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/cursor"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+const (
+	// maxNameServerChangesDays is the most days in the range of a nameserver's changes, and
+	// defaultNameServerChangesDays those of the range without ?start=
+	maxNameServerChangesDays     = 90
+	defaultNameServerChangesDays = 30
+
+	// nameServerChangesPageSize is the default and maxNameServerChangesPageSize the largest number of changes on a page
+	nameServerChangesPageSize    = 1000
+	maxNameServerChangesPageSize = 10000
+)
+
+// nameServerChangesRange returns the start and end query dates, end defaults to today and start to the
+// defaultNameServerChangesDays ending with it
+func nameServerChangesRange(r *http.Request) (time.Time, time.Time, *model.JSONError) {
+	end := server.Today()
+	if r.URL.Query().Get("end") != "" {
+		var jsonErr *model.JSONError
+		end, jsonErr = params.QueryDate(r, "end")
+		if jsonErr != nil {
+			return time.Time{}, time.Time{}, jsonErr
+		}
+	}
+	start := end.AddDate(0, 0, 1-defaultNameServerChangesDays)
+	if r.URL.Query().Get("start") != "" {
+		var jsonErr *model.JSONError
+		start, jsonErr = params.QueryDate(r, "start")
+		if jsonErr != nil {
+			return time.Time{}, time.Time{}, jsonErr
+		}
+	}
+	if start.After(end) {
+		return time.Time{}, time.Time{}, server.NewFieldError("start", "must not be after end")
+	}
+	if end.Sub(start) >= maxNameServerChangesDays*24*time.Hour {
+		return time.Time{}, time.Time{}, server.NewFieldError("start", "must be less than 90 days before end")
+	}
+	return start, end, nil
+}
+
+// apiNameServerChangesHandler returns a page of the domains that started or stopped delegating to the nameserver
+// from ?start= to ?end=, with the other nameservers of each domain on the other side of the change, so that a provider
+// can tell where its customers came from or went; ?limit= sets the page size, ?cursor= continues from the next_cursor
+// of the previous page and ?format= is json or csv
+// the domains of restricted zones the request may not read are left out of the pages but counted
+func (app *appContext) apiNameServerChangesHandler(w http.ResponseWriter, r *http.Request) {
+	nameserver, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	start, end, jsonErr := nameServerChangesRange(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxNameServerChangesPageSize, nameServerChangesPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv":
+	default:
+		server.WriteJSONError(w, server.NewFieldError("format", "must be json or csv"))
+		return
+	}
+	cursorFilter := cursor.Filter("nameserver_changes", nameserver, start.Format(model.DateFormat), end.Format(model.DateFormat))
+	var after *model.NameServerChange
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		last, err := app.cursors.Decode(token, "change", cursorFilter)
+		var date time.Time
+		if err == nil && len(last) == 3 {
+			date, err = time.Parse(model.DateFormat, last[0])
+		}
+		if err != nil || len(last) != 3 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+		after = &model.NameServerChange{Date: model.NewDate(date), Domain: last[1], Change: last[2]}
+	}
+
+	nameserverID, err := app.ds.GetNameServerID(r.Context(), nameserver)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data := &model.NameServerChanges{NameServer: nameserver, Start: model.NewDate(start), End: model.NewDate(end)}
+	data.Gained, data.Lost, err = app.ds.CountNameServerChanges(r.Context(), nameserverID, start, end)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	changes, err := app.ds.GetNameServerChanges(r.Context(), nameserverID, start, end, after, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		last := changes[limit-1]
+		data.NextCursor = app.cursors.Encode("change", []string{last.Date.Format(model.DateFormat), last.Domain, last.Change}, cursorFilter)
+	}
+	// the cursor follows the last row read, a page may be short once filtered
+	data.Changes = make([]*model.NameServerChange, 0, len(changes))
+	for _, c := range changes {
+		if app.zones.Check(r, c.Domain) == nil {
+			data.Changes = append(data.Changes, c)
+		}
+	}
+
+	meta := []server.MetaOption{server.Count(len(data.Changes)), server.NextCursor(data.NextCursor)}
+	if format == "json" {
+		server.WriteJSONWithMeta(w, data, meta...)
+		return
+	}
+	w.Header().Set("X-Changes-Gained", strconv.FormatInt(data.Gained, 10))
+	w.Header().Set("X-Changes-Lost", strconv.FormatInt(data.Lost, 10))
+	// the page is encoded into a buffer, writes to it do not fail
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"date", "change", "domain", "nameservers"})
+	for _, c := range data.Changes {
+		cw.Write([]string{c.Date.Format(model.DateFormat), c.Change, c.Domain, strings.Join(c.NameServers, " ")})
+	}
+	cw.Flush()
+	server.WriteBodyWithMeta(w, "text/csv; charset=utf-8", buf.Bytes(), meta...)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// nameServerSetPageSize is the default and maxNameServerSetPageSize the largest number of domains on a page of a nameserver set
+const (
+	nameServerSetPageSize    = 100
+	maxNameServerSetPageSize = 1000
+)
+
+// nameServerSetFingerprint returns the lower cased fingerprint path parameter
+func nameServerSetFingerprint(r *http.Request, name string) (string, *model.JSONError) {
+	fingerprint, jsonErr := params.Path(r, name)
+	if jsonErr != nil {
+		return "", jsonErr
+	}
+	fingerprint = strings.ToLower(fingerprint)
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != datastore.NameServerSetFingerprintLength {
+		return "", server.NewFieldError(name, fmt.Sprintf("must be a fingerprint of %d hex digits", datastore.NameServerSetFingerprintLength))
+	}
+	return fingerprint, nil
+}
+
+// apiNameServerSetHandler returns the nameservers of a set and a page of the active domains using exactly those nameservers
+// ?limit= sets the page size and ?cursor= continues from the next_cursor of the previous page, ?min_stability= only
+// lists the domains the stability job scored at least that stable
+// sets are indexed by the nssets job, a set is not found until it ran after a domain started using it
+func (app *appContext) apiNameServerSetHandler(w http.ResponseWriter, r *http.Request) {
+	fingerprint, jsonErr := nameServerSetFingerprint(r, "fingerprint")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxNameServerSetPageSize, nameServerSetPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	stabilities, jsonErr := minStability(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	filter := cursor.Filter("nsset", fingerprint)
+	if stabilities != nil {
+		filter = cursor.Filter("nsset", fingerprint, "min_stability", stabilities[0])
+	}
+	var afterID int64
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		last, err := app.cursors.Decode(token, "domain_id", filter)
+		if err == nil && len(last) == 1 {
+			afterID, err = strconv.ParseInt(last[0], 10, 64)
+		}
+		if err != nil || len(last) != 1 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+	}
+
+	data, err := app.ds.GetNameServerSet(r.Context(), fingerprint)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	domains, err := app.ds.GetNameServerSetDomains(r.Context(), fingerprint, stabilities, afterID, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if len(domains) > limit {
+		domains = domains[:limit]
+		data.NextCursor = app.cursors.Encode("domain_id", []string{strconv.FormatInt(domains[limit-1].ID, 10)}, filter)
+	}
+	data.MinStability = r.URL.Query().Get("min_stability")
+	data.Domains = app.visibleDomains(r, domains)
+
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Domains)), server.NextCursor(data.NextCursor))
+}
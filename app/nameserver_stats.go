@@ -0,0 +1,139 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// maxNameServerStatsDays is the most dates, and the default number of dates, in a nameserver's domain count history
+const maxNameServerStatsDays = 365
+
+// nameServerStatsRange returns the from and to query dates, to defaults to today and from to the maxNameServerStatsDays before it
+func nameServerStatsRange(r *http.Request) (time.Time, time.Time, *model.JSONError) {
+	to := server.Today()
+	if r.URL.Query().Get("to") != "" {
+		var jsonErr *model.JSONError
+		to, jsonErr = params.QueryDate(r, "to")
+		if jsonErr != nil {
+			return time.Time{}, time.Time{}, jsonErr
+		}
+	}
+	from := to.AddDate(0, 0, 1-maxNameServerStatsDays)
+	if r.URL.Query().Get("from") != "" {
+		var jsonErr *model.JSONError
+		from, jsonErr = params.QueryDate(r, "from")
+		if jsonErr != nil {
+			return time.Time{}, time.Time{}, jsonErr
+		}
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, server.NewFieldError("from", "must not be after to")
+	}
+	if to.Sub(from) >= maxNameServerStatsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, server.NewFieldError("from", "must be less than 365 days before to")
+	}
+	return from, to, nil
+}
+
+// nameServerStatsTTL is how long a nameserver's domain count history may be cached
+// counts for past dates never change, a range including today is still being imported
+func (app *appContext) nameServerStatsTTL(r *http.Request) time.Duration {
+	_, to, jsonErr := nameServerStatsRange(r)
+	if jsonErr != nil {
+		return -1
+	}
+	if to.Before(server.Today()) {
+		return 0
+	}
+	ttl := app.ttls.Load().Feed
+	if ttl <= 0 {
+		return -1
+	}
+	return ttl
+}
+
+// apiNameServerStatsHandler returns the number of domains delegated to the nameserver on every date of the range
+// ?from= and ?to= are YYYY-MM-DD dates, ?zone= only counts the domains of a zone
+func (app *appContext) apiNameServerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	nameserver, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	from, to, jsonErr := nameServerStatsRange(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data := &model.NameServerStats{NameServer: nameserver, From: model.NewDate(from), To: model.NewDate(to)}
+	var zoneID int64
+	if r.URL.Query().Get("zone") != "" {
+		data.Zone, jsonErr = params.QueryDomain(r, "zone")
+		if invalidParam(w, jsonErr) {
+			return
+		}
+		var err error
+		zoneID, err = app.zoneID(r.Context(), data.Zone)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+	}
+
+	nameserverID, err := app.ds.GetNameServerID(r.Context(), nameserver)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data.History, err = app.ds.GetNameServerDomainCounts(r.Context(), nameserverID, zoneID, from, to)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, data)
+}
+
+// nameServerSuffixPageSize is the default and maxNameServerSuffixPageSize the largest number of nameservers in a suffix breakdown
+const (
+	nameServerSuffixPageSize    = 100
+	maxNameServerSuffixPageSize = 1000
+)
+
+// nameServerSuffixTTL is how long the domain counts of a nameserver suffix may be cached, they change with every import
+func (app *appContext) nameServerSuffixTTL(r *http.Request) time.Duration {
+	ttl := app.ttls.Load().Feed
+	if ttl <= 0 {
+		return -1
+	}
+	return ttl
+}
+
+// apiNameServerSuffixStatsHandler counts the domains using the nameservers named by or below a suffix on a label boundary
+// the suffix needs at least two labels, ?limit= sets the number of nameservers in the breakdown
+func (app *appContext) apiNameServerSuffixStatsHandler(w http.ResponseWriter, r *http.Request) {
+	suffix, jsonErr := params.Domain(r, "suffix")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	suffix = strings.TrimSuffix(suffix, ".")
+	if !strings.Contains(suffix, ".") || strings.HasPrefix(suffix, ".") || strings.Contains(suffix, "..") {
+		server.WriteJSONError(w, server.NewFieldError("suffix", "must have at least two labels, ex: dns.example.net"))
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxNameServerSuffixPageSize, nameServerSuffixPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+
+	data, err := app.ds.GetNameServerSuffixStats(r.Context(), suffix, limit)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, data)
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+)
+
+// negativeCacheStats counts the exact-match lookups answered with a 404 by the negative cache without a query (hits),
+// those the filter could not rule out (misses) and those looked up while it was not built (bypasses)
+var negativeCacheStats = expvar.NewMap("negative_cache")
+
+// negativeCacheNames is the number of names in the filter last built
+var negativeCacheNames = new(expvar.Int)
+
+// bloomHashes is the number of bits set per name, the best for about 10 bits per name and 1% false positives
+const bloomHashes = 7
+
+// name kinds, the domains and nameservers share the filter and the misses
+const (
+	domainName     = 'd'
+	nameServerName = 'n'
+)
+
+// bloomFilter is a set of names that can only answer "maybe present" or "never added"
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(bytes int64) *bloomFilter {
+	words := bytes / 8
+	if words < 1 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words)}
+}
+
+// positions calls fn with the bits of key, double hashing the two halves of its FNV-1a hash
+func (bf *bloomFilter) positions(key string, fn func(word int, mask uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(bf.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (bf *bloomFilter) add(key string) {
+	bf.positions(key, func(word int, mask uint64) bool {
+		bf.bits[word] |= mask
+		return true
+	})
+}
+
+// mayContain returns false only if key was never added
+func (bf *bloomFilter) mayContain(key string) bool {
+	return bf.positions(key, func(word int, mask uint64) bool {
+		return bf.bits[word]&mask != 0
+	})
+}
+
+// negativeCache answers the exact-match lookups of names that were never seen without querying the database
+// it holds a bloom filter of every domain and nameserver name, built by the negative_cache job, and the recent misses
+// a name the filter may contain is always looked up, so that a false positive costs a query but never hides a record
+// imports make it stale: import notifications flush it and the job drops and rebuilds it when the data version changes
+type negativeCache struct {
+	ds *datastore.DataStore
+	// filter size in bytes
+	bytes int64
+	// number of recent misses kept
+	size int
+
+	mu sync.Mutex
+	// nil until built and after a flush
+	filter *bloomFilter
+	// data version of the filter and the misses
+	version int64
+	// incremented by every flush, misses and filters read before a flush are thrown away
+	generation uint64
+	misses     map[string]*list.Element
+	// most recent first
+	order *list.List
+}
+
+func newNegativeCache(ds *datastore.DataStore, bytes int64, size int) *negativeCache {
+	negativeCacheStats.Set("names", negativeCacheNames)
+	return &negativeCache{
+		ds:     ds,
+		bytes:  bytes,
+		size:   size,
+		misses: make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// absent returns true if the name of kind was never seen and can be answered with a 404
+// generation is passed to miss once the database lookup found nothing
+func (nc *negativeCache) absent(kind byte, name string) (ok bool, generation uint64) {
+	if nc == nil {
+		return false, 0
+	}
+	key := string(kind) + name
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if elem, ok := nc.misses[key]; ok {
+		nc.order.MoveToFront(elem)
+		negativeCacheStats.Add("hits", 1)
+		return true, nc.generation
+	}
+	if nc.filter == nil {
+		negativeCacheStats.Add("bypasses", 1)
+		return false, nc.generation
+	}
+	if !nc.filter.mayContain(key) {
+		negativeCacheStats.Add("hits", 1)
+		return true, nc.generation
+	}
+	negativeCacheStats.Add("misses", 1)
+	return false, nc.generation
+}
+
+// miss records that the database has no name of kind, unless the cache was flushed since generation
+func (nc *negativeCache) miss(kind byte, name string, generation uint64) {
+	if nc == nil || nc.size <= 0 {
+		return
+	}
+	key := string(kind) + name
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if generation != nc.generation {
+		return
+	}
+	if _, ok := nc.misses[key]; ok {
+		return
+	}
+	nc.misses[key] = nc.order.PushFront(key)
+	for nc.order.Len() > nc.size {
+		oldest := nc.order.Back()
+		nc.order.Remove(oldest)
+		delete(nc.misses, oldest.Value.(string))
+	}
+}
+
+// flush drops the filter and the misses, every lookup queries the database until the filter is built again
+func (nc *negativeCache) flush() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.flushLocked()
+}
+
+func (nc *negativeCache) flushLocked() {
+	nc.generation++
+	nc.filter = nil
+	nc.misses = make(map[string]*list.Element)
+	nc.order.Init()
+}
+
+// run is the negative_cache job, it builds the filter unless it is up to date with the latest import
+// a filter built while a flush happened is thrown away, the job runs again after the import notification that flushed it
+func (nc *negativeCache) run(ctx context.Context) error {
+	version, err := nc.ds.DataVersion(ctx)
+	if err != nil {
+		return err
+	}
+	nc.mu.Lock()
+	if nc.filter != nil && nc.version == version {
+		nc.mu.Unlock()
+		return nil
+	}
+	if nc.version != version {
+		// the names of the new imports would be answered with a 404 while the filter is rebuilt
+		nc.flushLocked()
+		nc.version = version
+	}
+	generation := nc.generation
+	nc.mu.Unlock()
+
+	start := time.Now()
+	filter := newBloomFilter(nc.bytes)
+	var names int64
+	add := func(kind byte) func(name string) error {
+		return func(name string) error {
+			filter.add(string(kind) + name)
+			names++
+			return nil
+		}
+	}
+	err = nc.ds.StreamNames(ctx, add(domainName), add(nameServerName))
+	if err != nil {
+		return err
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if generation != nc.generation {
+		logging.Debugf("negative cache: flushed while it was built, dropped")
+		return nil
+	}
+	nc.filter = filter
+	negativeCacheNames.Set(names)
+	logging.Infof("negative cache: %d names of data version %d in %s", names, version, time.Since(start).Round(time.Millisecond))
+	return nil
+}
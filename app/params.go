@@ -0,0 +1,18 @@
+package app
+
+import (
+	"net/http"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// invalidParam writes jsonErr and returns true if a parameter was rejected
+// so that handlers read each parameter in two lines
+func invalidParam(w http.ResponseWriter, jsonErr *model.JSONError) bool {
+	if jsonErr == nil {
+		return false
+	}
+	server.WriteJSONError(w, jsonErr)
+	return true
+}
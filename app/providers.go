@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// classifyNameServers sets the provider of the nameservers from their names
+func (app *appContext) classifyNameServers(lists ...[]*model.NameServer) {
+	classifier := app.providers.Load()
+	for _, nameservers := range lists {
+		for _, ns := range nameservers {
+			ns.Provider = classifier.Classify(ns.Name)
+		}
+	}
+}
+
+// precomputeProviderStats is the provider stats job, it stores the domains per provider to be served by providerStats
+func (app *appContext) precomputeProviderStats(ctx context.Context) error {
+	data, err := app.ds.GetProviderDomainCounts(ctx, app.providers.Load().Patterns())
+	if err != nil {
+		return err
+	}
+	app.providerCounts.Store(data)
+	return nil
+}
+
+// providerStats returns a copy of the precomputed provider stats, or computes them when the job has not run
+func (app *appContext) providerStats(ctx context.Context) (*model.ProviderCounts, error) {
+	if data, ok := app.providerCounts.Load().(*model.ProviderCounts); ok {
+		counts := *data
+		return &counts, nil
+	}
+	return app.ds.GetProviderDomainCounts(ctx, app.providers.Load().Patterns())
+}
+
+// apiProviderStatsHandler returns the number of active domains of every provider
+func (app *appContext) apiProviderStatsHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.providerStats(r.Context())
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, data)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/provider"
+)
+
+// providerStore counts the domains of the providers of the patterns it is given, or fails with err
+type providerStore struct {
+	fakeStore
+	err      error
+	patterns []provider.Pattern
+}
+
+func (s *providerStore) GetProviderDomainCounts(ctx context.Context, patterns []provider.Pattern) (*model.ProviderCounts, error) {
+	s.patterns = patterns
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &model.ProviderCounts{Providers: []*model.ProviderCount{{Provider: "Cloudflare", Domains: 3}}}, nil
+}
+
+func TestProviderStatsHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// the counts precomputed by the job, nil when it has not run
+		precomputed *model.ProviderCounts
+		err         error
+		want        int
+		body        string
+		// whether the handler queries the database
+		queried bool
+	}{
+		{name: "precomputed", precomputed: &model.ProviderCounts{Providers: []*model.ProviderCount{{Provider: "GoDaddy", Domains: 5}}}, want: http.StatusOK, body: `{"provider":"GoDaddy","domains":5}`},
+		{name: "job not run", want: http.StatusOK, body: `{"provider":"Cloudflare","domains":3}`, queried: true},
+		{name: "database unavailable", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, body: `"code":"database_unavailable"`, queried: true},
+		{name: "timeout", err: context.DeadlineExceeded, want: http.StatusServiceUnavailable, body: `"code":"timeout"`, queried: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &providerStore{err: tt.err}
+			app := &appContext{ds: ds, providers: testProviders(t)}
+			if tt.precomputed != nil {
+				app.providerCounts.Store(tt.precomputed)
+			}
+			w := httptest.NewRecorder()
+			app.apiProviderStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/stats/providers", nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("got %s, want %s", w.Body, tt.body)
+			}
+			if queried := ds.patterns != nil; queried != tt.queried {
+				t.Errorf("queried the database: %t, want %t", queried, tt.queried)
+			}
+			if tt.queried && len(ds.patterns) != len(provider.Defaults) {
+				t.Errorf("queried %d patterns, want the %d of the table", len(ds.patterns), len(provider.Defaults))
+			}
+		})
+	}
+}
+
+// TestDomainNameServerProviders classifies the nameservers of a domain lookup with the current table
+func TestDomainNameServerProviders(t *testing.T) {
+	ds := &domainStore{domains: map[string]*model.Domain{"EXAMPLE.ORG": {
+		Name:               "EXAMPLE.ORG",
+		NameServers:        []*model.NameServer{{Name: "ALICE.NS.CLOUDFLARE.COM"}},
+		ArchiveNameServers: []*model.NameServer{{Name: "NS1.EXAMPLE.NET"}},
+	}}}
+	app := &appContext{ds: ds, zones: testZoneAccess(t), providers: testProviders(t)}
+	classifier, err := provider.New([]provider.Pattern{{Suffix: "EXAMPLE.NET", Provider: "Example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.providers.Store(classifier)
+	w := httptest.NewRecorder()
+	app.apiDomainHandler(w, varsRequest("/api/domains/example.org", map[string]string{"domain": "example.org"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), `"provider":"Cloudflare"`) || !strings.Contains(w.Body.String(), `"provider":"Example"`) {
+		t.Errorf("got %s, want the nameservers classified by the stored table", w.Body)
+	}
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// txStore answers the lookups of a zone, a nameserver and an IP, only inside a ReadTx
+// beginErr fails the transactions, err the lookups in them and importErr the zone import
+type txStore struct {
+	fakeStore
+	t                        *testing.T
+	beginErr, err, importErr error
+	inTx                     bool
+	// transactions begun and lookups run in them
+	txs     int
+	queries []string
+}
+
+func (s *txStore) ReadTx(ctx context.Context, fn func(tx datastore.ReadQueries) error) error {
+	if s.beginErr != nil {
+		return s.beginErr
+	}
+	s.txs++
+	s.inTx = true
+	defer func() { s.inTx = false }()
+	return fn(s)
+}
+
+func (s *txStore) lookup(query string) error {
+	if !s.inTx {
+		s.t.Errorf("%s outside a read transaction", query)
+	}
+	s.queries = append(s.queries, query)
+	return s.err
+}
+
+func (s *txStore) GetZone(ctx context.Context, name string) (*model.Zone, error) {
+	if err := s.lookup("zone " + name); err != nil {
+		return nil, err
+	}
+	return &model.Zone{Name: name}, nil
+}
+
+func (s *txStore) GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error) {
+	if err := s.lookup("import " + zone); err != nil {
+		return nil, err
+	}
+	if s.importErr != nil {
+		return nil, s.importErr
+	}
+	return &model.ZoneImportResult{Zone: zone, Domains: 42}, nil
+}
+
+func (s *txStore) GetNameServer(ctx context.Context, domain string) (*model.NameServer, error) {
+	if err := s.lookup("nameserver " + domain); err != nil {
+		return nil, err
+	}
+	return &model.NameServer{Name: domain, Domains: []*model.Domain{{Name: "EXAMPLE.ORG"}, {Name: "EXAMPLE.COM"}}}, nil
+}
+
+func (s *txStore) GetIP(ctx context.Context, name string) (*model.IP, error) {
+	if err := s.lookup("ip " + name); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(name)
+	return &model.IP{Name: name, IP: &ip}, nil
+}
+
+// TestReadTxHandlers runs the lookups of each handler in one read transaction
+func TestReadTxHandlers(t *testing.T) {
+	type handler func(app *appContext) http.HandlerFunc
+	zone := func(app *appContext) http.HandlerFunc { return app.apiZoneHandler }
+	nameserver := func(app *appContext) http.HandlerFunc { return app.apiNameserverHandler }
+	ip := func(app *appContext) http.HandlerFunc { return app.apiIPHandler }
+	tests := []struct {
+		name    string
+		handler handler
+		vars    map[string]string
+		// errors of the transaction, the lookups and the zone import
+		beginErr, err, importErr error
+		want                     int
+		code                     string
+		// lookups run in the one transaction, and a string of the response
+		wantQueries []string
+		wantBody    string
+	}{
+		{name: "zone and its import", handler: zone, vars: map[string]string{"zone": "com"}, want: http.StatusOK,
+			wantQueries: []string{"zone COM", "import COM"}, wantBody: `"domains":42`},
+		{name: "zone without an import", handler: zone, vars: map[string]string{"zone": "com"}, importErr: datastore.ErrNoResource, want: http.StatusOK,
+			wantQueries: []string{"zone COM", "import COM"}, wantBody: `"name":"COM"`},
+		{name: "unknown zone", handler: zone, vars: map[string]string{"zone": "com"}, err: datastore.ErrNoResource, want: http.StatusNotFound, code: "resource_not_found",
+			wantQueries: []string{"zone COM"}},
+		{name: "invalid zone", handler: zone, vars: map[string]string{"zone": "xn--bcher-kvaü"}, want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "zone transaction fails", handler: zone, vars: map[string]string{"zone": "com"}, beginErr: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+
+		// the domains of restricted zones are left out of the nameserver
+		{name: "nameserver", handler: nameserver, vars: map[string]string{"domain": "ns1.example.net"}, want: http.StatusOK,
+			wantQueries: []string{"nameserver NS1.EXAMPLE.NET"}, wantBody: `"name":"EXAMPLE.ORG","firstseen":null,"lastseen":null}]`},
+		{name: "unknown nameserver", handler: nameserver, vars: map[string]string{"domain": "ns1.example.net"}, err: datastore.ErrNoResource, want: http.StatusNotFound, code: "resource_not_found",
+			wantQueries: []string{"nameserver NS1.EXAMPLE.NET"}},
+		{name: "invalid nameserver", handler: nameserver, vars: map[string]string{"domain": "xn--bcher-kvaü"}, want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "nameserver transaction fails", handler: nameserver, vars: map[string]string{"domain": "ns1.example.net"}, beginErr: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+
+		{name: "ip", handler: ip, vars: map[string]string{"ip": "192.0.2.1"}, want: http.StatusOK,
+			wantQueries: []string{"ip 192.0.2.1"}, wantBody: `"name":"192.0.2.1"`},
+		{name: "unknown ip", handler: ip, vars: map[string]string{"ip": "192.0.2.1"}, err: datastore.ErrNoResource, want: http.StatusNotFound, code: "resource_not_found",
+			wantQueries: []string{"ip 192.0.2.1"}},
+		{name: "invalid ip", handler: ip, vars: map[string]string{"ip": "192.0.2"}, want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "ip lookup fails", handler: ip, vars: map[string]string{"ip": "192.0.2.1"}, err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable",
+			wantQueries: []string{"ip 192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &txStore{t: t, beginErr: tt.beginErr, err: tt.err, importErr: tt.importErr}
+			app := &appContext{
+				ds:        ds,
+				zones:     testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+				providers: testProviders(t),
+			}
+			w := httptest.NewRecorder()
+			tt.handler(app)(w, varsRequest("/api/lookup", tt.vars))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+			if tt.wantBody != "" && !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got %s, want %s", w.Body, tt.wantBody)
+			}
+			if strings.Join(ds.queries, ", ") != strings.Join(tt.wantQueries, ", ") {
+				t.Errorf("looked up %q, want %q", ds.queries, tt.wantQueries)
+			}
+			wantTxs := 0
+			if len(tt.wantQueries) > 0 {
+				wantTxs = 1
+			}
+			if ds.txs != wantTxs {
+				t.Errorf("began %d read transactions, want %d", ds.txs, wantTxs)
+			}
+		})
+	}
+}
+
+// TestZoneHandlerImportInTx returns the import read in the same transaction as the zone
+func TestZoneHandlerImportInTx(t *testing.T) {
+	app := &appContext{ds: &txStore{t: t}}
+	w := httptest.NewRecorder()
+	app.apiZoneHandler(w, varsRequest("/api/zones/com", map[string]string{"zone": "com"}))
+	var resp struct{ Data model.Zone }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.ImportData == nil || resp.Data.ImportData.Zone != "COM" || resp.Data.ImportData.Domains != 42 {
+		t.Errorf("got import %+v, want that of COM", resp.Data.ImportData)
+	}
+}
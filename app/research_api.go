@@ -1,25 +1,21 @@
 package app
 
 import (
-	"dnscoffee/datastore"
+	"dnscoffee/params"
 	"dnscoffee/server"
 	"net/http"
-	"time"
-
-	"github.com/gorilla/mux"
 )
 
 func (app *appContext) apiIPNsZoneCount(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
+	ip, jsonErr := params.IP(r, "ip")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 
-	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip)
+	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip.String())
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
@@ -27,19 +23,15 @@ func (app *appContext) apiIPNsZoneCount(w http.ResponseWriter, r *http.Request)
 
 // apiActiveIPs exposes GetActiveIPs as an API
 func (app *appContext) apiActiveIPs(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
-	if err != nil {
-		panic(err)
+	date, jsonErr := params.Date(r, "date")
+	if invalidParam(w, jsonErr) {
+		return
 	}
 
 	data, err := app.ds.GetActiveIPs(r.Context(), date)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	server.WriteJSON(w, data)
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// knownSources returns the configured sources by name
+func knownSources(sources []string) map[string]bool {
+	known := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		known[source] = true
+	}
+	return known
+}
+
+// sourceNames returns the known sources sorted, for the errors naming them
+func (app *appContext) sourceNames() string {
+	names := make([]string, 0, len(app.sources))
+	for source := range app.sources {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// feedSources returns the sorted sources of the comma separated ?source= of a feed, nil when absent
+// the feed only lists the domains of the imports from one of them, so that clients can leave out the data of sources
+// whose terms they can not use; every source must be a known one
+func (app *appContext) feedSources(r *http.Request) ([]string, *model.JSONError) {
+	value := r.URL.Query().Get("source")
+	if value == "" {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if !app.sources[source] {
+			return nil, server.NewFieldError("source", "must be one or more of "+app.sourceNames()+" separated by commas")
+		}
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// validImportSource checks the source of an import notification, empty when the importer recorded it already
+func (app *appContext) validImportSource(source string) (string, *model.JSONError) {
+	source = strings.ToLower(source)
+	if source != "" && !app.sources[source] {
+		return "", server.NewFieldError("source", "must be one of "+app.sourceNames())
+	}
+	return source, nil
+}
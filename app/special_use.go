@@ -0,0 +1,48 @@
+package app
+
+import (
+	"net/http"
+
+	"dnscoffee/datastore"
+	"dnscoffee/server"
+	"dnscoffee/specialuse"
+)
+
+// specialUse returns the kind of the special-use domain name is under, empty for the other names
+func specialUse(name string) string {
+	n, _ := specialuse.Default.Classify(name)
+	return n.Kind
+}
+
+// specialUseMeta returns the meta of the errors of the lookups of name, its special-use kind and RFC, none for the
+// other names
+func specialUseMeta(name string) []map[string]string {
+	n, ok := specialuse.Default.Classify(name)
+	if !ok {
+		return nil
+	}
+	return []map[string]string{{"special_use": n.Kind, "rfc": n.RFC}}
+}
+
+// neverDelegated answers with ErrSpecialUseName and returns true when the domain is under a special-use domain no
+// public zone delegates, such as localhost or test, its lookup could only miss
+// the example names of the public zones, such as example.com, and the nameservers are looked up like any other name
+// as zone files do have them
+func neverDelegated(w http.ResponseWriter, domain string) bool {
+	n, ok := specialuse.Default.Classify(domain)
+	if !ok || n.Public {
+		return false
+	}
+	server.WriteJSONError(w, server.ErrSpecialUseName, specialUseMeta(domain)...)
+	return true
+}
+
+// writeLookupError writes the error of the lookup of name like writeError, the 404 of a special-use name carrying
+// its kind in the meta
+func (app *appContext) writeLookupError(w http.ResponseWriter, name string, err error) {
+	if meta := specialUseMeta(name); err == datastore.ErrNoResource && meta != nil {
+		server.WriteJSONError(w, server.ErrResourceNotFound, meta...)
+		return
+	}
+	app.writeError(w, err)
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// the thresholds of the stability score, see scoreStability
+const (
+	// changes back to a set the domain had before that make it parked-flapping
+	stabilityFlapReverts = 2
+	// changes within stabilityChurnWindow that make it churning
+	stabilityChurnChanges = 3
+	stabilityChurnWindow  = 180
+)
+
+// stabilityBatch is how many domains the stability job scores per query
+const stabilityBatch = 10000
+
+// stabilityRanks orders the stabilities from the least to the most stable, for ?min_stability=
+var stabilityRanks = []string{model.StabilityParkedFlapping, model.StabilityChurning, model.StabilityStable}
+
+// stabilityPeriod is a span of days, end excluded, the domain was delegated to the same nameserver set, none for a gap
+type stabilityPeriod struct {
+	set        string
+	start, end int64
+}
+
+// stabilityDay returns the number of days from the Unix epoch to the date of t
+func stabilityDay(t time.Time) int64 {
+	return t.Unix() / 86400
+}
+
+// stabilityDate returns the date of a day of stabilityDay
+func stabilityDate(d int64) model.Date {
+	return model.NewDate(time.Unix(d*86400, 0).UTC())
+}
+
+// stabilityPeriods splits the delegation history of a domain into the periods of its nameserver sets up to asOf, the
+// days it had no nameserver between two sets being a period of the empty set; every period has another set than the
+// previous one
+func stabilityPeriods(delegations []datastore.Delegation, asOf int64) []stabilityPeriod {
+	// the set only changes on the day a delegation starts or the day after it was last seen
+	var bounds []int64
+	for _, d := range delegations {
+		bounds = append(bounds, stabilityDay(d.FirstSeen.Time))
+		if !d.LastSeen.IsZero() {
+			bounds = append(bounds, stabilityDay(d.LastSeen.Time)+1)
+		}
+	}
+	bounds = append(bounds, asOf+1)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	var periods []stabilityPeriod
+	for i, start := range bounds {
+		if start > asOf || i+1 < len(bounds) && bounds[i+1] == start {
+			continue
+		}
+		end := asOf + 1
+		if i+1 < len(bounds) {
+			end = bounds[i+1]
+		}
+		var ids []string
+		for _, d := range delegations {
+			if stabilityDay(d.FirstSeen.Time) <= start && (d.LastSeen.IsZero() || stabilityDay(d.LastSeen.Time) >= start) {
+				ids = append(ids, strconv.FormatInt(d.NameServerID, 10))
+			}
+		}
+		sort.Strings(ids)
+		set := strings.Join(ids, ",")
+		if n := len(periods); n > 0 && periods[n-1].set == set {
+			periods[n-1].end = end
+			continue
+		}
+		periods = append(periods, stabilityPeriod{set: set, start: start, end: end})
+	}
+	// the domain is gone after its last delegation ended, that is no gap
+	for len(periods) > 0 && periods[len(periods)-1].set == "" {
+		periods = periods[:len(periods)-1]
+	}
+	return periods
+}
+
+// scoreStability summarizes the delegation history of a domain as of the day asOf, and scores it:
+//   - parked-flapping when it went back to a nameserver set it had before, a gap without nameservers included,
+//     stabilityFlapReverts times or more, as the domains moved between a parking service and their own nameservers do
+//   - churning when its set changed stabilityChurnChanges times or more within stabilityChurnWindow days
+//   - stable otherwise
+//
+// the score only depends on the changes, it stays right until the delegations of the domain change again
+func scoreStability(domain string, delegations []datastore.Delegation, asOf time.Time) *model.DomainStability {
+	today := stabilityDay(asOf)
+	periods := stabilityPeriods(delegations, today)
+	st := &model.DomainStability{Domain: domain, Stability: model.StabilityStable}
+	if len(periods) == 0 {
+		return st
+	}
+	st.FirstSeen = stabilityDate(periods[0].start)
+	last := periods[len(periods)-1]
+	if last.end <= today {
+		st.LastSeen = stabilityDate(last.end - 1)
+	}
+	seen := make(map[string]bool)
+	for i, p := range periods {
+		if p.set != "" {
+			if !seen[p.set] {
+				st.NameServerSets++
+			}
+			if days := int(p.end - p.start); days > st.LongestStableDays {
+				st.LongestStableDays = days
+			}
+		}
+		if i > 0 {
+			st.Changes++
+			if seen[p.set] {
+				st.Reverts++
+			}
+		}
+		seen[p.set] = true
+	}
+	since := periods[0].start
+	if st.Changes > 0 {
+		since = last.start
+		st.LastChange = stabilityDate(since)
+	}
+	st.DaysSinceChange = int(today - since)
+
+	// the changes are the starts of the periods after the first, in order
+	churning := false
+	for i := 1; i+stabilityChurnChanges-1 < len(periods); i++ {
+		if periods[i+stabilityChurnChanges-1].start-periods[i].start < stabilityChurnWindow {
+			churning = true
+			break
+		}
+	}
+	switch {
+	case st.Reverts >= stabilityFlapReverts:
+		st.Stability = model.StabilityParkedFlapping
+	case churning:
+		st.Stability = model.StabilityChurning
+	}
+	return st
+}
+
+// minStability returns the stabilities at least as stable as ?min_stability=, nil when absent
+func minStability(r *http.Request) ([]string, *model.JSONError) {
+	value := r.URL.Query().Get("min_stability")
+	if value == "" {
+		return nil, nil
+	}
+	for i, stability := range stabilityRanks {
+		if stability == value {
+			return stabilityRanks[i:], nil
+		}
+	}
+	return nil, server.NewFieldError("min_stability", "must be one of "+strings.Join(stabilityRanks, ", "))
+}
+
+// scoreStabilities is the stability job, it scores the domains of the zone of every finished import not scored yet whose
+// delegations changed since the previous scored import of the zone, every domain of the zone for its first one
+func (app *appContext) scoreStabilities(ctx context.Context) error {
+	imports, err := app.ds.GetStabilityImports(ctx)
+	if err != nil {
+		return err
+	}
+	for _, si := range imports {
+		var afterID, scored int64
+		for {
+			domains, err := app.ds.GetStabilityDelegations(ctx, si, afterID, stabilityBatch)
+			if err != nil {
+				return err
+			}
+			if len(domains) == 0 {
+				break
+			}
+			scores := make([]*datastore.StabilityScore, len(domains))
+			for i, dd := range domains {
+				st := scoreStability("", dd.Delegations, si.Date.Time)
+				scores[i] = &datastore.StabilityScore{
+					DomainID:   dd.DomainID,
+					Stability:  st.Stability,
+					Changes:    st.Changes,
+					Reverts:    st.Reverts,
+					LastChange: st.LastChange,
+				}
+			}
+			err = app.ds.SaveStabilityScores(ctx, si.ID, scores)
+			if err != nil {
+				return err
+			}
+			scored += int64(len(domains))
+			afterID = domains[len(domains)-1].DomainID
+		}
+		err = app.ds.SaveStabilityImport(ctx, si, scored)
+		if err != nil {
+			return err
+		}
+		logging.Debugf("stability: scored %d domains of import %d of zone %q", scored, si.ID, si.Zone)
+	}
+	return nil
+}
+
+// apiDomainStabilityHandler returns how often the nameserver set of a domain changed and its stability score, computed
+// from its delegation history as of today
+func (app *appContext) apiDomainStabilityHandler(w http.ResponseWriter, r *http.Request) {
+	domain, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if app.zoneForbidden(w, r, domain) {
+		return
+	}
+	domainID, _, err := app.ds.GetDomainID(r.Context(), domain)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	delegations, err := app.ds.GetDelegations(r.Context(), domainID)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, scoreStability(domain, delegations, server.Today()))
+}
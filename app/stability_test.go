@@ -0,0 +1,191 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+)
+
+// stabilityEpoch is day 0 of the synthetic histories
+var stabilityEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// day returns the date of day n of the synthetic histories
+func day(n int) model.Date {
+	return model.NewDate(stabilityEpoch.AddDate(0, 0, n))
+}
+
+// delegated is a delegation to nameserver ns from day first to day last included, still delegated when last is -1
+func delegated(ns int64, first, last int) datastore.Delegation {
+	d := datastore.Delegation{NameServerID: ns, FirstSeen: day(first)}
+	if last >= 0 {
+		d.LastSeen = day(last)
+	}
+	return d
+}
+
+// history returns the delegations of a domain moving between the single nameservers of sets, starting on the days of
+// starts, the last one still delegated
+func history(sets []int64, starts []int) []datastore.Delegation {
+	var ds []datastore.Delegation
+	for i, ns := range sets {
+		last := -1
+		if i+1 < len(starts) {
+			last = starts[i+1] - 1
+		}
+		ds = append(ds, delegated(ns, starts[i], last))
+	}
+	return ds
+}
+
+func TestScoreStability(t *testing.T) {
+	const asOf = 1000
+	tests := []struct {
+		name        string
+		delegations []datastore.Delegation
+		want        model.DomainStability
+	}{
+		{
+			name: "never delegated",
+			want: model.DomainStability{Stability: model.StabilityStable},
+		},
+		{
+			name:        "one set",
+			delegations: history([]int64{1}, []int{0}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 1, LongestStableDays: 1001,
+				DaysSinceChange: 1000, FirstSeen: day(0)},
+		},
+		{
+			name:        "one move",
+			delegations: history([]int64{1, 2}, []int{0, 500}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 2, Changes: 1, LongestStableDays: 501,
+				LastChange: day(500), DaysSinceChange: 500, FirstSeen: day(0)},
+		},
+		{
+			name:        "changes years apart",
+			delegations: history([]int64{1, 2, 3, 4}, []int{0, 300, 600, 900}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 4, Changes: 3, LongestStableDays: 300,
+				LastChange: day(900), DaysSinceChange: 100, FirstSeen: day(0)},
+		},
+		{
+			name:        "churning",
+			delegations: history([]int64{1, 2, 3, 4}, []int{0, 900, 950, 980}),
+			want: model.DomainStability{Stability: model.StabilityChurning, NameServerSets: 4, Changes: 3, LongestStableDays: 900,
+				LastChange: day(980), DaysSinceChange: 20, FirstSeen: day(0)},
+		},
+		{
+			name:        "churn window just missed",
+			delegations: history([]int64{1, 2, 3, 4}, []int{0, 100, 200, 280}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 4, Changes: 3, LongestStableDays: 721,
+				LastChange: day(280), DaysSinceChange: 720, FirstSeen: day(0)},
+		},
+		{
+			name:        "churn window just hit",
+			delegations: history([]int64{1, 2, 3, 4}, []int{0, 100, 200, 279}),
+			want: model.DomainStability{Stability: model.StabilityChurning, NameServerSets: 4, Changes: 3, LongestStableDays: 722,
+				LastChange: day(279), DaysSinceChange: 721, FirstSeen: day(0)},
+		},
+		{
+			name:        "parked flapping",
+			delegations: history([]int64{1, 9, 1, 9}, []int{0, 100, 200, 300}),
+			want: model.DomainStability{Stability: model.StabilityParkedFlapping, NameServerSets: 2, Changes: 3, Reverts: 2,
+				LongestStableDays: 701, LastChange: day(300), DaysSinceChange: 700, FirstSeen: day(0)},
+		},
+		{
+			name:        "one revert",
+			delegations: history([]int64{1, 9, 1}, []int{0, 100, 200}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 2, Changes: 2, Reverts: 1,
+				LongestStableDays: 801, LastChange: day(200), DaysSinceChange: 800, FirstSeen: day(0)},
+		},
+		{
+			name:        "gap and back",
+			delegations: []datastore.Delegation{delegated(1, 0, 99), delegated(1, 200, -1)},
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 1, Changes: 2, Reverts: 1,
+				LongestStableDays: 801, LastChange: day(200), DaysSinceChange: 800, FirstSeen: day(0)},
+		},
+		{
+			name:        "gaps flapping",
+			delegations: []datastore.Delegation{delegated(1, 0, 99), delegated(1, 200, 299), delegated(1, 400, -1)},
+			want: model.DomainStability{Stability: model.StabilityParkedFlapping, NameServerSets: 1, Changes: 4, Reverts: 3,
+				LongestStableDays: 601, LastChange: day(400), DaysSinceChange: 600, FirstSeen: day(0)},
+		},
+		{
+			name:        "gone",
+			delegations: []datastore.Delegation{delegated(1, 0, 499)},
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 1, LongestStableDays: 500,
+				DaysSinceChange: 1000, FirstSeen: day(0), LastSeen: day(499)},
+		},
+		{
+			name: "one of two nameservers replaced",
+			delegations: []datastore.Delegation{
+				delegated(1, 0, -1), delegated(2, 0, 499), delegated(3, 500, -1),
+			},
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 2, Changes: 1, LongestStableDays: 501,
+				LastChange: day(500), DaysSinceChange: 500, FirstSeen: day(0)},
+		},
+		{
+			name: "nameservers added one day apart",
+			delegations: []datastore.Delegation{
+				delegated(1, 0, -1), delegated(2, 1, -1),
+			},
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 2, Changes: 1, LongestStableDays: 1000,
+				LastChange: day(1), DaysSinceChange: 999, FirstSeen: day(0)},
+		},
+		{
+			name:        "changes after the day",
+			delegations: history([]int64{1, 2}, []int{0, 1200}),
+			want: model.DomainStability{Stability: model.StabilityStable, NameServerSets: 1, LongestStableDays: 1001,
+				DaysSinceChange: 1000, FirstSeen: day(0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scoreStability("example.com", tt.delegations, day(asOf).Time.Add(15*time.Hour))
+			tt.want.Domain = "example.com"
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScoreStabilityOrder checks that the score does not depend on the order of the history rows
+func TestScoreStabilityOrder(t *testing.T) {
+	ds := history([]int64{1, 9, 1, 9, 2}, []int{0, 100, 200, 300, 400})
+	want := scoreStability("example.com", ds, day(1000).Time)
+	for i, j := 0, len(ds)-1; i < j; i, j = i+1, j-1 {
+		ds[i], ds[j] = ds[j], ds[i]
+	}
+	if got := scoreStability("example.com", ds, day(1000).Time); *got != *want {
+		t.Errorf("got %+v reversed, want %+v", *got, *want)
+	}
+}
+
+func TestMinStability(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		invalid bool
+	}{
+		{value: ""},
+		{value: model.StabilityStable, want: []string{model.StabilityStable}},
+		{value: model.StabilityChurning, want: []string{model.StabilityChurning, model.StabilityStable}},
+		{value: model.StabilityParkedFlapping, want: stabilityRanks},
+		{value: "STABLE", invalid: true},
+		{value: "unknown", invalid: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?min_stability="+tt.value, nil)
+		got, jsonErr := minStability(r)
+		if (jsonErr != nil) != tt.invalid {
+			t.Errorf("%q: got error %v", tt.value, jsonErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/asn"
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/provider"
+)
+
+// store is the part of *datastore.DataStore the handlers query, tests give a fake with the queries they need
+type store interface {
+	AcknowledgeImportAlert(ctx context.Context, importID int64, by string, clear bool) (*model.ImportCheck, error)
+	AddWatchMatches(ctx context.Context, wi datastore.WatchImport, termIDs, domainIDs []int64, termsThrough int64) error
+	CountLabels(ctx context.Context, ci datastore.CheckImport) (*datastore.LabelCounts, error)
+	CountNameServerChanges(ctx context.Context, nameserverID int64, from, to time.Time) (int64, int64, error)
+	CountWatchlists(ctx context.Context, owner string) (map[string]int, error)
+	CountZoneDomains(ctx context.Context, zoneID int64, active *bool) (int64, error)
+	CreateWatchlist(ctx context.Context, owner, name, kind, term string) (*model.Watchlist, error)
+	DataVersion(ctx context.Context) (int64, error)
+	DeleteWatchlist(ctx context.Context, owner string, id int64) error
+	FeedLastModified(ctx context.Context, date time.Time) (*time.Time, error)
+	GetASNAddresses(ctx context.Context, v4, v6 []asn.Range, limit int) (*model.ASN, error)
+	GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error)
+	GetAllZoneHistoryCounts(ctx context.Context) (*model.AllZoneCounts, error)
+	GetAvailablePrefixes(ctx context.Context, name string) (*model.PrefixList, error)
+	GetClosestImport(ctx context.Context, zoneID int64, date time.Time) (datastore.Import, error)
+	GetCohortSample(ctx context.Context, zoneID int64, month time.Time, seed string, size int) ([]*model.CohortDomain, int64, error)
+	GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error)
+	GetDelegations(ctx context.Context, domainID int64) ([]datastore.Delegation, error)
+	GetDomain(ctx context.Context, domain string) (*model.Domain, error)
+	GetDomainID(ctx context.Context, domain string) (int64, int64, error)
+	GetDomainLifetimes(ctx context.Context, zoneID int64, from, to time.Time) ([]*model.DomainLifetimes, error)
+	GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error)
+	GetFeedMoved(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error)
+	GetFeedNew(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error)
+	GetFeedNewSince(ctx context.Context, pos datastore.FeedPosition, zoneID int64, sources []string, limit int) ([]*model.FeedDeltaDomain, error)
+	GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetFeedNsOld(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetFeedOld(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error)
+	GetGlueInconsistencies(ctx context.Context, zoneID int64, kind, after string, limit int) (*model.GlueInconsistencies, error)
+	GetIP(ctx context.Context, name string) (*model.IP, error)
+	GetIPID(ctx context.Context, ipStr string) (int64, int, error)
+	GetIPNsZoneCount(ctx context.Context, ip string) (*model.ResearchIPNsZoneCount, error)
+	GetImport(ctx context.Context, id int64) (*model.Import, error)
+	GetImportAlerts(ctx context.Context) ([]*model.ImportCheck, error)
+	GetImportProgress(ctx context.Context) (*model.ImportProgress, error)
+	GetInternetHistoryCounts(ctx context.Context) (*model.ZoneCount, error)
+	GetKeywordCounts(ctx context.Context, keyword, granularity string, from, to time.Time) ([]*model.KeywordPeriod, error)
+	GetLabelCounts(ctx context.Context, zoneID int64, asOf time.Time) (*datastore.LabelCounts, *time.Time, error)
+	GetLabelStatsImports(ctx context.Context) ([]datastore.CheckImport, error)
+	GetLabelZones(ctx context.Context, label string) ([]*model.LabelZone, []string, error)
+	GetLastFeedPosition(ctx context.Context) (datastore.FeedPosition, error)
+	GetMovedFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+	GetNameServer(ctx context.Context, domain string) (*model.NameServer, error)
+	GetNameServerChanges(ctx context.Context, nameserverID int64, from, to time.Time, after *model.NameServerChange, limit int) ([]*model.NameServerChange, error)
+	GetNameServerDomainCounts(ctx context.Context, nameserverID, zoneID int64, from, to time.Time) ([]*model.NameServerCount, error)
+	GetNameServerID(ctx context.Context, domain string) (int64, error)
+	GetNameServerSet(ctx context.Context, fingerprint string) (*model.NameServerSet, error)
+	GetNameServerSetDomains(ctx context.Context, fingerprint string, stabilities []string, afterID int64, limit int) ([]*model.Domain, error)
+	GetNameServerSuffixStats(ctx context.Context, suffix string, limit int) (*model.NameServerSuffixStats, error)
+	GetNewFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+	GetNewWatchMatches(ctx context.Context, termID int64, since, afterTime time.Time, afterDomain string, limit int) ([]*model.WatchlistMatch, error)
+	GetOldFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+	GetPendingWatchImports(ctx context.Context, termsThrough int64) ([]datastore.WatchImport, error)
+	GetProviderDomainCounts(ctx context.Context, patterns []provider.Pattern) (*model.ProviderCounts, error)
+	GetRandomDomain(ctx context.Context) (*model.Domain, error)
+	GetRunningImports(ctx context.Context) (*model.RunningImports, error)
+	GetStabilityDelegations(ctx context.Context, si datastore.StabilityImport, afterID int64, limit int) ([]*datastore.DomainDelegations, error)
+	GetStabilityImports(ctx context.Context) ([]datastore.StabilityImport, error)
+	GetTakenPrefixes(ctx context.Context, name string) (*model.PrefixList, error)
+	GetWatchMatches(ctx context.Context, termID int64, after string, limit int) ([]*model.WatchlistMatch, error)
+	GetWatchTermEvaluatedAt(ctx context.Context, termID int64) (model.Timestamp, error)
+	GetWatchTerms(ctx context.Context) ([]datastore.WatchTerm, error)
+	GetWatchlist(ctx context.Context, owner string, id int64) (*model.Watchlist, error)
+	GetWatchlists(ctx context.Context, owner string) ([]*model.Watchlist, error)
+	GetZone(ctx context.Context, name string) (*model.Zone, error)
+	GetZoneCountAt(ctx context.Context, zoneID int64, date time.Time) (*datastore.ZoneCountAt, error)
+	GetZoneCountsAt(ctx context.Context, zoneID int64, dates []time.Time) ([]*datastore.ZoneCountAt, error)
+	GetZoneDelegation(ctx context.Context, zoneID int64) (*model.ZoneDelegation, error)
+	GetZoneDelegationChanges(ctx context.Context, zoneID int64, limit int) ([]*model.DelegationChange, error)
+	GetZoneDomainEstimate(ctx context.Context, zoneID int64) (int64, error)
+	GetZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int) ([]*model.ZoneDomain, error)
+	GetZoneHistoryCounts(ctx context.Context, zone string) (*model.ZoneCount, error)
+	GetZoneID(ctx context.Context, name string) (int64, error)
+	GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error)
+	GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error)
+	ReadTx(ctx context.Context, fn func(tx datastore.ReadQueries) error) error
+	RefreshGlueInconsistencies(ctx context.Context) (int, error)
+	RefreshKeywordCounts(ctx context.Context, keywords []string) (int64, error)
+	RetryAfter() time.Duration
+	SaveLabelCounts(ctx context.Context, ci datastore.CheckImport, lc *datastore.LabelCounts) error
+	SaveStabilityImport(ctx context.Context, si datastore.StabilityImport, domains int64) error
+	SaveStabilityScores(ctx context.Context, importID int64, scores []*datastore.StabilityScore) error
+	SetImportSource(ctx context.Context, importID int64, source string) error
+	StreamFeed(ctx context.Context, change string, date time.Time, sources []string, fn func(domain string) error) error
+	StreamNewDomains(ctx context.Context, wi datastore.WatchImport, fn func(id int64, domain string) error) error
+	StreamZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int, fn func(*model.ZoneDomain) error) error
+}
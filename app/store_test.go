@@ -0,0 +1,14 @@
+package app
+
+import (
+	"time"
+)
+
+// fakeStore is the base of the tests' stores, the queries a test does not override panic
+type fakeStore struct {
+	store
+}
+
+func (fakeStore) RetryAfter() time.Duration {
+	return 30 * time.Second
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/schedule"
+	"dnscoffee/server"
+)
+
+// viewRefresher refreshes materialized views on their schedules and on demand
+// concurrent refreshes of the same view share a single refresh
+type viewRefresher struct {
+	ds    *datastore.DataStore
+	views map[string]datastore.MaterializedView
+	// ctx is canceled when the server shuts down
+	ctx context.Context
+
+	mu        sync.Mutex
+	inflight  map[string]*refreshCall
+	refreshed map[string]model.Timestamp
+}
+
+// refreshCall is a refresh in progress that other callers can wait on
+type refreshCall struct {
+	done   chan struct{}
+	result *model.ViewRefresh
+	err    error
+}
+
+func newViewRefresher(ctx context.Context, ds *datastore.DataStore) *viewRefresher {
+	vr := &viewRefresher{
+		ds:        ds,
+		views:     make(map[string]datastore.MaterializedView),
+		ctx:       ctx,
+		inflight:  make(map[string]*refreshCall),
+		refreshed: make(map[string]model.Timestamp),
+	}
+	for _, v := range ds.MaterializedViews() {
+		vr.views[v.Name] = v
+	}
+	return vr
+}
+
+// start runs a goroutine for every view with a schedule until the refresher's context is canceled
+func (vr *viewRefresher) start() {
+	for _, view := range vr.views {
+		if view.Schedule == "" {
+			continue
+		}
+		sched, err := schedule.Parse(view.Schedule)
+		if err != nil {
+			logging.Fatalf("materialized view %s: %s", view.Name, err)
+		}
+		go vr.run(view.Name, sched)
+	}
+}
+
+func (vr *viewRefresher) run(name string, sched schedule.Schedule) {
+	for {
+		t := time.NewTimer(time.Until(sched.Next(time.Now())))
+		select {
+		case <-vr.ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		// errors are logged by refresh
+		_, _ = vr.refresh(vr.ctx, name)
+	}
+}
+
+// refresh refreshes the named view, or waits for the refresh already in progress
+// returns datastore.ErrNoResource for unknown views
+func (vr *viewRefresher) refresh(ctx context.Context, name string) (*model.ViewRefresh, error) {
+	view, ok := vr.views[name]
+	if !ok {
+		return nil, datastore.ErrNoResource
+	}
+
+	vr.mu.Lock()
+	call, running := vr.inflight[name]
+	if !running {
+		call = &refreshCall{done: make(chan struct{})}
+		vr.inflight[name] = call
+	}
+	vr.mu.Unlock()
+
+	if running {
+		logging.Debugf("refresh %s already in progress, waiting", name)
+	} else {
+		// the refresh is not tied to any single request so that waiters are not canceled with it
+		go vr.doRefresh(view, call)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.result, call.err
+	}
+}
+
+func (vr *viewRefresher) doRefresh(view datastore.MaterializedView, call *refreshCall) {
+	start := time.Now()
+	rows, err := vr.ds.RefreshMaterializedView(vr.ctx, view)
+	took := time.Since(start)
+	if err != nil {
+		logging.Errorf("refresh %s failed after %s: %s", view.Name, took.Round(time.Millisecond), err)
+		call.err = err
+	} else {
+		logging.Infof("refreshed %s in %s: %d rows", view.Name, took.Round(time.Millisecond), rows)
+		call.result = &model.ViewRefresh{
+			View:        view.Name,
+			Rows:        rows,
+			Duration:    took,
+			RefreshedAt: model.NewTimestamp(start),
+		}
+	}
+
+	vr.mu.Lock()
+	if err == nil {
+		vr.refreshed[view.Name] = call.result.RefreshedAt
+	}
+	delete(vr.inflight, view.Name)
+	vr.mu.Unlock()
+	close(call.done)
+}
+
+// lastRefreshed returns the time each view was last refreshed by this process
+func (vr *viewRefresher) lastRefreshed() map[string]model.Timestamp {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	out := make(map[string]model.Timestamp, len(vr.refreshed))
+	for k, v := range vr.refreshed {
+		out[k] = v
+	}
+	return out
+}
+
+// apiAdminRefreshViewHandler refreshes a materialized view on demand
+func (app *appContext) apiAdminRefreshViewHandler(w http.ResponseWriter, r *http.Request) {
+	view, jsonErr := params.Path(r, "view")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	data, err := app.views.refresh(r.Context(), view)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+
+	server.WriteJSON(w, data)
+}
@@ -0,0 +1,433 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// maxWatchlistBody is the largest watchlist definition accepted in bytes
+const maxWatchlistBody = 4 << 10
+
+// watchPageSize is the default and maxWatchPageSize the largest number of matches on a page of a watchlist
+const (
+	watchPageSize    = 100
+	maxWatchPageSize = 1000
+)
+
+// watchlist query kinds, as stored in watch_terms
+const (
+	watchPrefix   = "prefix"
+	watchContains = "contains"
+	watchSimilar  = "similar"
+)
+
+// watchlist cost classes, the expensive queries are limited separately
+const (
+	costStandard  = "standard"
+	costExpensive = "expensive"
+)
+
+// watchCostClass returns the cost class of a query kind
+// similar queries compute an edit distance with every new domain, the others only compare bytes
+func watchCostClass(kind string) string {
+	if kind == watchSimilar {
+		return costExpensive
+	}
+	return costStandard
+}
+
+// describeWatchlist sets the query and cost class of a watchlist from its term
+func describeWatchlist(wl *model.Watchlist) {
+	switch wl.Kind {
+	case watchPrefix:
+		wl.Query.Prefix = wl.Term
+	case watchContains:
+		wl.Query.Contains = wl.Term
+	case watchSimilar:
+		wl.Query.SimilarTo = wl.Term
+	}
+	wl.CostClass = watchCostClass(wl.Kind)
+}
+
+// watchMatches returns true if the query of kind and term matches label, a lower case name without its zone
+func watchMatches(kind, term, label string) bool {
+	switch kind {
+	case watchPrefix:
+		return strings.HasPrefix(label, term)
+	case watchContains:
+		return strings.Contains(label, term)
+	case watchSimilar:
+		return withinEditDistance(label, term, similarDistance(term))
+	}
+	return false
+}
+
+// similarDistance is the largest edit distance of the names similar to term, the longer the term the more typos it takes
+func similarDistance(term string) int {
+	if len(term) < 8 {
+		return 1
+	}
+	return 2
+}
+
+// withinEditDistance returns true if the Levenshtein distance between a and b, in bytes, is at most max
+func withinEditDistance(a, b string, max int) bool {
+	if len(a)-len(b) > max || len(b)-len(a) > max {
+		return false
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j-1]+cost, minInt(prev[j]+1, cur[j-1]+1))
+			if cur[j] < best {
+				best = cur[j]
+			}
+		}
+		// the distance only grows from a row whose every cell is over max
+		if best > max {
+			return false
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)] <= max
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// evaluateWatchlists is the watchlists job, it matches the new domains of every finished import against the terms
+// it was not matched against yet, every import is read once for all of them, and a term shared by several watchlists
+// is matched once
+func (app *appContext) evaluateWatchlists(ctx context.Context) error {
+	terms, err := app.ds.GetWatchTerms(ctx)
+	if err != nil || len(terms) == 0 {
+		return err
+	}
+	through := terms[len(terms)-1].ID
+	imports, err := app.ds.GetPendingWatchImports(ctx, through)
+	if err != nil {
+		return err
+	}
+	var matched int
+	for _, wi := range imports {
+		var pending []datastore.WatchTerm
+		for _, t := range terms {
+			if t.ID > wi.TermsThrough {
+				pending = append(pending, t)
+			}
+		}
+		suffix := "." + strings.ToLower(wi.Zone)
+		var termIDs, domainIDs []int64
+		err = app.ds.StreamNewDomains(ctx, wi, func(id int64, domain string) error {
+			label := strings.TrimSuffix(domain, suffix)
+			for _, t := range pending {
+				if watchMatches(t.Kind, t.Term, label) {
+					termIDs = append(termIDs, t.ID)
+					domainIDs = append(domainIDs, id)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = app.ds.AddWatchMatches(ctx, wi, termIDs, domainIDs, through)
+		if err != nil {
+			return err
+		}
+		matched += len(termIDs)
+	}
+	logging.Debugf("watchlists: matched %d imports against %d terms, %d matches", len(imports), len(terms), matched)
+	return nil
+}
+
+// watchlistOwner returns the name of the API key of the request, the owner of its watchlists
+// requests without a key are answered with ErrAPIKeyRequired
+func watchlistOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	owner := server.RequestAPIKey(r.Context())
+	if owner == "" {
+		server.WriteJSONError(w, server.ErrAPIKeyRequired)
+		return "", false
+	}
+	return owner, true
+}
+
+// watchlistBody is the definition of a new watchlist, its query has one of prefix, contains or similar_to
+type watchlistBody struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Query struct {
+		Prefix    string `json:"prefix" validate:"pattern=[A-Za-z0-9-]{4,63}"`
+		Contains  string `json:"contains" validate:"pattern=[A-Za-z0-9-]{4,63}"`
+		SimilarTo string `json:"similar_to" validate:"pattern=[A-Za-z0-9-]{4,63}"`
+	} `json:"query" validate:"required"`
+}
+
+// apiWatchlistCreateHandler adds a watchlist to the API key of the request
+// the body is {"name": "acme", "query": {"prefix": "acme"}}, decoded by server.Body
+// every key may have watchlistsPerKey watchlists, watchlistsExpensivePerKey of them expensive
+func (app *appContext) apiWatchlistCreateHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := watchlistOwner(w, r)
+	if !ok {
+		return
+	}
+	req := server.RequestBody(r).(*watchlistBody)
+	name := strings.TrimSpace(req.Name)
+	if name == "" || !params.ValidText(name) {
+		server.WriteJSONError(w, server.NewFieldError("name", "must be text that is not blank"))
+		return
+	}
+	var kind, term string
+	var set int
+	for k, t := range map[string]string{watchPrefix: req.Query.Prefix, watchContains: req.Query.Contains, watchSimilar: req.Query.SimilarTo} {
+		if t != "" {
+			kind, term = k, strings.ToLower(t)
+			set++
+		}
+	}
+	if set != 1 {
+		server.WriteJSONError(w, server.NewFieldError("query", "must have one of prefix, contains or similar_to"))
+		return
+	}
+
+	counts, err := app.ds.CountWatchlists(r.Context(), owner)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+	class := watchCostClass(kind)
+	if total >= app.watchlistsPerKey {
+		server.WriteJSONError(w, server.WithMeta(server.ErrWatchlistLimit, map[string]string{"limit": strconv.Itoa(app.watchlistsPerKey)}))
+		return
+	}
+	if class == costExpensive && counts[watchSimilar] >= app.watchlistsExpensivePerKey {
+		meta := map[string]string{"limit": strconv.Itoa(app.watchlistsExpensivePerKey), "cost_class": class}
+		server.WriteJSONError(w, server.WithMeta(server.ErrWatchlistLimit, meta))
+		return
+	}
+	wl, err := app.ds.CreateWatchlist(r.Context(), owner, name, kind, term)
+	if err == datastore.ErrNameTaken {
+		server.WriteJSONError(w, server.ErrWatchlistExists)
+		return
+	}
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	describeWatchlist(wl)
+	logging.Infof("watchlists: %s added watchlist %d %q, %s %q", owner, wl.ID, name, kind, term)
+	server.WriteJSONStatus(w, http.StatusCreated, wl)
+}
+
+// apiWatchlistsHandler lists the watchlists of the API key of the request
+func (app *appContext) apiWatchlistsHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := watchlistOwner(w, r)
+	if !ok {
+		return
+	}
+	watchlists, err := app.ds.GetWatchlists(r.Context(), owner)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	for _, wl := range watchlists {
+		describeWatchlist(wl)
+	}
+	server.WriteJSON(w, &model.Watchlists{Watchlists: watchlists})
+}
+
+// ownWatchlist returns the watchlist of the {id} path parameter if it belongs to the API key of the request
+// the watchlists of other keys are not found
+func (app *appContext) ownWatchlist(w http.ResponseWriter, r *http.Request) (*model.Watchlist, bool) {
+	owner, ok := watchlistOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, jsonErr := params.ID(r, "id")
+	if invalidParam(w, jsonErr) {
+		return nil, false
+	}
+	wl, err := app.ds.GetWatchlist(r.Context(), owner, id)
+	if err != nil {
+		app.writeError(w, err)
+		return nil, false
+	}
+	describeWatchlist(wl)
+	return wl, true
+}
+
+// apiWatchlistHandler returns a watchlist of the API key of the request
+func (app *appContext) apiWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	wl, ok := app.ownWatchlist(w, r)
+	if !ok {
+		return
+	}
+	server.WriteJSON(w, wl)
+}
+
+// apiWatchlistDeleteHandler removes a watchlist of the API key of the request
+func (app *appContext) apiWatchlistDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := watchlistOwner(w, r)
+	if !ok {
+		return
+	}
+	id, jsonErr := params.ID(r, "id")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	err := app.ds.DeleteWatchlist(r.Context(), owner, id)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	logging.Infof("watchlists: %s deleted watchlist %d", owner, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiWatchlistMatchesHandler returns a page of the domains matched by a watchlist that still have an active delegation,
+// in name order, ?limit= sets the page size and ?cursor= continues from the next_cursor of the previous page
+// only the new domains of the imports the job matched are known, domains of restricted zones the key has no scope for are left out
+func (app *appContext) apiWatchlistMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	wl, ok := app.ownWatchlist(w, r)
+	if !ok {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxWatchPageSize, watchPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	filter := cursor.Filter("watchlist_matches", strconv.FormatInt(wl.ID, 10))
+	var after string
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		last, err := app.cursors.Decode(token, "domain", filter)
+		if err != nil || len(last) != 1 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+		after = last[0]
+	}
+	data, ok := app.watchlistMatches(w, r, wl)
+	if !ok {
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	matches, err := app.ds.GetWatchMatches(r.Context(), wl.TermID, after, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		data.NextCursor = app.cursors.Encode("domain", []string{matches[limit-1].Domain}, filter)
+	}
+	data.Matches = app.visibleMatches(r, matches)
+
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Matches)), server.NextCursor(data.NextCursor))
+}
+
+// apiWatchlistNewMatchesHandler returns a page of the domains a watchlist matched from ?since= on, in the order they were matched
+// since is a RFC 3339 time or any date ParseDateParam accepts, clients polling pass the evaluated_at of their previous poll
+func (app *appContext) apiWatchlistNewMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	wl, ok := app.ownWatchlist(w, r)
+	if !ok {
+		return
+	}
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		server.WriteJSONError(w, server.NewFieldError("since", "is required"))
+		return
+	}
+	// a RFC 3339 time is kept as is, other dates start at their UTC day
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		var jsonErr *model.JSONError
+		since, jsonErr = server.ParseDateParam("since", value)
+		if invalidParam(w, jsonErr) {
+			return
+		}
+	}
+	since = since.UTC()
+	limit, jsonErr := params.Int(r, "limit", 1, maxWatchPageSize, watchPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	filter := cursor.Filter("watchlist_new", strconv.FormatInt(wl.ID, 10), since.Format(time.RFC3339Nano))
+	afterTime, afterDomain := since, ""
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		last, err := app.cursors.Decode(token, "matched_at", filter)
+		if err == nil && len(last) == 2 {
+			afterTime, err = time.Parse(time.RFC3339Nano, last[0])
+		}
+		if err != nil || len(last) != 2 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+		afterDomain = last[1]
+	}
+	data, ok := app.watchlistMatches(w, r, wl)
+	if !ok {
+		return
+	}
+	data.Since = model.NewTimestamp(since)
+	matches, err := app.ds.GetNewWatchMatches(r.Context(), wl.TermID, since, afterTime, afterDomain, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		last := matches[limit-1]
+		data.NextCursor = app.cursors.Encode("matched_at", []string{last.MatchedAt.Time.Format(time.RFC3339Nano), last.Domain}, filter)
+	}
+	data.Matches = app.visibleMatches(r, matches)
+
+	server.WriteJSONWithMeta(w, data, server.Count(len(data.Matches)), server.NextCursor(data.NextCursor))
+}
+
+// watchlistMatches returns the response of the matches of a watchlist, with when its query was last evaluated
+func (app *appContext) watchlistMatches(w http.ResponseWriter, r *http.Request, wl *model.Watchlist) (*model.WatchlistMatches, bool) {
+	evaluated, err := app.ds.GetWatchTermEvaluatedAt(r.Context(), wl.TermID)
+	if err != nil {
+		app.writeError(w, err)
+		return nil, false
+	}
+	return &model.WatchlistMatches{Watchlist: wl.ID, EvaluatedAt: evaluated}, true
+}
+
+// visibleMatches removes the matches in restricted zones the request may not read
+func (app *appContext) visibleMatches(r *http.Request, matches []*model.WatchlistMatch) []*model.WatchlistMatch {
+	out := matches[:0]
+	for _, m := range matches {
+		if app.zones.Check(r, m.Domain) == nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -1,29 +1,236 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"dnscoffee/app/temfun"
+	"dnscoffee/cursor"
 	"dnscoffee/datastore"
+	"dnscoffee/logging"
 	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/provider"
+	"dnscoffee/refcache"
 	"dnscoffee/server"
+	"dnscoffee/specialuse"
 	"dnscoffee/version"
-
-	"github.com/gorilla/mux"
-	"golang.org/x/net/idna"
 )
 
 // object to hold application context and persistent storage
 type appContext struct {
-	ds *datastore.DataStore
+	ds store
 
-	// used for creating the API index
-	api map[string]string
+	// used for creating the API index, by version
+	api map[int]map[string]string
 
 	templates *template.Template
+
+	// refreshes materialized views
+	views *viewRefresher
+
+	// caches the feeds by date
+	feeds *server.ResponseCache
+	// how long the feeds and nameserver statistics of today and the live NS sets are cached
+	ttls *CacheTTLs
+
+	// caches the domain count histories of nameservers
+	nameServerStats *server.ResponseCache
+
+	// per-domain data of restricted zones is only served to API keys with their scopes
+	zones *server.ZoneAccess
+
+	// *model.ImportProgress precomputed by the stats job, unset until its first run
+	stats atomic.Value
+
+	// classifies nameservers by provider
+	providers *provider.Table
+	// *model.ProviderCounts precomputed by the providers job, unset until its first run
+	providerCounts atomic.Value
+	// *lifetimeStats precomputed by the lifetimes job, unset until its first run
+	lifetimes atomic.Value
+
+	// caches the zone diffs and computes them outside of the requests
+	diffs       *zoneDiffs
+	diffMaxDays int
+	cursors     *cursor.Codec
+	checkpoints *cursor.Codec
+	// caches the churn counts of the zones, computed like the zone diffs
+	churns *zoneChurns
+
+	// notifications of finished imports from the zone importer
+	imports *importHooks
+
+	// pre-generated feed downloads
+	exports *feedExports
+
+	// 404s of the exact-match lookups of names never seen, nil when disabled
+	negatives *negativeCache
+
+	// when the latest import of every zone finished, for the age of the data of the responses
+	freshness *freshness
+
+	// reference data refreshed after every import and config reload: the zone list of /zones and the zone IDs
+	// of the zone query parameters
+	zoneList *refcache.Value[*model.ZoneImportResults]
+	zoneIDs  *refcache.Value[map[string]int64]
+	// the known sources of the imports, and the source of the latest import of every zone for the restrictions by source
+	sources     map[string]bool
+	zoneSources *refcache.Value[map[string]string]
+
+	// the sanity checks of the imports, for the alerts and the suspect flag of the responses
+	importChecks *importChecks
+
+	// addresses the main listeners are bound to, for /api/version
+	listenAddrs func() []string
+
+	// keywords counted in the new domains by the keywords job
+	keywords map[string]bool
+
+	// watchlists an API key may have, and of those how many expensive ones
+	watchlistsPerKey          int
+	watchlistsExpensivePerKey int
+
+	// live NS queries of the /domains/{domain}/live route, nil when disabled
+	liveDNS                  *liveDNS
+	liveDNSRequestsPerMinute int
+	liveDNSRequestsBurst     int
+
+	// the routing table of the asn field of the IP addresses and of /asn/{asn}, nil when ASNFile is empty
+	routing *routingTable
+}
+
+// Config holds the application settings
+type Config struct {
+	// number of feed responses cached, 0 disables the cache
+	FeedCacheSize int
+	// number of nameserver domain count histories cached, 0 disables the cache
+	NameServerStatsCacheSize int
+	// how long feeds for today are cached, feeds for past dates never change and do not expire
+	FeedCacheTTL time.Duration
+	// how often the import progress is precomputed, 0 queries it on every request
+	StatsInterval time.Duration
+	// nameserver provider patterns, the table may be replaced at runtime, provider.Defaults are used when nil
+	Providers *provider.Table
+	// FeedCacheTTL and LiveDNSCacheTTL, they may be replaced at runtime, the two fields are used when nil
+	CacheTTLs *CacheTTLs
+	// how often the domains per provider are precomputed, 0 queries them on every request
+	ProviderStatsInterval time.Duration
+	// longest span between the dates of a zone diff, 0 is unlimited
+	ZoneDiffMaxDays int
+	// number of zone diffs cached
+	ZoneDiffCacheSize int
+	// how long computing a zone diff may take, longer than the request timeout
+	ZoneDiffTimeout time.Duration
+	// directory the feed downloads of the past FeedExportDays dates are pre-generated in every FeedExportInterval,
+	// downloads are streamed from the database when empty
+	FeedExportDir      string
+	FeedExportDays     int
+	FeedExportInterval time.Duration
+	// URL the FeedExportDir files are also published at with their checksum files, listed in the bulk manifest
+	FeedExportBaseURL string
+	// size in bytes of the bloom filter of every name answering the lookups of names never seen, 0 disables it,
+	// rebuilt every NegativeCacheInterval when an import landed, NegativeCacheSize recent misses are kept as well
+	NegativeCacheBytes    int64
+	NegativeCacheSize     int
+	NegativeCacheInterval time.Duration
+	// how often the nameserver sets of the domains are indexed by fingerprint
+	NameServerSetsInterval time.Duration
+	// how often the domain lifetime histograms are precomputed, 0 queries them on every request
+	LifetimesInterval time.Duration
+	// how often the glue of the latest imports is checked for anomalies, import notifications also start it
+	GlueInterval time.Duration
+	// lower case keywords counted in the names of the new domains every KeywordsInterval, import notifications also start it
+	Keywords         []string
+	KeywordsInterval time.Duration
+	// the sources the zone files are imported from, recorded from the import notifications and filtered on by ?source=
+	Sources []string
+	// watchlists an API key may have, of those how many expensive ones, and how often the new imports are matched
+	// against them, import notifications also start it
+	WatchlistsPerKey          int
+	WatchlistsExpensivePerKey int
+	WatchlistsInterval        time.Duration
+	// serve /domains/{domain}/live, comparing the zone file nameservers with a live NS query sent to LiveDNSResolver,
+	// an ip:port, or the system resolver when empty
+	LiveDNSEnabled  bool
+	LiveDNSResolver string
+	LiveDNSTimeout  time.Duration
+	// number of live NS sets cached and for how long, 0 disables the cache
+	LiveDNSCacheSize int
+	LiveDNSCacheTTL  time.Duration
+	// per client quota of the live route, on top of the API quota
+	LiveDNSRequestsPerMinute int
+	LiveDNSRequestsBurst     int
+	// responses whose data was last imported more than StaleDataAfter ago carry a warning, 0 never warns;
+	// when the zones were last imported is read every FreshnessInterval, import notifications also start it
+	StaleDataAfter    time.Duration
+	FreshnessInterval time.Duration
+	// the domains every finished import added and removed are compared with the averages of the ImportCheckTrailing
+	// previous imports of its zone every ImportChecksInterval, import notifications also start it; a count more than
+	// 1+threshold times its average or less than the average divided by it, and off by ImportCheckMinChange domains,
+	// makes the import suspect, a threshold of 0 never does; zones with fewer than ImportCheckMinHistory imports are not judged
+	ImportCheckTrailing         int
+	ImportCheckMinHistory       int
+	ImportCheckAddedThreshold   float64
+	ImportCheckRemovedThreshold float64
+	ImportCheckMinChange        int64
+	ImportChecksInterval        time.Duration
+	// the label statistics of every finished import are counted every LabelStatsInterval, import notifications also start it
+	LabelStatsInterval time.Duration
+	// the domains of every finished import whose delegations changed are scored for ?min_stability= every
+	// StabilityInterval, import notifications also start it
+	StabilityInterval time.Duration
+	// prefix to AS file, gzipped when named .gz, loaded every ASNInterval when it changed, for the asn field of the IP
+	// addresses and /asn/{asn}; more than ASNMaxPrefixes prefixes, unless 0, is rejected to bound the memory it holds
+	ASNFile        string
+	ASNInterval    time.Duration
+	ASNMaxPrefixes int
+}
+
+// DefaultConfig is the default application configuration
+var DefaultConfig = Config{
+	FeedCacheSize:               1000,
+	FeedCacheTTL:                5 * time.Minute,
+	NameServerStatsCacheSize:    1000,
+	StatsInterval:               time.Minute,
+	ProviderStatsInterval:       time.Hour,
+	ZoneDiffMaxDays:             90,
+	ZoneDiffCacheSize:           32,
+	ZoneDiffTimeout:             5 * time.Minute,
+	FeedExportDays:              7,
+	FeedExportInterval:          time.Hour,
+	NameServerSetsInterval:      24 * time.Hour,
+	LifetimesInterval:           24 * time.Hour,
+	GlueInterval:                24 * time.Hour,
+	KeywordsInterval:            time.Hour,
+	Sources:                     []string{"czds", "registry_ftp", "axfr"},
+	WatchlistsPerKey:            20,
+	WatchlistsExpensivePerKey:   5,
+	WatchlistsInterval:          time.Hour,
+	NegativeCacheSize:           10000,
+	NegativeCacheInterval:       time.Minute,
+	LiveDNSTimeout:              5 * time.Second,
+	LiveDNSCacheSize:            10000,
+	LiveDNSCacheTTL:             5 * time.Minute,
+	LiveDNSRequestsPerMinute:    10,
+	LiveDNSRequestsBurst:        5,
+	StaleDataAfter:              48 * time.Hour,
+	FreshnessInterval:           time.Minute,
+	ImportCheckTrailing:         7,
+	ImportCheckMinHistory:       3,
+	ImportCheckAddedThreshold:   1,
+	ImportCheckRemovedThreshold: 1,
+	ImportCheckMinChange:        1000,
+	ImportChecksInterval:        10 * time.Minute,
+	LabelStatsInterval:          time.Hour,
+	StabilityInterval:           time.Hour,
+	ASNInterval:                 time.Hour,
+	ASNMaxPrefixes:              2000000,
 }
 
 // Page holds information for rendered HTML pages
@@ -35,13 +242,108 @@ type Page struct {
 
 // Start entry point for starting application
 // adds routes to the server so that the correct handlers are registered
-func Start(ds *datastore.DataStore, server *server.Server) {
+func Start(ds *datastore.DataStore, server *server.Server, conf Config) {
 	var app appContext
 	app.ds = ds
+	app.zones = server.ZoneAccess()
+	app.listenAddrs = server.ListenAddrs
 	// compile all templates and cache them
 	//app.templates = template.Must(template.ParseGlob("templates/*.tmpl").Funcs(temfun.Funcs))
 	app.templates = template.Must(template.New("main").Funcs(temfun.Funcs).ParseGlob("templates/*.tmpl"))
 
+	// report not ready while the database is unavailable
+	server.AddReadinessCheck("database", ds.Ready)
+
+	// background work is stopped when the server shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	server.OnShutdown(cancel)
+	app.views = newViewRefresher(ctx, ds)
+	app.views.start()
+
+	app.feeds = server.NewResponseCache("feeds", conf.FeedCacheSize)
+	app.ttls = conf.CacheTTLs
+	if app.ttls == nil {
+		app.ttls = NewCacheTTLs(TTLs{Feed: conf.FeedCacheTTL, LiveDNS: conf.LiveDNSCacheTTL})
+	}
+	app.nameServerStats = server.NewResponseCache("nameserver_stats", conf.NameServerStatsCacheSize)
+	if conf.StatsInterval > 0 {
+		server.AddJob("stats", conf.StatsInterval, app.precomputeStats)
+	}
+	app.providers = conf.Providers
+	if app.providers == nil {
+		classifier, err := provider.New(provider.Defaults)
+		if err != nil {
+			logging.Fatalf("providers: %s", err)
+		}
+		app.providers = provider.NewTable(classifier)
+	}
+	app.diffs = newZoneDiffs(ctx, ds, conf.ZoneDiffCacheSize, conf.ZoneDiffTimeout)
+	app.diffMaxDays = conf.ZoneDiffMaxDays
+	app.cursors = server.Cursors()
+	app.checkpoints = server.Checkpoints()
+	server.AddCacheFlusher("zone_diffs", app.diffs.flush)
+	app.churns = newZoneChurns(ctx, ds, conf.ZoneDiffTimeout)
+	server.AddCacheFlusher("zone_churns", app.churns.flush)
+	app.imports = newImportHooks(server)
+	app.zoneList = refcache.New("zones", ds.GetZoneImportResults)
+	server.AddReferenceData(app.zoneList)
+	app.zoneIDs = refcache.New("zone_ids", ds.GetZoneIDs)
+	server.AddReferenceData(app.zoneIDs)
+	app.sources = knownSources(conf.Sources)
+	app.zoneSources = refcache.New("zone_sources", ds.GetZoneSources)
+	server.AddReferenceData(app.zoneSources)
+	app.zones.SetSources(app.zoneSources.Get)
+	if conf.ProviderStatsInterval > 0 {
+		server.AddJob("providers", conf.ProviderStatsInterval, app.precomputeProviderStats)
+	}
+	app.exports = &feedExports{ds: ds, zones: app.zones, dir: conf.FeedExportDir, days: conf.FeedExportDays, baseURL: conf.FeedExportBaseURL}
+	if conf.FeedExportDir != "" {
+		server.AddJob("feed_exports", conf.FeedExportInterval, app.exports.run)
+	}
+	if conf.NegativeCacheBytes > 0 {
+		app.negatives = newNegativeCache(ds, conf.NegativeCacheBytes, conf.NegativeCacheSize)
+		server.AddCacheFlusher("negative_cache", app.negatives.flush)
+		server.AddJob("negative_cache", conf.NegativeCacheInterval, app.negatives.run)
+	}
+	server.AddJob("nssets", conf.NameServerSetsInterval, ds.RefreshNameServerSets)
+	server.AddJob("glue", conf.GlueInterval, app.checkGlue)
+	if conf.LifetimesInterval > 0 {
+		server.AddJob("lifetimes", conf.LifetimesInterval, app.precomputeLifetimes)
+	}
+	app.keywords = make(map[string]bool, len(conf.Keywords))
+	for _, keyword := range conf.Keywords {
+		app.keywords[keyword] = true
+	}
+	if len(conf.Keywords) > 0 {
+		server.AddJob("keywords", conf.KeywordsInterval, app.countKeywords(conf.Keywords))
+	}
+	app.watchlistsPerKey = conf.WatchlistsPerKey
+	app.watchlistsExpensivePerKey = conf.WatchlistsExpensivePerKey
+	server.AddJob("watchlists", conf.WatchlistsInterval, app.evaluateWatchlists)
+	app.freshness = newFreshness(ds, conf.StaleDataAfter)
+	server.AddJob("freshness", conf.FreshnessInterval, app.freshness.run)
+	app.importChecks = newImportChecks(ds, importCheckConfig{
+		trailing:         conf.ImportCheckTrailing,
+		minHistory:       conf.ImportCheckMinHistory,
+		addedThreshold:   conf.ImportCheckAddedThreshold,
+		removedThreshold: conf.ImportCheckRemovedThreshold,
+		minChange:        conf.ImportCheckMinChange,
+	})
+	server.AddJob("import_checks", conf.ImportChecksInterval, app.importChecks.run)
+	server.AddJob("label_stats", conf.LabelStatsInterval, app.countLabels)
+	server.AddJob("stability", conf.StabilityInterval, app.scoreStabilities)
+	if conf.ASNFile != "" {
+		app.routing = &routingTable{path: conf.ASNFile, maxPrefixes: conf.ASNMaxPrefixes}
+		server.AddJob("asn", conf.ASNInterval, app.routing.run)
+	}
+
+	if conf.LiveDNSEnabled {
+		app.liveDNS = newLiveDNS(newResolver(conf.LiveDNSResolver), conf.LiveDNSTimeout, app.ttls, conf.LiveDNSCacheSize)
+		app.liveDNSRequestsPerMinute = conf.LiveDNSRequestsPerMinute
+		app.liveDNSRequestsBurst = conf.LiveDNSRequestsBurst
+		server.AddCacheFlusher("live_dns", app.liveDNS.flush)
+	}
+
 	// load the api
 	APIStart(&app, server)
 
@@ -88,9 +390,16 @@ func (app *appContext) searchIndexHandler(w http.ResponseWriter, r *http.Request
 
 func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 	var s model.Search
-	s.Query = cleanDomain(r.FormValue("query"))
+	query, err := params.CleanDomain(r.FormValue("query"))
+	if err != nil || len(query) > params.MaxLength {
+		// an invalid name can not match anything, show the empty search page
+		query = ""
+	}
+	s.Query = query
 	s.Type = r.FormValue("type")
-	var err error
+	if n, ok := specialuse.Default.Classify(query); ok {
+		s.SpecialUse, s.SpecialUseRFC, s.SpecialUsePublic = n.Kind, n.RFC, n.Public
+	}
 
 	// since the root zone is the empty string, this prevents empty searches from redirecting to the zones page
 	if len(s.Query) > 0 {
@@ -153,10 +462,30 @@ func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// precomputeStats is the stats job, it stores the import progress to be served by importProgress
+func (app *appContext) precomputeStats(ctx context.Context) error {
+	data, err := app.ds.GetImportProgress(ctx)
+	if err != nil {
+		return err
+	}
+	app.stats.Store(data)
+	return nil
+}
+
+// importProgress returns a copy of the precomputed import progress, or queries it if it has not been computed yet
+func (app *appContext) importProgress(ctx context.Context) (*model.ImportProgress, error) {
+	if data, ok := app.stats.Load().(*model.ImportProgress); ok {
+		progress := *data
+		return &progress, nil
+	}
+	return app.ds.GetImportProgress(ctx)
+}
+
 func (app *appContext) statsHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := app.ds.GetImportProgress(r.Context())
+	data, err := app.importProgress(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	p := Page{"Stats", "", data}
@@ -169,7 +498,8 @@ func (app *appContext) statsHandler(w http.ResponseWriter, r *http.Request) {
 func (app *appContext) zoneIndexHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetZoneImportResults(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	p := Page{"Zones", "Zones", data}
@@ -182,7 +512,8 @@ func (app *appContext) zoneIndexHandler(w http.ResponseWriter, r *http.Request)
 func (app *appContext) tldIndexHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetZoneImportResults(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	p := Page{"TLDs", "Zones", data}
@@ -196,12 +527,9 @@ func (app *appContext) rootHandler(w http.ResponseWriter, r *http.Request) {
 	name := ""
 	data, err := app.ds.GetZone(r.Context(), name)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeError(w, err)
+		return
 	}
 	importData, err := app.ds.GetZoneImport(r.Context(), name)
 	if err == nil {
@@ -218,26 +546,28 @@ func (app *appContext) rootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *appContext) zoneHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["zone"])
+	name, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	data, err := app.ds.GetZone(r.Context(), name)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeError(w, err)
+		return
 	}
 	importData, err := app.ds.GetZoneImport(r.Context(), name)
 	if err == nil {
 		// TODO check for datastore.ErrNoResource and sql.NoRows
 		// TODO in fact, make ErrNoResource include? sql.NowRows as well
 		data.ImportData = importData
-
+	}
+	// the domains of restricted zones are not listed, the rest of the page is aggregate data
+	if err == nil && app.zones.Check(r, data.Name) == nil {
 		domains, err := app.ds.GetDomainsInZoneID(r.Context(), data.ID)
 		if err != nil {
-			panic(err)
+			app.writeError(w, err)
+			return
 		}
 		data.Domains = &domains
 	}
@@ -250,17 +580,20 @@ func (app *appContext) zoneHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *appContext) nameserverHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["nameserver"])
+	name, jsonErr := params.Domain(r, "nameserver")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	data, err := app.ds.GetNameServer(r.Context(), name)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeError(w, err)
+		return
 	}
+	app.classifyNameServers([]*model.NameServer{data})
+	app.routeNameServerIPs(data)
+	data.Domains = app.visibleDomains(r, data.Domains)
+	data.ArchiveDomains = app.visibleDomains(r, data.ArchiveDomains)
 
 	p := Page{name, "Records", data}
 	err = app.templates.ExecuteTemplate(w, "nameserver.tmpl", p)
@@ -271,17 +604,24 @@ func (app *appContext) nameserverHandler(w http.ResponseWriter, r *http.Request)
 
 // domainHandler returns domain object for the queried domain
 func (app *appContext) domainHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
+	domain, jsonErr := params.Domain(r, "domain")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	if neverDelegated(w, domain) {
+		return
+	}
+	if app.zoneForbidden(w, r, domain) {
+		return
+	}
 	data, err := app.ds.GetDomain(r.Context(), domain)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeLookupError(w, domain, err)
+		return
 	}
+	app.classifyNameServers(data.NameServers, data.ArchiveNameServers)
+	data.SpecialUse = specialUse(domain)
 
 	p := Page{domain, "Records", data}
 	err = app.templates.ExecuteTemplate(w, "domain.tmpl", p)
@@ -292,17 +632,18 @@ func (app *appContext) domainHandler(w http.ResponseWriter, r *http.Request) {
 
 // ipHandler returns ip object for the queried domain
 func (app *appContext) ipHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["ip"])
+	ip, jsonErr := params.IP(r, "ip")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	name := ip.String()
 	data, err := app.ds.GetIP(r.Context(), name)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeError(w, err)
+		return
 	}
+	app.routeIPs(data)
 
 	p := Page{name, "Records", data}
 	err = app.templates.ExecuteTemplate(w, "ip.tmpl", p)
@@ -335,9 +676,15 @@ func (app *appContext) searchTrendsHandler(w http.ResponseWriter, r *http.Reques
 func (app *appContext) prefixHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var data *model.PrefixList
-	params := mux.Vars(r)
-	prefixType := strings.ToLower(params["type"])
-	name := cleanDomain(params["prefix"])
+	prefixType, jsonErr := params.Path(r, "type")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	prefixType = strings.ToLower(prefixType)
+	name, jsonErr := params.Domain(r, "prefix")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 	if prefixType == "active" {
 		data, err = app.ds.GetTakenPrefixes(r.Context(), name)
 
@@ -348,12 +695,9 @@ func (app *appContext) prefixHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		// TODO make http err (not json)
+		app.writeError(w, err)
+		return
 	}
 
 	p := Page{name + " Prefix", "Search", data}
@@ -409,16 +753,15 @@ func (app *appContext) ipIndexHandler(w http.ResponseWriter, r *http.Request) {
 
 // research
 func (app *appContext) ipNsZoneCountHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
+	ip, jsonErr := params.IP(r, "ip")
+	if invalidParam(w, jsonErr) {
+		return
+	}
 
-	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip)
+	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip.String())
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 
 	p := Page{"IP NS Zone Count", "Research", data}
@@ -436,21 +779,11 @@ func (app *appContext) trustTreeHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// helper
-func cleanDomain(domain string) string {
-	domain = strings.TrimSpace(domain)
-	domain, err := idna.ToASCII(domain)
-	if err != nil {
-		panic(err)
-	}
-	domain = strings.ToUpper(domain)
-	return domain
-}
-
 func (app *appContext) tldGraveyardIndexHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetDeadTLDs(r.Context())
 	if err != nil {
-		panic(err)
+		app.writeError(w, err)
+		return
 	}
 	p := Page{"TLD Graveyard", "Zones", data}
 	err = app.templates.ExecuteTemplate(w, "tld_graveyard.tmpl", p)
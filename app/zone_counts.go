@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+const (
+	// maxZoneCountDates is the most dates of a ?dates= batch
+	maxZoneCountDates = 100
+	// maxZoneCountAge is how old the latest import before a date may be, older imports leave a gap
+	maxZoneCountAge = 7 * 24 * time.Hour
+)
+
+// zoneCountDates returns the dates of ?date= or of the comma separated ?dates= batch, batch is true for the latter
+func zoneCountDates(r *http.Request) (dates []time.Time, batch bool, jsonErr *model.JSONError) {
+	query := r.URL.Query()
+	single, list := query.Get("date"), query.Get("dates")
+	switch {
+	case single != "" && list != "":
+		return nil, false, server.NewFieldError("dates", "can not be given with date")
+	case single != "":
+		date, jsonErr := server.ParseDateParam("date", single)
+		if jsonErr != nil {
+			return nil, false, jsonErr
+		}
+		return []time.Time{date}, false, nil
+	case list != "":
+		values := strings.Split(list, ",")
+		if len(values) > maxZoneCountDates {
+			return nil, true, server.NewFieldError("dates", fmt.Sprintf("must list at most %d dates", maxZoneCountDates))
+		}
+		dates = make([]time.Time, 0, len(values))
+		for _, value := range values {
+			date, jsonErr := server.ParseDateParam("dates", value)
+			if jsonErr != nil {
+				return nil, true, jsonErr
+			}
+			dates = append(dates, date)
+		}
+		return dates, true, nil
+	}
+	return nil, false, server.NewFieldError("date", "is required")
+}
+
+// zoneCountAsOf returns the count of an as-of lookup, or its error when date is before the first import or in a gap
+func zoneCountAsOf(zone string, c *datastore.ZoneCountAt) (*model.ZoneCountAsOf, *model.JSONError) {
+	count := &model.ZoneCountAsOf{Zone: zone, Date: model.NewDate(c.Date)}
+	if !c.Found {
+		if c.NextImportDate == nil {
+			return count, server.ErrBeforeFirstImport
+		}
+		jsonErr := *server.ErrBeforeFirstImport
+		jsonErr.Meta = map[string]string{"first_import": c.NextImportDate.Format("2006-01-02")}
+		return count, &jsonErr
+	}
+	if c.Date.Sub(c.ImportDate) >= maxZoneCountAge {
+		jsonErr := *server.ErrImportGap
+		jsonErr.Meta = map[string]string{"previous_import": c.ImportDate.Format("2006-01-02")}
+		if c.NextImportDate != nil {
+			jsonErr.Meta["next_import"] = c.NextImportDate.Format("2006-01-02")
+		}
+		return count, &jsonErr
+	}
+	domains := c.Domains
+	count.ImportID, count.ImportDate, count.Domains = c.ImportID, model.NewDate(c.ImportDate), &domains
+	return count, nil
+}
+
+// apiZoneCountHandler returns the number of domains of a zone as of ?date=, counted by the latest import at or before it
+// dates before the first import of the zone and dates whose latest import is a week old or more answer distinct 404 errors
+// ?dates= takes a comma separated batch of dates and returns the count or error of each, the dates whose lookup failed
+// with a transient database error included unless most of them did; the counts are aggregates and also served for
+// restricted zones
+func (app *appContext) apiZoneCountHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	dates, batch, jsonErr := zoneCountDates(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	today := server.Today()
+	for _, date := range dates {
+		if date.After(today) {
+			name := "date"
+			if batch {
+				name = "dates"
+			}
+			server.WriteJSONError(w, server.NewFieldError(name, "must not be in the future"))
+			return
+		}
+	}
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if !batch {
+		c, err := app.ds.GetZoneCountAt(r.Context(), zoneID, dates[0])
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		count, jsonErr := zoneCountAsOf(zone, c)
+		if jsonErr != nil {
+			server.WriteJSONError(w, jsonErr)
+			return
+		}
+		server.WriteJSON(w, count)
+		return
+	}
+	counts, err := app.ds.GetZoneCountsAt(r.Context(), zoneID, dates)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data := &model.ZoneCountAsOfSeries{Zone: zone, Counts: make([]*model.ZoneCountAsOf, 0, len(counts))}
+	for _, c := range counts {
+		if c.Err != nil {
+			data.Failed++
+			data.Counts = append(data.Counts, &model.ZoneCountAsOf{Zone: zone, Date: model.NewDate(c.Date), Error: batchItemError(c.Err)})
+			continue
+		}
+		count, jsonErr := zoneCountAsOf(zone, c)
+		count.Error = jsonErr
+		data.Counts = append(data.Counts, count)
+	}
+	if batchFailed(w, data.Failed, len(counts), app.ds.RetryAfter()) {
+		return
+	}
+	data.Partial = data.Failed > 0
+	server.WriteJSONWithMeta(w, data, server.FailedItems(data.Failed))
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+)
+
+// zoneCountStore counts the 100 domains of ORG from its import on importDate, the lookups of the dates in failing
+// fail with their transient error and err fails every lookup
+type zoneCountStore struct {
+	fakeStore
+	importDate time.Time
+	failing    map[string]error
+	err        error
+}
+
+func (s *zoneCountStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	if name != "ORG" {
+		return 0, datastore.ErrNoResource
+	}
+	return 3, nil
+}
+
+func (s *zoneCountStore) GetZoneCountAt(ctx context.Context, zoneID int64, date time.Time) (*datastore.ZoneCountAt, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	c := &datastore.ZoneCountAt{Date: date}
+	if date.Before(s.importDate) {
+		c.NextImportDate = &s.importDate
+		return c, nil
+	}
+	c.Found, c.ImportID, c.ImportDate, c.Domains = true, 42, s.importDate, 100
+	return c, nil
+}
+
+func (s *zoneCountStore) GetZoneCountsAt(ctx context.Context, zoneID int64, dates []time.Time) ([]*datastore.ZoneCountAt, error) {
+	counts := make([]*datastore.ZoneCountAt, 0, len(dates))
+	for _, date := range dates {
+		if err := s.failing[date.Format(model.DateFormat)]; err != nil {
+			counts = append(counts, &datastore.ZoneCountAt{Date: date, Err: err})
+			continue
+		}
+		c, err := s.GetZoneCountAt(ctx, zoneID, date)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+func TestZoneCountHandler(t *testing.T) {
+	importDate := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(model.DateFormat)
+	unavailable := datastore.ErrDatabaseUnavailable
+	tests := []struct {
+		name    string
+		zone    string
+		query   string
+		failing map[string]error
+		err     error
+		want    int
+		code    string
+		// the domains counted or the error code of each date of a batch, and the dates that failed
+		wantItems  []string
+		wantFailed int
+	}{
+		{name: "date", zone: "org", query: "?date=2024-05-08", want: http.StatusOK, wantItems: []string{"100"}},
+		{name: "before the first import", zone: "org", query: "?date=2024-05-01", want: http.StatusNotFound, code: "before_first_import"},
+		{name: "import gap", zone: "org", query: "?date=2024-05-20", want: http.StatusNotFound, code: "import_gap"},
+		{name: "date lookup fails", zone: "org", query: "?date=2024-05-08", err: unavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+
+		{name: "no date", zone: "org", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "date and dates", zone: "org", query: "?date=2024-05-08&dates=2024-05-08", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid date", zone: "org", query: "?dates=2024-05-08,8/5/2024", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "future date", zone: "org", query: "?dates=2024-05-08," + tomorrow, want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "too many dates", zone: "org", query: "?dates=" + strings.Repeat("2024-05-08,", maxZoneCountDates) + "2024-05-08", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid zone", zone: "xn--bcher-kvaü", query: "?date=2024-05-08", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "unknown zone", zone: "xyz", query: "?date=2024-05-08", want: http.StatusNotFound, code: "resource_not_found"},
+
+		// the dates without a count have their error and the batch is not partial
+		{name: "batch", zone: "org", query: "?dates=2024-05-01,2024-05-08,2024-05-20", want: http.StatusOK,
+			wantItems: []string{"before_first_import", "100", "import_gap"}},
+		{name: "one date fails", zone: "org", query: "?dates=2024-05-07,2024-05-08,2024-05-09", failing: map[string]error{"2024-05-08": unavailable}, want: http.StatusOK,
+			wantItems: []string{"100", "database_unavailable", "100"}, wantFailed: 1},
+		{name: "date times out", zone: "org", query: "?dates=2024-05-07,2024-05-08,2024-05-09", failing: map[string]error{"2024-05-09": context.DeadlineExceeded}, want: http.StatusOK,
+			wantItems: []string{"100", "100", "timeout"}, wantFailed: 1},
+		{name: "half the dates fail", zone: "org", query: "?dates=2024-05-07,2024-05-08,2024-05-09,2024-05-10",
+			failing: map[string]error{"2024-05-07": unavailable, "2024-05-10": unavailable}, want: http.StatusOK,
+			wantItems: []string{"database_unavailable", "100", "100", "database_unavailable"}, wantFailed: 2},
+		{name: "most dates fail", zone: "org", query: "?dates=2024-05-07,2024-05-08,2024-05-09",
+			failing: map[string]error{"2024-05-07": unavailable, "2024-05-09": context.DeadlineExceeded}, want: http.StatusServiceUnavailable, code: "database_unavailable", wantFailed: 2},
+		{name: "batch fails", zone: "org", query: "?dates=2024-05-07,2024-05-08", err: unavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &zoneCountStore{importDate: importDate, failing: tt.failing, err: tt.err}
+			app := &appContext{ds: ds}
+			w := httptest.NewRecorder()
+			app.apiZoneCountHandler(w, varsRequest("/api/zones/"+tt.zone+"/count"+tt.query, map[string]string{"zone": tt.zone}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" {
+				var body model.JSONErrors
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if len(body.Errors) != 1 || body.Errors[0].ID != tt.code {
+					t.Fatalf("got %s, want the error %s", w.Body, tt.code)
+				}
+				// a batch mostly failed is retried as a whole
+				if tt.wantFailed > 0 {
+					if body.Errors[0].Meta["failed_items"] != "2" || w.Header().Get("Retry-After") != "30" {
+						t.Errorf("got Retry-After %q %s, want 30 and meta.failed_items", w.Header().Get("Retry-After"), w.Body)
+					}
+				}
+				return
+			}
+			if !strings.Contains(tt.query, "dates=") {
+				var resp struct{ Data model.ZoneCountAsOf }
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Data.Domains == nil || *resp.Data.Domains != 100 || resp.Data.ImportID != 42 {
+					t.Errorf("got %s, want the 100 domains of import 42", w.Body)
+				}
+				return
+			}
+			var resp struct{ Data model.ZoneCountAsOfSeries }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Data.Failed != tt.wantFailed || resp.Data.Partial != (tt.wantFailed > 0) {
+				t.Errorf("got partial %t with %d failed, want %d failed", resp.Data.Partial, resp.Data.Failed, tt.wantFailed)
+			}
+			if len(resp.Data.Counts) != len(tt.wantItems) {
+				t.Fatalf("got %d counts, want %d: %s", len(resp.Data.Counts), len(tt.wantItems), w.Body)
+			}
+			for i, c := range resp.Data.Counts {
+				got := ""
+				switch {
+				case c.Error != nil:
+					got = c.Error.ID
+				case c.Domains != nil:
+					got = "100"
+					if *c.Domains != 100 {
+						got = "wrong count"
+					}
+				}
+				if got != tt.wantItems[i] {
+					t.Errorf("count %d: got %q, want %q", i, got, tt.wantItems[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"dnscoffee/cursor"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/server"
+)
+
+// zoneDomainsPageSize is the default and maxZoneDomainsPageSize the largest number of domains on a page of a zone listing
+const (
+	zoneDomainsPageSize    = 1000
+	maxZoneDomainsPageSize = 10000
+)
+
+// largeZoneDomains is the size of the latest import above which a zone listing only estimates its total
+const largeZoneDomains = 1000000
+
+// zoneDomainsActive returns the ?active= filter, nil for all domains
+func zoneDomainsActive(r *http.Request) (string, *bool, *model.JSONError) {
+	yes, no := true, false
+	switch value := r.URL.Query().Get("active"); value {
+	case "", "all":
+		return "all", nil, nil
+	case "1":
+		return value, &yes, nil
+	case "0":
+		return value, &no, nil
+	}
+	return "", nil, server.NewFieldError("active", "must be 1, 0 or all")
+}
+
+// apiZoneDomainsHandler returns a page of the domains of a zone in name order
+// ?active=1 only lists domains with an active delegation and ?active=0 those without, ?limit= sets the page size,
+// ?cursor= continues from the next_cursor of the previous page and ?format= is json, csv or ndjson
+// pages continue after the last name of the previous page, an import landing mid-walk does not repeat or skip the domains both imports have
+func (app *appContext) apiZoneDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	zone, jsonErr := params.Domain(r, "zone")
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	filter, active, jsonErr := zoneDomainsActive(r)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	limit, jsonErr := params.Int(r, "limit", 1, maxZoneDomainsPageSize, zoneDomainsPageSize)
+	if invalidParam(w, jsonErr) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv", "ndjson":
+	default:
+		server.WriteJSONError(w, server.NewFieldError("format", "must be json, csv or ndjson"))
+		return
+	}
+	if app.zoneForbidden(w, r, zone) {
+		return
+	}
+	cursorFilter := cursor.Filter("zone_domains", zone, filter)
+	token := r.URL.Query().Get("cursor")
+	var after string
+	if token != "" {
+		last, err := app.cursors.Decode(token, "domain", cursorFilter)
+		if err != nil || len(last) != 1 {
+			server.WriteJSONError(w, server.ErrInvalidCursor)
+			return
+		}
+		after = last[0]
+	}
+
+	zoneID, err := app.ds.GetZoneID(r.Context(), zone)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	data := &model.ZoneDomains{Zone: zone, Active: filter}
+	if token == "" {
+		estimate, err := app.ds.GetZoneDomainEstimate(r.Context(), zoneID)
+		if err != nil {
+			app.writeError(w, err)
+			return
+		}
+		if estimate > largeZoneDomains {
+			data.TotalEstimate = &estimate
+			data.Notice = "this zone is too large to count, walking it takes many requests: prefer a bulk zone file download"
+		} else {
+			total, err := app.ds.CountZoneDomains(r.Context(), zoneID, active)
+			if err != nil {
+				app.writeError(w, err)
+				return
+			}
+			data.Total = &total
+		}
+	}
+	if format == "json" {
+		app.streamZoneDomains(w, r, data, zoneID, active, after, limit, cursorFilter)
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	data.Domains, err = app.ds.GetZoneDomains(r.Context(), zoneID, active, after, limit+1)
+	if err != nil {
+		app.writeError(w, err)
+		return
+	}
+	if len(data.Domains) > limit {
+		data.Domains = data.Domains[:limit]
+		data.NextCursor = app.cursors.Encode("domain", []string{data.Domains[limit-1].Name}, cursorFilter)
+	}
+
+	meta := []server.MetaOption{server.Count(len(data.Domains)), server.NextCursor(data.NextCursor)}
+	// the pages are encoded into a buffer, writes to it do not fail
+	switch format {
+	case "csv":
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		cw.Write([]string{"domain", "active"})
+		for _, d := range data.Domains {
+			cw.Write([]string{d.Name, strconv.FormatBool(d.Active)})
+		}
+		cw.Flush()
+		server.WriteBodyWithMeta(w, "text/csv; charset=utf-8", buf.Bytes(), meta...)
+	case "ndjson":
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, d := range data.Domains {
+			enc.Encode(d)
+		}
+		server.WriteBodyWithMeta(w, "application/x-ndjson", buf.Bytes(), meta...)
+	}
+}
+
+// streamZoneDomains writes a JSON page of the zone listing as its rows are read, without holding the page
+func (app *appContext) streamZoneDomains(w http.ResponseWriter, r *http.Request, data *model.ZoneDomains, zoneID int64, active *bool, after string, limit int, cursorFilter string) {
+	aw := server.NewArrayWriter(w, data, "domains")
+	var last, nextCursor string
+	n := 0
+	// one more row than the page tells whether there is a next page
+	err := app.ds.StreamZoneDomains(r.Context(), zoneID, active, after, limit+1, func(d *model.ZoneDomain) error {
+		n++
+		if n > limit {
+			nextCursor = app.cursors.Encode("domain", []string{last}, cursorFilter)
+			return nil
+		}
+		last = d.Name
+		return aw.WriteItem(d)
+	})
+	switch {
+	case err == nil:
+		aw.Close(nextCursor)
+	case aw.Sent():
+		aw.Fail(err)
+	default:
+		app.writeError(w, err)
+	}
+}
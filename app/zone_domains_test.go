@@ -0,0 +1,247 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"dnscoffee/cursor"
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// zoneDomainsStore lists the domains of ORG in name order, the stream fails with streamErr after failAfter rows
+type zoneDomainsStore struct {
+	fakeStore
+	domains   []*model.ZoneDomain
+	estimate  int64
+	err       error
+	streamErr error
+	failAfter int
+}
+
+func (s *zoneDomainsStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if name != "ORG" {
+		return 0, datastore.ErrNoResource
+	}
+	return 3, nil
+}
+
+func (s *zoneDomainsStore) GetZoneDomainEstimate(ctx context.Context, zoneID int64) (int64, error) {
+	return s.estimate, nil
+}
+
+func (s *zoneDomainsStore) CountZoneDomains(ctx context.Context, zoneID int64, active *bool) (int64, error) {
+	domains, _ := s.GetZoneDomains(ctx, zoneID, active, "", len(s.domains))
+	return int64(len(domains)), nil
+}
+
+func (s *zoneDomainsStore) GetZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int) ([]*model.ZoneDomain, error) {
+	var domains []*model.ZoneDomain
+	for _, d := range s.domains {
+		if d.Name > after && (active == nil || d.Active == *active) && len(domains) < limit {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+func (s *zoneDomainsStore) StreamZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int, fn func(*model.ZoneDomain) error) error {
+	domains, _ := s.GetZoneDomains(ctx, zoneID, active, after, limit)
+	for i, d := range domains {
+		if s.streamErr != nil && i == s.failAfter {
+			return s.streamErr
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneDomainsApp returns an app listing the five domains of ORG, COM is restricted
+func zoneDomainsApp(t *testing.T) (*appContext, *zoneDomainsStore) {
+	ds := &zoneDomainsStore{domains: []*model.ZoneDomain{
+		{Name: "A.ORG", Active: true},
+		{Name: "B.ORG"},
+		{Name: "C.ORG", Active: true},
+		{Name: "D.ORG", Active: true},
+		{Name: "E.ORG"},
+	}}
+	cursors, err := cursor.NewCodec("test secret", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &appContext{
+		ds:      ds,
+		zones:   testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+		cursors: cursors,
+	}, ds
+}
+
+// zoneDomainsPage is a JSON page of a zone listing
+type zoneDomainsPage struct {
+	Data struct {
+		model.ZoneDomains
+		Meta struct {
+			Count     int  `json:"count"`
+			Truncated bool `json:"truncated"`
+		} `json:"meta"`
+	}
+}
+
+func zoneDomains(app *appContext, zone, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	app.apiZoneDomainsHandler(w, varsRequest("/api/zones/"+url.PathEscape(zone)+"/domains"+query, map[string]string{"zone": zone}))
+	return w
+}
+
+// TestZoneDomainsPages walks the listing a page at a time, the streamed JSON pages list the same domains as the others
+func TestZoneDomainsPages(t *testing.T) {
+	app, _ := zoneDomainsApp(t)
+	tests := []struct {
+		active string
+		want   []string
+		total  int64
+	}{
+		{active: "", want: []string{"A.ORG", "B.ORG", "C.ORG", "D.ORG", "E.ORG"}, total: 5},
+		{active: "1", want: []string{"A.ORG", "C.ORG", "D.ORG"}, total: 3},
+		{active: "0", want: []string{"B.ORG", "E.ORG"}, total: 2},
+	}
+	for _, tt := range tests {
+		t.Run("active="+tt.active, func(t *testing.T) {
+			var got []string
+			cursor := ""
+			for page := 0; ; page++ {
+				w := zoneDomains(app, "org", "?limit=2&active="+tt.active+"&cursor="+url.QueryEscape(cursor))
+				if w.Code != http.StatusOK {
+					t.Fatalf("page %d: got status %d %s", page, w.Code, w.Body)
+				}
+				var resp zoneDomainsPage
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("%s: %s", err, w.Body)
+				}
+				// the total is counted on the first page only
+				if page == 0 && (resp.Data.Total == nil || *resp.Data.Total != tt.total) || page > 0 && resp.Data.Total != nil {
+					t.Errorf("page %d: got total %v, want %d on the first page", page, resp.Data.Total, tt.total)
+				}
+				if resp.Data.Meta.Count != len(resp.Data.Domains) || len(resp.Data.Domains) > 2 {
+					t.Errorf("page %d: got count %d of %d domains", page, resp.Data.Meta.Count, len(resp.Data.Domains))
+				}
+				for _, d := range resp.Data.Domains {
+					got = append(got, d.Name)
+				}
+				cursor = resp.Data.NextCursor
+				if cursor == "" {
+					break
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !sort.StringsAreSorted(got) {
+				t.Errorf("got %v out of order", got)
+			}
+
+			for _, format := range []string{"csv", "ndjson"} {
+				w := zoneDomains(app, "org", "?limit=10&active="+tt.active+"&format="+format)
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: got status %d %s", format, w.Code, w.Body)
+				}
+				for _, name := range tt.want {
+					if !strings.Contains(w.Body.String(), name) {
+						t.Errorf("%s: got %s, want %s", format, w.Body, name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestZoneDomainsLargeZone(t *testing.T) {
+	app, ds := zoneDomainsApp(t)
+	ds.estimate = largeZoneDomains + 1
+	w := zoneDomains(app, "org", "")
+	var resp zoneDomainsPage
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Total != nil || resp.Data.TotalEstimate == nil || *resp.Data.TotalEstimate != ds.estimate || resp.Data.Notice == "" {
+		t.Errorf("got %s, want an estimate and a notice", w.Body)
+	}
+}
+
+func TestZoneDomainsErrors(t *testing.T) {
+	app, _ := zoneDomainsApp(t)
+	w := zoneDomains(app, "org", "?limit=2&active=1")
+	var resp zoneDomainsPage
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	activeCursor := resp.Data.NextCursor
+
+	tests := []struct {
+		name      string
+		zone      string
+		query     string
+		err       error
+		streamErr error
+		want      int
+		code      string
+		// items of a truncated page
+		truncated int
+	}{
+		{name: "invalid active", zone: "org", query: "?active=yes", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "limit too large", zone: "org", query: "?limit=10001", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid format", zone: "org", query: "?format=xml", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "invalid zone", zone: "xn--bcher-kvaü", want: http.StatusBadRequest, code: "invalid_name"},
+		{name: "tampered cursor", zone: "org", query: "?cursor=" + url.QueryEscape(activeCursor[:len(activeCursor)-2]+"xx"), want: http.StatusBadRequest, code: "invalid_cursor"},
+		{name: "cursor of another filter", zone: "org", query: "?active=0&cursor=" + url.QueryEscape(activeCursor), want: http.StatusBadRequest, code: "invalid_cursor"},
+		{name: "restricted zone", zone: "com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "unknown zone", zone: "example", want: http.StatusNotFound, code: "resource_not_found"},
+		{name: "database unavailable", zone: "org", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+		{name: "stream fails at once", zone: "org", streamErr: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+		{name: "stream fails mid page", zone: "org", query: "?limit=4", streamErr: errors.New("connection reset"), want: http.StatusOK, truncated: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ds := zoneDomainsApp(t)
+			ds.err, ds.streamErr, ds.failAfter = tt.err, tt.streamErr, tt.truncated
+			w := zoneDomains(app, tt.zone, tt.query)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+			if tt.truncated > 0 {
+				var resp struct {
+					Data struct {
+						Domains []json.RawMessage `json:"domains"`
+						Meta    struct {
+							Count     int  `json:"count"`
+							Truncated bool `json:"truncated"`
+						} `json:"meta"`
+					}
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if !resp.Data.Meta.Truncated || resp.Data.Meta.Count != tt.truncated || len(resp.Data.Domains) != tt.truncated+1 {
+					t.Errorf("got %s, want %d domains and an error element", w.Body, tt.truncated)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// zoneForbidden writes ErrForbiddenZone and returns true if name is in a restricted zone the request may not read
+// handlers looking up a single domain check its name before querying it
+func (app *appContext) zoneForbidden(w http.ResponseWriter, r *http.Request, name string) bool {
+	jsonErr := app.zones.Check(r, name)
+	if jsonErr == nil {
+		return false
+	}
+	server.WriteJSONError(w, jsonErr)
+	return true
+}
+
+// visibleDomains removes the domains of restricted zones the request may not read from a listing
+func (app *appContext) visibleDomains(r *http.Request, domains []*model.Domain) []*model.Domain {
+	return app.zones.FilterDomains(r, domains)
+}
+
+// zoneID returns the ID of a zone from the cached zone IDs, a zone they do not have yet is queried
+// ErrNoResource if there is no such zone
+func (app *appContext) zoneID(ctx context.Context, zone string) (int64, error) {
+	ids, err := app.zoneIDs.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if id, ok := ids[zone]; ok {
+		return id, nil
+	}
+	// a zone first imported since the last refresh
+	return app.ds.GetZoneID(ctx, zone)
+}
+
+// latestZones returns a copy of the cached zone list, the requests share the cached one and GenerateMetaData sets
+// fields of every zone
+func (app *appContext) latestZones(ctx context.Context) (*model.ZoneImportResults, error) {
+	cached, err := app.zoneList.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zones := *cached
+	zones.Zones = make([]*model.ZoneImportResult, len(cached.Zones))
+	for i, z := range cached.Zones {
+		zone := *z
+		zones.Zones[i] = &zone
+	}
+	return &zones, nil
+}
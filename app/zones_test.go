@@ -0,0 +1,252 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/provider"
+	"dnscoffee/refcache"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// domainStore looks up the domains of its map in a read transaction, or fails with err
+type domainStore struct {
+	fakeStore
+	domains map[string]*model.Domain
+	err     error
+	queried []string
+}
+
+func (s *domainStore) ReadTx(ctx context.Context, fn func(tx datastore.ReadQueries) error) error {
+	return fn(s)
+}
+
+func (s *domainStore) GetDomain(ctx context.Context, domain string) (*model.Domain, error) {
+	s.queried = append(s.queried, domain)
+	if s.err != nil {
+		return nil, s.err
+	}
+	d, ok := s.domains[domain]
+	if !ok {
+		return nil, datastore.ErrNoResource
+	}
+	copied := *d
+	return &copied, nil
+}
+
+// testZoneAccess returns the zone access of a server restricting zones
+func testZoneAccess(t *testing.T, zones ...server.RestrictedZone) *server.ZoneAccess {
+	t.Helper()
+	s, err := server.New([]string{"127.0.0.1:0"}, server.APIConfig{
+		APITimeout:            5,
+		APIRequestsPerMinute:  60,
+		APIRequestsBurst:      10,
+		APIMaxRequestHistory:  100,
+		RestrictedZones:       zones,
+		RestrictedZoneContact: "access@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.ZoneAccess()
+}
+
+// testProviders returns the providers table of the default patterns
+func testProviders(t *testing.T) *provider.Table {
+	t.Helper()
+	classifier, err := provider.New(provider.Defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.NewTable(classifier)
+}
+
+// varsRequest returns a GET of target with the path parameters vars, as mux routes it
+func varsRequest(target string, vars map[string]string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), vars)
+}
+
+func TestDomainHandlerRestrictedZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		err    error
+		want   int
+		code   string
+		// whether the database is queried
+		queried bool
+	}{
+		{name: "found", domain: "example.org", want: http.StatusOK, queried: true},
+		{name: "not found", domain: "missing.org", want: http.StatusNotFound, code: "resource_not_found", queried: true},
+		{name: "restricted zone", domain: "example.com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "below a restricted zone", domain: "www.example.com", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "invalid name", domain: "exa\x00mple.org", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "database unavailable", domain: "example.org", err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable", queried: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &domainStore{domains: map[string]*model.Domain{"EXAMPLE.ORG": {Name: "EXAMPLE.ORG"}}, err: tt.err}
+			app := &appContext{
+				ds:        ds,
+				zones:     testZoneAccess(t, server.RestrictedZone{Zone: "COM", Scopes: []string{"czds"}}),
+				providers: testProviders(t),
+			}
+			w := httptest.NewRecorder()
+			app.apiDomainHandler(w, varsRequest("/api/domains/"+url.PathEscape(tt.domain), map[string]string{"domain": tt.domain}))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), `"name":"EXAMPLE.ORG"`) {
+				t.Errorf("got %s, want EXAMPLE.ORG", w.Body)
+			}
+			if queried := len(ds.queried) > 0; queried != tt.queried {
+				t.Errorf("queried the database: %t, want %t", queried, tt.queried)
+			}
+		})
+	}
+}
+
+// zoneListStore lists two zones and their IDs, counting the loads, and fails the loads with err
+type zoneListStore struct {
+	fakeStore
+	err error
+	// loads of the zone list and the zone IDs, and zones looked up one by one
+	lists, idLists int
+	idQueries      []string
+}
+
+func (s *zoneListStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error) {
+	s.lists++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &model.ZoneImportResults{Count: 2, Zones: []*model.ZoneImportResult{{Zone: "COM", Count: 100}, {Zone: "NET", Count: 10}}}, nil
+}
+
+func (s *zoneListStore) GetZoneIDs(ctx context.Context) (map[string]int64, error) {
+	s.idLists++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return map[string]int64{"COM": 1, "NET": 2}, nil
+}
+
+func (s *zoneListStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	s.idQueries = append(s.idQueries, name)
+	if name == "ORG" {
+		return 3, nil
+	}
+	return 0, datastore.ErrNoResource
+}
+
+func zoneListApp(ds *zoneListStore) *appContext {
+	return &appContext{
+		ds:       ds,
+		zoneList: refcache.New("test_zones", ds.GetZoneImportResults),
+		zoneIDs:  refcache.New("test_zone_ids", ds.GetZoneIDs),
+	}
+}
+
+// TestLatestZonesHandler serves the zone list from the reference data, loaded by the first request
+func TestLatestZonesHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		requests int
+		want     int
+		code     string
+	}{
+		{name: "first request", requests: 1, want: http.StatusOK},
+		{name: "cached", requests: 3, want: http.StatusOK},
+		{name: "database unavailable", requests: 2, err: datastore.ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &zoneListStore{err: tt.err}
+			app := zoneListApp(ds)
+			for i := 0; i < tt.requests; i++ {
+				w := httptest.NewRecorder()
+				app.apiLatestZonesHandler(w, httptest.NewRequest(http.MethodGet, "/api/zones", nil))
+				if w.Code != tt.want {
+					t.Fatalf("request %d: got status %d %s, want %d", i, w.Code, w.Body, tt.want)
+				}
+				if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("got %s, want the error %s", w.Body, tt.code)
+				}
+				if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), `"link":"/zones/COM"`) {
+					t.Errorf("got %s, want the link of COM", w.Body)
+				}
+			}
+			// a failed load is tried again by the next request
+			wantLists := 1
+			if tt.err != nil {
+				wantLists = tt.requests
+			}
+			if ds.lists != wantLists {
+				t.Errorf("loaded the zone list %d times, want %d", ds.lists, wantLists)
+			}
+		})
+	}
+}
+
+// TestLatestZonesShared leaves the cached zone list as it was loaded, the responses set the metadata of a copy
+func TestLatestZonesShared(t *testing.T) {
+	app := zoneListApp(&zoneListStore{})
+	w := httptest.NewRecorder()
+	app.apiLatestZonesHandler(w, httptest.NewRequest(http.MethodGet, "/api/zones", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s", w.Code, w.Body)
+	}
+	cached, err := app.zoneList.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Type != nil || cached.Zones[0].Link != "" {
+		t.Errorf("the cached zone list was changed: %+v %+v", cached, cached.Zones[0])
+	}
+}
+
+func TestZoneID(t *testing.T) {
+	tests := []struct {
+		zone    string
+		err     error
+		want    int64
+		wantErr error
+		// zones looked up one by one
+		wantQueries []string
+	}{
+		{zone: "COM", want: 1},
+		{zone: "NET", want: 2},
+		// a zone first imported since the last refresh
+		{zone: "ORG", want: 3, wantQueries: []string{"ORG"}},
+		{zone: "XYZ", wantErr: datastore.ErrNoResource, wantQueries: []string{"XYZ"}},
+		{zone: "COM", err: datastore.ErrDatabaseUnavailable, wantErr: datastore.ErrDatabaseUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			ds := &zoneListStore{err: tt.err}
+			app := zoneListApp(ds)
+			id, err := app.zoneID(context.Background(), tt.zone)
+			if id != tt.want || err != tt.wantErr {
+				t.Errorf("got %d, %v, want %d, %v", id, err, tt.want, tt.wantErr)
+			}
+			if strings.Join(ds.idQueries, ", ") != strings.Join(tt.wantQueries, ", ") {
+				t.Errorf("looked up %q, want %q", ds.idQueries, tt.wantQueries)
+			}
+			if ds.idLists != 1 {
+				t.Errorf("loaded the zone IDs %d times, want 1", ds.idLists)
+			}
+		})
+	}
+}
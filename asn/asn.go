@@ -0,0 +1,303 @@
+// Package asn maps IP addresses to the autonomous systems originating them in a routing table dump
+//
+// An address is routed by the longest prefix of the table containing it and originated by the origin ASNs of that
+// prefix, several for the prefixes announced by more than one AS. The prefixes are flattened into sorted disjoint
+// address ranges when the table is built, so that a lookup is one binary search. IPv6 prefixes are matched on their
+// first 64 bits, longer ones are left out of the table as they are not routed globally.
+package asn
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// ErrTooManyPrefixes is returned by the loaders when the table has more prefixes than allowed
+var ErrTooManyPrefixes = errors.New("too many prefixes")
+
+// Route is a prefix of the table and the ASNs originating it
+type Route struct {
+	Prefix  netip.Prefix
+	Origins []uint32
+}
+
+// key is the part of an address the ranges are keyed on, the address itself for IPv4 and the first 64 bits for IPv6
+type key interface {
+	uint32 | uint64
+}
+
+// ranges are disjoint address ranges covering every key, ranges[i] spans from starts[i] to the key before
+// starts[i+1] and is routed by the route refs[i], -1 when unrouted
+type ranges[K key] struct {
+	starts []K
+	refs   []int32
+}
+
+// span is the range of keys of a route, last included
+type span[K key] struct {
+	first, last K
+	ref         int32
+}
+
+// flatten returns the ranges of the spans, a key being routed by the smallest span containing it; spans of the
+// same route must be merged before and spans only nest or are disjoint, as prefixes do
+func flatten[K key](spans []span[K]) ranges[K] {
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].first != spans[j].first {
+			return spans[i].first < spans[j].first
+		}
+		return spans[i].last > spans[j].last
+	})
+	r := ranges[K]{starts: []K{0}, refs: []int32{-1}}
+	// the spans containing the current key, innermost last
+	var open []span[K]
+	// end closes the open spans ending before first, their enclosing span or no route resuming after them
+	end := func(first K, all bool) {
+		for len(open) > 0 && (all || open[len(open)-1].last < first) {
+			last := open[len(open)-1].last
+			open = open[:len(open)-1]
+			ref := int32(-1)
+			if len(open) > 0 {
+				ref = open[len(open)-1].ref
+			}
+			if last != ^K(0) {
+				r.add(last+1, ref)
+			}
+		}
+	}
+	for _, s := range spans {
+		end(s.first, false)
+		r.add(s.first, s.ref)
+		open = append(open, s)
+	}
+	end(0, true)
+	return r
+}
+
+// add starts a range at start routed by ref, start is never before the start of the last range
+func (r *ranges[K]) add(start K, ref int32) {
+	n := len(r.starts)
+	if r.starts[n-1] == start {
+		// the range of the previous start is empty
+		r.starts, r.refs = r.starts[:n-1], r.refs[:n-1]
+		n--
+		if n == 0 {
+			r.starts, r.refs = append(r.starts, start), append(r.refs, ref)
+			return
+		}
+	}
+	if r.refs[n-1] == ref {
+		return
+	}
+	r.starts, r.refs = append(r.starts, start), append(r.refs, ref)
+}
+
+// find returns the index of the range containing k
+func (r *ranges[K]) find(k K) int {
+	return sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > k }) - 1
+}
+
+// bounds returns the first and last key of the range i
+func (r *ranges[K]) bounds(i int) (K, K) {
+	if i+1 < len(r.starts) {
+		return r.starts[i], r.starts[i+1] - 1
+	}
+	return r.starts[i], ^K(0)
+}
+
+// Table is a routing table built by New, it is not modified afterwards and may be shared
+type Table struct {
+	routes []Route
+	v4     ranges[uint32]
+	v6     ranges[uint64]
+	// the indexes of the v4 and v6 ranges routed by every origin ASN
+	v4ByASN map[uint32][]int32
+	v6ByASN map[uint32][]int32
+	// IPv6 prefixes longer than /64 left out
+	skipped int
+}
+
+// New returns the table of the routes, the origins of a prefix listed more than once are merged
+func New(routes []Route) *Table {
+	byPrefix := make(map[netip.Prefix]int32, len(routes))
+	t := &Table{v4ByASN: make(map[uint32][]int32), v6ByASN: make(map[uint32][]int32)}
+	var spans4 []span[uint32]
+	var spans6 []span[uint64]
+	for _, route := range routes {
+		prefix := route.Prefix.Masked()
+		if prefix.Addr().Is6() && prefix.Bits() > 64 {
+			t.skipped++
+			continue
+		}
+		if ref, ok := byPrefix[prefix]; ok {
+			t.routes[ref].Origins = mergeOrigins(t.routes[ref].Origins, route.Origins)
+			continue
+		}
+		ref := int32(len(t.routes))
+		byPrefix[prefix] = ref
+		t.routes = append(t.routes, Route{Prefix: prefix, Origins: mergeOrigins(nil, route.Origins)})
+		if prefix.Addr().Is4() {
+			first := key4(prefix.Addr())
+			spans4 = append(spans4, span[uint32]{first, first | ^uint32(0)>>prefix.Bits(), ref})
+		} else {
+			first := key6(prefix.Addr())
+			spans6 = append(spans6, span[uint64]{first, first | ^uint64(0)>>prefix.Bits(), ref})
+		}
+	}
+	t.v4 = flatten(spans4)
+	t.v6 = flatten(spans6)
+	index(t.v4ByASN, t.v4.refs, t.routes)
+	index(t.v6ByASN, t.v6.refs, t.routes)
+	return t
+}
+
+// mergeOrigins adds the ASNs of add missing from origins
+func mergeOrigins(origins, add []uint32) []uint32 {
+next:
+	for _, asn := range add {
+		for _, o := range origins {
+			if o == asn {
+				continue next
+			}
+		}
+		origins = append(origins, asn)
+	}
+	return origins
+}
+
+// index adds the ranges routed by every origin ASN to byASN
+func index(byASN map[uint32][]int32, refs []int32, routes []Route) {
+	for i, ref := range refs {
+		if ref < 0 {
+			continue
+		}
+		for _, asn := range routes[ref].Origins {
+			byASN[asn] = append(byASN[asn], int32(i))
+		}
+	}
+}
+
+// key4 returns the key of an IPv4 address
+func key4(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// key6 returns the key of an IPv6 address, its first 64 bits
+func key6(addr netip.Addr) uint64 {
+	b := addr.As16()
+	var k uint64
+	for _, octet := range b[:8] {
+		k = k<<8 | uint64(octet)
+	}
+	return k
+}
+
+// Lookup returns the route of the longest prefix containing addr, false when it is not routed
+// IPv4-mapped IPv6 addresses are looked up as IPv4 addresses
+func (t *Table) Lookup(addr netip.Addr) (Route, bool) {
+	addr = addr.Unmap()
+	var ref int32
+	switch {
+	case addr.Is4():
+		ref = t.v4.refs[t.v4.find(key4(addr))]
+	case addr.Is6():
+		ref = t.v6.refs[t.v6.find(key6(addr))]
+	default:
+		return Route{}, false
+	}
+	if ref < 0 {
+		return Route{}, false
+	}
+	return t.routes[ref], true
+}
+
+// Range is an inclusive range of addresses
+type Range struct {
+	First, Last netip.Addr
+}
+
+// Ranges returns the IPv4 and IPv6 address ranges whose longest matching prefix the ASN originates, in order, both
+// empty for the ASNs originating no prefix; the addresses of more specific prefixes of other ASNs are left out
+func (t *Table) Ranges(asn uint32) (v4, v6 []Range) {
+	for _, i := range t.v4ByASN[asn] {
+		first, last := t.v4.bounds(int(i))
+		v4 = append(v4, Range{addr4(first), addr4(last)})
+	}
+	for _, i := range t.v6ByASN[asn] {
+		first, last := t.v6.bounds(int(i))
+		v6 = append(v6, Range{addr6(first, 0), addr6(last, ^uint64(0))})
+	}
+	return v4, v6
+}
+
+// Prefixes returns the number of prefixes of the table the ASN originates
+func (t *Table) Prefixes(asn uint32) int {
+	n := 0
+	for _, route := range t.routes {
+		for _, o := range route.Origins {
+			if o == asn {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// addr4 returns the IPv4 address of a key
+func addr4(k uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(k >> 24), byte(k >> 16), byte(k >> 8), byte(k)})
+}
+
+// addr6 returns the IPv6 address of a key followed by the 64 bits low
+func addr6(k, low uint64) netip.Addr {
+	var b [16]byte
+	for i := 0; i < 8; i++ {
+		b[7-i] = byte(k >> (8 * i))
+		b[15-i] = byte(low >> (8 * i))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// Stats describes the size of a table
+type Stats struct {
+	Prefixes int
+	ASNs     int
+	// the IPv6 prefixes longer than /64 left out
+	Skipped int
+	// the IPv4 and IPv6 disjoint ranges the prefixes were flattened into
+	Ranges int
+	// an estimate of the memory the table holds
+	Bytes int64
+}
+
+// Stats returns the size of the table
+func (t *Table) Stats() Stats {
+	s := Stats{Prefixes: len(t.routes), Skipped: t.skipped, Ranges: len(t.v4.starts) + len(t.v6.starts)}
+	asns := make(map[uint32]bool, len(t.v4ByASN)+len(t.v6ByASN))
+	for asn, refs := range t.v4ByASN {
+		asns[asn] = true
+		s.Bytes += int64(4 * len(refs))
+	}
+	for asn, refs := range t.v6ByASN {
+		asns[asn] = true
+		s.Bytes += int64(4 * len(refs))
+	}
+	s.ASNs = len(asns)
+	// a map entry of the indexes takes about a key, a slice header and the overhead of the buckets
+	s.Bytes += int64(48 * (len(t.v4ByASN) + len(t.v6ByASN)))
+	s.Bytes += int64(8*len(t.v4.starts) + 12*len(t.v6.starts))
+	for _, route := range t.routes {
+		// the prefix, the slice header and the origins
+		s.Bytes += int64(56 + 4*cap(route.Origins))
+	}
+	return s
+}
+
+// String formats the size of the table for the logs
+func (s Stats) String() string {
+	return fmt.Sprintf("%d prefixes of %d ASNs in %d ranges, %d IPv6 prefixes longer than /64 left out, about %d KiB",
+		s.Prefixes, s.ASNs, s.Ranges, s.Skipped, s.Bytes>>10)
+}
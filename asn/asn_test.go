@@ -0,0 +1,284 @@
+package asn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTable is nested and adjacent prefixes of both families, a prefix announced by two ASes and one listed twice
+const testTable = `# prefix	length	origin
+10.0.0.0	8	64500
+10.1.0.0	16	64501
+10.1.2.0	24	64502
+10.1.2.0	24	64503
+10.2.0.0	16	64500
+10.255.255.255	32	64504
+11.0.0.0	8	64505_64506
+0.0.0.0	8	64507
+255.255.255.0	24	64508
+2001:db8::	32	64510
+2001:db8:1::	48	64511
+2001:db8:1::	64	64512
+2001:db8:1::1	128	64513
+`
+
+func mustTable(t *testing.T, table string) *Table {
+	t.Helper()
+	routes, err := ParsePfx2as(strings.NewReader(table), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(routes)
+}
+
+func TestLookup(t *testing.T) {
+	tbl := mustTable(t, testTable)
+	tests := []struct {
+		addr    string
+		prefix  string
+		origins []uint32
+	}{
+		{"10.0.0.1", "10.0.0.0/8", []uint32{64500}},
+		{"10.1.0.0", "10.1.0.0/16", []uint32{64501}},
+		{"10.1.2.255", "10.1.2.0/24", []uint32{64502, 64503}},
+		{"10.1.3.0", "10.1.0.0/16", []uint32{64501}},
+		{"10.1.255.255", "10.1.0.0/16", []uint32{64501}},
+		{"10.2.0.0", "10.2.0.0/16", []uint32{64500}},
+		{"10.3.0.0", "10.0.0.0/8", []uint32{64500}},
+		{"10.255.255.254", "10.0.0.0/8", []uint32{64500}},
+		{"10.255.255.255", "10.255.255.255/32", []uint32{64504}},
+		{"11.0.0.0", "11.0.0.0/8", []uint32{64505, 64506}},
+		{"0.0.0.0", "0.0.0.0/8", []uint32{64507}},
+		{"255.255.255.255", "255.255.255.0/24", []uint32{64508}},
+		{"::ffff:10.1.2.3", "10.1.2.0/24", []uint32{64502, 64503}},
+		{"2001:db8:ffff::1", "2001:db8::/32", []uint32{64510}},
+		{"2001:db8:1:1::1", "2001:db8:1::/48", []uint32{64511}},
+		{"2001:db8:1::2", "2001:db8:1::/64", []uint32{64512}},
+		{"12.0.0.0", "", nil},
+		{"9.255.255.255", "", nil},
+		{"255.255.254.255", "", nil},
+		{"2001:db9::", "", nil},
+		{"::", "", nil},
+	}
+	for _, tt := range tests {
+		route, ok := tbl.Lookup(netip.MustParseAddr(tt.addr))
+		if tt.prefix == "" {
+			if ok {
+				t.Errorf("%s: got %v, want unrouted", tt.addr, route)
+			}
+			continue
+		}
+		if !ok || route.Prefix.String() != tt.prefix || !reflect.DeepEqual(route.Origins, tt.origins) {
+			t.Errorf("%s: got %v %v, want %s %v", tt.addr, route.Prefix, route.Origins, tt.prefix, tt.origins)
+		}
+	}
+	if _, ok := tbl.Lookup(netip.Addr{}); ok {
+		t.Error("the zero address is routed")
+	}
+	if s := tbl.Stats(); s.Prefixes != 11 || s.Skipped != 1 || s.ASNs != 12 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	tbl := mustTable(t, testTable)
+	rng := func(first, last string) Range {
+		return Range{netip.MustParseAddr(first), netip.MustParseAddr(last)}
+	}
+	v4, v6 := tbl.Ranges(64500)
+	want := []Range{
+		rng("10.0.0.0", "10.0.255.255"),
+		// 10.1.0.0/16 is another AS, 10.2.0.0/16 is 64500 again but another prefix
+		rng("10.2.0.0", "10.2.255.255"),
+		rng("10.3.0.0", "10.255.255.254"),
+	}
+	if !reflect.DeepEqual(v4, want) || len(v6) != 0 {
+		t.Errorf("got %v %v, want %v", v4, v6, want)
+	}
+	v4, v6 = tbl.Ranges(64511)
+	want = []Range{rng("2001:db8:1:1::", "2001:db8:1:ffff:ffff:ffff:ffff:ffff")}
+	if len(v4) != 0 || !reflect.DeepEqual(v6, want) {
+		t.Errorf("got %v %v, want %v", v4, v6, want)
+	}
+	if v4, v6 := tbl.Ranges(1); v4 != nil || v6 != nil {
+		t.Errorf("got %v %v for an ASN without prefixes", v4, v6)
+	}
+	if n := tbl.Prefixes(64500); n != 2 {
+		t.Errorf("got %d prefixes, want 2", n)
+	}
+}
+
+// TestFlatten compares the flattened ranges with a search of the longest prefix over random nested prefixes
+func TestFlatten(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		var routes []Route
+		for i := 0; i < 40; i++ {
+			// a small address space so that the prefixes nest and touch often
+			addr := netip.AddrFrom4([4]byte{10, byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+			prefix := netip.PrefixFrom(addr, 8+rnd.Intn(25)).Masked()
+			routes = append(routes, Route{Prefix: prefix, Origins: []uint32{uint32(i)}})
+		}
+		tbl := New(routes)
+		for i := 0; i < 2000; i++ {
+			addr := netip.AddrFrom4([4]byte{10, byte(rnd.Intn(5)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+			want := -1
+			for j, route := range routes {
+				if route.Prefix.Contains(addr) && (want < 0 || route.Prefix.Bits() > routes[want].Prefix.Bits()) {
+					want = j
+				}
+			}
+			got, ok := tbl.Lookup(addr)
+			if want < 0 {
+				if ok {
+					t.Fatalf("round %d: %s: got %v, want unrouted", round, addr, got.Prefix)
+				}
+				continue
+			}
+			if !ok || got.Prefix != routes[want].Prefix {
+				t.Fatalf("round %d: %s: got %v, want %v", round, addr, got.Prefix, routes[want].Prefix)
+			}
+		}
+	}
+}
+
+func TestFlattenRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		spans      []span[uint32]
+		wantStarts []uint32
+		wantRefs   []int32
+	}{
+		{name: "empty", wantStarts: []uint32{0}, wantRefs: []int32{-1}},
+		{name: "everything", spans: []span[uint32]{{0, ^uint32(0), 0}}, wantStarts: []uint32{0}, wantRefs: []int32{0}},
+		{name: "nested ending together", spans: []span[uint32]{{10, 19, 0}, {15, 19, 1}}, wantStarts: []uint32{0, 10, 15, 20}, wantRefs: []int32{-1, 0, 1, -1}},
+		{name: "nested starting together", spans: []span[uint32]{{10, 14, 1}, {10, 19, 0}}, wantStarts: []uint32{0, 10, 15, 20}, wantRefs: []int32{-1, 1, 0, -1}},
+		{name: "adjacent", spans: []span[uint32]{{10, 14, 0}, {15, 19, 1}}, wantStarts: []uint32{0, 10, 15, 20}, wantRefs: []int32{-1, 0, 1, -1}},
+		{name: "at the end", spans: []span[uint32]{{^uint32(0) - 1, ^uint32(0), 0}}, wantStarts: []uint32{0, ^uint32(0) - 1}, wantRefs: []int32{-1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := flatten(tt.spans)
+			if !reflect.DeepEqual(r.starts, tt.wantStarts) || !reflect.DeepEqual(r.refs, tt.wantRefs) {
+				t.Errorf("got %v %v, want %v %v", r.starts, r.refs, tt.wantStarts, tt.wantRefs)
+			}
+		})
+	}
+}
+
+func TestParsePfx2as(t *testing.T) {
+	for _, line := range []string{
+		"10.0.0.0 8",
+		"10.0.0.0 8 64500 extra",
+		"10.0.0.300 8 64500",
+		"10.0.0.0 33 64500",
+		"10.0.0.0 -1 64500",
+		"fe80::1%eth0 64 64500",
+		"10.0.0.0 8 AS",
+		"10.0.0.0 8 _",
+		"10.0.0.0 8 4294967296",
+	} {
+		if _, err := ParsePfx2as(strings.NewReader(line), 0); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+	routes, err := ParsePfx2as(strings.NewReader("10.1.2.3 8 64500,64501_AS1.10\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Route{{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Origins: []uint32{64500, 64501, 65546}}}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("got %v, want %v", routes, want)
+	}
+	if _, err := ParsePfx2as(strings.NewReader(testTable), 5); !errors.Is(err, ErrTooManyPrefixes) {
+		t.Errorf("got %v, want ErrTooManyPrefixes", err)
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint32
+		invalid bool
+	}{
+		{s: "64496", want: 64496},
+		{s: "AS64496", want: 64496},
+		{s: "as64496", want: 64496},
+		{s: "AS1.10", want: 65546},
+		{s: "4294967295", want: 4294967295},
+		{s: "65535.65535", want: 4294967295},
+		{s: "4294967296", invalid: true},
+		{s: "65536.0", invalid: true},
+		{s: "1.", invalid: true},
+		{s: "-1", invalid: true},
+		{s: "AS", invalid: true},
+		{s: "", invalid: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseASN(tt.s)
+		if (err != nil) != tt.invalid || got != tt.want {
+			t.Errorf("%q: got %d, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(testTable))
+	zw.Close()
+	files := map[string][]byte{"pfx2as.txt": []byte(testTable), "pfx2as.txt.gz": gz.Bytes()}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tbl, err := LoadFile(path, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := tbl.Stats().Prefixes; n != 11 {
+			t.Errorf("%s: got %d prefixes", name, n)
+		}
+	}
+	if _, err := LoadFile(filepath.Join(dir, "pfx2as.txt"), 3); !errors.Is(err, ErrTooManyPrefixes) {
+		t.Errorf("got %v, want ErrTooManyPrefixes", err)
+	}
+}
+
+// BenchmarkLookup looks up random addresses in a table the size of a full routing table
+func BenchmarkLookup(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	var routes []Route
+	for i := 0; i < 1000000; i++ {
+		addr := netip.AddrFrom4([4]byte{byte(rnd.Intn(224)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), 0})
+		routes = append(routes, Route{Prefix: netip.PrefixFrom(addr, 8+rnd.Intn(17)).Masked(), Origins: []uint32{uint32(i)}})
+	}
+	for i := 0; i < 200000; i++ {
+		addr := netip.AddrFrom16([16]byte{0x20, byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+		routes = append(routes, Route{Prefix: netip.PrefixFrom(addr, 19+rnd.Intn(30)).Masked(), Origins: []uint32{uint32(i)}})
+	}
+	tbl := New(routes)
+	addrs := make([]netip.Addr, 1024)
+	for i := range addrs {
+		if i%4 == 0 {
+			addrs[i] = netip.AddrFrom16([16]byte{0x20, byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+			continue
+		}
+		addrs[i] = netip.AddrFrom4([4]byte{byte(rnd.Intn(224)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tbl.Lookup(addrs[i%len(addrs)])
+	}
+}
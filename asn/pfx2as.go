@@ -0,0 +1,115 @@
+package asn
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParsePfx2as reads a prefix to AS file as published by CAIDA from the RouteViews and RIPE RIS dumps: one prefix per
+// line as its address, length and origin separated by tabs or spaces, ex: "192.0.2.0	24	64496"; the origin of a
+// prefix announced by several ASes lists them separated by underscores, and AS sets by commas, both added to its
+// origins. Empty lines and those starting with # are skipped. More than maxPrefixes prefixes, unless 0, is an error.
+func ParsePfx2as(r io.Reader, maxPrefixes int) ([]Route, error) {
+	var routes []Route
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		route, err := parsePfx2asLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if maxPrefixes > 0 && len(routes) >= maxPrefixes {
+			return nil, fmt.Errorf("line %d: %w, the limit is %d", line, ErrTooManyPrefixes, maxPrefixes)
+		}
+		routes = append(routes, route)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// parsePfx2asLine parses a line of a prefix to AS file
+func parsePfx2asLine(text string) (Route, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return Route{}, fmt.Errorf("%q has %d fields, want prefix, length and origin", text, len(fields))
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil || addr.Zone() != "" {
+		return Route{}, fmt.Errorf("%q is not a valid IP address", fields[0])
+	}
+	bits, err := strconv.Atoi(fields[1])
+	if err != nil || bits < 0 || bits > addr.BitLen() {
+		return Route{}, fmt.Errorf("%q is not a valid prefix length of %s", fields[1], addr)
+	}
+	route := Route{Prefix: netip.PrefixFrom(addr, bits).Masked()}
+	for _, origin := range strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' }) {
+		asn, err := ParseASN(origin)
+		if err != nil {
+			return Route{}, err
+		}
+		route.Origins = append(route.Origins, asn)
+	}
+	if len(route.Origins) == 0 {
+		return Route{}, fmt.Errorf("%q has no origin", text)
+	}
+	return route, nil
+}
+
+// ParseASN parses an AS number, in plain or asdot notation and optionally prefixed by AS, ex: 64496, AS64496
+// or AS1.10 for 65546
+func ParseASN(s string) (uint32, error) {
+	value := s
+	if len(value) > 2 && strings.EqualFold(value[:2], "AS") {
+		value = value[2:]
+	}
+	high, low, dot := strings.Cut(value, ".")
+	if !dot {
+		asn, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid AS number", s)
+		}
+		return uint32(asn), nil
+	}
+	h, err1 := strconv.ParseUint(high, 10, 16)
+	l, err2 := strconv.ParseUint(low, 10, 16)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("%q is not a valid AS number", s)
+	}
+	return uint32(h<<16 | l), nil
+}
+
+// LoadFile reads a prefix to AS file with ParsePfx2as and returns its table, the files named .gz are decompressed
+func LoadFile(path string, maxPrefixes int) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	routes, err := ParsePfx2as(r, maxPrefixes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return New(routes), nil
+}
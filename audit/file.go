@@ -0,0 +1,82 @@
+// Package audit implements server.AuditSink backends other than the database
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// File appends the audit records to a file, one JSON object per line
+// the file is only ever appended to, rotating it is left to the operator
+type File struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// NewFile opens the append-only audit log at path, creating it if needed
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+// WriteAudit implements server.AuditSink, the batch is synced to disk before it returns
+func (af *File) WriteAudit(_ context.Context, records []*model.AuditRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	if _, err := af.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return af.f.Sync()
+}
+
+// QueryAudit implements server.AuditSink by reading the whole file, records are in the order they were written
+func (af *File) QueryAudit(ctx context.Context, key string, since time.Time, limit int) ([]*model.AuditRecord, error) {
+	// holding the lock keeps partially written batches out of the scan
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	f, err := os.Open(af.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]*model.AuditRecord, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() && len(records) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var rec model.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		if rec.Time.Before(since) || (key != "" && rec.Key != key) {
+			continue
+		}
+		records = append(records, &rec)
+	}
+	return records, scanner.Err()
+}
+
+// Close closes the file
+func (af *File) Close() error {
+	return af.f.Close()
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	af, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	record := func(key string, hours int) *model.AuditRecord {
+		return &model.AuditRecord{Time: model.NewTimestamp(start.Add(time.Duration(hours) * time.Hour)), Key: key, Method: "GET", Route: "/api/domains/{domain}", Status: 200}
+	}
+	ctx := context.Background()
+	if err := af.WriteAudit(ctx, []*model.AuditRecord{record("a", 0), record("b", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := af.WriteAudit(ctx, []*model.AuditRecord{record("a", 2), record("a", 3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		since time.Time
+		limit int
+		// hours after start of the records returned
+		want []int
+	}{
+		{name: "every key", limit: 10, want: []int{0, 1, 2, 3}},
+		{name: "one key", key: "a", limit: 10, want: []int{0, 2, 3}},
+		{name: "since", key: "a", since: start.Add(time.Hour), limit: 10, want: []int{2, 3}},
+		{name: "limit", limit: 2, want: []int{0, 1}},
+		{name: "unknown key", key: "c", limit: 10, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := af.QueryAudit(ctx, tt.key, tt.since, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records == nil || len(records) != len(tt.want) {
+				t.Fatalf("got %d records, want %d", len(records), len(tt.want))
+			}
+			for i, rec := range records {
+				if want := start.Add(time.Duration(tt.want[i]) * time.Hour); !rec.Time.Equal(want) {
+					t.Errorf("record %d at %s, want %s", i, rec.Time, want)
+				}
+			}
+		})
+	}
+
+	// the file is appended to when opened again
+	again, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if err := again.WriteAudit(ctx, []*model.AuditRecord{record("b", 4)}); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := af.QueryAudit(ctx, "", time.Time{}, 10); err != nil || len(records) != 5 {
+		t.Errorf("got %d records and %v after reopening, want 5", len(records), err)
+	}
+}
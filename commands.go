@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"dnscoffee/app"
+	"dnscoffee/config"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/params"
+	"dnscoffee/provider"
+	"dnscoffee/server"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// exit codes of the commands
+const (
+	exitOK      = 0
+	exitFailure = 1
+	// bad flags or arguments, as the flag package exits with
+	exitUsage = 2
+)
+
+// commandConnectTimeout bounds connecting to the database, commands other than serve do not wait for it
+const commandConnectTimeout = 30 * time.Second
+
+// command is a subcommand of the binary, run with the arguments after its name
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string) int
+}
+
+// commands are the subcommands, a command line not starting with one of them is served
+// they are set by init since the commands look themselves up in it for their usage
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"serve", "[flags]", "serve the API, the default", runServe},
+		{"check-config", "[flags]", "validate and print the effective config, exit 1 if it is invalid", runCheckConfig},
+		{"migrate", "[flags]", "apply pending database schema migrations", runMigrate},
+		{"routes", "[flags]", "print the route table", runRoutes},
+		{"query", "[flags] domain|nameserver|ip|zone NAME", "look NAME up in the database and print it as JSON", runQuery},
+		{"selftest", "[flags]", "check the config, database and routes on an ephemeral port, print a JSON report and exit 1 on failure", runSelfTestCommand},
+	}
+}
+
+// dispatch runs the command named by the first argument and returns its exit code
+// arguments starting with a flag, or none, are those of serve so that existing init scripts keep working
+func dispatch(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage(os.Stdout)
+			return exitOK
+		}
+		for _, cmd := range commands {
+			if args[0] == cmd.name {
+				return cmd.run(args[1:])
+			}
+		}
+		if !strings.HasPrefix(args[0], "-") {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+			usage(os.Stderr)
+			return exitUsage
+		}
+	}
+	return runServe(args)
+}
+
+// usage writes the list of commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: dnscoffee [command] [flags]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun dnscoffee COMMAND -h for the flags of a command.\n")
+}
+
+// newFlagSet returns the flag set of the command name, its errors are returned rather than exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd := cmd
+			fs.Usage = func() {
+				fmt.Fprintf(fs.Output(), "Usage: dnscoffee %s %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.usage)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args into fs, the exit code is returned with false for -h or bad flags
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// configFlags are the flags of the commands reading the config file
+type configFlags struct {
+	path   string
+	strict bool
+}
+
+// addConfigFlags adds -config and -strict-config to fs
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{}
+	fs.StringVar(&cf.path, "config", "", "path to the JSON, YAML or TOML config file")
+	fs.BoolVar(&cf.strict, "strict-config", false, "fail on unknown settings in the config file")
+	return cf
+}
+
+// load reads the config file and validates it, the invalid settings are logged and fail it
+func (cf *configFlags) load() (*config.Config, error) {
+	conf, err := config.Load(cf.path, cf.strict)
+	if err != nil {
+		return nil, err
+	}
+	errs := conf.Validate()
+	for _, err := range errs {
+		logging.Errorf("config: %s", err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%d invalid config settings", len(errs))
+	}
+	// the output of the commands is written to stdout, the log stays on stderr
+	level, err := logging.ParseLevel(conf.Log.Level)
+	if err == nil {
+		logging.SetLevel(level)
+	}
+	return conf, nil
+}
+
+// connect connects to the database of conf once, within commandConnectTimeout
+func connect(ctx context.Context, conf *config.Config) (*datastore.DataStore, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, commandConnectTimeout)
+	defer cancel()
+	return datastore.New(connectCtx, conf.Datastore())
+}
+
+// printJSON writes v indented to stdout
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// runCheckConfig prints the effective config with its secrets redacted, even when invalid, and fails if it is invalid
+func runCheckConfig(args []string) int {
+	fs := newFlagSet("check-config")
+	cf := addConfigFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	conf, err := config.Load(cf.path, cf.strict)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	return checkConfig(conf)
+}
+
+// checkConfig validates and prints conf, also run by serve -check-config
+func checkConfig(conf *config.Config) int {
+	errs := conf.Validate()
+	for _, err := range errs {
+		logging.Errorf("config: %s", err)
+	}
+	err := printJSON(conf.Redacted())
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	if len(errs) > 0 {
+		logging.Errorf("%d invalid config settings", len(errs))
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runMigrate applies the pending migrations, it fails rather than waiting when the database is unreachable
+func runMigrate(args []string) int {
+	fs := newFlagSet("migrate")
+	cf := addConfigFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	conf, err := cf.load()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	ctx := context.Background()
+	ds, err := connect(ctx, conf)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	defer ds.Close()
+	return migrateSchema(ctx, ds)
+}
+
+// migrateSchema applies the pending migrations of ds, also run by serve -migrate
+func migrateSchema(ctx context.Context, ds *datastore.DataStore) int {
+	applied, err := ds.Migrate(ctx)
+	if err != nil {
+		logging.Errorf("migrate: %s", err)
+		return exitFailure
+	}
+	logging.Infof("applied %d migrations", applied)
+	return exitOK
+}
+
+// runRoutes prints the routes the server registers with the config, one per line as methods, path and name or as JSON
+// the routes are registered by the app, which needs its database, but the schema is not checked and nothing is served
+func runRoutes(args []string) int {
+	fs := newFlagSet("routes")
+	cf := addConfigFlags(fs)
+	asJSON := fs.Bool("json", false, "print the routes as a JSON array")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	conf, err := cf.load()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	ds, err := connect(context.Background(), conf)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	defer ds.Close()
+	coffeeServer, err := server.New(conf.ListenAddrs(), conf.Server())
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	classifier, err := conf.Classifier()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	appConfig := conf.App()
+	appConfig.Providers = provider.NewTable(classifier)
+	app.Start(ds, coffeeServer, appConfig)
+
+	routes, err := coffeeServer.Routes()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	if *asJSON {
+		err = printJSON(routes)
+		if err != nil {
+			logging.Errorf("%s", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, route := range routes {
+		methods := strings.Join(route.Methods, ",")
+		if methods == "" {
+			methods = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", methods, route.Path, route.Name)
+	}
+	err = tw.Flush()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runQuery looks a domain, nameserver, IP or zone up in the database, in one read transaction like the API,
+// and prints it as JSON; a name that is not found exits 1 like any failure
+// zone access restrictions do not apply, the command has the access of the database user
+func runQuery(args []string) int {
+	fs := newFlagSet("query")
+	cf := addConfigFlags(fs)
+	timeout := fs.Duration("timeout", time.Minute, "how long the lookup may take")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitUsage
+	}
+	kind, name := fs.Arg(0), fs.Arg(1)
+	lookup, err := queryLookup(kind, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitUsage
+	}
+	conf, err := cf.load()
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ds, err := connect(ctx, conf)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	defer ds.Close()
+
+	var data model.APIData
+	err = ds.ReadTx(ctx, func(tx datastore.ReadQueries) (err error) {
+		data, err = lookup(ctx, tx)
+		return err
+	})
+	if err == datastore.ErrNoResource {
+		logging.Errorf("%s %s not found", kind, name)
+		return exitFailure
+	}
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	data.GenerateMetaData()
+	err = printJSON(data)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// queryLookup returns the lookup of name by runQuery, the name is normalized like the path parameters of the API
+func queryLookup(kind, name string) (func(ctx context.Context, tx datastore.ReadQueries) (model.APIData, error), error) {
+	if kind == "ip" {
+		addr, jsonErr := server.ParseIPParam("ip", name)
+		if jsonErr != nil {
+			return nil, fmt.Errorf("invalid IP %q", name)
+		}
+		return func(ctx context.Context, tx datastore.ReadQueries) (model.APIData, error) {
+			return tx.GetIP(ctx, addr.String())
+		}, nil
+	}
+	domain, err := params.CleanDomain(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	switch kind {
+	case "domain":
+		return func(ctx context.Context, tx datastore.ReadQueries) (model.APIData, error) {
+			return tx.GetDomain(ctx, domain)
+		}, nil
+	case "nameserver":
+		return func(ctx context.Context, tx datastore.ReadQueries) (model.APIData, error) {
+			return tx.GetNameServer(ctx, domain)
+		}, nil
+	case "zone":
+		return func(ctx context.Context, tx datastore.ReadQueries) (model.APIData, error) {
+			zone, err := tx.GetZone(ctx, domain)
+			if err != nil {
+				return nil, err
+			}
+			// the import metadata is optional, as in the API
+			importData, err := tx.GetZoneImport(ctx, domain)
+			if err == nil {
+				zone.ImportData = importData
+			}
+			return zone, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q, must be domain, nameserver, ip or zone", kind)
+}
+
+// runSelfTestCommand runs the self-test, see runSelfTest
+func runSelfTestCommand(args []string) int {
+	fs := newFlagSet("selftest")
+	cf := addConfigFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	conf, err := config.Load(cf.path, cf.strict)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	return runSelfTest(context.Background(), conf, conf.Validate())
+}
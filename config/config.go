@@ -0,0 +1,801 @@
+// Package config loads the dnscoffee configuration
+// settings are read from the defaults, then an optional JSON, YAML or TOML file, then environment variables
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/app"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/provider"
+	"dnscoffee/server"
+)
+
+// Config holds all of the dnscoffee settings
+// the json tag of every field is its name in the config file, see env.go for the environment variable names
+type Config struct {
+	HTTP        HTTPConfig        `json:"Http"`
+	Database    DatabaseConfig    `json:"Database"`
+	API         APIConfig         `json:"API"`
+	Admin       AdminConfig       `json:"Admin"`
+	Maintenance MaintenanceConfig `json:"Maintenance"`
+	Log         LogConfig         `json:"Log"`
+	Tracing     TracingConfig     `json:"Tracing"`
+	Errors      ErrorsConfig      `json:"Errors"`
+	Audit       AuditConfig       `json:"Audit"`
+	Jobs        JobsConfig        `json:"Jobs"`
+	Zones       ZonesConfig       `json:"Zones"`
+	Tenants     TenantsConfig     `json:"Tenants"`
+	Keywords    KeywordsConfig    `json:"Keywords"`
+	Watchlists  WatchlistsConfig  `json:"Watchlists"`
+	Providers   ProvidersConfig   `json:"Providers"`
+	ImportHook  ImportHookConfig  `json:"Import_Hook"`
+	LiveDNS     LiveDNSConfig     `json:"Live_DNS"`
+	// when requests of the expensive routes are shed
+	LoadShedding LoadSheddingConfig `json:"Load_Shedding"`
+	Recordings   RecordingsConfig   `json:"Recordings"`
+	ImportChecks ImportChecksConfig `json:"Import_Checks"`
+	Idempotency  IdempotencyConfig  `json:"Idempotency"`
+	ASN          ASNConfig          `json:"ASN"`
+}
+
+// HTTPConfig is the address of the main listeners
+type HTTPConfig struct {
+	// the address of the listener when Listen is empty
+	IP   string `json:"IP"`
+	Port int    `json:"Port"`
+	// ip:port addresses to listen on, replacing IP and Port when set, ex: ["0.0.0.0:8080", "[::]:8080"]
+	Listen []string `json:"Listen"`
+	// CIDRs of the reverse proxies whose forwarding headers are trusted, headers from any peer are ignored when empty
+	TrustedProxies []string `json:"Trusted_Proxies"`
+	// how long kept-alive connections wait for their next request, the API timeout when 0
+	IdleTimeout Duration `json:"Idle_Timeout"`
+	// HTTP/2 streams a client may have open at once on one connection
+	MaxConcurrentStreams int `json:"Max_Concurrent_Streams"`
+	// serve cleartext HTTP/2 on the listeners, for deployments where TLS terminates at a proxy
+	H2C bool `json:"H2C"`
+}
+
+// DatabaseConfig holds the database connection and query settings
+type DatabaseConfig struct {
+	// connection string, $DATABASE_URL is used when empty
+	DSN                string   `json:"DSN" secret:"true"`
+	BreakerThreshold   int      `json:"Breaker_Threshold"`
+	BreakerCooldown    Duration `json:"Breaker_Cooldown"`
+	MaxRetries         int      `json:"Max_Retries"`
+	RetryBackoff       Duration `json:"Retry_Backoff"`
+	SlowQueryThreshold Duration `json:"Slow_Query_Threshold"`
+	// list queries matching more rows fail, 0 is unlimited
+	MaxRows int `json:"Max_Rows"`
+	// concurrent queries of a request querying every zone
+	MaxParallelZoneQueries int                `json:"Max_Parallel_Zone_Queries"`
+	MaterializedViews      []MaterializedView `json:"Materialized_Views"`
+	// apply pending schema migrations at startup instead of refusing to start
+	AutoMigrate bool `json:"Auto_Migrate"`
+}
+
+// MaterializedView is a view to refresh on a schedule
+type MaterializedView struct {
+	Name         string `json:"Name"`
+	Schedule     string `json:"Schedule"`
+	Concurrently bool   `json:"Concurrently"`
+}
+
+// APIConfig holds the request timeout and rate limit settings
+type APIConfig struct {
+	// seconds
+	Timeout            int `json:"Timeout"`
+	RequestsPerMinute  int `json:"Requests_Per_Minute"`
+	RequestsMaxHistory int `json:"Requests_Max_History"`
+	RequestsBurst      int `json:"Requests_Burst"`
+	// shards of the rate limiter buckets, more lets more clients be limited at once without waiting on each other
+	RequestsHistoryShards int `json:"Requests_History_Shards"`
+	// peak number of clients a minute, checked against Requests_Max_History, 0 skips the check
+	ExpectedClients int `json:"Expected_Clients"`
+	// enforce, shadow to only count and log rate limited requests, or off
+	RateLimitMode string `json:"Rate_Limit_Mode"`
+	// off, admin to send X-Debug-Stats to requests with the admin token, or all
+	DebugStats string `json:"Debug_Stats"`
+	// YYYY-MM-DD sunset dates of deprecated routes by path, ex: {"/api/counts/root": "2027-01-01"}
+	Sunsets map[string]string `json:"Sunsets"`
+	// deprecated routes answer 410 after their sunset date
+	EnforceSunsets bool `json:"Enforce_Sunsets"`
+	// names of the routes answering 503 until they are enabled, ex: ["zone_diff"], see GET /api/admin/routes
+	DisabledRoutes []string `json:"Disabled_Routes"`
+	// clients rate limited Ban_Threshold times within Ban_Window are blocked for Ban_Duration, 0 disables banning
+	BanThreshold int      `json:"Ban_Threshold"`
+	BanWindow    Duration `json:"Ban_Window"`
+	BanDuration  Duration `json:"Ban_Duration"`
+	// maximum number of requests served at once, 0 is unlimited
+	MaxInFlight          int `json:"Max_In_Flight"`
+	MaxInFlightPerClient int `json:"Max_In_Flight_Per_Client"`
+	// bytes, 0 is unlimited
+	MaxResponseBytes int `json:"Max_Response_Bytes"`
+	// key signing pagination cursors, random when empty
+	CursorSecret string   `json:"Cursor_Secret" secret:"true"`
+	CursorTTL    Duration `json:"Cursor_TTL"`
+	// number of feed responses cached, 0 disables the cache
+	FeedCacheSize int      `json:"Feed_Cache_Size"`
+	FeedCacheTTL  Duration `json:"Feed_Cache_TTL"`
+	// nameserver domain count histories cached, ranges including today expire after Feed_Cache_TTL
+	NameServerStatsCacheSize int `json:"Nameserver_Stats_Cache_Size"`
+	// zone diffs, the span is limited to bound the cost of a diff
+	ZoneDiffMaxDays   int      `json:"Zone_Diff_Max_Days"`
+	ZoneDiffCacheSize int      `json:"Zone_Diff_Cache_Size"`
+	ZoneDiffTimeout   Duration `json:"Zone_Diff_Timeout"`
+	// directory the feed downloads of past dates are pre-generated in, empty streams every download from the database
+	FeedExportDir string `json:"Feed_Export_Dir"`
+	// number of past dates whose feed downloads are kept
+	FeedExportDays int `json:"Feed_Export_Days"`
+	// URL the Feed_Export_Dir files are also published at, the bulk manifest points at the API downloads when empty
+	FeedExportBaseURL string `json:"Feed_Export_Base_URL"`
+	// MiB of the bloom filter answering the lookups of names never seen without a query, 0 disables the negative cache
+	NegativeCacheMB int `json:"Negative_Cache_MB"`
+	// number of recent lookup misses kept by the negative cache
+	NegativeCacheSize int `json:"Negative_Cache_Size"`
+	// responses whose data was last imported longer ago carry a Warning header and a warning in the meta, 0 never warns
+	StaleDataAfter Duration `json:"Stale_Data_After"`
+	// keys sent in the X-API-Key header, granting access to restricted zones
+	Keys []APIKey `json:"Keys"`
+	// CIDRs the /api/admin and /api/internal routes may be called from, whatever the token, any address is allowed when empty
+	AdminAllowCIDRs    []string `json:"Admin_Allow_CIDRs"`
+	InternalAllowCIDRs []string `json:"Internal_Allow_CIDRs"`
+}
+
+// APIKey is an API key and the scopes it grants
+type APIKey struct {
+	Name   string   `json:"Name"`
+	Key    string   `json:"Key" secret:"true"`
+	Scopes []string `json:"Scopes"`
+}
+
+// AdminConfig holds the admin API settings
+type AdminConfig struct {
+	// bearer token, $ADMIN_TOKEN is used when empty
+	Token    string `json:"Token" secret:"true"`
+	Listen   string `json:"Listen"`
+	TLSCert  string `json:"TLS_Cert"`
+	TLSKey   string `json:"TLS_Key"`
+	ClientCA string `json:"Client_CA"`
+}
+
+// ImportHookConfig secures the /api/internal routes called by the zone importer
+type ImportHookConfig struct {
+	// bearer token the importer sends, the routes are disabled when empty
+	Secret string `json:"Secret" secret:"true"`
+	// CIDRs the importer may call from, any address is allowed when empty
+	AllowedCIDRs []string `json:"Allowed_CIDRs"`
+}
+
+// LiveDNSConfig enables the outbound NS queries of /api/domains/{domain}/live, deployments that can not query DNS leave it disabled
+type LiveDNSConfig struct {
+	Enabled bool `json:"Enabled"`
+	// ip:port of the resolver, the system resolver is used when empty
+	Resolver string   `json:"Resolver"`
+	Timeout  Duration `json:"Timeout"`
+	// number of live NS sets cached, 0 disables the cache
+	CacheSize int      `json:"Cache_Size"`
+	CacheTTL  Duration `json:"Cache_TTL"`
+	// per client quota of the route, on top of API.Requests_Per_Minute
+	RequestsPerMinute int `json:"Requests_Per_Minute"`
+	RequestsBurst     int `json:"Requests_Burst"`
+}
+
+// MaintenanceConfig sets the maintenance mode state at startup
+type MaintenanceConfig struct {
+	Enabled bool   `json:"Enabled"`
+	Message string `json:"Message"`
+}
+
+// LoadSheddingConfig sets when the requests of the expensive routes, such as searches and exports, are shed
+// the share of them rejected rises by Step after every Window whose error or slow rate is over its threshold,
+// and falls by Step after those under half of both
+type LoadSheddingConfig struct {
+	Enabled bool     `json:"Enabled"`
+	Window  Duration `json:"Window"`
+	// windows serving fewer requests never raise the share
+	MinRequests int `json:"Min_Requests"`
+	// fraction of 5xx responses and timeouts
+	ErrorRate float64 `json:"Error_Rate"`
+	// fraction of requests taking Slow_Request or longer
+	SlowRequest Duration `json:"Slow_Request"`
+	SlowRate    float64  `json:"Slow_Rate"`
+	// change of the share per window, and the share it stops rising at
+	Step           float64 `json:"Step"`
+	MaxProbability float64 `json:"Max_Probability"`
+}
+
+// ImportChecksConfig sets when the domains an import added or removed make it suspect, judged against the averages of
+// the previous imports of its zone
+type ImportChecksConfig struct {
+	// previous imports averaged, and how many a zone needs before its imports are judged
+	Trailing   int `json:"Trailing"`
+	MinHistory int `json:"Min_History"`
+	// a count more than 1+threshold times its average or less than the average divided by it is suspect, 0 never is
+	AddedThreshold   float64 `json:"Added_Threshold"`
+	RemovedThreshold float64 `json:"Removed_Threshold"`
+	// domains a count must be off its average by to be suspect, so that small zones are not flagged for a handful
+	MinChange int64 `json:"Min_Change"`
+}
+
+// RecordingsConfig bounds the responses recorded with ?record=1 by requests with an API key or the admin token,
+// recording is disabled when Max is 0
+type RecordingsConfig struct {
+	// most recordings kept, and for how long, 0 keeps them however old
+	Max    int      `json:"Max"`
+	MaxAge Duration `json:"Max_Age"`
+	// bytes, larger response bodies are only kept by hash, 0 keeps none
+	BodyMaxBytes int `json:"Body_Max_Bytes"`
+	// how often the recordings beyond Max and Max_Age are removed
+	CleanupInterval Duration `json:"Cleanup_Interval"`
+}
+
+// IdempotencyConfig sets where the responses of the POST requests with an Idempotency-Key are kept for their retries,
+// the header is ignored when Store is empty
+type IdempotencyConfig struct {
+	// memory, for a single server, or postgres, shared by the servers of the database
+	Store string `json:"Store"`
+	// how long a response is replayed to the retries
+	TTL Duration `json:"TTL"`
+	// bytes, retries of requests with larger response bodies only get their status
+	BodyMaxBytes int `json:"Body_Max_Bytes"`
+	// how often the expired keys are removed
+	CleanupInterval Duration `json:"Cleanup_Interval"`
+}
+
+// ASNConfig sets the prefix to AS file the asn field of the IP addresses and /api/asn/{asn} are served from,
+// neither is when File is empty
+type ASNConfig struct {
+	// CAIDA prefix to AS file, gzipped when named .gz, loaded again every Jobs.ASN_Interval when it changed
+	File string `json:"File"`
+	// files with more prefixes are rejected to bound the memory of the table, 0 is unlimited
+	MaxPrefixes int `json:"Max_Prefixes"`
+}
+
+// idempotency stores
+const (
+	IdempotencyStoreMemory   = "memory"
+	IdempotencyStorePostgres = "postgres"
+)
+
+// LogConfig sets the log level and destination
+type LogConfig struct {
+	// debug, info, warn or error
+	Level string `json:"Level"`
+	// stderr, stdout or a file path, files are reopened on SIGUSR1 for log rotation
+	Output string `json:"Output"`
+	// log the access line of 1 in Sample_Rate successful requests, errors and slow requests are always logged
+	SampleRate           int      `json:"Sample_Rate"`
+	SlowRequestThreshold Duration `json:"Slow_Request_Threshold"`
+	// sample the 404s like successful requests instead of logging them all
+	SampleNotFound bool `json:"Sample_Not_Found"`
+}
+
+// TracingConfig sets where OpenTelemetry spans are exported, tracing is disabled when Endpoint is empty
+type TracingConfig struct {
+	// OTLP HTTP endpoint, ex: http://localhost:4318
+	Endpoint string `json:"Endpoint"`
+	// fraction of requests to trace, from 0 to 1
+	SampleRatio float64 `json:"Sample_Ratio"`
+}
+
+// ErrorsConfig sets where panics and internal server errors are reported, at most one of Sentry_DSN and Webhook_URL may be set
+type ErrorsConfig struct {
+	SentryDSN  string `json:"Sentry_DSN" secret:"true"`
+	WebhookURL string `json:"Webhook_URL"`
+	// reports waiting to be sent, further reports are dropped
+	QueueSize int `json:"Queue_Size"`
+}
+
+// audit sinks
+const (
+	AuditSinkPostgres = "postgres"
+	AuditSinkFile     = "file"
+)
+
+// AuditConfig sets where the requests authenticated with an API key are recorded, they are not when Sink is empty
+type AuditConfig struct {
+	// postgres for the audit_log table or file for the append-only File
+	Sink string `json:"Sink"`
+	File string `json:"File"`
+	// records waiting to be written, requests wait briefly for room and further records are dropped
+	QueueSize int `json:"Queue_Size"`
+}
+
+// JobsConfig sets the intervals of the background jobs
+type JobsConfig struct {
+	// how often the import statistics are precomputed, 0 computes them on every request
+	StatsInterval Duration `json:"Stats_Interval"`
+	// how often the active domains per provider are precomputed, 0 computes them on every request
+	ProvidersInterval Duration `json:"Providers_Interval"`
+	// how often missing feed downloads are pre-generated, import notifications also start it
+	FeedExportsInterval Duration `json:"Feed_Exports_Interval"`
+	// how often the nameserver sets of the domains are indexed by fingerprint, import notifications also start it
+	NameServerSetsInterval Duration `json:"Nameserver_Sets_Interval"`
+	// how often the glue of the latest imports is checked for anomalies, import notifications also start it
+	GlueInterval Duration `json:"Glue_Interval"`
+	// how often the domain lifetime histograms are precomputed, 0 computes them on every request
+	LifetimesInterval Duration `json:"Lifetimes_Interval"`
+	// how often the tracked keywords are counted in the new imports, import notifications also start it
+	KeywordsInterval Duration `json:"Keywords_Interval"`
+	// how often the new imports are matched against the watchlists, import notifications also start it
+	WatchlistsInterval Duration `json:"Watchlists_Interval"`
+	// how often the negative cache checks for new imports and is rebuilt after them, import notifications also start it
+	NegativeCacheInterval Duration `json:"Negative_Cache_Interval"`
+	// how often when the zones were last imported is read for the data age of the responses, import notifications also start it
+	FreshnessInterval Duration `json:"Freshness_Interval"`
+	// how often the finished imports are checked for suspect changes, import notifications also start it
+	ImportChecksInterval Duration `json:"Import_Checks_Interval"`
+	// how often the label statistics of the finished imports are counted, import notifications also start it
+	LabelStatsInterval Duration `json:"Label_Stats_Interval"`
+	// how often the domains of the finished imports are scored for ?min_stability=, import notifications also start it
+	StabilityInterval Duration `json:"Stability_Interval"`
+	// how often ASN.File is loaded again when it changed
+	ASNInterval Duration `json:"ASN_Interval"`
+}
+
+// ZonesConfig restricts the per-domain data of licensed zones to API keys with the zone's scopes
+type ZonesConfig struct {
+	Restricted []RestrictedZone `json:"Restricted"`
+	// URL or email address to ask for access, included in the error
+	Contact string `json:"Contact"`
+	// the sources the zone files are imported from, recorded on the imports and accepted by ?source= on the feeds
+	Sources []string `json:"Sources"`
+}
+
+// RestrictedZone is a zone whose domains are only served to keys with one of Scopes
+// a Source in place of the Zone restricts the zones whose latest import came from one of Zones.Sources
+type RestrictedZone struct {
+	Zone   string   `json:"Zone"`
+	Source string   `json:"Source"`
+	Scopes []string `json:"Scopes"`
+}
+
+// KeywordsConfig lists the keywords whose new domains are counted for /api/stats/keywords
+type KeywordsConfig struct {
+	// lower case letters, digits and hyphens, matched within the names of the new domains without their zone
+	Tracked []string `json:"Tracked"`
+}
+
+// WatchlistsConfig limits the watchlists every API key may have
+type WatchlistsConfig struct {
+	MaxPerKey int `json:"Max_Per_Key"`
+	// of those, the expensive ones such as similar_to queries
+	MaxExpensivePerKey int `json:"Max_Expensive_Per_Key"`
+}
+
+// TenantsConfig serves the API under several hosts with their own settings, requests to other hosts use the API settings
+type TenantsConfig struct {
+	List []TenantConfig `json:"List"`
+}
+
+// TenantConfig overrides the API settings for the requests whose Host header is one of Hosts
+// unset settings keep those of the API
+type TenantConfig struct {
+	Name  string   `json:"Name"`
+	Hosts []string `json:"Hosts"`
+	// a quota of its own when positive, otherwise the tenant shares the API quota
+	RequestsPerMinute int `json:"Requests_Per_Minute"`
+	RequestsBurst     int `json:"Requests_Burst"`
+	// replaces Zones.Restricted when set, an empty list restricts no zone
+	RestrictedZones []RestrictedZone `json:"Restricted_Zones"`
+	CORSOrigins     []string         `json:"CORS_Origins"`
+	DebugStats      string           `json:"Debug_Stats"`
+	HideDebugVars   bool             `json:"Hide_Debug_Vars"`
+}
+
+// ProvidersConfig classifies nameservers by hosting provider
+type ProvidersConfig struct {
+	// include the built-in patterns of the largest providers
+	Defaults bool `json:"Defaults"`
+	// added to the defaults, replacing built-in patterns with the same suffix
+	Patterns []ProviderPattern `json:"Patterns"`
+}
+
+// ProviderPattern maps the nameservers named Suffix or below it to Provider
+type ProviderPattern struct {
+	Suffix   string `json:"Suffix"`
+	Provider string `json:"Provider"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in the config file
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	api := server.DefaultAPIConfig
+	ds := datastore.DefaultConfig
+	return &Config{
+		HTTP: HTTPConfig{
+			IP:                   "127.0.0.1",
+			Port:                 8080,
+			IdleTimeout:          Duration(api.IdleTimeout),
+			MaxConcurrentStreams: int(api.MaxConcurrentStreams),
+		},
+		Database: DatabaseConfig{
+			BreakerThreshold:   ds.BreakerThreshold,
+			BreakerCooldown:    Duration(ds.BreakerCooldown),
+			MaxRetries:         ds.MaxRetries,
+			RetryBackoff:       Duration(ds.RetryBackoff),
+			SlowQueryThreshold: Duration(ds.SlowQueryThreshold),
+			MaxRows:            ds.MaxRows,
+
+			MaxParallelZoneQueries: ds.MaxParallelZoneQueries,
+		},
+		Log: LogConfig{
+			Level:                "info",
+			Output:               "stderr",
+			SampleRate:           api.AccessLogSampleRate,
+			SlowRequestThreshold: Duration(api.SlowRequestThreshold),
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+		Errors: ErrorsConfig{
+			QueueSize: 100,
+		},
+		Audit: AuditConfig{
+			QueueSize: 10000,
+		},
+		Jobs: JobsConfig{
+			StatsInterval:          Duration(app.DefaultConfig.StatsInterval),
+			ProvidersInterval:      Duration(app.DefaultConfig.ProviderStatsInterval),
+			FeedExportsInterval:    Duration(app.DefaultConfig.FeedExportInterval),
+			NameServerSetsInterval: Duration(app.DefaultConfig.NameServerSetsInterval),
+			GlueInterval:           Duration(app.DefaultConfig.GlueInterval),
+			LifetimesInterval:      Duration(app.DefaultConfig.LifetimesInterval),
+			KeywordsInterval:       Duration(app.DefaultConfig.KeywordsInterval),
+			WatchlistsInterval:     Duration(app.DefaultConfig.WatchlistsInterval),
+			NegativeCacheInterval:  Duration(app.DefaultConfig.NegativeCacheInterval),
+			FreshnessInterval:      Duration(app.DefaultConfig.FreshnessInterval),
+			ImportChecksInterval:   Duration(app.DefaultConfig.ImportChecksInterval),
+			LabelStatsInterval:     Duration(app.DefaultConfig.LabelStatsInterval),
+			StabilityInterval:      Duration(app.DefaultConfig.StabilityInterval),
+			ASNInterval:            Duration(app.DefaultConfig.ASNInterval),
+		},
+		Zones: ZonesConfig{
+			Sources: app.DefaultConfig.Sources,
+		},
+		Watchlists: WatchlistsConfig{
+			MaxPerKey:          app.DefaultConfig.WatchlistsPerKey,
+			MaxExpensivePerKey: app.DefaultConfig.WatchlistsExpensivePerKey,
+		},
+		Providers: ProvidersConfig{
+			Defaults: true,
+		},
+		LiveDNS: LiveDNSConfig{
+			Timeout:           Duration(app.DefaultConfig.LiveDNSTimeout),
+			CacheSize:         app.DefaultConfig.LiveDNSCacheSize,
+			CacheTTL:          Duration(app.DefaultConfig.LiveDNSCacheTTL),
+			RequestsPerMinute: app.DefaultConfig.LiveDNSRequestsPerMinute,
+			RequestsBurst:     app.DefaultConfig.LiveDNSRequestsBurst,
+		},
+		API: APIConfig{
+			Timeout:                  api.APITimeout,
+			RequestsPerMinute:        api.APIRequestsPerMinute,
+			RequestsMaxHistory:       api.APIMaxRequestHistory,
+			RequestsBurst:            api.APIRequestsBurst,
+			RequestsHistoryShards:    api.RateLimitStoreShards,
+			RateLimitMode:            api.RateLimitMode,
+			DebugStats:               api.DebugStats,
+			BanThreshold:             api.BanThreshold,
+			BanWindow:                Duration(api.BanWindow),
+			BanDuration:              Duration(api.BanDuration),
+			MaxInFlight:              api.MaxInFlight,
+			MaxInFlightPerClient:     api.MaxInFlightPerClient,
+			MaxResponseBytes:         api.MaxResponseBytes,
+			CursorTTL:                Duration(api.CursorTTL),
+			FeedCacheSize:            app.DefaultConfig.FeedCacheSize,
+			FeedCacheTTL:             Duration(app.DefaultConfig.FeedCacheTTL),
+			NameServerStatsCacheSize: app.DefaultConfig.NameServerStatsCacheSize,
+			ZoneDiffMaxDays:          app.DefaultConfig.ZoneDiffMaxDays,
+			ZoneDiffCacheSize:        app.DefaultConfig.ZoneDiffCacheSize,
+			ZoneDiffTimeout:          Duration(app.DefaultConfig.ZoneDiffTimeout),
+			FeedExportDays:           app.DefaultConfig.FeedExportDays,
+			NegativeCacheSize:        app.DefaultConfig.NegativeCacheSize,
+			StaleDataAfter:           Duration(app.DefaultConfig.StaleDataAfter),
+		},
+		LoadShedding: LoadSheddingConfig{
+			Enabled:        api.LoadShedding.Enabled,
+			Window:         Duration(api.LoadShedding.Window),
+			MinRequests:    api.LoadShedding.MinRequests,
+			ErrorRate:      api.LoadShedding.ErrorRate,
+			SlowRequest:    Duration(api.LoadShedding.SlowRequest),
+			SlowRate:       api.LoadShedding.SlowRate,
+			Step:           api.LoadShedding.Step,
+			MaxProbability: api.LoadShedding.MaxProbability,
+		},
+		Recordings: RecordingsConfig{
+			MaxAge:          Duration(30 * 24 * time.Hour),
+			BodyMaxBytes:    1 << 20,
+			CleanupInterval: Duration(time.Hour),
+		},
+		ImportChecks: ImportChecksConfig{
+			Trailing:         app.DefaultConfig.ImportCheckTrailing,
+			MinHistory:       app.DefaultConfig.ImportCheckMinHistory,
+			AddedThreshold:   app.DefaultConfig.ImportCheckAddedThreshold,
+			RemovedThreshold: app.DefaultConfig.ImportCheckRemovedThreshold,
+			MinChange:        app.DefaultConfig.ImportCheckMinChange,
+		},
+		ASN: ASNConfig{
+			MaxPrefixes: app.DefaultConfig.ASNMaxPrefixes,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:             Duration(24 * time.Hour),
+			BodyMaxBytes:    1 << 20,
+			CleanupInterval: Duration(time.Hour),
+		},
+	}
+}
+
+// Load reads the config file at path over the defaults and then applies the environment variable overrides
+// JSON, YAML (.yaml, .yml) and TOML (.toml) files are accepted, path may be empty to only use the defaults and environment
+// unknown keys in the file are logged, or are an error when strict is set
+func Load(path string, strict bool) (*Config, error) {
+	c := Default()
+	if path != "" {
+		unknown, err := decodeFile(path, c)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		if len(unknown) > 0 && strict {
+			return nil, fmt.Errorf("config %s: unknown settings %s", path, strings.Join(unknown, ", "))
+		}
+		for _, key := range unknown {
+			logging.Warnf("config %s: unknown setting %s ignored", path, key)
+		}
+	}
+	err := applyEnv(c, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	// legacy environment variables
+	if c.Database.DSN == "" {
+		c.Database.DSN = os.Getenv("DATABASE_URL")
+	}
+	if c.Admin.Token == "" {
+		c.Admin.Token = os.Getenv("ADMIN_TOKEN")
+	}
+	return c, nil
+}
+
+// ListenAddrs returns the ip:port addresses of the main listeners, Http.Listen or else Http.IP and Http.Port
+func (c *Config) ListenAddrs() []string {
+	if len(c.HTTP.Listen) > 0 {
+		return c.HTTP.Listen
+	}
+	return []string{net.JoinHostPort(c.HTTP.IP, strconv.Itoa(c.HTTP.Port))}
+}
+
+// Datastore returns the datastore settings
+func (c *Config) Datastore() datastore.Config {
+	views := make([]datastore.MaterializedView, 0, len(c.Database.MaterializedViews))
+	for _, v := range c.Database.MaterializedViews {
+		views = append(views, datastore.MaterializedView{
+			Name:         v.Name,
+			Schedule:     v.Schedule,
+			Concurrently: v.Concurrently,
+		})
+	}
+	return datastore.Config{
+		DSN:                c.Database.DSN,
+		BreakerThreshold:   c.Database.BreakerThreshold,
+		BreakerCooldown:    time.Duration(c.Database.BreakerCooldown),
+		MaxRetries:         c.Database.MaxRetries,
+		RetryBackoff:       time.Duration(c.Database.RetryBackoff),
+		SlowQueryThreshold: time.Duration(c.Database.SlowQueryThreshold),
+		MaxRows:            c.Database.MaxRows,
+		MaterializedViews:  views,
+
+		MaxParallelZoneQueries: c.Database.MaxParallelZoneQueries,
+	}
+}
+
+// App returns the application settings
+func (c *Config) App() app.Config {
+	return app.Config{
+		FeedCacheSize:               c.API.FeedCacheSize,
+		FeedCacheTTL:                time.Duration(c.API.FeedCacheTTL),
+		NameServerStatsCacheSize:    c.API.NameServerStatsCacheSize,
+		StatsInterval:               time.Duration(c.Jobs.StatsInterval),
+		ProviderStatsInterval:       time.Duration(c.Jobs.ProvidersInterval),
+		ZoneDiffMaxDays:             c.API.ZoneDiffMaxDays,
+		ZoneDiffCacheSize:           c.API.ZoneDiffCacheSize,
+		ZoneDiffTimeout:             time.Duration(c.API.ZoneDiffTimeout),
+		FeedExportDir:               c.API.FeedExportDir,
+		FeedExportDays:              c.API.FeedExportDays,
+		FeedExportBaseURL:           c.API.FeedExportBaseURL,
+		FeedExportInterval:          time.Duration(c.Jobs.FeedExportsInterval),
+		NameServerSetsInterval:      time.Duration(c.Jobs.NameServerSetsInterval),
+		GlueInterval:                time.Duration(c.Jobs.GlueInterval),
+		LifetimesInterval:           time.Duration(c.Jobs.LifetimesInterval),
+		Keywords:                    c.Keywords.Tracked,
+		Sources:                     c.Zones.Sources,
+		KeywordsInterval:            time.Duration(c.Jobs.KeywordsInterval),
+		WatchlistsPerKey:            c.Watchlists.MaxPerKey,
+		WatchlistsExpensivePerKey:   c.Watchlists.MaxExpensivePerKey,
+		WatchlistsInterval:          time.Duration(c.Jobs.WatchlistsInterval),
+		NegativeCacheBytes:          int64(c.API.NegativeCacheMB) << 20,
+		NegativeCacheSize:           c.API.NegativeCacheSize,
+		NegativeCacheInterval:       time.Duration(c.Jobs.NegativeCacheInterval),
+		StaleDataAfter:              time.Duration(c.API.StaleDataAfter),
+		FreshnessInterval:           time.Duration(c.Jobs.FreshnessInterval),
+		ImportCheckTrailing:         c.ImportChecks.Trailing,
+		ImportCheckMinHistory:       c.ImportChecks.MinHistory,
+		ImportCheckAddedThreshold:   c.ImportChecks.AddedThreshold,
+		ImportCheckRemovedThreshold: c.ImportChecks.RemovedThreshold,
+		ImportCheckMinChange:        c.ImportChecks.MinChange,
+		ImportChecksInterval:        time.Duration(c.Jobs.ImportChecksInterval),
+		LabelStatsInterval:          time.Duration(c.Jobs.LabelStatsInterval),
+		StabilityInterval:           time.Duration(c.Jobs.StabilityInterval),
+		LiveDNSEnabled:              c.LiveDNS.Enabled,
+		LiveDNSResolver:             c.LiveDNS.Resolver,
+		LiveDNSTimeout:              time.Duration(c.LiveDNS.Timeout),
+		LiveDNSCacheSize:            c.LiveDNS.CacheSize,
+		LiveDNSCacheTTL:             time.Duration(c.LiveDNS.CacheTTL),
+		LiveDNSRequestsPerMinute:    c.LiveDNS.RequestsPerMinute,
+		LiveDNSRequestsBurst:        c.LiveDNS.RequestsBurst,
+		ASNFile:                     c.ASN.File,
+		ASNInterval:                 time.Duration(c.Jobs.ASNInterval),
+		ASNMaxPrefixes:              c.ASN.MaxPrefixes,
+	}
+}
+
+// CacheTTLs returns how long the app caches keep their responses
+func (c *Config) CacheTTLs() app.TTLs {
+	return app.TTLs{Feed: time.Duration(c.API.FeedCacheTTL), LiveDNS: time.Duration(c.LiveDNS.CacheTTL)}
+}
+
+// Classifier returns the nameserver provider classifier
+func (c *Config) Classifier() (*provider.Classifier, error) {
+	configured := make(map[string]bool, len(c.Providers.Patterns))
+	patterns := make([]provider.Pattern, 0, len(c.Providers.Patterns))
+	for _, p := range c.Providers.Patterns {
+		configured[strings.ToUpper(strings.TrimSuffix(p.Suffix, "."))] = true
+		patterns = append(patterns, provider.Pattern{Suffix: p.Suffix, Provider: p.Provider})
+	}
+	if c.Providers.Defaults {
+		for _, p := range provider.Defaults {
+			if !configured[p.Suffix] {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	return provider.New(patterns)
+}
+
+// Server returns the server settings
+func (c *Config) Server() server.APIConfig {
+	keys := make([]server.APIKey, 0, len(c.API.Keys))
+	for _, k := range c.API.Keys {
+		keys = append(keys, server.APIKey{Name: k.Name, Key: k.Key, Scopes: k.Scopes})
+	}
+	zones := make([]server.RestrictedZone, 0, len(c.Zones.Restricted))
+	for _, z := range c.Zones.Restricted {
+		zones = append(zones, server.RestrictedZone{Zone: z.Zone, Source: z.Source, Scopes: z.Scopes})
+	}
+	tenants := make([]server.Tenant, 0, len(c.Tenants.List))
+	for _, t := range c.Tenants.List {
+		var restricted []server.RestrictedZone
+		if t.RestrictedZones != nil {
+			restricted = make([]server.RestrictedZone, 0, len(t.RestrictedZones))
+			for _, z := range t.RestrictedZones {
+				restricted = append(restricted, server.RestrictedZone{Zone: z.Zone, Source: z.Source, Scopes: z.Scopes})
+			}
+		}
+		tenants = append(tenants, server.Tenant{
+			Name:              t.Name,
+			Hosts:             t.Hosts,
+			RequestsPerMinute: t.RequestsPerMinute,
+			RequestsBurst:     t.RequestsBurst,
+			RestrictedZones:   restricted,
+			CORSOrigins:       t.CORSOrigins,
+			DebugStats:        t.DebugStats,
+			HideDebugVars:     t.HideDebugVars,
+		})
+	}
+	sunsets := make(map[string]time.Time, len(c.API.Sunsets))
+	for path, date := range c.API.Sunsets {
+		// invalid dates are reported by Validate
+		if t, err := time.Parse("2006-01-02", date); err == nil {
+			sunsets[path] = t
+		}
+	}
+	return server.APIConfig{
+		TrustedProxies:        c.HTTP.TrustedProxies,
+		IdleTimeout:           time.Duration(c.HTTP.IdleTimeout),
+		MaxConcurrentStreams:  uint32(c.HTTP.MaxConcurrentStreams),
+		H2C:                   c.HTTP.H2C,
+		APITimeout:            c.API.Timeout,
+		APIRequestsPerMinute:  c.API.RequestsPerMinute,
+		APIMaxRequestHistory:  c.API.RequestsMaxHistory,
+		APIRequestsBurst:      c.API.RequestsBurst,
+		RateLimitStoreShards:  c.API.RequestsHistoryShards,
+		RateLimitMode:         c.API.RateLimitMode,
+		DebugStats:            c.API.DebugStats,
+		Sunsets:               sunsets,
+		EnforceSunsets:        c.API.EnforceSunsets,
+		DisabledRoutes:        c.API.DisabledRoutes,
+		BanThreshold:          c.API.BanThreshold,
+		BanWindow:             time.Duration(c.API.BanWindow),
+		BanDuration:           time.Duration(c.API.BanDuration),
+		MaxInFlight:           c.API.MaxInFlight,
+		MaxInFlightPerClient:  c.API.MaxInFlightPerClient,
+		MaxResponseBytes:      c.API.MaxResponseBytes,
+		CursorSecret:          c.API.CursorSecret,
+		CursorTTL:             time.Duration(c.API.CursorTTL),
+		APIKeys:               keys,
+		RestrictedZones:       zones,
+		RestrictedZoneContact: c.Zones.Contact,
+		AccessLogSampleRate:   c.Log.SampleRate,
+		SlowRequestThreshold:  time.Duration(c.Log.SlowRequestThreshold),
+		SampleNotFound:        c.Log.SampleNotFound,
+		Tenants:               tenants,
+		AdminToken:            c.Admin.Token,
+		AdminListen:           c.Admin.Listen,
+		AdminTLSCert:          c.Admin.TLSCert,
+		AdminTLSKey:           c.Admin.TLSKey,
+		AdminClientCA:         c.Admin.ClientCA,
+		ImportHookSecret:      c.ImportHook.Secret,
+		ImportHookCIDRs:       c.ImportHook.AllowedCIDRs,
+		AdminAllowCIDRs:       c.API.AdminAllowCIDRs,
+		InternalAllowCIDRs:    c.API.InternalAllowCIDRs,
+		Maintenance:           c.Maintenance.Enabled,
+		MaintenanceMessage:    c.Maintenance.Message,
+		LoadShedding:          c.LoadShedding.Server(),
+	}
+}
+
+// Server returns the recording bounds of the server
+func (rc RecordingsConfig) Server() server.Recordings {
+	return server.Recordings{
+		Max:             rc.Max,
+		MaxAge:          time.Duration(rc.MaxAge),
+		BodyMaxBytes:    rc.BodyMaxBytes,
+		CleanupInterval: time.Duration(rc.CleanupInterval),
+	}
+}
+
+// Server returns the idempotency bounds of the server
+func (ic IdempotencyConfig) Server() server.Idempotency {
+	return server.Idempotency{
+		TTL:             time.Duration(ic.TTL),
+		BodyMaxBytes:    ic.BodyMaxBytes,
+		CleanupInterval: time.Duration(ic.CleanupInterval),
+	}
+}
+
+// Server returns the load shedding settings of the server
+func (ls LoadSheddingConfig) Server() server.LoadShedding {
+	return server.LoadShedding{
+		Enabled:        ls.Enabled,
+		Window:         time.Duration(ls.Window),
+		MinRequests:    ls.MinRequests,
+		ErrorRate:      ls.ErrorRate,
+		SlowRequest:    time.Duration(ls.SlowRequest),
+		SlowRate:       ls.SlowRate,
+		Step:           ls.Step,
+		MaxProbability: ls.MaxProbability,
+	}
+}
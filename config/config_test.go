@@ -0,0 +1,332 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a config file named name in a temporary directory and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadPrecedence checks that the environment overrides the file, which overrides the defaults
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"Http": {"Port": 9000, "IP": "127.0.0.1"}, "API": {"Timeout": 7}}`)
+	t.Setenv("DNSCOFFEE_HTTP_PORT", "9100")
+	t.Setenv("DNSCOFFEE_HTTP_TRUSTED_PROXIES", `["10.0.0.0/8", "::1/128"]`)
+	t.Setenv("DNSCOFFEE_HTTP_IDLE_TIMEOUT", "90s")
+	c, err := Load(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := Default()
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"environment over file", c.HTTP.Port, 9100},
+		{"file over default", c.HTTP.IP, "127.0.0.1"},
+		{"file only", c.API.Timeout, 7},
+		{"default", c.API.RequestsPerMinute, def.API.RequestsPerMinute},
+		{"list from environment", c.HTTP.TrustedProxies, []string{"10.0.0.0/8", "::1/128"}},
+		{"duration from environment", c.HTTP.IdleTimeout, Duration(90 * time.Second)},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"Http": {"Port": 9000}, "API": {"Requests_Per_Minute": 5}}`,
+		"config.yaml": "Http:\n  Port: 9000\nAPI:\n  Requests_Per_Minute: 5\n",
+		"config.yml":  "Http: {Port: 9000}\nAPI: {Requests_Per_Minute: 5}\n",
+		"config.toml": "[Http]\nPort = 9000\n[API]\nRequests_Per_Minute = 5\n",
+	}
+	for name, content := range files {
+		c, err := Load(writeConfig(t, name, content), true)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if c.HTTP.Port != 9000 || c.API.RequestsPerMinute != 5 {
+			t.Errorf("%s: got port %d and %d requests a minute", name, c.HTTP.Port, c.API.RequestsPerMinute)
+		}
+	}
+	if _, err := Load(writeConfig(t, "config.ini", "Port=9000"), false); err == nil {
+		t.Error("unknown format loaded")
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"Http": {"Port": 9000, "Prot": 1}, "Nope": {}}`)
+	if _, err := Load(path, true); err == nil || !strings.Contains(err.Error(), "Http.Prot") || !strings.Contains(err.Error(), "Nope") {
+		t.Errorf("strict: got %v", err)
+	}
+	c, err := Load(path, false)
+	if err != nil || c.HTTP.Port != 9000 {
+		t.Errorf("not strict: got %v", err)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	secret := writeConfig(t, "token", "from-file\n")
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		invalid bool
+	}{
+		{name: "variable", env: map[string]string{"DNSCOFFEE_ADMIN_TOKEN": "from-env"}, want: "from-env"},
+		{name: "file", env: map[string]string{"DNSCOFFEE_ADMIN_TOKEN_FILE": secret}, want: "from-file"},
+		{name: "both", env: map[string]string{"DNSCOFFEE_ADMIN_TOKEN": "a", "DNSCOFFEE_ADMIN_TOKEN_FILE": secret}, invalid: true},
+		{name: "missing file", env: map[string]string{"DNSCOFFEE_ADMIN_TOKEN_FILE": secret + ".missing"}, invalid: true},
+		{name: "legacy", env: map[string]string{"ADMIN_TOKEN": "legacy"}, want: "legacy"},
+		{name: "legacy overridden", env: map[string]string{"ADMIN_TOKEN": "legacy", "DNSCOFFEE_ADMIN_TOKEN": "from-env"}, want: "from-env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"DNSCOFFEE_ADMIN_TOKEN", "DNSCOFFEE_ADMIN_TOKEN_FILE", "ADMIN_TOKEN"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			c, err := Load("", false)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got token %q, want an error", c.Admin.Token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Admin.Token != tt.want {
+				t.Errorf("got %q, want %q", c.Admin.Token, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	env := map[string]string{
+		"DNSCOFFEE_HTTP_PORT":            "eighty",
+		"DNSCOFFEE_HTTP_IDLE_TIMEOUT":    "soon",
+		"DNSCOFFEE_HTTP_TRUSTED_PROXIES": "10.0.0.0/8",
+		"DNSCOFFEE_API_TIMEOUT":          "5",
+	}
+	c := Default()
+	err := applyEnv(c, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	// every invalid value is reported at once
+	for _, name := range []string{"DNSCOFFEE_HTTP_PORT", "DNSCOFFEE_HTTP_IDLE_TIMEOUT", "DNSCOFFEE_HTTP_TRUSTED_PROXIES"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s missing from %q", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "DNSCOFFEE_API_TIMEOUT") || c.API.Timeout != 5 {
+		t.Errorf("valid value not applied: %q, timeout %d", err, c.API.Timeout)
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	// every setting has a distinct variable
+	seen := make(map[string]bool)
+	env := func(name string) (string, bool) {
+		if seen[name] {
+			t.Errorf("%s is the variable of two settings", name)
+		}
+		seen[name] = true
+		return "", false
+	}
+	if err := applyEnv(Default(), env); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"DNSCOFFEE_HTTP_PORT", "DNSCOFFEE_API_REQUESTS_PER_MINUTE", "DNSCOFFEE_DATABASE_DSN", "DNSCOFFEE_DATABASE_DSN_FILE"} {
+		if !seen[name] {
+			t.Errorf("%s not looked up", name)
+		}
+	}
+}
+
+// TestValidateExpectedClients checks Requests_Max_History against the clients of the minutes a bucket takes to drain
+func TestValidateExpectedClients(t *testing.T) {
+	tests := []struct {
+		name                   string
+		clients, perMin, burst int
+		history                int
+		wantErr                string
+	}{
+		{name: "no check", clients: 0, perMin: 60, burst: 10, history: 1},
+		{name: "one minute", clients: 1000, perMin: 60, burst: 10, history: 1000},
+		{name: "one minute too small", clients: 1000, perMin: 60, burst: 10, history: 999, wantErr: "API.Requests_Max_History: 999 is too small for 1000 clients a minute whose buckets take 1 minutes to drain, at least 1000 are needed"},
+		{name: "slow drain", clients: 1000, perMin: 10, burst: 30, history: 4000},
+		{name: "slow drain too small", clients: 1000, perMin: 10, burst: 30, history: 3000, wantErr: "at least 4000 are needed"},
+		{name: "negative", clients: -1, perMin: 60, burst: 10, history: 100, wantErr: "API.Expected_Clients: must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.API.ExpectedClients, c.API.RequestsPerMinute, c.API.RequestsBurst, c.API.RequestsMaxHistory = tt.clients, tt.perMin, tt.burst, tt.history
+			var got []string
+			for _, err := range c.Validate() {
+				if strings.HasPrefix(err.Error(), "API.Requests_Max_History") || strings.HasPrefix(err.Error(), "API.Expected_Clients") {
+					got = append(got, err.Error())
+				}
+			}
+			if tt.wantErr == "" && len(got) != 0 || tt.wantErr != "" && (len(got) != 1 || !strings.Contains(got[0], tt.wantErr)) {
+				t.Errorf("got %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLogSampling(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    int
+		slow    Duration
+		wantErr string
+	}{
+		{name: "every request", rate: 1, slow: Duration(time.Second)},
+		{name: "sampled", rate: 100},
+		{name: "zero rate", rate: 0, wantErr: "Log.Sample_Rate: must be at least 1"},
+		{name: "negative threshold", rate: 1, slow: Duration(-time.Second), wantErr: "Log.Slow_Request_Threshold: must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Log.SampleRate, c.Log.SlowRequestThreshold = tt.rate, tt.slow
+			var got []string
+			for _, err := range c.Validate() {
+				if strings.HasPrefix(err.Error(), "Log.") {
+					got = append(got, err.Error())
+				}
+			}
+			if tt.wantErr == "" && len(got) != 0 || tt.wantErr != "" && (len(got) != 1 || !strings.HasPrefix(got[0], tt.wantErr)) {
+				t.Errorf("got %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMaxParallelZoneQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel int
+		wantErr  string
+	}{
+		{name: "default", parallel: Default().Database.MaxParallelZoneQueries},
+		{name: "one at a time", parallel: 1},
+		{name: "zero", parallel: 0, wantErr: "Database.Max_Parallel_Zone_Queries: must be at least 1"},
+		{name: "negative", parallel: -2, wantErr: "Database.Max_Parallel_Zone_Queries: must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Database.MaxParallelZoneQueries = tt.parallel
+			var got []string
+			for _, err := range c.Validate() {
+				if strings.HasPrefix(err.Error(), "Database.Max_Parallel_Zone_Queries") {
+					got = append(got, err.Error())
+				}
+			}
+			if tt.wantErr == "" && len(got) != 0 || tt.wantErr != "" && (len(got) != 1 || !strings.HasPrefix(got[0], tt.wantErr)) {
+				t.Errorf("got %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFreshness(t *testing.T) {
+	tests := []struct {
+		name                string
+		staleAfter, refresh Duration
+		wantErr             []string
+	}{
+		{name: "default", staleAfter: Default().API.StaleDataAfter, refresh: Default().Jobs.FreshnessInterval},
+		{name: "never warns", staleAfter: 0, refresh: Duration(time.Minute)},
+		{name: "negative stale after", staleAfter: Duration(-time.Hour), refresh: Duration(time.Minute), wantErr: []string{"API.Stale_Data_After: must not be negative"}},
+		{name: "no refresh", staleAfter: Duration(time.Hour), refresh: 0, wantErr: []string{"Jobs.Freshness_Interval: must be positive"}},
+		{name: "negative refresh", staleAfter: Duration(time.Hour), refresh: Duration(-time.Minute), wantErr: []string{"Jobs.Freshness_Interval: must be positive"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.API.StaleDataAfter = tt.staleAfter
+			c.Jobs.FreshnessInterval = tt.refresh
+			var got []string
+			for _, err := range c.Validate() {
+				if strings.HasPrefix(err.Error(), "API.Stale_Data_After") || strings.HasPrefix(err.Error(), "Jobs.Freshness_Interval") {
+					got = append(got, err.Error())
+				}
+			}
+			if len(got) != len(tt.wantErr) {
+				t.Fatalf("got %q, want %q", got, tt.wantErr)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.wantErr[i]) {
+					t.Errorf("got %q, want %q", got[i], tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateIdempotency(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(c *IdempotencyConfig)
+		wantErr []string
+	}{
+		{name: "default", set: func(c *IdempotencyConfig) {}},
+		{name: "memory", set: func(c *IdempotencyConfig) { c.Store = IdempotencyStoreMemory }},
+		{name: "postgres", set: func(c *IdempotencyConfig) { c.Store = IdempotencyStorePostgres }},
+		{name: "unknown store", set: func(c *IdempotencyConfig) { c.Store = "redis" }, wantErr: []string{"Idempotency.Store: must be empty, memory or postgres"}},
+		{name: "no ttl", set: func(c *IdempotencyConfig) { c.Store, c.TTL = IdempotencyStoreMemory, 0 }, wantErr: []string{"Idempotency.TTL: must be positive"}},
+		{name: "no cleanup", set: func(c *IdempotencyConfig) { c.Store, c.CleanupInterval = IdempotencyStorePostgres, -1 },
+			wantErr: []string{"Idempotency.Cleanup_Interval: must be positive"}},
+		// the durations are only checked when a store is set
+		{name: "disabled without ttl", set: func(c *IdempotencyConfig) { c.TTL, c.CleanupInterval = 0, 0 }},
+		{name: "negative body size", set: func(c *IdempotencyConfig) { c.BodyMaxBytes = -1 }, wantErr: []string{"Idempotency.Body_Max_Bytes: must not be negative"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.set(&c.Idempotency)
+			var got []string
+			for _, err := range c.Validate() {
+				if strings.HasPrefix(err.Error(), "Idempotency.") {
+					got = append(got, err.Error())
+				}
+			}
+			if len(got) != len(tt.wantErr) {
+				t.Fatalf("got %q, want %q", got, tt.wantErr)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.wantErr[i]) {
+					t.Errorf("got %q, want %q", got[i], tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
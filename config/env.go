@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of all config environment variables
+const envPrefix = "DNSCOFFEE_"
+
+// envName returns the environment variable for a field in a config section
+// ex: the Requests_Per_Minute field of the API section is DNSCOFFEE_API_REQUESTS_PER_MINUTE
+func envName(section, field reflect.StructField) string {
+	return envPrefix + strings.ToUpper(section.Tag.Get("json")+"_"+field.Tag.Get("json"))
+}
+
+// applyEnv overrides the config fields that have an environment variable set
+// fields tagged as secret may also be read from the file named by the variable with a _FILE suffix
+// all invalid values are reported together
+func applyEnv(c *Config, lookup func(string) (string, bool)) error {
+	var problems []string
+	cv := reflect.ValueOf(c).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		section := cv.Type().Field(i)
+		sv := cv.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			field := sv.Type().Field(j)
+			name := envName(section, field)
+			value, ok := lookup(name)
+			if field.Tag.Get("secret") == "true" {
+				if path, fileOK := lookup(name + "_FILE"); fileOK {
+					if ok {
+						problems = append(problems, fmt.Sprintf("%s and %s_FILE are both set", name, name))
+						continue
+					}
+					b, err := ioutil.ReadFile(path)
+					if err != nil {
+						problems = append(problems, fmt.Sprintf("%s_FILE: %s", name, err))
+						continue
+					}
+					value, ok = strings.TrimRight(string(b), "\r\n"), true
+				}
+			}
+			if !ok {
+				continue
+			}
+			err := setField(sv.Field(j), value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// setField parses value into the field according to its type
+// lists are given as JSON
+func setField(v reflect.Value, value string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		return json.Unmarshal([]byte(value), v.Addr().Interface())
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
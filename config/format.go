@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeFile reads the config file at path into c, the format is chosen by the file extension
+// every format is first decoded into a generic map and then decoded as JSON,
+// so that all formats use the same key names, defaults and value parsing
+// returns the keys in the file that do not match a setting
+func decodeFile(path string, c *Config) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	case ".json", "":
+		err = json.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := unknownKeys(raw, reflect.TypeOf(*c), "")
+	sort.Strings(unknown)
+
+	b, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return unknown, json.Unmarshal(b, c)
+}
+
+// unknownKeys returns the keys of m that have no matching field in the struct type t
+// keys match case insensitively like encoding/json
+func unknownKeys(m map[string]interface{}, t reflect.Type, prefix string) []string {
+	var unknown []string
+	for key, value := range m {
+		field, ok := fieldByTag(t, key)
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		switch ft := field.Type; {
+		case ft.Kind() == reflect.Struct:
+			if sub, ok := value.(map[string]interface{}); ok {
+				unknown = append(unknown, unknownKeys(sub, ft, prefix+key+".")...)
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			list, _ := value.([]interface{})
+			for i, item := range list {
+				if sub, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownKeys(sub, ft.Elem(), fmt.Sprintf("%s%s[%d].", prefix, key, i))...)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+// fieldByTag returns the field of t with the json name key
+func fieldByTag(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.EqualFold(f.Tag.Get("json"), key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
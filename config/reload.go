@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+)
+
+// reloadable are the settings that a reload applies to the running server
+// all other settings need a restart to take effect
+var reloadable = map[string]bool{
+	"API.Requests_Per_Minute":       true,
+	"API.Requests_Burst":            true,
+	"API.Rate_Limit_Mode":           true,
+	"API.Admin_Allow_CIDRs":         true,
+	"API.Internal_Allow_CIDRs":      true,
+	"API.Disabled_Routes":           true,
+	"API.Feed_Cache_TTL":            true,
+	"Database.Slow_Query_Threshold": true,
+	"Log.Level":                     true,
+	"Log.Output":                    true,
+	"Log.Sample_Rate":               true,
+	"Log.Slow_Request_Threshold":    true,
+	"Log.Sample_Not_Found":          true,
+	"Load_Shedding.Enabled":         true,
+	"Load_Shedding.Window":          true,
+	"Load_Shedding.Min_Requests":    true,
+	"Load_Shedding.Error_Rate":      true,
+	"Load_Shedding.Slow_Request":    true,
+	"Load_Shedding.Slow_Rate":       true,
+	"Load_Shedding.Step":            true,
+	"Load_Shedding.Max_Probability": true,
+	"Providers.Defaults":            true,
+	"Providers.Patterns":            true,
+	"Live_DNS.Cache_TTL":            true,
+}
+
+// Changes returns the names of the settings that differ between old and new,
+// split into those that can be applied at runtime and those that need a restart
+func Changes(old, new *Config) (applied, restart []string) {
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		section := ov.Type().Field(i)
+		for j := 0; j < ov.Field(i).NumField(); j++ {
+			field := section.Type.Field(j)
+			if reflect.DeepEqual(ov.Field(i).Field(j).Interface(), nv.Field(i).Field(j).Interface()) {
+				continue
+			}
+			name := section.Tag.Get("json") + "." + field.Tag.Get("json")
+			if reloadable[name] {
+				applied = append(applied, name)
+			} else {
+				restart = append(restart, name)
+			}
+		}
+	}
+	return applied, restart
+}
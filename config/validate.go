@@ -0,0 +1,683 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/reporter"
+	"dnscoffee/schedule"
+	"dnscoffee/server"
+)
+
+// redacted replaces secrets when printing the config
+const redacted = "<redacted>"
+
+// Validate checks every setting and returns all of the problems found
+func (c *Config) Validate() []error {
+	var errs []error
+	problem := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	// Http
+	if c.HTTP.IP != "" && net.ParseIP(c.HTTP.IP) == nil {
+		problem("Http.IP", "%q is not an IP address", c.HTTP.IP)
+	}
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		problem("Http.Port", "%d is not between 1 and 65535", c.HTTP.Port)
+	}
+	listen := make(map[string]bool)
+	for _, addr := range c.HTTP.Listen {
+		if err := validListenAddr(addr); err != nil {
+			problem("Http.Listen", "%s", err)
+		} else if listen[addr] {
+			problem("Http.Listen", "duplicate address %q", addr)
+		}
+		listen[addr] = true
+	}
+	for _, cidr := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problem("Http.Trusted_Proxies", "%q is not a CIDR", cidr)
+		}
+	}
+	if c.HTTP.IdleTimeout < 0 {
+		problem("Http.Idle_Timeout", "must not be negative")
+	}
+	if c.HTTP.MaxConcurrentStreams < 1 || c.HTTP.MaxConcurrentStreams > math.MaxInt32 {
+		problem("Http.Max_Concurrent_Streams", "%d is not between 1 and %d", c.HTTP.MaxConcurrentStreams, math.MaxInt32)
+	}
+
+	// Database
+	if c.Database.BreakerThreshold < 0 {
+		problem("Database.Breaker_Threshold", "must not be negative")
+	}
+	if c.Database.BreakerThreshold > 0 && c.Database.BreakerCooldown <= 0 {
+		problem("Database.Breaker_Cooldown", "must be positive when the breaker is enabled")
+	}
+	if c.Database.MaxRetries < 0 {
+		problem("Database.Max_Retries", "must not be negative")
+	}
+	if c.Database.MaxRetries > 0 && c.Database.RetryBackoff <= 0 {
+		problem("Database.Retry_Backoff", "must be positive when retries are enabled")
+	}
+	if c.Database.MaxRows < 0 {
+		problem("Database.Max_Rows", "must not be negative")
+	}
+	if c.Database.MaxParallelZoneQueries < 1 {
+		problem("Database.Max_Parallel_Zone_Queries", "must be at least 1")
+	}
+	if c.Database.SlowQueryThreshold < 0 {
+		problem("Database.Slow_Query_Threshold", "must not be negative")
+	}
+	views := make(map[string]bool)
+	for i, v := range c.Database.MaterializedViews {
+		key := fmt.Sprintf("Database.Materialized_Views[%d]", i)
+		if v.Name == "" {
+			problem(key, "missing Name")
+		} else if views[v.Name] {
+			problem(key, "duplicate view %q", v.Name)
+		}
+		views[v.Name] = true
+		if v.Schedule != "" {
+			if _, err := schedule.Parse(v.Schedule); err != nil {
+				problem(key, "%s", err)
+			}
+		}
+	}
+
+	// API
+	if c.API.Timeout <= 0 {
+		problem("API.Timeout", "must be positive")
+	}
+	if c.API.RequestsPerMinute <= 0 {
+		problem("API.Requests_Per_Minute", "must be positive")
+	}
+	if c.API.RequestsMaxHistory <= 0 {
+		problem("API.Requests_Max_History", "must be positive")
+	}
+	if c.API.RequestsHistoryShards < 1 || c.API.RequestsHistoryShards > 1024 {
+		problem("API.Requests_History_Shards", "must be between 1 and 1024")
+	}
+	if c.API.ExpectedClients < 0 {
+		problem("API.Expected_Clients", "must not be negative")
+	}
+	// a client needs a bucket until it has drained, (burst + 1) / per minute minutes after its last request,
+	// so the clients of that many minutes, at least one, must fit in the store or limited clients get evicted
+	if c.API.ExpectedClients > 0 && c.API.RequestsPerMinute > 0 && c.API.RequestsBurst >= 0 {
+		minutes := (c.API.RequestsBurst + c.API.RequestsPerMinute) / c.API.RequestsPerMinute
+		if needed := c.API.ExpectedClients * minutes; c.API.RequestsMaxHistory < needed {
+			problem("API.Requests_Max_History", "%d is too small for %d clients a minute whose buckets take %d minutes to drain, at least %d are needed",
+				c.API.RequestsMaxHistory, c.API.ExpectedClients, minutes, needed)
+		}
+	}
+	if !server.ValidRateLimitMode(c.API.RateLimitMode) {
+		problem("API.Rate_Limit_Mode", "must be enforce, shadow or off")
+	}
+	if !server.ValidDebugStatsMode(c.API.DebugStats) {
+		problem("API.Debug_Stats", "must be off, admin or all")
+	}
+	for path, date := range c.API.Sunsets {
+		if !strings.HasPrefix(path, "/") {
+			problem("API.Sunsets", "%q is not a route path", path)
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			problem("API.Sunsets", "%q: %q is not a YYYY-MM-DD date", path, date)
+		}
+	}
+	for _, name := range c.API.DisabledRoutes {
+		if strings.TrimSpace(name) == "" {
+			problem("API.Disabled_Routes", "must not list a blank name")
+		}
+	}
+	if c.API.RequestsBurst < 0 {
+		problem("API.Requests_Burst", "must not be negative")
+	}
+	if c.Jobs.StatsInterval < 0 {
+		problem("Jobs.Stats_Interval", "must not be negative")
+	}
+	if c.API.FeedCacheSize < 0 {
+		problem("API.Feed_Cache_Size", "must not be negative")
+	}
+	if c.API.NameServerStatsCacheSize < 0 {
+		problem("API.Nameserver_Stats_Cache_Size", "must not be negative")
+	}
+	if c.API.FeedCacheSize > 0 && c.API.FeedCacheTTL <= 0 {
+		problem("API.Feed_Cache_TTL", "must be positive when the feed cache is enabled")
+	}
+	if c.API.ZoneDiffMaxDays < 0 {
+		problem("API.Zone_Diff_Max_Days", "must not be negative")
+	}
+	if c.API.ZoneDiffCacheSize < 0 {
+		problem("API.Zone_Diff_Cache_Size", "must not be negative")
+	}
+	if c.API.ZoneDiffTimeout <= 0 {
+		problem("API.Zone_Diff_Timeout", "must be positive")
+	}
+	if c.API.CursorTTL < 0 {
+		problem("API.Cursor_TTL", "must not be negative")
+	}
+	if c.API.MaxResponseBytes < 0 {
+		problem("API.Max_Response_Bytes", "must not be negative")
+	}
+	if c.API.MaxInFlight < 0 {
+		problem("API.Max_In_Flight", "must not be negative")
+	}
+	if c.API.MaxInFlightPerClient < 0 {
+		problem("API.Max_In_Flight_Per_Client", "must not be negative")
+	}
+	if c.API.MaxInFlight > 0 && c.API.MaxInFlightPerClient > c.API.MaxInFlight {
+		problem("API.Max_In_Flight_Per_Client", "must not be more than Max_In_Flight")
+	}
+	keys := make(map[string]bool)
+	for i, k := range c.API.Keys {
+		key := fmt.Sprintf("API.Keys[%d]", i)
+		if k.Name == "" {
+			problem(key, "missing Name")
+		}
+		switch {
+		case k.Key == "":
+			problem(key, "missing Key")
+		case len(k.Key) < 16:
+			problem(key, "Key must be at least 16 characters")
+		case keys[k.Key]:
+			problem(key, "duplicate Key")
+		}
+		keys[k.Key] = true
+	}
+	if c.API.BanThreshold < 0 {
+		problem("API.Ban_Threshold", "must not be negative")
+	}
+	if c.API.BanThreshold > 0 && c.API.BanWindow <= 0 {
+		problem("API.Ban_Window", "must be positive when banning is enabled")
+	}
+	if c.API.BanThreshold > 0 && c.API.BanDuration <= 0 {
+		problem("API.Ban_Duration", "must be positive when banning is enabled")
+	}
+
+	// Log
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		problem("Log.Level", "%s", err)
+	}
+	if c.Log.SampleRate < 1 {
+		problem("Log.Sample_Rate", "must be at least 1, 1 logs every request")
+	}
+	if c.Log.SlowRequestThreshold < 0 {
+		problem("Log.Slow_Request_Threshold", "must not be negative")
+	}
+
+	// Tracing
+	if c.Tracing.Endpoint != "" {
+		u, err := url.Parse(c.Tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("Tracing.Endpoint", "%q is not a http or https URL", c.Tracing.Endpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problem("Tracing.Sample_Ratio", "%g is not between 0 and 1", c.Tracing.SampleRatio)
+	}
+
+	// Errors
+	if c.Errors.SentryDSN != "" && c.Errors.WebhookURL != "" {
+		problem("Errors.Sentry_DSN", "only one of Sentry_DSN and Webhook_URL may be set")
+	}
+	if c.Errors.SentryDSN != "" {
+		if _, err := reporter.NewSentry(c.Errors.SentryDSN); err != nil {
+			problem("Errors.Sentry_DSN", "%s", err)
+		}
+	}
+	if c.Errors.WebhookURL != "" {
+		u, err := url.Parse(c.Errors.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("Errors.Webhook_URL", "%q is not a http or https URL", c.Errors.WebhookURL)
+		}
+	}
+	if c.Errors.QueueSize <= 0 {
+		problem("Errors.Queue_Size", "must be positive")
+	}
+
+	// Audit
+	switch c.Audit.Sink {
+	case "", AuditSinkPostgres:
+	case AuditSinkFile:
+		if c.Audit.File == "" {
+			problem("Audit.File", "required with the file sink")
+		}
+	default:
+		problem("Audit.Sink", "must be empty, postgres or file")
+	}
+	if c.Audit.QueueSize <= 0 {
+		problem("Audit.Queue_Size", "must be positive")
+	}
+
+	// Zones
+	sources := make(map[string]bool)
+	for _, source := range c.Zones.Sources {
+		if !validSource(source) {
+			problem("Zones.Sources", "%q must be 1 to 32 lower case letters, digits or underscores", source)
+		} else if sources[source] {
+			problem("Zones.Sources", "duplicate source %q", source)
+		}
+		sources[source] = true
+	}
+	validRestrictedZones(problem, "Zones.Restricted", c.Zones.Restricted, sources)
+
+	// Tenants
+	tenantNames := make(map[string]bool)
+	tenantHosts := make(map[string]bool)
+	for i, t := range c.Tenants.List {
+		key := fmt.Sprintf("Tenants.List[%d]", i)
+		name := strings.ToLower(t.Name)
+		if name == "" {
+			problem(key, "missing Name")
+		} else if name == server.DefaultTenant {
+			problem(key, "the name %q is taken by the requests to other hosts", t.Name)
+		} else if tenantNames[name] {
+			problem(key, "duplicate name %q", t.Name)
+		}
+		tenantNames[name] = true
+		if len(t.Hosts) == 0 {
+			problem(key, "missing Hosts")
+		}
+		for _, host := range t.Hosts {
+			h := strings.TrimSuffix(strings.ToLower(host), ".")
+			if h == "" {
+				problem(key+".Hosts", "must not contain an empty host")
+			} else if strings.Contains(h, ":") && net.ParseIP(h) == nil {
+				problem(key+".Hosts", "%q must not have a port", host)
+			} else if tenantHosts[h] {
+				problem(key+".Hosts", "%q is already the host of another tenant", host)
+			}
+			tenantHosts[h] = true
+		}
+		if t.RequestsPerMinute < 0 {
+			problem(key+".Requests_Per_Minute", "must not be negative")
+		}
+		if t.RequestsBurst < 0 {
+			problem(key+".Requests_Burst", "must not be negative")
+		}
+		if t.DebugStats != "" && !server.ValidDebugStatsMode(t.DebugStats) {
+			problem(key+".Debug_Stats", "must be empty, off, admin or all")
+		}
+		validRestrictedZones(problem, key+".Restricted_Zones", t.RestrictedZones, sources)
+	}
+
+	// Providers
+	if _, err := c.Classifier(); err != nil {
+		problem("Providers.Patterns", "%s", err)
+	}
+	if c.Jobs.ProvidersInterval < 0 {
+		problem("Jobs.Providers_Interval", "must not be negative")
+	}
+
+	// Feed exports
+	if c.API.FeedExportDir != "" {
+		if info, err := os.Stat(c.API.FeedExportDir); err != nil {
+			problem("API.Feed_Export_Dir", "%s", err)
+		} else if !info.IsDir() {
+			problem("API.Feed_Export_Dir", "is not a directory")
+		}
+	}
+	if c.API.FeedExportBaseURL != "" {
+		u, err := url.Parse(c.API.FeedExportBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("API.Feed_Export_Base_URL", "%q is not a http or https URL", c.API.FeedExportBaseURL)
+		}
+	}
+	if c.API.FeedExportDays <= 0 {
+		problem("API.Feed_Export_Days", "must be positive")
+	}
+	if c.Jobs.FeedExportsInterval <= 0 {
+		problem("Jobs.Feed_Exports_Interval", "must be positive")
+	}
+
+	// Nameserver sets
+	if c.Jobs.NameServerSetsInterval <= 0 {
+		problem("Jobs.Nameserver_Sets_Interval", "must be positive")
+	}
+
+	// Glue
+	if c.Jobs.GlueInterval <= 0 {
+		problem("Jobs.Glue_Interval", "must be positive")
+	}
+
+	// Lifetimes
+	if c.Jobs.LifetimesInterval < 0 {
+		problem("Jobs.Lifetimes_Interval", "must not be negative")
+	}
+
+	// Keywords
+	keywords := make(map[string]bool)
+	for _, keyword := range c.Keywords.Tracked {
+		if !validKeyword(keyword) {
+			problem("Keywords.Tracked", "%q must be 2 to 63 lower case letters, digits or hyphens", keyword)
+		} else if keywords[keyword] {
+			problem("Keywords.Tracked", "duplicate keyword %q", keyword)
+		}
+		keywords[keyword] = true
+	}
+	if c.Jobs.KeywordsInterval <= 0 {
+		problem("Jobs.Keywords_Interval", "must be positive")
+	}
+
+	// Watchlists
+	if c.Watchlists.MaxPerKey < 0 {
+		problem("Watchlists.Max_Per_Key", "must not be negative")
+	}
+	if c.Watchlists.MaxExpensivePerKey < 0 {
+		problem("Watchlists.Max_Expensive_Per_Key", "must not be negative")
+	} else if c.Watchlists.MaxExpensivePerKey > c.Watchlists.MaxPerKey {
+		problem("Watchlists.Max_Expensive_Per_Key", "must not be more than Watchlists.Max_Per_Key")
+	}
+	if c.Jobs.WatchlistsInterval <= 0 {
+		problem("Jobs.Watchlists_Interval", "must be positive")
+	}
+
+	// Negative cache
+	if c.API.NegativeCacheMB < 0 {
+		problem("API.Negative_Cache_MB", "must not be negative")
+	}
+	if c.API.NegativeCacheSize < 0 {
+		problem("API.Negative_Cache_Size", "must not be negative")
+	}
+	if c.Jobs.NegativeCacheInterval <= 0 {
+		problem("Jobs.Negative_Cache_Interval", "must be positive")
+	}
+	if c.API.StaleDataAfter < 0 {
+		problem("API.Stale_Data_After", "must not be negative")
+	}
+	if c.Jobs.FreshnessInterval <= 0 {
+		problem("Jobs.Freshness_Interval", "must be positive")
+	}
+
+	// Import checks
+	if c.ImportChecks.Trailing <= 0 {
+		problem("Import_Checks.Trailing", "must be positive")
+	}
+	if c.ImportChecks.MinHistory < 0 {
+		problem("Import_Checks.Min_History", "must not be negative")
+	} else if c.ImportChecks.MinHistory > c.ImportChecks.Trailing {
+		problem("Import_Checks.Min_History", "must not be more than Import_Checks.Trailing")
+	}
+	if c.ImportChecks.AddedThreshold < 0 {
+		problem("Import_Checks.Added_Threshold", "must not be negative")
+	}
+	if c.ImportChecks.RemovedThreshold < 0 {
+		problem("Import_Checks.Removed_Threshold", "must not be negative")
+	}
+	if c.ImportChecks.MinChange < 0 {
+		problem("Import_Checks.Min_Change", "must not be negative")
+	}
+	if c.Jobs.ImportChecksInterval <= 0 {
+		problem("Jobs.Import_Checks_Interval", "must be positive")
+	}
+	if c.Jobs.LabelStatsInterval <= 0 {
+		problem("Jobs.Label_Stats_Interval", "must be positive")
+	}
+	if c.Jobs.StabilityInterval <= 0 {
+		problem("Jobs.Stability_Interval", "must be positive")
+	}
+	if c.Jobs.ASNInterval <= 0 {
+		problem("Jobs.ASN_Interval", "must be positive")
+	}
+
+	// Live DNS
+	if c.LiveDNS.Resolver != "" {
+		host, _, err := net.SplitHostPort(c.LiveDNS.Resolver)
+		if err != nil {
+			problem("Live_DNS.Resolver", "%s", err)
+		} else if net.ParseIP(host) == nil {
+			problem("Live_DNS.Resolver", "%q is not an IP address", host)
+		}
+	}
+	if c.LiveDNS.Timeout <= 0 {
+		problem("Live_DNS.Timeout", "must be positive")
+	}
+	if c.LiveDNS.CacheSize < 0 {
+		problem("Live_DNS.Cache_Size", "must not be negative")
+	}
+	if c.LiveDNS.CacheTTL <= 0 {
+		problem("Live_DNS.Cache_TTL", "must be positive")
+	}
+	if c.LiveDNS.RequestsPerMinute <= 0 {
+		problem("Live_DNS.Requests_Per_Minute", "must be positive")
+	}
+	if c.LiveDNS.RequestsBurst < 0 {
+		problem("Live_DNS.Requests_Burst", "must not be negative")
+	}
+
+	// Recordings
+	if c.Recordings.Max < 0 {
+		problem("Recordings.Max", "must not be negative")
+	}
+	if c.Recordings.MaxAge < 0 {
+		problem("Recordings.Max_Age", "must not be negative")
+	}
+	if c.Recordings.BodyMaxBytes < 0 {
+		problem("Recordings.Body_Max_Bytes", "must not be negative")
+	}
+	if c.Recordings.Max > 0 && c.Recordings.CleanupInterval <= 0 {
+		problem("Recordings.Cleanup_Interval", "must be positive")
+	}
+
+	// Idempotency
+	switch c.Idempotency.Store {
+	case "":
+	case IdempotencyStoreMemory, IdempotencyStorePostgres:
+		if c.Idempotency.TTL <= 0 {
+			problem("Idempotency.TTL", "must be positive")
+		}
+		if c.Idempotency.CleanupInterval <= 0 {
+			problem("Idempotency.Cleanup_Interval", "must be positive")
+		}
+	default:
+		problem("Idempotency.Store", "must be empty, memory or postgres")
+	}
+	if c.Idempotency.BodyMaxBytes < 0 {
+		problem("Idempotency.Body_Max_Bytes", "must not be negative")
+	}
+
+	// ASN
+	if c.ASN.File != "" {
+		if info, err := os.Stat(c.ASN.File); err != nil {
+			problem("ASN.File", "%s", err)
+		} else if info.IsDir() {
+			problem("ASN.File", "is a directory")
+		}
+	}
+	if c.ASN.MaxPrefixes < 0 {
+		problem("ASN.Max_Prefixes", "must not be negative")
+	}
+
+	// Load shedding
+	if c.LoadShedding.Window <= 0 {
+		problem("Load_Shedding.Window", "must be positive")
+	}
+	if c.LoadShedding.MinRequests < 0 {
+		problem("Load_Shedding.Min_Requests", "must not be negative")
+	}
+	if c.LoadShedding.ErrorRate <= 0 || c.LoadShedding.ErrorRate > 1 {
+		problem("Load_Shedding.Error_Rate", "must be above 0 and at most 1")
+	}
+	if c.LoadShedding.SlowRequest <= 0 {
+		problem("Load_Shedding.Slow_Request", "must be positive")
+	}
+	if c.LoadShedding.SlowRate <= 0 || c.LoadShedding.SlowRate > 1 {
+		problem("Load_Shedding.Slow_Rate", "must be above 0 and at most 1")
+	}
+	if c.LoadShedding.Step <= 0 || c.LoadShedding.Step > 1 {
+		problem("Load_Shedding.Step", "must be above 0 and at most 1")
+	}
+	if c.LoadShedding.MaxProbability <= 0 || c.LoadShedding.MaxProbability > 1 {
+		problem("Load_Shedding.Max_Probability", "must be above 0 and at most 1")
+	}
+
+	// Allowlists
+	for _, cidr := range c.API.AdminAllowCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			problem("API.Admin_Allow_CIDRs", "%q is not a CIDR", cidr)
+		}
+	}
+	for _, cidr := range c.API.InternalAllowCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			problem("API.Internal_Allow_CIDRs", "%q is not a CIDR", cidr)
+		}
+	}
+
+	// Import hook
+	for _, cidr := range c.ImportHook.AllowedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			problem("Import_Hook.Allowed_CIDRs", "%q is not a CIDR", cidr)
+		}
+	}
+
+	// Admin
+	if c.Admin.Listen != "" {
+		if err := validListenAddr(c.Admin.Listen); err != nil {
+			problem("Admin.Listen", "%s", err)
+		}
+		for _, addr := range c.ListenAddrs() {
+			if c.Admin.Listen == addr {
+				problem("Admin.Listen", "must differ from the main listeners")
+			}
+		}
+	}
+	if (c.Admin.TLSCert == "") != (c.Admin.TLSKey == "") {
+		problem("Admin.TLS_Cert", "TLS_Cert and TLS_Key must be set together")
+	}
+	if c.Admin.TLSCert != "" && c.Admin.Listen == "" {
+		problem("Admin.TLS_Cert", "requires Admin.Listen")
+	}
+	if c.Admin.ClientCA != "" && c.Admin.TLSCert == "" {
+		problem("Admin.Client_CA", "requires Admin.TLS_Cert")
+	}
+	files := [][2]string{
+		{"Admin.TLS_Cert", c.Admin.TLSCert},
+		{"Admin.TLS_Key", c.Admin.TLSKey},
+		{"Admin.Client_CA", c.Admin.ClientCA},
+	}
+	for _, f := range files {
+		if f[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(f[1]); err != nil {
+			problem(f[0], "%s", err)
+		}
+	}
+
+	return errs
+}
+
+// validListenAddr checks that addr is an ip:port
+func validListenAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("%q is not an IP address", host)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a valid port", port)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with the secrets replaced, for printing
+func (c *Config) Redacted() *Config {
+	out := *c
+	cv := reflect.ValueOf(&out).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		sv := cv.Field(i)
+		redactFields(sv)
+		// lists of settings such as API.Keys are copied before their secrets are replaced
+		for j := 0; j < sv.NumField(); j++ {
+			f := sv.Field(j)
+			if f.Kind() != reflect.Slice || f.Type().Elem().Kind() != reflect.Struct || f.Len() == 0 {
+				continue
+			}
+			list := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			reflect.Copy(list, f)
+			for k := 0; k < list.Len(); k++ {
+				redactFields(list.Index(k))
+			}
+			f.Set(list)
+		}
+	}
+	return &out
+}
+
+// redactFields replaces the secret string fields of the struct v
+func redactFields(v reflect.Value) {
+	for j := 0; j < v.NumField(); j++ {
+		if v.Type().Field(j).Tag.Get("secret") == "true" && v.Field(j).String() != "" {
+			v.Field(j).SetString(redacted)
+		}
+	}
+}
+
+// validRestrictedZones checks the restricted zones listed under key, each names a zone or one of sources
+func validRestrictedZones(problem func(key, format string, args ...interface{}), key string, restricted []RestrictedZone, sources map[string]bool) {
+	zones := make(map[string]bool)
+	restrictedSources := make(map[string]bool)
+	for i, z := range restricted {
+		zoneKey := fmt.Sprintf("%s[%d]", key, i)
+		name := strings.ToUpper(strings.TrimSuffix(z.Zone, "."))
+		switch {
+		case z.Source != "" && z.Zone != "":
+			problem(zoneKey, "must have a Zone or a Source, not both")
+		case z.Source != "":
+			if !sources[z.Source] {
+				problem(zoneKey, "unknown source %q, the sources are those of Zones.Sources", z.Source)
+			} else if restrictedSources[z.Source] {
+				problem(zoneKey, "duplicate source %q", z.Source)
+			}
+			restrictedSources[z.Source] = true
+		case name == "":
+			problem(zoneKey, "missing Zone, the root zone can not be restricted")
+		case zones[name]:
+			problem(zoneKey, "duplicate zone %q", z.Zone)
+		}
+		if z.Source == "" {
+			zones[name] = true
+		}
+		if len(z.Scopes) == 0 {
+			problem(zoneKey, "missing Scopes")
+		}
+	}
+}
+
+// validSource returns true if source is a lower case identifier, as the sources are named in the database and the API
+func validSource(source string) bool {
+	if source == "" || len(source) > 32 {
+		return false
+	}
+	for _, r := range source {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validKeyword returns true if keyword can be part of a domain label
+func validKeyword(keyword string) bool {
+	if len(keyword) < 2 || len(keyword) > 63 {
+		return false
+	}
+	for _, c := range keyword {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testCodec(t testing.TB, ttl time.Duration) *Codec {
+	t.Helper()
+	c, err := NewCodec("secret", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := testCodec(t, time.Hour)
+	filter := Filter("zone_domains", "COM", "all")
+	last := []string{"EXAMPLE.COM", "42"}
+	got, err := c.Decode(c.Encode("domain", last, filter), "domain", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, last) {
+		t.Errorf("got %q, want %q", got, last)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	if Filter("a", "bc") == Filter("a", "b", "c") || Filter("ab", "c") == Filter("a", "bc") {
+		t.Error("filters that differ hash the same")
+	}
+	if Filter("zone_domains", "COM") != Filter("zone_domains", "COM") {
+		t.Error("filter is not stable")
+	}
+}
+
+// signedToken returns a token for p signed by c, for payloads Encode would not produce
+func signedToken(c *Codec, p payload) string {
+	body, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(c.sign(body))
+}
+
+func TestDecodeRejects(t *testing.T) {
+	c := testCodec(t, 0)
+	filter := Filter("zone_domains", "COM")
+	token := c.Encode("domain", []string{"EXAMPLE.COM"}, filter)
+	body, sig, _ := strings.Cut(token, ".")
+	forged, _ := json.Marshal(payload{Sort: "domain", Last: []string{"ZZZ.COM"}, Filter: filter})
+	other := testCodec(t, 0)
+	other.secret = []byte("other secret")
+
+	tests := []struct {
+		name   string
+		token  string
+		sort   string
+		filter string
+		want   error
+	}{
+		{"empty", "", "domain", filter, ErrInvalid},
+		{"no signature", body, "domain", filter, ErrInvalid},
+		{"truncated body", body[:len(body)/2] + "." + sig, "domain", filter, ErrInvalid},
+		{"truncated signature", body + "." + sig[:len(sig)-2], "domain", filter, ErrInvalid},
+		{"tampered body", base64.RawURLEncoding.EncodeToString(forged) + "." + sig, "domain", filter, ErrInvalid},
+		{"tampered signature", body + "." + strings.Repeat("A", len(sig)), "domain", filter, ErrInvalid},
+		{"not base64", "!!!." + sig, "domain", filter, ErrInvalid},
+		{"other secret", other.Encode("domain", []string{"EXAMPLE.COM"}, filter), "domain", filter, ErrInvalid},
+		{"other endpoint", token, "domain", Filter("nsset", "COM"), ErrInvalid},
+		{"other filters", token, "domain", Filter("zone_domains", "NET"), ErrInvalid},
+		{"other sort key", token, "domain_id", filter, ErrInvalid},
+		{"too long", token + strings.Repeat("A", maxTokenLength), "domain", filter, ErrInvalid},
+		{"signed garbage", base64.RawURLEncoding.EncodeToString([]byte("{")) + "." + base64.RawURLEncoding.EncodeToString(c.sign([]byte("{"))), "domain", filter, ErrInvalid},
+		{"expired", signedToken(c, payload{Sort: "domain", Last: []string{"EXAMPLE.COM"}, Filter: filter, Expires: time.Now().Add(-time.Minute).Unix()}), "domain", filter, ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last, err := c.Decode(tt.token, tt.sort, tt.filter)
+			if err != tt.want {
+				t.Errorf("got %q, %v, want %v", last, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	filter := Filter("zone_domains", "COM")
+	c := testCodec(t, time.Hour)
+	token := c.Encode("domain", []string{"EXAMPLE.COM"}, filter)
+	if _, err := c.Decode(token, "domain", filter); err != nil {
+		t.Errorf("fresh cursor: %v", err)
+	}
+	// a codec without a TTL issues cursors that never expire
+	body, _, _ := strings.Cut(testCodec(t, 0).Encode("domain", nil, filter), ".")
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), `"e"`) {
+		t.Errorf("cursor without TTL has an expiry: %s", raw)
+	}
+}
+
+func TestRandomSecret(t *testing.T) {
+	a, err := NewCodec("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCodec("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := Filter("zone_domains")
+	if _, err := b.Decode(a.Encode("domain", []string{"A"}, filter), "domain", filter); err != ErrInvalid {
+		t.Errorf("cursor of another process decoded: %v", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	c := testCodec(f, time.Hour)
+	filter := Filter("zone_domains", "COM")
+	f.Add(c.Encode("domain", []string{"EXAMPLE.COM"}, filter))
+	f.Add(c.Encode("domain", nil, filter))
+	f.Add("e30.AAAAAAAAAAAAAAAAAAAAAA")
+	f.Add(".")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, token string) {
+		last, err := c.Decode(token, "domain", filter)
+		if err == nil {
+			// only tokens signed with the secret decode, they encode back to a valid token
+			if _, err := c.Decode(c.Encode("domain", last, filter), "domain", filter); err != nil {
+				t.Fatalf("%q decoded to %q which does not round trip: %v", token, last, err)
+			}
+		} else if err != ErrInvalid && err != ErrExpired {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	c := testCodec(f, time.Hour)
+	f.Add("domain", "EXAMPLE.COM", "42", "zone_domains")
+	f.Add("matched_at", "2023-07-04T00:00:00Z", "\x00\xff", "watchlist")
+	f.Add("", "", "", "")
+	f.Fuzz(func(t *testing.T, sort, last1, last2, endpoint string) {
+		if !utf8.ValidString(sort) {
+			// sort keys are column names chosen by the server, json would mangle them
+			t.Skip()
+		}
+		filter := Filter(endpoint, last1)
+		token := c.Encode(sort, []string{last1, last2}, filter)
+		got, err := c.Decode(token, sort, filter)
+		if len(token) > maxTokenLength {
+			if err != ErrInvalid {
+				t.Fatalf("oversized token: %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", token, err)
+		}
+		// json replaces invalid UTF-8, compare with what it keeps
+		var want []string
+		b, _ := json.Marshal([]string{last1, last2})
+		if err := json.Unmarshal(b, &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
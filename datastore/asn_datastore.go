@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/asn"
+	"dnscoffee/model"
+)
+
+// asnAddressesQuery selects as ips the active delegations of the nameserver IP addresses within the ranges from $1
+// to $2 for IPv4 and from $3 to $4 for IPv6, first and last addresses included
+const asnAddressesQuery = `with r4 as (
+		select r.first::inet as first, r.last::inet as last from unnest($1::text[], $2::text[]) as r(first, last)
+	), r6 as (
+		select r.first::inet as first, r.last::inet as last from unnest($3::text[], $4::text[]) as r(first, last)
+	), ips as (
+		select 4 as version, a.ip, an.nameserver_id, an.first_seen
+		from r4 join a on a.ip between r4.first and r4.last join a_nameservers an on an.a_id = a.id
+		where an.last_seen is null
+		union all
+		select 6, aaaa.ip, an.nameserver_id, an.first_seen
+		from r6 join aaaa on aaaa.ip between r6.first and r6.last join aaaa_nameservers an on an.aaaa_id = aaaa.id
+		where an.last_seen is null
+	)`
+
+// rangeParams returns the first and last addresses of the ranges, the parameters of asnAddressesQuery
+func rangeParams(ranges []asn.Range) (first, last []string) {
+	first = make([]string, len(ranges))
+	last = make([]string, len(ranges))
+	for i, r := range ranges {
+		first[i], last[i] = r.First.String(), r.Last.String()
+	}
+	return first, last
+}
+
+// GetASNAddresses returns the active nameserver IP addresses within the IPv4 and IPv6 ranges of an ASN, up to limit
+// of them by address with the number of their active nameservers, and how many addresses, active nameservers and
+// active domains delegated to those nameservers there are in all
+func (ds *DataStore) GetASNAddresses(ctx context.Context, v4, v6 []asn.Range, limit int) (*model.ASN, error) {
+	first4, last4 := rangeParams(v4)
+	first6, last6 := rangeParams(v6)
+	var data model.ASN
+	err := ds.db.scanOne(ctx, &data, asnAddressesQuery+`
+		select count(distinct ip) filter (where version = 4) as ipv4_count, count(distinct ip) filter (where version = 6) as ipv6_count,
+			count(distinct nameserver_id) as nameserver_count,
+			(select count(distinct dns.domain_id) from domains_nameservers dns
+				where dns.last_seen is null and dns.nameserver_id in (select nameserver_id from ips)) as domain_count
+		from ips`, first4, last4, first6, last6)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := ds.db.Query(ctx, asnAddressesQuery+`
+		select ip, version, min(first_seen) as first_seen, count(distinct nameserver_id) as nameserver_count from ips
+		group by ip, version order by version, ip limit $5`, first4, last4, first6, last6, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	data.IPs = make([]*model.IP, 0)
+	for rows.Next() {
+		var ip model.IP
+		err = scanRow(rows, &ip)
+		if err != nil {
+			return nil, err
+		}
+		ip.Name = ip.IPString()
+		data.IPs = append(data.IPs, &ip)
+	}
+	return &data, rows.Err()
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"dnscoffee/asn"
+)
+
+func TestRangeParams(t *testing.T) {
+	ranges := []asn.Range{
+		{First: netip.MustParseAddr("192.0.2.0"), Last: netip.MustParseAddr("192.0.2.255")},
+		{First: netip.MustParseAddr("2001:db8::"), Last: netip.MustParseAddr("2001:db8::ffff")},
+	}
+	first, last := rangeParams(ranges)
+	if want := []string{"192.0.2.0", "2001:db8::"}; !reflect.DeepEqual(first, want) {
+		t.Errorf("first addresses %v, want %v", first, want)
+	}
+	if want := []string{"192.0.2.255", "2001:db8::ffff"}; !reflect.DeepEqual(last, want) {
+		t.Errorf("last addresses %v, want %v", last, want)
+	}
+	if first, last := rangeParams(nil); len(first) != 0 || len(last) != 0 {
+		t.Errorf("got %v and %v for no ranges, want empty", first, last)
+	}
+}
+
+// BenchmarkGetASNAddresses looks up the nameserver addresses of an AS of many small ranges and of one of a few large
+func BenchmarkGetASNAddresses(b *testing.B) {
+	ds := testDataStore(b)
+	ctx := context.Background()
+	var fragmented []asn.Range
+	for i := 0; i < 256; i++ {
+		first := netip.AddrFrom4([4]byte{10, byte(i), 0, 0})
+		fragmented = append(fragmented, asn.Range{First: first, Last: netip.AddrFrom4([4]byte{10, byte(i), 0, 255})})
+	}
+	lookups := []struct {
+		name   string
+		v4, v6 []asn.Range
+	}{
+		{name: "fragmented", v4: fragmented},
+		{
+			name: "large",
+			v4:   []asn.Range{{First: netip.MustParseAddr("8.0.0.0"), Last: netip.MustParseAddr("8.255.255.255")}},
+			v6:   []asn.Range{{First: netip.MustParseAddr("2001:4860::"), Last: netip.MustParseAddr("2001:4860:ffff:ffff:ffff:ffff:ffff:ffff")}},
+		},
+	}
+	for _, lookup := range lookups {
+		b.Run(lookup.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ds.GetASNAddresses(ctx, lookup.v4, lookup.v6, 100); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// WriteAudit implements server.AuditSink, the batch is inserted with a single statement
+func (ds *DataStore) WriteAudit(ctx context.Context, records []*model.AuditRecord) error {
+	n := len(records)
+	times := make([]time.Time, n)
+	keys := make([]string, n)
+	requestIDs := make([]string, n)
+	methods := make([]string, n)
+	routes := make([]string, n)
+	params := make([]string, n)
+	queries := make([]string, n)
+	statuses := make([]int32, n)
+	rows := make([]int64, n)
+	for i, rec := range records {
+		times[i], keys[i], requestIDs[i], methods[i], routes[i] = rec.Time.Time, rec.Key, rec.RequestID, rec.Method, rec.Route
+		statuses[i], rows[i] = int32(rec.Status), rec.Rows
+		// maps of strings always encode
+		p, _ := json.Marshal(rec.Params)
+		q, _ := json.Marshal(rec.Query)
+		params[i], queries[i] = string(p), string(q)
+	}
+	_, err := ds.db.Exec(ctx, `insert into audit_log (time, key_name, request_id, method, route, params, query, status, rows)
+		select t, k, id, m, r, p::jsonb, q::jsonb, s, n
+		from unnest($1::timestamptz[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[], $8::integer[], $9::bigint[])
+			as batch(t, k, id, m, r, p, q, s, n)`,
+		times, keys, requestIDs, methods, routes, params, queries, statuses, rows)
+	return err
+}
+
+// QueryAudit implements server.AuditSink
+func (ds *DataStore) QueryAudit(ctx context.Context, key string, since time.Time, limit int) ([]*model.AuditRecord, error) {
+	rows, err := ds.db.Query(ctx, `select time, key_name, request_id, method, route, params, query, status, rows from audit_log
+		where ($1 = '' or key_name = $1) and time >= $2
+		order by time, id limit $3`, key, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]*model.AuditRecord, 0)
+	for rows.Next() {
+		var rec model.AuditRecord
+		err = scanRow(rows, &rec)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &rec)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,173 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrDatabaseUnavailable is returned without querying the database while the circuit breaker is open
+var ErrDatabaseUnavailable = errors.New("the database is currently unavailable")
+
+// breaker metrics
+var (
+	breakerStateVar    = expvar.NewString("datastore_breaker_state")
+	breakerTransitions = expvar.NewMap("datastore_breaker_transitions")
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker stops sending queries to the database after threshold consecutive
+// connection failures, and lets a single probe query through every cooldown to close it again
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probeAt  time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+	breakerStateVar.Set(b.state.String())
+	return b
+}
+
+// allow reports if a query may be sent to the database
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probeAt = now
+		return true
+	case breakerHalfOpen:
+		// a probe that never reported back is treated as lost and replaced
+		if now.Sub(b.probeAt) < b.cooldown {
+			return false
+		}
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a query
+func (b *circuitBreaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	failed := isConnectionError(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+		logging.Errorf("datastore: %d consecutive connection failures, last: %s", b.failures, err)
+	}
+}
+
+// retryAfter returns how long until the breaker will allow another probe
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed {
+		return 0
+	}
+	wait := b.cooldown - time.Since(b.openedAt)
+	if wait < 0 {
+		wait = b.cooldown
+	}
+	return wait
+}
+
+// ready returns ErrDatabaseUnavailable unless the breaker is closed
+func (b *circuitBreaker) ready() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		return ErrDatabaseUnavailable
+	}
+	return nil
+}
+
+// setState must be called with mu held
+func (b *circuitBreaker) setState(s breakerState) {
+	logging.Warnf("datastore: circuit breaker %s -> %s", b.state, s)
+	b.state = s
+	breakerStateVar.Set(s.String())
+	breakerTransitions.Add(s.String(), 1)
+}
+
+// isConnectionError reports if err means the database could not be reached,
+// as opposed to an error with the query or a missing result
+// a request's context running out is not a failure of the database, clients choose their own deadlines
+func isConnectionError(err error) bool {
+	if err == nil || err == pgx.ErrNoRows || err == ErrDatabaseUnavailable ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// class 08 is connection exceptions, 57P01-57P03 are server shutdowns and startups
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P0")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return pgconn.SafeToRetry(err)
+}
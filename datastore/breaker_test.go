@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no rows", pgx.ErrNoRows, false},
+		{"unavailable", ErrDatabaseUnavailable, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"wrapped deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), false},
+		{"statement timeout", &pgconn.PgError{Code: "57014"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"eof", io.EOF, true},
+		{"unexpected eof", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"other", errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresDeadlines(t *testing.T) {
+	b := newCircuitBreaker(3, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.record(context.DeadlineExceeded)
+	}
+	if err := b.ready(); err != nil {
+		t.Fatalf("deadlines opened the breaker: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		b.record(io.EOF)
+	}
+	if b.allow() {
+		t.Fatal("connection failures did not open the breaker")
+	}
+}
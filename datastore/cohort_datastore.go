@@ -0,0 +1,46 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// GetCohortSample returns up to size domains first seen in the month, ordered by the md5 of the seed and their name,
+// and the number of domains first seen in the month, with zoneID 0 the domains of every zone are sampled
+// the order only depends on the seed and the names, so the same seed returns the same sample as long as the cohort is unchanged
+func (ds *DataStore) GetCohortSample(ctx context.Context, zoneID int64, month time.Time, seed string, size int) ([]*model.CohortDomain, int64, error) {
+	rows, err := ds.db.Query(ctx, `with cohort as (
+			select domain_id, min(first_seen) as first_seen,
+				bool_or(last_seen is null) as active, max(last_seen) as last_seen
+			from domains_nameservers where ($1::bigint = 0 or zone_id = $1)
+			group by domain_id
+			having date_trunc('month', min(first_seen))::date = $2::date
+		)
+		select d.domain as name, c.active, c.first_seen,
+			case when c.active then null else c.last_seen end as last_seen, count(*) over () as total
+		from cohort c join domains d on d.id = c.domain_id
+		order by md5($3::text || ':' || d.domain), d.domain
+		limit $4`, zoneID, month, seed, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	domains := make([]*model.CohortDomain, 0, size)
+	var total int64
+	for rows.Next() {
+		var row struct {
+			model.CohortDomain
+			Total int64 `db:"total"`
+		}
+		err = scanRow(rows, &row)
+		if err != nil {
+			return nil, 0, err
+		}
+		d := row.CohortDomain
+		domains = append(domains, &d)
+		total = row.Total
+	}
+	return domains, total, rows.Err()
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"dnscoffee/logging"
 	"dnscoffee/model"
 
 	"github.com/jackc/pgtype"
@@ -25,19 +25,73 @@ import (
 // ErrNoResource a 404 for a resource
 var ErrNoResource = errors.New("the requested object does not exist")
 
+// ErrInvalidIP is returned for IP lookups of strings that are not a single IP address
+var ErrInvalidIP = errors.New("not a valid IP address")
+
+// ErrSearchTooShort is returned for feed searches shorter than MinSearchLength
+var ErrSearchTooShort = fmt.Errorf("search term must be at least %d characters long", MinSearchLength)
+
+// MinSearchLength is the shortest substring accepted by the feed searches
+const MinSearchLength = 4
+
+// ErrTooManyRows is returned by list queries that match more than the configured maximum rows
+var ErrTooManyRows = errors.New("the query matched too many rows")
+
 // DataStore stores references to the database and
 // has methods for querying the database
 type DataStore struct {
-	db *pgxpool.Pool
+	db    *db
+	views []MaterializedView
+	// maximum rows returned by list queries, 0 is unlimited
+	maxRows int
+	// maximum concurrent queries of a request querying every zone, see fanOutZones
+	maxParallelZoneQueries int
+}
+
+// Config holds the datastore settings
+type Config struct {
+	// postgres connection string, libpq environment variables such as $PGHOST fill in missing settings
+	DSN string
+	// number of consecutive connection failures before the circuit breaker opens, 0 disables it
+	BreakerThreshold int
+	// how long the circuit breaker stays open before probing the database again
+	BreakerCooldown time.Duration
+	// number of times read queries are retried after a transient error before their first row
+	MaxRetries int
+	// delay before the first retry, doubled for each following attempt
+	RetryBackoff time.Duration
+	// queries slower than this are logged, 0 disables slow query logging
+	SlowQueryThreshold time.Duration
+	// maximum rows returned by list queries before they fail with ErrTooManyRows, 0 is unlimited
+	MaxRows int
+	// maximum concurrent queries of a request querying every zone, at least 1
+	MaxParallelZoneQueries int
+	// materialized views to refresh on a schedule
+	MaterializedViews []MaterializedView
+}
+
+// DefaultConfig is the default datastore configuration
+var DefaultConfig = Config{
+	BreakerThreshold:   5,
+	BreakerCooldown:    30 * time.Second,
+	MaxRetries:         2,
+	RetryBackoff:       100 * time.Millisecond,
+	SlowQueryThreshold: 5 * time.Second,
+	MaxRows:            100000,
+
+	MaxParallelZoneQueries: 8,
 }
 
 // New Creates a new DataStore with the provided database configuration
-// database connection variables are set from environment variables
-func New(ctx context.Context) (*DataStore, error) {
-	connPoolConfig, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
+func New(ctx context.Context, config Config) (*DataStore, error) {
+	// pool settings such as pool_max_conns are read from the connection string
+	connPoolConfig, err := pgxpool.ParseConfig(config.DSN)
 	if err != nil {
 		return nil, err
 	}
+	connPoolConfig.AfterConnect = prepareStatements
+	// import dates are UTC days, casts between dates and times in queries must not depend on the server's time zone
+	connPoolConfig.ConnConfig.RuntimeParams["timezone"] = "UTC"
 	pool, err := pgxpool.ConnectConfig(ctx, connPoolConfig)
 	if err != nil {
 		return nil, err
@@ -55,7 +109,25 @@ func New(ctx context.Context) (*DataStore, error) {
 	}
 	err = conn.Close(ctx)
 
-	ds := DataStore{pool}
+	ds := DataStore{
+		db: &db{
+			pool:         pool,
+			queries:      pool,
+			breaker:      newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+			maxRetries:   config.MaxRetries,
+			retryBackoff: config.RetryBackoff,
+
+			slowQueryThreshold: int64(config.SlowQueryThreshold),
+		},
+		views:   config.MaterializedViews,
+		maxRows: config.MaxRows,
+
+		maxParallelZoneQueries: config.MaxParallelZoneQueries,
+	}
+	if ds.maxParallelZoneQueries < 1 {
+		ds.maxParallelZoneQueries = 1
+	}
+	statsPool.Store(pool)
 	return &ds, err
 }
 
@@ -65,10 +137,47 @@ func (ds *DataStore) Close() error {
 	return nil
 }
 
+// rowLimit is the LIMIT argument for list queries, one more than the maximum so that
+// exceeding it can be detected, nil (no limit) when unlimited
+func (ds *DataStore) rowLimit() interface{} {
+	if ds.maxRows <= 0 {
+		return nil
+	}
+	return ds.maxRows + 1
+}
+
+// tooManyRows returns true if n rows is over the maximum for list queries
+func (ds *DataStore) tooManyRows(n int) bool {
+	return ds.maxRows > 0 && n > ds.maxRows
+}
+
+// likeEscaper escapes the LIKE pattern characters with the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes s to match literally in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// SetSlowQueryThreshold changes the slow query logging threshold, 0 disables it
+func (ds *DataStore) SetSlowQueryThreshold(d time.Duration) {
+	atomic.StoreInt64(&ds.db.slowQueryThreshold, int64(d))
+}
+
+// Ready returns ErrDatabaseUnavailable while the circuit breaker is not closed
+func (ds *DataStore) Ready() error {
+	return ds.db.breaker.ready()
+}
+
+// RetryAfter returns how long clients should wait before retrying while the database is unavailable
+func (ds *DataStore) RetryAfter() time.Duration {
+	return ds.db.breaker.retryAfter()
+}
+
 // GetDomainID gets the domain's ID and domain's zone's ID
 func (ds *DataStore) GetDomainID(ctx context.Context, domain string) (int64, int64, error) {
 	var id, zoneID int64
-	err := ds.db.QueryRow(ctx, "SELECT id, zone_id FROM domains WHERE domain = $1", domain).Scan(&id, &zoneID)
+	err := ds.db.QueryRow(ctx, stmtDomainID, domain).Scan(&id, &zoneID)
 	if err == pgx.ErrNoRows {
 		err = ErrNoResource
 	}
@@ -80,14 +189,18 @@ func (ds *DataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, err
 	var id int64
 	var version int
 	var err error
+	// pgtype also accepts CIDRs, only single addresses can be looked up
+	if net.ParseIP(ipStr) == nil {
+		return -1, 0, ErrInvalidIP
+	}
 	var ip pgtype.Inet
 	err = ip.DecodeText(nil, []byte(ipStr))
 	if err != nil {
-		return -1, 0, err
+		return -1, 0, ErrInvalidIP
 	}
 	if ip.IPNet.IP.To4() != nil {
 		version = 4
-		err = ds.db.QueryRow(ctx, "SELECT id FROM a WHERE ip = $1", ip).Scan(&id)
+		err = ds.db.QueryRow(ctx, stmtIP4ID, ip).Scan(&id)
 		if err == pgx.ErrNoRows {
 			err = ErrNoResource
 		}
@@ -95,7 +208,7 @@ func (ds *DataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, err
 	}
 	if ip.IPNet.IP.To16() != nil {
 		version = 6
-		err = ds.db.QueryRow(ctx, "SELECT id FROM aaaa WHERE ip = $1", ip).Scan(&id)
+		err = ds.db.QueryRow(ctx, stmtIP6ID, ip).Scan(&id)
 		if err == pgx.ErrNoRows {
 			err = ErrNoResource
 		}
@@ -107,13 +220,33 @@ func (ds *DataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, err
 // GetZoneID gets the zoneID with the given name
 func (ds *DataStore) GetZoneID(ctx context.Context, name string) (int64, error) {
 	var id int64
-	err := ds.db.QueryRow(ctx, "select id from zones where zone = $1 limit 1", name).Scan(&id)
+	err := ds.db.QueryRow(ctx, stmtZoneID, name).Scan(&id)
 	if err == pgx.ErrNoRows {
 		err = ErrNoResource
 	}
 	return id, err
 }
 
+// GetZoneIDs returns the ID of every zone by name, as GetZoneID does
+func (ds *DataStore) GetZoneIDs(ctx context.Context) (map[string]int64, error) {
+	rows, err := ds.db.Query(ctx, "select zone, min(id) from zones group by zone")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]int64)
+	for rows.Next() {
+		var zone string
+		var id int64
+		err = rows.Scan(&zone, &id)
+		if err != nil {
+			return nil, err
+		}
+		ids[zone] = id
+	}
+	return ids, rows.Err()
+}
+
 // GetZone gets the Zone with the given name from zones_nameservers
 func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, error) {
 	var z model.Zone
@@ -129,7 +262,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	err = ds.db.QueryRow(ctx, "select first_seen from zones_nameservers where zone_id = $1 order by first_seen asc nulls first limit 1", z.ID).Scan(&z.FirstSeen)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			z.FirstSeen = nil
+			z.FirstSeen = model.Date{}
 		} else {
 			return nil, err
 		}
@@ -138,7 +271,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	err = ds.db.QueryRow(ctx, "select last_seen from zones_nameservers where zone_id = $1 order by last_seen desc nulls first limit 1", z.ID).Scan(&z.LastSeen)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			z.LastSeen = nil
+			z.LastSeen = model.Date{}
 		} else {
 			return nil, err
 		}
@@ -157,7 +290,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	}
 
 	// get active NS
-	rows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.ID AND zns.last_seen IS NULL AND zns.zone_id = $1 limit 100", z.ID)
+	rows, err := ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.ID AND zns.last_seen IS NULL AND zns.zone_id = $1 limit 100", z.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -165,7 +298,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	z.NameServers = make([]*model.NameServer, 0, 4)
 	for rows.Next() {
 		var ns model.NameServer
-		err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+		err = scanRow(rows, &ns)
 		if err != nil {
 			return nil, err
 		}
@@ -173,7 +306,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	}
 
 	// get archive NS
-	archiveRows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.ID AND zns.last_seen IS NOT NULL AND zns.zone_id = $1 ORDER BY last_seen desc limit 100", z.ID)
+	archiveRows, err := ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.ID AND zns.last_seen IS NOT NULL AND zns.zone_id = $1 ORDER BY last_seen desc limit 100", z.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -181,7 +314,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 	z.ArchiveNameServers = make([]*model.NameServer, 0, 4)
 	for archiveRows.Next() {
 		var ns model.NameServer
-		err = archiveRows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+		err = scanRow(archiveRows, &ns)
 		if err != nil {
 			return nil, err
 		}
@@ -202,20 +335,23 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 // GetNameServerID given a nameserver, find its ID
 func (ds *DataStore) GetNameServerID(ctx context.Context, domain string) (int64, error) {
 	var id int64
-	err := ds.db.QueryRow(ctx, "SELECT id FROM nameservers WHERE domain = $1", domain).Scan(&id)
+	err := ds.db.QueryRow(ctx, stmtNameServerID, domain).Scan(&id)
 	if err == pgx.ErrNoRows {
 		err = ErrNoResource
 	}
 	return id, err
 }
 
-func (ds *DataStore) GetFeedNew(ctx context.Context, date time.Time) (*model.Feed, error) {
+// GetFeedNew returns the new domains of date, only those of imports from one of sources and scored one of
+// stabilities unless they are empty
+func (ds *DataStore) GetFeedNew(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "new"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT domain_id, domain from recent_new_domains where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT r.domain_id AS id, r.domain AS name from recent_new_domains r where r.date = $1 and "+feedSourceFilter+" and "+stabilityFilter("r.domain_id", "$4")+" limit $2",
+		date, ds.rowLimit(), arrayParam(sources), arrayParam(stabilities))
 	if err != nil {
 		return nil, err
 	}
@@ -223,23 +359,29 @@ func (ds *DataStore) GetFeedNew(ctx context.Context, date time.Time) (*model.Fee
 	f.Domains = make([]*model.Domain, 0, 100)
 	for rows.Next() {
 		var d model.Domain
-		err = rows.Scan(&d.ID, &d.Name)
+		err = scanRow(rows, &d)
 		if err != nil {
 			return nil, err
 		}
 		f.Domains = append(f.Domains, &d)
+		if ds.tooManyRows(len(f.Domains)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return &f, err
 }
 
-func (ds *DataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Feed, error) {
+// GetFeedOld returns the old domains of date, only those of imports from one of sources and scored one of
+// stabilities unless they are empty
+func (ds *DataStore) GetFeedOld(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "old"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT domain_id, domain from recent_old_domains where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT r.domain_id AS id, r.domain AS name from recent_old_domains r where r.date = $1 and "+feedSourceFilter+" and "+stabilityFilter("r.domain_id", "$4")+" limit $2",
+		date, ds.rowLimit(), arrayParam(sources), arrayParam(stabilities))
 	if err != nil {
 		return nil, err
 	}
@@ -247,23 +389,29 @@ func (ds *DataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Fee
 	f.Domains = make([]*model.Domain, 0, 100)
 	for rows.Next() {
 		var d model.Domain
-		err = rows.Scan(&d.ID, &d.Name)
+		err = scanRow(rows, &d)
 		if err != nil {
 			return nil, err
 		}
 		f.Domains = append(f.Domains, &d)
+		if ds.tooManyRows(len(f.Domains)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return &f, err
 }
 
-func (ds *DataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.Feed, error) {
+// GetFeedMoved returns the moved domains of date, only those of imports from one of sources and scored one of
+// stabilities unless they are empty
+func (ds *DataStore) GetFeedMoved(ctx context.Context, date time.Time, sources, stabilities []string) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "moved"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT domain_id, domain from recent_moved_domains where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT r.domain_id AS id, r.domain AS name from recent_moved_domains r where r.date = $1 and "+feedSourceFilter+" and "+stabilityFilter("r.domain_id", "$4")+" limit $2",
+		date, ds.rowLimit(), arrayParam(sources), arrayParam(stabilities))
 	if err != nil {
 		return nil, err
 	}
@@ -271,43 +419,55 @@ func (ds *DataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.F
 	f.Domains = make([]*model.Domain, 0, 100)
 	for rows.Next() {
 		var d model.Domain
-		err = rows.Scan(&d.ID, &d.Name)
+		err = scanRow(rows, &d)
 		if err != nil {
 			return nil, err
 		}
 		f.Domains = append(f.Domains, &d)
+		if ds.tooManyRows(len(f.Domains)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return &f, err
 }
 
+// feedNameServer is a nameserver of a feed and the IP version of its addresses
+type feedNameServer struct {
+	model.NameServer
+	Version int `db:"version"`
+}
+
 func (ds *DataStore) GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error) {
 	var f model.NSFeed
 	f.Change = "moved"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT nameserver_id, nameserver, version from recent_moved_ns where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT nameserver_id AS id, nameserver AS name, version from recent_moved_ns where date = $1 limit $2", date, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	f.Nameservers4 = make([]*model.NameServer, 0, 10)
 	f.Nameservers6 = make([]*model.NameServer, 0, 10)
-	for rows.Next() {
-		var ns model.NameServer
-		var v int
-		err = rows.Scan(&ns.ID, &ns.Name, &v)
+	for n := 1; rows.Next(); n++ {
+		if ds.tooManyRows(n) {
+			return nil, ErrTooManyRows
+		}
+		var ns feedNameServer
+		err = scanRow(rows, &ns)
 		if err != nil {
 			return nil, err
 		}
+		v := ns.Version
 		if v == 4 {
-			f.Nameservers4 = append(f.Nameservers4, &ns)
+			f.Nameservers4 = append(f.Nameservers4, &ns.NameServer)
 		} else if v == 6 {
-			f.Nameservers6 = append(f.Nameservers6, &ns)
+			f.Nameservers6 = append(f.Nameservers6, &ns.NameServer)
 		} else {
 			// log this
-			log.Printf("Got NS Feed with unknown IP version %d for %s\n", v, date)
+			logging.Warnf("Got NS Feed with unknown IP version %d for %s", v, date)
 			// skip unknown versions for now
 			continue
 		}
@@ -348,15 +508,16 @@ func (ds *DataStore) getFeedCount(ctx context.Context, table, search string) (*m
 	fc.Search = search
 	var err error
 
-	if len(search) < 4 {
-		return nil, fmt.Errorf("search term must be at least %d long", 4)
+	if len(search) < MinSearchLength {
+		return nil, ErrSearchTooShort
 	}
 
-	search = strings.ToUpper(search)
+	// wildcards in the search term would turn it into a scan of the whole feed
+	search = escapeLike(strings.ToUpper(search))
 
 	// TODO add index here for like substring search
-	query := fmt.Sprintf("SELECT date, count(domain) FROM %s where domain like '%%' || $1 || '%%' group by date order by date desc", table)
-	rows, err := ds.db.Query(ctx, query, search)
+	query := fmt.Sprintf("SELECT date, count(domain) AS count FROM %s where domain like '%%' || $1 || '%%' group by date order by date desc limit $2", table)
+	rows, err := ds.db.Query(ctx, query, search, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
@@ -365,11 +526,14 @@ func (ds *DataStore) getFeedCount(ctx context.Context, table, search string) (*m
 
 	for rows.Next() {
 		var f model.FeedCount
-		err = rows.Scan(&f.Date, &f.Count)
+		err = scanRow(rows, &f)
 		if err != nil {
 			return nil, err
 		}
 		fc.Counts = append(fc.Counts, f)
+		if ds.tooManyRows(len(fc.Counts)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return &fc, err
@@ -379,29 +543,32 @@ func (ds *DataStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.N
 	var f model.NSFeed
 	f.Change = "new"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT nameserver_id, nameserver, version from recent_new_ns where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT nameserver_id AS id, nameserver AS name, version from recent_new_ns where date = $1 limit $2", date, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	f.Nameservers4 = make([]*model.NameServer, 0, 10)
 	f.Nameservers6 = make([]*model.NameServer, 0, 10)
-	for rows.Next() {
-		var ns model.NameServer
-		var v int
-		err = rows.Scan(&ns.ID, &ns.Name, &v)
+	for n := 1; rows.Next(); n++ {
+		if ds.tooManyRows(n) {
+			return nil, ErrTooManyRows
+		}
+		var ns feedNameServer
+		err = scanRow(rows, &ns)
 		if err != nil {
 			return nil, err
 		}
+		v := ns.Version
 		if v == 4 {
-			f.Nameservers4 = append(f.Nameservers4, &ns)
+			f.Nameservers4 = append(f.Nameservers4, &ns.NameServer)
 		} else if v == 6 {
-			f.Nameservers6 = append(f.Nameservers6, &ns)
+			f.Nameservers6 = append(f.Nameservers6, &ns.NameServer)
 		} else {
 			// log this
-			log.Printf("Got NS Feed with unknown IP version %d for %s\n", v, date)
+			logging.Warnf("Got NS Feed with unknown IP version %d for %s", v, date)
 			// skip unknown versions for now
 			continue
 		}
@@ -414,29 +581,32 @@ func (ds *DataStore) GetFeedNsOld(ctx context.Context, date time.Time) (*model.N
 	var f model.NSFeed
 	f.Change = "old"
 	var err error
-	f.Date = date
+	f.Date = model.NewDate(date)
 
-	rows, err := ds.db.Query(ctx, "SELECT nameserver_id, nameserver, version from recent_old_ns where date = $1", date)
+	rows, err := ds.db.Query(ctx, "SELECT nameserver_id AS id, nameserver AS name, version from recent_old_ns where date = $1 limit $2", date, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	f.Nameservers4 = make([]*model.NameServer, 0, 10)
 	f.Nameservers6 = make([]*model.NameServer, 0, 10)
-	for rows.Next() {
-		var ns model.NameServer
-		var v int
-		err = rows.Scan(&ns.ID, &ns.Name, &v)
+	for n := 1; rows.Next(); n++ {
+		if ds.tooManyRows(n) {
+			return nil, ErrTooManyRows
+		}
+		var ns feedNameServer
+		err = scanRow(rows, &ns)
 		if err != nil {
 			return nil, err
 		}
+		v := ns.Version
 		if v == 4 {
-			f.Nameservers4 = append(f.Nameservers4, &ns)
+			f.Nameservers4 = append(f.Nameservers4, &ns.NameServer)
 		} else if v == 6 {
-			f.Nameservers6 = append(f.Nameservers6, &ns)
+			f.Nameservers6 = append(f.Nameservers6, &ns.NameServer)
 		} else {
 			// log this
-			log.Printf("Got NS Feed with unknown IP version %d for %s\n", v, date)
+			logging.Warnf("Got NS Feed with unknown IP version %d for %s", v, date)
 			// skip unknown versions for now
 			continue
 		}
@@ -460,35 +630,39 @@ func (ds *DataStore) GetDomain(ctx context.Context, domain string) (*model.Domai
 	d.Name = domain
 
 	// zone queries
-	err = ds.db.QueryRow(ctx, "select zones.zone, zone_imports.first_import_date, zone_imports.last_import_date from zones, zone_imports where zones.id = zone_imports.zone_id and zones.id = $1 limit 1;", d.Zone.ID).Scan(&d.Zone.Name, &d.Zone.FirstSeen, &d.Zone.LastSeen)
+	err = ds.db.scanOne(ctx, d.Zone, "select zones.zone AS name, zone_imports.first_import_date AS first_seen, zone_imports.last_import_date AS last_seen from zones, zone_imports where zones.id = zone_imports.zone_id and zones.id = $1 limit 1", d.Zone.ID)
 	if err != nil {
 		return nil, err
 	}
 
 	// get first_seen & last_seen
-	err = ds.db.QueryRow(ctx, "select first_seen from domains_nameservers where domain_id = $1 order by first_seen asc nulls first limit 1", d.ID).Scan(&d.FirstSeen)
+	err = ds.db.QueryRow(ctx, stmtDomainFirstSeen, d.ID).Scan(&d.FirstSeen)
 	if err != nil {
 		return nil, err
 	}
-	err = ds.db.QueryRow(ctx, "select last_seen from domains_nameservers where domain_id = $1 order by last_seen desc nulls first limit 1", d.ID).Scan(&d.LastSeen)
+	err = ds.db.QueryRow(ctx, stmtDomainLastSeen, d.ID).Scan(&d.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	d.Source, err = ds.getDomainSource(ctx, d.Zone.ID, d.LastSeen)
 	if err != nil {
 		return nil, err
 	}
 
 	// get num NS
-	err = ds.db.QueryRow(ctx, "SELECT count(*) FROM domains_nameservers WHERE domain_id = $1 AND last_seen IS NULL", d.ID).Scan(&d.NameServerCount)
+	err = ds.db.QueryRow(ctx, stmtDomainNameServerCount, d.ID).Scan(&d.NameServerCount)
 	if err != nil {
 		return nil, err
 	}
 
 	// get num archive NS
-	err = ds.db.QueryRow(ctx, "SELECT count(*) FROM domains_nameservers WHERE domain_id = $1 AND last_seen IS NOT NULL", d.ID).Scan(&d.ArchiveNameServerCount)
+	err = ds.db.QueryRow(ctx, stmtDomainArchiveNameServerCount, d.ID).Scan(&d.ArchiveNameServerCount)
 	if err != nil {
 		return nil, err
 	}
 
 	// get active NS
-	rows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.domain_id = $1 limit 100", d.ID)
+	rows, err := ds.db.Query(ctx, stmtDomainNameServers, d.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -496,15 +670,22 @@ func (ds *DataStore) GetDomain(ctx context.Context, domain string) (*model.Domai
 	d.NameServers = make([]*model.NameServer, 0, 4)
 	for rows.Next() {
 		var ns model.NameServer
-		err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+		err = scanRow(rows, &ns)
 		if err != nil {
 			return nil, err
 		}
 		d.NameServers = append(d.NameServers, &ns)
 	}
+	if len(d.NameServers) > 0 {
+		names := make([]string, len(d.NameServers))
+		for i, ns := range d.NameServers {
+			names[i] = ns.Name
+		}
+		d.NameServerSetFingerprint = NameServerSetFingerprint(names)
+	}
 
 	// get archive NS
-	archiveRows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.domain_id = $1 ORDER BY last_seen desc limit 100", d.ID)
+	archiveRows, err := ds.db.Query(ctx, stmtDomainArchiveNameServers, d.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -512,7 +693,7 @@ func (ds *DataStore) GetDomain(ctx context.Context, domain string) (*model.Domai
 	d.ArchiveNameServers = make([]*model.NameServer, 0, 4)
 	for archiveRows.Next() {
 		var ns model.NameServer
-		err = archiveRows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+		err = scanRow(archiveRows, &ns)
 		if err != nil {
 			return nil, err
 		}
@@ -552,7 +733,7 @@ func (ds *DataStore) GetRandomDomain(ctx context.Context) (*model.Domain, error)
 // GetZoneImport gets the most-recent recent ZoneImportResult for the given zone
 func (ds *DataStore) GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error) {
 	var r model.ZoneImportResult
-	err := ds.db.QueryRow(ctx,
+	err := ds.db.scanOne(ctx, &r,
 		`SELECT
 			zones.zone,
 			import_counts.domains,
@@ -561,16 +742,19 @@ func (ds *DataStore) GetZoneImport(ctx context.Context, zone string) (*model.Zon
 			zone_imports.first_import_id,
 			zone_imports.last_import_date,
 			zone_imports.last_import_id,
-			zone_imports.count
+			zone_imports.count,
+			coalesce(imports.source, '') AS source
 		from
 			zones,
 			zone_imports,
-			import_counts
+			import_counts,
+			imports
 		where
 			zones.id = zone_imports.zone_id
 			and zone_imports.last_import_id = import_counts.import_id
+			and imports.id = zone_imports.last_import_id
 			and zones.zone = $1`,
-		zone).Scan(&r.Zone, &r.Domains, &r.Records, &r.FirstImportDate, &r.FirstImportID, &r.LastImportDate, &r.LastImportID, &r.Count)
+		zone)
 	if err != nil {
 		return nil, err
 	}
@@ -582,14 +766,14 @@ func (ds *DataStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImpor
 	var zoneImportResults model.ZoneImportResults
 	zoneImportResults.Zones = make([]*model.ZoneImportResult, 0, 100)
 
-	rows, err := ds.db.Query(ctx, "select zones.zone, import_counts.domains, import_counts.records, zone_imports.first_import_date, zone_imports.first_import_id, zone_imports.last_import_date,zone_imports.last_import_id, zone_imports.count from zones, zone_imports, import_counts where zones.id = zone_imports.zone_id and zone_imports.last_import_id = import_counts.import_id order by zone asc")
+	rows, err := ds.db.Query(ctx, "select zones.zone, import_counts.domains, import_counts.records, zone_imports.first_import_date, zone_imports.first_import_id, zone_imports.last_import_date,zone_imports.last_import_id, zone_imports.count, coalesce(imports.source, '') as source from zones, zone_imports, import_counts, imports where zones.id = zone_imports.zone_id and zone_imports.last_import_id = import_counts.import_id and imports.id = zone_imports.last_import_id order by zone asc")
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		var r model.ZoneImportResult
-		err = rows.Scan(&r.Zone, &r.Domains, &r.Records, &r.FirstImportDate, &r.FirstImportID, &r.LastImportDate, &r.LastImportID, &r.Count)
+		err = scanRow(rows, &r)
 		if err != nil {
 			return nil, err
 		}
@@ -607,14 +791,14 @@ func (ds *DataStore) GetInternetHistoryCounts(ctx context.Context) (*model.ZoneC
 	zc.Zone = ""
 	limit := 300
 
-	rows, err := ds.db.Query(ctx, "with s as (select date, sum(domains) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from weighted_counts where date not in (select distinct date from imports where imported = false) group by 1 order by 1 desc limit (52 * $1)) select date_trunc('week', date) AS week, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from s group by 1 order by 1 desc limit $1", limit)
+	rows, err := ds.db.Query(ctx, "with s as (select date, sum(domains) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from weighted_counts where date not in (select distinct date from imports where imported = false) group by 1 order by 1 desc limit (52 * $1)) select date_trunc('week', date) AS date, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from s group by 1 order by 1 desc limit $1", limit)
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		var c model.ZoneCounts
-		err = rows.Scan(&c.Date, &c.Domains, &c.Old, &c.Moved, &c.New)
+		err = scanRow(rows, &c)
 		if err != nil {
 			return nil, err
 		}
@@ -665,7 +849,7 @@ func (ds *DataStore) GetZoneHistoryCounts(ctx context.Context, zone string) (*mo
 
 	for rows.Next() {
 		var c model.ZoneCounts
-		err = rows.Scan(&c.Date, &c.Domains, &c.Old, &c.Moved, &c.New)
+		err = scanRow(rows, &c)
 		if err != nil {
 			return nil, err
 		}
@@ -680,30 +864,77 @@ func (ds *DataStore) GetAllZoneHistoryCounts(ctx context.Context) (*model.AllZon
 	var all model.AllZoneCounts
 	all.Counts = make(map[string]*model.ZoneCount)
 
-	rows, err := ds.db.Query(ctx, "select date_trunc('month', date) AS month, zone, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from weighted_counts, zones where zones.id = zone_id group by 1, 2 order by 1 desc, 2 limit 300 * 1200")
+	rows, err := ds.db.Query(ctx, "select date_trunc('month', date) AS date, zone, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from weighted_counts, zones where zones.id = zone_id group by 1, 2 order by 1 desc, 2 limit 300 * 1200")
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
-		var c model.ZoneCounts
-		var zone string
-		err = rows.Scan(&c.Date, &zone, &c.Domains, &c.Old, &c.Moved, &c.New)
+		var zc struct {
+			model.ZoneCounts
+			Zone string `db:"zone"`
+		}
+		err = scanRow(rows, &zc)
 		if err != nil {
 			return nil, err
 		}
+		zone := zc.Zone
 		if _, ok := all.Counts[zone]; !ok {
 			all.Counts[zone] = new(model.ZoneCount)
 			all.Counts[zone].Zone = zone
 			all.Counts[zone].History = make([]*model.ZoneCounts, 0, 100)
 		}
 
-		all.Counts[zone].History = append(all.Counts[zone].History, &c)
+		all.Counts[zone].History = append(all.Counts[zone].History, &zc.ZoneCounts)
 	}
 
 	return &all, nil
 }
 
+// DataVersion returns the ID of the latest finished import, it changes whenever an import lands
+func (ds *DataStore) DataVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := ds.db.QueryRow(ctx, "select coalesce(max(id), 0) from imports where imported = true").Scan(&version)
+	return version, err
+}
+
+// FeedLastModified returns when the latest finished import of the date finished, nil if none has
+// the feeds of a date only change when one of its imports finishes
+func (ds *DataStore) FeedLastModified(ctx context.Context, date time.Time) (*time.Time, error) {
+	var modified *time.Time
+	err := ds.db.QueryRow(ctx, "select max(imported_at) from imports where date = $1 and imported = true", date).Scan(&modified)
+	return modified, err
+}
+
+// GetZoneLastImported returns when the latest finished import of every zone finished, by zone name
+// zones whose imports finished before imported_at was recorded are left out
+func (ds *DataStore) GetZoneLastImported(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := ds.db.Query(ctx, `select z.zone, max(i.imported_at) from imports i join zones z on z.id = i.zone_id
+		where i.imported = true and i.imported_at is not null group by z.zone`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	imported := make(map[string]time.Time)
+	for rows.Next() {
+		var zone string
+		var at time.Time
+		err = rows.Scan(&zone, &at)
+		if err != nil {
+			return nil, err
+		}
+		imported[zone] = at
+	}
+	return imported, rows.Err()
+}
+
+// importDateRow is the import progress of a date, with its durations as intervals
+type importDateRow struct {
+	model.ImportDate
+	TookDiff   pgtype.Interval `db:"took_diff"`
+	TookImport pgtype.Interval `db:"took_import"`
+}
+
 // GetImportProgress gets information on the progress of unimported zones
 func (ds *DataStore) GetImportProgress(ctx context.Context) (*model.ImportProgress, error) {
 	history := 60
@@ -730,25 +961,25 @@ func (ds *DataStore) GetImportProgress(ctx context.Context) (*model.ImportProgre
 		return nil, err
 	}
 
-	rows, err := ds.db.Query(ctx, "select date, sum(coalesce(diff_duration, '0'::interval)) took_diff, sum(coalesce(import_duration,'0'::interval)) took_import, count(CASE WHEN imports.imported THEN 1 END) from imports, import_progress where imports.id = import_progress.import_id group by date order by date desc limit $1", history)
+	rows, err := ds.db.Query(ctx, "select date, sum(coalesce(diff_duration, '0'::interval)) took_diff, sum(coalesce(import_duration,'0'::interval)) took_import, count(CASE WHEN imports.imported THEN 1 END) AS count from imports, import_progress where imports.id = import_progress.import_id group by date order by date desc limit $1", history)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var diffDuration, importDuration pgtype.Interval
 	ip.Dates = make([]model.ImportDate, 0, history)
 
 	for rows.Next() {
-		var ipd model.ImportDate
-		err = rows.Scan(&ipd.Date, &diffDuration, &importDuration, &ipd.Count)
+		var row importDateRow
+		err = scanRow(rows, &row)
 		if err != nil {
 			return nil, err
 		}
-		err = diffDuration.AssignTo(&ipd.DiffDuration)
+		ipd := row.ImportDate
+		err = row.TookDiff.AssignTo(&ipd.DiffDuration)
 		if err != nil {
 			return nil, err
 		}
-		err = importDuration.AssignTo(&ipd.ImportDuration)
+		err = row.TookImport.AssignTo(&ipd.ImportDuration)
 		if err != nil {
 			return nil, err
 		}
@@ -773,13 +1004,17 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.Name = domain
 
 	// get NS metadata
-	err = ds.db.QueryRow(ctx, "select first_seen, last_seen, domains_count, domains_archive_count, a_count, a_archive_count, aaaa_count, aaaa_archive_count from nameserver_metadata where nameserver_id = $1", ns.ID).Scan(&ns.FirstSeen, &ns.LastSeen, &ns.DomainCount, &ns.ArchiveDomainCount, &ns.IP4Count, &ns.ArchiveIP4Count, &ns.IP6Count, &ns.ArchiveIP6Count)
+	err = ds.db.scanOne(ctx, &ns, stmtNameServerMetadata, ns.ID)
+	if err != nil {
+		return nil, err
+	}
+	ns.Source, err = ds.getNameServerSource(ctx, ns.ID)
 	if err != nil {
 		return nil, err
 	}
 
 	// get some active Domains
-	rows, err := ds.db.Query(ctx, "SELECT d.ID, d.domain, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE d.ID = dns.domain_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
+	rows, err := ds.db.Query(ctx, stmtNameServerDomains, ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -787,7 +1022,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.Domains = make([]*model.Domain, 0, 4)
 	for rows.Next() {
 		var d model.Domain
-		err = rows.Scan(&d.ID, &d.Name, &d.FirstSeen, &d.LastSeen)
+		err = scanRow(rows, &d)
 		if err != nil {
 			return nil, err
 		}
@@ -795,7 +1030,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	}
 
 	// get some old Domains
-	archiveRows, err := ds.db.Query(ctx, "SELECT d.ID, d.domain, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE d.ID = dns.domain_id AND dns.last_seen IS NOT NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
+	archiveRows, err := ds.db.Query(ctx, stmtNameServerArchiveDomains, ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -803,7 +1038,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.ArchiveDomains = make([]*model.Domain, 0, 4)
 	for archiveRows.Next() {
 		var d model.Domain
-		err = archiveRows.Scan(&d.ID, &d.Name, &d.FirstSeen, &d.LastSeen)
+		err = scanRow(archiveRows, &d)
 		if err != nil {
 			return nil, err
 		}
@@ -811,7 +1046,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	}
 
 	// get current IP4
-	rows, err = ds.db.Query(ctx, "SELECT ip.ID, ip.ip, dns.first_seen, dns.last_seen FROM a_nameservers dns, a ip WHERE ip.ID = dns.a_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
+	rows, err = ds.db.Query(ctx, "SELECT ip.id, ip.ip, dns.first_seen, dns.last_seen FROM a_nameservers dns, a ip WHERE ip.ID = dns.a_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -819,7 +1054,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.IP4 = make([]*model.IP4, 0, 4)
 	for rows.Next() {
 		var ip model.IP4
-		err = rows.Scan(&ip.ID, &ip.IP.IP, &ip.FirstSeen, &ip.LastSeen)
+		err = scanRow(rows, &ip)
 		if err != nil {
 			return nil, err
 		}
@@ -829,7 +1064,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	}
 
 	//get archive ipv4
-	rows, err = ds.db.Query(ctx, "SELECT ip.ID, ip.ip, ans.first_seen, ans.last_seen FROM a_nameservers ans, a ip WHERE ip.ID = ans.a_id AND ans.last_seen IS NOT NULL AND ans.nameserver_id = $1 limit 100", ns.ID)
+	rows, err = ds.db.Query(ctx, "SELECT ip.id, ip.ip, ans.first_seen, ans.last_seen FROM a_nameservers ans, a ip WHERE ip.ID = ans.a_id AND ans.last_seen IS NOT NULL AND ans.nameserver_id = $1 limit 100", ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -837,7 +1072,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.ArchiveIP4 = make([]*model.IP4, 0, 4)
 	for rows.Next() {
 		var ip model.IP4
-		err = rows.Scan(&ip.ID, &ip.IP.IP, &ip.FirstSeen, &ip.LastSeen)
+		err = scanRow(rows, &ip)
 		if err != nil {
 			return nil, err
 		}
@@ -863,7 +1098,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	// If we do not import the nameserver zone then
 	// we do not worry about populating the Zone fields
 	if z.ID != 0 {
-		err = ds.db.QueryRow(ctx, "select zones.zone, zone_imports.first_import_date, zone_imports.last_import_date from zones, zone_imports where zones.id = zone_imports.zone_id and zones.id = $1 limit 1", z.ID).Scan(&z.Name, &z.FirstSeen, &z.LastSeen)
+		err = ds.db.scanOne(ctx, &z, "select zones.zone AS name, zone_imports.first_import_date AS first_seen, zone_imports.last_import_date AS last_seen from zones, zone_imports where zones.id = zone_imports.zone_id and zones.id = $1 limit 1", z.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -871,7 +1106,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	}
 
 	// get current IP6
-	rows, err = ds.db.Query(ctx, "SELECT ip.ID, ip.ip, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, aaaa ip WHERE ip.ID = dns.aaaa_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
+	rows, err = ds.db.Query(ctx, "SELECT ip.id, ip.ip, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, aaaa ip WHERE ip.ID = dns.aaaa_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -879,7 +1114,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.IP6 = make([]*model.IP6, 0, 4)
 	for rows.Next() {
 		var ip model.IP6
-		err = rows.Scan(&ip.ID, &ip.IP.IP, &ip.FirstSeen, &ip.LastSeen)
+		err = scanRow(rows, &ip)
 		if err != nil {
 			return nil, err
 		}
@@ -889,7 +1124,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	}
 
 	//get archive ipv6
-	rows, err = ds.db.Query(ctx, "SELECT ip.ID, ip.ip, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, aaaa ip WHERE ip.ID = dns.aaaa_id AND dns.last_seen IS NOT NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
+	rows, err = ds.db.Query(ctx, "SELECT ip.id, ip.ip, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, aaaa ip WHERE ip.ID = dns.aaaa_id AND dns.last_seen IS NOT NULL AND dns.nameserver_id = $1 limit 100", ns.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -897,7 +1132,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 	ns.ArchiveIP6 = make([]*model.IP6, 0, 4)
 	for rows.Next() {
 		var ip model.IP6
-		err = rows.Scan(&ip.ID, &ip.IP.IP, &ip.FirstSeen, &ip.LastSeen)
+		err = scanRow(rows, &ip)
 		if err != nil {
 			return nil, err
 		}
@@ -919,7 +1154,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 	}
 	netIP := net.ParseIP(name)
 	if netIP == nil {
-		return nil, fmt.Errorf("unable top parse IP %s", name)
+		return nil, ErrInvalidIP
 	}
 	ip.IP = &netIP
 	ip.Name = ip.IPString()
@@ -948,7 +1183,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		}
 
 		// get current NS
-		rows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM a_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.a_id = $1 limit 100", ip.ID)
+		rows, err := ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM a_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.a_id = $1 limit 100", ip.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -956,7 +1191,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		ip.NameServers = make([]*model.NameServer, 0, 4)
 		for rows.Next() {
 			var ns model.NameServer
-			err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+			err = scanRow(rows, &ns)
 			if err != nil {
 				return nil, err
 			}
@@ -964,7 +1199,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		}
 
 		// get archive NS
-		rows, err = ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM a_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.a_id = $1 ORDER BY last_seen desc limit 100", ip.ID)
+		rows, err = ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM a_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.a_id = $1 ORDER BY last_seen desc limit 100", ip.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -972,7 +1207,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		ip.ArchiveNameServers = make([]*model.NameServer, 0, 4)
 		for rows.Next() {
 			var ns model.NameServer
-			err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+			err = scanRow(rows, &ns)
 			if err != nil {
 				return nil, err
 			}
@@ -1002,7 +1237,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		}
 
 		// get current NS
-		rows, err := ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.aaaa_id = $1 limit 100", ip.ID)
+		rows, err := ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.aaaa_id = $1 limit 100", ip.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -1010,7 +1245,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		ip.NameServers = make([]*model.NameServer, 0, 4)
 		for rows.Next() {
 			var ns model.NameServer
-			err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+			err = scanRow(rows, &ns)
 			if err != nil {
 				return nil, err
 			}
@@ -1018,7 +1253,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		}
 
 		// get archive NS
-		rows, err = ds.db.Query(ctx, "SELECT ns.ID, ns.domain, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.aaaa_id = $1 ORDER BY last_seen desc limit 100", ip.ID)
+		rows, err = ds.db.Query(ctx, "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM aaaa_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.aaaa_id = $1 ORDER BY last_seen desc limit 100", ip.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -1026,7 +1261,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 		ip.ArchiveNameServers = make([]*model.NameServer, 0, 4)
 		for rows.Next() {
 			var ns model.NameServer
-			err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen)
+			err = scanRow(rows, &ns)
 			if err != nil {
 				return nil, err
 			}
@@ -1098,7 +1333,7 @@ func (ds *DataStore) GetAvailablePrefixes(ctx context.Context, name string) (*mo
 	   available_domains.domain 
 	ORDER BY
 	   Char_length(available_domains.domain),
-	   1,  2`, name)
+	   1,  2`, escapeLike(name))
 	if err != nil {
 		return nil, err
 	}
@@ -1110,7 +1345,7 @@ func (ds *DataStore) GetAvailablePrefixes(ctx context.Context, name string) (*mo
 			return nil, err
 		}
 		if lastSeen.Status == pgtype.Present {
-			domain.LastSeen = &lastSeen.Time
+			domain.LastSeen = model.NewDate(lastSeen.Time)
 		}
 		prefixes.Domains = append(prefixes.Domains, domain)
 	}
@@ -1125,7 +1360,7 @@ func (ds *DataStore) GetTakenPrefixes(ctx context.Context, name string) (*model.
 	prefixes.Prefix = name
 	prefixes.Active = true
 	prefixes.Domains = make([]model.PrefixResult, 0, 10)
-	rows, err := ds.db.Query(ctx, "select domains.domain, min(domains_nameservers.first_seen) first_seen from domains, domains_nameservers where domains.id = domains_nameservers.domain_id and domain LIKE $1 || '.%' and last_seen is null group by domains.domain order by domains.domain", prefixes.Prefix)
+	rows, err := ds.db.Query(ctx, "select domains.domain, min(domains_nameservers.first_seen) first_seen from domains, domains_nameservers where domains.id = domains_nameservers.domain_id and domain LIKE $1 || '.%' and last_seen is null group by domains.domain order by domains.domain", escapeLike(prefixes.Prefix))
 	if err != nil {
 		return nil, err
 	}
@@ -1137,7 +1372,7 @@ func (ds *DataStore) GetTakenPrefixes(ctx context.Context, name string) (*model.
 			return nil, err
 		}
 		if firstSeen.Status == pgtype.Present {
-			domain.FirstSeen = &firstSeen.Time
+			domain.FirstSeen = model.NewDate(firstSeen.Time)
 		}
 		prefixes.Domains = append(prefixes.Domains, domain)
 	}
@@ -1160,18 +1395,22 @@ func (ds *DataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error)
 	where
 		dead_zones.id = zones_nameservers.zone_id
 	group by zone
-	order by 3 desc, 2 asc, 1`)
+	order by 3 desc, 2 asc, 1
+	limit $1`, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		var t model.TLDLife
-		err = rows.Scan(&t.Zone, &t.Created, &t.Removed, &t.Age, &t.Domains)
+		err = scanRow(rows, &t)
 		if err != nil {
 			return nil, err
 		}
 		out = append(out, &t)
+		if ds.tooManyRows(len(out)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return out, nil
@@ -1182,13 +1421,13 @@ func (ds *DataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error)
 // useing a zoneId is fast
 func (ds *DataStore) GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error) {
 	out := make([]model.Domain, 0, 50)
-	rows, err := ds.db.Query(ctx, "with dupes as (select domain, last_seen from domains, domains_nameservers, zones where domains.id = domains_nameservers.domain_id and domains_nameservers.zone_id = zones.id and zones.id = $1 order by last_Seen desc limit 150) select domain, max(last_seen) last_seen from dupes group by domain limit 50", zoneID)
+	rows, err := ds.db.Query(ctx, "with dupes as (select domain, last_seen from domains, domains_nameservers, zones where domains.id = domains_nameservers.domain_id and domains_nameservers.zone_id = zones.id and zones.id = $1 order by last_Seen desc limit 150) select domain AS name, max(last_seen) last_seen from dupes group by domain limit 50", zoneID)
 	if err != nil {
 		return out, err
 	}
 	for rows.Next() {
 		var d model.Domain
-		err = rows.Scan(&d.Name, &d.LastSeen)
+		err = scanRow(rows, &d)
 		if err != nil {
 			return out, err
 		}
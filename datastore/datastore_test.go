@@ -0,0 +1,28 @@
+package datastore
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// testDatabaseEnv names the database the benchmarks query, one with the dnscoffee schema
+// they are skipped when it is not set
+const testDatabaseEnv = "DNSCOFFEE_TEST_DATABASE_URL"
+
+// testDataStore connects to the database of $DNSCOFFEE_TEST_DATABASE_URL or skips tb
+func testDataStore(tb testing.TB) *DataStore {
+	tb.Helper()
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		tb.Skipf("$%s is not set", testDatabaseEnv)
+	}
+	config := DefaultConfig
+	config.DSN = dsn
+	ds, err := New(context.Background(), config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ds.Close() })
+	return ds
+}
@@ -0,0 +1,214 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/reqstats"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// db wraps the connection pool so that every query passes through the circuit breaker,
+// transient errors are retried, and query timings are recorded
+// it has the same query methods as pgxpool.Pool, Query and QueryRow must only be used for read only queries
+type db struct {
+	// time.Duration, changed at runtime with SetSlowQueryThreshold
+	// first in the struct for 64-bit alignment of atomic operations on 32-bit platforms
+	slowQueryThreshold int64
+
+	pool *pgxpool.Pool
+	// where the queries run outside a transaction, the pool, tests can give a fake
+	queries querier
+	breaker *circuitBreaker
+	// set on the db of a ReadTx, its queries then run in the transaction instead of on the pool
+	tx pgx.Tx
+
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// querier has the query methods of pgxpool.Pool and pgx.Tx
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// conn returns where the queries run, the transaction if there is one
+func (d *db) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.queries
+}
+
+// Query runs a query returning rows
+// errors before the first row is read are retried, whether Query or the rows report them, later ones are not since
+// the caller has read rows already: the rows return them from Err and IsTransient classifies them
+func (d *db) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return d.query(ctx, callerName(1), sql, args)
+}
+
+// query runs a query returning rows, recorded under the name of the method running it
+func (d *db) query(ctx context.Context, method, sql string, args []interface{}) (pgx.Rows, error) {
+	q := &queryInfo{method: method, sql: sql, args: args, start: time.Now(), stats: reqstats.FromContext(ctx)}
+	q.startSpan(ctx)
+	rows, attempt, err := d.run(ctx, q, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedRows{Rows: rows, d: d, ctx: ctx, query: q, attempt: attempt}, nil
+}
+
+// run runs the query of q from attempt on until it does not fail with a transient error
+// returns the rows and the attempt they came from, q is recorded when it fails
+func (d *db) run(ctx context.Context, q *queryInfo, attempt int) (pgx.Rows, int, error) {
+	for ; ; attempt++ {
+		if !d.breaker.allow() {
+			q.endSpan(0, ErrDatabaseUnavailable)
+			return nil, attempt, ErrDatabaseUnavailable
+		}
+		rows, err := d.conn().Query(ctx, q.sql, q.args...)
+		if err == nil {
+			return rows, attempt, nil
+		}
+		d.breaker.record(err)
+		if !d.shouldRetry(ctx, attempt, err) {
+			d.observe(q, 0, err)
+			return nil, attempt, err
+		}
+	}
+}
+
+// QueryRow runs a query returning at most one row
+// the query is run when the row is scanned
+func (d *db) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &row{d: d, ctx: ctx, query: &queryInfo{method: callerName(1), sql: sql, args: args, stats: reqstats.FromContext(ctx)}}
+}
+
+// Exec runs a statement that does not return rows
+// statements are not retried since they may not be idempotent
+func (d *db) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	q := &queryInfo{method: callerName(1), sql: sql, args: args, start: time.Now(), stats: reqstats.FromContext(ctx)}
+	q.startSpan(ctx)
+	if !d.breaker.allow() {
+		q.endSpan(0, ErrDatabaseUnavailable)
+		return nil, ErrDatabaseUnavailable
+	}
+	tag, err := d.conn().Exec(ctx, sql, args...)
+	d.breaker.record(err)
+	d.observe(q, int(tag.RowsAffected()), err)
+	return tag, err
+}
+
+// Close closes the connection pool
+func (d *db) Close() {
+	d.pool.Close()
+}
+
+// row runs the query when scanned, recording the result in the breaker and retrying transient errors
+type row struct {
+	d     *db
+	ctx   context.Context
+	query *queryInfo
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	r.query.start = time.Now()
+	r.query.startSpan(r.ctx)
+	for attempt := 0; ; attempt++ {
+		if !r.d.breaker.allow() {
+			r.query.endSpan(0, ErrDatabaseUnavailable)
+			return ErrDatabaseUnavailable
+		}
+		err := r.d.conn().QueryRow(r.ctx, r.query.sql, r.query.args...).Scan(dest...)
+		r.d.breaker.record(err)
+		if err == nil || !r.d.shouldRetry(r.ctx, attempt, err) {
+			n := 0
+			if err == nil {
+				n = 1
+			}
+			r.d.observe(r.query, n, err)
+			return err
+		}
+	}
+}
+
+// trackedRows records the result of the query once the rows are consumed or closed
+// the query is run again when it fails with a transient error before its first row
+type trackedRows struct {
+	pgx.Rows
+	d       *db
+	ctx     context.Context
+	query   *queryInfo
+	attempt int
+	count   int
+	done    bool
+	// the error of the last attempt, when it failed before returning rows
+	err error
+}
+
+func (r *trackedRows) Next() bool {
+	for {
+		if r.Rows.Next() {
+			r.count++
+			return true
+		}
+		if !r.retry() {
+			r.finish()
+			return false
+		}
+	}
+}
+
+// retry runs the query again when the rows failed with a transient error before the first row, pgx reports most
+// errors of a query there instead of from Query
+// returns false when the rows ended or may not be retried, with the result recorded if retry did
+func (r *trackedRows) retry() bool {
+	err := r.Rows.Err()
+	if r.count > 0 || r.done || !isRetryable(err) {
+		return false
+	}
+	r.done = true
+	r.d.breaker.record(err)
+	if !r.d.shouldRetry(r.ctx, r.attempt, err) {
+		r.d.observe(r.query, 0, err)
+		return false
+	}
+	r.Rows.Close()
+	rows, attempt, err := r.d.run(r.ctx, r.query, r.attempt+1)
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.Rows, r.attempt, r.done = rows, attempt, false
+	return true
+}
+
+func (r *trackedRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
+
+func (r *trackedRows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *trackedRows) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	err := r.Rows.Err()
+	if r.count > 0 && isRetryable(err) {
+		rowsInterrupted.Add(1)
+	}
+	r.d.breaker.record(err)
+	r.d.observe(r.query, r.count, err)
+}
@@ -0,0 +1,120 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Import is a finished import of a zone
+type Import struct {
+	ID   int64
+	Date time.Time
+}
+
+// ZoneDiff holds the domains that changed in a zone between two imports, each list sorted bytewise
+type ZoneDiff struct {
+	From, To Import
+	// domains in the To import but not the From import
+	Added []string
+	// domains in the From import but not the To import
+	Removed []string
+	// domains in both imports whose nameservers differ
+	Changed []string
+}
+
+// ZoneChurn counts the domains of a zone that stayed, were added or removed, and changed nameservers between two imports
+type ZoneChurn struct {
+	From, To Import
+	// domains in both imports, Changed of them with other nameservers
+	Stayed  int64
+	Added   int64
+	Removed int64
+	Changed int64
+}
+
+// GetClosestImport returns the finished import of the zone nearest to date, the earlier one on a tie
+func (ds *DataStore) GetClosestImport(ctx context.Context, zoneID int64, date time.Time) (Import, error) {
+	var imp Import
+	err := ds.db.QueryRow(ctx, "select id, date from imports where zone_id = $1 and imported = true order by abs(date - $2::date), date limit 1", zoneID, date).Scan(&imp.ID, &imp.Date)
+	if err == pgx.ErrNoRows {
+		err = ErrNoResource
+	}
+	return imp, err
+}
+
+// GetZoneDiff computes the set differences between the domains and their nameservers of the zone on two import dates
+// it is expensive for large zones, the number of changed domains is bounded by the row limit
+func (ds *DataStore) GetZoneDiff(ctx context.Context, zoneID int64, from, to Import) (*ZoneDiff, error) {
+	diff := &ZoneDiff{From: from, To: to, Added: []string{}, Removed: []string{}, Changed: []string{}}
+	rows, err := ds.db.Query(ctx, `with a as (select domain_id, nameserver_id from domains_nameservers where zone_id = $1 and first_seen <= $2 and (last_seen >= $2 or last_seen is null)),
+		b as (select domain_id, nameserver_id from domains_nameservers where zone_id = $1 and first_seen <= $3 and (last_seen >= $3 or last_seen is null)),
+		a_domains as (select distinct domain_id from a),
+		b_domains as (select distinct domain_id from b),
+		changes as (
+			select domain_id, 'added' as change from (select domain_id from b_domains except select domain_id from a_domains) added
+			union all
+			select domain_id, 'removed' from (select domain_id from a_domains except select domain_id from b_domains) removed
+			union all
+			select domain_id, 'changed' from (
+				select domain_id from ((select * from a except select * from b) union (select * from b except select * from a)) ns
+				intersect select domain_id from a_domains
+				intersect select domain_id from b_domains) changed
+		)
+		select changes.change, domains.domain from changes, domains where domains.id = changes.domain_id limit $4`,
+		zoneID, from.Date, to.Date, ds.rowLimit())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var change, domain string
+		err = rows.Scan(&change, &domain)
+		if err != nil {
+			return nil, err
+		}
+		switch change {
+		case "added":
+			diff.Added = append(diff.Added, domain)
+		case "removed":
+			diff.Removed = append(diff.Removed, domain)
+		case "changed":
+			diff.Changed = append(diff.Changed, domain)
+		}
+		n++
+		if ds.tooManyRows(n) {
+			return nil, ErrTooManyRows
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// sorted here rather than by the database collation so that callers can binary search the lists
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff, nil
+}
+
+// GetZoneChurn counts the set differences between the domains and their nameservers of the zone on two import dates
+// only the counts are returned, so unlike GetZoneDiff it is not bounded by the row limit, but it is as expensive
+func (ds *DataStore) GetZoneChurn(ctx context.Context, zoneID int64, from, to Import) (*ZoneChurn, error) {
+	churn := &ZoneChurn{From: from, To: to}
+	err := ds.db.QueryRow(ctx, `with a as (select domain_id, array_agg(nameserver_id order by nameserver_id) ns from domains_nameservers
+			where zone_id = $1 and first_seen <= $2 and (last_seen >= $2 or last_seen is null) group by domain_id),
+		b as (select domain_id, array_agg(nameserver_id order by nameserver_id) ns from domains_nameservers
+			where zone_id = $1 and first_seen <= $3 and (last_seen >= $3 or last_seen is null) group by domain_id)
+		select count(*) filter (where a.domain_id is not null and b.domain_id is not null),
+			count(*) filter (where a.domain_id is null),
+			count(*) filter (where b.domain_id is null),
+			count(*) filter (where a.ns <> b.ns)
+		from a full join b on b.domain_id = a.domain_id`,
+		zoneID, from.Date, to.Date).Scan(&churn.Stayed, &churn.Added, &churn.Removed, &churn.Changed)
+	if err != nil {
+		return nil, err
+	}
+	return churn, nil
+}
@@ -0,0 +1,84 @@
+package datastore
+
+import (
+	"context"
+	"sync"
+
+	"dnscoffee/logging"
+)
+
+// zoneRef is a zone queried by a fan-out
+type zoneRef struct {
+	ID   int64
+	Zone string
+}
+
+// getImportedZones returns the zones with an import but the root, in name order
+func (ds *DataStore) getImportedZones(ctx context.Context) ([]zoneRef, error) {
+	rows, err := ds.db.Query(ctx, `select z.id, z.zone from zones z join zone_imports on zone_imports.zone_id = z.id
+		where z.zone <> '' order by z.zone`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var zones []zoneRef
+	for rows.Next() {
+		var z zoneRef
+		err = rows.Scan(&z.ID, &z.Zone)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+// fanOut calls fn for every index up to n, up to maxParallelZoneQueries at once, fn stores its result at index i so that
+// the results keep their order whatever order the queries finish in; it returns the error of every index, those not
+// started for a canceled request being nil, the caller checks ctx
+func (ds *DataStore) fanOut(ctx context.Context, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, ds.maxParallelZoneQueries)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// the queries not started yet are not run for a canceled request
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// fanOutZones calls fn for every zone like fanOut
+// it returns the names of the zones fn failed for, in the order of zones, or an error when nothing can be returned:
+// the request was canceled or every zone failed, then the error of the first zone, ex: ErrDatabaseUnavailable
+func (ds *DataStore) fanOutZones(ctx context.Context, zones []zoneRef, fn func(ctx context.Context, i int, z zoneRef) error) ([]string, error) {
+	errs := ds.fanOut(ctx, len(zones), func(ctx context.Context, i int) error {
+		return fn(ctx, i, zones[i])
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			logging.Warnf("zone %s: %s", zones[i].Zone, err)
+			failed = append(failed, zones[i].Zone)
+		}
+	}
+	if len(zones) > 0 && len(failed) == len(zones) {
+		return nil, errs[0]
+	}
+	return failed, nil
+}
@@ -0,0 +1,102 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutZones(t *testing.T) {
+	zones := []zoneRef{{1, "com"}, {2, "net"}, {3, "org"}, {4, "info"}, {5, "xyz"}}
+	unavailable := ErrDatabaseUnavailable
+	tests := []struct {
+		name     string
+		parallel int
+		// zones whose query fails
+		failing    map[string]bool
+		wantFailed []string
+		wantErr    error
+	}{
+		{name: "no failure", parallel: 2},
+		{name: "one at a time", parallel: 1},
+		{name: "more than the zones", parallel: 8},
+		{name: "some fail", parallel: 2, failing: map[string]bool{"net": true, "xyz": true}, wantFailed: []string{"net", "xyz"}},
+		{name: "every zone fails", parallel: 3, failing: map[string]bool{"com": true, "net": true, "org": true, "info": true, "xyz": true}, wantErr: unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &DataStore{maxParallelZoneQueries: tt.parallel}
+			results := make([]string, len(zones))
+			var running, most int32
+			failed, err := ds.fanOutZones(context.Background(), zones, func(ctx context.Context, i int, z zoneRef) error {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					m := atomic.LoadInt32(&most)
+					if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+						break
+					}
+				}
+				// the later zones finish first
+				time.Sleep(time.Duration(len(zones)-i) * time.Millisecond)
+				if tt.failing[z.Zone] {
+					return unavailable
+				}
+				results[i] = z.Zone
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("got failed zones %v, want %v", failed, tt.wantFailed)
+			}
+			if int(most) > tt.parallel {
+				t.Errorf("ran %d queries at once, want at most %d", most, tt.parallel)
+			}
+			if err != nil {
+				return
+			}
+			// the results are in the order of the zones
+			for i, z := range zones {
+				if !tt.failing[z.Zone] && results[i] != z.Zone {
+					t.Errorf("result %d is %q, want %q", i, results[i], z.Zone)
+				}
+			}
+		})
+	}
+}
+
+// TestFanOutZonesCanceled stops starting zones once the request is canceled
+func TestFanOutZonesCanceled(t *testing.T) {
+	zones := []zoneRef{{1, "com"}, {2, "net"}, {3, "org"}, {4, "info"}}
+	ds := &DataStore{maxParallelZoneQueries: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var started int32
+	failed, err := ds.fanOutZones(ctx, zones, func(ctx context.Context, i int, z zoneRef) error {
+		atomic.AddInt32(&started, 1)
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || failed != nil {
+		t.Errorf("got %v and failed zones %v, want %v", err, failed, context.Canceled)
+	}
+	if started != 1 {
+		t.Errorf("started %d zones after the cancel, want 1", started)
+	}
+}
+
+func TestFanOutNoZones(t *testing.T) {
+	ds := &DataStore{maxParallelZoneQueries: 2}
+	failed, err := ds.fanOutZones(context.Background(), nil, func(ctx context.Context, i int, z zoneRef) error {
+		t.Error("called without zones")
+		return nil
+	})
+	if failed != nil || err != nil {
+		t.Errorf("got failed zones %v and %v, want none", failed, err)
+	}
+}
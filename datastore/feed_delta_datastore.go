@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// FeedPosition is a position in the domains added by the finished imports, taken in the order they finished
+// the rows of an import are ordered by name and Offset of them have been read, ImportID 0 is before every import
+type FeedPosition struct {
+	ImportID int64
+	Offset   int64
+}
+
+// GetLastFeedPosition returns the position after every domain of the latest finished import
+// imports finished before schema version 4 have no finish time and sort first, by id
+func (ds *DataStore) GetLastFeedPosition(ctx context.Context) (FeedPosition, error) {
+	var pos FeedPosition
+	err := ds.db.QueryRow(ctx, "select i.id, (select count(*) from recent_new_domains r join domains d on d.id = r.domain_id where r.date = i.date and d.zone_id = i.zone_id) from imports i where i.imported = true order by "+
+		"coalesce(i.imported_at, 'epoch'::timestamptz) desc, i.id desc limit 1").Scan(&pos.ImportID, &pos.Offset)
+	if err == pgx.ErrNoRows {
+		return FeedPosition{}, nil
+	}
+	return pos, err
+}
+
+// GetFeedNewSince returns up to limit domains added by the finished imports after pos, in import order, only of the zone unless it is 0
+// and of the imports from one of sources unless it is empty
+// returns ErrNoResource if the import of pos does not exist any more
+// an import finishing between two pages sorts after the position and is read by a later page
+func (ds *DataStore) GetFeedNewSince(ctx context.Context, pos FeedPosition, zoneID int64, sources []string, limit int) ([]*model.FeedDeltaDomain, error) {
+	var after time.Time
+	if pos.ImportID != 0 {
+		err := ds.db.QueryRow(ctx, "select coalesce(imported_at, 'epoch'::timestamptz) from imports where id = $1 and imported = true", pos.ImportID).Scan(&after)
+		if err == pgx.ErrNoRows {
+			return nil, ErrNoResource
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows, err := ds.db.Query(ctx, `with added as (
+			select i.id, coalesce(i.imported_at, 'epoch'::timestamptz) as finished, i.date, z.zone, d.domain
+			from imports i join zones z on z.id = i.zone_id
+			join recent_new_domains r on r.date = i.date join domains d on d.id = r.domain_id and d.zone_id = i.zone_id
+			where i.imported = true and ($4 = 0 or i.zone_id = $4) and ($6::text[] is null or i.source = any($6::text[])))
+		select id as import_id, date, zone, domain as name from (
+			(select * from added where id = $1 order by domain offset $2)
+			union all
+			(select * from added where (finished, id) > ($5, $1) order by finished, id, domain limit $3)
+		) page order by finished, id, domain limit $3`,
+		pos.ImportID, pos.Offset, limit, zoneID, after, arrayParam(sources))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	domains := make([]*model.FeedDeltaDomain, 0, limit)
+	for rows.Next() {
+		var d model.FeedDeltaDomain
+		err = scanRow(rows, &d)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, &d)
+	}
+	return domains, rows.Err()
+}
@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// feedTables are the tables holding the domains of each feed by date
+var feedTables = map[string]string{
+	"new":   "recent_new_domains",
+	"old":   "recent_old_domains",
+	"moved": "recent_moved_domains",
+}
+
+// StreamFeed calls fn with every domain of the new, old or moved feed of date, in name order
+// unlike GetFeedNew and the other feeds it is not bounded by the row limit, the rows are never held in memory
+// only the domains of imports from one of sources are streamed unless it is empty
+// an error returned by fn stops the query and is returned
+func (ds *DataStore) StreamFeed(ctx context.Context, change string, date time.Time, sources []string, fn func(domain string) error) error {
+	table, ok := feedTables[change]
+	if !ok {
+		return fmt.Errorf("unknown feed %q", change)
+	}
+	rows, err := ds.db.Query(ctx, "SELECT r.domain from "+table+" r where r.date = $1 and ($2::text[] is null or "+
+		"exists (select 1 from domains d join imports i on i.zone_id = d.zone_id where d.id = r.domain_id and i.date = r.date "+
+		"and i.imported = true and i.source = any($2::text[]))) order by r.domain", date, arrayParam(sources))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var domain string
+		err = rows.Scan(&domain)
+		if err != nil {
+			return err
+		}
+		err = fn(domain)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
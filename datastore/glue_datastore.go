@@ -0,0 +1,147 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// glue anomaly types of glue_inconsistencies
+const (
+	OrphanGlue  = "orphan_glue"
+	MissingGlue = "missing_glue"
+)
+
+// glueCheck is the latest import of a zone whose glue was not checked yet
+type glueCheck struct {
+	zoneID   int64
+	zone     string
+	importID int64
+}
+
+// RefreshGlueInconsistencies finds the glue anomalies of the latest finished import of every zone not checked since it landed
+// and returns the number of zones checked, the anomalies of an import are only served once both types are found
+func (ds *DataStore) RefreshGlueInconsistencies(ctx context.Context) (int, error) {
+	rows, err := ds.db.Query(ctx, `select i.zone_id, z.zone, i.id
+		from (select distinct on (zone_id) zone_id, id from imports where imported = true order by zone_id, date desc, id desc) i
+		join zones z on z.id = i.zone_id
+		left join glue_inconsistency_imports g on g.zone_id = i.zone_id
+		where g.import_id is distinct from i.id
+		order by i.zone_id`)
+	if err != nil {
+		return 0, err
+	}
+	var checks []glueCheck
+	for rows.Next() {
+		var c glueCheck
+		err = rows.Scan(&c.zoneID, &c.zone, &c.importID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		checks = append(checks, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, c := range checks {
+		err = ds.checkGlue(ctx, c)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(checks), nil
+}
+
+// checkGlue stores the anomalies of the import of a zone and then records the import, so that a check cut short is redone
+// the anomalies of its earlier imports are deleted last, until then they are still served
+func (ds *DataStore) checkGlue(ctx context.Context, c glueCheck) error {
+	// glue of the zone for nameservers no domain of any zone nor any zone apex delegates to
+	_, err := ds.db.Exec(ctx, `insert into glue_inconsistencies
+			(zone_id, import_id, kind, nameserver, addresses, domains_count, example_domain, last_delegated)
+		select $1, $2, 'orphan_glue', lower(ns.domain), g.addresses, 0, '',
+			(select max(dns.last_seen) from domains_nameservers dns where dns.nameserver_id = ns.id)
+		from (select nameserver_id, array_agg(distinct host(ip) order by host(ip)) as addresses
+			from (select an.nameserver_id, a.ip from a_nameservers an join a on a.id = an.a_id
+					where an.zone_id = $1 and an.last_seen is null
+				union all
+				select an.nameserver_id, aaaa.ip from aaaa_nameservers an join aaaa on aaaa.id = an.aaaa_id
+					where an.zone_id = $1 and an.last_seen is null) glue
+			group by nameserver_id) g
+		join nameservers ns on ns.id = g.nameserver_id
+		where not exists (select 1 from domains_nameservers dns where dns.nameserver_id = g.nameserver_id and dns.last_seen is null)
+			and not exists (select 1 from zones_nameservers zns where zns.nameserver_id = g.nameserver_id and zns.last_seen is null)
+		on conflict do nothing`, c.zoneID, c.importID)
+	if err != nil {
+		return err
+	}
+	// nameservers under the zone, which can only be resolved with its glue, delegated to by its domains without any
+	// the glue they have in other zones, such as those sharing a registry, is given as context
+	_, err = ds.db.Exec(ctx, `insert into glue_inconsistencies
+			(zone_id, import_id, kind, nameserver, addresses, domains_count, example_domain, last_delegated)
+		select $1, $2, 'missing_glue', lower(ns.domain),
+			coalesce((select array_agg(distinct host(ip) order by host(ip))
+				from (select a.ip from a_nameservers an join a on a.id = an.a_id where an.nameserver_id = ns.id and an.last_seen is null
+					union all
+					select aaaa.ip from aaaa_nameservers an join aaaa on aaaa.id = an.aaaa_id where an.nameserver_id = ns.id and an.last_seen is null) glue),
+				'{}'),
+			count(distinct d.id), min(d.domain), null
+		from domains_nameservers dns join domains d on d.id = dns.domain_id join nameservers ns on ns.id = dns.nameserver_id
+		where dns.zone_id = $1 and dns.last_seen is null
+			and ($3::text = '' or right(lower(ns.domain), length($3::text) + 1) = '.' || $3::text)
+			and not exists (select 1 from a_nameservers an where an.nameserver_id = ns.id and an.zone_id = $1 and an.last_seen is null)
+			and not exists (select 1 from aaaa_nameservers an where an.nameserver_id = ns.id and an.zone_id = $1 and an.last_seen is null)
+		group by ns.id, ns.domain
+		on conflict do nothing`, c.zoneID, c.importID, c.zone)
+	if err != nil {
+		return err
+	}
+	// counted from the table, rows of a check cut short were not inserted again
+	_, err = ds.db.Exec(ctx, `insert into glue_inconsistency_imports (zone_id, import_id, orphan_glue, missing_glue, computed_at)
+		select $1, $2, count(*) filter (where kind = 'orphan_glue'), count(*) filter (where kind = 'missing_glue'), now()
+		from glue_inconsistencies where zone_id = $1 and import_id = $2
+		on conflict (zone_id) do update set import_id = excluded.import_id, orphan_glue = excluded.orphan_glue,
+			missing_glue = excluded.missing_glue, computed_at = excluded.computed_at`, c.zoneID, c.importID)
+	if err != nil {
+		return err
+	}
+	_, err = ds.db.Exec(ctx, "delete from glue_inconsistencies where zone_id = $1 and import_id <> $2", c.zoneID, c.importID)
+	return err
+}
+
+// GetGlueInconsistencies returns the latest checked import of a zone with up to limit of its anomalies of kind
+// whose nameserver is after the name after, in name order, ErrNoResource until the glue of the zone was checked
+func (ds *DataStore) GetGlueInconsistencies(ctx context.Context, zoneID int64, kind, after string, limit int) (*model.GlueInconsistencies, error) {
+	data := &model.GlueInconsistencies{Inconsistency: kind}
+	err := ds.db.QueryRow(ctx, `select g.import_id, i.date, g.computed_at,
+			case when $2::text = 'orphan_glue' then g.orphan_glue else g.missing_glue end
+		from glue_inconsistency_imports g join imports i on i.id = g.import_id
+		where g.zone_id = $1`, zoneID, kind).Scan(&data.ImportID, &data.ImportDate, &data.ComputedAt, &data.Total)
+	if err == pgx.ErrNoRows {
+		err = ErrNoResource
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := ds.db.Query(ctx, `select nameserver, addresses, domains_count, example_domain, last_delegated
+		from glue_inconsistencies
+		where zone_id = $1 and import_id = $2 and kind = $3 and nameserver > $4
+		order by nameserver limit $5`, zoneID, data.ImportID, kind, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	data.Items = make([]*model.GlueInconsistency, 0, limit)
+	for rows.Next() {
+		var gi model.GlueInconsistency
+		err = scanRow(rows, &gi)
+		if err != nil {
+			return nil, err
+		}
+		data.Items = append(data.Items, &gi)
+	}
+	return data, rows.Err()
+}
@@ -0,0 +1,83 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// idempotencyColumns are the columns of an idempotency key, in the order of model.IdempotencyKey
+const idempotencyColumns = `client, key, request_sha256, done, status, content_type, body_sha256, body_bytes, body, expires_at`
+
+// ClaimIdempotencyKey stores a claim for a request being processed unless the client has an unexpired entry for the
+// key, which is returned; the expired entries are taken over, so that only one of concurrent requests gets a nil entry
+func (ds *DataStore) ClaimIdempotencyKey(ctx context.Context, claim *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	// the entry may expire and be removed between the insert and the select, the claim is then tried again once
+	for attempt := 0; attempt < 2; attempt++ {
+		tag, err := ds.db.Exec(ctx, `insert into idempotency_keys (client, key, request_sha256, expires_at)
+			values ($1, $2, $3, $4)
+			on conflict (client, key) do update set request_sha256 = excluded.request_sha256, done = false, status = 0,
+				content_type = '', body_sha256 = '', body_bytes = 0, body = null, expires_at = excluded.expires_at
+			where idempotency_keys.expires_at < now()`, claim.Client, claim.Key, claim.RequestSHA256, claim.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if tag.RowsAffected() == 1 {
+			return nil, nil
+		}
+		rows, err := ds.db.Query(ctx, "select "+idempotencyColumns+" from idempotency_keys where client = $1 and key = $2",
+			claim.Client, claim.Key)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := scanIdempotencyKey(rows)
+		if entry != nil || err != nil {
+			return entry, err
+		}
+	}
+	return nil, errors.New("idempotency key claimed and removed concurrently")
+}
+
+// scanIdempotencyKey returns the first idempotency key of rows, nil if there is none, and closes rows
+func scanIdempotencyKey(rows pgx.Rows) (*model.IdempotencyKey, error) {
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var entry model.IdempotencyKey
+	err := scanRow(rows, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SaveIdempotencyKey stores the response of the request of a claim, unless the claim expired and was taken over
+func (ds *DataStore) SaveIdempotencyKey(ctx context.Context, entry *model.IdempotencyKey) error {
+	_, err := ds.db.Exec(ctx, `update idempotency_keys set done = true, status = $4, content_type = $5, body_sha256 = $6,
+			body_bytes = $7, body = $8, expires_at = $9
+		where client = $1 and key = $2 and request_sha256 = $3 and not done`,
+		entry.Client, entry.Key, entry.RequestSHA256, entry.Status, entry.ContentType, entry.BodySHA256, entry.BodyBytes,
+		entry.Body, entry.ExpiresAt)
+	return err
+}
+
+// ReleaseIdempotencyKey removes the claim of a request that failed
+func (ds *DataStore) ReleaseIdempotencyKey(ctx context.Context, claim *model.IdempotencyKey) error {
+	_, err := ds.db.Exec(ctx, `delete from idempotency_keys
+		where client = $1 and key = $2 and request_sha256 = $3 and not done`, claim.Client, claim.Key, claim.RequestSHA256)
+	return err
+}
+
+// DeleteIdempotencyKeys removes the idempotency keys that expired before before and returns how many
+func (ds *DataStore) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := ds.db.Exec(ctx, "delete from idempotency_keys where expires_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+)
+
+// CheckImport is a finished import a job has not checked or counted yet, see GetUncheckedImports and GetLabelStatsImports
+type CheckImport struct {
+	ID     int64      `db:"id"`
+	ZoneID int64      `db:"zone_id"`
+	Zone   string     `db:"zone"`
+	Date   model.Date `db:"date"`
+}
+
+// importCheckColumns selects a check with the name of its zone
+const importCheckColumns = `select k.import_id, z.zone, k.date, k.added, k.removed, k.history, k.added_average, k.removed_average,
+		k.suspect, k.reasons, k.checked_at, k.acknowledged_at, k.acknowledged_by
+	from import_checks k join zones z on z.id = k.zone_id`
+
+// GetUncheckedImports returns the finished imports without a sanity check, oldest first
+// only the imports whose date is still in recent_new_domains can be counted, older ones are never checked
+func (ds *DataStore) GetUncheckedImports(ctx context.Context) ([]CheckImport, error) {
+	rows, err := ds.db.Query(ctx, `select i.id, i.zone_id, z.zone, i.date
+		from imports i join zones z on z.id = i.zone_id
+		where i.imported = true
+			and i.date >= (select min(date) from recent_new_domains)
+			and not exists (select 1 from import_checks k where k.import_id = i.id)
+		order by i.date, i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var imports []CheckImport
+	for rows.Next() {
+		var ci CheckImport
+		err = scanRow(rows, &ci)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, ci)
+	}
+	return imports, rows.Err()
+}
+
+// CountImportChanges returns the number of domains an import added and removed
+func (ds *DataStore) CountImportChanges(ctx context.Context, ci CheckImport) (added, removed int64, err error) {
+	err = ds.db.QueryRow(ctx, `select
+		(select count(*) from recent_new_domains r join domains d on d.id = r.domain_id where r.date = $1 and d.zone_id = $2),
+		(select count(*) from recent_old_domains r join domains d on d.id = r.domain_id where r.date = $1 and d.zone_id = $2)`,
+		ci.Date, ci.ZoneID).Scan(&added, &removed)
+	return added, removed, err
+}
+
+// GetImportCheckAverages returns how many of the last n checks of the zone before the import were not suspect,
+// and the averages of the domains they added and removed, suspect imports would skew the averages they are judged by
+func (ds *DataStore) GetImportCheckAverages(ctx context.Context, ci CheckImport, n int) (history int, added, removed float64, err error) {
+	err = ds.db.QueryRow(ctx, `select count(*), coalesce(avg(added), 0)::float8, coalesce(avg(removed), 0)::float8 from (
+			select added, removed from import_checks where zone_id = $1 and date < $2 and not suspect
+			order by date desc limit $3
+		) previous`, ci.ZoneID, ci.Date, n).Scan(&history, &added, &removed)
+	return history, added, removed, err
+}
+
+// SaveImportCheck records the sanity check of an import, a check done before is kept
+func (ds *DataStore) SaveImportCheck(ctx context.Context, ci CheckImport, ic *model.ImportCheck) error {
+	reasons := ic.Reasons
+	if reasons == nil {
+		reasons = []string{}
+	}
+	_, err := ds.db.Exec(ctx, `insert into import_checks (import_id, zone_id, date, added, removed, history, added_average, removed_average,
+			suspect, reasons, checked_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		on conflict (import_id) do nothing`,
+		ci.ID, ci.ZoneID, ci.Date, ic.Added, ic.Removed, ic.History, ic.AddedAverage, ic.RemovedAverage,
+		ic.Suspect, reasons, ic.CheckedAt.Time)
+	return err
+}
+
+// GetSuspectImports returns the checks of the suspect imports, acknowledged or not, oldest first
+func (ds *DataStore) GetSuspectImports(ctx context.Context) ([]*model.ImportCheck, error) {
+	return ds.getImportChecks(ctx, importCheckColumns+" where k.suspect order by k.date, k.import_id")
+}
+
+// GetImportAlerts returns the checks of the suspect imports not acknowledged yet, latest first
+func (ds *DataStore) GetImportAlerts(ctx context.Context) ([]*model.ImportCheck, error) {
+	return ds.getImportChecks(ctx, importCheckColumns+" where k.suspect and k.acknowledged_at is null order by k.date desc, k.import_id desc")
+}
+
+// AcknowledgeImportAlert acknowledges the alert of a suspect import, by names who did, and returns its check
+// clear also resets suspect as a false alarm, the responses derived from the import stop being flagged and later
+// checks count it in their averages; ErrNoResource if its check did not find the import suspect
+func (ds *DataStore) AcknowledgeImportAlert(ctx context.Context, importID int64, by string, clear bool) (*model.ImportCheck, error) {
+	tag, err := ds.db.Exec(ctx, `update import_checks set acknowledged_at = coalesce(acknowledged_at, now()), acknowledged_by = $2,
+			suspect = suspect and not $3
+		where import_id = $1 and reasons <> '{}'`, importID, by, clear)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNoResource
+	}
+	checks, err := ds.getImportChecks(ctx, importCheckColumns+" where k.import_id = $1", importID)
+	if err != nil {
+		return nil, err
+	}
+	if len(checks) == 0 {
+		return nil, ErrNoResource
+	}
+	return checks[0], nil
+}
+
+func (ds *DataStore) getImportChecks(ctx context.Context, query string, args ...interface{}) ([]*model.ImportCheck, error) {
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	checks := make([]*model.ImportCheck, 0)
+	for rows.Next() {
+		var ic model.ImportCheck
+		err = scanRow(rows, &ic)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &ic)
+	}
+	return checks, rows.Err()
+}
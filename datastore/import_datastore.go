@@ -0,0 +1,143 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+)
+
+// import states and stage states of model.Import
+const (
+	importRunning  = "running"
+	importFinished = "finished"
+	importFailed   = "failed"
+
+	stageDone    = "done"
+	stageRunning = "running"
+	stagePending = "pending"
+	stageFailed  = "failed"
+	stageSkipped = "skipped"
+)
+
+// importSuperseded is the error of an unfinished import of a zone whose later import finished
+const importSuperseded = "a later import of the zone finished, this one stopped"
+
+// stages of the zone importer in pipeline order, as recorded in import_progress:
+// the zone file is downloaded, diffed with the previous one and loaded into the tables
+// parsing and indexing happen within the load and are not recorded on their own
+var importStages = []string{"download", "diff", "load"}
+
+// importColumns selects an import with its progress, its counts once finished, whether a later import of its zone finished,
+// whether its sanity check found it suspect and its source
+// the importer writes import_progress as it goes and marks the import imported last, so an unfinished import with a later
+// finished one crashed or was abandoned
+const importColumns = `select i.id, z.zone, i.date, i.imported, i.imported_at,
+		p.zonefile_path is not null as downloaded, p.zonediff_path is not null as diffed, p.diff_duration, p.import_duration,
+		c.domains, c.records,
+		exists (select 1 from imports l where l.zone_id = i.zone_id and l.imported = true and l.date > i.date) as superseded,
+		coalesce(k.suspect, false) as suspect, coalesce(i.source, '') as source
+	from imports i
+	join zones z on z.id = i.zone_id
+	left join import_progress p on p.import_id = i.id
+	left join import_counts c on c.import_id = i.id
+	left join import_checks k on k.import_id = i.id`
+
+// GetImport returns an import of a zone with the state of its stages, ErrNoResource if there is no such import
+func (ds *DataStore) GetImport(ctx context.Context, id int64) (*model.Import, error) {
+	rows, err := ds.db.Query(ctx, importColumns+" where i.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := scanImports(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(imports) == 0 {
+		return nil, ErrNoResource
+	}
+	return imports[0], nil
+}
+
+// GetRunningImports returns the unfinished imports, oldest first, leaving out those a later import of their zone superseded
+func (ds *DataStore) GetRunningImports(ctx context.Context) (*model.RunningImports, error) {
+	rows, err := ds.db.Query(ctx, importColumns+` where i.imported = false
+		and not exists (select 1 from imports l where l.zone_id = i.zone_id and l.imported = true and l.date > i.date)
+		order by i.date, i.id`)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := scanImports(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RunningImports{Imports: imports}, nil
+}
+
+// importRow is a row of importColumns, the import and the progress its status is computed from
+type importRow struct {
+	model.Import
+	Imported       bool            `db:"imported"`
+	Downloaded     pgtype.Bool     `db:"downloaded"`
+	Diffed         pgtype.Bool     `db:"diffed"`
+	DiffDuration   pgtype.Interval `db:"diff_duration"`
+	ImportDuration pgtype.Interval `db:"import_duration"`
+	Superseded     bool            `db:"superseded"`
+}
+
+// scanImports reads the imports selected by importColumns and closes rows
+func scanImports(rows pgx.Rows) ([]*model.Import, error) {
+	defer rows.Close()
+	imports := make([]*model.Import, 0)
+	for rows.Next() {
+		var row importRow
+		err := scanRow(rows, &row)
+		if err != nil {
+			return nil, err
+		}
+		i, imported, superseded := row.Import, row.Imported, row.Superseded
+		// stages done in order, without a progress row nothing was done yet
+		done := []bool{row.Downloaded.Bool, row.Diffed.Bool, imported}
+		durations := make([]*time.Duration, len(importStages))
+		for n, interval := range []pgtype.Interval{row.DiffDuration, row.ImportDuration} {
+			err = interval.AssignTo(&durations[n+1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		i.Status = importRunning
+		if imported {
+			i.Status = importFinished
+		} else if superseded {
+			i.Status = importFailed
+			i.Error = importSuperseded
+		}
+		current := true
+		for n, name := range importStages {
+			stage := &model.ImportStage{Name: name, Status: stagePending}
+			switch {
+			case done[n]:
+				stage.Status = stageDone
+				stage.Duration = durations[n]
+				i.LastCompletedStage = name
+			case imported:
+				// the first import of a zone has nothing to diff with
+				stage.Status = stageSkipped
+			case current:
+				// the first stage not done is the one the import is in or stopped in
+				current = false
+				stage.Status = stageRunning
+				if i.Status == importFailed {
+					stage.Status = stageFailed
+				}
+				i.Stage = name
+			}
+			i.Stages = append(i.Stages, stage)
+		}
+		imports = append(imports, &i)
+	}
+	return imports, rows.Err()
+}
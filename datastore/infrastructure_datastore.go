@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+)
+
+// GetZoneDelegation returns the delegation of the zone in the root zone imports, nil when they never delegated it
+// the addresses are those the imports currently record for the nameservers, glue included
+func (ds *DataStore) GetZoneDelegation(ctx context.Context, zoneID int64) (*model.ZoneDelegation, error) {
+	var delegated bool
+	err := ds.db.QueryRow(ctx, "select exists (select 1 from zones_nameservers where zone_id = $1)", zoneID).Scan(&delegated)
+	if err != nil || !delegated {
+		return nil, err
+	}
+	rows, err := ds.db.Query(ctx, `select ns.domain as name, zns.first_seen,
+			array(select host(a.ip) from a_nameservers an join a on a.id = an.a_id
+				where an.nameserver_id = ns.id and an.last_seen is null order by 1) as ipv4,
+			array(select host(aaaa.ip) from aaaa_nameservers an join aaaa on aaaa.id = an.aaaa_id
+				where an.nameserver_id = ns.id and an.last_seen is null order by 1) as ipv6
+		from zones_nameservers zns join nameservers ns on ns.id = zns.nameserver_id
+		where zns.zone_id = $1 and zns.last_seen is null
+		order by ns.domain`, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	delegation := &model.ZoneDelegation{NameServers: make([]*model.DelegationNameServer, 0, 8)}
+	for rows.Next() {
+		var ns model.DelegationNameServer
+		err = scanRow(rows, &ns)
+		if err != nil {
+			return nil, err
+		}
+		delegation.NameServers = append(delegation.NameServers, &ns)
+	}
+	return delegation, rows.Err()
+}
+
+// GetZoneDelegationChanges returns the limit latest nameservers added to and removed from the delegation of the zone, latest first
+func (ds *DataStore) GetZoneDelegationChanges(ctx context.Context, zoneID int64, limit int) ([]*model.DelegationChange, error) {
+	rows, err := ds.db.Query(ctx, `select date, change, nameserver from (
+			select zns.first_seen as date, 'added' as change, ns.domain as nameserver
+			from zones_nameservers zns join nameservers ns on ns.id = zns.nameserver_id
+			where zns.zone_id = $1 and zns.first_seen is not null
+			union all
+			select zns.last_seen, 'removed', ns.domain
+			from zones_nameservers zns join nameservers ns on ns.id = zns.nameserver_id
+			where zns.zone_id = $1 and zns.last_seen is not null
+		) changes
+		order by date desc, change, nameserver limit $2`, zoneID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make([]*model.DelegationChange, 0, limit)
+	for rows.Next() {
+		var c model.DelegationChange
+		err = scanRow(rows, &c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/metrics"
+	"dnscoffee/reqstats"
+	"dnscoffee/tracing"
+
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryLatency holds the query durations in seconds labeled by the datastore method that ran them
+var queryLatency = metrics.NewHistogramMap("datastore_query_seconds", metrics.DefaultLatencyBuckets)
+
+// callerNames caches the datastore method name for each calling program counter
+var callerNames sync.Map
+
+// callerName returns the name of the datastore method skip frames above the caller
+// ex: "GetDomain" for dnscoffee/datastore.(*DataStore).GetDomain
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	if name, ok := callerNames.Load(pc); ok {
+		return name.(string)
+	}
+	name := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	callerNames.Store(pc, name)
+	return name
+}
+
+// queryInfo describes a single query for instrumentation
+type queryInfo struct {
+	method string
+	sql    string
+	args   []interface{}
+	start  time.Time
+	// only set when tracing is enabled
+	span trace.Span
+	// only set when the request collects debug stats
+	stats *reqstats.Stats
+}
+
+// tracer creates the datastore spans
+var tracer = tracing.Tracer("dnscoffee/datastore")
+
+// startSpan starts a span for the query as a child of the request span in ctx
+func (q *queryInfo) startSpan(ctx context.Context) {
+	if !tracing.Enabled() {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", q.statement()),
+	}
+	if _, ok := preparedStatements[q.sql]; ok {
+		attrs = append(attrs, attribute.String("db.prepared_statement", q.sql))
+	}
+	_, q.span = tracer.Start(ctx, "datastore."+q.method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// statement returns the SQL of the query, that of the prepared statement when the query names one
+func (q *queryInfo) statement() string {
+	if sql, ok := preparedStatements[q.sql]; ok {
+		return sql
+	}
+	return q.sql
+}
+
+// logStatement returns the SQL of the query on one line for the slow query log, after the name of its prepared statement
+func (q *queryInfo) logStatement() string {
+	sql := strings.Join(strings.Fields(q.statement()), " ")
+	if _, ok := preparedStatements[q.sql]; ok {
+		return q.sql + ": " + sql
+	}
+	return sql
+}
+
+// endSpan ends the query's span with the result
+func (q *queryInfo) endSpan(rows int, err error) {
+	if q.span == nil {
+		return
+	}
+	q.span.SetAttributes(attribute.Int("db.rows", rows))
+	if err != nil && err != pgx.ErrNoRows {
+		q.span.RecordError(err)
+		q.span.SetStatus(codes.Error, err.Error())
+	}
+	q.span.End()
+}
+
+// observe records the query duration and logs it if it is slower than the slow query threshold
+// and ends the query's span
+func (d *db) observe(q *queryInfo, rows int, err error) {
+	took := time.Since(q.start)
+	q.endSpan(rows, err)
+	q.stats.Query(took, rows)
+	queryLatency.Observe(q.method, took.Seconds())
+	threshold := time.Duration(atomic.LoadInt64(&d.slowQueryThreshold))
+	if threshold <= 0 || took < threshold {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	logging.Warnf("slow query: %s(%s) rows: %d took: %s status: %s sql: %s", q.method, formatArgs(q.args), rows, took.Round(time.Millisecond), status, q.logStatement())
+}
+
+// formatArgs formats query arguments for logging, shortening long values
+func formatArgs(args []interface{}) string {
+	const maxLen = 64
+	out := make([]string, 0, len(args))
+	for _, a := range args {
+		var s string
+		switch v := a.(type) {
+		case time.Time:
+			s = v.Format("2006-01-02")
+		default:
+			s = fmt.Sprintf("%v", v)
+		}
+		if len(s) > maxLen {
+			s = s[:maxLen] + "..."
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/tracing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// lookupZone queries like a datastore method, its spans and slow query logs are named after it
+func lookupZone(ctx context.Context, d *db) error {
+	var id int
+	return d.QueryRow(ctx, stmtZoneID, "com").Scan(&id)
+}
+
+// captureLog returns what fn logs
+func captureLog(t *testing.T, fn func()) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log")
+	if err := logging.SetOutput(path); err != nil {
+		t.Fatal(err)
+	}
+	fn()
+	if err := logging.SetOutput("stderr"); err != nil {
+		t.Fatal(err)
+	}
+	logged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(logged)
+}
+
+func TestQueryInstrumentation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.UseProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	syntax := &pgconn.PgError{Code: "42601", Message: "syntax error"}
+	tests := []struct {
+		name      string
+		attempt   fakeAttempt
+		threshold time.Duration
+		wantErr   error
+		// status of the span and the logged slow query, empty when none is
+		wantStatus codes.Code
+		wantLog    string
+	}{
+		{
+			name:    "ok",
+			attempt: fakeAttempt{rows: []int{1}}, threshold: time.Hour,
+			wantStatus: codes.Unset,
+		},
+		{
+			name:    "no rows",
+			attempt: fakeAttempt{}, threshold: time.Hour, wantErr: pgx.ErrNoRows,
+			wantStatus: codes.Unset,
+		},
+		{
+			name:    "error",
+			attempt: fakeAttempt{err: syntax}, threshold: time.Hour, wantErr: syntax,
+			wantStatus: codes.Error,
+		},
+		{
+			name:    "slow",
+			attempt: fakeAttempt{rows: []int{1}}, threshold: time.Nanosecond,
+			wantStatus: codes.Unset,
+			wantLog:    "[WARN] slow query: lookupZone(com) rows: 1 took: ",
+		},
+		{
+			name:    "slow error",
+			attempt: fakeAttempt{err: syntax}, threshold: time.Nanosecond, wantErr: syntax,
+			wantStatus: codes.Error,
+			wantLog:    "[WARN] slow query: lookupZone(com) rows: 0 took: ",
+		},
+		{
+			name:    "threshold disabled",
+			attempt: fakeAttempt{rows: []int{1}}, threshold: 0,
+			wantStatus: codes.Unset,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testRetryDB(&fakeConn{attempts: []fakeAttempt{tt.attempt}}, 0, 0)
+			d.slowQueryThreshold = int64(tt.threshold)
+			ended := len(recorder.Ended())
+
+			var err error
+			logged := captureLog(t, func() { err = lookupZone(context.Background(), d) })
+			if err != tt.wantErr {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantLog == "" && logged != "" {
+				t.Errorf("logged %q, want nothing", logged)
+			}
+			if tt.wantLog != "" {
+				if !strings.Contains(logged, tt.wantLog) {
+					t.Errorf("logged %q, want %q", logged, tt.wantLog)
+				}
+				if want := "sql: " + stmtZoneID + ": " + preparedStatements[stmtZoneID]; !strings.Contains(logged, want) {
+					t.Errorf("logged %q, want %q", logged, want)
+				}
+				if tt.wantErr != nil && !strings.Contains(logged, "status: "+tt.wantErr.Error()) {
+					t.Errorf("logged %q, want status %q", logged, tt.wantErr)
+				}
+			}
+
+			spans := recorder.Ended()[ended:]
+			if len(spans) != 1 {
+				t.Fatalf("ended %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != "datastore.lookupZone" {
+				t.Errorf("span name %q, want %q", span.Name(), "datastore.lookupZone")
+			}
+			if span.Status().Code != tt.wantStatus {
+				t.Errorf("span status %v, want %v", span.Status().Code, tt.wantStatus)
+			}
+			if tt.wantStatus == codes.Error && span.Status().Description != tt.wantErr.Error() {
+				t.Errorf("span status description %q, want %q", span.Status().Description, tt.wantErr)
+			}
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			if got := attrs["db.statement"].AsString(); got != preparedStatements[stmtZoneID] {
+				t.Errorf("db.statement %q, want %q", got, preparedStatements[stmtZoneID])
+			}
+			if got := attrs["db.prepared_statement"].AsString(); got != stmtZoneID {
+				t.Errorf("db.prepared_statement %q, want %q", got, stmtZoneID)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// RefreshKeywordCounts counts the new domains containing each keyword in every finished import not counted for it yet
+// only the imports whose date is still in recent_new_domains can be counted, a keyword added later starts from those
+// names are matched without their zone, lower cased, the root zone is left out
+func (ds *DataStore) RefreshKeywordCounts(ctx context.Context, keywords []string) (int64, error) {
+	tag, err := ds.db.Exec(ctx, `insert into keyword_import_counts (keyword, import_id, zone_id, date, domains_count)
+		select k.keyword, i.id, i.zone_id, i.date, count(d.id)
+		from unnest($1::text[]) k(keyword)
+		cross join imports i join zones z on z.id = i.zone_id
+		left join (recent_new_domains r join domains d on d.id = r.domain_id)
+			on r.date = i.date and d.zone_id = i.zone_id
+			and strpos(lower(left(d.domain, length(d.domain) - length(z.zone) - 1)), k.keyword) > 0
+		where i.imported = true and z.zone <> ''
+			and i.date >= (select min(date) from recent_new_domains)
+			and not exists (select 1 from keyword_import_counts c where c.keyword = k.keyword and c.import_id = i.id)
+		group by k.keyword, i.id, i.zone_id, i.date
+		on conflict (keyword, import_id) do nothing`, keywords)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetKeywordCounts returns the new domains containing keyword by period and zone, oldest first
+// granularity is a date_trunc field, periods start on their first day, weeks on Mondays
+// periods whose counted imports matched nothing are returned with a zero count and no zone
+func (ds *DataStore) GetKeywordCounts(ctx context.Context, keyword, granularity string, from, to time.Time) ([]*model.KeywordPeriod, error) {
+	rows, err := ds.db.Query(ctx, `select date_trunc($2, c.date)::date as period, z.zone, sum(c.domains_count)
+		from keyword_import_counts c join zones z on z.id = c.zone_id
+		where c.keyword = $1 and c.date >= $3 and c.date <= $4
+		group by period, z.zone
+		order by period, z.zone`, keyword, granularity, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var periods []*model.KeywordPeriod
+	for rows.Next() {
+		var start time.Time
+		var zone string
+		var count int64
+		err = rows.Scan(&start, &zone, &count)
+		if err != nil {
+			return nil, err
+		}
+		if len(periods) == 0 || !periods[len(periods)-1].Start.Equal(start) {
+			periods = append(periods, &model.KeywordPeriod{Start: model.NewDate(start), Zones: make(map[string]int64)})
+		}
+		period := periods[len(periods)-1]
+		period.Domains += count
+		if count > 0 {
+			period.Zones[zone] = count
+		}
+	}
+	return periods, rows.Err()
+}
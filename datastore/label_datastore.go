@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// GetLabelZones looks up label.zone in every zone with an import but the root, the zones are queried concurrently
+// each zone is a lookup of the (zone_id, domain) index, domains it never had are returned with Exists false
+// a zone whose query failed is left out and named in the returned failed zones, see fanOutZones
+func (ds *DataStore) GetLabelZones(ctx context.Context, label string) ([]*model.LabelZone, []string, error) {
+	zones, err := ds.getImportedZones(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := make([]*model.LabelZone, len(zones))
+	failed, err := ds.fanOutZones(ctx, zones, func(ctx context.Context, i int, z zoneRef) error {
+		lz, err := ds.getLabelZone(ctx, z, label)
+		found[i] = lz
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	labelZones := make([]*model.LabelZone, 0, len(found))
+	for _, lz := range found {
+		if lz != nil {
+			labelZones = append(labelZones, lz)
+		}
+	}
+	return labelZones, failed, nil
+}
+
+// getLabelZone looks up label.zone in one zone
+func (ds *DataStore) getLabelZone(ctx context.Context, z zoneRef, label string) (*model.LabelZone, error) {
+	lz := &model.LabelZone{Zone: z.Zone, Domain: label + "." + z.Zone}
+	var active bool
+	err := ds.db.QueryRow(ctx, `select dns.first_seen, dns.last_seen, coalesce(dns.active, false), coalesce(dns.nameservers, '{}')
+		from domains d
+		left join lateral (select min(dns.first_seen) first_seen, max(dns.last_seen) last_seen, bool_or(dns.last_seen is null) active,
+				array_agg(ns.domain order by ns.domain) filter (where dns.last_seen is null) nameservers
+			from domains_nameservers dns join nameservers ns on ns.id = dns.nameserver_id
+			where dns.domain_id = d.id) dns on true
+		where d.zone_id = $1 and d.domain = $2`, z.ID, lz.Domain).Scan(&lz.FirstSeen, &lz.LastSeen, &active, &lz.NameServers)
+	if err == pgx.ErrNoRows {
+		return lz, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lz.Exists = true
+	if active {
+		lz.LastSeen = model.Date{}
+	}
+	return lz, nil
+}
@@ -0,0 +1,141 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// LabelCounts are the label distributions of the active domains of an import, the label is the name without the zone
+// element n of Lengths, Digits and Hyphens is the number of labels of n characters, digits and hyphens
+type LabelCounts struct {
+	ImportID   int64     `db:"import_id"`
+	ImportDate time.Time `db:"date"`
+	Domains    int64     `db:"domains"`
+	IDNDomains int64     `db:"idn_domains"`
+	Lengths    []int64   `db:"lengths"`
+	Digits     []int64   `db:"digits"`
+	Hyphens    []int64   `db:"hyphens"`
+	ComputedAt time.Time `db:"computed_at"`
+}
+
+// labelCountRow is a row of the label counts of CountLabels, of a grouping set of the measures
+type labelCountRow struct {
+	// the bits of the measures the row is not grouped by, the length being the highest
+	Set   int   `db:"set"`
+	Value int   `db:"value"`
+	Count int64 `db:"count"`
+	IDN   int64 `db:"idn"`
+}
+
+// GetLabelStatsImports returns the finished imports without label statistics, oldest first, the root zone is left out
+// only the imports whose date is still in recent_new_domains are counted, older ones are never
+func (ds *DataStore) GetLabelStatsImports(ctx context.Context) ([]CheckImport, error) {
+	rows, err := ds.db.Query(ctx, `select i.id, i.zone_id, z.zone, i.date
+		from imports i join zones z on z.id = i.zone_id
+		where i.imported = true and z.zone <> ''
+			and i.date >= (select min(date) from recent_new_domains)
+			and not exists (select 1 from label_stats s where s.import_id = i.id)
+		order by i.date, i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var imports []CheckImport
+	for rows.Next() {
+		var ci CheckImport
+		err = scanRow(rows, &ci)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, ci)
+	}
+	return imports, rows.Err()
+}
+
+// CountLabels counts the labels of the domains of the zone delegated on the date of the import by their length,
+// digits and hyphens, and those of internationalized domains, in one scan of the zone
+func (ds *DataStore) CountLabels(ctx context.Context, ci CheckImport) (*LabelCounts, error) {
+	rows, err := ds.db.Query(ctx, `with labels as (
+			select left(d.domain, length(d.domain) - length($3) - 1) as label
+			from domains d
+			where d.zone_id = $1 and exists (select 1 from domains_nameservers dns where dns.domain_id = d.id
+				and dns.first_seen <= $2 and (dns.last_seen >= $2 or dns.last_seen is null))
+		), measures as (
+			select length(label) as length, length(label) - length(translate(label, '0123456789', '')) as digits,
+				length(label) - length(replace(label, '-', '')) as hyphens, upper(label) like 'XN--%' as idn
+			from labels
+		)
+		select grouping(length, digits, hyphens) as set, coalesce(length, digits, hyphens, 0) as value, count(*) as count,
+			count(*) filter (where idn) as idn
+		from measures
+		group by grouping sets ((length), (digits), (hyphens), ())`, ci.ZoneID, ci.Date, ci.Zone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lc := &LabelCounts{ImportID: ci.ID, ImportDate: ci.Date.Time, Lengths: []int64{}, Digits: []int64{}, Hyphens: []int64{}}
+	for rows.Next() {
+		var row labelCountRow
+		err = scanRow(rows, &row)
+		if err != nil {
+			return nil, err
+		}
+		// grouping() sets the bit of every column the row is not grouped by, the first column is the highest bit
+		switch row.Set {
+		case 0b011:
+			lc.Lengths = addLabelCount(lc.Lengths, row.Value, row.Count)
+		case 0b101:
+			lc.Digits = addLabelCount(lc.Digits, row.Value, row.Count)
+		case 0b110:
+			lc.Hyphens = addLabelCount(lc.Hyphens, row.Value, row.Count)
+		case 0b111:
+			lc.Domains, lc.IDNDomains = row.Count, row.IDN
+		}
+	}
+	lc.ComputedAt = time.Now().UTC()
+	return lc, rows.Err()
+}
+
+// addLabelCount sets element value of counts, growing it as needed
+func addLabelCount(counts []int64, value int, count int64) []int64 {
+	for len(counts) <= value {
+		counts = append(counts, 0)
+	}
+	counts[value] += count
+	return counts
+}
+
+// SaveLabelCounts records the label statistics of an import, those counted before are kept
+func (ds *DataStore) SaveLabelCounts(ctx context.Context, ci CheckImport, lc *LabelCounts) error {
+	_, err := ds.db.Exec(ctx, `insert into label_stats (import_id, zone_id, date, domains, idn_domains, lengths, digits, hyphens, computed_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		on conflict (import_id) do nothing`,
+		ci.ID, ci.ZoneID, ci.Date, lc.Domains, lc.IDNDomains, lc.Lengths, lc.Digits, lc.Hyphens, lc.ComputedAt)
+	return err
+}
+
+// GetLabelCounts returns the label statistics of the latest import of the zone at or before asOf, or of its latest
+// import when asOf is zero; ErrNoResource if there are none, and first is then the date of the first import counted, nil if none was
+func (ds *DataStore) GetLabelCounts(ctx context.Context, zoneID int64, asOf time.Time) (lc *LabelCounts, first *time.Time, err error) {
+	var date *time.Time
+	if !asOf.IsZero() {
+		date = &asOf
+	}
+	var counts LabelCounts
+	err = ds.db.scanOne(ctx, &counts, `select import_id, date, domains, idn_domains, lengths, digits, hyphens, computed_at
+		from label_stats where zone_id = $1 and ($2::date is null or date <= $2)
+		order by date desc, import_id desc limit 1`, zoneID, date)
+	if err == pgx.ErrNoRows {
+		err = ds.db.QueryRow(ctx, "select min(date) from label_stats where zone_id = $1", zoneID).Scan(&first)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, first, ErrNoResource
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &counts, nil, nil
+}
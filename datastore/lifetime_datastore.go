@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// LifetimeBuckets are the buckets of the domain lifetime histograms in the order GetDomainLifetimes returns them
+// the lifetime of a domain that left its zone is the days from its first to its last delegation
+var LifetimeBuckets = []string{"under_7d", "under_30d", "under_90d", "under_1y", "1y_or_more", "active"}
+
+// GetDomainLifetimes returns the lifetime histograms of the monthly cohorts from from to to of the domains first seen in them
+// with zoneID 0 the histograms of every zone are returned along with those of all zones together, which have ZoneID 0
+// cohorts without domains are left out
+func (ds *DataStore) GetDomainLifetimes(ctx context.Context, zoneID int64, from, to time.Time) ([]*model.DomainLifetimes, error) {
+	computedAt := time.Now().UTC()
+	rows, err := ds.db.Query(ctx, `with lifetimes as (
+			select zone_id, date_trunc('month', min(first_seen))::date as cohort,
+				bool_or(last_seen is null) as active, max(last_seen) - min(first_seen) as days
+			from domains_nameservers where ($1::bigint = 0 or zone_id = $1)
+			group by domain_id, zone_id
+		)
+		select zone_id, cohort, count(*),
+			count(*) filter (where not active and days < 7),
+			count(*) filter (where not active and days >= 7 and days < 30),
+			count(*) filter (where not active and days >= 30 and days < 90),
+			count(*) filter (where not active and days >= 90 and days < 365),
+			count(*) filter (where not active and days >= 365),
+			count(*) filter (where active)
+		from lifetimes where cohort >= $2 and cohort <= $3
+		group by grouping sets ((zone_id, cohort), (cohort))
+		order by cohort, zone_id nulls first`, zoneID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var histograms []*model.DomainLifetimes
+	for rows.Next() {
+		var zone *int64
+		var cohort time.Time
+		counts := make([]int64, len(LifetimeBuckets))
+		dl := model.DomainLifetimes{ComputedAt: model.NewTimestamp(computedAt)}
+		err = rows.Scan(&zone, &cohort, &dl.Domains, &counts[0], &counts[1], &counts[2], &counts[3], &counts[4], &counts[5])
+		if err != nil {
+			return nil, err
+		}
+		if zone != nil {
+			dl.ZoneID = *zone
+		}
+		dl.Cohort = cohort.Format("2006-01")
+		dl.Buckets = make([]*model.LifetimeBucket, len(LifetimeBuckets))
+		for i, bucket := range LifetimeBuckets {
+			dl.Buckets[i] = &model.LifetimeBucket{Bucket: bucket, Domains: counts[i]}
+		}
+		histograms = append(histograms, &dl)
+	}
+	return histograms, rows.Err()
+}
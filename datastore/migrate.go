@@ -0,0 +1,198 @@
+package datastore
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dnscoffee/logging"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// migrationFiles are the schema migrations named <version>_<name>.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// undefinedTable is the SQLSTATE of a query on a table that does not exist
+const undefinedTable = "42P01"
+
+// migrationLockID is the advisory lock held while migrating so that instances started together do not race
+const migrationLockID = 0x646e73636f6666 // "dnscoff"
+
+// errors returned by CheckSchema
+var (
+	ErrSchemaAhead  = errors.New("the database schema is newer than this build, upgrade dnscoffee")
+	ErrSchemaBehind = errors.New("the database schema is older than this build, run dnscoffee -migrate or enable Database.Auto_Migrate")
+)
+
+// migration is a single embedded schema migration
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations returns the migrations of fsys ordered by version
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, 0, len(entries))
+	for _, e := range entries {
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>.sql", e.Name())
+		}
+		sql, err := fs.ReadFile(fsys, path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(sql)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if i > 0 && m.version == migrations[i-1].version {
+			return nil, fmt.Errorf("migration %d_%s: version %d is also %d_%s", m.version, m.name, m.version, m.version, migrations[i-1].name)
+		}
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d_%s: versions must count up from 1 without gaps", m.version, m.name)
+		}
+	}
+	return migrations, nil
+}
+
+// ExpectedSchemaVersion returns the schema version the queries of this build are written for
+func ExpectedSchemaVersion() (int, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+// SchemaVersion returns the latest migration applied to the database, 0 if none are
+func (ds *DataStore) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, ds.db)
+}
+
+func schemaVersion(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}) (int, error) {
+	var version int
+	err := q.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == undefinedTable {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CheckSchema compares the database schema version with the version this build expects
+// returns ErrSchemaAhead if the database is newer, and if it is older
+// migrates it when autoMigrate is set or returns ErrSchemaBehind
+func (ds *DataStore) CheckSchema(ctx context.Context, autoMigrate bool) error {
+	expected, err := ExpectedSchemaVersion()
+	if err != nil {
+		return err
+	}
+	current, err := ds.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case current > expected:
+		return fmt.Errorf("%w: database version %d, expected %d", ErrSchemaAhead, current, expected)
+	case current < expected && !autoMigrate:
+		return fmt.Errorf("%w: database version %d, expected %d", ErrSchemaBehind, current, expected)
+	case current < expected:
+		_, err = ds.Migrate(ctx)
+		return err
+	}
+	return nil
+}
+
+// Migrate applies the pending migrations, each in its own transaction, and returns how many were applied
+func (ds *DataStore) Migrate(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return 0, err
+	}
+	// migrations are not read queries, so they bypass the breaker and retries of db
+	conn, err := ds.db.pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	return migrate(ctx, conn, migrations)
+}
+
+// migrationConn is the connection the migrations run on, *pgxpool.Conn is one, tests can give a fake
+type migrationConn interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// migrate applies the migrations after the schema version of the database on conn under the advisory lock
+func migrate(ctx context.Context, conn migrationConn, migrations []migration) (int, error) {
+	_, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+		if err != nil {
+			logging.Errorf("releasing migration lock: %s", err)
+		}
+	}()
+
+	// read after taking the lock, another instance may have migrated in the meantime
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if current > len(migrations) {
+		return 0, fmt.Errorf("%w: database version %d, expected %d", ErrSchemaAhead, current, len(migrations))
+	}
+	applied := 0
+	for _, m := range migrations[current:] {
+		err = applyMigration(ctx, conn, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+		}
+		logging.Infof("applied migration %d_%s", m.version, m.name)
+		applied++
+	}
+	return applied, nil
+}
+
+// applyMigration runs a migration and records it in schema_migrations in a single transaction
+func applyMigration(ctx context.Context, conn migrationConn, m migration) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// rollback after commit is a no-op
+	defer func() { _ = tx.Rollback(ctx) }()
+	_, err = tx.Exec(ctx, m.sql)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
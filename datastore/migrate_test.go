@@ -0,0 +1,268 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	files := func(names ...string) fstest.MapFS {
+		fsys := fstest.MapFS{}
+		for _, name := range names {
+			fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+		}
+		return fsys
+	}
+	tests := []struct {
+		name         string
+		fsys         fstest.MapFS
+		wantVersions []int
+		wantErr      string
+	}{
+		{name: "ordered by version", fsys: files("0010_j.sql", "0002_b.sql", "1_a.sql", "3_c.sql", "4_d.sql", "5_e.sql", "6_f.sql", "7_g.sql", "8_h.sql", "9_i.sql"), wantVersions: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{name: "gap", fsys: files("0001_a.sql", "0003_c.sql"), wantErr: "migration 3_c: versions must count up from 1 without gaps"},
+		{name: "not from 1", fsys: files("0002_b.sql"), wantErr: "migration 2_b: versions must count up from 1 without gaps"},
+		{name: "duplicate", fsys: files("0001_a.sql", "0002_b.sql", "0002_c.sql"), wantErr: "version 2 is also"},
+		{name: "no version", fsys: files("schema.sql"), wantErr: "migration schema.sql: name must be <version>_<name>.sql"},
+		{name: "no migrations directory", fsys: fstest.MapFS{}, wantErr: "file does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.fsys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions := make([]int, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.version)
+				// the sql is read from the file of the migration
+				if !strings.HasSuffix(m.sql, "_"+m.name+".sql") {
+					t.Errorf("migration %d_%s has the sql %q", m.version, m.name, m.sql)
+				}
+			}
+			if !reflect.DeepEqual(versions, tt.wantVersions) {
+				t.Errorf("got versions %v, want %v", versions, tt.wantVersions)
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks the migrations built into the binary
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ExpectedSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || expected != len(migrations) || migrations[len(migrations)-1].version != expected {
+		t.Errorf("expected schema version %d of %d migrations", expected, len(migrations))
+	}
+}
+
+// fakeSchema is a database shared by fakeMigrationConns, its advisory lock is a mutex
+type fakeSchema struct {
+	lock sync.Mutex
+
+	mu sync.Mutex
+	// versions in the order they were committed
+	applied []int
+}
+
+func (s *fakeSchema) version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.applied) == 0 {
+		return 0
+	}
+	return s.applied[len(s.applied)-1]
+}
+
+// fakeMigrationConn runs migrations on a fakeSchema and logs its statements
+type fakeMigrationConn struct {
+	t      *testing.T
+	schema *fakeSchema
+	locked bool
+	log    []string
+}
+
+func (c *fakeMigrationConn) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	switch sql {
+	case "SELECT pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)":
+		if len(args) != 1 || args[0] != migrationLockID {
+			c.t.Errorf("%s with %v, want the migration lock %d", sql, args, migrationLockID)
+		}
+		if strings.Contains(sql, "unlock") {
+			c.log = append(c.log, "unlock")
+			c.locked = false
+			c.schema.lock.Unlock()
+		} else {
+			c.schema.lock.Lock()
+			c.locked = true
+			c.log = append(c.log, "lock")
+		}
+		return nil, nil
+	}
+	c.t.Errorf("unexpected statement %q outside a transaction", sql)
+	return nil, nil
+}
+
+func (c *fakeMigrationConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	c.log = append(c.log, "version")
+	if !c.locked {
+		c.t.Error("schema version read without the migration lock")
+	}
+	return &fakeRows{rows: []int{c.schema.version()}, i: -1}
+}
+
+func (c *fakeMigrationConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	if !c.locked {
+		c.t.Error("migration without the migration lock")
+	}
+	return &fakeMigrationTx{conn: c}, nil
+}
+
+// fakeMigrationTx commits the version it inserted into schema_migrations, a migration whose sql is "fail" fails
+type fakeMigrationTx struct {
+	pgx.Tx
+	conn    *fakeMigrationConn
+	version int
+}
+
+func (tx *fakeMigrationTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if strings.HasPrefix(sql, "INSERT INTO schema_migrations") {
+		tx.version = args[0].(int)
+		return nil, nil
+	}
+	if sql == "fail" {
+		return nil, errors.New("failed")
+	}
+	tx.conn.log = append(tx.conn.log, sql)
+	return nil, nil
+}
+
+func (tx *fakeMigrationTx) Commit(ctx context.Context) error {
+	tx.conn.schema.mu.Lock()
+	defer tx.conn.schema.mu.Unlock()
+	tx.conn.schema.applied = append(tx.conn.schema.applied, tx.version)
+	return nil
+}
+
+func (tx *fakeMigrationTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+// testMigrations returns migrations 1 to n, the sql of each is its name
+func testMigrations(n int) []migration {
+	migrations := make([]migration, n)
+	for i := range migrations {
+		name := string(rune('a' + i))
+		migrations[i] = migration{version: i + 1, name: name, sql: name}
+	}
+	return migrations
+}
+
+func TestMigrate(t *testing.T) {
+	failing := testMigrations(3)
+	failing[2].sql = "fail"
+	tests := []struct {
+		name       string
+		applied    []int
+		migrations []migration
+		wantCount  int
+		wantErr    string
+		wantLog    []string
+		// versions of the database afterwards
+		wantApplied []int
+	}{
+		{
+			name: "empty database", migrations: testMigrations(3),
+			wantCount: 3, wantLog: []string{"lock", "version", "a", "b", "c", "unlock"}, wantApplied: []int{1, 2, 3},
+		},
+		{
+			name: "skips applied", applied: []int{1, 2}, migrations: testMigrations(4),
+			wantCount: 2, wantLog: []string{"lock", "version", "c", "d", "unlock"}, wantApplied: []int{1, 2, 3, 4},
+		},
+		{
+			name: "up to date", applied: []int{1, 2, 3}, migrations: testMigrations(3),
+			wantLog: []string{"lock", "version", "unlock"}, wantApplied: []int{1, 2, 3},
+		},
+		{
+			name: "ahead", applied: []int{1, 2, 3, 4}, migrations: testMigrations(3), wantErr: ErrSchemaAhead.Error(),
+			wantLog: []string{"lock", "version", "unlock"}, wantApplied: []int{1, 2, 3, 4},
+		},
+		{
+			name: "failed migration", applied: []int{1}, migrations: failing,
+			wantCount: 1, wantErr: "migration 3_c: failed", wantLog: []string{"lock", "version", "b", "unlock"}, wantApplied: []int{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := &fakeSchema{applied: tt.applied}
+			conn := &fakeMigrationConn{t: t, schema: schema}
+			count, err := migrate(context.Background(), conn, tt.migrations)
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("applied %d migrations, want %d", count, tt.wantCount)
+			}
+			if !reflect.DeepEqual(conn.log, tt.wantLog) {
+				t.Errorf("ran %v, want %v", conn.log, tt.wantLog)
+			}
+			if !reflect.DeepEqual(schema.applied, tt.wantApplied) {
+				t.Errorf("database has versions %v, want %v", schema.applied, tt.wantApplied)
+			}
+		})
+	}
+}
+
+// TestMigrateLock starts instances migrating the same database together, the lock lets only one apply the migrations
+func TestMigrateLock(t *testing.T) {
+	schema := &fakeSchema{}
+	migrations := testMigrations(5)
+	counts := make([]int, 4)
+	var wg sync.WaitGroup
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			count, err := migrate(context.Background(), &fakeMigrationConn{t: t, schema: schema}, migrations)
+			if err != nil {
+				t.Error(err)
+			}
+			counts[i] = count
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, count := range counts {
+		total += count
+		if count != 0 && count != len(migrations) {
+			t.Errorf("an instance applied %d migrations, want all or none", count)
+		}
+	}
+	if total != len(migrations) {
+		t.Errorf("instances applied %d migrations together, want %d", total, len(migrations))
+	}
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(schema.applied, want) {
+		t.Errorf("database has versions %v, want %v", schema.applied, want)
+	}
+}
@@ -0,0 +1,7 @@
+-- tracks the applied migrations
+-- the tables that existed before migrations were embedded are version 1
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    name text NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+-- lets nameservers be looked up by name suffix, used to classify them by provider
+CREATE INDEX IF NOT EXISTS nameservers_reverse_domain_idx ON nameservers (reverse(domain) text_pattern_ops);
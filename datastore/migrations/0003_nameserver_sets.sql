@@ -0,0 +1,14 @@
+-- nameserver sets by fingerprint, the sha256 of the sorted lower cased names of a domain's active nameservers
+-- refreshed by the nssets job, see RefreshNameServerSets
+CREATE TABLE IF NOT EXISTS nameserver_sets (
+    fingerprint text PRIMARY KEY,
+    nameservers text[] NOT NULL,
+    domains_count bigint NOT NULL
+);
+
+-- the active nameserver set of every domain, looked up by fingerprint and paginated by domain ID
+CREATE TABLE IF NOT EXISTS domains_nameserver_sets (
+    domain_id bigint PRIMARY KEY,
+    fingerprint text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS domains_nameserver_sets_fingerprint_idx ON domains_nameserver_sets (fingerprint, domain_id);
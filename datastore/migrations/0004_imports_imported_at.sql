@@ -0,0 +1,19 @@
+-- records when an import finished, used as the Last-Modified time of the feeds of its date
+-- the importer only sets imported, the trigger stamps the time it flips to true
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS imported_at timestamptz;
+
+-- the real time is unknown for earlier imports, the migration time is later than it so clients revalidate once
+UPDATE imports SET imported_at = now() WHERE imported AND imported_at IS NULL;
+
+CREATE OR REPLACE FUNCTION imports_set_imported_at() RETURNS trigger AS $$
+BEGIN
+    IF NEW.imported AND (TG_OP = 'INSERT' OR NOT OLD.imported) THEN
+        NEW.imported_at := now();
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS imports_imported_at ON imports;
+CREATE TRIGGER imports_imported_at BEFORE INSERT OR UPDATE OF imported ON imports
+    FOR EACH ROW EXECUTE FUNCTION imports_set_imported_at();
@@ -0,0 +1,2 @@
+-- lets the domains of a zone be walked in name order, used by the zone domain listing
+CREATE INDEX IF NOT EXISTS domains_zone_id_domain_idx ON domains (zone_id, domain);
@@ -0,0 +1,16 @@
+-- the requests of API keys, written when Audit.Sink is postgres and read by GET /api/admin/audit
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    key_name text NOT NULL,
+    request_id text NOT NULL DEFAULT '',
+    method text NOT NULL,
+    route text NOT NULL,
+    params jsonb,
+    query jsonb,
+    status integer NOT NULL,
+    rows bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time);
+CREATE INDEX IF NOT EXISTS audit_log_key_name_time_idx ON audit_log (key_name, time);
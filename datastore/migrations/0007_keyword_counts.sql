@@ -0,0 +1,12 @@
+-- the number of new domains of every finished import whose name, without the zone, contains a tracked keyword
+-- filled by the keywords job from recent_new_domains, see RefreshKeywordCounts
+-- imports without a match have a row with a zero count so that they are not matched again
+CREATE TABLE IF NOT EXISTS keyword_import_counts (
+    keyword text NOT NULL,
+    import_id bigint NOT NULL,
+    zone_id bigint NOT NULL,
+    date date NOT NULL,
+    domains_count bigint NOT NULL,
+    PRIMARY KEY (keyword, import_id)
+);
+CREATE INDEX IF NOT EXISTS keyword_import_counts_keyword_date_idx ON keyword_import_counts (keyword, date);
@@ -0,0 +1,2 @@
+-- finds the latest import of a zone at or before a date, used by the as-of zone counts
+CREATE INDEX IF NOT EXISTS import_counts_zone_id_date_idx ON import_counts (zone_id, date);
@@ -0,0 +1,23 @@
+-- the glue anomalies of the latest import of every zone, filled by the glue job, see RefreshGlueInconsistencies
+-- orphan_glue is active glue of the zone for a nameserver no domain or zone delegates to,
+-- missing_glue a nameserver under the zone its domains delegate to without glue for it in the zone
+CREATE TABLE IF NOT EXISTS glue_inconsistencies (
+    zone_id bigint NOT NULL,
+    import_id bigint NOT NULL,
+    kind text NOT NULL,
+    nameserver text NOT NULL,
+    addresses text[] NOT NULL,
+    domains_count bigint NOT NULL,
+    example_domain text NOT NULL,
+    last_delegated date,
+    PRIMARY KEY (zone_id, import_id, kind, nameserver)
+);
+
+-- the import of every zone whose anomalies glue_inconsistencies holds, the rows of older imports are deleted once it is recorded
+CREATE TABLE IF NOT EXISTS glue_inconsistency_imports (
+    zone_id bigint PRIMARY KEY,
+    import_id bigint NOT NULL,
+    orphan_glue bigint NOT NULL,
+    missing_glue bigint NOT NULL,
+    computed_at timestamptz NOT NULL
+);
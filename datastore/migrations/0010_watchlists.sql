@@ -0,0 +1,37 @@
+-- the queries of the watchlists, shared by every watchlist with the same query so that it is evaluated once
+-- kind is prefix, contains or similar, term what the names of the new domains are matched against
+CREATE TABLE IF NOT EXISTS watch_terms (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    term text NOT NULL,
+    UNIQUE (kind, term)
+);
+
+-- the watchlists of the API keys, by key name
+CREATE TABLE IF NOT EXISTS watchlists (
+    id bigserial PRIMARY KEY,
+    owner text NOT NULL,
+    name text NOT NULL,
+    term_id bigint NOT NULL REFERENCES watch_terms (id),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (owner, name)
+);
+
+-- the new domains of the finished imports matched by every term, filled by the watchlists job, see AddWatchMatches
+CREATE TABLE IF NOT EXISTS watch_matches (
+    term_id bigint NOT NULL,
+    domain_id bigint NOT NULL,
+    import_id bigint NOT NULL,
+    date date NOT NULL,
+    matched_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (term_id, domain_id)
+);
+CREATE INDEX IF NOT EXISTS watch_matches_term_id_matched_at_idx ON watch_matches (term_id, matched_at);
+
+-- the imports whose new domains were matched against every term up to terms_through, terms get increasing IDs
+-- so a new term is matched against the imports still in recent_new_domains by the next run
+CREATE TABLE IF NOT EXISTS watch_import_evaluations (
+    import_id bigint PRIMARY KEY,
+    terms_through bigint NOT NULL,
+    evaluated_at timestamptz NOT NULL
+);
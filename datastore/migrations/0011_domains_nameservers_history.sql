@@ -0,0 +1,3 @@
+-- find the delegations to a nameserver starting or ending in a date range, used by the nameserver changes
+CREATE INDEX IF NOT EXISTS domains_nameservers_nameserver_id_first_seen_idx ON domains_nameservers (nameserver_id, first_seen);
+CREATE INDEX IF NOT EXISTS domains_nameservers_nameserver_id_last_seen_idx ON domains_nameservers (nameserver_id, last_seen);
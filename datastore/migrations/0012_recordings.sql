@@ -0,0 +1,21 @@
+-- responses recorded with ?record=1, read by GET /api/admin/recordings/{id} and removed by the recordings job
+CREATE TABLE IF NOT EXISTS recordings (
+    id text PRIMARY KEY,
+    time timestamptz NOT NULL,
+    key_name text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    method text NOT NULL,
+    route text NOT NULL,
+    path text NOT NULL,
+    params jsonb,
+    query jsonb,
+    headers jsonb,
+    status integer NOT NULL,
+    data_version bigint NOT NULL,
+    content_type text NOT NULL DEFAULT '',
+    body_sha256 text NOT NULL,
+    body_bytes bigint NOT NULL,
+    body bytea
+);
+
+CREATE INDEX IF NOT EXISTS recordings_time_idx ON recordings (time);
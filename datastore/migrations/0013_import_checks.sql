@@ -0,0 +1,21 @@
+-- the sanity check of the domains every finished import added and removed, filled by the import_checks job, see SaveImportCheck
+-- the averages are those of the previous unsuspected checks of the zone, null when it had too few to judge the import
+-- a suspect import is an alert until acknowledged, clearing it as a false alarm also resets suspect
+CREATE TABLE IF NOT EXISTS import_checks (
+    import_id bigint PRIMARY KEY,
+    zone_id bigint NOT NULL,
+    date date NOT NULL,
+    added bigint NOT NULL,
+    removed bigint NOT NULL,
+    history integer NOT NULL,
+    added_average double precision,
+    removed_average double precision,
+    suspect boolean NOT NULL,
+    reasons text[] NOT NULL DEFAULT '{}',
+    checked_at timestamptz NOT NULL,
+    acknowledged_at timestamptz,
+    acknowledged_by text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS import_checks_zone_id_date_idx ON import_checks (zone_id, date);
+CREATE INDEX IF NOT EXISTS import_checks_suspect_idx ON import_checks (import_id) WHERE suspect;
@@ -0,0 +1,15 @@
+-- the label distributions of the active domains of every finished import, filled by the label_stats job, see SaveLabelStats
+-- the label is the name without the zone, element n of lengths, digits and hyphens counts the labels with n of them from 0
+CREATE TABLE IF NOT EXISTS label_stats (
+    import_id bigint PRIMARY KEY,
+    zone_id bigint NOT NULL,
+    date date NOT NULL,
+    domains bigint NOT NULL,
+    idn_domains bigint NOT NULL,
+    lengths bigint[] NOT NULL,
+    digits bigint[] NOT NULL,
+    hyphens bigint[] NOT NULL,
+    computed_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS label_stats_zone_id_date_idx ON label_stats (zone_id, date);
@@ -0,0 +1,6 @@
+-- where the zone file of an import came from, such as czds, registry_ftp or axfr, set by the importer or by the
+-- import notification, see SetImportSource; null for the imports that predate it
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source text;
+
+-- finds the imports of the sources a feed is filtered on
+CREATE INDEX IF NOT EXISTS imports_source_date_idx ON imports (source, date) WHERE source IS NOT NULL;
@@ -0,0 +1,17 @@
+-- POST requests sent with an Idempotency-Key and their response, replayed to the retries until expires_at and removed
+-- by the idempotency_keys job; a row that is not done is a request still processed, its key held until expires_at
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    client text NOT NULL,
+    key text NOT NULL,
+    request_sha256 text NOT NULL,
+    done boolean NOT NULL DEFAULT false,
+    status integer NOT NULL DEFAULT 0,
+    content_type text NOT NULL DEFAULT '',
+    body_sha256 text NOT NULL DEFAULT '',
+    body_bytes bigint NOT NULL DEFAULT 0,
+    body bytea,
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (client, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
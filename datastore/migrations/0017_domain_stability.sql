@@ -0,0 +1,25 @@
+-- the stability of the nameserver set of every domain scored by the stability job, see SaveStabilityScores; a score
+-- is replaced when the delegations of its domain change, and read by the min_stability filters
+CREATE TABLE IF NOT EXISTS domain_stability (
+    domain_id bigint PRIMARY KEY,
+    stability text NOT NULL,
+    changes integer NOT NULL,
+    reverts integer NOT NULL,
+    last_change date,
+    import_id bigint NOT NULL,
+    computed_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS domain_stability_stability_idx ON domain_stability (stability, domain_id);
+
+-- the imports whose domains the stability job scored, the next import of a zone only scores the domains whose
+-- delegations changed since the last one
+CREATE TABLE IF NOT EXISTS stability_imports (
+    import_id bigint PRIMARY KEY,
+    zone_id bigint NOT NULL,
+    date date NOT NULL,
+    domains bigint NOT NULL,
+    scored_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stability_imports_zone_id_date_idx ON stability_imports (zone_id, date);
@@ -0,0 +1,36 @@
+package datastore
+
+import (
+	"context"
+)
+
+// StreamNames calls fn with every domain then every nameserver name ever seen, for the negative lookup cache
+// neither is ordered, at most one of each is held in memory
+func (ds *DataStore) StreamNames(ctx context.Context, domain, nameServer func(name string) error) error {
+	err := ds.streamColumn(ctx, "SELECT domain FROM domains", domain)
+	if err != nil {
+		return err
+	}
+	return ds.streamColumn(ctx, "SELECT domain FROM nameservers", nameServer)
+}
+
+// streamColumn calls fn with the text column of every row of query
+func (ds *DataStore) streamColumn(ctx context.Context, query string, fn func(value string) error) error {
+	rows, err := ds.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return err
+		}
+		err = fn(value)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,61 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// stmtNameServerChanges are the delegations to nameserver $1 gained or lost from $2 to $3
+// a delegation is lost on the day after it was last seen, the delegations of both are found with the
+// (nameserver_id, first_seen) and (nameserver_id, last_seen) indexes
+const stmtNameServerChanges = `select first_seen as date, 'gained' as change, domain_id from domains_nameservers
+		where nameserver_id = $1 and first_seen between $2 and $3
+	union all
+	select last_seen + 1, 'lost', domain_id from domains_nameservers
+		where nameserver_id = $1 and last_seen between $2::date - 1 and $3::date - 1`
+
+// GetNameServerChanges returns up to limit of the delegations to the nameserver gained or lost from from to to,
+// ordered by date, domain and change after after, from the first unless it is nil
+// every change lists the other nameservers of the domain on the day before a gain or on the day of a loss
+func (ds *DataStore) GetNameServerChanges(ctx context.Context, nameserverID int64, from, to time.Time, after *model.NameServerChange, limit int) ([]*model.NameServerChange, error) {
+	var afterDate interface{}
+	var afterDomain, afterChange string
+	if after != nil {
+		afterDate, afterDomain, afterChange = after.Date.Time, after.Domain, after.Change
+	}
+	rows, err := ds.db.Query(ctx, `with events as (`+stmtNameServerChanges+`)
+		select e.date, e.change, d.domain, array(
+			select ns.domain from domains_nameservers o, nameservers ns
+			where o.domain_id = e.domain_id and ns.id = o.nameserver_id and o.nameserver_id <> $1
+				and o.first_seen <= case when e.change = 'gained' then e.date - 1 else e.date end
+				and (o.last_seen is null or o.last_seen >= case when e.change = 'gained' then e.date - 1 else e.date end)
+			order by ns.domain) as nameservers
+		from events e, domains d
+		where d.id = e.domain_id and ($4::date is null or (e.date, d.domain, e.change) > ($4::date, $5::text, $6::text))
+		order by e.date, d.domain, e.change limit $7`,
+		nameserverID, from, to, afterDate, afterDomain, afterChange, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make([]*model.NameServerChange, 0, limit)
+	for rows.Next() {
+		var c model.NameServerChange
+		err = scanRow(rows, &c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// CountNameServerChanges returns the number of delegations to the nameserver gained and lost from from to to
+func (ds *DataStore) CountNameServerChanges(ctx context.Context, nameserverID int64, from, to time.Time) (int64, int64, error) {
+	var gained, lost int64
+	err := ds.db.QueryRow(ctx, `select count(*) filter (where change = 'gained'), count(*) filter (where change = 'lost')
+		from (`+stmtNameServerChanges+`) events`, nameserverID, from, to).Scan(&gained, &lost)
+	return gained, lost, err
+}
@@ -0,0 +1,83 @@
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// NameServerSetFingerprintLength is the number of hex digits of a nameserver set fingerprint
+const NameServerSetFingerprintLength = 32
+
+// NameServerSetFingerprint returns the fingerprint of a set of nameserver names
+// the names are lower cased, sorted and joined with commas, the fingerprint is the start of the hex sha256 of that
+// RefreshNameServerSets computes the same fingerprint in SQL, both must be changed together
+func NameServerSetFingerprint(names []string) string {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	sort.Strings(lower)
+	sum := sha256.Sum256([]byte(strings.Join(lower, ",")))
+	return hex.EncodeToString(sum[:])[:NameServerSetFingerprintLength]
+}
+
+// RefreshNameServerSets indexes the active nameserver set of every domain by fingerprint
+// rows of domains whose set did not change are not written, the statements run in a single transaction
+// names are sorted with the C collation to match the byte order of NameServerSetFingerprint
+func (ds *DataStore) RefreshNameServerSets(ctx context.Context) error {
+	_, err := ds.db.Exec(ctx, `create temporary table current_nameserver_sets on commit drop as
+			select domain_id, nameservers, left(encode(sha256(convert_to(array_to_string(nameservers, ','), 'UTF8')), 'hex'), 32) as fingerprint
+			from (select dns.domain_id, array_agg(lower(ns.domain) order by lower(ns.domain) collate "C") as nameservers
+				from domains_nameservers dns join nameservers ns on ns.id = dns.nameserver_id
+				where dns.last_seen is null group by dns.domain_id) sets;
+		delete from domains_nameserver_sets s where not exists (select 1 from current_nameserver_sets c where c.domain_id = s.domain_id);
+		insert into domains_nameserver_sets (domain_id, fingerprint) select domain_id, fingerprint from current_nameserver_sets
+			on conflict (domain_id) do update set fingerprint = excluded.fingerprint where domains_nameserver_sets.fingerprint <> excluded.fingerprint;
+		insert into nameserver_sets (fingerprint, nameservers, domains_count) select fingerprint, min(nameservers), count(*) from current_nameserver_sets group by fingerprint
+			on conflict (fingerprint) do update set domains_count = excluded.domains_count;
+		delete from nameserver_sets s where not exists (select 1 from domains_nameserver_sets d where d.fingerprint = s.fingerprint);`)
+	return err
+}
+
+// GetNameServerSet returns the nameservers and domain count of the set with the fingerprint
+// sets are only known once RefreshNameServerSets indexed a domain using them
+func (ds *DataStore) GetNameServerSet(ctx context.Context, fingerprint string) (*model.NameServerSet, error) {
+	nss := model.NameServerSet{Fingerprint: fingerprint}
+	err := ds.db.QueryRow(ctx, "select nameservers, domains_count from nameserver_sets where fingerprint = $1", fingerprint).Scan(&nss.NameServers, &nss.DomainCount)
+	if err == pgx.ErrNoRows {
+		err = ErrNoResource
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &nss, nil
+}
+
+// GetNameServerSetDomains returns up to limit domains using the set with the fingerprint, ordered by ID after afterID,
+// only those scored one of stabilities unless it is empty
+func (ds *DataStore) GetNameServerSetDomains(ctx context.Context, fingerprint string, stabilities []string, afterID int64, limit int) ([]*model.Domain, error) {
+	rows, err := ds.db.Query(ctx, `select d.id, d.domain as name from domains_nameserver_sets s join domains d on d.id = s.domain_id
+		where s.fingerprint = $1 and s.domain_id > $2 and `+stabilityFilter("s.domain_id", "$4")+`
+		order by s.domain_id limit $3`, fingerprint, afterID, limit, arrayParam(stabilities))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	domains := make([]*model.Domain, 0, limit)
+	for rows.Next() {
+		var d model.Domain
+		err = scanRow(rows, &d)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, &d)
+	}
+	return domains, rows.Err()
+}
@@ -0,0 +1,42 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// GetNameServerDomainCounts returns the number of domains delegated to the nameserver on every date from from to to
+// only domains of zoneID are counted unless it is 0, dates without a finished import of that zone, or of any zone, have no count
+// the counts are a running sum of the delegations starting and ending, found in a single scan of the nameserver's history
+func (ds *DataStore) GetNameServerDomainCounts(ctx context.Context, nameserverID, zoneID int64, from, to time.Time) ([]*model.NameServerCount, error) {
+	rows, err := ds.db.Query(ctx, `with events as (
+			select greatest(first_seen, $2::date) as date, 1 as delta from domains_nameservers
+			where nameserver_id = $1 and ($4::bigint = 0 or zone_id = $4) and first_seen <= $3 and (last_seen >= $2 or last_seen is null)
+			union all
+			select last_seen + 1, -1 from domains_nameservers
+			where nameserver_id = $1 and ($4::bigint = 0 or zone_id = $4) and last_seen >= $2 and last_seen < $3
+		),
+		daily as (select date, sum(delta) as delta from events group by date),
+		days as (select d::date as date from generate_series($2::date, $3::date, interval '1 day') d),
+		series as (select days.date, sum(coalesce(daily.delta, 0)) over (order by days.date) as domains from days left join daily on daily.date = days.date)
+		select series.date, case when exists (select 1 from imports where imports.date = series.date and imports.imported = true and ($4::bigint = 0 or imports.zone_id = $4))
+			then series.domains::bigint end
+		from series order by series.date`,
+		nameserverID, from, to, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make([]*model.NameServerCount, 0, int(to.Sub(from).Hours()/24)+1)
+	for rows.Next() {
+		var c model.NameServerCount
+		err = rows.Scan(&c.Date, &c.Domains)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &c)
+	}
+	return counts, rows.Err()
+}
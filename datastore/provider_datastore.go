@@ -0,0 +1,100 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+	"dnscoffee/provider"
+)
+
+// reverse returns the bytes of the ASCII name s in reverse order
+func reverse(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		b[len(s)-1-i] = s[i]
+	}
+	return string(b)
+}
+
+// GetProviderDomainCounts returns the number of active domains using a nameserver of each provider
+// nameservers are matched to the longest pattern suffix, domains with nameservers of several providers count for each
+func (ds *DataStore) GetProviderDomainCounts(ctx context.Context, patterns []provider.Pattern) (*model.ProviderCounts, error) {
+	suffixes := make([]string, 0, len(patterns))
+	reversed := make([]string, 0, len(patterns))
+	providers := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		suffixes = append(suffixes, p.Suffix)
+		reversed = append(reversed, suffixPattern(p.Suffix))
+		providers = append(providers, p.Provider)
+	}
+
+	var counts model.ProviderCounts
+	counts.Providers = make([]*model.ProviderCount, 0, len(patterns))
+	rows, err := ds.db.Query(ctx, `with patterns as (select * from unnest($1::text[], $2::text[], $3::text[]) as p(suffix, reversed, provider)),
+		matches as (
+			select distinct on (ns.id) ns.id, p.provider
+			from patterns p join nameservers ns on ns.domain = p.suffix or reverse(ns.domain) like p.reversed
+			order by ns.id, length(p.suffix) desc
+		)
+		select matches.provider, count(distinct dns.domain_id)
+		from matches, domains_nameservers dns
+		where dns.nameserver_id = matches.id and dns.last_seen is null
+		group by 1 order by 2 desc`, suffixes, reversed, providers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c model.ProviderCount
+		err = rows.Scan(&c.Provider, &c.Domains)
+		if err != nil {
+			return nil, err
+		}
+		counts.Providers = append(counts.Providers, &c)
+	}
+	return &counts, rows.Err()
+}
+
+// suffixPattern returns the LIKE pattern of the reversed names below suffix on a label boundary, found with the reverse(domain) index
+func suffixPattern(suffix string) string {
+	return escapeLike(reverse("."+suffix)) + "%"
+}
+
+// GetNameServerSuffixStats counts the distinct domains with an active delegation to the nameserver suffix or any nameserver below it,
+// and returns the limit nameservers with the most active domains
+// suffix is a name in the upper case form the nameservers are stored in
+func (ds *DataStore) GetNameServerSuffixStats(ctx context.Context, suffix string, limit int) (*model.NameServerSuffixStats, error) {
+	stats := model.NameServerSuffixStats{Suffix: suffix}
+	var active bool
+	err := ds.db.QueryRow(ctx, `with matches as (select id from nameservers where domain = $1 or reverse(domain) like $2)
+		select count(distinct dns.domain_id) filter (where dns.last_seen is null), count(distinct dns.nameserver_id),
+			min(dns.first_seen), max(dns.last_seen), coalesce(bool_or(dns.last_seen is null), false)
+		from matches join domains_nameservers dns on dns.nameserver_id = matches.id`,
+		suffix, suffixPattern(suffix)).Scan(&stats.Domains, &stats.NameServers, &stats.FirstSeen, &stats.LastSeen, &active)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		stats.LastSeen = model.Date{}
+	}
+
+	rows, err := ds.db.Query(ctx, `with matches as (select id, domain from nameservers where domain = $1 or reverse(domain) like $2)
+		select matches.domain, count(*) from matches join domains_nameservers dns on dns.nameserver_id = matches.id
+		where dns.last_seen is null
+		group by matches.domain order by 2 desc, 1 limit $3`,
+		suffix, suffixPattern(suffix), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats.TopNameServers = make([]*model.NameServerSuffixCount, 0, limit)
+	for rows.Next() {
+		var c model.NameServerSuffixCount
+		err = rows.Scan(&c.Name, &c.Domains)
+		if err != nil {
+			return nil, err
+		}
+		stats.TopNameServers = append(stats.TopNameServers, &c)
+	}
+	return &stats, rows.Err()
+}
@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// SaveRecording stores a recorded response, its data version is the latest finished import as it is stored
+func (ds *DataStore) SaveRecording(ctx context.Context, rec *model.Recording) error {
+	// maps of strings always encode
+	params, _ := json.Marshal(rec.Params)
+	query, _ := json.Marshal(rec.Query)
+	headers, _ := json.Marshal(rec.Headers)
+	err := ds.db.QueryRow(ctx, `insert into recordings (id, time, key_name, request_id, method, route, path, params, query, headers,
+			status, data_version, content_type, body_sha256, body_bytes, body)
+		values ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9::jsonb, $10::jsonb, $11,
+			(select coalesce(max(id), 0) from imports where imported = true), $12, $13, $14, $15)
+		returning data_version`,
+		rec.ID, rec.Time.Time, rec.Key, rec.RequestID, rec.Method, rec.Route, rec.Path, string(params), string(query), string(headers),
+		rec.Status, rec.ContentType, rec.BodySHA256, rec.BodyBytes, rec.Body).Scan(&rec.DataVersion)
+	return err
+}
+
+// GetRecording returns the recording of id, nil if there is none
+func (ds *DataStore) GetRecording(ctx context.Context, id string) (*model.Recording, error) {
+	rows, err := ds.db.Query(ctx, `select id, time, key_name, request_id, method, route, path, params, query, headers,
+			status, data_version, content_type, body_sha256, body_bytes, body
+		from recordings where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var rec model.Recording
+	err = scanRow(rows, &rec)
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// DeleteRecordings removes the recordings from before before, and those beyond the keep most recent, and returns how many
+func (ds *DataStore) DeleteRecordings(ctx context.Context, before time.Time, keep int) (int64, error) {
+	tag, err := ds.db.Exec(ctx, `delete from recordings where time < $1
+		or id in (select id from recordings order by time desc, id offset $2)`, before, keep)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,6 +3,7 @@ package datastore
 import (
 	"context"
 	"dnscoffee/model"
+	"net"
 	"strings"
 	"time"
 )
@@ -11,10 +12,10 @@ import (
 func (ds *DataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error) {
 	var err error
 	var aip model.ActiveIPs
-	aip.Date = date
+	aip.Date = model.NewDate(date)
 
-	query := "select distinct a.ip from a_nameservers, a where a_nameservers.a_id = a.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"
-	rows, err := ds.db.Query(ctx, query, date)
+	query := "select distinct a.ip from a_nameservers, a where a_nameservers.a_id = a.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL) limit $2"
+	rows, err := ds.db.Query(ctx, query, date, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
@@ -28,10 +29,13 @@ func (ds *DataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.A
 			return nil, err
 		}
 		aip.IPv4IPs = append(aip.IPv4IPs, ipv4)
+		if ds.tooManyRows(len(aip.IPv4IPs)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
-	query = "select distinct aaaa.ip from aaaa_nameservers, aaaa where aaaa_nameservers.aaaa_id = aaaa.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"
-	rows, err = ds.db.Query(ctx, query, date)
+	query = "select distinct aaaa.ip from aaaa_nameservers, aaaa where aaaa_nameservers.aaaa_id = aaaa.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL) limit $2"
+	rows, err = ds.db.Query(ctx, query, date, ds.rowLimit())
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +49,9 @@ func (ds *DataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.A
 			return nil, err
 		}
 		aip.IPv6IPs = append(aip.IPv6IPs, ipv6)
+		if ds.tooManyRows(len(aip.IPv6IPs)) {
+			return nil, ErrTooManyRows
+		}
 	}
 
 	return &aip, nil
@@ -56,6 +63,9 @@ func (ds *DataStore) GetIPNsZoneCount(ctx context.Context, ip string) (*model.Re
 	var ipZoneCount model.ResearchIPNsZoneCount
 	var err error
 	ipZoneCount.IP = ip
+	if net.ParseIP(ip) == nil {
+		return nil, ErrInvalidIP
+	}
 
 	query := "select zone, count(*) from zones, a_nameservers, a where a.id = a_nameservers.a_id and zones.id = a_nameservers.zone_id and a.ip = $1 group by zone order by count desc"
 	if strings.Contains(ip, ":") {
@@ -0,0 +1,104 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// retry metrics
+var (
+	retryCount   = expvar.NewInt("datastore_retries")
+	retryGiveUps = expvar.NewInt("datastore_retries_exhausted")
+	// transient errors after rows were read, which are not retried
+	rowsInterrupted = expvar.NewInt("datastore_rows_interrupted")
+	retryableCode   = map[string]bool{
+		"40001": true, // serialization_failure
+		"40P01": true, // deadlock_detected
+		"57P01": true, // admin_shutdown
+		"57P02": true, // crash_shutdown
+		"57P03": true, // cannot_connect_now
+		"08000": true, // connection_exception
+		"08003": true, // connection_does_not_exist
+		"08006": true, // connection_failure
+	}
+)
+
+// isRetryable reports if err is a transient error that is safe to retry for a read only query
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return retryableCode[pgErr.Code]
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// IsTransient reports if err is a failure of the database expected to pass, so that a request can be tried again later:
+// the errors retried for read only queries, timeouts, query_canceled by the statement timeout included, and
+// ErrDatabaseUnavailable while the circuit breaker is open; the batches answer the items failing with them with an error
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrDatabaseUnavailable) || errors.Is(err, context.DeadlineExceeded) || IsTimeout(err) {
+		return true
+	}
+	return isRetryable(err)
+}
+
+// IsTimeout reports if err is a query that ran out of time, the request's deadline or the statement timeout
+func IsTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57014" // query_canceled
+	}
+	return errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)
+}
+
+// backoff waits before the given retry attempt (starting at 0)
+// returns false without waiting if the context does not have enough time left for the wait
+func (d *db) backoff(ctx context.Context, attempt int) bool {
+	delay := d.retryBackoff << uint(attempt)
+	if delay <= 0 {
+		return true
+	}
+	// jitter the delay between 50% and 100%
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// shouldRetry reports if a query that failed with err on the given attempt may be tried again
+// and waits for the backoff if so
+func (d *db) shouldRetry(ctx context.Context, attempt int, err error) bool {
+	if !isRetryable(err) {
+		return false
+	}
+	if d.tx != nil {
+		// the failed query aborted the transaction, ReadTx retries it as a whole
+		return false
+	}
+	if attempt >= d.maxRetries || !d.backoff(ctx, attempt) {
+		retryGiveUps.Add(1)
+		return false
+	}
+	retryCount.Add(1)
+	return true
+}
@@ -0,0 +1,250 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeAttempt is the result of one query sent to a fakeConn
+type fakeAttempt struct {
+	// returned by Query and QueryRow's Scan
+	err error
+	// rows of ints, then rowsErr from the rows
+	rows    []int
+	rowsErr error
+}
+
+// fakeConn answers the queries in turn with its attempts, the last one is repeated
+type fakeConn struct {
+	attempts []fakeAttempt
+	calls    int
+}
+
+func (c *fakeConn) next() fakeAttempt {
+	i := c.calls
+	if i >= len(c.attempts) {
+		i = len(c.attempts) - 1
+	}
+	c.calls++
+	return c.attempts[i]
+}
+
+func (c *fakeConn) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	a := c.next()
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &fakeRows{rows: a.rows, err: a.rowsErr, i: -1}, nil
+}
+
+func (c *fakeConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	a := c.next()
+	return &fakeRows{rows: a.rows, err: a.err, i: -1}
+}
+
+func (c *fakeConn) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return nil, c.next().err
+}
+
+// fakeRows returns its ints one by one, then err
+type fakeRows struct {
+	pgx.Rows
+	rows   []int
+	err    error
+	i      int
+	closed bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.i+1 >= len(r.rows) {
+		r.closed = true
+		return false
+	}
+	r.i++
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.i < 0 {
+		if r.err != nil {
+			return r.err
+		}
+		if len(r.rows) == 0 {
+			return pgx.ErrNoRows
+		}
+		r.i = 0
+	}
+	*dest[0].(*int) = r.rows[r.i]
+	return nil
+}
+
+func (r *fakeRows) Err() error {
+	if r.closed {
+		return r.err
+	}
+	return nil
+}
+
+func (r *fakeRows) Close() {
+	r.closed = true
+}
+
+// testRetryDB returns a db querying conn, retrying up to maxRetries times after backoff
+func testRetryDB(conn querier, maxRetries int, backoff time.Duration) *db {
+	return &db{queries: conn, breaker: newCircuitBreaker(0, time.Minute), maxRetries: maxRetries, retryBackoff: backoff}
+}
+
+func TestQueryRetries(t *testing.T) {
+	reset := syscall.ECONNRESET
+	serialization := &pgconn.PgError{Code: "40001"}
+	syntax := &pgconn.PgError{Code: "42601"}
+	tests := []struct {
+		name     string
+		attempts []fakeAttempt
+		// rows read and the error of the rows
+		wantRows []int
+		wantErr  error
+		// queries sent, retries and retries given up
+		wantCalls, wantRetries, wantGiveUps int
+		// the jittered backoff is at least half of retryBackoff doubled for every retry
+		minWait     time.Duration
+		interrupted int
+	}{
+		{
+			name:     "no error",
+			attempts: []fakeAttempt{{rows: []int{1, 2}}},
+			wantRows: []int{1, 2}, wantCalls: 1,
+		},
+		{
+			name:     "query fails twice",
+			attempts: []fakeAttempt{{err: reset}, {err: reset}, {rows: []int{1, 2}}},
+			wantRows: []int{1, 2}, wantCalls: 3, wantRetries: 2, minWait: 15 * time.Millisecond,
+		},
+		{
+			name:     "rows fail before the first row",
+			attempts: []fakeAttempt{{rowsErr: serialization}, {rows: []int{1}}},
+			wantRows: []int{1}, wantCalls: 2, wantRetries: 1, minWait: 5 * time.Millisecond,
+		},
+		{
+			name:     "rows fail after a row",
+			attempts: []fakeAttempt{{rows: []int{1}, rowsErr: reset}, {rows: []int{1, 2}}},
+			wantRows: []int{1}, wantErr: reset, wantCalls: 1, interrupted: 1,
+		},
+		{
+			name:     "not transient",
+			attempts: []fakeAttempt{{err: syntax}, {rows: []int{1}}},
+			wantErr:  syntax, wantCalls: 1,
+		},
+		{
+			name:     "rows not transient",
+			attempts: []fakeAttempt{{rowsErr: syntax}, {rows: []int{1}}},
+			wantErr:  syntax, wantCalls: 1,
+		},
+		{
+			name:     "retries exhausted",
+			attempts: []fakeAttempt{{err: reset}},
+			wantErr:  reset, wantCalls: 3, wantRetries: 2, wantGiveUps: 1, minWait: 15 * time.Millisecond,
+		},
+		{
+			name:     "rows retries exhausted",
+			attempts: []fakeAttempt{{rowsErr: serialization}, {err: reset}},
+			wantErr:  reset, wantCalls: 3, wantRetries: 2, wantGiveUps: 1, minWait: 15 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{attempts: tt.attempts}
+			d := testRetryDB(conn, 2, 10*time.Millisecond)
+			retries, giveUps, interrupted := retryCount.Value(), retryGiveUps.Value(), rowsInterrupted.Value()
+			start := time.Now()
+
+			var got []int
+			rows, err := d.Query(context.Background(), "select")
+			if err == nil {
+				for rows.Next() {
+					var n int
+					if err := rows.Scan(&n); err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, n)
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			took := time.Since(start)
+
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.wantRows) {
+				t.Errorf("got rows %v, want %v", got, tt.wantRows)
+			}
+			if conn.calls != tt.wantCalls {
+				t.Errorf("sent %d queries, want %d", conn.calls, tt.wantCalls)
+			}
+			if n := retryCount.Value() - retries; n != int64(tt.wantRetries) {
+				t.Errorf("counted %d retries, want %d", n, tt.wantRetries)
+			}
+			if n := retryGiveUps.Value() - giveUps; n != int64(tt.wantGiveUps) {
+				t.Errorf("counted %d retries given up, want %d", n, tt.wantGiveUps)
+			}
+			if n := rowsInterrupted.Value() - interrupted; n != int64(tt.interrupted) {
+				t.Errorf("counted %d interrupted rows, want %d", n, tt.interrupted)
+			}
+			if took < tt.minWait {
+				t.Errorf("took %s, want at least %s of backoff", took, tt.minWait)
+			}
+			if err != nil && tt.wantErr == reset && !IsTransient(err) {
+				t.Errorf("%v is not transient", err)
+			}
+		})
+	}
+}
+
+func TestQueryRowRetries(t *testing.T) {
+	conn := &fakeConn{attempts: []fakeAttempt{{err: syscall.ECONNRESET}, {err: &pgconn.PgError{Code: "40P01"}}, {rows: []int{7}}}}
+	d := testRetryDB(conn, 2, 10*time.Millisecond)
+	retries := retryCount.Value()
+	start := time.Now()
+	var n int
+	if err := d.QueryRow(context.Background(), "select").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 || conn.calls != 3 {
+		t.Errorf("got %d after %d queries, want 7 after 3", n, conn.calls)
+	}
+	if got := retryCount.Value() - retries; got != 2 {
+		t.Errorf("counted %d retries, want 2", got)
+	}
+	if took := time.Since(start); took < 15*time.Millisecond {
+		t.Errorf("took %s, want at least 15ms of backoff", took)
+	}
+
+	// no rows is an answer, not a failure
+	conn = &fakeConn{attempts: []fakeAttempt{{}}}
+	d = testRetryDB(conn, 2, 10*time.Millisecond)
+	if err := d.QueryRow(context.Background(), "select").Scan(&n); err != pgx.ErrNoRows || conn.calls != 1 {
+		t.Errorf("got %v after %d queries, want %v after 1", err, conn.calls, pgx.ErrNoRows)
+	}
+}
+
+// TestBackoffDeadline gives up without waiting when the context ends before the backoff
+func TestBackoffDeadline(t *testing.T) {
+	conn := &fakeConn{attempts: []fakeAttempt{{err: syscall.ECONNRESET}}}
+	d := testRetryDB(conn, 5, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.Query(ctx, "select"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("got error %v, want %v", err, syscall.ECONNRESET)
+	}
+	if took := time.Since(start); took > 50*time.Millisecond || conn.calls != 1 {
+		t.Errorf("gave up after %s and %d queries, want at once after 1", took, conn.calls)
+	}
+}
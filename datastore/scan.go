@@ -0,0 +1,124 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// scanPlans caches the scanPlan of every struct type and column list, keyed by scanKey
+var scanPlans sync.Map
+
+// scanKey identifies the columns of a query scanned into a struct type
+type scanKey struct {
+	typ reflect.Type
+	// column names joined by commas
+	columns string
+}
+
+// scanPlan is the index path of the destination field of every column, in column order
+type scanPlan [][]int
+
+// scanRow scans the current row of rows into the struct dest points to, matching the columns to the fields by their db tag
+// fields of embedded structs are matched as well, those of the outer struct first, and fields without a column are left unchanged
+// a column without a field, or two columns of the same field, is an error the first time the query is scanned rather than
+// a value silently scanned into the wrong field, alias the columns of the query after the fields
+func scanRow(rows pgx.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan: %T is not a pointer to a struct", dest)
+	}
+	v = v.Elem()
+	fields := rows.FieldDescriptions()
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = string(f.Name)
+	}
+	key := scanKey{typ: v.Type(), columns: strings.Join(columns, ",")}
+	plan, ok := scanPlans.Load(key)
+	if !ok {
+		p, err := newScanPlan(v.Type(), columns)
+		if err != nil {
+			return err
+		}
+		plan, _ = scanPlans.LoadOrStore(key, p)
+	}
+
+	paths := plan.(scanPlan)
+	targets := make([]interface{}, len(paths))
+	for i, path := range paths {
+		targets[i] = v.FieldByIndex(path).Addr().Interface()
+	}
+	return rows.Scan(targets...)
+}
+
+// scanOne runs a query returning at most one row and scans it into the struct dest points to, as scanRow does
+// returns pgx.ErrNoRows when there is no row, like QueryRow
+func (d *db) scanOne(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
+	rows, err := d.query(ctx, callerName(1), sql, args)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+	err = scanRow(rows, dest)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// newScanPlan returns the fields of t the columns are scanned into
+func newScanPlan(t reflect.Type, columns []string) (scanPlan, error) {
+	byTag := make(map[string][]int)
+	dbFields(t, nil, byTag)
+	plan := make(scanPlan, len(columns))
+	used := make(map[string]bool, len(columns))
+	for i, column := range columns {
+		path, ok := byTag[column]
+		if !ok {
+			return nil, fmt.Errorf("scan: column %q has no db field in %s", column, t)
+		}
+		if used[column] {
+			return nil, fmt.Errorf("scan: column %q is selected twice for %s", column, t)
+		}
+		used[column] = true
+		plan[i] = path
+	}
+	return plan, nil
+}
+
+// dbFields adds the index path of every db tagged field of t under prefix to byTag, a tag already added is kept
+// embedded structs are walked after the fields of t so that those of the outer struct win
+func dbFields(t reflect.Type, prefix []int, byTag map[string][]int) {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		path := append(append([]int{}, prefix...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			f.Index = path
+			embedded = append(embedded, f)
+			continue
+		}
+		tag := f.Tag.Get("db")
+		if tag == "" || tag == "-" || !f.IsExported() {
+			continue
+		}
+		if _, ok := byTag[tag]; !ok {
+			byTag[tag] = path
+		}
+	}
+	for _, f := range embedded {
+		dbFields(f.Type, f.Index, byTag)
+	}
+}
@@ -0,0 +1,94 @@
+package datastore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+type scanInner struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+type scanOuter struct {
+	scanInner
+	// the outer field wins over the one of the embedded struct
+	Name     string `db:"name"`
+	Count    int64  `db:"count"`
+	Ignored  string `db:"-"`
+	Untagged string
+	private  string `db:"private"`
+}
+
+func TestScanPlan(t *testing.T) {
+	plan, err := newScanPlan(reflect.TypeOf(scanOuter{}), []string{"count", "name", "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := scanPlan{{2}, {1}, {0, 0}}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got %v, want %v", plan, want)
+	}
+}
+
+func TestScanPlanMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		want    string
+	}{
+		{"unknown column", []string{"id", "nmae"}, `column "nmae" has no db field`},
+		{"unaliased expression", []string{"id", "coalesce"}, `column "coalesce" has no db field`},
+		{"ignored field", []string{"ignored"}, `column "ignored" has no db field`},
+		{"unexported field", []string{"private"}, `column "private" has no db field`},
+		{"column twice", []string{"id", "name", "id"}, `column "id" is selected twice`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newScanPlan(reflect.TypeOf(scanOuter{}), tt.columns)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanRowDest(t *testing.T) {
+	for _, dest := range []interface{}{scanOuter{}, new(int), nil} {
+		if err := scanRow(nil, dest); err == nil {
+			t.Errorf("%T: no error", dest)
+		}
+	}
+}
+
+// TestScanQueries checks that the columns of the queries scanned by name all have a field
+func TestScanQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		dest    interface{}
+		columns string
+	}{
+		{"zone import", model.ZoneImportResult{}, "zone,domains,records,first_import_date,first_import_id,last_import_date,last_import_id,count,source"},
+		{"nameserver metadata", model.NameServer{}, "first_seen,last_seen,domains_count,domains_archive_count,a_count,a_archive_count,aaaa_count,aaaa_archive_count"},
+		{"zone", model.Zone{}, "name,first_seen,last_seen"},
+		{"feed nameserver", feedNameServer{}, "id,name,version"},
+		{"import date", importDateRow{}, "date,took_diff,took_import,count"},
+		{"import", importRow{}, "id,zone,date,imported,imported_at,downloaded,diffed,diff_duration,import_duration,domains,records,superseded,suspect,source"},
+		{"check import", CheckImport{}, "id,zone_id,zone,date"},
+		{"stability import", StabilityImport{}, "id,zone_id,zone,date,since"},
+		{"delegation", Delegation{}, "nameserver_id,first_seen,last_seen"},
+		{"asn counts", model.ASN{}, "ipv4_count,ipv6_count,nameserver_count,domain_count"},
+		{"asn address", model.IP{}, "ip,version,first_seen,nameserver_count"},
+		{"label count", labelCountRow{}, "set,value,count,idn"},
+		{"label counts", LabelCounts{}, "import_id,date,domains,idn_domains,lengths,digits,hyphens,computed_at"},
+		{"nameserver change", model.NameServerChange{}, "date,change,domain,nameservers"},
+	}
+	for _, tt := range tests {
+		if _, err := newScanPlan(reflect.TypeOf(tt.dest), strings.Split(tt.columns, ",")); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
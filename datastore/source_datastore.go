@@ -0,0 +1,94 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// the latest finished import of a zone at or before the day a domain or nameserver was last observed, or the latest
+// import for what is still active; $1 is the zone and $2 the last_seen date, null when active
+const importSourceQuery = `select coalesce(i.source, '') from imports i
+	where i.zone_id = $1 and i.imported = true and ($2::date is null or i.date <= $2)
+	order by i.date desc, i.id desc limit 1`
+
+// feedSourceFilter keeps the rows r of a feed table whose domain's zone was imported on their date from one of the
+// sources of $3, every row when $3 is null
+const feedSourceFilter = `($3::text[] is null or exists (select 1 from domains d join imports i on i.zone_id = d.zone_id
+	where d.id = r.domain_id and i.date = r.date and i.imported = true and i.source = any($3::text[])))`
+
+// arrayParam is the parameter of the source and stability filters, null when nothing is selected
+func arrayParam(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// SetImportSource records where the zone file of an import came from, unless the importer already did
+// it returns ErrNoResource if there is no such import
+func (ds *DataStore) SetImportSource(ctx context.Context, importID int64, source string) error {
+	tag, err := ds.db.Exec(ctx, "update imports set source = coalesce(source, $2) where id = $1", importID, source)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoResource
+	}
+	return nil
+}
+
+// GetZoneSources returns the source of the latest finished import of every zone by zone name, the zones whose
+// latest import has no source are left out
+func (ds *DataStore) GetZoneSources(ctx context.Context) (map[string]string, error) {
+	rows, err := ds.db.Query(ctx, `select distinct on (i.zone_id) z.zone, i.source
+		from imports i join zones z on z.id = i.zone_id
+		where i.imported = true
+		order by i.zone_id, i.date desc, i.id desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sources := make(map[string]string)
+	for rows.Next() {
+		var zone string
+		var source *string
+		err = rows.Scan(&zone, &source)
+		if err != nil {
+			return nil, err
+		}
+		if source != nil {
+			sources[zone] = *source
+		}
+	}
+	return sources, rows.Err()
+}
+
+// getDomainSource returns the source of the import of the zone that last observed a domain last seen on lastSeen,
+// zero while active; empty when unknown
+func (ds *DataStore) getDomainSource(ctx context.Context, zoneID int64, lastSeen model.Date) (string, error) {
+	var source string
+	err := ds.db.QueryRow(ctx, importSourceQuery, zoneID, lastSeen).Scan(&source)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return source, err
+}
+
+// getNameServerSource returns the source of the import that last observed a delegation to the nameserver,
+// that of the zone of one of its active domains, or of the domain it was last delegated by; empty when unknown
+func (ds *DataStore) getNameServerSource(ctx context.Context, nameserverID int64) (string, error) {
+	var zoneID int64
+	var lastSeen model.Date
+	err := ds.db.QueryRow(ctx, `select d.zone_id, dns.last_seen from domains_nameservers dns join domains d on d.id = dns.domain_id
+		where dns.nameserver_id = $1 order by dns.last_seen desc nulls first limit 1`, nameserverID).Scan(&zoneID, &lastSeen)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ds.getDomainSource(ctx, zoneID, lastSeen)
+}
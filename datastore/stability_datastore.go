@@ -0,0 +1,161 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// Delegation is a row of the delegation history of a domain, LastSeen is null while the nameserver is active
+type Delegation struct {
+	NameServerID int64      `db:"nameserver_id"`
+	FirstSeen    model.Date `db:"first_seen"`
+	LastSeen     model.Date `db:"last_seen"`
+}
+
+// DomainDelegations are the delegation history rows of a domain
+type DomainDelegations struct {
+	DomainID    int64
+	Delegations []Delegation
+}
+
+// StabilityImport is a finished import whose domains the stability job scores, only those whose delegations changed
+// since the import it scored last for the zone, Since, or all of them for the first import it scores
+type StabilityImport struct {
+	CheckImport
+	Since *time.Time `db:"since"`
+}
+
+// StabilityScore is the stability of a domain computed by the stability job, valid until its delegations change
+type StabilityScore struct {
+	DomainID   int64
+	Stability  string
+	Changes    int
+	Reverts    int
+	LastChange model.Date
+}
+
+// stabilityFilter keeps the rows whose domain, domainID, was scored one of the stabilities of the text array param,
+// every row when param is null
+func stabilityFilter(domainID, param string) string {
+	return `(` + param + `::text[] is null or exists (select 1 from domain_stability st where st.domain_id = ` + domainID +
+		` and st.stability = any(` + param + `::text[])))`
+}
+
+// GetDelegations returns the delegation history of a domain, oldest first
+func (ds *DataStore) GetDelegations(ctx context.Context, domainID int64) ([]Delegation, error) {
+	rows, err := ds.db.Query(ctx, `select nameserver_id, first_seen, last_seen from domains_nameservers
+		where domain_id = $1 order by first_seen, nameserver_id`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var delegations []Delegation
+	for rows.Next() {
+		var d Delegation
+		err = scanRow(rows, &d)
+		if err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, d)
+	}
+	return delegations, rows.Err()
+}
+
+// GetStabilityImports returns the finished imports the stability job has to score, oldest first: for every zone the
+// imports after the last one it scored, or its latest import when it scored none, so that the history is never replayed
+func (ds *DataStore) GetStabilityImports(ctx context.Context) ([]StabilityImport, error) {
+	rows, err := ds.db.Query(ctx, `with scored as (
+			select i.zone_id, max(i.date) as date from stability_imports s join imports i on i.id = s.import_id group by i.zone_id
+		), latest as (
+			select distinct on (zone_id) id from imports where imported = true order by zone_id, date desc, id desc
+		)
+		select i.id, i.zone_id, z.zone, i.date, sc.date as since
+		from imports i join zones z on z.id = i.zone_id left join scored sc on sc.zone_id = i.zone_id
+		where i.imported = true and z.zone <> ''
+			and not exists (select 1 from stability_imports s where s.import_id = i.id)
+			and (i.date > sc.date or sc.date is null and i.id in (select id from latest))
+		order by i.date, i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var imports []StabilityImport
+	for rows.Next() {
+		var si StabilityImport
+		err = scanRow(rows, &si)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, si)
+	}
+	return imports, rows.Err()
+}
+
+// GetStabilityDelegations returns the delegation history of up to limit domains of the zone of an import after afterID,
+// by domain ID, those with a delegation added or ended since si.Since unless it is nil
+func (ds *DataStore) GetStabilityDelegations(ctx context.Context, si StabilityImport, afterID int64, limit int) ([]*DomainDelegations, error) {
+	rows, err := ds.db.Query(ctx, `with changed as (
+			select distinct dns.domain_id from domains_nameservers dns join domains d on d.id = dns.domain_id
+			where d.zone_id = $1 and dns.domain_id > $2
+				and ($3::date is null or dns.first_seen > $3 or dns.last_seen >= $3)
+			order by dns.domain_id limit $4
+		)
+		select dns.domain_id, dns.nameserver_id, dns.first_seen, dns.last_seen
+		from domains_nameservers dns join changed c on c.domain_id = dns.domain_id
+		order by dns.domain_id, dns.first_seen, dns.nameserver_id`, si.ZoneID, afterID, si.Since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []*DomainDelegations
+	for rows.Next() {
+		var row struct {
+			DomainID int64 `db:"domain_id"`
+			Delegation
+		}
+		err = scanRow(rows, &row)
+		if err != nil {
+			return nil, err
+		}
+		domainID, d := row.DomainID, row.Delegation
+		if len(domains) == 0 || domains[len(domains)-1].DomainID != domainID {
+			domains = append(domains, &DomainDelegations{DomainID: domainID})
+		}
+		last := domains[len(domains)-1]
+		last.Delegations = append(last.Delegations, d)
+	}
+	return domains, rows.Err()
+}
+
+// SaveStabilityScores records the stability of domains computed for an import, replacing their previous scores
+func (ds *DataStore) SaveStabilityScores(ctx context.Context, importID int64, scores []*StabilityScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(scores))
+	stabilities := make([]string, len(scores))
+	changes := make([]int, len(scores))
+	reverts := make([]int, len(scores))
+	lastChanges := make([]model.Date, len(scores))
+	for i, sc := range scores {
+		ids[i], stabilities[i], changes[i], reverts[i], lastChanges[i] = sc.DomainID, sc.Stability, sc.Changes, sc.Reverts, sc.LastChange
+	}
+	_, err := ds.db.Exec(ctx, `insert into domain_stability (domain_id, stability, changes, reverts, last_change, import_id, computed_at)
+		select u.domain_id, u.stability, u.changes, u.reverts, u.last_change, $6, now()
+		from unnest($1::bigint[], $2::text[], $3::int[], $4::int[], $5::date[]) as u(domain_id, stability, changes, reverts, last_change)
+		on conflict (domain_id) do update set stability = excluded.stability, changes = excluded.changes,
+			reverts = excluded.reverts, last_change = excluded.last_change, import_id = excluded.import_id,
+			computed_at = excluded.computed_at`,
+		ids, stabilities, changes, reverts, lastChanges, importID)
+	return err
+}
+
+// SaveStabilityImport records that the domains of an import were scored, domains of them
+func (ds *DataStore) SaveStabilityImport(ctx context.Context, si StabilityImport, domains int64) error {
+	_, err := ds.db.Exec(ctx, `insert into stability_imports (import_id, zone_id, date, domains, scored_at)
+		values ($1, $2, $3, $4, now())
+		on conflict (import_id) do nothing`, si.ID, si.ZoneID, si.Date, domains)
+	return err
+}
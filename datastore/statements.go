@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// names of the prepared statements for hot-path queries
+// pgx accepts the statement name in place of the SQL when querying
+const (
+	stmtDomainID                     = "domain_id"
+	stmtIP4ID                        = "ip4_id"
+	stmtIP6ID                        = "ip6_id"
+	stmtZoneID                       = "zone_id"
+	stmtNameServerID                 = "nameserver_id"
+	stmtDomainFirstSeen              = "domain_first_seen"
+	stmtDomainLastSeen               = "domain_last_seen"
+	stmtDomainNameServerCount        = "domain_nameserver_count"
+	stmtDomainArchiveNameServerCount = "domain_archive_nameserver_count"
+	stmtDomainNameServers            = "domain_nameservers"
+	stmtDomainArchiveNameServers     = "domain_archive_nameservers"
+	stmtNameServerMetadata           = "nameserver_metadata"
+	stmtNameServerDomains            = "nameserver_domains"
+	stmtNameServerArchiveDomains     = "nameserver_archive_domains"
+)
+
+// preparedStatements are prepared once on every new connection in the pool
+// so that the lookup endpoints do not re-plan them, and their results use the binary format
+var preparedStatements = map[string]string{
+	stmtDomainID:                     "SELECT id, zone_id FROM domains WHERE domain = $1",
+	stmtIP4ID:                        "SELECT id FROM a WHERE ip = $1",
+	stmtIP6ID:                        "SELECT id FROM aaaa WHERE ip = $1",
+	stmtZoneID:                       "select id from zones where zone = $1 limit 1",
+	stmtNameServerID:                 "SELECT id FROM nameservers WHERE domain = $1",
+	stmtDomainFirstSeen:              "select first_seen from domains_nameservers where domain_id = $1 order by first_seen asc nulls first limit 1",
+	stmtDomainLastSeen:               "select last_seen from domains_nameservers where domain_id = $1 order by last_seen desc nulls first limit 1",
+	stmtDomainNameServerCount:        "SELECT count(*) FROM domains_nameservers WHERE domain_id = $1 AND last_seen IS NULL",
+	stmtDomainArchiveNameServerCount: "SELECT count(*) FROM domains_nameservers WHERE domain_id = $1 AND last_seen IS NOT NULL",
+	stmtDomainNameServers:            "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NULL AND dns.domain_id = $1 limit 100",
+	stmtDomainArchiveNameServers:     "SELECT ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.ID AND dns.last_seen IS NOT NULL AND dns.domain_id = $1 ORDER BY last_seen desc limit 100",
+	stmtNameServerMetadata:           "select first_seen, last_seen, domains_count, domains_archive_count, a_count, a_archive_count, aaaa_count, aaaa_archive_count from nameserver_metadata where nameserver_id = $1",
+	stmtNameServerDomains:            "SELECT d.id, d.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE d.ID = dns.domain_id AND dns.last_seen IS NULL AND dns.nameserver_id = $1 limit 100",
+	stmtNameServerArchiveDomains:     "SELECT d.id, d.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE d.ID = dns.domain_id AND dns.last_seen IS NOT NULL AND dns.nameserver_id = $1 limit 100",
+}
+
+// prepareStatements prepares the hot-path queries on a new connection
+// it is used as the pool's AfterConnect hook, so a schema mismatch fails the connection instead of the request
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range preparedStatements {
+		_, err := conn.Prepare(ctx, name, sql)
+		if err != nil {
+			return fmt.Errorf("preparing statement %s: %w", name, err)
+		}
+	}
+	return nil
+}
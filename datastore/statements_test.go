@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// BenchmarkPreparedLookup compares a lookup by the name of its prepared statement with the same SQL, which pgx
+// prepares itself on first use of a connection, and with the SQL sent over the simple protocol, planned every time
+func BenchmarkPreparedLookup(b *testing.B) {
+	ds := testDataStore(b)
+	ctx := context.Background()
+	sql := preparedStatements[stmtDomainID]
+	lookups := []struct {
+		name string
+		sql  string
+		args []interface{}
+	}{
+		{name: "prepared", sql: stmtDomainID, args: []interface{}{"example.com"}},
+		{name: "statement_cache", sql: sql, args: []interface{}{"example.com"}},
+		{name: "unprepared", sql: sql, args: []interface{}{pgx.QuerySimpleProtocol(true), "example.com"}},
+	}
+	for _, lookup := range lookups {
+		b.Run(lookup.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var id, zoneID int64
+				err := ds.db.QueryRow(ctx, lookup.sql, lookup.args...).Scan(&id, &zoneID)
+				if err != nil && err != pgx.ErrNoRows {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestPreparedStatementSQL keeps the SQL of a prepared statement in its span and slow query log rather than its name
+func TestPreparedStatementSQL(t *testing.T) {
+	tests := []struct {
+		sql, wantStatement, wantLog string
+	}{
+		{sql: stmtZoneID, wantStatement: preparedStatements[stmtZoneID], wantLog: "zone_id: select id from zones where zone = $1 limit 1"},
+		{sql: "SELECT id\n\t\tFROM zones", wantStatement: "SELECT id\n\t\tFROM zones", wantLog: "SELECT id FROM zones"},
+	}
+	for _, tt := range tests {
+		q := &queryInfo{sql: tt.sql}
+		if got := q.statement(); got != tt.wantStatement {
+			t.Errorf("statement of %q = %q, want %q", tt.sql, got, tt.wantStatement)
+		}
+		if got := q.logStatement(); got != tt.wantLog {
+			t.Errorf("logged statement of %q = %q, want %q", tt.sql, got, tt.wantLog)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	"context"
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"dnscoffee/metrics"
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	// read transactions by outcome: committed, failed, begin_failed
+	readTxs = expvar.NewMap("datastore_read_txs")
+	// how long read transactions held their connection
+	readTxSeconds = metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+	// read transactions running now
+	readTxsOpen int64
+
+	// the pool whose stats datastore_pool publishes, set by New
+	statsPool atomic.Value
+)
+
+func init() {
+	expvar.Publish("datastore_read_tx_seconds", readTxSeconds)
+	expvar.Publish("datastore_read_txs_open", expvar.Func(func() interface{} { return atomic.LoadInt64(&readTxsOpen) }))
+	expvar.Publish("datastore_pool", expvar.Func(poolStats))
+}
+
+// poolStats returns the usage of the connection pool, so that running out of connections shows before it happens:
+// empty_acquires counts the acquires that had to wait for a connection
+func poolStats() interface{} {
+	pool, ok := statsPool.Load().(*pgxpool.Pool)
+	if !ok {
+		return nil
+	}
+	stat := pool.Stat()
+	return map[string]interface{}{
+		"max_conns":             stat.MaxConns(),
+		"total_conns":           stat.TotalConns(),
+		"acquired_conns":        stat.AcquiredConns(),
+		"idle_conns":            stat.IdleConns(),
+		"constructing_conns":    stat.ConstructingConns(),
+		"acquires":              stat.AcquireCount(),
+		"empty_acquires":        stat.EmptyAcquireCount(),
+		"canceled_acquires":     stat.CanceledAcquireCount(),
+		"acquire_duration_secs": stat.AcquireDuration().Seconds(),
+	}
+}
+
+// ReadQueries are the queries of the DataStore that may run in a ReadTx
+type ReadQueries interface {
+	GetDomain(ctx context.Context, domain string) (*model.Domain, error)
+	GetIP(ctx context.Context, name string) (*model.IP, error)
+	GetNameServer(ctx context.Context, domain string) (*model.NameServer, error)
+	GetZone(ctx context.Context, name string) (*model.Zone, error)
+	GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error)
+}
+
+// ReadTx runs fn in one read only, repeatable read transaction, so that every query of fn sees the database as of its
+// first query and a response built from several queries is not torn by an import committing between them
+// the transaction holds a connection until fn returns: fn must only query, never write the response or wait on
+// anything else; its queries run one at a time on the connection, so it must not query concurrently
+// transient errors retry fn as a whole, since a failed query aborts the transaction
+func (ds *DataStore) ReadTx(ctx context.Context, fn func(tx ReadQueries) error) error {
+	for attempt := 0; ; attempt++ {
+		err := ds.readTx(ctx, fn)
+		if err == nil || !ds.db.shouldRetry(ctx, attempt, err) {
+			return err
+		}
+	}
+}
+
+func (ds *DataStore) readTx(ctx context.Context, fn func(tx ReadQueries) error) error {
+	if !ds.db.breaker.allow() {
+		return ErrDatabaseUnavailable
+	}
+	tx, err := ds.db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	ds.db.breaker.record(err)
+	if err != nil {
+		readTxs.Add("begin_failed", 1)
+		return err
+	}
+	start := time.Now()
+	atomic.AddInt64(&readTxsOpen, 1)
+	defer func() {
+		// a no-op once committed, and ends the transaction should fn panic; with ctx canceled pgx closes the
+		// connection rather than return it to the pool mid-query
+		_ = tx.Rollback(ctx)
+		atomic.AddInt64(&readTxsOpen, -1)
+		readTxSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	txds := *ds
+	txds.db = &db{
+		pool:    ds.db.pool,
+		breaker: ds.db.breaker,
+		tx:      tx,
+
+		slowQueryThreshold: atomic.LoadInt64(&ds.db.slowQueryThreshold),
+	}
+	// the zones of a fan out are queried one at a time on the connection
+	txds.maxParallelZoneQueries = 1
+	err = fn(&txds)
+	if err == nil {
+		// nothing was written, committing only ends the transaction
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		readTxs.Add("failed", 1)
+		return err
+	}
+	readTxs.Add("committed", 1)
+	return nil
+}
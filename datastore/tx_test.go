@@ -0,0 +1,75 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeTx is a read transaction answering its queries with those of a fakeConn
+type fakeTx struct {
+	pgx.Tx
+	conn *fakeConn
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return tx.conn.Query(ctx, sql, args...)
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.conn.QueryRow(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.conn.Exec(ctx, sql, args...)
+}
+
+// TestQueryInReadTx runs the queries of a transaction in it, a transient error is left to ReadTx to retry
+func TestQueryInReadTx(t *testing.T) {
+	reset := syscall.ECONNRESET
+	tests := []struct {
+		name     string
+		attempts []fakeAttempt
+		wantErr  error
+	}{
+		{name: "ok", attempts: []fakeAttempt{{rows: []int{7}}}},
+		{name: "transient", attempts: []fakeAttempt{{err: reset}, {rows: []int{7}}}, wantErr: reset},
+		{name: "no rows", attempts: []fakeAttempt{{}}, wantErr: pgx.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &fakeConn{attempts: []fakeAttempt{{rows: []int{1}}}}
+			d := testRetryDB(pool, 2, time.Millisecond)
+			txConn := &fakeConn{attempts: tt.attempts}
+			d.tx = &fakeTx{conn: txConn}
+			retries := retryCount.Value()
+
+			var n int
+			err := d.QueryRow(context.Background(), "select").Scan(&n)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && n != 7 {
+				t.Errorf("got %d, want the 7 of the transaction", n)
+			}
+			if pool.calls != 0 || txConn.calls != 1 {
+				t.Errorf("sent %d queries to the pool and %d to the transaction, want 0 and 1", pool.calls, txConn.calls)
+			}
+			if got := retryCount.Value() - retries; got != 0 {
+				t.Errorf("counted %d retries in a transaction", got)
+			}
+		})
+	}
+
+	// outside a transaction the same error is retried on the pool
+	pool := &fakeConn{attempts: []fakeAttempt{{err: reset}, {rows: []int{1}}}}
+	var n int
+	if err := testRetryDB(pool, 2, time.Millisecond).QueryRow(context.Background(), "select").Scan(&n); err != nil || pool.calls != 2 {
+		t.Errorf("got %v after %d queries, want 1 after 2", err, pool.calls)
+	}
+}
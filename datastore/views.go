@@ -0,0 +1,38 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// MaterializedView is a view in the database that is periodically refreshed
+type MaterializedView struct {
+	Name string
+	// cron-like refresh schedule, see schedule.Parse
+	Schedule string
+	// refresh without blocking readers, requires a unique index on the view
+	Concurrently bool
+}
+
+// MaterializedViews returns the configured materialized views
+func (ds *DataStore) MaterializedViews() []MaterializedView {
+	return ds.views
+}
+
+// RefreshMaterializedView refreshes the view and returns the number of rows it contains
+func (ds *DataStore) RefreshMaterializedView(ctx context.Context, view MaterializedView) (int64, error) {
+	name := pgx.Identifier{view.Name}.Sanitize()
+	sql := "REFRESH MATERIALIZED VIEW " + name
+	if view.Concurrently {
+		sql = "REFRESH MATERIALIZED VIEW CONCURRENTLY " + name
+	}
+	_, err := ds.db.Exec(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("refreshing %s: %w", view.Name, err)
+	}
+	var rows int64
+	err = ds.db.QueryRow(ctx, "SELECT count(*) FROM "+name).Scan(&rows)
+	return rows, err
+}
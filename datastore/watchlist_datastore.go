@@ -0,0 +1,266 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgconn"
+)
+
+// ErrNameTaken is returned when an owner already has a watchlist with the name
+var ErrNameTaken = errors.New("the name is already taken")
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+// WatchTerm is a watchlist query, shared by the watchlists with the same kind and term
+type WatchTerm struct {
+	ID   int64
+	Kind string
+	Term string
+}
+
+// WatchImport is a finished import whose new domains were not matched against every term yet
+type WatchImport struct {
+	ID     int64
+	ZoneID int64
+	Zone   string
+	Date   time.Time
+	// the terms up to this ID were matched already
+	TermsThrough int64
+}
+
+// watchlistColumns selects a watchlist with its query
+const watchlistColumns = `select w.id, w.name, t.id as term_id, t.kind, t.term, w.created_at
+	from watchlists w join watch_terms t on t.id = w.term_id`
+
+// CountWatchlists returns the number of watchlists of owner by query kind
+func (ds *DataStore) CountWatchlists(ctx context.Context, owner string) (map[string]int, error) {
+	rows, err := ds.db.Query(ctx, `select t.kind, count(*) from watchlists w join watch_terms t on t.id = w.term_id
+		where w.owner = $1 group by t.kind`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var kind string
+		var n int
+		err = rows.Scan(&kind, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[kind] = n
+	}
+	return counts, rows.Err()
+}
+
+// CreateWatchlist adds a watchlist of owner matching kind and term, reusing the term of other watchlists with the same query
+// ErrNameTaken if owner has a watchlist with the name
+func (ds *DataStore) CreateWatchlist(ctx context.Context, owner, name, kind, term string) (*model.Watchlist, error) {
+	var termID int64
+	// the no-op update returns the ID of an existing term
+	err := ds.db.QueryRow(ctx, `insert into watch_terms (kind, term) values ($1, $2)
+		on conflict (kind, term) do update set kind = excluded.kind returning id`, kind, term).Scan(&termID)
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	err = ds.db.QueryRow(ctx, "insert into watchlists (owner, name, term_id) values ($1, $2, $3) returning id", owner, name, termID).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds.GetWatchlist(ctx, owner, id)
+}
+
+// GetWatchlists returns the watchlists of owner in creation order
+func (ds *DataStore) GetWatchlists(ctx context.Context, owner string) ([]*model.Watchlist, error) {
+	rows, err := ds.db.Query(ctx, watchlistColumns+" where w.owner = $1 order by w.id", owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	watchlists := make([]*model.Watchlist, 0)
+	for rows.Next() {
+		var wl model.Watchlist
+		err = scanRow(rows, &wl)
+		if err != nil {
+			return nil, err
+		}
+		watchlists = append(watchlists, &wl)
+	}
+	return watchlists, rows.Err()
+}
+
+// GetWatchlist returns the watchlist id of owner, ErrNoResource if owner has no such watchlist
+func (ds *DataStore) GetWatchlist(ctx context.Context, owner string, id int64) (*model.Watchlist, error) {
+	rows, err := ds.db.Query(ctx, watchlistColumns+" where w.owner = $1 and w.id = $2", owner, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoResource
+	}
+	var wl model.Watchlist
+	err = scanRow(rows, &wl)
+	if err != nil {
+		return nil, err
+	}
+	return &wl, nil
+}
+
+// DeleteWatchlist removes the watchlist id of owner, ErrNoResource if owner has no such watchlist
+// its term and matches are kept for the other watchlists with the same query and those created with it again
+func (ds *DataStore) DeleteWatchlist(ctx context.Context, owner string, id int64) error {
+	tag, err := ds.db.Exec(ctx, "delete from watchlists where owner = $1 and id = $2", owner, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoResource
+	}
+	return nil
+}
+
+// GetWatchMatches returns up to limit domains matched by the term that still have an active delegation,
+// in name order after the name after
+func (ds *DataStore) GetWatchMatches(ctx context.Context, termID int64, after string, limit int) ([]*model.WatchlistMatch, error) {
+	return ds.getWatchMatches(ctx, `select d.domain, z.zone, m.import_id, m.date, m.matched_at
+		from watch_matches m join domains d on d.id = m.domain_id join zones z on z.id = d.zone_id
+		where m.term_id = $1 and d.domain > $2
+			and exists (select 1 from domains_nameservers dns where dns.domain_id = d.id and dns.last_seen is null)
+		order by d.domain limit $3`, termID, after, limit)
+}
+
+// GetNewWatchMatches returns up to limit domains matched by the term since the time since, in the order they were matched,
+// after the match at afterTime of the domain afterDomain
+func (ds *DataStore) GetNewWatchMatches(ctx context.Context, termID int64, since, afterTime time.Time, afterDomain string, limit int) ([]*model.WatchlistMatch, error) {
+	return ds.getWatchMatches(ctx, `select d.domain, z.zone, m.import_id, m.date, m.matched_at
+		from watch_matches m join domains d on d.id = m.domain_id join zones z on z.id = d.zone_id
+		where m.term_id = $1 and m.matched_at >= $2 and (m.matched_at, d.domain) > ($3, $4)
+		order by m.matched_at, d.domain limit $5`, termID, since, afterTime, afterDomain, limit)
+}
+
+func (ds *DataStore) getWatchMatches(ctx context.Context, query string, args ...interface{}) ([]*model.WatchlistMatch, error) {
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := make([]*model.WatchlistMatch, 0)
+	for rows.Next() {
+		var m model.WatchlistMatch
+		err = scanRow(rows, &m)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, &m)
+	}
+	return matches, rows.Err()
+}
+
+// GetWatchTerms returns every term in ID order, those of deleted watchlists too, so that a watchlist created again with
+// the same query has the matches of the imports in between
+func (ds *DataStore) GetWatchTerms(ctx context.Context) ([]WatchTerm, error) {
+	rows, err := ds.db.Query(ctx, "select id, kind, term from watch_terms order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var terms []WatchTerm
+	for rows.Next() {
+		var t WatchTerm
+		err = rows.Scan(&t.ID, &t.Kind, &t.Term)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+// GetPendingWatchImports returns the finished imports not matched against the terms up to termsThrough, oldest first
+// only the imports whose date is still in recent_new_domains can be matched, the root zone is left out
+func (ds *DataStore) GetPendingWatchImports(ctx context.Context, termsThrough int64) ([]WatchImport, error) {
+	rows, err := ds.db.Query(ctx, `select i.id, i.zone_id, z.zone, i.date, coalesce(e.terms_through, 0)
+		from imports i join zones z on z.id = i.zone_id
+		left join watch_import_evaluations e on e.import_id = i.id
+		where i.imported = true and z.zone <> ''
+			and i.date >= (select min(date) from recent_new_domains)
+			and coalesce(e.terms_through, 0) < $1
+		order by i.date, i.id`, termsThrough)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var imports []WatchImport
+	for rows.Next() {
+		var wi WatchImport
+		err = rows.Scan(&wi.ID, &wi.ZoneID, &wi.Zone, &wi.Date, &wi.TermsThrough)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, wi)
+	}
+	return imports, rows.Err()
+}
+
+// StreamNewDomains calls fn with the ID and lower case name of every new domain of an import, unordered
+func (ds *DataStore) StreamNewDomains(ctx context.Context, wi WatchImport, fn func(id int64, domain string) error) error {
+	rows, err := ds.db.Query(ctx, `select d.id, lower(d.domain) from recent_new_domains r join domains d on d.id = r.domain_id
+		where r.date = $1 and d.zone_id = $2`, wi.Date, wi.ZoneID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var domain string
+		err = rows.Scan(&id, &domain)
+		if err != nil {
+			return err
+		}
+		err = fn(id, domain)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// AddWatchMatches records the domains of an import matched by the terms, termIDs[i] matched domainIDs[i],
+// and then that the import was matched against the terms up to termsThrough, so that an interrupted run is redone
+// a domain matched by a term before keeps its first match
+func (ds *DataStore) AddWatchMatches(ctx context.Context, wi WatchImport, termIDs, domainIDs []int64, termsThrough int64) error {
+	if len(termIDs) > 0 {
+		_, err := ds.db.Exec(ctx, `insert into watch_matches (term_id, domain_id, import_id, date)
+			select m.term_id, m.domain_id, $3, $4 from unnest($1::bigint[], $2::bigint[]) m(term_id, domain_id)
+			on conflict (term_id, domain_id) do nothing`, termIDs, domainIDs, wi.ID, wi.Date)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := ds.db.Exec(ctx, `insert into watch_import_evaluations (import_id, terms_through, evaluated_at) values ($1, $2, now())
+		on conflict (import_id) do update set terms_through = excluded.terms_through, evaluated_at = excluded.evaluated_at`,
+		wi.ID, termsThrough)
+	return err
+}
+
+// GetWatchTermEvaluatedAt returns when the new domains of an import were last matched against the term, null before the first
+// the matches of the imports finished since are not known yet
+func (ds *DataStore) GetWatchTermEvaluatedAt(ctx context.Context, termID int64) (model.Timestamp, error) {
+	var evaluated model.Timestamp
+	err := ds.db.QueryRow(ctx, "select max(evaluated_at) from watch_import_evaluations where terms_through >= $1", termID).Scan(&evaluated)
+	return evaluated, err
+}
@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/logging"
+)
+
+// ZoneCountAt is the latest finished import of a zone at or before Date, Found is false when there is none
+// NextImportDate is the date of the first finished import after Date, nil when there is none
+type ZoneCountAt struct {
+	Date           time.Time
+	Found          bool
+	ImportID       int64
+	ImportDate     time.Time
+	Domains        int64
+	NextImportDate *time.Time
+	// the transient error the lookup of Date failed with, the other fields are unset
+	Err error
+}
+
+// GetZoneCountsAt returns the domain count of the zone as of each date, in the order of dates
+// each date is two lookups of the (zone_id, date) index of import_counts, the dates are queried at once up to
+// MaxParallelZoneQueries; a date whose lookup failed with a transient error has it in Err, see IsTransient, other
+// errors and a canceled request fail the whole batch
+func (ds *DataStore) GetZoneCountsAt(ctx context.Context, zoneID int64, dates []time.Time) ([]*ZoneCountAt, error) {
+	counts := make([]*ZoneCountAt, len(dates))
+	errs := ds.fanOut(ctx, len(dates), func(ctx context.Context, i int) (err error) {
+		counts[i], err = ds.GetZoneCountAt(ctx, zoneID, dates[i])
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !IsTransient(err) {
+			return nil, err
+		}
+		logging.Warnf("zone count %d as of %s: %s", zoneID, dates[i].Format("2006-01-02"), err)
+		counts[i] = &ZoneCountAt{Date: dates[i], Err: err}
+	}
+	return counts, nil
+}
+
+// GetZoneCountAt returns the domain count of the zone as of date
+func (ds *DataStore) GetZoneCountAt(ctx context.Context, zoneID int64, date time.Time) (*ZoneCountAt, error) {
+	c := &ZoneCountAt{Date: date}
+	var importID, domains *int64
+	var importDate *time.Time
+	err := ds.db.QueryRow(ctx, `select prev.import_id, prev.date, prev.domains, next.date
+		from (select $2::date as date) d
+		left join lateral (select c.import_id, c.date, c.domains from import_counts c join imports i on i.id = c.import_id
+			where c.zone_id = $1 and c.date <= d.date and i.imported = true
+			order by c.date desc, c.import_id desc limit 1) prev on true
+		left join lateral (select c.date from import_counts c join imports i on i.id = c.import_id
+			where c.zone_id = $1 and c.date > d.date and i.imported = true
+			order by c.date limit 1) next on true`, zoneID, date).Scan(&importID, &importDate, &domains, &c.NextImportDate)
+	if err != nil {
+		return nil, err
+	}
+	if importID != nil {
+		c.Found = true
+		c.ImportID, c.ImportDate, c.Domains = *importID, *importDate, *domains
+	}
+	return c, nil
+}
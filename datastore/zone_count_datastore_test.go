@@ -0,0 +1,101 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func TestIsTransient(t *testing.T) {
+	canceled := &pgconn.PgError{Code: "57014"}
+	tests := []struct {
+		name          string
+		err           error
+		wantTransient bool
+		wantTimeout   bool
+	}{
+		{name: "connection reset", err: syscall.ECONNRESET, wantTransient: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, wantTransient: true},
+		{name: "open breaker", err: fmt.Errorf("query: %w", ErrDatabaseUnavailable), wantTransient: true},
+		{name: "statement timeout", err: canceled, wantTransient: true, wantTimeout: true},
+		{name: "request deadline", err: context.DeadlineExceeded, wantTransient: true, wantTimeout: true},
+		{name: "syntax error", err: &pgconn.PgError{Code: "42601"}},
+		{name: "no rows", err: pgx.ErrNoRows},
+		// a request its client went away from is answered like a timeout
+		{name: "canceled request", err: context.Canceled, wantTransient: true, wantTimeout: true},
+		{name: "no resource", err: ErrNoResource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.wantTransient {
+				t.Errorf("IsTransient(%v) = %t, want %t", tt.err, got, tt.wantTransient)
+			}
+			if got := IsTimeout(tt.err); got != tt.wantTimeout {
+				t.Errorf("IsTimeout(%v) = %t, want %t", tt.err, got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+// TestGetZoneCountsAtErrors keeps the transient error of each date and fails the batch on any other
+func TestGetZoneCountsAtErrors(t *testing.T) {
+	dates := []time.Time{
+		time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC),
+	}
+	reset := syscall.ECONNRESET
+	timeout := &pgconn.PgError{Code: "57014"}
+	syntax := &pgconn.PgError{Code: "42601"}
+	tests := []struct {
+		name string
+		// the error of the lookup of each date
+		errs    []error
+		wantErr error
+	}{
+		{name: "transient errors", errs: []error{reset, timeout, reset}},
+		{name: "one not transient", errs: []error{reset, syntax, reset}, wantErr: syntax},
+		{name: "no rows is not transient", errs: []error{timeout, timeout, pgx.ErrNoRows}, wantErr: pgx.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			for _, err := range tt.errs {
+				conn.attempts = append(conn.attempts, fakeAttempt{err: err})
+			}
+			// one date at a time, so that the dates get the attempts in turn
+			ds := &DataStore{db: testRetryDB(conn, 0, 0), maxParallelZoneQueries: 1}
+			counts, err := ds.GetZoneCountsAt(context.Background(), 1, dates)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if conn.calls != len(dates) {
+				t.Errorf("sent %d queries, want one per date", conn.calls)
+			}
+			if err != nil {
+				return
+			}
+			for i, c := range counts {
+				if c.Date != dates[i] || !errors.Is(c.Err, tt.errs[i]) || c.Found {
+					t.Errorf("count %d: got %+v, want the error %v of %s", i, c, tt.errs[i], dates[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetZoneCountsAtCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ds := &DataStore{db: testRetryDB(&fakeConn{attempts: []fakeAttempt{{err: syscall.ECONNRESET}}}, 0, 0), maxParallelZoneQueries: 1}
+	counts, err := ds.GetZoneCountsAt(ctx, 1, []time.Time{time.Now()})
+	if !errors.Is(err, context.Canceled) || counts != nil {
+		t.Errorf("got %v and %v, want %v", counts, err, context.Canceled)
+	}
+}
@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	"context"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// stmtDomainActive is true for a domain of the listing with an active delegation
+const stmtDomainActive = "exists (select 1 from domains_nameservers dns where dns.domain_id = d.id and dns.last_seen is null)"
+
+// GetZoneDomains returns up to limit domains of the zone ordered by name after after
+// active only returns the domains with or without an active delegation unless it is nil
+// pages are keyed on the name with the (zone_id, domain) index so they stay in place when an import adds or removes domains
+func (ds *DataStore) GetZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int) ([]*model.ZoneDomain, error) {
+	domains := make([]*model.ZoneDomain, 0, limit)
+	err := ds.StreamZoneDomains(ctx, zoneID, active, after, limit, func(d *model.ZoneDomain) error {
+		domains = append(domains, d)
+		return nil
+	})
+	return domains, err
+}
+
+// StreamZoneDomains calls fn with the domains GetZoneDomains returns, one at a time as they are read
+// an error from fn stops the query and is returned
+func (ds *DataStore) StreamZoneDomains(ctx context.Context, zoneID int64, active *bool, after string, limit int, fn func(*model.ZoneDomain) error) error {
+	rows, err := ds.db.Query(ctx, `select d.domain, `+stmtDomainActive+` from domains d
+		where d.zone_id = $1 and d.domain > $2 and ($3::boolean is null or `+stmtDomainActive+` = $3)
+		order by d.domain limit $4`, zoneID, after, active, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d model.ZoneDomain
+		err = rows.Scan(&d.Name, &d.Active)
+		if err != nil {
+			return err
+		}
+		if err = fn(&d); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountZoneDomains returns the number of domains of the zone, with the same active filter as GetZoneDomains
+func (ds *DataStore) CountZoneDomains(ctx context.Context, zoneID int64, active *bool) (int64, error) {
+	var count int64
+	err := ds.db.QueryRow(ctx, `select count(*) from domains d
+		where d.zone_id = $1 and ($2::boolean is null or `+stmtDomainActive+` = $2)`, zoneID, active).Scan(&count)
+	return count, err
+}
+
+// GetZoneDomainEstimate returns the number of domains in the latest import of the zone, 0 if it has none
+// it is read from the import counts and cheap for any zone
+func (ds *DataStore) GetZoneDomainEstimate(ctx context.Context, zoneID int64) (int64, error) {
+	var count int64
+	err := ds.db.QueryRow(ctx, `select import_counts.domains from zone_imports, import_counts
+		where zone_imports.zone_id = $1 and import_counts.import_id = zone_imports.last_import_id`, zoneID).Scan(&count)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
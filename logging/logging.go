@@ -0,0 +1,229 @@
+// Package logging provides leveled logging to a configurable output
+// the package level functions log with the default logger
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is the severity of a log message
+type Level int32
+
+// log levels in increasing severity
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger logs formatted messages at a level
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// logger writes messages at or above its level to its output
+type logger struct {
+	level int32
+	out   *output
+	std   *log.Logger
+}
+
+// std is the default logger
+var std = newLogger()
+
+func newLogger() *logger {
+	out := &output{w: os.Stderr}
+	return &logger{
+		level: int32(Info),
+		out:   out,
+		std:   log.New(out, "", log.LstdFlags),
+	}
+}
+
+func (l *logger) enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&l.level))
+}
+
+func (l *logger) logf(level Level, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	_ = l.std.Output(3, "["+strings.ToUpper(level.String())+"] "+fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) { l.logf(Debug, format, args...) }
+func (l *logger) Infof(format string, args ...interface{})  { l.logf(Info, format, args...) }
+func (l *logger) Warnf(format string, args ...interface{})  { l.logf(Warn, format, args...) }
+func (l *logger) Errorf(format string, args ...interface{}) { l.logf(Error, format, args...) }
+
+// Default returns the default logger
+func Default() Logger {
+	return std
+}
+
+// Debugf logs at the debug level
+func Debugf(format string, args ...interface{}) { std.logf(Debug, format, args...) }
+
+// Infof logs at the info level
+func Infof(format string, args ...interface{}) { std.logf(Info, format, args...) }
+
+// Warnf logs at the warn level
+func Warnf(format string, args ...interface{}) { std.logf(Warn, format, args...) }
+
+// Errorf logs at the error level
+func Errorf(format string, args ...interface{}) { std.logf(Error, format, args...) }
+
+// Fatalf logs at the error level and exits
+func Fatalf(format string, args ...interface{}) {
+	std.logf(Error, format, args...)
+	os.Exit(1)
+}
+
+// Enabled returns true if messages at level are logged
+func Enabled(level Level) bool {
+	return std.enabled(level)
+}
+
+// SetLevel sets the minimum level of logged messages, it is safe to call while logging
+func SetLevel(level Level) {
+	atomic.StoreInt32(&std.level, int32(level))
+}
+
+// SetOutput sets where logs are written: "stderr", "stdout", or a file path that is appended to
+func SetOutput(dest string) error {
+	return std.out.open(dest)
+}
+
+// Reopen reopens the output file so that rotated log files are released
+func Reopen() error {
+	return std.out.reopen()
+}
+
+// Writer returns a writer that logs every line written to it at level
+// for libraries that log to an io.Writer, such as the access log
+// lines are written as is, without the level prefix
+func Writer(level Level) io.Writer {
+	return &levelWriter{std, level}
+}
+
+// PanicLogger returns a logger for handlers.RecoveryHandler that logs recovered panics and their stack at the error level
+func PanicLogger() interface{ Println(...interface{}) } {
+	return panicLogger{}
+}
+
+type panicLogger struct{}
+
+func (panicLogger) Println(v ...interface{}) {
+	std.logf(Error, "panic: %s\n%s", strings.TrimRight(fmt.Sprintln(v...), "\n"), debug.Stack())
+}
+
+// RedirectStdLog sends messages from the standard library log package to the default logger at the info level
+// so that call sites not yet converted keep working
+func RedirectStdLog() {
+	log.SetFlags(0)
+	log.SetOutput(&shimWriter{std, Info})
+}
+
+type levelWriter struct {
+	l     *logger
+	level Level
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if !w.l.enabled(w.level) {
+		return len(p), nil
+	}
+	return w.l.out.Write(p)
+}
+
+// shimWriter logs the messages of a standard library logger
+type shimWriter struct {
+	l     *logger
+	level Level
+}
+
+func (w *shimWriter) Write(p []byte) (int, error) {
+	w.l.logf(w.level, "%s", bytes.TrimRight(p, "\n"))
+	return len(p), nil
+}
+
+// output is a reopenable log destination
+type output struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+	path string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+func (o *output) open(dest string) error {
+	var w io.Writer
+	var f *os.File
+	switch dest {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		var err error
+		f, err = os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	o.mu.Lock()
+	old := o.file
+	o.w, o.file, o.path = w, f, dest
+	o.mu.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+func (o *output) reopen() error {
+	o.mu.Lock()
+	path, isFile := o.path, o.file != nil
+	o.mu.Unlock()
+	if !isFile {
+		return nil
+	}
+	return o.open(path)
+}
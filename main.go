@@ -3,32 +3,104 @@ package main
 import (
 	"context"
 	"dnscoffee/app"
+	"dnscoffee/audit"
+	"dnscoffee/config"
 	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/provider"
+	"dnscoffee/reporter"
 	"dnscoffee/server"
+	"dnscoffee/tracing"
 	"dnscoffee/version"
-	"flag"
-	"log"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-var (
-	listenAddr = flag.String("listen", "127.0.0.1:8080", "ip:port to listen on")
-)
-
 // main
 func main() {
-	flag.Parse()
-	log.Printf("version: %s", version.String())
+	// calls to the standard log package go through the leveled logger
+	logging.RedirectStdLog()
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// runServe serves the API until SIGINT or SIGTERM, waiting for the database to be reachable
+// -check-config, -migrate and -selftest are those of before the commands, they run the command instead of serving
+func runServe(args []string) int {
+	fs := newFlagSet("serve")
+	cf := addConfigFlags(fs)
+	listenAddr := fs.String("listen", "", "comma separated ip:port addresses to listen on, overrides the config")
+	checkOnly := fs.Bool("check-config", false, "validate and print the effective config then exit, same as dnscoffee check-config")
+	migrateOnly := fs.Bool("migrate", false, "apply pending database schema migrations then exit, waiting for the database")
+	selfTest := fs.Bool("selftest", false, "run the self-test then exit, same as dnscoffee selftest")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+	conf, err := config.Load(cf.path, cf.strict)
+	if err != nil {
+		logging.Errorf("%s", err)
+		return exitFailure
+	}
+	if *selfTest {
+		return runSelfTest(context.Background(), conf, conf.Validate())
+	}
+	if *checkOnly {
+		return checkConfig(conf)
+	}
+	errs := conf.Validate()
+	for _, err := range errs {
+		logging.Errorf("config: %s", err)
+	}
+	if len(errs) > 0 {
+		logging.Errorf("%d invalid config settings", len(errs))
+		return exitFailure
+	}
+	err = applyLogConfig(conf.Log)
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+	logging.Infof("version: %s %s built %s with %s", version.Version, version.String(), version.BuildDate, version.GoVersion)
+	ctx := context.Background()
+	listenAddrs := conf.ListenAddrs()
+	if *listenAddr != "" {
+		listenAddrs = strings.Split(*listenAddr, ",")
+	}
+
+	// tracing
+	if conf.Tracing.Endpoint != "" {
+		stopTracing, err := tracing.Setup(ctx, conf.Tracing.Endpoint, conf.Tracing.SampleRatio)
+		if err != nil {
+			logging.Fatalf("tracing: %s", err)
+		}
+		// flush the remaining spans on exit
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			err := stopTracing(flushCtx)
+			if err != nil {
+				logging.Errorf("tracing: %s", err)
+			}
+		}()
+		logging.Infof("tracing to %s", conf.Tracing.Endpoint)
+	}
+
 	// get datstore
 	// if no DB wait for valid connection
 	var ds *datastore.DataStore
-	var err error
-	ctx := context.Background()
 	for {
-		ds, err = datastore.New(ctx)
+		ds, err = datastore.New(ctx, conf.Datastore())
 		if err != nil {
-			log.Println(err)
-			log.Println("waiting for 30s")
+			logging.Errorf("%s", err)
+			logging.Infof("waiting for 30s")
 			time.Sleep(30 * time.Second)
 		} else {
 			break
@@ -36,12 +108,114 @@ func main() {
 	}
 	defer ds.Close()
 
+	// -migrate only migrates, otherwise refuse to run against a schema the queries were not written for
+	if *migrateOnly {
+		return migrateSchema(ctx, ds)
+	}
+	err = ds.CheckSchema(ctx, conf.Database.AutoMigrate)
+	if err != nil {
+		logging.Fatalf("schema: %s", err)
+	}
+
 	// get server and start application
-	coffeeServer, err := server.New(*listenAddr, server.DefaultAPIConfig)
+	apiConfig := conf.Server()
+	coffeeServer, err := server.New(listenAddrs, apiConfig)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("%s", err)
+	}
+	switch {
+	case conf.Errors.SentryDSN != "":
+		sentry, err := reporter.NewSentry(conf.Errors.SentryDSN)
+		if err != nil {
+			logging.Fatalf("%s", err)
+		}
+		coffeeServer.SetErrorReporter(sentry, conf.Errors.QueueSize)
+	case conf.Errors.WebhookURL != "":
+		coffeeServer.SetErrorReporter(&reporter.Webhook{URL: conf.Errors.WebhookURL}, conf.Errors.QueueSize)
+	}
+	switch conf.Audit.Sink {
+	case config.AuditSinkPostgres:
+		coffeeServer.SetAuditSink(ds, conf.Audit.QueueSize)
+	case config.AuditSinkFile:
+		auditFile, err := audit.NewFile(conf.Audit.File)
+		if err != nil {
+			logging.Fatalf("audit: %s", err)
+		}
+		defer auditFile.Close()
+		coffeeServer.SetAuditSink(auditFile, conf.Audit.QueueSize)
+	}
+	if conf.Recordings.Max > 0 {
+		coffeeServer.SetRecordingStore(ds, conf.Recordings.Server())
+	}
+	switch conf.Idempotency.Store {
+	case config.IdempotencyStoreMemory:
+		coffeeServer.SetIdempotencyStore(server.NewIdempotencyMemoryStore(), conf.Idempotency.Server())
+	case config.IdempotencyStorePostgres:
+		coffeeServer.SetIdempotencyStore(ds, conf.Idempotency.Server())
+	}
+	classifier, err := conf.Classifier()
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+	providers := provider.NewTable(classifier)
+	appConfig := conf.App()
+	appConfig.Providers = providers
+	ttls := app.NewCacheTTLs(conf.CacheTTLs())
+	appConfig.CacheTTLs = ttls
+	app.Start(ds, coffeeServer, appConfig)
+
+	// reload runtime settings on SIGHUP & POST /api/admin/reload
+	rl := &reloader{path: cf.path, strict: cf.strict, conf: conf, ds: ds, server: coffeeServer, providers: providers, ttls: ttls}
+	coffeeServer.SetReloader(rl.reload)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			logging.Infof("reloading config")
+			_, err := rl.reload()
+			if err != nil {
+				logging.Errorf("reload failed: %s", err)
+			}
+		}
+	}()
+
+	// reopen the log file on SIGUSR1 after it is rotated
+	go func() {
+		usr1 := make(chan os.Signal, 1)
+		signal.Notify(usr1, syscall.SIGUSR1)
+		for range usr1 {
+			err := logging.Reopen()
+			if err != nil {
+				logging.Errorf("reopening log: %s", err)
+			}
+		}
+	}()
+
+	// shutdown gracefully on SIGINT & SIGTERM
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		logging.Infof("Server shutting down")
+		shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		err := coffeeServer.Shutdown(shutdownCtx)
+		if err != nil {
+			logging.Errorf("%s", err)
+		}
+	}()
+
+	logging.Infof("Server starting on %s", strings.Join(listenAddrs, ", "))
+	if apiConfig.AdminListen != "" {
+		logging.Infof("Admin API on %s", apiConfig.AdminListen)
+	}
+	err = coffeeServer.Start()
+	if err != http.ErrServerClosed {
+		logging.Fatalf("%s", err)
 	}
-	app.Start(ds, coffeeServer)
-	log.Printf("Server starting on %s", *listenAddr)
-	log.Fatal(coffeeServer.Start())
+	// wait for in-flight requests to finish before closing the datastore
+	<-shutdown
+	return exitOK
 }
@@ -0,0 +1,133 @@
+// Package metrics provides expvar variable types not included in the standard library
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"sort"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds used for latency histograms
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observed values into buckets, it implements expvar.Var
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram returns a new histogram with the given bucket upper bounds
+// values larger than the last bucket are only counted in the total
+func NewHistogram(buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{
+		buckets: b,
+		counts:  make([]uint64, len(b)),
+	}
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// histogramJSON is the exported form of a histogram
+// bucket counts are cumulative
+type histogramJSON struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+func (h *Histogram) snapshot() histogramJSON {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := histogramJSON{
+		Count:   h.count,
+		Sum:     h.sum,
+		Buckets: make(map[string]uint64, len(h.buckets)),
+	}
+	var total uint64
+	for i, b := range h.buckets {
+		total += h.counts[i]
+		out.Buckets[formatFloat(b)] = total
+	}
+	return out
+}
+
+// String returns the histogram as JSON
+func (h *Histogram) String() string {
+	b, _ := json.Marshal(h.snapshot())
+	return string(b)
+}
+
+// HistogramMap is a set of histograms sharing the same buckets, keyed by a label
+// it implements expvar.Var
+type HistogramMap struct {
+	buckets []float64
+
+	mu sync.RWMutex
+	m  map[string]*Histogram
+}
+
+// NewHistogramMap creates a new HistogramMap and publishes it with expvar under name
+func NewHistogramMap(name string, buckets []float64) *HistogramMap {
+	hm := &HistogramMap{
+		buckets: buckets,
+		m:       make(map[string]*Histogram),
+	}
+	expvar.Publish(name, hm)
+	return hm
+}
+
+// Get returns the histogram for label, creating it if needed
+func (hm *HistogramMap) Get(label string) *Histogram {
+	hm.mu.RLock()
+	h, ok := hm.m[label]
+	hm.mu.RUnlock()
+	if ok {
+		return h
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if h, ok = hm.m[label]; !ok {
+		h = NewHistogram(hm.buckets)
+		hm.m[label] = h
+	}
+	return h
+}
+
+// Observe adds v to the histogram for label
+func (hm *HistogramMap) Observe(label string, v float64) {
+	hm.Get(label).Observe(v)
+}
+
+// String returns all of the histograms as a JSON object
+func (hm *HistogramMap) String() string {
+	hm.mu.RLock()
+	out := make(map[string]histogramJSON, len(hm.m))
+	for k, h := range hm.m {
+		out[k] = h.snapshot()
+	}
+	hm.mu.RUnlock()
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+func formatFloat(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
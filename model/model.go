@@ -8,17 +8,63 @@ import (
 )
 
 var (
-	domainType            = "domain"
-	zoneType              = "zone"
-	feedType              = "feed"
-	feedNsType            = "feed_ns"
-	nameServerType        = "nameserver"
-	ipType                = "ip"
-	importProgressType    = "import_progress"
-	zoneImportResultType  = "zone_import_result"
-	zoneImportResultsType = "zone_import_results"
-	zoneCountsType        = "zone_counts"
-	zoneAllCountsType     = "zone_all_counts"
+	domainType             = "domain"
+	zoneType               = "zone"
+	feedType               = "feed"
+	feedNsType             = "feed_ns"
+	nameServerType         = "nameserver"
+	ipType                 = "ip"
+	importProgressType     = "import_progress"
+	zoneImportResultType   = "zone_import_result"
+	zoneImportResultsType  = "zone_import_results"
+	zoneCountsType         = "zone_counts"
+	zoneAllCountsType      = "zone_all_counts"
+	viewRefreshType        = "view_refresh"
+	rateLimitType          = "rate_limit"
+	cacheFlushType         = "cache_flush"
+	maintenanceType        = "maintenance"
+	adminStatusType        = "admin_status"
+	configReloadType       = "config_reload"
+	versionType            = "version"
+	bansType               = "bans"
+	jobType                = "job"
+	jobsType               = "jobs"
+	routeType              = "route"
+	routesType             = "routes"
+	providerCountsType     = "provider_counts"
+	zoneDiffType           = "zone_diff"
+	zoneChurnType          = "zone_churn"
+	importNotificationType = "import_notification"
+	nameServerStatsType    = "nameserver_stats"
+	nameServerChangesType  = "nameserver_changes"
+	nameServerSetType      = "nsset"
+	domainLifetimesType    = "domain_lifetimes"
+	zoneDomainsType        = "zone_domains"
+	liveDomainType         = "domain_live"
+	nameServerSuffixType   = "nameserver_suffix_stats"
+	labelZonesType         = "label_zones"
+	auditLogType           = "audit_log"
+	feedDeltaType          = "feed_delta"
+	keywordTimeseriesType  = "keyword_timeseries"
+	zoneAsOfType           = "zone_count_as_of"
+	zoneAsOfSeriesType     = "zone_count_as_of_series"
+	bulkManifestType       = "bulk_manifest"
+	zoneInfraType          = "zone_infrastructure"
+	zoneInfraHistoryType   = "zone_infrastructure_history"
+	cohortSampleType       = "cohort_sample"
+	glueReportType         = "glue_inconsistencies"
+	importType             = "import"
+	runningImportsType     = "running_imports"
+	watchlistType          = "watchlist"
+	watchlistsType         = "watchlists"
+	watchlistMatchesType   = "watchlist_matches"
+	recordingType          = "recording"
+	importCheckType        = "import_check"
+	importAlertsType       = "import_alerts"
+	labelStatsType         = "label_stats"
+	activityType           = "activity"
+	domainStabilityType    = "domain_stability"
+	asnType                = "asn"
 )
 
 // APIData interface forces the use of GenerateMetaData on response data
@@ -37,17 +83,64 @@ type JSONResponse struct {
 	Data interface{} `json:"data,omitempty"`
 }
 
+// EnvelopedResponse is the root object of the responses of requests opting in to the metadata envelope
+type EnvelopedResponse struct {
+	Data interface{} `json:"data"`
+	ResponseEnvelope
+}
+
+// ResponseEnvelope is the meta and links block of an enveloped response, after its data
+type ResponseEnvelope struct {
+	Meta  *ResponseMeta  `json:"meta"`
+	Links *ResponseLinks `json:"links"`
+}
+
+// ResponseMeta describes the data of an enveloped response
+type ResponseMeta struct {
+	GeneratedAt Timestamp `json:"generated_at"`
+	// items on a page of a listing
+	Count      *int   `json:"count,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// the import the data was read from, when it is the data of one import
+	ImportID    int64  `json:"import_id,omitempty"`
+	DataVersion *int64 `json:"data_version,omitempty"`
+	// set when some of the data could not be read, the zones that failed are named in FailedZones
+	Partial     bool     `json:"partial,omitempty"`
+	FailedZones []string `json:"failed_zones,omitempty"`
+	// the items of a batch whose lookup failed, answered with their error, Partial is set along
+	FailedItems int `json:"failed_items,omitempty"`
+	// set when a streamed listing was cut short by an error, its last item
+	Truncated bool `json:"truncated,omitempty"`
+	// the ID of the recording of the response, with ?record=1
+	RecordingID string `json:"recording_id,omitempty"`
+	// when the latest import of the data finished, and the warnings about it such as the data being stale
+	DataAsOf *Timestamp `json:"data_as_of,omitempty"`
+	Warnings []string   `json:"warnings,omitempty"`
+	// set when an import the data derives from failed its sanity check, the suspect imports are listed by ID
+	Suspect        bool    `json:"suspect,omitempty"`
+	SuspectImports []int64 `json:"suspect_imports,omitempty"`
+}
+
+// ResponseLinks are the URLs of an enveloped response and of the next page of a listing
+type ResponseLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+}
+
 // JSONErrors JSON-API root error object
 type JSONErrors struct {
 	Errors []*JSONError `json:"errors"`
 }
 
 // JSONError JSON-API error object
+// Code is stable and can be used by clients to tell errors apart, the other fields are for people
 type JSONError struct {
-	ID     string `json:"-"`
-	Status int    `json:"status"`
-	Title  string `json:"title"`
-	Detail string `json:"detail"`
+	ID               string            `json:"code"`
+	Status           int               `json:"status"`
+	Title            string            `json:"title"`
+	Detail           string            `json:"detail"`
+	DocumentationURL string            `json:"documentation_url,omitempty"`
+	Meta             map[string]string `json:"meta,omitempty"`
 }
 
 // NewJSONError returns a New JSONError
@@ -69,19 +162,20 @@ func (err JSONError) Error() string {
 // ImportProgress Import Progress
 type ImportProgress struct {
 	Metadata
-	Imports int64        `json:"imports_left"`
-	Diffs   int64        `json:"diffs_left"`
-	Days    int          `json:"days_left"`
-	Dates   []ImportDate `json:"dates"` // gets last n days
+	Imports        int64                `json:"imports_left"`
+	Diffs          int64                `json:"diffs_left"`
+	Days           int                  `json:"days_left"`
+	Dates          []ImportDate         `json:"dates"`                     // gets last n days
+	ViewsRefreshed map[string]Timestamp `json:"views_refreshed,omitempty"` // last refresh of each materialized view
 }
 
 // ImportDate import date data
 // TODO go2: time.Duration does not marshal into JSON correctly https://github.com/golang/go/issues/10275
 type ImportDate struct {
-	Date           *time.Time    `json:"date"`
+	Date           Date          `json:"date" db:"date"`
 	DiffDuration   time.Duration `json:"diff_duration"`
 	ImportDuration time.Duration `json:"import_duration"`
-	Count          uint64        `json:"count"`
+	Count          uint64        `json:"count" db:"count"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -91,6 +185,817 @@ func (ip *ImportProgress) GenerateMetaData() {
 	ip.Link = "/imports"
 }
 
+// ViewRefresh is the result of refreshing a materialized view
+type ViewRefresh struct {
+	Metadata
+	View        string        `json:"view"`
+	Rows        int64         `json:"rows"`
+	Duration    time.Duration `json:"duration"`
+	RefreshedAt Timestamp     `json:"refreshed_at"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (vr *ViewRefresh) GenerateMetaData() {
+	vr.Type = &viewRefreshType
+	vr.Link = fmt.Sprintf("/admin/refresh/%s", vr.View)
+}
+
+// RateLimit is the current rate limit bucket of a client
+type RateLimit struct {
+	Metadata
+	IP         string  `json:"ip"`
+	Limit      int     `json:"limit"`
+	Remaining  int     `json:"remaining"`
+	ResetAfter float64 `json:"reset_after"`
+	Limited    bool    `json:"limited"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (rl *RateLimit) GenerateMetaData() {
+	rl.Type = &rateLimitType
+	rl.Link = fmt.Sprintf("/admin/ratelimit/%s", rl.IP)
+}
+
+// CacheFlush lists the caches that were flushed
+type CacheFlush struct {
+	Metadata
+	Caches []string `json:"caches"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (cf *CacheFlush) GenerateMetaData() {
+	cf.Type = &cacheFlushType
+}
+
+// Maintenance is the maintenance mode state
+type Maintenance struct {
+	Metadata
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	ETA     Timestamp `json:"eta"`
+	Since   Timestamp `json:"since"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (m *Maintenance) GenerateMetaData() {
+	m.Type = &maintenanceType
+	m.Link = "/admin/maintenance"
+}
+
+// LoadShedding is the state of the load shedding of the expensive routes
+type LoadShedding struct {
+	Enabled bool `json:"enabled"`
+	// off, ok, or whether the probability is rising, holding or falling after the last window
+	State string `json:"state"`
+	// share of the expensive requests rejected
+	Probability float64 `json:"probability"`
+	// requests of the cheap and expensive routes in the last finished window
+	Classes   map[string]*LoadClassWindow `json:"classes"`
+	UpdatedAt Timestamp                   `json:"updated_at"`
+}
+
+// LoadClassWindow counts the requests of a route class in a load shedding window
+type LoadClassWindow struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	Slow     int64 `json:"slow"`
+	Shed     int64 `json:"shed"`
+}
+
+// AdminStatus is the operational status of the server
+type AdminStatus struct {
+	Metadata
+	Ready        bool              `json:"ready"`
+	Checks       map[string]string `json:"checks"`
+	Maintenance  *Maintenance      `json:"maintenance"`
+	LoadShedding *LoadShedding     `json:"load_shedding"`
+	// the cached lookups and when they were last refreshed
+	ReferenceData []*ReferenceData `json:"reference_data"`
+}
+
+// ReferenceData is the state of a cached lookup refreshed after every import, such as the list of zones
+type ReferenceData struct {
+	Name string `json:"name"`
+	// null until it was first loaded
+	RefreshedAt Timestamp `json:"refreshed_at"`
+	// the error of the last refresh, the previous value is served until a refresh succeeds
+	Error string `json:"error,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (as *AdminStatus) GenerateMetaData() {
+	as.Type = &adminStatusType
+	as.Link = "/admin/status"
+	if as.Maintenance != nil {
+		as.Maintenance.GenerateMetaData()
+	}
+}
+
+// ConfigReload lists the changed settings of a config reload
+type ConfigReload struct {
+	Metadata
+	// settings that were changed
+	Applied []string `json:"applied"`
+	// changed settings that need a restart to take effect
+	Ignored []string `json:"ignored"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (cr *ConfigReload) GenerateMetaData() {
+	cr.Type = &configReloadType
+	cr.Link = "/admin/reload"
+}
+
+// Version is the build information of the running server
+type Version struct {
+	Metadata
+	Version   string    `json:"version"`
+	GitHash   string    `json:"git_hash"`
+	GitBranch string    `json:"git_branch"`
+	GitDate   string    `json:"git_date"`
+	BuildDate string    `json:"build_date"`
+	GoVersion string    `json:"go_version"`
+	StartTime Timestamp `json:"start_time"`
+	// addresses the API is served on
+	Listen []string `json:"listen"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (v *Version) GenerateMetaData() {
+	v.Type = &versionType
+	v.Link = "/version"
+}
+
+// Ban is a client temporarily blocked for abuse
+type Ban struct {
+	IP string `json:"ip"`
+	// rate limited requests that caused the ban
+	Strikes int `json:"strikes"`
+	// requests rejected while banned
+	Rejected int       `json:"rejected"`
+	Since    Timestamp `json:"since"`
+	Until    Timestamp `json:"until"`
+}
+
+// Bans lists the banned clients
+type Bans struct {
+	Metadata
+	Bans []*Ban `json:"bans"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (b *Bans) GenerateMetaData() {
+	b.Type = &bansType
+	b.Link = "/admin/bans"
+}
+
+// Activity is the traffic of the public API in the last minutes, by client and route, to find who is hitting it hardest
+type Activity struct {
+	Metadata
+	// the minutes aggregated, the current one included
+	Minutes int       `json:"minutes"`
+	From    Timestamp `json:"from"`
+	// the filters of the clients and routes listed, empty when not given
+	Client string `json:"client,omitempty"`
+	Route  string `json:"route,omitempty"`
+	Class  string `json:"class,omitempty"`
+	// every request of the window, the filters aside
+	Total *ActivityCounts `json:"total"`
+	// by route class, cheap or expensive
+	Classes map[string]*ActivityCounts `json:"classes"`
+	// the clients and routes with the most requests, at most limit of each
+	Clients []*ActivityClient `json:"clients"`
+	Routes  []*ActivityRoute  `json:"routes"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (a *Activity) GenerateMetaData() {
+	a.Type = &activityType
+	a.Link = "/admin/activity"
+}
+
+// ActivityCounts are the requests of a client, route or class in an activity window
+type ActivityCounts struct {
+	Requests int64 `json:"requests"`
+	// 5xx responses, timeouts included
+	Errors int64 `json:"errors"`
+	// 4xx responses, rate limited requests are also counted apart
+	ClientErrors int64   `json:"client_errors"`
+	RateLimited  int64   `json:"rate_limited"`
+	ErrorRate    float64 `json:"error_rate"`
+	// latency percentiles in milliseconds, the upper bound of their histogram bucket
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+}
+
+// ActivityClient is the activity of an API key, or of an address for anonymous requests
+type ActivityClient struct {
+	// key or ip; other gathers the clients beyond those the window keeps
+	Type   string `json:"type"`
+	Client string `json:"client"`
+	*ActivityCounts
+}
+
+// ActivityRoute is the activity of a route template
+type ActivityRoute struct {
+	Route string `json:"route"`
+	Class string `json:"class"`
+	*ActivityCounts
+}
+
+// Job is the state of a background job
+type Job struct {
+	Metadata
+	Name     string        `json:"name"`
+	Interval time.Duration `json:"interval"`
+	Running  bool          `json:"running"`
+	Runs     int64         `json:"runs"`
+	// unset until the job has run once
+	LastRun      Timestamp     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (j *Job) GenerateMetaData() {
+	j.Type = &jobType
+	j.Link = fmt.Sprintf("/admin/jobs/%s", j.Name)
+}
+
+// RouteStatus is whether a named route is served, Enabled is false while it answers 503 route_disabled
+type RouteStatus struct {
+	Metadata
+	Name    string   `json:"name"`
+	Paths   []string `json:"paths"`
+	Enabled bool     `json:"enabled"`
+	// config or admin, and since when, while disabled
+	DisabledBy string    `json:"disabled_by,omitempty"`
+	Since      Timestamp `json:"since"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (rs *RouteStatus) GenerateMetaData() {
+	rs.Type = &routeType
+	rs.Link = fmt.Sprintf("/admin/routes/%s", rs.Name)
+}
+
+// Routes lists the named routes
+type Routes struct {
+	Metadata
+	Routes []*RouteStatus `json:"routes"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (r *Routes) GenerateMetaData() {
+	r.Type = &routesType
+	r.Link = "/admin/routes"
+	for _, route := range r.Routes {
+		route.GenerateMetaData()
+	}
+}
+
+// Jobs lists the background jobs
+type Jobs struct {
+	Metadata
+	Jobs []*Job `json:"jobs"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (j *Jobs) GenerateMetaData() {
+	j.Type = &jobsType
+	j.Link = "/admin/jobs"
+	for _, job := range j.Jobs {
+		job.GenerateMetaData()
+	}
+}
+
+// AuditRecord is a request of an API key written to the audit log
+type AuditRecord struct {
+	Time      Timestamp `json:"time" db:"time"`
+	Key       string    `json:"key" db:"key_name"`
+	RequestID string    `json:"request_id,omitempty" db:"request_id"`
+	Method    string    `json:"method" db:"method"`
+	Route     string    `json:"route" db:"route"`
+	// the path variables of the route and the query parameters, with sensitive values removed
+	Params map[string]string `json:"params,omitempty" db:"params"`
+	Query  map[string]string `json:"query,omitempty" db:"query"`
+	Status int               `json:"status" db:"status"`
+	// rows read from the database to answer the request
+	Rows int64 `json:"rows" db:"rows"`
+}
+
+// Recording is a response recorded with ?record=1 and the request it answered, to reproduce it after the data changed
+type Recording struct {
+	Metadata
+	ID        string    `json:"id" db:"id"`
+	Time      Timestamp `json:"time" db:"time"`
+	Key       string    `json:"key,omitempty" db:"key_name"`
+	RequestID string    `json:"request_id,omitempty" db:"request_id"`
+	Method    string    `json:"method" db:"method"`
+	Route     string    `json:"route" db:"route"`
+	Path      string    `json:"path" db:"path"`
+	// the path variables of the route, the query parameters and the request headers the response depends on,
+	// with sensitive values removed
+	Params  map[string]string `json:"params,omitempty" db:"params"`
+	Query   map[string]string `json:"query,omitempty" db:"query"`
+	Headers map[string]string `json:"headers,omitempty" db:"headers"`
+	Status  int               `json:"status" db:"status"`
+	// the latest finished import when the response was recorded
+	DataVersion int64  `json:"data_version" db:"data_version"`
+	ContentType string `json:"content_type,omitempty" db:"content_type"`
+	BodySHA256  string `json:"body_sha256" db:"body_sha256"`
+	BodyBytes   int64  `json:"body_bytes" db:"body_bytes"`
+	// the body when it was small enough to keep, base64 encoded
+	Body []byte `json:"body,omitempty" db:"body"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (rec *Recording) GenerateMetaData() {
+	rec.Type = &recordingType
+	rec.Link = fmt.Sprintf("/admin/recordings/%s", rec.ID)
+}
+
+// IdempotencyKey is a POST request sent with an Idempotency-Key header and, once answered, its response,
+// replayed to the retries sending the same key
+type IdempotencyKey struct {
+	// the name of the API key of the request, or the address of anonymous clients, and the header value
+	Client string `json:"client" db:"client"`
+	Key    string `json:"key" db:"key"`
+	// SHA-256 of the method, path, query and body of the request, a retry must send the same
+	RequestSHA256 string `json:"request_sha256" db:"request_sha256"`
+	// false while the first request is processed
+	Done        bool   `json:"done" db:"done"`
+	Status      int    `json:"status" db:"status"`
+	ContentType string `json:"content_type,omitempty" db:"content_type"`
+	BodySHA256  string `json:"body_sha256,omitempty" db:"body_sha256"`
+	BodyBytes   int64  `json:"body_bytes" db:"body_bytes"`
+	// the body when it was small enough to keep
+	Body []byte `json:"body,omitempty" db:"body"`
+	// when the response is forgotten, or for a request still processed when it is given up for dead
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// AuditLog lists the audit records of a key since a time, oldest first
+type AuditLog struct {
+	Metadata
+	// empty for every key
+	Key     string         `json:"key,omitempty"`
+	Since   Timestamp      `json:"since"`
+	Records []*AuditRecord `json:"records"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (al *AuditLog) GenerateMetaData() {
+	al.Type = &auditLogType
+	al.Link = "/admin/audit"
+}
+
+// ProviderCount is the number of active domains using a provider's nameservers
+type ProviderCount struct {
+	Provider string `json:"provider"`
+	Domains  int64  `json:"domains"`
+}
+
+// ProviderCounts lists the active domains of every provider, largest first
+type ProviderCounts struct {
+	Metadata
+	Providers []*ProviderCount `json:"providers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (p *ProviderCounts) GenerateMetaData() {
+	p.Type = &providerCountsType
+	p.Link = "/stats/providers"
+}
+
+// DomainLifetimes is a histogram of how long the domains first seen in a month stayed in their zone
+type DomainLifetimes struct {
+	Metadata
+	ZoneID int64 `json:"-"`
+	// all zones when empty
+	Zone string `json:"zone,omitempty"`
+	// YYYY-MM
+	Cohort string `json:"cohort"`
+	// number of domains first seen in the cohort month
+	Domains    int64             `json:"domains"`
+	Buckets    []*LifetimeBucket `json:"buckets"`
+	ComputedAt Timestamp         `json:"computed_at"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (dl *DomainLifetimes) GenerateMetaData() {
+	dl.Type = &domainLifetimesType
+	dl.Link = "/stats/lifetimes"
+}
+
+// LifetimeBucket is the number of domains of a cohort whose lifetime is in a bucket
+type LifetimeBucket struct {
+	Bucket  string `json:"bucket"`
+	Domains int64  `json:"domains"`
+}
+
+// CohortSample is a seeded pseudo-random panel of the domains first seen in a month, the same seed returns the same panel
+type CohortSample struct {
+	Metadata
+	// YYYY-MM
+	Cohort string `json:"cohort"`
+	// all zones when empty
+	Zone string `json:"zone,omitempty"`
+	Seed string `json:"seed"`
+	Size int    `json:"size"`
+	// number of domains first seen in the cohort month, the sampling fraction is Size over it
+	CohortSize int64 `json:"cohort_size"`
+	// false until the month ended, domains first seen later in it may still join the sample
+	Complete bool            `json:"complete"`
+	Domains  []*CohortDomain `json:"domains"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (cs *CohortSample) GenerateMetaData() {
+	cs.Type = &cohortSampleType
+	cs.Link = fmt.Sprintf("/cohorts/%s/sample", cs.Cohort)
+}
+
+// CohortDomain is a domain of a cohort sample with its current status
+type CohortDomain struct {
+	Name   string `json:"name" db:"name"`
+	Active bool   `json:"active" db:"active"`
+	// first delegation of the domain, in the cohort month
+	FirstSeen Date `json:"firstseen" db:"first_seen"`
+	// last delegation of a domain that left its zone, null while it is active
+	LastSeen Date `json:"lastseen" db:"last_seen"`
+}
+
+// ZoneDomains is a page of the domains of a zone in name order
+type ZoneDomains struct {
+	Metadata
+	Zone string `json:"zone"`
+	// the active filter, 1, 0 or all
+	Active string `json:"active"`
+	// counted on the first page, large zones only get an estimate from their latest import and a notice
+	Total         *int64        `json:"total,omitempty"`
+	TotalEstimate *int64        `json:"total_estimate,omitempty"`
+	Notice        string        `json:"notice,omitempty"`
+	Domains       []*ZoneDomain `json:"domains"`
+	NextCursor    string        `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zd *ZoneDomains) GenerateMetaData() {
+	zd.Type = &zoneDomainsType
+	zd.Link = fmt.Sprintf("/zones/%s/domains", zd.Zone)
+}
+
+// ZoneDomain is a domain of a zone listing
+type ZoneDomain struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// GlueInconsistencies is a page of the glue anomalies of one type found in the latest checked import of a zone, in nameserver order
+type GlueInconsistencies struct {
+	Metadata
+	Zone string `json:"zone"`
+	// orphan_glue or missing_glue
+	Inconsistency string    `json:"inconsistency"`
+	ImportID      int64     `json:"import_id"`
+	ImportDate    Date      `json:"import_date"`
+	ComputedAt    Timestamp `json:"computed_at"`
+	// anomalies of the type in the import
+	Total      int64                `json:"total"`
+	Items      []*GlueInconsistency `json:"inconsistencies"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (gi *GlueInconsistencies) GenerateMetaData() {
+	gi.Type = &glueReportType
+	gi.Link = fmt.Sprintf("/zones/%s/inconsistencies?type=%s", gi.Zone, gi.Inconsistency)
+}
+
+// GlueInconsistency is a nameserver whose glue does not match the delegations of a zone
+type GlueInconsistency struct {
+	NameServer string `json:"nameserver" db:"nameserver"`
+	// the glue of an orphan in the zone, and the glue a missing glue nameserver has in other zones
+	Addresses []string `json:"addresses" db:"addresses"`
+	// active domains of the zone delegating to a missing glue nameserver, and the first of them by name
+	DomainCount   int64  `json:"domain_count" db:"domains_count"`
+	ExampleDomain string `json:"example_domain,omitempty" db:"example_domain"`
+	// when the last delegation to an orphan ended, null if no domain ever delegated to it
+	LastDelegated Date `json:"last_delegated" db:"last_delegated"`
+}
+
+// Import is the state of an import of a zone by the zone importer and the stages it went through
+type Import struct {
+	Metadata
+	ID   int64  `json:"id" db:"id"`
+	Zone string `json:"zone" db:"zone"`
+	Date Date   `json:"date" db:"date"`
+	// running, finished or failed
+	Status string `json:"status"`
+	// the stage running, or the stage a failed import stopped in, empty when finished
+	Stage string `json:"stage,omitempty"`
+	// the last stage done, empty before the zone file was downloaded
+	LastCompletedStage string    `json:"last_completed_stage,omitempty"`
+	ImportedAt         Timestamp `json:"imported_at" db:"imported_at"`
+	// rows of the finished import, null until it finished
+	Domains *int64         `json:"domains" db:"domains"`
+	Records *int64         `json:"records" db:"records"`
+	Stages  []*ImportStage `json:"stages"`
+	// why the import is considered failed, the importer does not record its errors
+	Error string `json:"error,omitempty"`
+	// set when the domains it added or removed failed their sanity check, see ImportCheck
+	Suspect bool `json:"suspect" db:"suspect"`
+	// where the zone file came from, such as czds, registry_ftp or axfr, empty when not recorded
+	Source string `json:"source" db:"source"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (i *Import) GenerateMetaData() {
+	i.Type = &importType
+	i.Link = fmt.Sprintf("/admin/imports/%d", i.ID)
+}
+
+// ImportCheck is the sanity check of the domains a finished import added and removed against the previous imports of its zone
+type ImportCheck struct {
+	Metadata
+	ImportID int64  `json:"import_id" db:"import_id"`
+	Zone     string `json:"zone" db:"zone"`
+	Date     Date   `json:"date" db:"date"`
+	Added    int64  `json:"added" db:"added"`
+	Removed  int64  `json:"removed" db:"removed"`
+	// the previous imports compared with and their averages, null when there were too few to judge the import
+	History        int      `json:"history" db:"history"`
+	AddedAverage   *float64 `json:"added_average" db:"added_average"`
+	RemovedAverage *float64 `json:"removed_average" db:"removed_average"`
+	Suspect        bool     `json:"suspect" db:"suspect"`
+	// why the import is suspect
+	Reasons   []string  `json:"reasons,omitempty" db:"reasons"`
+	CheckedAt Timestamp `json:"checked_at" db:"checked_at"`
+	// when and from where the alert of a suspect import was acknowledged or cleared, null while it is an alert
+	AcknowledgedAt Timestamp `json:"acknowledged_at" db:"acknowledged_at"`
+	AcknowledgedBy string    `json:"acknowledged_by,omitempty" db:"acknowledged_by"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ic *ImportCheck) GenerateMetaData() {
+	ic.Type = &importCheckType
+	ic.Link = fmt.Sprintf("/admin/imports/%d", ic.ImportID)
+}
+
+// ImportAlerts lists the suspect imports not acknowledged yet, latest first
+type ImportAlerts struct {
+	Metadata
+	Alerts []*ImportCheck `json:"alerts"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ia *ImportAlerts) GenerateMetaData() {
+	ia.Type = &importAlertsType
+	ia.Link = "/alerts"
+	for _, ic := range ia.Alerts {
+		ic.GenerateMetaData()
+	}
+}
+
+// ImportStage is a stage of an import in pipeline order
+type ImportStage struct {
+	Name string `json:"name"`
+	// done, running, pending, failed or skipped
+	Status string `json:"status"`
+	// null for stages the importer does not time and stages not done
+	Duration *time.Duration `json:"duration"`
+}
+
+// RunningImports lists the imports the zone importer has not finished, oldest first
+type RunningImports struct {
+	Metadata
+	Imports []*Import `json:"imports"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ri *RunningImports) GenerateMetaData() {
+	ri.Type = &runningImportsType
+	ri.Link = "/admin/imports/running"
+	for _, i := range ri.Imports {
+		i.GenerateMetaData()
+	}
+}
+
+// Watchlist is a saved query of an API key, matched against the new domains of every import
+type Watchlist struct {
+	Metadata
+	ID    int64      `json:"id" db:"id"`
+	Name  string     `json:"name" db:"name"`
+	Query WatchQuery `json:"query"`
+	// standard or expensive, API keys may only have a few expensive watchlists
+	CostClass string    `json:"cost_class"`
+	CreatedAt Timestamp `json:"created_at" db:"created_at"`
+	// the shared query, also set in Query
+	TermID int64  `json:"-" db:"term_id"`
+	Kind   string `json:"-" db:"kind"`
+	Term   string `json:"-" db:"term"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (wl *Watchlist) GenerateMetaData() {
+	wl.Type = &watchlistType
+	wl.Link = fmt.Sprintf("/watchlists/%d", wl.ID)
+}
+
+// WatchQuery is what a watchlist matches in the names of the new domains without their zone, exactly one is set
+type WatchQuery struct {
+	Prefix    string `json:"prefix,omitempty"`
+	Contains  string `json:"contains,omitempty"`
+	SimilarTo string `json:"similar_to,omitempty"`
+}
+
+// Watchlists lists the watchlists of an API key
+type Watchlists struct {
+	Metadata
+	Watchlists []*Watchlist `json:"watchlists"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (wls *Watchlists) GenerateMetaData() {
+	wls.Type = &watchlistsType
+	wls.Link = "/watchlists"
+	for _, wl := range wls.Watchlists {
+		wl.GenerateMetaData()
+	}
+}
+
+// WatchlistMatches is a page of the domains matched by a watchlist, the current matches or those matched since Since
+type WatchlistMatches struct {
+	Metadata
+	Watchlist int64 `json:"watchlist_id"`
+	// set for the new matches
+	Since Timestamp `json:"since"`
+	// when the imports were last matched against the query, the matches of the imports finished since are not known yet
+	EvaluatedAt Timestamp         `json:"evaluated_at"`
+	Matches     []*WatchlistMatch `json:"matches"`
+	NextCursor  string            `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (wm *WatchlistMatches) GenerateMetaData() {
+	wm.Type = &watchlistMatchesType
+	if wm.Since.IsZero() {
+		wm.Link = fmt.Sprintf("/watchlists/%d/matches", wm.Watchlist)
+	} else {
+		wm.Link = fmt.Sprintf("/watchlists/%d/new", wm.Watchlist)
+	}
+}
+
+// WatchlistMatch is a new domain of an import matched by a watchlist
+type WatchlistMatch struct {
+	Domain     string    `json:"domain" db:"domain"`
+	Zone       string    `json:"zone" db:"zone"`
+	ImportID   int64     `json:"import_id" db:"import_id"`
+	ImportDate Date      `json:"import_date" db:"date"`
+	MatchedAt  Timestamp `json:"matched_at" db:"matched_at"`
+}
+
+// LabelZones is a second-level label looked up in every zone with an import
+type LabelZones struct {
+	Metadata
+	Label string `json:"label"`
+	// the number of zones the label was ever seen in
+	Found int          `json:"found"`
+	Zones []*LabelZone `json:"zones"`
+	// set when the lookup failed in some zones, they are named in FailedZones and left out of Zones
+	Partial     bool     `json:"partial,omitempty"`
+	FailedZones []string `json:"failed_zones,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (lz *LabelZones) GenerateMetaData() {
+	lz.Type = &labelZonesType
+	lz.Link = fmt.Sprintf("/label/%s", lz.Label)
+}
+
+// LabelZone is the label in one zone, the dates and nameservers of restricted zones are left out
+type LabelZone struct {
+	Zone   string `json:"zone"`
+	Domain string `json:"domain"`
+	Exists bool   `json:"exists"`
+	// set when the request has no scope for the zone, only Exists is given
+	Restricted bool `json:"restricted,omitempty"`
+	// the first and last delegation of the domain, LastSeen is null while one is active
+	FirstSeen   Date     `json:"firstseen"`
+	LastSeen    Date     `json:"lastseen"`
+	NameServers []string `json:"nameservers,omitempty"`
+}
+
+// LiveDomain compares the active nameservers of a domain in the zone files with those live DNS answers
+// when the live query fails only the zone file nameservers are set, along with LiveError
+type LiveDomain struct {
+	Metadata
+	Name            string   `json:"name"`
+	ZoneNameServers []string `json:"zone_nameservers"`
+	LiveNameServers []string `json:"live_nameservers,omitempty"`
+	LiveError       string   `json:"live_error,omitempty"`
+	// the nameservers only answering in live DNS and those only in the zone files
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Matches   *bool     `json:"matches,omitempty"`
+	CheckedAt Timestamp `json:"checked_at"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ld *LiveDomain) GenerateMetaData() {
+	ld.Type = &liveDomainType
+	ld.Link = fmt.Sprintf("/domains/%s/live", ld.Name)
+}
+
+// ZoneDiff counts the domains of a zone that changed between two imports
+// and lists a page of the domains of one change set
+type ZoneDiff struct {
+	Metadata
+	Zone string `json:"zone"`
+	// the imports closest to the requested dates that were compared
+	FromImportID int64 `json:"from_import_id"`
+	FromDate     Date  `json:"from_date"`
+	ToImportID   int64 `json:"to_import_id"`
+	ToDate       Date  `json:"to_date"`
+	Added        int   `json:"added"`
+	Removed      int   `json:"removed"`
+	Changed      int   `json:"changed"`
+	// only set when a set was requested
+	Set        string    `json:"set,omitempty"`
+	Domains    []*Domain `json:"domains,omitempty"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (d *ZoneDiff) GenerateMetaData() {
+	d.Type = &zoneDiffType
+	d.Link = fmt.Sprintf("/zones/%s/diff", d.Zone)
+	for _, domain := range d.Domains {
+		if domain.Type == nil {
+			domain.GenerateMetaData()
+		}
+	}
+}
+
+// ZoneChurn compares the domains of a zone on its latest import with those of the import a window earlier
+// only counts are given, so it is also served for restricted zones
+type ZoneChurn struct {
+	Metadata
+	Zone   string `json:"zone"`
+	Window string `json:"window"`
+	// the imports compared, the latest and the latest at or before its date less the window
+	FromImportID int64 `json:"from_import_id"`
+	FromDate     Date  `json:"from_date"`
+	ToImportID   int64 `json:"to_import_id"`
+	ToDate       Date  `json:"to_date"`
+	// the domains of either import
+	FromDomains int64 `json:"from_domains"`
+	ToDomains   int64 `json:"to_domains"`
+	// domains in both imports, changed of them with other nameservers
+	Stayed  int64 `json:"stayed"`
+	Added   int64 `json:"added"`
+	Removed int64 `json:"removed"`
+	Changed int64 `json:"changed"`
+	// the counts as fractions of FromDomains, null when the earlier import had no domains
+	Fractions *ChurnFractions `json:"fractions"`
+}
+
+// ChurnFractions are the counts of a ZoneChurn divided by the domains of the earlier import
+type ChurnFractions struct {
+	Stayed  float64 `json:"stayed"`
+	Added   float64 `json:"added"`
+	Removed float64 `json:"removed"`
+	Changed float64 `json:"changed"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (c *ZoneChurn) GenerateMetaData() {
+	c.Type = &zoneChurnType
+	c.Link = fmt.Sprintf("/stats/churn?zone=%s&window=%s", c.Zone, c.Window)
+}
+
+// ImportNotification is sent by the zone importer when it finished importing a zone
+type ImportNotification struct {
+	Metadata
+	Zone     string `json:"zone"`
+	ImportID int64  `json:"import_id"`
+	// where the zone file came from, recorded on the import unless the importer did
+	Source string `json:"source,omitempty"`
+	// rows written by the import by table
+	Rows map[string]int64 `json:"rows,omitempty"`
+	// set when the import had already been notified and nothing was done
+	Duplicate bool `json:"duplicate"`
+	// what was done for the notification, the jobs run in the background
+	FlushedCaches []string `json:"flushed_caches,omitempty"`
+	Jobs          []string `json:"jobs,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (in *ImportNotification) GenerateMetaData() {
+	in.Type = &importNotificationType
+}
+
 // ZoneImportResults results for imports
 type ZoneImportResults struct {
 	Metadata
@@ -110,14 +1015,16 @@ func (zirs *ZoneImportResults) GenerateMetaData() {
 // ZoneImportResult holds data about the results of a single import
 type ZoneImportResult struct {
 	Metadata
-	FirstImportID   int64      `json:"-"`
-	LastImportID    int64      `json:"-"`
-	FirstImportDate *time.Time `json:"first_date"`
-	LastImportDate  *time.Time `json:"last_date"`
-	Zone            string     `json:"zone"`
-	Records         int64      `json:"records"`
-	Domains         int64      `json:"domains"`
-	Count           int64      `json:"count"`
+	FirstImportID   int64  `json:"-" db:"first_import_id"`
+	LastImportID    int64  `json:"-" db:"last_import_id"`
+	FirstImportDate Date   `json:"first_date" db:"first_import_date"`
+	LastImportDate  Date   `json:"last_date" db:"last_import_date"`
+	Zone            string `json:"zone" db:"zone"`
+	Records         int64  `json:"records" db:"records"`
+	Domains         int64  `json:"domains" db:"domains"`
+	Count           int64  `json:"count" db:"count"`
+	// source of the last import, empty when not recorded
+	Source string `json:"source" db:"source"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -141,11 +1048,114 @@ func (zc *ZoneCount) GenerateMetaData() {
 
 // ZoneCounts contains stats and counts for a single zone on a single day
 type ZoneCounts struct {
-	Date    time.Time `json:"date"`
-	Domains int64     `json:"domains"`
-	Old     int64     `json:"old"`
-	Moved   int64     `json:"moved"`
-	New     int64     `json:"new"`
+	Date    Date  `json:"date" db:"date"`
+	Domains int64 `json:"domains" db:"domains"`
+	Old     int64 `json:"old" db:"old"`
+	Moved   int64 `json:"moved" db:"moved"`
+	New     int64 `json:"new" db:"new"`
+}
+
+// NameServerStats is the number of domains delegated to a nameserver on every date of a range
+type NameServerStats struct {
+	Metadata
+	NameServer string `json:"nameserver"`
+	// only domains of the zone are counted when set
+	Zone    string             `json:"zone,omitempty"`
+	From    Date               `json:"from"`
+	To      Date               `json:"to"`
+	History []*NameServerCount `json:"history"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (nss *NameServerStats) GenerateMetaData() {
+	nss.Type = &nameServerStatsType
+	nss.Link = fmt.Sprintf("/nameservers/%s", nss.NameServer)
+}
+
+// NameServerChanges lists the domains that started or stopped delegating to a nameserver in a date range
+type NameServerChanges struct {
+	Metadata
+	NameServer string `json:"nameserver"`
+	Start      Date   `json:"start"`
+	End        Date   `json:"end"`
+	// changes of the whole range, not only of the page
+	Gained     int64               `json:"gained"`
+	Lost       int64               `json:"lost"`
+	Changes    []*NameServerChange `json:"changes"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (nsc *NameServerChanges) GenerateMetaData() {
+	nsc.Type = &nameServerChangesType
+	nsc.Link = fmt.Sprintf("/nameservers/%s/changes", nsc.NameServer)
+}
+
+// NameServerChange is a domain that started or stopped delegating to a nameserver
+type NameServerChange struct {
+	Domain string `json:"domain" db:"domain"`
+	// gained on the first date the delegation was seen, lost on the day after the last
+	Change string `json:"change" db:"change"`
+	Date   Date   `json:"date" db:"date"`
+	// the other nameservers of the domain the day before it was gained or the day it was lost, where it came from or went
+	NameServers []string `json:"nameservers" db:"nameservers"`
+}
+
+// NameServerSuffixStats counts the domains delegated to the nameservers named by or below a suffix
+type NameServerSuffixStats struct {
+	Metadata
+	Suffix string `json:"suffix"`
+	// distinct domains with an active delegation to any of the nameservers
+	Domains     int64 `json:"domains"`
+	NameServers int64 `json:"nameserver_count"`
+	// the first and last delegation to any of the nameservers, LastSeen is null while one is active
+	FirstSeen Date `json:"firstseen"`
+	LastSeen  Date `json:"lastseen"`
+	// the nameservers with the most active domains
+	TopNameServers []*NameServerSuffixCount `json:"nameservers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (nss *NameServerSuffixStats) GenerateMetaData() {
+	nss.Type = &nameServerSuffixType
+	nss.Link = fmt.Sprintf("/nameservers/suffix/%s/stats", nss.Suffix)
+}
+
+// NameServerSuffixCount is the number of active domains of a nameserver below a suffix
+type NameServerSuffixCount struct {
+	Name    string `json:"name"`
+	Domains int64  `json:"domains"`
+}
+
+// NameServerCount is the number of domains delegated to a nameserver on a date
+// Domains is null for dates without an import
+type NameServerCount struct {
+	Date    Date   `json:"date"`
+	Domains *int64 `json:"domains"`
+}
+
+// NameServerSet is a set of nameservers and a page of the active domains delegated to exactly that set
+type NameServerSet struct {
+	Metadata
+	Fingerprint string   `json:"fingerprint"`
+	NameServers []string `json:"nameservers"`
+	// number of active domains with the set when it was last indexed
+	DomainCount int64 `json:"domain_count"`
+	// only domains scored at least this stable are listed when set
+	MinStability string    `json:"min_stability,omitempty"`
+	Domains      []*Domain `json:"domains"`
+	NextCursor   string    `json:"next_cursor,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (nss *NameServerSet) GenerateMetaData() {
+	nss.Type = &nameServerSetType
+	nss.Link = fmt.Sprintf("/nsset/%s", nss.Fingerprint)
+	for _, domain := range nss.Domains {
+		if domain.Type == nil {
+			domain.GenerateMetaData()
+		}
+	}
 }
 
 // AllZoneCounts contains zone counts for all zones in a Map
@@ -163,10 +1173,10 @@ func (zc *AllZoneCounts) GenerateMetaData() {
 // Zone holds information about a zone
 type Zone struct {
 	Metadata
-	ID                     int64             `json:"-"`
-	Name                   string            `json:"name"`
-	FirstSeen              *time.Time        `json:"firstseen,omitempty"`
-	LastSeen               *time.Time        `json:"lastseen,omitempty"`
+	ID                     int64             `json:"-" db:"id"`
+	Name                   string            `json:"name" db:"name"`
+	FirstSeen              Date              `json:"firstseen" db:"first_seen"`
+	LastSeen               Date              `json:"lastseen" db:"last_seen"`
 	NameServers            []*NameServer     `json:"nameservers,omitempty"`
 	ArchiveNameServers     []*NameServer     `json:"archive_nameservers,omitempty"`
 	NameServerCount        *int64            `json:"nameserver_count,omitempty"`
@@ -176,10 +1186,62 @@ type Zone struct {
 	RootImport             *RootZone         `json:"root,omitempty"`
 }
 
+// ZoneInfrastructure is the delegation of a zone in its parent, the root zone, apart from the domains of the zone
+type ZoneInfrastructure struct {
+	Metadata
+	Zone string `json:"zone"`
+	// null when the imports never saw the zone delegated
+	ParentData *ZoneDelegation `json:"parent_data"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zi *ZoneInfrastructure) GenerateMetaData() {
+	zi.Type = &zoneInfraType
+	zi.Link = fmt.Sprintf("/zones/%s/infrastructure", zi.Zone)
+}
+
+// ZoneDelegation is the current delegation of a zone in the root zone imports
+type ZoneDelegation struct {
+	// the parent the delegation was seen in
+	Parent      string                  `json:"parent"`
+	NameServers []*DelegationNameServer `json:"nameservers"`
+	// whether the parent has DS records for the zone, null since the importer does not record them
+	DS *bool `json:"ds"`
+}
+
+// DelegationNameServer is a nameserver of a delegation and its current addresses
+type DelegationNameServer struct {
+	Name      string   `json:"name" db:"name"`
+	FirstSeen Date     `json:"firstseen" db:"first_seen"`
+	IPv4      []string `json:"ipv4" db:"ipv4"`
+	IPv6      []string `json:"ipv6" db:"ipv6"`
+}
+
+// ZoneInfrastructureHistory lists the changes of the delegation of a zone, latest first
+type ZoneInfrastructureHistory struct {
+	Metadata
+	Zone    string              `json:"zone"`
+	Changes []*DelegationChange `json:"changes"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zih *ZoneInfrastructureHistory) GenerateMetaData() {
+	zih.Type = &zoneInfraHistoryType
+	zih.Link = fmt.Sprintf("/zones/%s/infrastructure/history", zih.Zone)
+}
+
+// DelegationChange is a nameserver added to or removed from a delegation on an import date
+type DelegationChange struct {
+	Date Date `json:"date" db:"date"`
+	// added or removed
+	Change     string `json:"change" db:"change"`
+	NameServer string `json:"nameserver" db:"nameserver"`
+}
+
 // RootZone adds root metadata to the zone types
 type RootZone struct {
-	FirstImport *time.Time `json:"first_import"`
-	LastImport  *time.Time `json:"last_import"`
+	FirstImport Date `json:"first_import"`
+	LastImport  Date `json:"last_import"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -191,15 +1253,21 @@ func (z *Zone) GenerateMetaData() {
 // Domain domain object
 type Domain struct {
 	Metadata
-	ID                     int64         `json:"-"`
-	Name                   string        `json:"name"`
-	FirstSeen              *time.Time    `json:"firstseen,omitempty"`
-	LastSeen               *time.Time    `json:"lastseen,omitempty"`
-	NameServers            []*NameServer `json:"nameservers,omitempty"`
-	ArchiveNameServers     []*NameServer `json:"archive_nameservers,omitempty"`
-	NameServerCount        *int64        `json:"nameserver_count,omitempty"`
-	ArchiveNameServerCount *int64        `json:"archive_nameserver_count,omitempty"`
-	Zone                   *Zone         `json:"zone,omitempty"`
+	ID                       int64         `json:"-" db:"id"`
+	Name                     string        `json:"name" db:"name"`
+	FirstSeen                Date          `json:"firstseen" db:"first_seen"`
+	LastSeen                 Date          `json:"lastseen" db:"last_seen"`
+	NameServers              []*NameServer `json:"nameservers,omitempty"`
+	ArchiveNameServers       []*NameServer `json:"archive_nameservers,omitempty"`
+	NameServerCount          *int64        `json:"nameserver_count,omitempty"`
+	ArchiveNameServerCount   *int64        `json:"archive_nameserver_count,omitempty"`
+	NameServerSetFingerprint string        `json:"nsset_fingerprint,omitempty"`
+	Zone                     *Zone         `json:"zone,omitempty"`
+	// source of the import that last observed the domain, such as czds, only set on the domain lookup and empty when unknown
+	Source string `json:"source,omitempty"`
+	// kind of the special-use domain the name is under, such as example, only set on the domain lookup and empty for
+	// the other names
+	SpecialUse string `json:"special_use,omitempty"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -218,11 +1286,205 @@ func (d *Domain) GenerateMetaData() {
 	}
 }
 
+// FeedDelta is a page of the domains added by the imports finished after a checkpoint, in the order they finished
+type FeedDelta struct {
+	Metadata
+	Change string `json:"change"`
+	// only domains of the zone are listed when set
+	Zone string `json:"zone,omitempty"`
+	// only domains of imports from these sources are listed when set
+	Sources []string           `json:"sources,omitempty"`
+	Domains []*FeedDeltaDomain `json:"domains"`
+	// passed as the checkpoint of the next call, it is returned even when the page is empty
+	NextCheckpoint string `json:"next_checkpoint"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (fd *FeedDelta) GenerateMetaData() {
+	fd.Type = &feedDeltaType
+	fd.Link = fmt.Sprintf("/feeds/%s/since/%s", fd.Change, fd.NextCheckpoint)
+}
+
+// FeedDeltaDomain is a domain of a feed delta with the import that added it
+type FeedDeltaDomain struct {
+	Name     string `json:"name" db:"name"`
+	Zone     string `json:"zone" db:"zone"`
+	Date     Date   `json:"date" db:"date"`
+	ImportID int64  `json:"import_id" db:"import_id"`
+}
+
+// ZoneCountAsOf is the number of domains of a zone as of a date, counted by the latest import at or before it
+type ZoneCountAsOf struct {
+	Metadata
+	Zone       string `json:"zone,omitempty"`
+	Date       Date   `json:"date"`
+	ImportID   int64  `json:"import_id,omitempty"`
+	ImportDate Date   `json:"import_date"`
+	Domains    *int64 `json:"domains,omitempty"`
+	// why there is no count as of the date, only set in a ZoneCountAsOfSeries
+	Error *JSONError `json:"error,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zc *ZoneCountAsOf) GenerateMetaData() {
+	zc.Type = &zoneAsOfType
+	zc.Link = fmt.Sprintf("/zones/%s/count?date=%s", zc.Zone, zc.Date.Format("2006-01-02"))
+}
+
+// ZoneCountAsOfSeries is the number of domains of a zone as of several dates, in the order they were requested
+type ZoneCountAsOfSeries struct {
+	Metadata
+	Zone   string           `json:"zone"`
+	Counts []*ZoneCountAsOf `json:"counts"`
+	// set when the lookup of some dates failed, they are counted in Failed and have their error
+	Partial bool `json:"partial,omitempty"`
+	Failed  int  `json:"failed_items,omitempty"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zcs *ZoneCountAsOfSeries) GenerateMetaData() {
+	zcs.Type = &zoneAsOfSeriesType
+	zcs.Link = fmt.Sprintf("/zones/%s/count", zcs.Zone)
+}
+
+// LabelStats are the distributions of the labels of the active domains of a zone as of an import, without the zone
+type LabelStats struct {
+	Metadata
+	Zone string `json:"zone"`
+	// the date asked for with as_of, unset for the latest import
+	AsOf       Date  `json:"as_of"`
+	ImportID   int64 `json:"import_id"`
+	ImportDate Date  `json:"import_date"`
+	Domains    int64 `json:"domains"`
+	// internationalized labels, in their xn-- form
+	IDNDomains  int64          `json:"idn_domains"`
+	IDNFraction float64        `json:"idn_fraction"`
+	Lengths     []*LabelBucket `json:"lengths"`
+	Digits      []*LabelBucket `json:"digits"`
+	Hyphens     []*LabelBucket `json:"hyphens"`
+	ComputedAt  Timestamp      `json:"computed_at"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ls *LabelStats) GenerateMetaData() {
+	ls.Type = &labelStatsType
+	ls.Link = fmt.Sprintf("/zones/%s/stats/labels", ls.Zone)
+	if !ls.AsOf.IsZero() {
+		ls.Link += "?as_of=" + ls.AsOf.Format(DateFormat)
+	}
+}
+
+// domain stabilities, from the most to the least stable
+const (
+	StabilityStable         = "stable"
+	StabilityChurning       = "churning"
+	StabilityParkedFlapping = "parked-flapping"
+)
+
+// DomainStability summarizes how often the nameserver set of a domain changed over its history
+type DomainStability struct {
+	Metadata
+	Domain string `json:"domain"`
+	// stable, churning or parked-flapping, see the scoring of the domain stability
+	Stability string `json:"stability"`
+	// distinct nameserver sets the domain was delegated to
+	NameServerSets int `json:"nameserver_sets"`
+	// changes of the set, a gap without delegation and the return from it included, and the changes back to a set
+	// the domain had before
+	Changes int `json:"changes"`
+	Reverts int `json:"reverts"`
+	// the longest time the domain kept a set, up to the day of the response for its current set
+	LongestStableDays int `json:"longest_stable_days"`
+	// the last change, null if the set never changed, and the days since it or since the domain was first seen
+	LastChange      Date `json:"last_change"`
+	DaysSinceChange int  `json:"days_since_change"`
+	FirstSeen       Date `json:"firstseen"`
+	LastSeen        Date `json:"lastseen"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ds *DomainStability) GenerateMetaData() {
+	ds.Type = &domainStabilityType
+	ds.Link = fmt.Sprintf("/domains/%s/stability", ds.Domain)
+}
+
+// LabelBucket is the number of labels whose length, or number of digits or hyphens, is from Min to Max
+type LabelBucket struct {
+	Bucket string `json:"bucket"`
+	Min    int    `json:"min"`
+	// unset for the last bucket, which has no upper bound
+	Max     *int  `json:"max"`
+	Domains int64 `json:"domains"`
+}
+
+// BulkManifest lists the pre-generated bulk downloads, of one date when Date is set
+type BulkManifest struct {
+	Metadata
+	Date      Date            `json:"date"`
+	Artifacts []*BulkArtifact `json:"artifacts"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (bm *BulkManifest) GenerateMetaData() {
+	bm.Type = &bulkManifestType
+	bm.Link = "/bulk/manifest"
+	if !bm.Date.IsZero() {
+		bm.Link += "/" + bm.Date.Format(DateFormat)
+	}
+}
+
+// BulkArtifact is a pre-generated bulk download and the checksum to verify it with
+type BulkArtifact struct {
+	// feed
+	Kind string `json:"kind"`
+	// new, old or moved for feeds
+	Change string `json:"change,omitempty"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	// hex SHA-256 of the file as downloaded
+	SHA256      string `json:"sha256"`
+	Compression string `json:"compression"`
+	// date of the data in the file
+	Date        Date      `json:"date"`
+	GeneratedAt Timestamp `json:"generated_at"`
+}
+
+// KeywordTimeseries is the number of new domains containing a tracked keyword in every period
+type KeywordTimeseries struct {
+	Metadata
+	Keyword string `json:"keyword"`
+	// day, week or month
+	Granularity string           `json:"granularity"`
+	From        Date             `json:"from"`
+	To          Date             `json:"to"`
+	Periods     []*KeywordPeriod `json:"periods"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (kt *KeywordTimeseries) GenerateMetaData() {
+	kt.Type = &keywordTimeseriesType
+	kt.Link = fmt.Sprintf("/stats/keywords/%s/timeseries", kt.Keyword)
+}
+
+// KeywordPeriod is the number of new domains containing a keyword first seen in a period, in total and by zone
+type KeywordPeriod struct {
+	// first day of the period, weeks start on Monday
+	Start   Date  `json:"start"`
+	Domains int64 `json:"domains"`
+	// zones without a match are left out
+	Zones map[string]int64 `json:"zones"`
+}
+
 type Feed struct {
 	Metadata
-	Change  string    `json:"change,omitempty"`
-	Date    time.Time `json:"date"`
-	Domains []*Domain `json:"domains"`
+	Change string `json:"change,omitempty"`
+	Date   Date   `json:"date"`
+	// only domains of imports from these sources are listed when set
+	Sources []string `json:"sources,omitempty"`
+	// only domains scored at least this stable are listed when set
+	MinStability string    `json:"min_stability,omitempty"`
+	Domains      []*Domain `json:"domains"`
 }
 
 func (f *Feed) GenerateMetaData() {
@@ -239,7 +1501,7 @@ func (f *Feed) GenerateMetaData() {
 type NSFeed struct {
 	Metadata
 	Change       string        `json:"change,omitempty"`
-	Date         time.Time     `json:"date"`
+	Date         Date          `json:"date"`
 	Nameservers4 []*NameServer `json:"nameservers_4"`
 	Nameservers6 []*NameServer `json:"nameservers_6"`
 }
@@ -263,23 +1525,31 @@ func (f *NSFeed) GenerateMetaData() {
 // NameServer nameserver object
 type NameServer struct {
 	Metadata
-	ID                 int64      `json:"-"`
-	Name               string     `json:"name"`
-	FirstSeen          *time.Time `json:"firstseen,omitempty"`
-	LastSeen           *time.Time `json:"lastseen,omitempty"`
-	Domains            []*Domain  `json:"domains,omitempty"`
-	ArchiveDomains     []*Domain  `json:"archive_domains,omitempty"`
-	DomainCount        *int64     `json:"domain_count,omitempty"`
-	ArchiveDomainCount *int64     `json:"archive_domain_count,omitempty"`
-	IP4                []*IP4     `json:"ipv4,omitempty"`
-	ArchiveIP4         []*IP4     `json:"archive_ipv4,omitempty"`
-	IP4Count           *int64     `json:"ipv4_count,omitempty"`
-	ArchiveIP4Count    *int64     `json:"archive_ipv4_count,omitempty"`
-	IP6                []*IP6     `json:"ipv6,omitempty"`
-	ArchiveIP6         []*IP6     `json:"archive_ipv6,omitempty"`
-	IP6Count           *int64     `json:"ipv6_count,omitempty"`
-	ArchiveIP6Count    *int64     `json:"archive_ipv6_count,omitempty"`
-	Zone               *Zone      `json:"zone,omitempty"`
+	ID                 int64     `json:"-" db:"id"`
+	Name               string    `json:"name" db:"name"`
+	FirstSeen          Date      `json:"firstseen" db:"first_seen"`
+	LastSeen           Date      `json:"lastseen" db:"last_seen"`
+	Domains            []*Domain `json:"domains,omitempty"`
+	ArchiveDomains     []*Domain `json:"archive_domains,omitempty"`
+	DomainCount        *int64    `json:"domain_count,omitempty" db:"domains_count"`
+	ArchiveDomainCount *int64    `json:"archive_domain_count,omitempty" db:"domains_archive_count"`
+	IP4                []*IP4    `json:"ipv4,omitempty"`
+	ArchiveIP4         []*IP4    `json:"archive_ipv4,omitempty"`
+	IP4Count           *int64    `json:"ipv4_count,omitempty" db:"a_count"`
+	ArchiveIP4Count    *int64    `json:"archive_ipv4_count,omitempty" db:"a_archive_count"`
+	IP6                []*IP6    `json:"ipv6,omitempty"`
+	ArchiveIP6         []*IP6    `json:"archive_ipv6,omitempty"`
+	IP6Count           *int64    `json:"ipv6_count,omitempty" db:"aaaa_count"`
+	ArchiveIP6Count    *int64    `json:"archive_ipv6_count,omitempty" db:"aaaa_archive_count"`
+	Zone               *Zone     `json:"zone,omitempty"`
+	// hosting provider classified from the name, empty when unknown
+	Provider string `json:"provider,omitempty"`
+	// source of the import that last observed a delegation to the nameserver, only set on the nameserver lookup
+	// and empty when unknown
+	Source string `json:"source,omitempty"`
+	// kind of the special-use domain the name is under, such as localhost, only set on the nameserver lookup and
+	// empty for the other names
+	SpecialUse string `json:"special_use,omitempty"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -321,16 +1591,26 @@ func (ns *NameServer) GenerateMetaData() {
 // IP holds information about an IP address
 type IP struct {
 	Metadata
-	ID                     int64         `json:"-"`
+	ID                     int64         `json:"-" db:"id"`
 	Name                   string        `json:"name"`
-	IP                     *net.IP       `json:"-"`
-	Version                int           `json:"version"`
-	FirstSeen              *time.Time    `json:"firstseen,omitempty"`
-	LastSeen               *time.Time    `json:"lastseen,omitempty"`
+	IP                     *net.IP       `json:"-" db:"ip"`
+	Version                int           `json:"version" db:"version"`
+	FirstSeen              Date          `json:"firstseen" db:"first_seen"`
+	LastSeen               Date          `json:"lastseen" db:"last_seen"`
 	NameServers            []*NameServer `json:"nameservers,omitempty"`
 	ArchiveNameServers     []*NameServer `json:"archive_nameservers,omitempty"`
-	NameServerCount        *int64        `json:"nameserver_count,omitempty"`
+	NameServerCount        *int64        `json:"nameserver_count,omitempty" db:"nameserver_count"`
 	ArchiveNameServerCount *int64        `json:"archive_nameserver_count,omitempty"`
+	// the route of the address in the routing table of the asn job, nil when no table is loaded
+	ASN *IPRoute `json:"asn,omitempty"`
+}
+
+// IPRoute is the longest prefix of the routing table containing an IP address and the ASNs originating it
+type IPRoute struct {
+	// false for the addresses no prefix of the table contains, Prefix and Origins are then empty
+	Routed  bool     `json:"routed"`
+	Prefix  string   `json:"prefix,omitempty"`
+	Origins []uint32 `json:"origins,omitempty"`
 }
 
 // IP4 is an alias to the IP type
@@ -370,11 +1650,41 @@ func (ip *IP) GenerateMetaData() {
 	}
 }
 
+// ASN holds the nameserver IP addresses an autonomous system originates, those whose longest matching prefix of
+// the routing table it originates, and the active nameservers and domains using them
+type ASN struct {
+	Metadata
+	ASN             uint32 `json:"asn"`
+	PrefixCount     int    `json:"prefix_count"`
+	IP4Count        int64  `json:"ipv4_count" db:"ipv4_count"`
+	IP6Count        int64  `json:"ipv6_count" db:"ipv6_count"`
+	NameServerCount int64  `json:"nameserver_count" db:"nameserver_count"`
+	DomainCount     int64  `json:"domain_count" db:"domain_count"`
+	// the first of the active nameserver IP addresses by address, with the count of their active nameservers
+	IPs []*IP `json:"ips"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (a *ASN) GenerateMetaData() {
+	a.Type = &asnType
+	a.Link = fmt.Sprintf("/asn/%d", a.ASN)
+	for _, ip := range a.IPs {
+		if ip.Type == nil {
+			ip.GenerateMetaData()
+		}
+	}
+}
+
 // Search has the metadata and results for a search operation
 type Search struct {
 	Query   string
 	Type    string
 	Results []SearchResult
+	// kind of the special-use domain the query is under, empty for the other names
+	SpecialUse string
+	// the RFC reserving it, and whether it may be delegated in a public zone
+	SpecialUseRFC    string
+	SpecialUsePublic bool
 }
 
 // SearchResult has the name and type of search results
@@ -386,9 +1696,9 @@ type SearchResult struct {
 
 // PrefixResult stores the result of an individual prefix search result
 type PrefixResult struct {
-	Domain    string     `json:"domain"`
-	FirstSeen *time.Time `json:"firstseen,omitempty"`
-	LastSeen  *time.Time `json:"lastseen,omitempty"`
+	Domain    string `json:"domain"`
+	FirstSeen Date   `json:"firstseen"`
+	LastSeen  Date   `json:"lastseen"`
 }
 
 // PrefixList holds information about an IP address
@@ -402,11 +1712,11 @@ type PrefixList struct {
 // TLDLife holds TLD age information for the TLD graveyard page
 type TLDLife struct {
 	Metadata
-	Zone    string     `json:"zone"`
-	Created *time.Time `json:"created"`
-	Removed *time.Time `json:"removed"`
-	Domains *int64     `json:"domains"`
-	Age     *string    `json:"age"`
+	Zone    string  `json:"zone" db:"zone"`
+	Created Date    `json:"created" db:"created"`
+	Removed Date    `json:"removed" db:"removed"`
+	Domains *int64  `json:"domains" db:"domains"`
+	Age     *string `json:"age" db:"age"`
 }
 
 type FeedCountList struct {
@@ -421,6 +1731,6 @@ func (fc *FeedCountList) GenerateMetaData() {
 }
 
 type FeedCount struct {
-	Date  *time.Time `json:"date"`
-	Count int64      `json:"count"`
+	Date  Date  `json:"date" db:"date"`
+	Count int64 `json:"count" db:"count"`
 }
@@ -2,7 +2,6 @@ package model
 
 import (
 	"fmt"
-	"time"
 )
 
 // API Explain Strings
@@ -26,9 +25,9 @@ type ResearchZoneCount struct {
 // ActiveIPs  Struct that lists addresses for a given date
 type ActiveIPs struct {
 	Metadata
-	Date    time.Time `json:"date"`
-	IPv4IPs []string  `json:"ipv4_ips"`
-	IPv6IPs []string  `json:"ipv6_ips"`
+	Date    Date     `json:"date"`
+	IPv4IPs []string `json:"ipv4_ips"`
+	IPv6IPs []string `json:"ipv6_ips"`
 }
 
 // GenerateMetaData generates metadata recursively for ActiveIPs API
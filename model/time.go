@@ -0,0 +1,152 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// DateFormat is the JSON encoding of a Date
+const DateFormat = "2006-01-02"
+
+// TimestampFormat is the JSON encoding of a Timestamp, always in UTC
+const TimestampFormat = time.RFC3339
+
+// Date is a calendar day in UTC, such as the date of an import, encoded as 2006-01-02
+// the zero Date is a NULL column and encoded as null
+type Date struct {
+	time.Time
+}
+
+// NewDate returns the day of t in UTC
+func NewDate(t time.Time) Date {
+	t = t.UTC()
+	return Date{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}
+}
+
+// MarshalJSON encodes the date as 2006-01-02, or null
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + d.UTC().Format(DateFormat) + `"`), nil
+}
+
+// UnmarshalJSON decodes a 2006-01-02 date, or a RFC 3339 time as dates were encoded before, null is the zero Date
+func (d *Date) UnmarshalJSON(b []byte) error {
+	t, err := unmarshalTime(b, DateFormat)
+	if err != nil {
+		return fmt.Errorf("date: %w", err)
+	}
+	if t.IsZero() {
+		*d = Date{}
+		return nil
+	}
+	*d = NewDate(t)
+	return nil
+}
+
+// Scan implements sql.Scanner, NULL is the zero Date
+func (d *Date) Scan(src interface{}) error {
+	t, err := scanTime(src)
+	if err != nil {
+		return fmt.Errorf("date: %w", err)
+	}
+	if t.IsZero() {
+		*d = Date{}
+		return nil
+	}
+	*d = NewDate(t)
+	return nil
+}
+
+// Value implements driver.Valuer, the zero Date is NULL
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.Time, nil
+}
+
+// Timestamp is an instant, such as when a job last ran, encoded in RFC 3339 in UTC to the second
+// the zero Timestamp is a NULL column and encoded as null
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp returns t in UTC
+func NewTimestamp(t time.Time) Timestamp {
+	if t.IsZero() {
+		return Timestamp{}
+	}
+	return Timestamp{t.UTC()}
+}
+
+// Now returns the current time as a Timestamp
+func Now() Timestamp {
+	return NewTimestamp(time.Now())
+}
+
+// MarshalJSON encodes the time in RFC 3339 in UTC, or null
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	if ts.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + ts.UTC().Format(TimestampFormat) + `"`), nil
+}
+
+// UnmarshalJSON decodes a RFC 3339 time with any offset, null is the zero Timestamp
+func (ts *Timestamp) UnmarshalJSON(b []byte) error {
+	t, err := unmarshalTime(b, time.RFC3339Nano)
+	if err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+	*ts = NewTimestamp(t)
+	return nil
+}
+
+// Scan implements sql.Scanner, NULL is the zero Timestamp
+func (ts *Timestamp) Scan(src interface{}) error {
+	t, err := scanTime(src)
+	if err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+	*ts = NewTimestamp(t)
+	return nil
+}
+
+// Value implements driver.Valuer, the zero Timestamp is NULL
+func (ts Timestamp) Value() (driver.Value, error) {
+	if ts.IsZero() {
+		return nil, nil
+	}
+	return ts.Time, nil
+}
+
+// unmarshalTime decodes a JSON string in layout or in RFC 3339, null is the zero time
+func unmarshalTime(b []byte, layout string) (time.Time, error) {
+	s := string(b)
+	if s == "null" {
+		return time.Time{}, nil
+	}
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return time.Time{}, fmt.Errorf("%s is not a string", s)
+	}
+	s = s[1 : len(s)-1]
+	t, err := time.Parse(layout, s)
+	if err != nil && layout != time.RFC3339Nano {
+		t, err = time.Parse(time.RFC3339Nano, s)
+	}
+	return t, err
+}
+
+// scanTime returns the time of a column value, nil is the zero time
+func scanTime(src interface{}) (time.Time, error) {
+	switch v := src.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	}
+	return time.Time{}, fmt.Errorf("can not scan %T", src)
+}
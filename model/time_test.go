@@ -0,0 +1,133 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDateJSON(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tests := []struct {
+		name string
+		date Date
+		want string
+	}{
+		{name: "day", date: NewDate(time.Date(2023, 7, 4, 15, 4, 5, 0, time.UTC)), want: `"2023-07-04"`},
+		{name: "day of the UTC time", date: NewDate(time.Date(2023, 7, 5, 8, 0, 0, 0, tokyo)), want: `"2023-07-04"`},
+		{name: "leap day", date: NewDate(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)), want: `"2024-02-29"`},
+		{name: "zero", want: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != tt.want {
+				t.Errorf("got %s, want %s", encoded, tt.want)
+			}
+			var decoded Date
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !decoded.Equal(tt.date.Time) {
+				t.Errorf("decoded %s, want %s", decoded, tt.date)
+			}
+		})
+	}
+}
+
+func TestDateUnmarshal(t *testing.T) {
+	tests := []struct {
+		encoded string
+		want    time.Time
+		wantErr bool
+	}{
+		{encoded: `"2023-07-04"`, want: time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC)},
+		// dates were encoded as RFC 3339 times before
+		{encoded: `"2023-07-04T00:00:00Z"`, want: time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{encoded: `"2023-07-04T22:00:00-04:00"`, want: time.Date(2023, 7, 5, 0, 0, 0, 0, time.UTC)},
+		{encoded: `null`},
+		{encoded: `"2023-02-29"`, wantErr: true},
+		{encoded: `"07/04/2023"`, wantErr: true},
+		{encoded: `20230704`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var d Date
+		err := json.Unmarshal([]byte(tt.encoded), &d)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want one: %t", tt.encoded, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!d.Equal(tt.want) || !tt.want.IsZero() && d.Location() != time.UTC) {
+			t.Errorf("%s: got %s, want %s", tt.encoded, d, tt.want)
+		}
+	}
+}
+
+func TestTimestampJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp Timestamp
+		want      string
+	}{
+		{name: "utc", timestamp: NewTimestamp(time.Date(2023, 7, 4, 15, 4, 5, 0, time.UTC)), want: `"2023-07-04T15:04:05Z"`},
+		{name: "offset", timestamp: NewTimestamp(time.Date(2023, 7, 4, 17, 4, 5, 0, time.FixedZone("", 2*60*60))), want: `"2023-07-04T15:04:05Z"`},
+		{name: "to the second", timestamp: NewTimestamp(time.Date(2023, 7, 4, 15, 4, 5, 999999999, time.UTC)), want: `"2023-07-04T15:04:05Z"`},
+		{name: "zero", timestamp: NewTimestamp(time.Time{}), want: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != tt.want {
+				t.Errorf("got %s, want %s", encoded, tt.want)
+			}
+		})
+	}
+
+	var ts Timestamp
+	if err := json.Unmarshal([]byte(`"2023-07-04T17:04:05.5+02:00"`), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2023, 7, 4, 15, 4, 5, 500000000, time.UTC); !ts.Equal(want) || ts.Location() != time.UTC {
+		t.Errorf("decoded %s, want %s", ts, want)
+	}
+	if err := json.Unmarshal([]byte(`"2023-07-04"`), &ts); err == nil {
+		t.Error("decoded a date as a timestamp")
+	}
+}
+
+func TestTimeColumns(t *testing.T) {
+	at := time.Date(2023, 7, 4, 22, 0, 0, 0, time.FixedZone("", -4*60*60))
+	var d Date
+	if err := d.Scan(at); err != nil || !d.Equal(time.Date(2023, 7, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("scanned %s, %v", d, err)
+	}
+	if err := d.Scan(nil); err != nil || !d.IsZero() {
+		t.Errorf("scanned NULL as %s, %v", d, err)
+	}
+	if err := d.Scan("2023-07-04"); err == nil {
+		t.Error("scanned a string")
+	}
+	if v, err := d.Value(); v != nil || err != nil {
+		t.Errorf("zero date is %v, %v, want NULL", v, err)
+	}
+
+	var ts Timestamp
+	if err := ts.Scan(at); err != nil || !ts.Equal(at) || ts.Location() != time.UTC {
+		t.Errorf("scanned %s, %v", ts, err)
+	}
+	if v, err := ts.Value(); err != nil || v != ts.Time {
+		t.Errorf("value %v, %v, want %s", v, err, ts)
+	}
+	if err := ts.Scan(nil); err != nil || !ts.IsZero() {
+		t.Errorf("scanned NULL as %s, %v", ts, err)
+	}
+	if v, err := ts.Value(); v != nil || err != nil {
+		t.Errorf("zero timestamp is %v, %v, want NULL", v, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package params reads and validates request parameters
+// every accessor returns the parsed value, or a JSON error naming the parameter ready to be written
+package params
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"dnscoffee/asn"
+	"dnscoffee/model"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/idna"
+)
+
+// MaxLength is the longest parameter accepted in bytes
+// well above the 253 characters of the longest domain name
+const MaxLength = 512
+
+// ErrInvalidName is returned by CleanDomain for names that can not be converted to ASCII
+var ErrInvalidName = errors.New("not a valid name")
+
+// ValidText returns false for strings the database would reject, invalid UTF-8 or NUL bytes
+func ValidText(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+// checkText returns an error naming the parameter if value is too long or not valid text
+func checkText(name, value string) *model.JSONError {
+	if len(value) > MaxLength {
+		return server.NewFieldError(name, fmt.Sprintf("must be at most %d bytes", MaxLength))
+	}
+	if !ValidText(value) {
+		return server.NewFieldError(name, "must be valid UTF-8 without NUL characters")
+	}
+	return nil
+}
+
+// Path returns the decoded path parameter name
+// every path parameter is read through it so that it is bounded in length and valid text
+func Path(r *http.Request, name string) (string, *model.JSONError) {
+	value := mux.Vars(r)[name]
+	if err := checkText(name, value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Domain returns the path parameter name cleaned with CleanDomain
+func Domain(r *http.Request, name string) (string, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return "", jsonErr
+	}
+	domain, err := CleanDomain(value)
+	if err != nil {
+		return "", server.FieldError(server.ErrInvalidName, name, "is not a valid name")
+	}
+	return domain, nil
+}
+
+// QueryDomain returns the query parameter name cleaned with CleanDomain
+func QueryDomain(r *http.Request, name string) (string, *model.JSONError) {
+	value := r.URL.Query().Get(name)
+	if err := checkText(name, value); err != nil {
+		return "", err
+	}
+	domain, err := CleanDomain(value)
+	if err != nil {
+		return "", server.FieldError(server.ErrInvalidName, name, "is not a valid name")
+	}
+	return domain, nil
+}
+
+// Date returns the path parameter name parsed with server.ParseDateParam as a UTC day
+func Date(r *http.Request, name string) (time.Time, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return time.Time{}, jsonErr
+	}
+	return server.ParseDateParam(name, value)
+}
+
+// QueryDate returns the required query parameter name parsed with server.ParseDateParam as a UTC day
+func QueryDate(r *http.Request, name string) (time.Time, *model.JSONError) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, server.NewFieldError(name, "is required")
+	}
+	if err := checkText(name, value); err != nil {
+		return time.Time{}, err
+	}
+	return server.ParseDateParam(name, value)
+}
+
+// Month returns the path parameter name parsed as a YYYY-MM month, the first day of the month
+func Month(r *http.Request, name string) (time.Time, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return time.Time{}, jsonErr
+	}
+	return parseMonth(name, value)
+}
+
+// QueryMonth returns the required query parameter name parsed as a YYYY-MM month, the first day of the month
+func QueryMonth(r *http.Request, name string) (time.Time, *model.JSONError) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, server.NewFieldError(name, "is required")
+	}
+	if err := checkText(name, value); err != nil {
+		return time.Time{}, err
+	}
+	return parseMonth(name, value)
+}
+
+// parseMonth parses a YYYY-MM month
+func parseMonth(name, value string) (time.Time, *model.JSONError) {
+	month, err := time.Parse("2006-01", value)
+	if err != nil {
+		return time.Time{}, server.NewFieldError(name, "must be a month formatted as YYYY-MM")
+	}
+	return month, nil
+}
+
+// IP returns the path parameter name parsed with server.ParseIPParam
+func IP(r *http.Request, name string) (netip.Addr, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return netip.Addr{}, jsonErr
+	}
+	return server.ParseIPParam(name, value)
+}
+
+// CIDR returns the path parameter name parsed with server.ParseCIDRParam
+func CIDR(r *http.Request, name string, minV4, minV6 int) (netip.Prefix, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return netip.Prefix{}, jsonErr
+	}
+	return server.ParseCIDRParam(name, value, minV4, minV6)
+}
+
+// ASN returns the path parameter name parsed with asn.ParseASN, ex: 64496 or AS64496
+func ASN(r *http.Request, name string) (uint32, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return 0, jsonErr
+	}
+	number, err := asn.ParseASN(value)
+	if err != nil {
+		return 0, server.NewFieldError(name, "is not a valid AS number")
+	}
+	return number, nil
+}
+
+// ID returns the path parameter name as a positive database ID
+func ID(r *http.Request, name string) (int64, *model.JSONError) {
+	value, jsonErr := Path(r, name)
+	if jsonErr != nil {
+		return 0, jsonErr
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, server.NewFieldError(name, "must be a positive ID")
+	}
+	return id, nil
+}
+
+// Int returns the query parameter name as an integer between min and max inclusive
+// def is returned when the parameter is absent or empty
+func Int(r *http.Request, name string, min, max, def int) (int, *model.JSONError) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	if err := checkText(name, value); err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, server.NewFieldError(name, "must be an integer")
+	}
+	if n < min || n > max {
+		return 0, server.NewFieldError(name, fmt.Sprintf("must be between %d and %d", min, max))
+	}
+	return n, nil
+}
+
+// CleanDomain normalizes a name to upper case ASCII as it is stored in the database
+func CleanDomain(domain string) (string, error) {
+	if !ValidText(domain) {
+		return "", ErrInvalidName
+	}
+	domain = strings.TrimSpace(domain)
+	domain, err := idna.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalidName
+	}
+	domain = strings.ToUpper(domain)
+	return domain, nil
+}
@@ -0,0 +1,323 @@
+package params
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// pathRequest returns a request whose path parameter name is value, as mux decodes it
+func pathRequest(name, value string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/", nil), map[string]string{name: value})
+}
+
+func TestPathLength(t *testing.T) {
+	long := strings.Repeat("a", MaxLength+1)
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"empty", "", true},
+		{"at the limit", strings.Repeat("a", MaxLength), true},
+		{"over the limit", long, false},
+		{"10KB label", strings.Repeat("x", 10<<10) + ".com", false},
+		{"NUL", "exa\x00mple.com", false},
+		{"invalid UTF-8", "exa\xffmple.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, jsonErr := Path(pathRequest("domain", tt.value), "domain")
+			if tt.ok != (jsonErr == nil) {
+				t.Fatalf("got error %v", jsonErr)
+			}
+			if jsonErr != nil && (jsonErr.Status != http.StatusBadRequest || jsonErr.Meta["field"] != "domain") {
+				t.Errorf("got error %+v", jsonErr)
+			}
+		})
+	}
+}
+
+func TestDomain(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"example.com", "EXAMPLE.COM"},
+		{" Example.COM ", "EXAMPLE.COM"},
+		{"bücher.example", "XN--BCHER-KVA.EXAMPLE"},
+		{"exa\x00mple.com", ""},
+	}
+	for _, tt := range tests {
+		got, jsonErr := Domain(pathRequest("domain", tt.value), "domain")
+		if tt.want == "" {
+			if jsonErr == nil {
+				t.Errorf("%q: got %q, want an error", tt.value, got)
+			}
+			continue
+		}
+		if jsonErr != nil || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.value, got, jsonErr, tt.want)
+		}
+	}
+}
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		ok    bool
+	}{
+		{"", 100, true},
+		{"limit=10", 10, true},
+		{"limit=-1", 0, false},
+		{"limit=0", 0, false},
+		{"limit=1001", 0, false},
+		{"limit=99999999999999999999", 0, false},
+		{"limit=1e3", 0, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		got, jsonErr := Int(r, "limit", 1, 1000, 100)
+		if tt.ok != (jsonErr == nil) || (tt.ok && got != tt.want) {
+			t.Errorf("%q: got %d, %v", tt.query, got, jsonErr)
+		}
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	long := strings.Repeat("1", MaxLength+1)
+	path := func(accessor func(*http.Request, string) *model.JSONError) func(string) *model.JSONError {
+		return func(value string) *model.JSONError { return accessor(pathRequest("p", value), "p") }
+	}
+	query := func(accessor func(*http.Request, string) *model.JSONError) func(string) *model.JSONError {
+		return func(value string) *model.JSONError {
+			return accessor(httptest.NewRequest(http.MethodGet, "/?p="+url.QueryEscape(value), nil), "p")
+		}
+	}
+	date := func(r *http.Request, name string) *model.JSONError { _, jsonErr := Date(r, name); return jsonErr }
+	queryDate := func(r *http.Request, name string) *model.JSONError { _, jsonErr := QueryDate(r, name); return jsonErr }
+	month := func(r *http.Request, name string) *model.JSONError { _, jsonErr := Month(r, name); return jsonErr }
+	queryMonth := func(r *http.Request, name string) *model.JSONError { _, jsonErr := QueryMonth(r, name); return jsonErr }
+	queryDomain := func(r *http.Request, name string) *model.JSONError {
+		_, jsonErr := QueryDomain(r, name)
+		return jsonErr
+	}
+	asNumber := func(r *http.Request, name string) *model.JSONError { _, jsonErr := ASN(r, name); return jsonErr }
+	id := func(r *http.Request, name string) *model.JSONError { _, jsonErr := ID(r, name); return jsonErr }
+	tests := []struct {
+		name     string
+		accessor func(string) *model.JSONError
+		value    string
+		// the code of the error, empty when the value is accepted
+		want string
+	}{
+		{"date", path(date), "2023-07-04", ""},
+		{"date empty", path(date), "", "invalid_parameter"},
+		{"date overlong", path(date), long, "invalid_parameter"},
+		{"date wrong type", path(date), "yesterday", "invalid_parameter"},
+		{"date out of range", path(date), "1969-12-31", "invalid_parameter"},
+		{"query date", query(queryDate), "20230704", ""},
+		{"query date missing", query(queryDate), "", "invalid_parameter"},
+		{"query date wrong type", query(queryDate), "2023-02-30", "invalid_parameter"},
+		{"month", path(month), "2023-07", ""},
+		{"month empty", path(month), "", "invalid_parameter"},
+		{"month wrong type", path(month), "2023-07-04", "invalid_parameter"},
+		{"month out of range", path(month), "2023-13", "invalid_parameter"},
+		{"query month", query(queryMonth), "2023-07", ""},
+		{"query month missing", query(queryMonth), "", "invalid_parameter"},
+		{"query month overlong", query(queryMonth), long, "invalid_parameter"},
+		{"query domain", query(queryDomain), "example.com", ""},
+		{"query domain overlong", query(queryDomain), long, "invalid_parameter"},
+		{"query domain invalid", query(queryDomain), "xn--bcher-kvaü.example", "invalid_name"},
+		{"asn", path(asNumber), "AS64496", ""},
+		{"asn empty", path(asNumber), "", "invalid_parameter"},
+		{"asn wrong type", path(asNumber), "ASX", "invalid_parameter"},
+		{"asn out of range", path(asNumber), "4294967296", "invalid_parameter"},
+		{"id", path(id), "42", ""},
+		{"id empty", path(id), "", "invalid_parameter"},
+		{"id overlong", path(id), long, "invalid_parameter"},
+		{"id wrong type", path(id), "4.2", "invalid_parameter"},
+		{"id out of range", path(id), "0", "invalid_parameter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonErr := tt.accessor(tt.value)
+			if tt.want == "" {
+				if jsonErr != nil {
+					t.Errorf("%q: got error %+v", tt.value, jsonErr)
+				}
+				return
+			}
+			if jsonErr == nil {
+				t.Fatalf("%q: got no error, want %s", tt.value, tt.want)
+			}
+			if jsonErr.ID != tt.want || jsonErr.Status != http.StatusBadRequest || jsonErr.Meta["field"] != "p" {
+				t.Errorf("%q: got error %+v, want a 400 %s naming p", tt.value, jsonErr, tt.want)
+			}
+		})
+	}
+}
+
+// TestAccessorsHandler serves a handler reading its parameters with the accessors, as the app's handlers do
+func TestAccessorsHandler(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/zones/{zone}/{date}", func(w http.ResponseWriter, r *http.Request) {
+		zone, jsonErr := Domain(r, "zone")
+		if jsonErr != nil {
+			server.WriteJSONError(w, jsonErr)
+			return
+		}
+		date, jsonErr := Date(r, "date")
+		if jsonErr != nil {
+			server.WriteJSONError(w, jsonErr)
+			return
+		}
+		limit, jsonErr := Int(r, "limit", 1, 1000, 100)
+		if jsonErr != nil {
+			server.WriteJSONError(w, jsonErr)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %d", zone, date.Format("2006-01-02"), limit)
+	})
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		// the body of a success, the field named by an error
+		body  string
+		field string
+	}{
+		{name: "ok", target: "/zones/com/2023-07-04?limit=10", want: http.StatusOK, body: "COM 2023-07-04 10"},
+		{name: "default", target: "/zones/b%C3%BCcher/20230704", want: http.StatusOK, body: "XN--BCHER-KVA 2023-07-04 100"},
+		{name: "invalid zone", target: "/zones/a%00b/2023-07-04", want: http.StatusBadRequest, field: "zone"},
+		{name: "invalid date", target: "/zones/com/tomorrow", want: http.StatusBadRequest, field: "date"},
+		{name: "limit out of range", target: "/zones/com/2023-07-04?limit=1001", want: http.StatusBadRequest, field: "limit"},
+		{name: "limit not an integer", target: "/zones/com/2023-07-04?limit=ten", want: http.StatusBadRequest, field: "limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("got %q, want %q", rec.Body.String(), tt.body)
+			}
+			if tt.field != "" {
+				var body model.JSONErrors
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if len(body.Errors) != 1 || body.Errors[0].Meta["field"] != tt.field {
+					t.Errorf("got %s, want an error naming %s", rec.Body, tt.field)
+				}
+			}
+		})
+	}
+}
+
+func FuzzDomain(f *testing.F) {
+	for _, seed := range []string{"example.com", "bücher.example", "xn--bcher-kva.example", "exa\x00mple.com", ".", "a..b", "-a.com", "*.example.com"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		domain, jsonErr := Domain(pathRequest("domain", value), "domain")
+		if jsonErr != nil {
+			if jsonErr.Status != http.StatusBadRequest {
+				t.Fatalf("got status %d", jsonErr.Status)
+			}
+			return
+		}
+		if len(value) > MaxLength {
+			t.Fatalf("accepted %d bytes", len(value))
+		}
+		for i := 0; i < len(domain); i++ {
+			if domain[i] >= utf8.RuneSelf || domain[i] == 0 {
+				t.Fatalf("cleaned name %q is not ASCII", domain)
+			}
+		}
+		if domain != strings.ToUpper(domain) {
+			t.Fatalf("cleaned name %q is not upper case", domain)
+		}
+	})
+}
+
+func FuzzDate(f *testing.F) {
+	for _, seed := range []string{"2023-07-04", "2023-7-4", "20230704", "1688428800", "2023-07-04T12:00:00Z", "2023-02-29", "0000-01-01", "9999-12-31T23:59:59-12:00"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		date, jsonErr := Date(pathRequest("date", value), "date")
+		if jsonErr != nil {
+			if jsonErr.Status != http.StatusBadRequest {
+				t.Fatalf("got status %d", jsonErr.Status)
+			}
+			return
+		}
+		if date.Location() != time.UTC || !date.Equal(date.Truncate(24*time.Hour)) {
+			t.Fatalf("%q: %s is not a UTC day", value, date)
+		}
+		if date.Year() < 1970 || date.Year() > 9999 {
+			t.Fatalf("%q: year %d out of range", value, date.Year())
+		}
+		again, jsonErr := Date(pathRequest("date", date.Format("2006-01-02")), "date")
+		if jsonErr != nil || !again.Equal(date) {
+			t.Fatalf("%q: %s does not parse back: %v", value, date, jsonErr)
+		}
+	})
+}
+
+func FuzzCIDR(f *testing.F) {
+	for _, seed := range []string{"192.0.2.0/24", "192.0.2.1/24", "2001:db8::/32", "::ffff:192.0.2.0/120", "::ffff:0:0/95", "fe80::%eth0/64", "0.0.0.0/0", "192.0.2.0/33"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		prefix, jsonErr := CIDR(pathRequest("cidr", value), "cidr", 8, 32)
+		if jsonErr != nil {
+			if jsonErr.Status != http.StatusBadRequest {
+				t.Fatalf("got status %d", jsonErr.Status)
+			}
+			return
+		}
+		if prefix != prefix.Masked() || prefix.Addr().Is4In6() || prefix.Addr().Zone() != "" {
+			t.Fatalf("%q: %s is not canonical", value, prefix)
+		}
+		if (prefix.Addr().Is4() && prefix.Bits() < 8) || (prefix.Addr().Is6() && prefix.Bits() < 32) {
+			t.Fatalf("%q: %s is shorter than the minimum", value, prefix)
+		}
+		if again, err := netip.ParsePrefix(prefix.String()); err != nil || again != prefix {
+			t.Fatalf("%q: %s does not parse back", value, prefix)
+		}
+	})
+}
+
+func FuzzCheck(f *testing.F) {
+	for _, seed := range []string{"limit=10", "limit=-5&zone=com", "zone=%00", "from=2023-02-29", "month=2023-13", "a=1&a=2", "%zz", "limit=" + strings.Repeat("9", 600)} {
+		f.Add(seed)
+	}
+	queries := Queries{"limit": FormatInt, "zone": FormatDomain, "from": FormatDate, "month": FormatMonth, "q": FormatText}
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.RawQuery = rawQuery
+		w := httptest.NewRecorder()
+		reached := false
+		Check(queries, func(w http.ResponseWriter, r *http.Request) { reached = true })(w, r)
+		if !reached && w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: got status %d without reaching the handler", rawQuery, w.Code)
+		}
+	})
+}
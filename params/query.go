@@ -0,0 +1,111 @@
+package params
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// IgnoredHeader lists the query parameters of a request that its route does not accept
+const IgnoredHeader = "X-Ignored-Parameters"
+
+// Format is the expected format of a query parameter, it is named in the error for values that do not parse
+type Format string
+
+// query parameter formats
+const (
+	FormatText   Format = "text"
+	FormatInt    Format = "integer"
+	FormatDate   Format = "date"
+	FormatMonth  Format = "YYYY-MM month"
+	FormatDomain Format = "domain name"
+)
+
+// formatReasons are the errors of the formats
+var formatReasons = map[Format]string{
+	FormatInt:    "must be an integer",
+	FormatMonth:  "must be a month formatted as YYYY-MM",
+	FormatDomain: "is not a valid name",
+}
+
+// Queries declares the query parameters a route accepts by name
+type Queries map[string]Format
+
+// globalQueries are the query parameters every route accepts, read by the server's middleware
+var globalQueries = Queries{
+	// records the response, see server.SetRecordingStore
+	"record": FormatInt,
+}
+
+// Check parses the query parameters declared in queries before next runs
+// a value that does not parse is rejected with a 400 naming the parameter and its expected format,
+// parameters that are not declared are listed in the X-Ignored-Parameters response header
+// handlers still read the parameters with the accessors to apply defaults and bounds
+func Check(queries Queries, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ignored []string
+		for name, values := range r.URL.Query() {
+			format, ok := queries[name]
+			if !ok {
+				format, ok = globalQueries[name]
+			}
+			if !ok {
+				ignored = append(ignored, name)
+				continue
+			}
+			for _, value := range values {
+				if jsonErr := checkFormat(name, value, format); jsonErr != nil {
+					server.WriteJSONError(w, jsonErr)
+					return
+				}
+			}
+		}
+		if len(ignored) > 0 {
+			for i, name := range ignored {
+				// names that would break the list are quoted
+				if !ValidText(name) || strings.ContainsAny(name, "\",\r\n") {
+					ignored[i] = strconv.Quote(name)
+				}
+			}
+			sort.Strings(ignored)
+			w.Header().Set(IgnoredHeader, strings.Join(ignored, ", "))
+		}
+		next(w, r)
+	}
+}
+
+// checkFormat returns an error naming the parameter and format if value does not parse
+// empty values are accepted, they are treated as absent by the accessors
+func checkFormat(name, value string, format Format) *model.JSONError {
+	if value == "" {
+		return nil
+	}
+	var jsonErr *model.JSONError
+	if jsonErr = checkText(name, value); jsonErr == nil {
+		var err error
+		switch format {
+		case FormatInt:
+			_, err = strconv.Atoi(value)
+		case FormatDate:
+			_, jsonErr = server.ParseDateParam(name, value)
+		case FormatMonth:
+			_, err = time.Parse("2006-01", value)
+		case FormatDomain:
+			_, err = CleanDomain(value)
+		}
+		if err != nil && format == FormatDomain {
+			jsonErr = server.FieldError(server.ErrInvalidName, name, formatReasons[format])
+		} else if err != nil {
+			jsonErr = server.NewFieldError(name, formatReasons[format])
+		}
+	}
+	if jsonErr != nil {
+		jsonErr.Meta["expected"] = string(format)
+	}
+	return jsonErr
+}
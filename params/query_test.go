@@ -0,0 +1,68 @@
+package params
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+func TestCheck(t *testing.T) {
+	queries := Queries{"limit": FormatInt, "zone": FormatDomain, "from": FormatDate, "month": FormatMonth, "q": FormatText}
+	tests := []struct {
+		name     string
+		rawQuery string
+		want     int
+		// the code and meta of a rejected request
+		code string
+		meta map[string]string
+		// the X-Ignored-Parameters header of an accepted request
+		ignored string
+	}{
+		{name: "no parameters", want: http.StatusOK},
+		{name: "every format", rawQuery: "limit=10&zone=com&from=2023-07-04&month=2023-07&q=text", want: http.StatusOK},
+		{name: "empty values", rawQuery: "limit=&zone=", want: http.StatusOK},
+		{name: "global parameter", rawQuery: "record=1", want: http.StatusOK},
+		{name: "ignored parameters", rawQuery: "limit=10&sort=name&Limit=5", want: http.StatusOK, ignored: "Limit, sort"},
+		{name: "ignored names quoted", rawQuery: "a%2Cb=1&c%22=2", want: http.StatusOK, ignored: `"a,b", "c\""`},
+		{name: "not an integer", rawQuery: "limit=ten", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "limit", "expected": "integer"}},
+		{name: "second value not an integer", rawQuery: "limit=1&limit=x", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "limit"}},
+		{name: "not a date", rawQuery: "from=2023-02-30", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "from", "expected": "date"}},
+		{name: "not a month", rawQuery: "month=2023-13", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "month", "expected": "YYYY-MM month"}},
+		{name: "not a domain", rawQuery: "zone=xn--bcher-kva%C3%BC", want: http.StatusBadRequest, code: "invalid_name", meta: map[string]string{"field": "zone", "expected": "domain name"}},
+		{name: "not text", rawQuery: "q=a%00b", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "q", "expected": "text"}},
+		{name: "invalid global parameter", rawQuery: "record=yes", want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "record"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/zones/com/domains", nil)
+			r.URL.RawQuery = tt.rawQuery
+			w := httptest.NewRecorder()
+			reached := false
+			Check(queries, func(w http.ResponseWriter, r *http.Request) { reached = true })(w, r)
+			if reached != (tt.want == http.StatusOK) || w.Code != tt.want {
+				t.Fatalf("got status %d %s, reached the handler: %t, want %d", w.Code, w.Body, reached, tt.want)
+			}
+			if got := w.Header().Get(IgnoredHeader); got != tt.ignored {
+				t.Errorf("got %s %q, want %q", IgnoredHeader, got, tt.ignored)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body model.JSONErrors
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].ID != tt.code {
+				t.Fatalf("got %s, want the error %s", w.Body, tt.code)
+			}
+			for k, v := range tt.meta {
+				if body.Errors[0].Meta[k] != v {
+					t.Errorf("meta %s is %q, want %q", k, body.Errors[0].Meta[k], v)
+				}
+			}
+		})
+	}
+}
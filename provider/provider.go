@@ -0,0 +1,137 @@
+// Package provider classifies nameservers by the DNS hosting provider operating them
+//
+// Providers are matched by nameserver name suffix on label boundaries, the longest matching suffix wins,
+// ex: with the patterns "EXAMPLE.NET" and "DNS.EXAMPLE.NET" the nameserver NS1.DNS.EXAMPLE.NET matches the latter
+// and NS1.OTHER-EXAMPLE.NET matches neither.
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Pattern maps a nameserver name suffix to a provider
+type Pattern struct {
+	Suffix   string
+	Provider string
+}
+
+// Defaults are the patterns of the largest DNS hosting providers
+var Defaults = append(route53Patterns(), []Pattern{
+	{"NS.CLOUDFLARE.COM", "Cloudflare"},
+	{"DOMAINCONTROL.COM", "GoDaddy"},
+	{"GOOGLEDOMAINS.COM", "Google"},
+	{"GOOGLE.COM", "Google"},
+	{"AZURE-DNS.COM", "Microsoft Azure"},
+	{"AZURE-DNS.NET", "Microsoft Azure"},
+	{"AZURE-DNS.ORG", "Microsoft Azure"},
+	{"AZURE-DNS.INFO", "Microsoft Azure"},
+	{"REGISTRAR-SERVERS.COM", "Namecheap"},
+	{"NAME-SERVICES.COM", "Enom"},
+	{"DNSMADEEASY.COM", "DNS Made Easy"},
+	{"NSONE.NET", "NS1"},
+	{"ULTRADNS.COM", "UltraDNS"},
+	{"ULTRADNS.NET", "UltraDNS"},
+	{"ULTRADNS.ORG", "UltraDNS"},
+	{"DYNECT.NET", "Dyn"},
+	{"WIXDNS.NET", "Wix"},
+	{"SQUARESPACEDNS.COM", "Squarespace"},
+	{"HOSTGATOR.COM", "HostGator"},
+	{"BLUEHOST.COM", "Bluehost"},
+	{"DREAMHOST.COM", "DreamHost"},
+	{"UI-DNS.COM", "IONOS"},
+	{"UI-DNS.DE", "IONOS"},
+	{"UI-DNS.ORG", "IONOS"},
+	{"UI-DNS.BIZ", "IONOS"},
+	{"OVH.NET", "OVHcloud"},
+	{"HETZNER.COM", "Hetzner"},
+	{"DIGITALOCEAN.COM", "DigitalOcean"},
+	{"LINODE.COM", "Akamai Linode"},
+	{"AKAM.NET", "Akamai"},
+	{"PARKINGCREW.NET", "ParkingCrew"},
+	{"SEDOPARKING.COM", "Sedo"},
+}...)
+
+// route53Patterns returns the patterns of the Route 53 nameserver domains AWSDNS-00.COM to AWSDNS-63.CO.UK
+func route53Patterns() []Pattern {
+	var patterns []Pattern
+	for i := 0; i < 64; i++ {
+		for _, tld := range []string{"COM", "NET", "ORG", "CO.UK"} {
+			patterns = append(patterns, Pattern{fmt.Sprintf("AWSDNS-%02d.%s", i, tld), "Amazon Route 53"})
+		}
+	}
+	return patterns
+}
+
+// Classifier maps nameserver names to providers
+type Classifier struct {
+	// provider by upper case suffix
+	suffixes map[string]string
+	patterns []Pattern
+}
+
+// normalize returns the name as stored in the database, upper case without the trailing dot
+func normalize(name string) string {
+	return strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// New returns a classifier for the patterns, suffixes must be unique
+func New(patterns []Pattern) (*Classifier, error) {
+	c := &Classifier{suffixes: make(map[string]string, len(patterns))}
+	for _, p := range patterns {
+		suffix := normalize(p.Suffix)
+		if suffix == "" || p.Provider == "" {
+			return nil, fmt.Errorf("provider pattern %q: suffix and provider must not be empty", p.Suffix)
+		}
+		if _, dup := c.suffixes[suffix]; dup {
+			return nil, fmt.Errorf("provider pattern %q: duplicate suffix", p.Suffix)
+		}
+		c.suffixes[suffix] = p.Provider
+		c.patterns = append(c.patterns, Pattern{Suffix: suffix, Provider: p.Provider})
+	}
+	return c, nil
+}
+
+// Classify returns the provider of the nameserver name, or "" if no pattern matches
+func (c *Classifier) Classify(name string) string {
+	name = normalize(name)
+	for name != "" {
+		if provider, ok := c.suffixes[name]; ok {
+			return provider
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return ""
+}
+
+// Patterns returns the normalized patterns of the classifier
+func (c *Classifier) Patterns() []Pattern {
+	return append([]Pattern(nil), c.patterns...)
+}
+
+// Table holds the current classifier, it is replaced when the config is reloaded
+type Table struct {
+	v atomic.Value
+}
+
+// NewTable returns a table holding c
+func NewTable(c *Classifier) *Table {
+	t := &Table{}
+	t.Store(c)
+	return t
+}
+
+// Load returns the current classifier
+func (t *Table) Load() *Classifier {
+	return t.v.Load().(*Classifier)
+}
+
+// Store replaces the current classifier
+func (t *Table) Store(c *Classifier) {
+	t.v.Store(c)
+}
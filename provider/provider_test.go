@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	c, err := New(append(Defaults, Pattern{"example.net.", "Example"}, Pattern{"DNS.EXAMPLE.NET", "Example DNS"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ns1.example.net", "Example"},
+		{"NS1.DNS.EXAMPLE.NET.", "Example DNS"},
+		{"example.net", "Example"},
+		{"ns1.other-example.net", ""},
+		{"ns1.example.network", ""},
+		{"ns-1.awsdns-07.co.uk", "Amazon Route 53"},
+		{"ns-1.awsdns-64.com", ""},
+		{"alice.ns.cloudflare.com", "Cloudflare"},
+		{"cloudflare.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []Pattern
+		wantErr  string
+	}{
+		{name: "defaults", patterns: Defaults},
+		{name: "duplicate", patterns: []Pattern{{"EXAMPLE.NET", "A"}, {"example.net.", "B"}}, wantErr: "duplicate suffix"},
+		{name: "empty suffix", patterns: []Pattern{{" . ", "A"}}, wantErr: "must not be empty"},
+		{name: "empty provider", patterns: []Pattern{{"EXAMPLE.NET", ""}}, wantErr: "must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.patterns)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range c.Patterns() {
+				if p.Suffix != strings.ToUpper(p.Suffix) {
+					t.Errorf("pattern %q is not normalized", p.Suffix)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Package refcache caches reference data, small lookups such as the list of zones that change with imports at most
+//
+// A Value is loaded on first read and then only by Refresh, which loads the new value while readers keep getting
+// the current one and swaps it in atomically, so that readers never wait once a value was loaded.
+// Concurrent first reads share one load.
+package refcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the state of a Value
+type Status struct {
+	Name string
+	// when the current value was loaded, zero before the first load
+	RefreshedAt time.Time
+	// the error of the last load, empty when it succeeded
+	Error string
+}
+
+// Refresher is a Value of any type, as kept by a Group
+type Refresher interface {
+	Refresh(ctx context.Context) error
+	Status() Status
+}
+
+// Value is a read-through cache of one value of type T, see the package documentation
+type Value[T any] struct {
+	name string
+	load func(ctx context.Context) (T, error)
+	// nil until the first load succeeded
+	current atomic.Pointer[snapshot[T]]
+	// held by the load in flight
+	mu sync.Mutex
+	// string, the error of the last load
+	lastErr atomic.Value
+}
+
+// snapshot is a loaded value and when it was loaded
+type snapshot[T any] struct {
+	value T
+	at    time.Time
+}
+
+// New returns a Value named name loaded by load, load should not return values that are modified afterwards
+// as readers share them
+func New[T any](name string, load func(ctx context.Context) (T, error)) *Value[T] {
+	return &Value[T]{name: name, load: load}
+}
+
+// Get returns the current value, it is loaded first unless a load succeeded before
+func (v *Value[T]) Get(ctx context.Context) (T, error) {
+	if s := v.current.Load(); s != nil {
+		return s.value, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// loaded by the read that held the lock before
+	if s := v.current.Load(); s != nil {
+		return s.value, nil
+	}
+	return v.refreshLocked(ctx)
+}
+
+// Refresh loads the value again and swaps it in, the readers get the current value in the meantime
+// and keep it if the load fails
+func (v *Value[T]) Refresh(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, err := v.refreshLocked(ctx)
+	return err
+}
+
+func (v *Value[T]) refreshLocked(ctx context.Context) (T, error) {
+	value, err := v.load(ctx)
+	if err != nil {
+		v.lastErr.Store(err.Error())
+		var zero T
+		return zero, err
+	}
+	v.lastErr.Store("")
+	v.current.Store(&snapshot[T]{value: value, at: time.Now()})
+	return value, nil
+}
+
+// Status returns when the value was loaded and the error of the last load, it does not wait for a load in flight
+func (v *Value[T]) Status() Status {
+	lastErr, _ := v.lastErr.Load().(string)
+	status := Status{Name: v.name, Error: lastErr}
+	if s := v.current.Load(); s != nil {
+		status.RefreshedAt = s.at
+	}
+	return status
+}
+
+// Group refreshes several values together, such as after every import
+type Group struct {
+	mu     sync.Mutex
+	values []Refresher
+}
+
+// Add adds a value to the group
+func (g *Group) Add(r Refresher) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = append(g.values, r)
+}
+
+// Refresh refreshes every value of the group one after the other, a failed value does not stop the others
+// and the errors are returned together
+func (g *Group) Refresh(ctx context.Context) error {
+	g.mu.Lock()
+	values := append([]Refresher(nil), g.values...)
+	g.mu.Unlock()
+	var errs []error
+	for _, r := range values {
+		err := r.Refresh(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Status().Name, err))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// Status returns the status of every value of the group by name
+func (g *Group) Status() []Status {
+	g.mu.Lock()
+	values := append([]Refresher(nil), g.values...)
+	g.mu.Unlock()
+	statuses := make([]Status, 0, len(values))
+	for _, r := range values {
+		statuses = append(statuses, r.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// joinErrors returns the errors as one, nil when there are none
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msg := errs[0].Error()
+	for _, err := range errs[1:] {
+		msg += "; " + err.Error()
+	}
+	return errors.New(msg)
+}
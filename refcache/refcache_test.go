@@ -0,0 +1,179 @@
+package refcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestValueGetShared shares one load between the concurrent first reads
+func TestValueGetShared(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	v := New("zones", func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return 42, nil
+	})
+	var wg sync.WaitGroup
+	got := make([]int, 8)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := v.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = value
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("loaded %d times, want 1", loads)
+	}
+	for i, value := range got {
+		if value != 42 {
+			t.Errorf("read %d got %d, want 42", i, value)
+		}
+	}
+}
+
+func TestValueRefresh(t *testing.T) {
+	unavailable := errors.New("database unavailable")
+	tests := []struct {
+		name string
+		// the results of the successive loads, a Get then a Refresh then a Get
+		loads     []error
+		wantFirst error
+		// the value and status after the refresh
+		want       int
+		wantErr    string
+		wantLoaded bool
+	}{
+		{name: "refreshed", loads: []error{nil, nil}, want: 2, wantLoaded: true},
+		{name: "failed refresh keeps the value", loads: []error{nil, unavailable}, want: 1, wantErr: "database unavailable", wantLoaded: true},
+		// a failed first load is tried again by the refresh
+		{name: "failed first load", loads: []error{unavailable, nil}, wantFirst: unavailable, want: 2, wantLoaded: true},
+		{name: "never loaded", loads: []error{unavailable, unavailable, unavailable}, wantFirst: unavailable, wantErr: "database unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int
+			v := New("zones", func(ctx context.Context) (int, error) {
+				err := tt.loads[n]
+				n++
+				if err != nil {
+					return 0, err
+				}
+				return n, nil
+			})
+			if status := v.Status(); !status.RefreshedAt.IsZero() || status.Error != "" || status.Name != "zones" {
+				t.Errorf("got status %+v before the first load", status)
+			}
+			if _, err := v.Get(context.Background()); err != tt.wantFirst {
+				t.Fatalf("got error %v, want %v", err, tt.wantFirst)
+			}
+			if err := v.Refresh(context.Background()); (err != nil) != (tt.wantErr != "") {
+				t.Errorf("got refresh error %v, want %q", err, tt.wantErr)
+			}
+			status := v.Status()
+			if status.Error != tt.wantErr || status.RefreshedAt.IsZero() == tt.wantLoaded {
+				t.Errorf("got status %+v, want error %q and loaded %t", status, tt.wantErr, tt.wantLoaded)
+			}
+			got, err := v.Get(context.Background())
+			if tt.wantLoaded && (err != nil || got != tt.want) {
+				t.Errorf("got %d, %v, want %d", got, err, tt.want)
+			}
+			if !tt.wantLoaded && err == nil {
+				t.Errorf("got %d, want an error", got)
+			}
+		})
+	}
+}
+
+// TestValueRefreshConcurrentReads keeps serving the current value while a refresh loads
+func TestValueRefreshConcurrentReads(t *testing.T) {
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	var n int32
+	v := New("zones", func(ctx context.Context) (int32, error) {
+		loads := atomic.AddInt32(&n, 1)
+		if loads > 1 {
+			close(loading)
+			<-release
+		}
+		return loads, nil
+	})
+	if _, err := v.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- v.Refresh(context.Background()) }()
+	<-loading
+	if got, err := v.Get(context.Background()); err != nil || got != 1 {
+		t.Errorf("got %d, %v during the refresh, want 1", got, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := v.Get(context.Background()); got != 2 {
+		t.Errorf("got %d after the refresh, want 2", got)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	unavailable := errors.New("database unavailable")
+	tests := []struct {
+		name string
+		// the load errors of the values named zones, labels and zone_ids
+		errs    map[string]error
+		wantErr string
+	}{
+		{name: "refreshed"},
+		{name: "one fails", errs: map[string]error{"labels": unavailable}, wantErr: "labels: database unavailable"},
+		{name: "several fail", errs: map[string]error{"zones": unavailable, "zone_ids": unavailable},
+			wantErr: "zones: database unavailable; zone_ids: database unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g Group
+			var loaded []string
+			for _, name := range []string{"zones", "labels", "zone_ids"} {
+				name := name
+				g.Add(New(name, func(ctx context.Context) (string, error) {
+					loaded = append(loaded, name)
+					return name, tt.errs[name]
+				}))
+			}
+			var got string
+			if err := g.Refresh(context.Background()); err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("got error %q, want %q", got, tt.wantErr)
+			}
+			// a failed value does not stop the others
+			if len(loaded) != 3 {
+				t.Errorf("loaded %q, want every value", loaded)
+			}
+			statuses := g.Status()
+			wantNames := []string{"labels", "zone_ids", "zones"}
+			if len(statuses) != len(wantNames) {
+				t.Fatalf("got statuses %+v, want %q", statuses, wantNames)
+			}
+			for i, status := range statuses {
+				failed := tt.errs[status.Name] != nil
+				if status.Name != wantNames[i] || (status.Error != "") != failed || status.RefreshedAt.IsZero() != failed {
+					t.Errorf("status %d: got %+v, want %s failed %t", i, status, wantNames[i], failed)
+				}
+			}
+		})
+	}
+}
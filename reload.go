@@ -0,0 +1,139 @@
+package main
+
+import (
+	"dnscoffee/app"
+	"dnscoffee/config"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/provider"
+	"dnscoffee/server"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloader re-reads the config file and applies the settings that can change at runtime
+type reloader struct {
+	mu     sync.Mutex
+	path   string
+	strict bool
+	conf   *config.Config
+	ds     slowQueryThresholder
+	server *server.Server
+	// nameserver provider patterns
+	providers *provider.Table
+	ttls      *app.CacheTTLs
+}
+
+// slowQueryThresholder sets the threshold of the slow query log, *datastore.DataStore is one, tests can give a fake
+type slowQueryThresholder interface {
+	SetSlowQueryThreshold(d time.Duration)
+}
+
+// reload loads and validates the config, an invalid config leaves the running settings unchanged
+func (rl *reloader) reload() (*model.ConfigReload, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	conf, err := config.Load(rl.path, rl.strict)
+	if err != nil {
+		return nil, err
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		problems := make([]string, 0, len(errs))
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+
+	// everything that can fail is checked before anything is applied, so a reload applies the whole config or none of it
+	level, err := logging.ParseLevel(conf.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	classifier, err := conf.Classifier()
+	if err != nil {
+		return nil, fmt.Errorf("providers: %w", err)
+	}
+	err = server.CheckRateLimit(conf.API.RequestsPerMinute, conf.API.RequestsBurst, conf.API.RateLimitMode)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+	err = server.CheckAllowlists(conf.API.AdminAllowCIDRs, conf.API.InternalAllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("allowlists: %w", err)
+	}
+	// opening the log output is the only step left that can fail, it goes first
+	err = logging.SetOutput(conf.Log.Output)
+	if err != nil {
+		return nil, fmt.Errorf("log output: %w", err)
+	}
+
+	applied, restart := config.Changes(rl.conf, conf)
+	logging.SetLevel(level)
+	rl.server.SetAccessLogSampling(conf.Log.SampleRate, time.Duration(conf.Log.SlowRequestThreshold), conf.Log.SampleNotFound)
+	// the checks above leave these nothing to fail on
+	logApplyError("rate limit", rl.server.SetRateLimit(conf.API.RequestsPerMinute, conf.API.RequestsBurst))
+	logApplyError("rate limit mode", rl.server.SetRateLimitMode(conf.API.RateLimitMode))
+	logApplyError("allowlists", rl.server.SetAllowlists(conf.API.AdminAllowCIDRs, conf.API.InternalAllowCIDRs))
+	rl.ds.SetSlowQueryThreshold(time.Duration(conf.Database.SlowQueryThreshold))
+	rl.server.SetLoadShedding(conf.LoadShedding.Server())
+	rl.server.SetDisabledRoutes(conf.API.DisabledRoutes)
+	rl.providers.Store(classifier)
+	rl.ttls.Store(conf.CacheTTLs())
+	rl.server.RefreshReferenceData()
+
+	// settings needing a restart keep their running values, so they are reported again on the next reload
+	for _, name := range applied {
+		logging.Infof("reload: applied %s", name)
+	}
+	for _, name := range restart {
+		logging.Warnf("reload: %s changed but requires a restart, ignored", name)
+	}
+	rl.conf.API.RequestsPerMinute = conf.API.RequestsPerMinute
+	rl.conf.API.RequestsBurst = conf.API.RequestsBurst
+	rl.conf.API.RateLimitMode = conf.API.RateLimitMode
+	rl.conf.API.AdminAllowCIDRs = conf.API.AdminAllowCIDRs
+	rl.conf.API.InternalAllowCIDRs = conf.API.InternalAllowCIDRs
+	rl.conf.API.DisabledRoutes = conf.API.DisabledRoutes
+	rl.conf.Database.SlowQueryThreshold = conf.Database.SlowQueryThreshold
+	rl.conf.Log = conf.Log
+	rl.conf.LoadShedding = conf.LoadShedding
+	rl.conf.Providers = conf.Providers
+	rl.conf.API.FeedCacheTTL = conf.API.FeedCacheTTL
+	rl.conf.LiveDNS.CacheTTL = conf.LiveDNS.CacheTTL
+
+	return &model.ConfigReload{Applied: nonNil(applied), Ignored: nonNil(restart)}, nil
+}
+
+// applyLogConfig sets the log level and output
+func applyLogConfig(c config.LogConfig) error {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	err = logging.SetOutput(c.Output)
+	if err != nil {
+		return fmt.Errorf("log output: %w", err)
+	}
+	logging.SetLevel(level)
+	return nil
+}
+
+// logApplyError logs err, from applying a setting that was checked beforehand and should not fail
+func logApplyError(name string, err error) {
+	if err != nil {
+		logging.Errorf("reload: %s was checked but failed to apply: %s", name, err)
+	}
+}
+
+// nonNil returns an empty slice for nil so it is encoded as [] instead of null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"dnscoffee/app"
+	"dnscoffee/config"
+	"dnscoffee/provider"
+	"dnscoffee/server"
+)
+
+// slowQueries records the slow query threshold in place of the datastore
+type slowQueries struct {
+	threshold time.Duration
+}
+
+func (s *slowQueries) SetSlowQueryThreshold(d time.Duration) {
+	s.threshold = d
+}
+
+// testReloader starts a server from the config at path and returns its base URL and a reloader of the config
+func testReloader(t *testing.T, path string) (string, *reloader) {
+	t.Helper()
+	conf, err := config.Load(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.New([]string{"127.0.0.1:0"}, conf.Server())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Get("/api/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	go s.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.ListenAddrs()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	classifier, err := conf.Classifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := &reloader{
+		path:      path,
+		strict:    true,
+		conf:      conf,
+		ds:        &slowQueries{},
+		server:    s,
+		providers: provider.NewTable(classifier),
+		ttls:      app.NewCacheTTLs(conf.CacheTTLs()),
+	}
+	return "http://" + s.ListenAddrs()[0], rl
+}
+
+// ping sends a request from client through the trusted proxy and returns its status and Retry-After
+func ping(t *testing.T, url, client string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url+"/api/ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", client)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Retry-After")
+}
+
+func writeTestConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReloadRateLimit reloads a changed per-minute quota, the requests after the reload are limited by the new quota
+func TestReloadRateLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeTestConfig(t, path, `{"Http": {"Trusted_Proxies": ["127.0.0.1/32"]}, "API": {"Requests_Per_Minute": 1, "Requests_Burst": 0}}`)
+	url, rl := testReloader(t, path)
+
+	if status, _ := ping(t, url, "192.0.2.1"); status != http.StatusNoContent {
+		t.Fatalf("first request: status %d, want %d", status, http.StatusNoContent)
+	}
+	if status, retry := ping(t, url, "192.0.2.1"); status != http.StatusTooManyRequests || retry != "60" {
+		t.Fatalf("second request: status %d retry after %q, want %d after 60", status, retry, http.StatusTooManyRequests)
+	}
+
+	// 1200 a minute is one request every 50ms
+	writeTestConfig(t, path, `{"Http": {"Trusted_Proxies": ["127.0.0.1/32"]}, "API": {"Requests_Per_Minute": 1200, "Requests_Burst": 0, "Feed_Cache_TTL": "1m"}, "Live_DNS": {"Cache_TTL": "2m"}}`)
+	result, err := rl.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"API.Requests_Per_Minute": true, "API.Feed_Cache_TTL": true, "Live_DNS.Cache_TTL": true}
+	if len(result.Applied) != len(want) || len(result.Ignored) != 0 {
+		t.Errorf("applied %v and ignored %v, want %v", result.Applied, result.Ignored, want)
+	}
+	for _, name := range result.Applied {
+		if !want[name] {
+			t.Errorf("applied %s, want %v", name, want)
+		}
+	}
+	if ttls := rl.ttls.Load(); ttls.Feed != time.Minute || ttls.LiveDNS != 2*time.Minute {
+		t.Errorf("cache TTLs %+v after the reload, want 1m and 2m", ttls)
+	}
+
+	if status, _ := ping(t, url, "192.0.2.2"); status != http.StatusNoContent {
+		t.Fatalf("first request after the reload: status %d, want %d", status, http.StatusNoContent)
+	}
+	if status, retry := ping(t, url, "192.0.2.2"); status != http.StatusTooManyRequests || retry != "1" {
+		t.Fatalf("second request after the reload: status %d retry after %q, want %d after 1", status, retry, http.StatusTooManyRequests)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status, _ := ping(t, url, "192.0.2.2"); status != http.StatusNoContent {
+		t.Errorf("request after the new interval: status %d, want %d", status, http.StatusNoContent)
+	}
+}
+
+// TestReloadInvalid rejects a config with an invalid setting without applying any of the valid ones
+func TestReloadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeTestConfig(t, path, `{"Http": {"Trusted_Proxies": ["127.0.0.1/32"]}, "API": {"Requests_Per_Minute": 1, "Requests_Burst": 0}}`)
+	url, rl := testReloader(t, path)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid mode", content: `{"API": {"Requests_Per_Minute": 1200, "Rate_Limit_Mode": "strict", "Feed_Cache_TTL": "1m"}}`},
+		{name: "invalid allowlist", content: `{"API": {"Requests_Per_Minute": 1200, "Admin_Allow_CIDRs": ["10.0.0.0"], "Feed_Cache_TTL": "1m"}}`},
+		{name: "unwritable log output", content: `{"API": {"Requests_Per_Minute": 1200, "Feed_Cache_TTL": "1m"}, "Log": {"Output": "` + filepath.Join(t.TempDir(), "missing", "log") + `"}}`},
+	}
+	ttls := rl.ttls.Load()
+	for i, test := range tests {
+		writeTestConfig(t, path, test.content)
+		if _, err := rl.reload(); err == nil {
+			t.Errorf("%s: reload succeeded", test.name)
+		}
+		if got := rl.ttls.Load(); got != ttls {
+			t.Errorf("%s: cache TTLs %+v, want %+v", test.name, got, ttls)
+		}
+		// the quota is still one request a minute
+		client := "192.0.2." + strconv.Itoa(i+1)
+		ping(t, url, client)
+		if status, retry := ping(t, url, client); status != http.StatusTooManyRequests || retry != "60" {
+			t.Errorf("%s: second request: status %d retry after %q, want %d after 60", test.name, status, retry, http.StatusTooManyRequests)
+		}
+	}
+}
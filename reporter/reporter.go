@@ -0,0 +1,130 @@
+// Package reporter implements server.ErrorReporter backends
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"dnscoffee/server"
+	"dnscoffee/version"
+)
+
+// client is used by all reporters, requests are also bounded by the report context
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Webhook posts every report as JSON to a URL
+type Webhook struct {
+	URL string
+}
+
+// Report implements server.ErrorReporter
+func (wh *Webhook) Report(ctx context.Context, report *server.ErrorReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return post(ctx, wh.URL, body, nil)
+}
+
+// Sentry sends reports to a Sentry project using its store API
+type Sentry struct {
+	storeURL string
+	auth     string
+}
+
+// NewSentry parses a Sentry DSN, ex: https://<key>@sentry.example.com/<project>
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry DSN is missing the public key")
+	}
+	project := path.Base(u.Path)
+	if project == "" || project == "/" || project == "." {
+		return nil, fmt.Errorf("sentry DSN is missing the project ID")
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=dnscoffee/%s, sentry_key=%s", version.Version, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	store := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   path.Join(path.Dir(u.Path), "api", project, "store") + "/",
+	}
+	return &Sentry{storeURL: store.String(), auth: auth}, nil
+}
+
+// Report implements server.ErrorReporter
+func (s *Sentry) Report(ctx context.Context, report *server.ErrorReport) error {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return err
+	}
+	query := make([]string, 0, len(report.Query))
+	for k, v := range report.Query {
+		query = append(query, url.QueryEscape(k)+"="+url.QueryEscape(v))
+	}
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   report.Time.Format(time.RFC3339),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "dnscoffee",
+		"release":     version.Version,
+		"message":     report.Error,
+		"transaction": fmt.Sprintf("%s %s", report.Method, report.Route),
+		"tags": map[string]string{
+			"route":      report.Route,
+			"request_id": report.RequestID,
+		},
+		"user": map[string]string{"ip_address": report.ClientIP},
+		"request": map[string]string{
+			"method":       report.Method,
+			"url":          report.Path,
+			"query_string": strings.Join(query, "&"),
+		},
+		"extra": map[string]string{"stack": report.Stack},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.storeURL, body, map[string]string{"X-Sentry-Auth": s.auth})
+}
+
+// post sends a JSON body and fails on non 2xx responses
+func post(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Package reqstats collects the database and cache work done for a single request
+// the collector is carried in the request context, when collection is not enabled
+// no collector is created and the instrumented packages skip recording
+package reqstats
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// enabled is set once by Enable before the server starts
+var enabled bool
+
+// Enable turns on collection for the requests given a collector with WithStats
+func Enable() {
+	enabled = true
+}
+
+// Enabled returns true if requests may carry a collector
+func Enabled() bool {
+	return enabled
+}
+
+// Stats counts the work done for a request, it is safe for concurrent use
+type Stats struct {
+	queries     int64
+	dbTime      int64 // time.Duration
+	rows        int64
+	cacheHits   int64
+	cacheMisses int64
+}
+
+type statsKey struct{}
+
+// WithStats returns a context carrying a new collector
+func WithStats(ctx context.Context) (context.Context, *Stats) {
+	s := &Stats{}
+	return context.WithValue(ctx, statsKey{}, s), s
+}
+
+// FromContext returns the collector of the request, nil when it has none
+// the methods of a nil collector do nothing
+func FromContext(ctx context.Context) *Stats {
+	if !enabled {
+		return nil
+	}
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// Query records a query that took took and returned or changed rows rows
+func (s *Stats) Query(took time.Duration, rows int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.queries, 1)
+	atomic.AddInt64(&s.dbTime, int64(took))
+	atomic.AddInt64(&s.rows, int64(rows))
+}
+
+// Cache records a cache lookup
+func (s *Stats) Cache(hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		atomic.AddInt64(&s.cacheHits, 1)
+	} else {
+		atomic.AddInt64(&s.cacheMisses, 1)
+	}
+}
+
+// Rows returns the rows returned or changed by the queries recorded so far
+func (s *Stats) Rows() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.rows)
+}
+
+// String formats the counts for the X-Debug-Stats header
+// ex: queries=3; db_ms=12.4; rows=120; cache_hits=0; cache_misses=1
+func (s *Stats) String() string {
+	dbTime := time.Duration(atomic.LoadInt64(&s.dbTime))
+	return fmt.Sprintf("queries=%d; db_ms=%.1f; rows=%d; cache_hits=%d; cache_misses=%d",
+		atomic.LoadInt64(&s.queries), float64(dbTime)/float64(time.Millisecond), atomic.LoadInt64(&s.rows),
+		atomic.LoadInt64(&s.cacheHits), atomic.LoadInt64(&s.cacheMisses))
+}
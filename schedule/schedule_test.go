@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// a Monday
+	from := time.Date(2023, 5, 15, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"@every 6h", []string{"2023-05-15T16:30:20Z", "2023-05-15T22:30:20Z"}},
+		{"@hourly", []string{"2023-05-15T11:00:00Z", "2023-05-15T12:00:00Z"}},
+		{"@daily", []string{"2023-05-16T00:00:00Z", "2023-05-17T00:00:00Z"}},
+		{"@weekly", []string{"2023-05-21T00:00:00Z", "2023-05-28T00:00:00Z"}},
+		{"@monthly", []string{"2023-06-01T00:00:00Z", "2023-07-01T00:00:00Z"}},
+		{"*/15 * * * *", []string{"2023-05-15T10:45:00Z", "2023-05-15T11:00:00Z"}},
+		{"30 10 * * *", []string{"2023-05-16T10:30:00Z", "2023-05-17T10:30:00Z"}},
+		{"0 9-17/4 * * 1-5", []string{"2023-05-15T13:00:00Z", "2023-05-15T17:00:00Z", "2023-05-16T09:00:00Z"}},
+		{"0 0 * * 6,0", []string{"2023-05-20T00:00:00Z", "2023-05-21T00:00:00Z", "2023-05-27T00:00:00Z"}},
+		// with both day fields restricted either matches
+		{"0 0 1 * 3", []string{"2023-05-17T00:00:00Z", "2023-05-24T00:00:00Z", "2023-05-31T00:00:00Z", "2023-06-01T00:00:00Z"}},
+		{"0 0 31 * *", []string{"2023-05-31T00:00:00Z", "2023-07-31T00:00:00Z"}},
+		{"0 12 29 2 *", []string{"2024-02-29T12:00:00Z", "2028-02-29T12:00:00Z"}},
+		{"59 23 31 12 *", []string{"2023-12-31T23:59:00Z", "2024-12-31T23:59:00Z"}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		next := from
+		for i, want := range tt.want {
+			next = s.Next(next)
+			if got := next.Format(time.RFC3339); got != want {
+				t.Errorf("%q: run %d got %s, want %s", tt.spec, i+1, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestNextInUTC(t *testing.T) {
+	s, err := Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := time.FixedZone("UTC+2", 2*3600)
+	got := s.Next(time.Date(2023, 5, 15, 4, 0, 0, 0, loc))
+	if want := time.Date(2023, 5, 15, 3, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	// a schedule that never matches gives up 5 years later rather than looping
+	if got := s.Next(from); got.Before(from.AddDate(5, 0, 0)) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"@yearly",
+		"@every",
+		"@every soon",
+		"@every 0s",
+		"@every -1h",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"dnscoffee/app"
+	"dnscoffee/config"
+	"dnscoffee/datastore"
+	"dnscoffee/logging"
+	"dnscoffee/provider"
+	"dnscoffee/server"
+	"dnscoffee/version"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// selfTestConnectTimeout bounds connecting to the database, the self-test does not wait for it like a normal start
+const selfTestConnectTimeout = 30 * time.Second
+
+// runSelfTest checks that the server can serve with conf: its settings are valid, the database is reachable
+// with the expected schema, and every route self-test answers, served on an ephemeral port
+// it writes the JSON report to stdout and returns the exit code, 1 if any check failed
+// configErrs are the errors of validating conf
+func runSelfTest(ctx context.Context, conf *config.Config, configErrs []error) int {
+	report := &server.SelfTestReport{Version: version.Version}
+	defer func() {
+		report.OK = !report.Failed()
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(report); err != nil {
+			logging.Errorf("selftest: %s", err)
+		}
+	}()
+
+	var err error
+	if len(configErrs) > 0 {
+		msgs := make([]string, len(configErrs))
+		for i, e := range configErrs {
+			msgs[i] = e.Error()
+		}
+		err = fmt.Errorf("%d invalid config settings: %s", len(configErrs), strings.Join(msgs, "; "))
+	}
+	report.Add("config", 0, err)
+	if err != nil {
+		return 1
+	}
+	// the report is written to stdout, the log stays on stderr
+	level, err := logging.ParseLevel(conf.Log.Level)
+	if err == nil {
+		logging.SetLevel(level)
+	}
+
+	start := time.Now()
+	connectCtx, cancel := context.WithTimeout(ctx, selfTestConnectTimeout)
+	ds, err := datastore.New(connectCtx, conf.Datastore())
+	cancel()
+	report.Add("database", time.Since(start), err)
+	if err != nil {
+		return 1
+	}
+	defer ds.Close()
+
+	start = time.Now()
+	err = ds.CheckSchema(ctx, false)
+	report.Add("schema", time.Since(start), err)
+	if err != nil {
+		return 1
+	}
+
+	coffeeServer, err := server.New(conf.ListenAddrs(), conf.Server())
+	if err != nil {
+		report.Add("server", 0, err)
+		return 1
+	}
+	classifier, err := conf.Classifier()
+	if err != nil {
+		report.Add("providers", 0, err)
+		return 1
+	}
+	appConfig := conf.App()
+	appConfig.Providers = provider.NewTable(classifier)
+	app.Start(ds, coffeeServer, appConfig)
+	err = coffeeServer.SelfTest(ctx, report)
+	if err != nil {
+		report.Add("server", 0, err)
+		return 1
+	}
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
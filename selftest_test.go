@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnscoffee/config"
+	"dnscoffee/server"
+)
+
+// selfTestReport runs the self-test of the config at path and returns its exit code and the report it printed
+func selfTestReport(t *testing.T, path string, configErrs []error) (int, *server.SelfTestReport) {
+	t.Helper()
+	conf, err := config.Load(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	code := runSelfTest(ctx, conf, configErrs)
+	cancel()
+	os.Stdout = stdout
+
+	report := &server.SelfTestReport{}
+	if _, err := out.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(out).Decode(report); err != nil {
+		t.Fatal(err)
+	}
+	return code, report
+}
+
+// TestSelfTestFailures stops the self-test at the first check that fails and reports it
+func TestSelfTestFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		configErrs []error
+		// the checks run and their statuses
+		want []string
+	}{
+		{
+			name:       "invalid config",
+			config:     `{}`,
+			configErrs: []error{errors.New("API.Requests_Per_Minute: must be positive")},
+			want:       []string{"config failed"},
+		},
+		{
+			name:   "database unreachable",
+			config: `{"Database": {"DSN": "postgres://dnscoffee@127.0.0.1:1/dnscoffee?connect_timeout=5"}}`,
+			want:   []string{"config ok", "database failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			writeTestConfig(t, path, tt.config)
+			code, report := selfTestReport(t, path, tt.configErrs)
+			if code != 1 || report.OK {
+				t.Errorf("exit code %d and ok %t, want 1 and false", code, report.OK)
+			}
+			var got []string
+			for _, check := range report.Checks {
+				got = append(got, check.Name+" "+check.Status)
+				if check.Status == server.SelfTestFailed && check.Error == "" {
+					t.Errorf("check %s failed without an error", check.Name)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got checks %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got checks %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// abuse counters
+var (
+	bansAdded    = expvar.NewInt("abuse_bans")
+	bansRejected = expvar.NewInt("abuse_rejected")
+	// strikes and bans not recorded because maxEntries clients were already tracked
+	bansUntracked = expvar.NewInt("abuse_untracked")
+)
+
+// banList temporarily bans clients that keep sending requests after being rate limited
+// a client is banned once it is rate limited threshold times within window
+type banList struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	// upper bound on the number of clients with strikes, and on the number of bans
+	maxEntries int
+
+	mu      sync.Mutex
+	strikes map[string]*strikes
+	bans    map[string]*ban
+}
+
+// strikes counts the rate limited requests of a client in the current window
+type strikes struct {
+	count int
+	start time.Time
+}
+
+type ban struct {
+	strikes  int
+	since    time.Time
+	until    time.Time
+	rejected int
+}
+
+func newBanList(threshold int, window, duration time.Duration, maxEntries int) *banList {
+	return &banList{
+		threshold:  threshold,
+		window:     window,
+		duration:   duration,
+		maxEntries: maxEntries,
+		strikes:    make(map[string]*strikes),
+		bans:       make(map[string]*ban),
+	}
+}
+
+// enabled returns false when banning is turned off
+func (bl *banList) enabled() bool {
+	return bl.threshold > 0
+}
+
+// strike records a rate limited request and bans the client once it reaches the threshold
+func (bl *banList) strike(ip string) {
+	if !bl.enabled() {
+		return
+	}
+	now := time.Now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	s, ok := bl.strikes[ip]
+	if !ok || now.Sub(s.start) > bl.window {
+		if !ok && len(bl.strikes) >= bl.maxEntries {
+			bl.prune(now)
+			if len(bl.strikes) >= bl.maxEntries {
+				// every tracked client is still within its window, new clients are not tracked until one ends
+				bansUntracked.Add(1)
+				return
+			}
+		}
+		s = &strikes{start: now}
+		bl.strikes[ip] = s
+	}
+	s.count++
+	if s.count < bl.threshold {
+		return
+	}
+	delete(bl.strikes, ip)
+	if len(bl.bans) >= bl.maxEntries {
+		bl.prune(now)
+		if len(bl.bans) >= bl.maxEntries {
+			bansUntracked.Add(1)
+			logging.Warnf("abuse: not banning %s, %d clients are banned already", ip, len(bl.bans))
+			return
+		}
+	}
+	bl.bans[ip] = &ban{strikes: s.count, since: now, until: now.Add(bl.duration)}
+	bansAdded.Add(1)
+	logging.Warnf("abuse: banned %s for %s after %d rate limited requests in %s", ip, bl.duration, s.count, now.Sub(s.start).Round(time.Second))
+}
+
+// prune removes expired strike windows and expired bans, bl.mu must be held
+func (bl *banList) prune(now time.Time) {
+	for ip, s := range bl.strikes {
+		if now.Sub(s.start) > bl.window {
+			delete(bl.strikes, ip)
+		}
+	}
+	for ip, b := range bl.bans {
+		if !now.Before(b.until) {
+			bl.expire(ip, b)
+		}
+	}
+}
+
+// expire removes the expired ban b of ip, bl.mu must be held
+func (bl *banList) expire(ip string, b *ban) {
+	delete(bl.bans, ip)
+	logging.Infof("abuse: ban of %s expired, %d requests rejected", ip, b.rejected)
+}
+
+// banned returns how long ip remains banned, 0 if it is not
+func (bl *banList) banned(ip string) time.Duration {
+	now := time.Now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	b, ok := bl.bans[ip]
+	if !ok {
+		return 0
+	}
+	if !now.Before(b.until) {
+		bl.expire(ip, b)
+		return 0
+	}
+	b.rejected++
+	return b.until.Sub(now)
+}
+
+// remove lifts the ban of ip, returns false if it was not banned
+func (bl *banList) remove(ip string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	b, ok := bl.bans[ip]
+	if ok {
+		delete(bl.bans, ip)
+		logging.Infof("abuse: ban of %s removed, %d requests rejected", ip, b.rejected)
+	}
+	return ok
+}
+
+// list returns the active bans, expired ones are removed
+func (bl *banList) list() []*model.Ban {
+	now := time.Now()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	out := make([]*model.Ban, 0, len(bl.bans))
+	for ip, b := range bl.bans {
+		if !now.Before(b.until) {
+			bl.expire(ip, b)
+			continue
+		}
+		out = append(out, &model.Ban{
+			IP:       ip,
+			Strikes:  b.strikes,
+			Rejected: b.rejected,
+			Since:    model.NewTimestamp(b.since),
+			Until:    model.NewTimestamp(b.until),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since.Time) })
+	return out
+}
+
+// handler rejects banned clients before any other middleware runs
+// clients are the addresses resolved by stripUntrustedProxyHeaders, which must run first so that forwarding
+// headers sent by clients themselves can neither dodge a ban nor get another address banned
+// the admin API is never blocked
+func (bl *banList) handler(next http.Handler) http.Handler {
+	if !bl.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		left := bl.banned(getIPAddress(r))
+		if left <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bansRejected.Add(1)
+		WriteRetryError(w, ErrBanned, left)
+	})
+}
+
+// adminBansHandler lists the banned clients
+func (s *Server) adminBansHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, &model.Bans{Bans: s.bans.list()})
+}
+
+// adminBanRemoveHandler lifts the ban of a client
+func (s *Server) adminBanRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	ip, jsonErr := ParseIPParam("ip", mux.Vars(r)["ip"])
+	if jsonErr != nil {
+		WriteJSONError(w, jsonErr)
+		return
+	}
+	if !s.bans.remove(ip.String()) {
+		WriteJSONError(w, ErrResourceNotFound)
+		return
+	}
+	logging.Infof("admin: %s lifted the ban of %s", getIPAddress(r), ip)
+	WriteJSON(w, &model.Bans{Bans: s.bans.list()})
+}
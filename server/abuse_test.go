@@ -0,0 +1,179 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// abuseChain serves requests as the outer middleware does, every request that reaches the handler is rate limited
+func abuseChain(s *Server) http.Handler {
+	limited := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.bans.strike(getIPAddress(r))
+		WriteRetryError(w, ErrLimitExceeded, time.Second)
+	})
+	return s.stripUntrustedProxyHeaders(s.bans.handler(limited))
+}
+
+func abuseRequest(h http.Handler, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+	r.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestBanAbusiveClient(t *testing.T) {
+	s := testServer(t)
+	s.bans = newBanList(10, time.Minute, time.Minute, 100)
+	h := abuseChain(s)
+	for i := 0; i < 10; i++ {
+		abuseRequest(h, "192.0.2.10:1234", "")
+	}
+	if s.bans.banned("192.0.2.10") <= 0 {
+		t.Fatal("abusive client not banned")
+	}
+	w := abuseRequest(h, "192.0.2.10:1234", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("banned client got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// well-behaved clients on nearby addresses are not affected
+	for _, addr := range []string{"192.0.2.11:1234", "192.0.2.9:1234"} {
+		abuseRequest(h, addr, "")
+	}
+	for _, ip := range []string{"192.0.2.11", "192.0.2.9"} {
+		if s.bans.banned(ip) > 0 {
+			t.Errorf("%s banned with its neighbour", ip)
+		}
+	}
+}
+
+func TestBanIgnoresSpoofedForwardedFor(t *testing.T) {
+	s := testServer(t)
+	s.bans = newBanList(10, time.Minute, time.Minute, 100)
+	h := abuseChain(s)
+	for i := 0; i < 10; i++ {
+		// a victim's address, and then a new one for every request
+		abuseRequest(h, "192.0.2.10:1234", "198.51.100.1")
+		abuseRequest(h, "192.0.2.10:1234", "203.0.113."+strconv.Itoa(i))
+	}
+	if s.bans.banned("198.51.100.1") > 0 {
+		t.Error("client got a forwarded address banned")
+	}
+	if s.bans.banned("192.0.2.10") <= 0 {
+		t.Error("client rotating forwarded addresses not banned")
+	}
+}
+
+func TestBanBehindTrustedProxy(t *testing.T) {
+	s := testServer(t, "172.16.0.0/12")
+	s.bans = newBanList(10, time.Minute, time.Minute, 100)
+	h := abuseChain(s)
+	for i := 0; i < 10; i++ {
+		// the client prepends the victim, the proxy appends the client
+		abuseRequest(h, "172.16.0.1:1234", "198.51.100.1, 192.0.2.10")
+	}
+	if s.bans.banned("192.0.2.10") <= 0 {
+		t.Error("abusive client behind the proxy not banned")
+	}
+	if s.bans.banned("198.51.100.1") > 0 || s.bans.banned("172.16.0.1") > 0 {
+		t.Error("the prepended address or the proxy got banned")
+	}
+	abuseRequest(h, "172.16.0.1:1234", "192.0.2.11")
+	if s.bans.banned("192.0.2.11") > 0 {
+		t.Error("a nearby client behind the proxy was banned")
+	}
+}
+
+func TestBanExpiresAndRemove(t *testing.T) {
+	bl := newBanList(1, time.Minute, 20*time.Millisecond, 100)
+	bl.strike("192.0.2.1")
+	bl.strike("192.0.2.2")
+	if len(bl.list()) != 2 {
+		t.Fatalf("got %d bans, want 2", len(bl.list()))
+	}
+	if !bl.remove("192.0.2.2") || bl.remove("192.0.2.2") {
+		t.Error("remove did not lift the ban once")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if bl.banned("192.0.2.1") > 0 {
+		t.Error("ban did not expire")
+	}
+	if len(bl.list()) != 0 {
+		t.Error("expired ban listed")
+	}
+}
+
+func TestBanSkipsAdmin(t *testing.T) {
+	s := testServer(t)
+	s.bans = newBanList(1, time.Minute, time.Minute, 100)
+	s.bans.strike("192.0.2.1")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/bans", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	s.stripUntrustedProxyHeaders(s.bans.handler(ok)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin request of a banned client got status %d", w.Code)
+	}
+}
+
+// TestBanListBounds floods the list with rotating addresses, neither strikes nor bans outgrow maxEntries
+func TestBanListBounds(t *testing.T) {
+	bl := newBanList(2, time.Minute, time.Minute, 10)
+	for i := 0; i < 100; i++ {
+		bl.strike("192.0.2." + strconv.Itoa(i))
+	}
+	if len(bl.strikes) != 10 {
+		t.Errorf("got %d clients with strikes, want 10", len(bl.strikes))
+	}
+	// the tracked clients get banned, the next ones take their strike slots and the others are refused
+	for i := 0; i < 100; i++ {
+		bl.strike("192.0.2." + strconv.Itoa(i))
+	}
+	if len(bl.bans) != 10 || len(bl.strikes) != 10 {
+		t.Errorf("got %d bans and %d clients with strikes, want 10 and 10", len(bl.bans), len(bl.strikes))
+	}
+	for i := 0; i < 100; i++ {
+		bl.strike("198.51.100." + strconv.Itoa(i))
+		bl.strike("198.51.100." + strconv.Itoa(i))
+	}
+	if len(bl.bans) != 10 {
+		t.Errorf("got %d bans, want at most 10", len(bl.bans))
+	}
+}
+
+// TestBanListSweepsExpired checks that expired bans are removed without their client coming back
+func TestBanListSweepsExpired(t *testing.T) {
+	bl := newBanList(1, 10*time.Millisecond, 10*time.Millisecond, 10)
+	for i := 0; i < 10; i++ {
+		bl.strike("192.0.2." + strconv.Itoa(i))
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := bl.list(); len(got) != 0 || len(bl.bans) != 0 {
+		t.Errorf("listed %d bans and kept %d after they expired", len(got), len(bl.bans))
+	}
+
+	for i := 0; i < 10; i++ {
+		bl.strike("192.0.2." + strconv.Itoa(i))
+	}
+	time.Sleep(20 * time.Millisecond)
+	// a full list of expired bans and strikes makes room for new clients
+	for i := 0; i < 10; i++ {
+		bl.strike("198.51.100." + strconv.Itoa(i))
+	}
+	if len(bl.bans) != 10 {
+		t.Errorf("got %d bans, want 10", len(bl.bans))
+	}
+	for ip := range bl.bans {
+		if strings.HasPrefix(ip, "192.0.2.") {
+			t.Errorf("expired ban of %s kept", ip)
+		}
+	}
+}
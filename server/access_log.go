@@ -0,0 +1,143 @@
+package server
+
+import (
+	"expvar"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/handlers"
+)
+
+// accessLogStats counts the access lines written and those left out by sampling, by status class
+// logged_2xx times sample_rate estimates the successful requests
+var accessLogStats = expvar.NewMap("access_log")
+
+// accessLogSampling holds the access log sampling settings
+type accessLogSampling struct {
+	// 1 in rate successful requests is logged, 1 logs every request
+	rate int
+	// requests taking longer are always logged, 0 disables it
+	slow time.Duration
+	// sample the 404s like successful requests
+	sampleNotFound bool
+}
+
+// accessLogger writes the access lines in Apache Common Log Format, sampling the successful requests
+type accessLogger struct {
+	out io.Writer
+	// *accessLogSampling, replaced on reload
+	sampling atomic.Value
+}
+
+func newAccessLogger(out io.Writer, rate int, slow time.Duration, sampleNotFound bool) *accessLogger {
+	l := &accessLogger{out: out}
+	l.set(rate, slow, sampleNotFound)
+	accessLogStats.Set("sample_rate", expvar.Func(func() interface{} { return l.current().rate }))
+	return l
+}
+
+// set replaces the sampling settings, a rate below 1 logs every request
+func (l *accessLogger) set(rate int, slow time.Duration, sampleNotFound bool) {
+	if rate < 1 {
+		rate = 1
+	}
+	l.sampling.Store(&accessLogSampling{rate: rate, slow: slow, sampleNotFound: sampleNotFound})
+}
+
+func (l *accessLogger) current() *accessLogSampling {
+	return l.sampling.Load().(*accessLogSampling)
+}
+
+// sampled returns true if the request with the ID is one of the 1 in rate requests logged
+// the choice only depends on the ID so that every line about a request agrees on it
+func sampled(id string, rate int) bool {
+	if rate <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()%uint32(rate) == 0
+}
+
+// keep returns true if the access line of a request must be written
+// errors, 404s unless they are sampled, rate limit denials and slow requests are always logged
+func (ls *accessLogSampling) keep(id string, status int, took time.Duration) bool {
+	switch {
+	case status >= 500:
+		return true
+	case status == http.StatusNotFound:
+		if !ls.sampleNotFound {
+			return true
+		}
+	case status >= 400:
+		return true
+	}
+	if ls.slow > 0 && took >= ls.slow {
+		return true
+	}
+	return sampled(id, ls.rate)
+}
+
+// handler logs the requests once they are answered, it must run after requestID
+// the line of the rate limited requests is logged as well, the limiters run inside of it
+func (l *accessLogger) handler(next http.Handler) http.Handler {
+	return handlers.CustomLoggingHandler(l.out, next, func(w io.Writer, params handlers.LogFormatterParams) {
+		class := strconv.Itoa(params.StatusCode/100) + "xx"
+		if !l.current().keep(RequestID(params.Request.Context()), params.StatusCode, time.Since(params.TimeStamp)) {
+			accessLogStats.Add("sampled_out_"+class, 1)
+			return
+		}
+		accessLogStats.Add("logged_"+class, 1)
+		w.Write(commonLogLine(params))
+	})
+}
+
+// commonLogLine formats the access line of a request in Apache Common Log Format, like handlers.LoggingHandler
+func commonLogLine(params handlers.LogFormatterParams) []byte {
+	req := params.Request
+	username := "-"
+	if params.URL.User != nil {
+		if name := params.URL.User.Username(); name != "" {
+			username = name
+		}
+	}
+	// the access logger runs before handlers.ProxyHeaders, the address forwarded by a trusted proxy is read from the headers
+	host := getIPAddress(req)
+	uri := req.RequestURI
+	if req.ProtoMajor == 2 && req.Method == http.MethodConnect {
+		uri = req.Host
+	}
+	if uri == "" {
+		uri = params.URL.RequestURI()
+	}
+	quoted := strconv.Quote(uri)
+
+	buf := make([]byte, 0, len(host)+len(username)+len(req.Method)+len(quoted)+len(req.Proto)+64)
+	buf = append(buf, host...)
+	buf = append(buf, " - "...)
+	buf = append(buf, username...)
+	buf = append(buf, " ["...)
+	buf = append(buf, params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700")...)
+	buf = append(buf, `] "`...)
+	buf = append(buf, req.Method...)
+	buf = append(buf, ' ')
+	buf = append(buf, quoted[1:len(quoted)-1]...)
+	buf = append(buf, ' ')
+	buf = append(buf, req.Proto...)
+	buf = append(buf, `" `...)
+	buf = append(buf, strconv.Itoa(params.StatusCode)...)
+	buf = append(buf, ' ')
+	buf = append(buf, strconv.Itoa(params.Size)...)
+	buf = append(buf, '\n')
+	return buf
+}
+
+// SetAccessLogSampling changes the access log sampling of the running server
+// 1 in rate successful requests is logged, requests slower than slow are always logged
+func (s *Server) SetAccessLogSampling(rate int, slow time.Duration, sampleNotFound bool) {
+	s.accessLog.set(rate, slow, sampleNotFound)
+}
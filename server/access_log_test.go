@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sampledIDs returns a request ID sampled at rate and one that is not
+func sampledIDs(t *testing.T, rate int) (in, out string) {
+	t.Helper()
+	for i := 0; in == "" || out == ""; i++ {
+		id := "test-" + strconv.Itoa(i)
+		if sampled(id, rate) {
+			in = id
+		} else {
+			out = id
+		}
+		if i > 1000 {
+			t.Fatal("no request IDs on both sides of the sample")
+		}
+	}
+	return in, out
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	in, out := sampledIDs(t, 4)
+	tests := []struct {
+		name           string
+		rate           int
+		slow           time.Duration
+		sampleNotFound bool
+		id             string
+		status         int
+		delay          time.Duration
+		want           bool
+	}{
+		{name: "every request", rate: 1, id: out, status: http.StatusOK, want: true},
+		{name: "sampled in", rate: 4, id: in, status: http.StatusOK, want: true},
+		{name: "sampled out", rate: 4, id: out, status: http.StatusOK},
+		{name: "redirect sampled out", rate: 4, id: out, status: http.StatusPermanentRedirect},
+		{name: "client error", rate: 4, id: out, status: http.StatusBadRequest, want: true},
+		{name: "rate limited", rate: 4, id: out, status: http.StatusTooManyRequests, want: true},
+		{name: "server error", rate: 4, id: out, status: http.StatusServiceUnavailable, want: true},
+		{name: "not found", rate: 4, id: out, status: http.StatusNotFound, want: true},
+		{name: "not found sampled out", rate: 4, sampleNotFound: true, id: out, status: http.StatusNotFound},
+		{name: "not found sampled in", rate: 4, sampleNotFound: true, id: in, status: http.StatusNotFound, want: true},
+		{name: "slow", rate: 4, slow: time.Millisecond, id: out, status: http.StatusOK, delay: 5 * time.Millisecond, want: true},
+		{name: "fast", rate: 4, slow: time.Hour, id: out, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := newAccessLogger(&buf, tt.rate, tt.slow, tt.sampleNotFound)
+			h := requestID(l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.status)
+			})))
+			class := strconv.Itoa(tt.status/100) + "xx"
+			logged, sampledOut := expvarInt(accessLogStats, "logged_"+class), expvarInt(accessLogStats, "sampled_out_"+class)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/test/log", nil)
+			r.Header.Set(requestIDHeader, tt.id)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got := buf.Len() > 0; got != tt.want {
+				t.Errorf("logged %q, want a line: %t", buf.String(), tt.want)
+			}
+			wantLogged, wantOut := int64(0), int64(1)
+			if tt.want {
+				wantLogged, wantOut = 1, 0
+			}
+			if got := expvarInt(accessLogStats, "logged_"+class) - logged; got != wantLogged {
+				t.Errorf("counted %d logged, want %d", got, wantLogged)
+			}
+			if got := expvarInt(accessLogStats, "sampled_out_"+class) - sampledOut; got != wantOut {
+				t.Errorf("counted %d sampled out, want %d", got, wantOut)
+			}
+		})
+	}
+}
+
+// TestAccessLogRate logs about 1 in rate requests with random IDs
+func TestAccessLogRate(t *testing.T) {
+	var buf bytes.Buffer
+	l := newAccessLogger(&buf, 10, 0, false)
+	h := requestID(l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for i := 0; i < 10000; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/test/log", nil))
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte{'\n'}); lines < 800 || lines > 1200 {
+		t.Errorf("logged %d of 10000 requests, want about 1000", lines)
+	}
+}
+
+func TestAccessLogLine(t *testing.T) {
+	var buf bytes.Buffer
+	l := newAccessLogger(&buf, 1, 0, false)
+	h := requestID(l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	r := httptest.NewRequest(http.MethodGet, "/api/test/log?q=a%20b", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	line := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /api/test/log\?q=a%20b HTTP/1\.1" 200 2\n$`)
+	if !line.Match(buf.Bytes()) {
+		t.Errorf("logged %q, want a common log line", buf.String())
+	}
+}
+
+// TestSetAccessLogSampling changes the sampling of a running server
+func TestSetAccessLogSampling(t *testing.T) {
+	conf := testAPIConfig
+	conf.AccessLogSampleRate, conf.SlowRequestThreshold = 10, time.Second
+	s, err := New([]string{"127.0.0.1:0"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.accessLog.current(); got.rate != 10 || got.slow != time.Second || got.sampleNotFound {
+		t.Errorf("sampling %+v, want 1 in 10 and 1s", got)
+	}
+	s.SetAccessLogSampling(100, time.Minute, true)
+	if got := s.accessLog.current(); got.rate != 100 || got.slow != time.Minute || !got.sampleNotFound {
+		t.Errorf("sampling %+v after the change", got)
+	}
+	s.SetAccessLogSampling(0, 0, false)
+	if got := s.accessLog.current(); got.rate != 1 {
+		t.Errorf("rate %d, want 1 for a rate below 1", got.rate)
+	}
+}
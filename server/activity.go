@@ -0,0 +1,341 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dnscoffee/model"
+)
+
+const (
+	// activityMinutes is the length of the activity window, in the per-minute buckets of its ring
+	activityMinutes = 15
+	// activityShards splits the activity by a hash of the client, each shard with its own lock
+	activityShards = 16
+	// activityShardClients is how many clients a shard keeps a minute, the requests of the others are counted together
+	// in the other client; with the routes, which are as many as the templates, this bounds the memory of the window
+	activityShardClients = 500
+	// defaultActivityLimit and maxActivityLimit are the clients and routes listed without and with ?limit=
+	defaultActivityLimit = 10
+	maxActivityLimit     = 100
+)
+
+// client types of the activity, see ActivityClient
+const (
+	activityKey   = "key"
+	activityIP    = "ip"
+	activityOther = "other"
+)
+
+// activityLatencyBounds are the upper bounds of the latency histograms of the activity, those of DefaultLatencyBuckets,
+// a last bucket counts the slower requests
+var activityLatencyBounds = [...]time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+	10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// activityClient identifies a client by its API key or, for anonymous requests, its address
+type activityClient struct {
+	kind string
+	name string
+}
+
+// activityCounts counts the requests of a client or route
+type activityCounts struct {
+	requests, errors, clientErrors, rateLimited int64
+	// requests by latency bucket, the streamed downloads are not measured
+	latency [len(activityLatencyBounds) + 1]int64
+	// the slowest request measured, the percentile of the last bucket
+	max time.Duration
+}
+
+// add counts a request, took is not measured when measured is false
+func (c *activityCounts) add(status int, took time.Duration, measured bool) {
+	c.requests++
+	switch {
+	case status >= 500:
+		c.errors++
+	case status >= 400:
+		c.clientErrors++
+		if status == http.StatusTooManyRequests {
+			c.rateLimited++
+		}
+	}
+	if !measured {
+		return
+	}
+	i := sort.Search(len(activityLatencyBounds), func(i int) bool { return took <= activityLatencyBounds[i] })
+	c.latency[i]++
+	if took > c.max {
+		c.max = took
+	}
+}
+
+// merge adds the counts of o
+func (c *activityCounts) merge(o *activityCounts) {
+	c.requests += o.requests
+	c.errors += o.errors
+	c.clientErrors += o.clientErrors
+	c.rateLimited += o.rateLimited
+	for i, n := range o.latency {
+		c.latency[i] += n
+	}
+	if o.max > c.max {
+		c.max = o.max
+	}
+}
+
+// percentile returns the upper bound of the latency bucket of the q quantile in milliseconds, or the slowest request
+// when it falls in the last bucket; 0 without measured requests
+func (c *activityCounts) percentile(q float64) float64 {
+	var measured int64
+	for _, n := range c.latency {
+		measured += n
+	}
+	if measured == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(measured)))
+	var seen int64
+	for i, n := range c.latency {
+		seen += n
+		if seen >= rank {
+			if i < len(activityLatencyBounds) {
+				return float64(activityLatencyBounds[i]) / float64(time.Millisecond)
+			}
+			break
+		}
+	}
+	return float64(c.max) / float64(time.Millisecond)
+}
+
+// model returns the counts of the API
+func (c *activityCounts) model() *model.ActivityCounts {
+	counts := &model.ActivityCounts{
+		Requests:     c.requests,
+		Errors:       c.errors,
+		ClientErrors: c.clientErrors,
+		RateLimited:  c.rateLimited,
+		P50:          c.percentile(0.5),
+		P95:          c.percentile(0.95),
+	}
+	if c.requests > 0 {
+		counts.ErrorRate = float64(c.errors) / float64(c.requests)
+	}
+	return counts
+}
+
+// activityMinute is the bucket of a minute of the ring of a shard
+type activityMinute struct {
+	// Unix minute the bucket counts, the bucket is reset when a later minute wraps around to it
+	minute  int64
+	clients map[activityClient]*activityCounts
+	routes  map[string]*activityCounts
+}
+
+// activityShard holds the minutes of the clients hashed to it, and of the routes they requested
+type activityShard struct {
+	mu      sync.Mutex
+	minutes [activityMinutes]activityMinute
+}
+
+// activityTracker aggregates the requests of the public API over the last activityMinutes minutes, in a ring of
+// per-minute buckets split between shards by client so that concurrent requests rarely wait on each other
+// it is kept across config reloads, which change nothing it counts
+type activityTracker struct {
+	shards [activityShards]activityShard
+}
+
+func newActivityTracker() *activityTracker {
+	t := &activityTracker{}
+	for i := range t.shards {
+		for j := range t.shards[i].minutes {
+			t.shards[i].minutes[j] = activityMinute{
+				minute:  -1,
+				clients: make(map[activityClient]*activityCounts),
+				routes:  make(map[string]*activityCounts),
+			}
+		}
+	}
+	return t
+}
+
+// record counts a request of client to the route template answered with status at now
+func (t *activityTracker) record(client activityClient, route string, status int, took time.Duration, measured bool, now time.Time) {
+	minute := now.Unix() / 60
+	sh := &t.shards[hashKey(client.name)%activityShards]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	m := &sh.minutes[minute%activityMinutes]
+	if m.minute != minute {
+		m.minute = minute
+		m.clients = make(map[activityClient]*activityCounts, len(m.clients))
+		m.routes = make(map[string]*activityCounts, len(m.routes))
+	}
+	cc, ok := m.clients[client]
+	if !ok {
+		if len(m.clients) >= activityShardClients {
+			client = activityClient{kind: activityOther}
+			cc = m.clients[client]
+		}
+		if cc == nil {
+			cc = &activityCounts{}
+			m.clients[client] = cc
+		}
+	}
+	cc.add(status, took, measured)
+	rc, ok := m.routes[route]
+	if !ok {
+		rc = &activityCounts{}
+		m.routes[route] = rc
+	}
+	rc.add(status, took, measured)
+}
+
+// snapshot returns the counts of the last minutes minutes up to now by client and route, from every shard
+func (t *activityTracker) snapshot(minutes int, now time.Time) (map[activityClient]*activityCounts, map[string]*activityCounts) {
+	last := now.Unix() / 60
+	first := last - int64(minutes) + 1
+	clients := make(map[activityClient]*activityCounts)
+	routes := make(map[string]*activityCounts)
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.Lock()
+		for j := range sh.minutes {
+			m := &sh.minutes[j]
+			if m.minute < first || m.minute > last {
+				continue
+			}
+			mergeActivity(clients, m.clients)
+			mergeActivity(routes, m.routes)
+		}
+		sh.mu.Unlock()
+	}
+	return clients, routes
+}
+
+// mergeActivity adds the counts of from to those of into by key
+func mergeActivity[K comparable](into, from map[K]*activityCounts) {
+	for k, c := range from {
+		sum, ok := into[k]
+		if !ok {
+			sum = &activityCounts{}
+			into[k] = sum
+		}
+		sum.merge(c)
+	}
+}
+
+// trackActivity counts the requests of the public API by client and route, those rejected by the rate limiter included
+// the route is matched like the rate limiter does, the routing is still ahead; the operational routes are not counted
+func (s *Server) trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if operationalRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		took := time.Since(start)
+		client := activityClient{kind: activityIP, name: getIPAddress(r)}
+		if name := s.zones.keyName(r); name != "" {
+			client = activityClient{kind: activityKey, name: name}
+		}
+		route := s.matchRoute(r)
+		// the streamed downloads run as long as the client reads, like for the load shedder
+		s.activity.record(client, route, sw.status, took, !s.streaming[route], time.Now())
+	})
+}
+
+// adminActivityHandler returns the requests of the last ?minutes= minutes, activityMinutes by default, in total, by route
+// class, and of the ?limit= clients and routes with the most requests; ?client= and ?route= only list the clients and
+// routes containing them, case insensitively, and ?class= the routes of a class
+func (s *Server) adminActivityHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minutes := activityMinutes
+	if value := query.Get("minutes"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > activityMinutes {
+			WriteJSONError(w, NewFieldError("minutes", "must be an integer from 1 to "+strconv.Itoa(activityMinutes)))
+			return
+		}
+		minutes = n
+	}
+	limit := defaultActivityLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxActivityLimit {
+			WriteJSONError(w, NewFieldError("limit", "must be an integer from 1 to "+strconv.Itoa(maxActivityLimit)))
+			return
+		}
+		limit = n
+	}
+	class := query.Get("class")
+	if class != "" && class != routeClassCheap && class != routeClassExpensive {
+		WriteJSONError(w, NewFieldError("class", "must be "+routeClassCheap+" or "+routeClassExpensive))
+		return
+	}
+	clientFilter, routeFilter := strings.ToLower(query.Get("client")), strings.ToLower(query.Get("route"))
+
+	now := time.Now()
+	clients, routes := s.activity.snapshot(minutes, now)
+	total := &activityCounts{}
+	classes := map[string]*activityCounts{routeClassCheap: {}, routeClassExpensive: {}}
+	data := &model.Activity{
+		Minutes: minutes,
+		From:    model.NewTimestamp(time.Unix((now.Unix()/60-int64(minutes)+1)*60, 0)),
+		Client:  query.Get("client"),
+		Route:   query.Get("route"),
+		Class:   class,
+		Classes: make(map[string]*model.ActivityCounts, len(classes)),
+		Clients: []*model.ActivityClient{},
+		Routes:  []*model.ActivityRoute{},
+	}
+	for route, c := range routes {
+		total.merge(c)
+		routeClass := s.shedder.class(route)
+		classes[routeClass].merge(c)
+		if class != "" && routeClass != class || !strings.Contains(strings.ToLower(route), routeFilter) {
+			continue
+		}
+		data.Routes = append(data.Routes, &model.ActivityRoute{Route: route, Class: routeClass, ActivityCounts: c.model()})
+	}
+	data.Total = total.model()
+	for name, c := range classes {
+		data.Classes[name] = c.model()
+	}
+	for client, c := range clients {
+		if !strings.Contains(strings.ToLower(client.name), clientFilter) {
+			continue
+		}
+		data.Clients = append(data.Clients, &model.ActivityClient{Type: client.kind, Client: client.name, ActivityCounts: c.model()})
+	}
+	sort.Slice(data.Clients, func(i, j int) bool {
+		a, b := data.Clients[i], data.Clients[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Type+a.Client < b.Type+b.Client
+	})
+	sort.Slice(data.Routes, func(i, j int) bool {
+		a, b := data.Routes[i], data.Routes[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Route < b.Route
+	})
+	if len(data.Clients) > limit {
+		data.Clients = data.Clients[:limit]
+	}
+	if len(data.Routes) > limit {
+		data.Routes = data.Routes[:limit]
+	}
+	WriteJSON(w, data)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// activityNow is the time of the simulated requests, in the middle of a minute
+var activityNow = time.Unix(1700000030, 0)
+
+func TestActivityConcurrent(t *testing.T) {
+	tr := newActivityTracker()
+	const writers, requests = 16, 500
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	// snapshots are taken while the requests are recorded, run with -race
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				tr.snapshot(activityMinutes, activityNow)
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				client := activityClient{kind: activityIP, name: fmt.Sprintf("192.0.2.%d", i%50)}
+				status := http.StatusOK
+				if i%10 == 0 {
+					status = http.StatusInternalServerError
+				}
+				tr.record(client, fmt.Sprintf("/api/route/%d", w%4), status, time.Millisecond, true, activityNow)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+
+	clients, routes := tr.snapshot(activityMinutes, activityNow)
+	if len(clients) != 50 || len(routes) != 4 {
+		t.Fatalf("got %d clients and %d routes, want 50 and 4", len(clients), len(routes))
+	}
+	total := &activityCounts{}
+	for _, c := range clients {
+		total.merge(c)
+	}
+	byRoute := &activityCounts{}
+	for _, c := range routes {
+		byRoute.merge(c)
+	}
+	if total.requests != writers*requests || byRoute.requests != total.requests {
+		t.Errorf("got %d requests by client and %d by route, want %d", total.requests, byRoute.requests, writers*requests)
+	}
+	if total.errors != writers*requests/10 {
+		t.Errorf("got %d errors, want %d", total.errors, writers*requests/10)
+	}
+}
+
+func TestActivityWindow(t *testing.T) {
+	tr := newActivityTracker()
+	client := activityClient{kind: activityKey, name: "ops"}
+	for m := 0; m < 20; m++ {
+		tr.record(client, "/api/domains/{domain}", http.StatusOK, time.Millisecond, true, activityNow.Add(time.Duration(m)*time.Minute))
+	}
+	now := activityNow.Add(19 * time.Minute)
+	tests := []struct {
+		minutes int
+		now     time.Time
+		want    int64
+	}{
+		{minutes: activityMinutes, now: now, want: activityMinutes},
+		{minutes: 5, now: now, want: 5},
+		{minutes: 1, now: now, want: 1},
+		// the minutes that wrapped around the ring were reset
+		{minutes: activityMinutes, now: activityNow.Add(4 * time.Minute), want: 0},
+		// nothing was recorded yet
+		{minutes: activityMinutes, now: now.Add(time.Hour), want: 0},
+	}
+	for _, tt := range tests {
+		clients, _ := tr.snapshot(tt.minutes, tt.now)
+		var got int64
+		if c, ok := clients[client]; ok {
+			got = c.requests
+		}
+		if got != tt.want {
+			t.Errorf("%d minutes up to %s: got %d requests, want %d", tt.minutes, tt.now.Sub(activityNow), got, tt.want)
+		}
+	}
+}
+
+func TestActivityClientLimit(t *testing.T) {
+	tr := newActivityTracker()
+	// many more clients than the shards keep, every request is still counted
+	n := activityShards * activityShardClients * 2
+	for i := 0; i < n; i++ {
+		tr.record(activityClient{kind: activityIP, name: fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)}, "/", http.StatusOK, 0, false, activityNow)
+	}
+	clients, _ := tr.snapshot(activityMinutes, activityNow)
+	if len(clients) > activityShards*activityShardClients+1 {
+		t.Errorf("kept %d clients", len(clients))
+	}
+	var total int64
+	for _, c := range clients {
+		total += c.requests
+	}
+	if total != int64(n) {
+		t.Errorf("got %d requests, want %d", total, n)
+	}
+	if other, ok := clients[activityClient{kind: activityOther}]; !ok || other.requests == 0 {
+		t.Error("the requests of the clients over the limit were not counted as other")
+	}
+}
+
+func TestActivityCounts(t *testing.T) {
+	c := &activityCounts{}
+	for i := 0; i < 90; i++ {
+		c.add(http.StatusOK, 3*time.Millisecond, true)
+	}
+	for i := 0; i < 9; i++ {
+		c.add(http.StatusTooManyRequests, 200*time.Millisecond, true)
+	}
+	c.add(http.StatusBadGateway, 2*time.Minute, true)
+	// not measured, counted but not in the percentiles
+	c.add(http.StatusOK, time.Hour, false)
+
+	m := c.model()
+	if m.Requests != 101 || m.Errors != 1 || m.ClientErrors != 9 || m.RateLimited != 9 {
+		t.Errorf("got %+v", m)
+	}
+	if m.P50 != 5 || m.P95 != 250 {
+		t.Errorf("got p50 %g, p95 %g, want 5 and 250", m.P50, m.P95)
+	}
+	if got := c.percentile(1); got != float64(2*time.Minute/time.Millisecond) {
+		t.Errorf("got p100 %g, want the slowest request", got)
+	}
+	if got := (&activityCounts{}).percentile(0.5); got != 0 {
+		t.Errorf("got %g without requests", got)
+	}
+}
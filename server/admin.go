@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// adminPrefix is the path prefix of all operator only routes
+const adminPrefix = "/api/admin"
+
+// cacheFlusher is a named cache that can be emptied from the admin API
+type cacheFlusher struct {
+	name  string
+	flush func()
+}
+
+// Admin registers an operator only handler under /api/admin
+// requests must come from the admin allowlist and carry the admin token as a bearer token, or a verified client certificate
+// admin routes are never rate limited
+func (s *Server) Admin(method, path string, fn http.HandlerFunc) {
+	s.router.Handle(adminPrefix+path, s.adminAllow.handler(s.requireAdmin(fn))).Methods(method)
+}
+
+// AddCacheFlusher registers a cache to be emptied by POST /api/admin/cache/flush
+func (s *Server) AddCacheFlusher(name string, flush func()) {
+	s.cacheFlushers = append(s.cacheFlushers, cacheFlusher{name, flush})
+}
+
+// requireAdmin rejects requests without a valid admin bearer token or client certificate
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only set when the admin listener verified the certificate against the admin client CA
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.apiConfig.AdminToken == "" {
+			logging.Warnf("admin: rejected %s %s from %s: admin API disabled", r.Method, r.URL.Path, getIPAddress(r))
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			logging.Warnf("admin: rejected %s %s from %s: missing token", r.Method, r.URL.Path, getIPAddress(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			WriteJSONError(w, ErrUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiConfig.AdminToken)) != 1 {
+			logging.Warnf("admin: rejected %s %s from %s: invalid token", r.Method, r.URL.Path, getIPAddress(r))
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// FlushCaches empties every registered cache and returns their names
+func (s *Server) FlushCaches() []string {
+	names := make([]string, 0, len(s.cacheFlushers))
+	for _, c := range s.cacheFlushers {
+		c.flush()
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// adminCacheFlushHandler empties every registered cache
+func (s *Server) adminCacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	data := &model.CacheFlush{Caches: s.FlushCaches()}
+	logging.Infof("admin: flushed caches %v", data.Caches)
+	WriteJSON(w, data)
+}
+
+// maxAdminBody is the largest request body accepted by the admin API in bytes
+const maxAdminBody = 64 << 10
+
+// adminRateLimitHandler returns the current rate limit bucket of an IP without counting a request
+func (s *Server) adminRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	ip, jsonErr := ParseIPParam("ip", mux.Vars(r)["ip"])
+	if jsonErr != nil {
+		WriteJSONError(w, jsonErr)
+		return
+	}
+	limited, result, err := s.throttle.peek(ip.String())
+	if err != nil {
+		panic(err)
+	}
+	data := &model.RateLimit{
+		IP:         ip.String(),
+		Limit:      result.Limit,
+		Remaining:  result.Remaining,
+		ResetAfter: result.ResetAfter.Seconds(),
+		Limited:    limited,
+	}
+	WriteJSON(w, data)
+}
+
+// adminRateLimitResetHandler restores the full quota of an IP
+func (s *Server) adminRateLimitResetHandler(w http.ResponseWriter, r *http.Request) {
+	ip, jsonErr := ParseIPParam("ip", mux.Vars(r)["ip"])
+	if jsonErr != nil {
+		WriteJSONError(w, jsonErr)
+		return
+	}
+	// the IP may have used several tenants
+	found := false
+	for _, t := range s.tenants.throttles() {
+		reset, err := t.reset(ip.String())
+		if err != nil {
+			panic(err)
+		}
+		found = found || reset
+	}
+	if !found {
+		WriteJSONError(w, ErrResourceNotFound)
+		return
+	}
+	logging.Infof("admin: reset rate limit of %s", ip)
+	s.adminRateLimitHandler(w, r)
+}
+
+// adminReloadHandler re-reads the config and applies the settings that can change at runtime
+func (s *Server) adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		WriteJSONError(w, ErrNotImplemented)
+		return
+	}
+	data, err := s.reloader()
+	if err != nil {
+		logging.Errorf("admin: reload requested by %s failed: %s", getIPAddress(r), err)
+		jsonErr := *ErrInvalidConfig
+		jsonErr.Detail = err.Error()
+		WriteJSONError(w, &jsonErr)
+		return
+	}
+	WriteJSON(w, data)
+}
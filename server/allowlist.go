@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"dnscoffee/logging"
+)
+
+// parsePrefixes parses CIDRs into masked prefixes
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsIP returns true if the address ip is in one of prefixes, IPv4-mapped IPv6 addresses match IPv4 prefixes
+func containsIP(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowlist limits a group of routes to client addresses in a list of networks that can be replaced at runtime
+// an empty list allows every address, like Import_Hook.Allowed_CIDRs, so that configs without one keep working
+type ipAllowlist struct {
+	// routes the list protects, in the log lines of rejected requests
+	group string
+
+	mu       sync.RWMutex
+	prefixes []netip.Prefix
+}
+
+func newIPAllowlist(group string, cidrs []string) (*ipAllowlist, error) {
+	l := &ipAllowlist{group: group}
+	if err := l.set(cidrs); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// set replaces the allowed networks, an invalid CIDR leaves them unchanged
+func (l *ipAllowlist) set(cidrs []string) error {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.prefixes = prefixes
+	l.mu.Unlock()
+	return nil
+}
+
+// allows returns true if ip is in one of the networks, or there are none
+func (l *ipAllowlist) allows(ip string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.prefixes) == 0 || containsIP(l.prefixes, ip)
+}
+
+// handler answers a 403 to clients outside of the networks, their address is the one forwarded by a trusted proxy
+func (l *ipAllowlist) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := getIPAddress(r)
+		if !l.allows(ip) {
+			logging.Warnf("%s: rejected %s %s from %s: address not in the allowlist", l.group, r.Method, r.URL.Path, ip)
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckAllowlists returns the error SetAllowlists would return for the lists, without changing anything
+func CheckAllowlists(admin, internal []string) error {
+	if _, err := parsePrefixes(admin); err != nil {
+		return err
+	}
+	_, err := parsePrefixes(internal)
+	return err
+}
+
+// SetAllowlists replaces the networks the admin and internal routes may be called from, empty lists allow every address
+func (s *Server) SetAllowlists(admin, internal []string) error {
+	// both are parsed before either is replaced
+	if err := CheckAllowlists(admin, internal); err != nil {
+		return err
+	}
+	if err := s.adminAllow.set(admin); err != nil {
+		return err
+	}
+	return s.internalAllow.set(internal)
+}
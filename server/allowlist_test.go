@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testServer(t *testing.T, trustedProxies ...string) *Server {
+	t.Helper()
+	s := &Server{}
+	for _, cidr := range trustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.trustedProxies = append(s.trustedProxies, ipNet)
+	}
+	return s
+}
+
+func TestAllowlist(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name           string
+		allow          []string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   []string
+		realIP         string
+		want           int
+	}{
+		{name: "ipv4 inside", allow: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:1234", want: http.StatusOK},
+		{name: "ipv4 outside", allow: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:1234", want: http.StatusForbidden},
+		{name: "ipv6 inside", allow: []string{"2001:db8::/32"}, remoteAddr: "[2001:db8::1]:1234", want: http.StatusOK},
+		{name: "ipv6 outside", allow: []string{"2001:db8::/32"}, remoteAddr: "[2001:db9::1]:1234", want: http.StatusForbidden},
+		{name: "ipv4-mapped ipv6", allow: []string{"10.0.0.0/8"}, remoteAddr: "[::ffff:10.0.0.1]:1234", want: http.StatusOK},
+		{name: "mixed list", allow: []string{"10.0.0.0/8", "2001:db8::/32"}, remoteAddr: "[2001:db8::1]:1234", want: http.StatusOK},
+		{name: "empty list allows all", remoteAddr: "192.0.2.1:1234", want: http.StatusOK},
+		{
+			name: "forwarded for ignored without trusted proxies", allow: []string{"10.0.0.0/8"},
+			remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"10.0.0.1"}, want: http.StatusForbidden,
+		},
+		{
+			name: "real ip ignored without trusted proxies", allow: []string{"10.0.0.0/8"},
+			remoteAddr: "192.0.2.1:1234", realIP: "10.0.0.1", want: http.StatusForbidden,
+		},
+		{
+			name: "forwarded for ignored from untrusted peer", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"10.0.0.1"}, want: http.StatusForbidden,
+		},
+		{
+			name: "forwarded by trusted proxy", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", forwardedFor: []string{"10.0.0.1"}, want: http.StatusOK,
+		},
+		{
+			name: "forwarded ipv6 by trusted proxy", allow: []string{"2001:db8::/32"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", forwardedFor: []string{"2001:db8::1"}, want: http.StatusOK,
+		},
+		{
+			name: "client prepended address behind trusted proxy", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", forwardedFor: []string{"10.0.0.1, 192.0.2.1"}, want: http.StatusForbidden,
+		},
+		{
+			name: "client prepended header line behind trusted proxy", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", forwardedFor: []string{"10.0.0.1", "192.0.2.1"}, want: http.StatusForbidden,
+		},
+		{
+			name: "chain of trusted proxies", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", forwardedFor: []string{"192.0.2.1, 10.0.0.1, 172.16.0.2"}, want: http.StatusOK,
+		},
+		{
+			name: "real ip from trusted proxy", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", realIP: "10.0.0.1", want: http.StatusOK,
+		},
+		{
+			name: "trusted proxy itself outside", allow: []string{"10.0.0.0/8"}, trustedProxies: []string{"172.16.0.0/12"},
+			remoteAddr: "172.16.0.1:1234", want: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t, tt.trustedProxies...)
+			l, err := newIPAllowlist("admin", tt.allow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/reload", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-Ip", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			s.stripUntrustedProxyHeaders(l.handler(ok)).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowlistSet(t *testing.T) {
+	l, err := newIPAllowlist("internal", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.set([]string{"not a cidr"}); err == nil {
+		t.Fatal("set accepted an invalid CIDR")
+	}
+	if !l.allows("10.0.0.1") {
+		t.Error("an invalid CIDR replaced the networks")
+	}
+	if err := l.set([]string{"192.0.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if l.allows("10.0.0.1") || !l.allows("192.0.2.1") {
+		t.Error("set did not replace the networks")
+	}
+}
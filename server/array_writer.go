@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+)
+
+// errArrayWriterDone is returned by WriteItem once the response has ended
+var errArrayWriterDone = errors.New("array writer: response ended")
+
+// arrayWriterBuffer is the size of the buffer between an ArrayWriter and the response
+const arrayWriterBuffer = 32 << 10
+
+// ArrayWriter streams a JSON response whose list is written one item at a time, as the rows are scanned
+// the response is the one WriteJSON sends for data with the list, followed by a meta object with the count
+// and next_cursor, the cursor is also kept next to the list where the listings had it; requests opting in to the
+// envelope get its meta and links after the data object instead
+// nothing is sent before the first item or Close, an error until then is still answered with a normal error response
+type ArrayWriter struct {
+	w     http.ResponseWriter
+	out   *bufio.Writer
+	route string
+	// the data object up to the opening bracket of the list, and what closes the envelope after the data object
+	head, tail []byte
+	// set for requests opting in to the envelope, which then ends the response
+	envelope *metaWriter
+	// maximum response size in bytes, 0 is unlimited
+	limit   int
+	size    int
+	count   int
+	started bool
+	done    bool
+}
+
+// NewArrayWriter returns an ArrayWriter for data with the JSON array field, the list of data is left out of it
+// a list field that is not in the encoded data is an encoding bug, the response is then ErrInternalServer
+func NewArrayWriter(w http.ResponseWriter, data model.APIData, field string) *ArrayWriter {
+	data.GenerateMetaData()
+	aw := &ArrayWriter{w: w, route: "unknown route"}
+	if sw, ok := findSizeWriter(w); ok {
+		aw.route = sw.route
+		aw.limit = sw.limit
+	}
+	head, err := arrayHead(data, field)
+	if err != nil {
+		logging.Errorf("encoding response for %s: %s", aw.route, err)
+		WriteJSONError(w, ErrInternalServer)
+		aw.done = true
+		return aw
+	}
+	// requests opting in get the metadata envelope, version 2 and later send the data without the data envelope
+	switch mw, ok := findMetaWriter(w); {
+	case ok && mw.envelope:
+		aw.envelope = mw
+		aw.head = append([]byte(`{"data":`), head...)
+	case responseVersion(w) >= 2:
+		aw.head = head
+		aw.tail = []byte("}\n")
+	default:
+		aw.head = append([]byte(`{"data":`), head...)
+		aw.tail = []byte("}}\n")
+	}
+	return aw
+}
+
+// arrayHead encodes data without the list field, next_cursor and meta, and opens the list at the end of it
+// the fields keep the order they are encoded in
+func arrayHead(data interface{}, field string) ([]byte, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("array writer: %T is not encoded as an object", data)
+	}
+	head := []byte{'{'}
+	found := false
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		switch key {
+		case field:
+			found = true
+			continue
+		case "next_cursor", "meta":
+			continue
+		}
+		encodedKey, _ := json.Marshal(key)
+		head = append(head, encodedKey...)
+		head = append(head, ':')
+		head = append(head, value...)
+		head = append(head, ',')
+	}
+	if !found {
+		return nil, fmt.Errorf("array writer: %T has no field %q", data, field)
+	}
+	encodedField, _ := json.Marshal(field)
+	head = append(head, encodedField...)
+	return append(head, ':', '['), nil
+}
+
+// Sent returns true once the response, or an error in its place, is written, the caller must not write an error of its own
+func (aw *ArrayWriter) Sent() bool {
+	return aw.started || aw.done
+}
+
+// WriteItem adds v to the list, it returns an error once the response can not continue
+// the error has been taken care of, the caller only stops producing items
+func (aw *ArrayWriter) WriteItem(v interface{}) error {
+	if aw.done {
+		return errArrayWriterDone
+	}
+	item, err := json.Marshal(v)
+	if err != nil {
+		aw.Fail(err)
+		return err
+	}
+	if aw.count > 0 {
+		item = append([]byte{','}, item...)
+	}
+	// the end of the response is counted so that a page stopped at the limit can still be closed under it
+	pending := len(item) + len(aw.tail)
+	if !aw.started {
+		pending += len(aw.head)
+	}
+	if aw.limit > 0 && aw.size+pending > aw.limit {
+		responsesTooLarge.Add(1)
+		logging.Warnf("response for %s too large after %d items, limit %d", aw.route, aw.count, aw.limit)
+		aw.fail(ErrResponseTooLarge)
+		return errResponseTooLarge
+	}
+	if !aw.start() || !aw.write(item) {
+		return errArrayWriterDone
+	}
+	aw.count++
+	return nil
+}
+
+// Close ends the list with the meta object, next_cursor is left out when it is empty
+func (aw *ArrayWriter) Close(nextCursor string) {
+	if aw.done || !aw.start() {
+		return
+	}
+	meta := map[string]interface{}{"count": aw.count}
+	trailer := []byte{']'}
+	if nextCursor != "" {
+		encoded, _ := json.Marshal(nextCursor)
+		trailer = append(trailer, `,"next_cursor":`...)
+		trailer = append(trailer, encoded...)
+		meta["next_cursor"] = nextCursor
+	}
+	aw.end(trailer, meta, Count(aw.count), NextCursor(nextCursor))
+}
+
+// Fail ends the response after err, an internal error, the caller does not log it
+// before anything is sent the client gets ErrInternalServer, after it the list is truncated with an error element
+func (aw *ArrayWriter) Fail(err error) {
+	if aw.done {
+		return
+	}
+	if clientGone(err) {
+		logging.Debugf("writing response for %s: %s", aw.route, err)
+	} else {
+		logging.Warnf("response for %s failed after %d items: %s", aw.route, aw.count, err)
+	}
+	aw.fail(ErrInternalServer)
+}
+
+// fail answers jsonErr before the response is sent, and truncates the list with it after
+// the last element of a truncated list is {"error": jsonErr} and its meta has truncated set
+func (aw *ArrayWriter) fail(jsonErr *model.JSONError) {
+	if !aw.started {
+		aw.done = true
+		WriteJSONError(aw.w, jsonErr)
+		return
+	}
+	sentinel, _ := json.Marshal(map[string]*model.JSONError{"error": jsonErr})
+	trailer := []byte{}
+	if aw.count > 0 {
+		trailer = append(trailer, ',')
+	}
+	trailer = append(trailer, sentinel...)
+	trailer = append(trailer, ']')
+	aw.end(trailer, map[string]interface{}{"count": aw.count, "truncated": true}, Count(aw.count), truncated())
+}
+
+// end writes trailer, the meta object and the end of the envelope
+// opts are the meta of the requests opting in to the envelope, meta that of the others
+func (aw *ArrayWriter) end(trailer []byte, meta map[string]interface{}, opts ...MetaOption) {
+	if aw.envelope != nil {
+		// {"meta":...,"links":...} continues the envelope after the data object
+		encoded, _ := json.Marshal(aw.envelope.build(opts))
+		trailer = append(trailer, '}', ',')
+		trailer = append(trailer, encoded[1:]...)
+		trailer = append(trailer, '\n')
+	} else {
+		encoded, _ := json.Marshal(meta)
+		trailer = append(trailer, `,"meta":`...)
+		trailer = append(trailer, encoded...)
+		trailer = append(trailer, aw.tail...)
+	}
+	if aw.write(trailer) {
+		if err := aw.out.Flush(); err != nil {
+			aw.writeFailed(err)
+		}
+	}
+	aw.done = true
+}
+
+// start sends the status and the head of the response, once
+func (aw *ArrayWriter) start() bool {
+	if aw.started {
+		return true
+	}
+	aw.started = true
+	aw.w.Header().Set("Content-Type", "application/json")
+	aw.w.WriteHeader(http.StatusOK)
+	aw.out = bufio.NewWriterSize(aw.w, arrayWriterBuffer)
+	return aw.write(aw.head)
+}
+
+// write writes p to the response, a write error ends it
+func (aw *ArrayWriter) write(p []byte) bool {
+	aw.size += len(p)
+	if _, err := aw.out.Write(p); err != nil {
+		aw.writeFailed(err)
+		return false
+	}
+	return true
+}
+
+// writeFailed logs a write error, the response is left as it is
+func (aw *ArrayWriter) writeFailed(err error) {
+	aw.done = true
+	if clientGone(err) {
+		logging.Debugf("writing response for %s: %s", aw.route, err)
+		return
+	}
+	logging.Warnf("writing response for %s: %s", aw.route, err)
+}
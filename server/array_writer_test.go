@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+// arrayResponse is the response of an ArrayWriter for model.ZoneDomains, the meta object follows the list
+type arrayResponse struct {
+	Data struct {
+		Zone       string            `json:"zone"`
+		Domains    []json.RawMessage `json:"domains"`
+		NextCursor string            `json:"next_cursor"`
+		Meta       struct {
+			Count      int    `json:"count"`
+			NextCursor string `json:"next_cursor"`
+			Truncated  bool   `json:"truncated"`
+		} `json:"meta"`
+	} `json:"data"`
+}
+
+func TestArrayWriter(t *testing.T) {
+	items := []*model.ZoneDomain{{Name: "A.ORG", Active: true}, {Name: "B.ORG"}, {Name: "C.ORG", Active: true}}
+	tests := []struct {
+		name string
+		// items written, then Close with the cursor, or Fail with err
+		items  int
+		cursor string
+		err    error
+		// the response size limit, 0 is unlimited
+		limit int
+		field string
+		want  int
+		code  string
+		// items in the list, and whether the list ends with an error element
+		wantItems     int
+		wantTruncated bool
+	}{
+		{name: "page", items: 3, cursor: "next", want: http.StatusOK, wantItems: 3},
+		{name: "last page", items: 2, want: http.StatusOK, wantItems: 2},
+		{name: "empty", want: http.StatusOK},
+		{name: "failed before an item", err: errors.New("connection reset"), want: http.StatusInternalServerError, code: "internal_server_error"},
+		{name: "failed after items", items: 2, err: errors.New("connection reset"), want: http.StatusOK, wantItems: 2, wantTruncated: true},
+		{name: "too large after items", items: 3, limit: 150, want: http.StatusOK, wantItems: 1, wantTruncated: true},
+		{name: "too large at once", items: 3, limit: 20, want: http.StatusInternalServerError, code: "response_too_large"},
+		{name: "missing field", items: 1, field: "names", want: http.StatusInternalServerError, code: "internal_server_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var w http.ResponseWriter = rec
+			if tt.limit > 0 {
+				w = &sizeWriter{ResponseWriter: rec, route: "/test/array", limit: tt.limit}
+			}
+			field := tt.field
+			if field == "" {
+				field = "domains"
+			}
+			aw := NewArrayWriter(w, &model.ZoneDomains{Zone: "ORG", Active: "all"}, field)
+			for _, item := range items[:tt.items] {
+				if err := aw.WriteItem(item); err != nil {
+					break
+				}
+			}
+			if tt.err != nil {
+				aw.Fail(tt.err)
+			} else {
+				aw.Close(tt.cursor)
+			}
+			if !aw.Sent() {
+				t.Error("response not sent")
+			}
+			if err := aw.WriteItem(items[0]); err == nil {
+				t.Error("item written after the response ended")
+			}
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if tt.code != "" {
+				if !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("got %s, want the error %s", rec.Body, tt.code)
+				}
+				return
+			}
+
+			var resp arrayResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: %s", err, rec.Body)
+			}
+			wantLen := tt.wantItems
+			if tt.wantTruncated {
+				wantLen++
+			}
+			if resp.Data.Zone != "ORG" || len(resp.Data.Domains) != wantLen || resp.Data.Meta.Count != tt.wantItems {
+				t.Fatalf("got %s, want %d items", rec.Body, tt.wantItems)
+			}
+			if resp.Data.Meta.Truncated != tt.wantTruncated {
+				t.Errorf("got truncated %t, want %t", resp.Data.Meta.Truncated, tt.wantTruncated)
+			}
+			if tt.wantTruncated && !strings.Contains(string(resp.Data.Domains[wantLen-1]), `{"error":{"code":`) {
+				t.Errorf("got the last element %s, want an error", resp.Data.Domains[wantLen-1])
+			}
+			if resp.Data.NextCursor != tt.cursor || resp.Data.Meta.NextCursor != tt.cursor {
+				t.Errorf("got next cursors %q and %q, want %q", resp.Data.NextCursor, resp.Data.Meta.NextCursor, tt.cursor)
+			}
+		})
+	}
+}
+
+// TestArrayWriterConnectionLost stops the list once the client is gone
+func TestArrayWriterConnectionLost(t *testing.T) {
+	w := &brokenWriter{h: http.Header{}, err: syscall.EPIPE}
+	aw := NewArrayWriter(w, &model.ZoneDomains{Zone: "ORG"}, "domains")
+	// the items are buffered, the write error ends the response when they are flushed
+	for i := 0; i < 10; i++ {
+		if err := aw.WriteItem(&model.ZoneDomain{Name: "A.ORG"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aw.Close("")
+	if w.status != http.StatusOK {
+		t.Errorf("got status %d", w.status)
+	}
+	if err := aw.WriteItem(&model.ZoneDomain{Name: "B.ORG"}); err != errArrayWriterDone {
+		t.Errorf("got %v after the connection was lost, want %v", err, errArrayWriterDone)
+	}
+	// failing after the connection was lost writes nothing more
+	aw.Fail(errors.New("scan failed"))
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/reqstats"
+
+	"github.com/gorilla/mux"
+)
+
+// audit log counters
+var (
+	auditWritten = expvar.NewInt("audit_records_written")
+	auditFailed  = expvar.NewInt("audit_records_failed")
+	auditDropped = expvar.NewInt("audit_records_dropped")
+)
+
+const (
+	// auditBatchSize is the most records written to the sink at once
+	auditBatchSize = 500
+	// auditEnqueueWait is how long a request waits for room in a full queue before its record is dropped
+	auditEnqueueWait = 100 * time.Millisecond
+	// auditWriteTimeout bounds writing a batch to the sink
+	auditWriteTimeout = 30 * time.Second
+	// auditQueryLimit is the default and maxAuditQueryLimit the largest number of records of GET /api/admin/audit
+	auditQueryLimit    = 1000
+	maxAuditQueryLimit = 10000
+)
+
+// AuditSink stores the audit records of the requests authenticated with an API key
+type AuditSink interface {
+	// WriteAudit stores a batch of records in time order
+	WriteAudit(ctx context.Context, records []*model.AuditRecord) error
+	// QueryAudit returns up to limit records of key, or of every key when empty, from since on, oldest first
+	QueryAudit(ctx context.Context, key string, since time.Time, limit int) ([]*model.AuditRecord, error)
+}
+
+// auditQueue writes records to the sink in batches in the background
+// a full queue holds requests back for auditEnqueueWait before dropping their record
+type auditQueue struct {
+	sink  AuditSink
+	queue chan *model.AuditRecord
+	done  chan struct{}
+
+	// held by add while sending, close takes it to stop accepting records
+	mu       sync.RWMutex
+	closed   bool
+	lastWarn time.Time
+	warnMu   sync.Mutex
+}
+
+func newAuditQueue(sink AuditSink, size int) *auditQueue {
+	q := &auditQueue{
+		sink:  sink,
+		queue: make(chan *model.AuditRecord, size),
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *auditQueue) run() {
+	defer close(q.done)
+	batch := make([]*model.AuditRecord, 0, auditBatchSize)
+	for record := range q.queue {
+		batch = append(batch[:0], record)
+	fill:
+		for len(batch) < auditBatchSize {
+			select {
+			case record, ok := <-q.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, record)
+			default:
+				break fill
+			}
+		}
+		q.write(batch)
+	}
+}
+
+// write sends a batch to the sink, a failed batch is counted and logged, it is not retried
+func (q *auditQueue) write(batch []*model.AuditRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditWriteTimeout)
+	defer cancel()
+	err := q.sink.WriteAudit(ctx, batch)
+	if err != nil {
+		auditFailed.Add(int64(len(batch)))
+		logging.Errorf("audit: writing %d records failed: %s", len(batch), err)
+		return
+	}
+	auditWritten.Add(int64(len(batch)))
+}
+
+// add queues a record, waiting up to auditEnqueueWait when the queue is full
+// records added after close are dropped
+func (q *auditQueue) add(record *model.AuditRecord) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		auditDropped.Add(1)
+		return
+	}
+	select {
+	case q.queue <- record:
+		return
+	default:
+	}
+	timer := time.NewTimer(auditEnqueueWait)
+	defer timer.Stop()
+	select {
+	case q.queue <- record:
+	case <-timer.C:
+		auditDropped.Add(1)
+		q.warnDropped()
+	}
+}
+
+// warnDropped logs dropped records at most once a minute
+func (q *auditQueue) warnDropped() {
+	q.warnMu.Lock()
+	defer q.warnMu.Unlock()
+	if time.Since(q.lastWarn) < time.Minute {
+		return
+	}
+	q.lastWarn = time.Now()
+	logging.Warnf("audit: the queue of %d records is full, records are dropped, %d so far", cap(q.queue), auditDropped.Value())
+}
+
+// close writes the queued records and stops the queue, giving up when ctx is done
+func (q *auditQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetAuditSink records every request authenticated with an API key to sink through a queue of queueSize records
+// and serves the records at GET /api/admin/audit, anonymous requests are never recorded
+// it must be called before Start
+func (s *Server) SetAuditSink(sink AuditSink, queueSize int) {
+	s.audits = newAuditQueue(sink, queueSize)
+	s.Admin(http.MethodGet, "/audit", s.adminAuditHandler)
+}
+
+// audit is a router middleware queueing an audit record of the requests carrying an API key once they are answered
+// the rows are those the request's queries read, collected like the debug stats
+func (s *Server) audit(next http.Handler) http.Handler {
+	if s.audits == nil {
+		return next
+	}
+	reqstats.Enable()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _ := r.Context().Value(scopesKey{}).(*apiKey)
+		if key == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		stats := reqstats.FromContext(ctx)
+		if stats == nil {
+			ctx, stats = reqstats.WithStats(ctx)
+			r = r.WithContext(ctx)
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route, _ = cr.GetPathTemplate()
+		}
+		params := mux.Vars(r)
+		if len(params) == 0 {
+			params = nil
+		}
+		s.audits.add(&model.AuditRecord{
+			Time:      model.Now(),
+			Key:       key.name,
+			RequestID: RequestID(ctx),
+			Method:    r.Method,
+			Route:     route,
+			Params:    params,
+			Query:     sanitizeQuery(r.URL.Query()),
+			Status:    sw.status,
+			Rows:      stats.Rows(),
+		})
+	})
+}
+
+// adminAuditHandler returns the audit records of ?key=, or of every key, from ?since= on, oldest first
+// since is a RFC 3339 time or any date ParseDateParam accepts and defaults to 24 hours ago, ?limit= caps the records
+func (s *Server) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since := time.Now().UTC().Add(-24 * time.Hour)
+	if value := query.Get("since"); value != "" {
+		// a RFC 3339 time is kept to the second, other dates start at their UTC day
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			var jsonErr *model.JSONError
+			t, jsonErr = ParseDateParam("since", value)
+			if jsonErr != nil {
+				WriteJSONError(w, jsonErr)
+				return
+			}
+		}
+		since = t.UTC()
+	}
+	limit := auditQueryLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditQueryLimit {
+			WriteJSONError(w, NewFieldError("limit", "must be an integer from 1 to "+strconv.Itoa(maxAuditQueryLimit)))
+			return
+		}
+		limit = n
+	}
+	key := query.Get("key")
+	records, err := s.audits.sink.QueryAudit(r.Context(), key, since, limit)
+	if err != nil {
+		logging.Errorf("audit: query: %s", err)
+		WriteJSONError(w, ErrInternalServer)
+		return
+	}
+	if records == nil {
+		records = []*model.AuditRecord{}
+	}
+	WriteJSON(w, &model.AuditLog{Key: key, Since: model.NewTimestamp(since), Records: records})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// fakeAuditSink keeps the records written to it and the arguments of its last query
+type fakeAuditSink struct {
+	mu       sync.Mutex
+	records  []*model.AuditRecord
+	writeErr error
+
+	queryErr   error
+	queryKey   string
+	querySince time.Time
+	queryLimit int
+}
+
+func (s *fakeAuditSink) WriteAudit(ctx context.Context, records []*model.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *fakeAuditSink) QueryAudit(ctx context.Context, key string, since time.Time, limit int) ([]*model.AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queryKey, s.querySince, s.queryLimit = key, since, limit
+	if s.queryErr != nil {
+		return nil, s.queryErr
+	}
+	return s.records, nil
+}
+
+// TestAuditRequests records the requests with an API key once answered, the anonymous ones are not
+func TestAuditRequests(t *testing.T) {
+	conf := testAPIConfig
+	conf.APIKeys = []APIKey{{Name: "research", Key: "secret-research"}}
+	s, err := New([]string{"127.0.0.1:0"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &fakeAuditSink{}
+	s.SetAuditSink(sink, 10)
+	s.Get("/api/test/audit/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	public, _ := s.handlers()
+	// the request ID is set by the outer middleware
+	h := s.outer(public)
+	written, failed := auditWritten.Value(), auditFailed.Value()
+
+	for _, key := range []string{"", "secret-research"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/test/audit/example?limit=5&api_key=x", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if key != "" {
+			r.Header.Set(apiKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("key %q: got status %d %s", key, w.Code, w.Body)
+		}
+	}
+	// closing the queue writes the records queued
+	if err := s.audits.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("recorded %d requests, want the one with a key", len(sink.records))
+	}
+	got := sink.records[0]
+	want := &model.AuditRecord{
+		Key:    "research",
+		Method: http.MethodGet,
+		Route:  "/api/test/audit/{name}",
+		Params: map[string]string{"name": "example"},
+		Query:  map[string]string{"limit": "5", "api_key": "[redacted]"},
+		Status: http.StatusAccepted,
+	}
+	if got.Time.IsZero() || got.RequestID == "" {
+		t.Errorf("record without its time or request ID: %+v", got)
+	}
+	got.Time, got.RequestID = model.Timestamp{}, ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if n := auditWritten.Value() - written; n != 1 {
+		t.Errorf("counted %d records written, want 1", n)
+	}
+	if n := auditFailed.Value() - failed; n != 0 {
+		t.Errorf("counted %d records failed, want 0", n)
+	}
+
+	// records after the queue is closed are dropped
+	dropped := auditDropped.Value()
+	s.audits.add(&model.AuditRecord{Key: "research"})
+	if n := auditDropped.Value() - dropped; n != 1 {
+		t.Errorf("counted %d records dropped after close, want 1", n)
+	}
+}
+
+// TestAuditWriteFailure counts the records of a batch the sink failed to write
+func TestAuditWriteFailure(t *testing.T) {
+	q := newAuditQueue(&fakeAuditSink{writeErr: errors.New("disk full")}, 10)
+	failed := auditFailed.Value()
+	q.add(&model.AuditRecord{Key: "research"})
+	q.add(&model.AuditRecord{Key: "research"})
+	if err := q.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := auditFailed.Value() - failed; n != 2 {
+		t.Errorf("counted %d records failed, want 2", n)
+	}
+}
+
+func TestAdminAuditHandler(t *testing.T) {
+	record := &model.AuditRecord{Key: "research", Method: http.MethodGet, Route: "/api/domains/{domain}", Status: http.StatusOK}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		query    string
+		records  []*model.AuditRecord
+		queryErr error
+		want     int
+		code     string
+		// the arguments of the query, a zero since is about 24 hours ago
+		wantKey   string
+		wantSince time.Time
+		wantLimit int
+	}{
+		{name: "defaults", records: []*model.AuditRecord{record}, want: http.StatusOK, wantLimit: auditQueryLimit},
+		{name: "no records", want: http.StatusOK, wantLimit: auditQueryLimit},
+		{name: "key and limit", query: "?key=research&limit=10", records: []*model.AuditRecord{record}, want: http.StatusOK, wantKey: "research", wantLimit: 10},
+		{name: "since time", query: "?since=2024-03-01T12:30:15%2B02:00", want: http.StatusOK, wantSince: day.Add(10*time.Hour + 30*time.Minute + 15*time.Second), wantLimit: auditQueryLimit},
+		{name: "since date", query: "?since=2024-03-01", want: http.StatusOK, wantSince: day, wantLimit: auditQueryLimit},
+		{name: "invalid since", query: "?since=yesterday", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "limit not a number", query: "?limit=all", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "limit zero", query: "?limit=0", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "limit too large", query: "?limit=10001", want: http.StatusBadRequest, code: "invalid_parameter"},
+		{name: "query fails", queryErr: errors.New("connection refused"), want: http.StatusInternalServerError, code: "internal_server_error", wantLimit: auditQueryLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeAuditSink{records: tt.records, queryErr: tt.queryErr}
+			s := &Server{audits: &auditQueue{sink: sink}}
+			w := httptest.NewRecorder()
+			start := time.Now().UTC()
+			s.adminAuditHandler(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+			if tt.want == http.StatusBadRequest {
+				if sink.queryLimit != 0 {
+					t.Error("invalid request queried the sink")
+				}
+				return
+			}
+			if sink.queryKey != tt.wantKey || sink.queryLimit != tt.wantLimit {
+				t.Errorf("queried key %q limit %d, want %q limit %d", sink.queryKey, sink.queryLimit, tt.wantKey, tt.wantLimit)
+			}
+			wantSince := tt.wantSince
+			if wantSince.IsZero() {
+				wantSince = start.Add(-24 * time.Hour)
+				if d := sink.querySince.Sub(wantSince); d < 0 || d > time.Minute {
+					t.Errorf("queried since %s, want about %s", sink.querySince, wantSince)
+				}
+			} else if !sink.querySince.Equal(wantSince) {
+				t.Errorf("queried since %s, want %s", sink.querySince, wantSince)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var body struct{ Data model.AuditLog }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.Key != tt.wantKey || len(body.Data.Records) != len(tt.records) {
+				t.Errorf("got key %q and %d records, want %q and %d", body.Data.Key, len(body.Data.Records), tt.wantKey, len(tt.records))
+			}
+			// no records is an empty list, not null
+			if !strings.Contains(w.Body.String(), `"records":[`) {
+				t.Errorf("got %s, want a list of records", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"dnscoffee/model"
+)
+
+// bodyKey is the context key of the request body decoded by Body
+type bodyKey struct{}
+
+// bodyRules are the validate rules of the fields of a struct type, in field order
+type bodyRules []*fieldRules
+
+// fieldRules are the validate rules of a struct field
+// the tag is a comma separated list of required, min=N, max=N and pattern=RE, pattern must come last as RE may hold commas
+// min and max bound the length of strings in characters, the items of arrays and maps and the value of numbers
+type fieldRules struct {
+	index    int
+	name     string
+	required bool
+	min, max *float64
+	pattern  *regexp.Regexp
+	// rules of the struct the field holds, points to or has items of
+	nested bodyRules
+}
+
+// Body decodes the JSON body of the requests into a new value of the struct type proto points to and validates it
+// before calling fn, which reads it with RequestBody
+// unknown fields, values of the wrong type and bodies over maxBytes are rejected, and every field breaking its validate
+// rules is reported at once, named by JSON pointer in meta.pointer; the rules are checked when the route is registered
+func Body(proto interface{}, maxBytes int64, fn http.HandlerFunc) http.HandlerFunc {
+	t := reflect.TypeOf(proto)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("body: %T is not a pointer to a struct", proto))
+	}
+	t = t.Elem()
+	rules, err := newBodyRules(t)
+	if err != nil {
+		panic(fmt.Sprintf("body %s: %s", t, err))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := reflect.New(t)
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(body.Interface())
+		if err != nil {
+			WriteJSONErrors(w, decodeErrors(err))
+			return
+		}
+		var violations []*model.JSONError
+		rules.check(body.Elem(), "", &violations)
+		if len(violations) > 0 {
+			WriteJSONErrors(w, violations)
+			return
+		}
+		fn(w, r.WithContext(context.WithValue(r.Context(), bodyKey{}, body.Interface())))
+	}
+}
+
+// RequestBody returns the body decoded by Body, a pointer of the type of its proto
+func RequestBody(r *http.Request) interface{} {
+	return r.Context().Value(bodyKey{})
+}
+
+// decodeErrors returns the errors of a body that could not be decoded
+func decodeErrors(err error) []*model.JSONError {
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return []*model.JSONError{ErrRequestTooLarge}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return []*model.JSONError{bodyFieldError("/"+strings.ReplaceAll(typeErr.Field, ".", "/"), "must be "+jsonKind(typeErr.Type))}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder does not say how deep the field is, only its name
+		name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return []*model.JSONError{bodyFieldError("/"+escapePointer(name), "is not a known field")}
+	case err == io.EOF:
+		return []*model.JSONError{FieldError(ErrBadRequest, "body", "is empty")}
+	}
+	return []*model.JSONError{ErrBadRequest}
+}
+
+// jsonKind names the JSON type of values decoded into t
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Ptr:
+		return jsonKind(t.Elem())
+	}
+	return "an object"
+}
+
+// bodyFieldError returns the ErrInvalidParameter of the body field at pointer, named with dots in meta.field
+func bodyFieldError(pointer, reason string) *model.JSONError {
+	jsonErr := FieldError(ErrInvalidParameter, strings.ReplaceAll(strings.TrimPrefix(pointer, "/"), "/", "."), reason)
+	jsonErr.Meta["pointer"] = pointer
+	return jsonErr
+}
+
+// escapePointer escapes a field name as a JSON pointer reference token, RFC 6901
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// newBodyRules parses the validate tags of the fields of the struct type t and of the structs they hold
+func newBodyRules(t reflect.Type) (bodyRules, error) {
+	var rules bodyRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fr := &fieldRules{index: i, name: name}
+		tag := f.Tag.Get("validate")
+		for tag != "" {
+			var rule string
+			if strings.HasPrefix(tag, "pattern=") {
+				rule, tag = tag, ""
+			} else if i := strings.IndexByte(tag, ','); i >= 0 {
+				rule, tag = tag[:i], tag[i+1:]
+			} else {
+				rule, tag = tag, ""
+			}
+			err := fr.parse(rule)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", f.Name, err)
+			}
+		}
+		held := f.Type
+		for held.Kind() == reflect.Ptr || held.Kind() == reflect.Slice || held.Kind() == reflect.Array {
+			held = held.Elem()
+		}
+		if held.Kind() == reflect.Struct {
+			nested, err := newBodyRules(held)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", f.Name, err)
+			}
+			fr.nested = nested
+		}
+		rules = append(rules, fr)
+	}
+	return rules, nil
+}
+
+// parse adds a rule of a validate tag
+func (fr *fieldRules) parse(rule string) error {
+	key, value, _ := strings.Cut(rule, "=")
+	switch key {
+	case "required":
+		fr.required = true
+	case "min", "max":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", key, value)
+		}
+		if key == "min" {
+			fr.min = &n
+		} else {
+			fr.max = &n
+		}
+	case "pattern":
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+		fr.pattern = re
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+// check adds the violations of the rules by the struct v, at the JSON pointer prefix, to violations
+func (rules bodyRules) check(v reflect.Value, prefix string, violations *[]*model.JSONError) {
+	for _, fr := range rules {
+		fr.check(v.Field(fr.index), prefix+"/"+escapePointer(fr.name), violations)
+	}
+}
+
+// check adds the violations of the field value v at pointer to violations
+// fields left out, or set to their zero value, only break the required rule
+func (fr *fieldRules) check(v reflect.Value, pointer string, violations *[]*model.JSONError) {
+	if v.IsZero() {
+		if fr.required {
+			*violations = append(*violations, bodyFieldError(pointer, "is required"))
+		}
+		return
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var size float64
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+		if fr.pattern != nil && !fr.pattern.MatchString(v.String()) {
+			*violations = append(*violations, bodyFieldError(pointer, "must match "+strings.TrimSuffix(strings.TrimPrefix(fr.pattern.String(), "^(?:"), ")$")))
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	}
+	if fr.min != nil && size < *fr.min {
+		*violations = append(*violations, bodyFieldError(pointer, "must be at least "+formatBound(*fr.min)+unit))
+	}
+	if fr.max != nil && size > *fr.max {
+		*violations = append(*violations, bodyFieldError(pointer, "must be at most "+formatBound(*fr.max)+unit))
+	}
+	if fr.nested == nil {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fr.nested.check(v, pointer, violations)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i)
+			for item.Kind() == reflect.Ptr && !item.IsNil() {
+				item = item.Elem()
+			}
+			if item.Kind() == reflect.Struct {
+				fr.nested.check(item, pointer+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	}
+}
+
+// formatBound formats the bound of a min or max rule
+func formatBound(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
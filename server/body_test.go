@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+// testItem is an item of testBody, its rules are checked for every item
+type testItem struct {
+	Label string `json:"label" validate:"required,pattern=[a-z]+"`
+}
+
+// testBody has a field of every kind of rule
+type testBody struct {
+	Name   string            `json:"name" validate:"required,max=5"`
+	Count  int               `json:"count" validate:"min=1,max=10"`
+	Ratio  *float64          `json:"ratio" validate:"max=0.5"`
+	Tags   map[string]string `json:"tags" validate:"max=2"`
+	Items  []testItem        `json:"items" validate:"max=3"`
+	Slash  string            `json:"a/b" validate:"pattern=x,y"`
+	Hidden string            `json:"-"`
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// status, then the code and pointer of each error, nil for the accepted bodies
+		want     int
+		code     string
+		pointers []string
+	}{
+		{name: "valid", body: `{"name": "héllo", "count": 10, "ratio": 0.5, "tags": {"a": "b"}, "items": [{"label": "ok"}], "a/b": "x,y"}`, want: http.StatusOK},
+		{name: "only required", body: `{"name": "a"}`, want: http.StatusOK},
+		{name: "required missing", body: `{"count": 1}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/name"}},
+		{name: "required empty", body: `{"name": ""}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/name"}},
+		{
+			name: "every violation", body: `{"name": "toolong", "count": 11, "ratio": 0.75, "tags": {"a": "", "b": "", "c": ""}, "items": [{"label": "ok"}, {"label": "NO"}, {}], "a/b": "x"}`,
+			want: http.StatusBadRequest, code: "invalid_parameter",
+			pointers: []string{"/name", "/count", "/ratio", "/tags", "/items/1/label", "/items/2/label", "/a~1b"},
+		},
+		{name: "too many items", body: `{"name": "a", "items": [{"label": "a"}, {"label": "b"}, {"label": "c"}, {"label": "d"}]}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/items"}},
+		{name: "under min", body: `{"name": "a", "count": -1}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/count"}},
+		{name: "unknown field", body: `{"name": "a", "extra": 1}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/extra"}},
+		{name: "ignored field", body: `{"name": "a", "Hidden": "x"}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/Hidden"}},
+		{name: "wrong type", body: `{"name": 5}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/name"}},
+		{name: "wrong nested type", body: `{"name": "a", "items": [{"label": true}]}`, want: http.StatusBadRequest, code: "invalid_parameter", pointers: []string{"/items/0/label"}},
+		{name: "empty", body: ``, want: http.StatusBadRequest, code: "bad_request"},
+		{name: "malformed", body: `{"name": `, want: http.StatusBadRequest, code: "bad_request"},
+		{name: "not an object", body: `[1]`, want: http.StatusBadRequest, code: "bad_request"},
+		{name: "too large", body: `{"name": "` + strings.Repeat("a", 300) + `"}`, want: http.StatusRequestEntityTooLarge, code: "request_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *testBody
+			h := Body(&testBody{}, 256, func(w http.ResponseWriter, r *http.Request) {
+				got = RequestBody(r).(*testBody)
+				w.WriteHeader(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				if got == nil || got.Name == "" {
+					t.Errorf("the handler got the body %+v", got)
+				}
+				return
+			}
+			if got != nil {
+				t.Error("the handler was called with an invalid body")
+			}
+			var body model.JSONErrors
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			var pointers []string
+			for _, jsonErr := range body.Errors {
+				if jsonErr.ID != tt.code || jsonErr.Status != tt.want {
+					t.Errorf("got error %s %d, want %s %d", jsonErr.ID, jsonErr.Status, tt.code, tt.want)
+				}
+				if p, ok := jsonErr.Meta["pointer"]; ok {
+					pointers = append(pointers, p)
+				}
+			}
+			if len(tt.pointers) == 0 && len(body.Errors) != 1 {
+				t.Errorf("got %d errors, want 1", len(body.Errors))
+			}
+			if !reflect.DeepEqual(pointers, tt.pointers) {
+				t.Errorf("got pointers %v, want %v", pointers, tt.pointers)
+			}
+		})
+	}
+}
+
+func TestBodyFieldError(t *testing.T) {
+	jsonErr := bodyFieldError("/items/1/label", "is required")
+	if jsonErr.Meta["field"] != "items.1.label" || jsonErr.Meta["pointer"] != "/items/1/label" || jsonErr.Detail != "Parameter items.1.label is required." {
+		t.Errorf("got %+v", jsonErr)
+	}
+	if ErrInvalidParameter.Meta != nil {
+		t.Errorf("ErrInvalidParameter was changed to %+v", ErrInvalidParameter)
+	}
+}
+
+// TestBodyInvalidRules panics when a route is registered with rules that can not be checked
+func TestBodyInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto interface{}
+	}{
+		{name: "not a pointer", proto: testBody{}},
+		{name: "not a struct", proto: new(string)},
+		{name: "unknown rule", proto: &struct {
+			Name string `validate:"requried"`
+		}{}},
+		{name: "bad bound", proto: &struct {
+			Name string `validate:"max=ten"`
+		}{}},
+		{name: "bad pattern", proto: &struct {
+			Name string `validate:"pattern=[a-"`
+		}{}},
+		{name: "bad nested rule", proto: &struct {
+			Items []struct {
+				Name string `validate:"min"`
+			}
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("registered without a panic")
+				}
+			}()
+			Body(tt.proto, 1024, func(w http.ResponseWriter, r *http.Request) {})
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"dnscoffee/reqstats"
+)
+
+// responseCacheStats counts the hits and misses of every response cache by name
+var responseCacheStats = expvar.NewMap("response_cache")
+
+// maxCachedResponse is the largest response body kept in a response cache
+const maxCachedResponse = maxBufferedResponse
+
+// ResponseCache caches encoded responses, hits skip both the handler's queries and the JSON encoding
+// entries are evicted least recently used first once the cache is full
+type ResponseCache struct {
+	name string
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+// cachedResponse is a successful response stored in a ResponseCache
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+	// zero never expires
+	expires time.Time
+}
+
+// NewResponseCache returns a cache of at most size responses, the hits and misses are exported under name
+// the cache is emptied by POST /api/admin/cache/flush
+func (s *Server) NewResponseCache(name string, size int) *ResponseCache {
+	c := &ResponseCache{
+		name:    name,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	s.AddCacheFlusher(name, c.Flush)
+	return c
+}
+
+func (c *ResponseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cachedResponse)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry
+}
+
+func (c *ResponseCache) put(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// Flush removes every entry
+func (c *ResponseCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Handler serves next through the cache keyed on the request URI
+// ttl returns how long the response to r may be cached, 0 never expires and a negative ttl is not cached
+// only 200 responses are cached
+func (c *ResponseCache) Handler(ttl func(r *http.Request) time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if c.size <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// recorded responses are built anew, the meta of a cached one holds another recording ID
+		if r.URL.Query().Get("record") != "" {
+			next(w, r)
+			return
+		}
+		key := r.URL.RequestURI() + cacheKey(r.Context())
+		if r.Header.Get(EnvelopeHeader) != "" {
+			key += "\x00envelope"
+		}
+		entry := c.get(key)
+		reqstats.FromContext(r.Context()).Cache(entry != nil)
+		if entry != nil {
+			responseCacheStats.Add(c.name+"_hits", 1)
+			w.Header().Set("Content-Type", entry.contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(entry.body)))
+			w.Header().Set("X-Cache", "HIT")
+			_, err := w.Write(entry.body)
+			if err != nil {
+				writeFailed(w, err)
+			}
+			return
+		}
+		responseCacheStats.Add(c.name+"_misses", 1)
+		w.Header().Set("X-Cache", "MISS")
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		var out http.ResponseWriter = cw
+		// keep the response size limit of WriteJSON
+		if sw, ok := findSizeWriter(w); ok {
+			out = &sizeWriter{ResponseWriter: cw, route: sw.route, limit: sw.limit}
+		}
+		next(out, r)
+
+		d := ttl(r)
+		if cw.status != http.StatusOK || cw.uncacheable || d < 0 {
+			return
+		}
+		entry = &cachedResponse{
+			key:         key,
+			contentType: w.Header().Get("Content-Type"),
+			body:        cw.body.Bytes(),
+		}
+		if d > 0 {
+			entry.expires = time.Now().Add(d)
+		}
+		c.put(entry)
+	}
+}
+
+// captureWriter keeps a copy of the response written through it
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	// set when the body is too large to cache or could not be written
+	uncacheable bool
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if !cw.uncacheable {
+		if cw.body.Len()+len(p) > maxCachedResponse {
+			cw.uncacheable = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	if err != nil {
+		cw.uncacheable = true
+	}
+	return n, err
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"dnscoffee/logging"
+)
+
+// commitWriter tracks whether the response has been committed, its status sent to the client
+// once the request's context is done, by the timeout or the client going away,
+// further writes are dropped instead of reaching a response nobody reads
+type commitWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+
+	mu        sync.Mutex
+	committed bool
+}
+
+// Committed returns true once the status of the response has been written
+// or the request ended, in either case it is too late to write an error
+func (cw *commitWriter) Committed() bool {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.committed || cw.ctx.Err() != nil
+}
+
+func (cw *commitWriter) WriteHeader(status int) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.committed || cw.ctx.Err() != nil {
+		logging.Debugf("dropping response status %d, response already committed", status)
+		return
+	}
+	cw.committed = true
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *commitWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, http.ErrHandlerTimeout
+		}
+		return 0, err
+	}
+	cw.committed = true
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *commitWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// trackCommits is a router middleware that wraps the response in a commitWriter
+// it runs inside the timeout handler, whose deadline cancels the request's context
+func trackCommits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&commitWriter{ResponseWriter: w, ctx: r.Context()}, r)
+	})
+}
+
+// committed returns true if the response written to w, or any writer it wraps, has been committed
+func committed(w http.ResponseWriter) bool {
+	for {
+		if cw, ok := w.(interface{ Committed() bool }); ok {
+			return cw.Committed()
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
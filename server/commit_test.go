@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// serveTimed serves r with h behind the timeout handler and commit tracking, as the chain of handlers does
+func serveTimed(h http.HandlerFunc, d time.Duration, r *http.Request) *httptest.ResponseRecorder {
+	s := &Server{router: mux.NewRouter()}
+	w := httptest.NewRecorder()
+	s.timeout(trackCommits(h), d).ServeHTTP(w, r)
+	return w
+}
+
+// checkTimeoutResponse fails unless w holds the timeout error and nothing else
+func checkTimeoutResponse(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var body model.JSONErrors
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %s", w.Body, err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].ID != ErrTimeout.ID {
+		t.Errorf("got body %q, want only the timeout error", w.Body)
+	}
+}
+
+func TestWritesAfterTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+	}{
+		{"json", func(w http.ResponseWriter) { WriteJSON(w, testBans(3)) }},
+		{"error", func(w http.ResponseWriter) { WriteJSONError(w, ErrInternalServer) }},
+		{"errors", func(w http.ResponseWriter) { WriteJSONErrors(w, []*model.JSONError{ErrBadRequest}) }},
+		{"raw", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("late"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan bool)
+			r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+			w := serveTimed(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				<-r.Context().Done()
+				tt.write(w)
+				done <- committed(w)
+			}, 10*time.Millisecond, r)
+			if !<-done {
+				t.Error("response not committed after the timeout")
+			}
+			checkTimeoutResponse(t, w)
+		})
+	}
+}
+
+func TestCommittedBeforeTimeout(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+	w := serveTimed(func(w http.ResponseWriter, r *http.Request) {
+		if committed(w) {
+			t.Error("committed before anything was written")
+		}
+		w.WriteHeader(http.StatusAccepted)
+		if !committed(w) {
+			t.Error("not committed after the status was written")
+		}
+		// the error is dropped rather than written after the status
+		WriteJSONError(w, ErrInternalServer)
+	}, time.Second, r)
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("got status %d and body %q, want %d and no body", w.Code, w.Body, http.StatusAccepted)
+	}
+}
+
+// TestTimeoutRace races handlers writing their response against the timeout, run it with -race
+// either the whole response or the timeout error is sent, never a mix of both
+func TestTimeoutRace(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+			w := serveTimed(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Duration(i%10) * 100 * time.Microsecond)
+				if i%2 == 0 {
+					WriteJSON(w, testBans(2))
+				} else {
+					WriteJSONError(w, ErrNotFound)
+				}
+			}, 500*time.Microsecond, r)
+			switch w.Code {
+			case http.StatusServiceUnavailable:
+				checkTimeoutResponse(t, w)
+			case http.StatusOK, http.StatusNotFound:
+				if !json.Valid(w.Body.Bytes()) || strings.Contains(w.Body.String(), ErrTimeout.ID) {
+					t.Errorf("got body %q", w.Body)
+				}
+			default:
+				t.Errorf("got status %d", w.Code)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
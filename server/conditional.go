@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// NotModified sets the Last-Modified header of the response and answers a GET or HEAD request
+// whose If-Modified-Since is not older than modified with a 304, returning true when it did
+// HTTP dates have second granularity so modified is truncated to the second before comparing,
+// a malformed If-Modified-Since is ignored and the full response is served
+func NotModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2023, 7, 4, 12, 30, 15, 500e6, time.UTC)
+	lastModified := "Tue, 04 Jul 2023 12:30:15 GMT"
+	tests := []struct {
+		name            string
+		method          string
+		ifModifiedSince string
+		want            bool
+	}{
+		{name: "no condition", want: false},
+		{name: "equal", ifModifiedSince: lastModified, want: true},
+		{name: "newer", ifModifiedSince: "Wed, 05 Jul 2023 00:00:00 GMT", want: true},
+		{name: "older", ifModifiedSince: "Tue, 04 Jul 2023 12:30:14 GMT", want: false},
+		{name: "RFC 850", ifModifiedSince: "Tuesday, 04-Jul-23 12:30:15 GMT", want: true},
+		{name: "asctime", ifModifiedSince: "Tue Jul  4 12:30:15 2023", want: true},
+		{name: "malformed", ifModifiedSince: "2023-07-04", want: false},
+		{name: "head", method: http.MethodHead, ifModifiedSince: lastModified, want: true},
+		{name: "post", method: http.MethodPost, ifModifiedSince: lastModified, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/api/feeds/new/date/2023-07-03", nil)
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "application/json")
+			got := NotModified(w, r, modified)
+			if got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+			if w.Header().Get("Last-Modified") != lastModified {
+				t.Errorf("got Last-Modified %q, want %q", w.Header().Get("Last-Modified"), lastModified)
+			}
+			if tt.want && (w.Code != http.StatusNotModified || w.Header().Get("Content-Type") != "") {
+				t.Errorf("got status %d with Content-Type %q, want a bare 304", w.Code, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
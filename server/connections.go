@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// connection counters of the listeners: new, reused when a kept-alive connection serves again after being idle,
+// closed and hijacked, which the h2c upgrades are, with the connections open; requests by protocol such as HTTP/2.0
+var (
+	connectionStats = expvar.NewMap("http_connections")
+	connectionsOpen = expvar.NewInt("http_connections_open")
+	protocolStats   = expvar.NewMap("http_protocols")
+)
+
+// connTracker follows the connections of the listeners through the ConnState hook of their http.Server
+type connTracker struct {
+	mu sync.Mutex
+	// the last state of every open connection
+	states map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{states: make(map[net.Conn]http.ConnState)}
+}
+
+// hook is the ConnState hook of the servers, it is called for the HTTP/2 connections over TLS too
+// the connections the HTTP/2 server serves after an h2c upgrade were hijacked from the HTTP/1 server and are not followed
+func (ct *connTracker) hook(conn net.Conn, state http.ConnState) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	prev, known := ct.states[conn]
+	switch state {
+	case http.StateNew:
+		ct.states[conn] = state
+		connectionStats.Add("new", 1)
+		connectionsOpen.Add(1)
+	case http.StateActive:
+		if !known {
+			return
+		}
+		if prev == http.StateIdle {
+			connectionStats.Add("reused", 1)
+		}
+		ct.states[conn] = state
+	case http.StateIdle:
+		if known {
+			ct.states[conn] = state
+		}
+	case http.StateHijacked, http.StateClosed:
+		if !known {
+			return
+		}
+		delete(ct.states, conn)
+		connectionsOpen.Add(-1)
+		if state == http.StateHijacked {
+			connectionStats.Add("hijacked", 1)
+		} else {
+			connectionStats.Add("closed", 1)
+		}
+	}
+}
+
+// countProtocols counts the requests by protocol version
+func countProtocols(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocolStats.Add(r.Proto, 1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// http2Server returns the HTTP/2 settings of the listeners
+// its idle timeout is that of the HTTP/1 connections, ConfigureServer copies it but the h2c handler does not
+func (s *Server) http2Server() *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams: s.apiConfig.MaxConcurrentStreams,
+		IdleTimeout:          s.idleTimeout(),
+	}
+}
+
+// idleTimeout is how long kept-alive connections wait for their next request, the read timeout when not set
+func (s *Server) idleTimeout() time.Duration {
+	if s.apiConfig.IdleTimeout > 0 {
+		return s.apiConfig.IdleTimeout
+	}
+	return time.Duration(s.apiConfig.APITimeout) * time.Second
+}
+
+// configureHTTP2 enables HTTP/2 on a TLS server with the HTTP/2 settings, negotiated with ALPN
+// net/http only enables it by itself while TLSNextProto is nil, and a TLS config whose NextProtos leave out h2 or whose
+// cipher suites HTTP/2 forbids disables it silently, so the result is checked and not offering h2 is an error
+func (s *Server) configureHTTP2(srv *http.Server) error {
+	if srv.TLSConfig == nil {
+		return errors.New("http2: the server has no TLS config")
+	}
+	err := http2.ConfigureServer(srv, s.http2Server())
+	if err != nil {
+		return err
+	}
+	if !offersHTTP2(srv.TLSConfig) {
+		return errors.New("http2: the TLS config does not offer h2")
+	}
+	return nil
+}
+
+// offersHTTP2 reports if TLS clients can negotiate HTTP/2 with the config
+func offersHTTP2(conf *tls.Config) bool {
+	for _, proto := range conf.NextProtos {
+		if proto == http2.NextProtoTLS {
+			return true
+		}
+	}
+	return false
+}
+
+// serveH2C serves cleartext HTTP/2 as well as HTTP/1 with h, for the main listeners behind a proxy terminating TLS
+// clients either upgrade an HTTP/1.1 request or start with the HTTP/2 preface, which is prior knowledge
+func (s *Server) serveH2C(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, s.http2Server())
+}
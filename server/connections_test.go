@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// protoHandler answers with the protocol of the request
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Proto))
+})
+
+func readProto(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.Proto
+}
+
+func TestH2C(t *testing.T) {
+	s := &Server{apiConfig: APIConfig{MaxConcurrentStreams: 10, APITimeout: 5}}
+	ts := httptest.NewServer(s.serveH2C(protoHandler))
+	defer ts.Close()
+
+	// prior knowledge, the client starts with the HTTP/2 preface over cleartext
+	h2Client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	if got := readProto(t, h2Client, ts.URL); got != "HTTP/2.0" {
+		t.Errorf("h2c client got %s", got)
+	}
+	// HTTP/1 clients are still served
+	if got := readProto(t, ts.Client(), ts.URL); got != "HTTP/1.1" {
+		t.Errorf("HTTP/1 client got %s", got)
+	}
+}
+
+func TestConfigureHTTP2(t *testing.T) {
+	s := &Server{apiConfig: APIConfig{MaxConcurrentStreams: 10, APITimeout: 5}}
+	if err := s.configureHTTP2(&http.Server{}); err == nil {
+		t.Error("a server without TLS config was configured")
+	}
+
+	ts := httptest.NewUnstartedServer(protoHandler)
+	ts.Config.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := s.configureHTTP2(ts.Config); err != nil {
+		t.Fatal(err)
+	}
+	if !offersHTTP2(ts.Config.TLSConfig) {
+		t.Fatal("h2 not offered")
+	}
+	// the certificate is added by StartTLS, the protocols are those configureHTTP2 set
+	ts.TLS = ts.Config.TLSConfig
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	if got := readProto(t, ts.Client(), ts.URL); got != "HTTP/2.0" {
+		t.Errorf("TLS client offering h2 got %s", got)
+	}
+	// clients that only offer HTTP/1.1 fall back to it
+	roots := ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	h1Client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, NextProtos: []string{"http/1.1"}},
+	}}
+	if got := readProto(t, h1Client, ts.URL); got != "HTTP/1.1" {
+		t.Errorf("TLS client offering http/1.1 got %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// dateFormats lists the accepted formats in the errors of date parameters
+const dateFormats = "YYYY-MM-DD, YYYYMMDD, a RFC 3339 time or unix epoch seconds"
+
+// the years a date parameter may fall in, zone files are not older and four digits do not go further
+const (
+	minDateYear = 1970
+	maxDateYear = 9999
+)
+
+var (
+	// YYYY-MM-DD, also with single digit months and days, ex: 2023-7-4
+	isoDate = regexp.MustCompile(`^([0-9]{4})-([0-9]{1,2})-([0-9]{1,2})$`)
+	// YYYYMMDD, ex: 20230704
+	basicDate = regexp.MustCompile(`^([0-9]{4})([0-9]{2})([0-9]{2})$`)
+	// unix epoch seconds, 9 digits and more so that they are never mistaken for a basic date
+	epochSeconds = regexp.MustCompile(`^[0-9]{9,12}$`)
+)
+
+// ParseDateParam parses the request parameter name as a UTC day, returned at midnight UTC
+// it accepts YYYY-MM-DD with or without zero padding, YYYYMMDD, a RFC 3339 time converted to UTC and truncated to its day,
+// and unix epoch seconds, likewise truncated; times without an offset, impossible days such as 2023-02-29
+// and years before 1970 are rejected, so every accepted value names exactly one UTC day
+func ParseDateParam(name, value string) (time.Time, *model.JSONError) {
+	value = strings.TrimSpace(value)
+	var year, month, day int
+	switch {
+	case isoDate.MatchString(value):
+		m := isoDate.FindStringSubmatch(value)
+		year, month, day = dateDigits(m[1]), dateDigits(m[2]), dateDigits(m[3])
+	case basicDate.MatchString(value):
+		m := basicDate.FindStringSubmatch(value)
+		year, month, day = dateDigits(m[1]), dateDigits(m[2]), dateDigits(m[3])
+	case epochSeconds.MatchString(value):
+		secs, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, NewFieldError(name, "must be a date formatted as "+dateFormats)
+		}
+		t := time.Unix(secs, 0).UTC()
+		year, month, day = t.Year(), int(t.Month()), t.Day()
+	case strings.Contains(value, "T") || strings.Contains(value, "t"):
+		t, err := time.Parse(time.RFC3339Nano, strings.ToUpper(value))
+		if err != nil {
+			return time.Time{}, NewFieldError(name, "must be a date formatted as "+dateFormats)
+		}
+		t = t.UTC()
+		year, month, day = t.Year(), int(t.Month()), t.Day()
+	default:
+		return time.Time{}, NewFieldError(name, "must be a date formatted as "+dateFormats)
+	}
+	if year < minDateYear || year > maxDateYear {
+		return time.Time{}, NewFieldError(name, "must be a date from 1970 to 9999 formatted as "+dateFormats)
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalizes out of range months and days, 2023-02-29 would become 2023-03-01
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, NewFieldError(name, "must be an existing day formatted as "+dateFormats)
+	}
+	return date, nil
+}
+
+// dateDigits converts the digits matched by a date pattern
+func dateDigits(digits string) int {
+	n, _ := strconv.Atoi(digits)
+	return n
+}
+
+// UTCDay returns the midnight UTC starting the UTC day of t
+// import dates are UTC days, every comparison with them goes through it
+func UTCDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Today returns the current UTC day
+func Today() time.Time {
+	return UTCDay(time.Now())
+}
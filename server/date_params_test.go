@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDateParam(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		value string
+		want  time.Time
+		// the error detail of a rejected value
+		wantErr string
+	}{
+		{value: "2023-07-04", want: day(2023, 7, 4)},
+		{value: "2023-7-4", want: day(2023, 7, 4)},
+		{value: " 2023-07-04 ", want: day(2023, 7, 4)},
+		{value: "20230704", want: day(2023, 7, 4)},
+		{value: "2023-07-04T23:59:59Z", want: day(2023, 7, 4)},
+		{value: "2023-07-04t12:00:00z", want: day(2023, 7, 4)},
+		// offsets move the time to another UTC day
+		{value: "2023-07-04T22:00:00-04:00", want: day(2023, 7, 5)},
+		{value: "2023-07-04T01:00:00+02:00", want: day(2023, 7, 3)},
+		// the hours around the US DST changes of 2023 fall in the UTC day of their instant
+		{value: "2023-03-12T01:59:59-08:00", want: day(2023, 3, 12)},
+		{value: "2023-03-12T03:00:00-07:00", want: day(2023, 3, 12)},
+		{value: "2023-11-05T01:30:00-07:00", want: day(2023, 11, 5)},
+		{value: "2023-11-05T23:30:00-08:00", want: day(2023, 11, 6)},
+		{value: "1688428800", want: day(2023, 7, 4)},
+		{value: "1688515199", want: day(2023, 7, 4)},
+		{value: "2024-02-29", want: day(2024, 2, 29)},
+		{value: "20000229", want: day(2000, 2, 29)},
+		{value: "1970-01-01", want: day(1970, 1, 1)},
+		{value: "9999-12-31", want: day(9999, 12, 31)},
+		{value: "2023-02-29", wantErr: "must be an existing day"},
+		{value: "21000229", wantErr: "must be an existing day"},
+		{value: "2023-13-01", wantErr: "must be an existing day"},
+		{value: "2023-04-31", wantErr: "must be an existing day"},
+		{value: "1969-12-31", wantErr: "must be a date from 1970 to 9999"},
+		{value: "2023-07-04T12:00:00", wantErr: "must be a date formatted as"},
+		{value: "2023/07/04", wantErr: "must be a date formatted as"},
+		{value: "04-07-2023", wantErr: "must be a date formatted as"},
+		{value: "yesterday", wantErr: "must be a date formatted as"},
+		{value: "", wantErr: "must be a date formatted as"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, jsonErr := ParseDateParam("date", tt.value)
+			if tt.wantErr != "" {
+				if jsonErr == nil || jsonErr.Status != 400 || jsonErr.Meta["field"] != "date" || !strings.Contains(jsonErr.Detail, tt.wantErr) {
+					t.Fatalf("got %v, %+v, want the error %q", got, jsonErr, tt.wantErr)
+				}
+				return
+			}
+			if jsonErr != nil {
+				t.Fatal(jsonErr.Detail)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUTCDay(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tests := []struct {
+		t, want time.Time
+	}{
+		{t: time.Date(2023, 7, 4, 8, 0, 0, 0, tokyo), want: time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC)},
+		{t: time.Date(2023, 7, 4, 9, 0, 0, 0, tokyo), want: time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{t: time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC), want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := UTCDay(tt.t); !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("UTCDay(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+	if today := Today(); !today.Equal(UTCDay(time.Now())) {
+		t.Errorf("Today() = %s", today)
+	}
+}
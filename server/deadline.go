@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dnscoffee/model"
+)
+
+const (
+	// DeadlineHeader is the request header in which clients give the milliseconds they wait for the response,
+	// deadlines longer than the API timeout are cut to it
+	DeadlineHeader = "X-Request-Deadline-Ms"
+	// DeadlineExceededHeader is set on the timeout responses to client or server, whose deadline passed
+	DeadlineExceededHeader = "X-Deadline-Exceeded"
+)
+
+// requestDeadline returns the deadline of the DeadlineHeader of r cut to max, max when there is none
+func requestDeadline(r *http.Request, max time.Duration) (time.Duration, *model.JSONError) {
+	value := r.Header.Get(DeadlineHeader)
+	if value == "" {
+		return max, nil
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, NewFieldError(DeadlineHeader, "must be a positive number of milliseconds")
+	}
+	if ms >= int64(max/time.Millisecond) {
+		return max, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// deadlineWriter names whose deadline passed on the timeout response of http.TimeoutHandler
+type deadlineWriter struct {
+	http.ResponseWriter
+	// the client's deadline, nil when the server's is the shorter
+	client context.Context
+}
+
+// WriteHeader sets DeadlineExceededHeader on the timeout response, the only one written without a Content-Type
+// since the handler's own 503s are JSON
+func (dw *deadlineWriter) WriteHeader(status int) {
+	h := dw.ResponseWriter.Header()
+	if status == http.StatusServiceUnavailable && h.Get("Content-Type") == "" {
+		source := "server"
+		if dw.client != nil && dw.client.Err() == context.DeadlineExceeded {
+			source = "client"
+		}
+		h.Set("Content-Type", "application/json")
+		h.Set(DeadlineExceededHeader, source)
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
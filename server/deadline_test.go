@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	max := 2 * time.Second
+	tests := []struct {
+		name    string
+		header  string
+		want    time.Duration
+		invalid bool
+	}{
+		{name: "none", want: max},
+		{name: "client shorter", header: "500", want: 500 * time.Millisecond},
+		{name: "server shorter", header: "60000", want: max},
+		{name: "equal", header: "2000", want: max},
+		{name: "zero", header: "0", invalid: true},
+		{name: "negative", header: "-5", invalid: true},
+		{name: "not a number", header: "soon", invalid: true},
+		{name: "fraction", header: "1.5", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(DeadlineHeader, tt.header)
+			}
+			got, jsonErr := requestDeadline(r, max)
+			if tt.invalid {
+				if jsonErr == nil || jsonErr.Status != http.StatusBadRequest {
+					t.Fatalf("got %v, want a 400 error", jsonErr)
+				}
+				return
+			}
+			if jsonErr != nil {
+				t.Fatalf("unexpected error %v", jsonErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutDeadlines(t *testing.T) {
+	// the handler waits for its context, as a query would
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	tests := []struct {
+		name       string
+		server     time.Duration
+		header     string
+		wantStatus int
+		wantSource string
+	}{
+		{name: "client shorter", server: 10 * time.Second, header: "20", wantStatus: http.StatusServiceUnavailable, wantSource: "client"},
+		{name: "server shorter", server: 20 * time.Millisecond, header: "10000", wantStatus: http.StatusServiceUnavailable, wantSource: "server"},
+		{name: "no header", server: 20 * time.Millisecond, wantStatus: http.StatusServiceUnavailable, wantSource: "server"},
+		{name: "malformed", server: 10 * time.Second, header: "abc", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{router: mux.NewRouter()}
+			r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+			if tt.header != "" {
+				r.Header.Set(DeadlineHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			start := time.Now()
+			s.timeout(slow, tt.server).ServeHTTP(w, r)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("took %s", elapsed)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get(DeadlineExceededHeader); got != tt.wantSource {
+				t.Errorf("got %s %q, want %q", DeadlineExceededHeader, got, tt.wantSource)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("got Content-Type %q", got)
+			}
+		})
+	}
+}
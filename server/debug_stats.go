@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+
+	"dnscoffee/reqstats"
+)
+
+// DebugStatsHeader carries the number of queries, database time, rows and cache lookups of a request
+const DebugStatsHeader = "X-Debug-Stats"
+
+// debug stats modes, the requests X-Debug-Stats is sent for
+const (
+	DebugStatsOff   = "off"
+	DebugStatsAdmin = "admin"
+	DebugStatsAll   = "all"
+)
+
+// ValidDebugStatsMode returns true if mode is a debug stats mode
+func ValidDebugStatsMode(mode string) bool {
+	switch mode {
+	case DebugStatsOff, DebugStatsAdmin, DebugStatsAll:
+		return true
+	}
+	return false
+}
+
+// debugStats collects the work done for the requests the debug stats mode of their tenant covers and sends it in the X-Debug-Stats header
+// with DebugStatsAdmin only requests carrying the admin token or a verified admin client certificate are covered
+// when off for every tenant no collector is created and the handler is not wrapped
+func (s *Server) debugStats(next http.Handler) http.Handler {
+	enabled := false
+	for _, t := range s.tenants.all {
+		if t.debugStats != "" && t.debugStats != DebugStatsOff {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return next
+	}
+	reqstats.Enable()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := s.tenantOf(r.Context()).debugStats
+		if mode == "" || mode == DebugStatsOff || (mode == DebugStatsAdmin && !s.adminAuthenticated(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, stats := reqstats.WithStats(r.Context())
+		next.ServeHTTP(&debugStatsWriter{ResponseWriter: w, stats: stats}, r.WithContext(ctx))
+	})
+}
+
+// adminAuthenticated returns true if the request carries the admin token or a verified admin client certificate
+func (s *Server) adminAuthenticated(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	token := bearerToken(r)
+	return s.apiConfig.AdminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.apiConfig.AdminToken)) == 1
+}
+
+// debugStatsWriter sets the X-Debug-Stats header when the response is committed
+// work done after the headers are written, while streaming a body, is not included
+type debugStatsWriter struct {
+	http.ResponseWriter
+	stats *reqstats.Stats
+	once  sync.Once
+}
+
+func (dw *debugStatsWriter) setHeader() {
+	dw.once.Do(func() {
+		dw.Header().Set(DebugStatsHeader, dw.stats.String())
+	})
+}
+
+func (dw *debugStatsWriter) WriteHeader(status int) {
+	dw.setHeader()
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+func (dw *debugStatsWriter) Write(p []byte) (int, error) {
+	dw.setHeader()
+	return dw.ResponseWriter.Write(p)
+}
+
+func (dw *debugStatsWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/logging"
+)
+
+// deprecatedRequests counts the requests of every deprecated route
+var deprecatedRequests = expvar.NewMap("deprecated_requests")
+
+// RouteOption changes how a route registered with Get is served
+type RouteOption func(*routeOptions)
+
+type routeOptions struct {
+	deprecation *deprecation
+	// shed first under load, see Expensive
+	expensive bool
+	// can be disabled at runtime, see Named
+	name string
+}
+
+// deprecation describes a route clients should move off
+type deprecation struct {
+	since     time.Time
+	successor string
+}
+
+// Deprecated marks a route as deprecated since the YYYY-MM-DD date in favor of the successor path
+// its responses carry Deprecation, Link and, when a sunset is configured for the route, Sunset headers
+// and every request is logged with the client. After the sunset the route answers 410 if APIConfig.EnforceSunsets is set
+func Deprecated(since, successor string) RouteOption {
+	date, err := time.Parse("2006-01-02", since)
+	if err != nil {
+		panic(fmt.Sprintf("deprecated since %q: %s", since, err))
+	}
+	return func(o *routeOptions) {
+		o.deprecation = &deprecation{since: date, successor: successor}
+	}
+}
+
+// IsDeprecated returns true if opts mark a route as deprecated
+func IsDeprecated(opts ...RouteOption) bool {
+	var o routeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.deprecation != nil
+}
+
+// routeHandler applies the route options of path to fn
+func (s *Server) routeHandler(path string, fn http.HandlerFunc, opts []RouteOption) http.HandlerFunc {
+	var o routeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.expensive {
+		s.shedder.expensive[path] = true
+	}
+	if o.deprecation != nil {
+		fn = s.deprecated(path, o.deprecation, fn)
+	}
+	if o.name != "" {
+		fn = s.routeFlags.add(o.name, path).handler(fn)
+	}
+	return fn
+}
+
+// deprecated sends the deprecation headers of the route and logs who still uses it
+func (s *Server) deprecated(path string, d *deprecation, next http.HandlerFunc) http.HandlerFunc {
+	sunset, hasSunset := s.apiConfig.Sunsets[path]
+	if !hasSunset && strings.HasPrefix(path, apiPrefix+"/v1/") {
+		// the sunset of a version 1 route also applies to its /api/v1 path
+		sunset, hasSunset = s.apiConfig.Sunsets[unversionedPath(path)]
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		deprecatedRequests.Add(path, 1)
+		client := getIPAddress(r)
+		if key, _ := r.Context().Value(scopesKey{}).(*apiKey); key != nil {
+			client = fmt.Sprintf("%s (key %s)", client, key.name)
+		}
+		logging.Infof("deprecated route %s used by %s, user agent %q", path, client, r.UserAgent())
+
+		// RFC 9745 and RFC 8594
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.since.Unix()))
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.successor))
+		if hasSunset {
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			if s.apiConfig.EnforceSunsets && !time.Now().Before(sunset) {
+				jsonErr := *ErrGone
+				jsonErr.Meta = map[string]string{"successor": d.successor}
+				WriteJSONError(w, &jsonErr)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	past := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	future := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name    string
+		path    string
+		sunsets map[string]time.Time
+		enforce bool
+		method  string
+		want    int
+		sunset  string
+	}{
+		{name: "no sunset", path: "/api/old", want: http.StatusOK},
+		{name: "before the sunset", path: "/api/old", sunsets: map[string]time.Time{"/api/old": future}, enforce: true, want: http.StatusOK, sunset: future.Format(http.TimeFormat)},
+		{name: "after the sunset", path: "/api/old", sunsets: map[string]time.Time{"/api/old": past}, enforce: true, want: http.StatusGone, sunset: past.Format(http.TimeFormat)},
+		{name: "after the sunset not enforced", path: "/api/old", sunsets: map[string]time.Time{"/api/old": past}, want: http.StatusOK, sunset: past.Format(http.TimeFormat)},
+		{name: "head after the sunset", path: "/api/old", method: http.MethodHead, sunsets: map[string]time.Time{"/api/old": past}, enforce: true, want: http.StatusGone, sunset: past.Format(http.TimeFormat)},
+		{name: "sunset of the unversioned path", path: "/api/v1/old", sunsets: map[string]time.Time{"/api/old": past}, enforce: true, want: http.StatusGone, sunset: past.Format(http.TimeFormat)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := testAPIConfig
+			conf.Sunsets = tt.sunsets
+			conf.EnforceSunsets = tt.enforce
+			s, err := New([]string{"127.0.0.1:0"}, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Get(tt.path, ok, Deprecated("2023-01-01", "/api/new"))
+			s.Get("/api/new", ok)
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			before := expvarInt(deprecatedRequests, tt.path)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, httptest.NewRequest(method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			// 2023-01-01 as a Unix time
+			if got, want := w.Header().Get("Deprecation"), "@1672531200"; got != want {
+				t.Errorf("got Deprecation %q, want %q", got, want)
+			}
+			if got, want := w.Header().Get("Link"), `</api/new>; rel="successor-version"`; got != want {
+				t.Errorf("got Link %q, want %q", got, want)
+			}
+			if got := w.Header().Get("Sunset"); got != tt.sunset {
+				t.Errorf("got Sunset %q, want %q", got, tt.sunset)
+			}
+			if tt.want == http.StatusGone && method == http.MethodGet && (!strings.Contains(w.Body.String(), `"code":"gone"`) || !strings.Contains(w.Body.String(), `"successor":"/api/new"`)) {
+				t.Errorf("got %s, want the gone error naming the successor", w.Body)
+			}
+			if got := expvarInt(deprecatedRequests, tt.path) - before; got != 1 {
+				t.Errorf("counted %d requests, want 1", got)
+			}
+
+			// the successor is not deprecated
+			w = httptest.NewRecorder()
+			s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/new", nil))
+			if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+				t.Errorf("successor got status %d with Deprecation %q", w.Code, w.Header().Get("Deprecation"))
+			}
+		})
+	}
+}
+
+func TestDeprecatedInvalidDate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an invalid date did not panic")
+		}
+	}()
+	Deprecated("01/01/2023", "/api/new")
+}
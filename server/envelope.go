@@ -0,0 +1,240 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+)
+
+const (
+	// EnvelopeHeader opts a request in to the metadata envelope with the value meta, the response is then
+	// {"data": ..., "meta": {...}, "links": {...}} in every API version; other requests keep the envelope of their version
+	EnvelopeHeader = "X-Envelope"
+	// NextCursorHeader carries the cursor of the next page of CSV and NDJSON listings
+	NextCursorHeader = "X-Next-Cursor"
+	// CountHeader carries the number of items on a page of CSV and NDJSON listings
+	CountHeader = "X-Count"
+)
+
+// MetaOption sets a field of the meta of a response, see WriteJSONWithMeta
+type MetaOption func(*model.ResponseMeta)
+
+// Count is the number of items on the page of a listing
+func Count(n int) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.Count = &n
+	}
+}
+
+// NextCursor is the cursor of the next page of a listing, the links of the response then have next
+// nothing is set for an empty cursor, the last page
+func NextCursor(cursor string) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.NextCursor = cursor
+	}
+}
+
+// ImportID is the import the data of the response was read from
+func ImportID(id int64) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.ImportID = id
+	}
+}
+
+// DataVersion is the data version the response was read at, see the data_version parameter
+func DataVersion(version int64) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.DataVersion = &version
+	}
+}
+
+// PartialResult names the zones whose data could not be read and is missing from the response, nothing for none
+func PartialResult(failedZones []string) MetaOption {
+	return func(m *model.ResponseMeta) {
+		if len(failedZones) > 0 {
+			m.Partial, m.FailedZones = true, failedZones
+		}
+	}
+}
+
+// FailedItems is the number of items of a batch whose lookup failed and that are answered with their error, nothing
+// for none
+func FailedItems(n int) MetaOption {
+	return func(m *model.ResponseMeta) {
+		if n > 0 {
+			m.Partial, m.FailedItems = true, n
+		}
+	}
+}
+
+// DataAsOf is when the latest import of the data of the response finished
+func DataAsOf(at time.Time) MetaOption {
+	return func(m *model.ResponseMeta) {
+		ts := model.NewTimestamp(at)
+		m.DataAsOf = &ts
+	}
+}
+
+// Warning adds a warning about the data of the response for the client, such as its age
+func Warning(msg string) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.Warnings = append(m.Warnings, msg)
+	}
+}
+
+// SuspectImports flags the data of the response as derived from imports whose sanity check found them suspect
+func SuspectImports(ids []int64) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.Suspect = true
+		m.SuspectImports = append(m.SuspectImports, ids...)
+	}
+}
+
+// RecordingID is the ID of the recording of the response, see SetRecordingStore
+func RecordingID(id string) MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.RecordingID = id
+	}
+}
+
+// truncated marks a streamed listing cut short by an error
+func truncated() MetaOption {
+	return func(m *model.ResponseMeta) {
+		m.Truncated = true
+	}
+}
+
+// metaWriter carries the URL of a request and the meta set by middlewares to the writers of its response
+type metaWriter struct {
+	http.ResponseWriter
+	// the path and query of the request, links are relative to the host
+	uri *url.URL
+	// true when the request opted in to the envelope
+	envelope bool
+	opts     []MetaOption
+}
+
+// Unwrap returns the wrapped writer
+func (mw *metaWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// Flush sends any buffered data to the client
+func (mw *metaWriter) Flush() {
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// negotiateEnvelope is a middleware wrapping every response in a metaWriter, a EnvelopeHeader other than meta is a 400
+// it runs inside the router, http.TimeoutHandler's writer would hide a writer set outside
+func negotiateEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := &metaWriter{ResponseWriter: w, uri: &url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}}
+		if value := r.Header.Get(EnvelopeHeader); value != "" {
+			w.Header().Add("Vary", EnvelopeHeader)
+			if !strings.EqualFold(value, "meta") {
+				WriteJSONError(w, NewFieldError(EnvelopeHeader, "must be meta"))
+				return
+			}
+			mw.envelope = true
+		}
+		next.ServeHTTP(mw, r)
+	})
+}
+
+// findMetaWriter returns the metaWriter of the response written to w
+func findMetaWriter(w http.ResponseWriter) (*metaWriter, bool) {
+	for {
+		if mw, ok := w.(*metaWriter); ok {
+			return mw, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// SetResponseMeta adds opts to the meta of the response written to w, for middlewares that know some of it
+// the options of the handler apply after them
+func SetResponseMeta(w http.ResponseWriter, opts ...MetaOption) {
+	if mw, ok := findMetaWriter(w); ok {
+		mw.opts = append(mw.opts, opts...)
+	}
+}
+
+// build returns the meta and links of the response with opts applied after those of the middlewares
+func (mw *metaWriter) build(opts []MetaOption) *model.ResponseEnvelope {
+	meta := &model.ResponseMeta{GeneratedAt: model.Now()}
+	for _, opt := range mw.opts {
+		opt(meta)
+	}
+	for _, opt := range opts {
+		opt(meta)
+	}
+	links := &model.ResponseLinks{Self: mw.uri.RequestURI()}
+	if meta.NextCursor != "" {
+		links.Next = mw.nextLink(meta.NextCursor)
+	}
+	return &model.ResponseEnvelope{Meta: meta, Links: links}
+}
+
+// nextLink returns the URL of the request with the cursor parameter set to cursor
+func (mw *metaWriter) nextLink(cursor string) string {
+	next := *mw.uri
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	return next.RequestURI()
+}
+
+// responseBody returns what WriteJSON encodes for data: the envelope for requests opting in to it, the data alone
+// from version 2 and the data envelope before
+func responseBody(w http.ResponseWriter, data model.APIData, opts []MetaOption) interface{} {
+	if mw, ok := findMetaWriter(w); ok && mw.envelope {
+		return &model.EnvelopedResponse{Data: data, ResponseEnvelope: *mw.build(opts)}
+	}
+	if responseVersion(w) >= 2 {
+		return data
+	}
+	return model.JSONResponse{Data: data}
+}
+
+// WriteJSONWithMeta writes data like WriteJSON, with opts in the meta of the requests opting in to the envelope
+// listings keep their own next_cursor and similar fields for the other requests
+func WriteJSONWithMeta(w http.ResponseWriter, data model.APIData, opts ...MetaOption) {
+	writeJSONStatus(w, http.StatusOK, data, opts)
+}
+
+// WriteBodyWithMeta writes a body other than JSON like WriteBody, with opts in headers since it has no envelope:
+// the cursor in X-Next-Cursor and a Link to the next page, the count in X-Count, the import in X-Import-ID and the
+// failed zones of a partial result in X-Failed-Zones separated by spaces
+func WriteBodyWithMeta(w http.ResponseWriter, contentType string, body []byte, opts ...MetaOption) {
+	meta := &model.ResponseMeta{}
+	for _, opt := range opts {
+		opt(meta)
+	}
+	h := w.Header()
+	if meta.NextCursor != "" {
+		h.Set(NextCursorHeader, meta.NextCursor)
+		if mw, ok := findMetaWriter(w); ok {
+			h.Add("Link", "<"+mw.nextLink(meta.NextCursor)+`>; rel="next"`)
+		}
+	}
+	if meta.Count != nil {
+		h.Set(CountHeader, strconv.Itoa(*meta.Count))
+	}
+	if meta.ImportID != 0 {
+		h.Set("X-Import-ID", strconv.FormatInt(meta.ImportID, 10))
+	}
+	if len(meta.FailedZones) > 0 {
+		h.Set("X-Failed-Zones", strings.Join(meta.FailedZones, " "))
+	}
+	WriteBody(w, contentType, body)
+}
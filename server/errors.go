@@ -1,15 +1,149 @@
 package server
 
-import "dnscoffee/model"
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// errorDocsPath is the page documenting every error, each error's code is an anchor on it
+const errorDocsPath = "/errors"
+
+// errorList holds every error created with newError, it is the source of the documentation page
+var errorList []*model.JSONError
+
+// newError creates a documented JSON error
+// codes must be unique and statuses errors, a mistake is caught when the package is loaded
+func newError(code string, status int, title, detail string) *model.JSONError {
+	if status < 400 || status > 599 {
+		panic(fmt.Sprintf("error %s: status %d is not an error status", code, status))
+	}
+	if title != http.StatusText(status) && !strings.EqualFold(title, http.StatusText(status)) {
+		panic(fmt.Sprintf("error %s: title %q does not match status %d", code, title, status))
+	}
+	for _, e := range errorList {
+		if e.ID == code {
+			panic(fmt.Sprintf("error %s: duplicate code", code))
+		}
+	}
+	jsonErr := model.NewJSONError(code, status, title, detail)
+	jsonErr.DocumentationURL = errorDocsPath + "#" + code
+	errorList = append(errorList, jsonErr)
+	return jsonErr
+}
 
 // variables to hold common json errors
 var (
-	//ErrBadRequest           = &JSONError{"bad_request", 400, "Bad request", "Request body is not well-formed. It must be JSON."}
-	//ErrUnauthorized         = &JSONError{"unauthorized", 401, "Unauthorized", "Access token is invalid."}
-	ErrNotFound         = model.NewJSONError("not_found", 404, "Not found", "Route not found.")
-	ErrResourceNotFound = model.NewJSONError("resource_not_found", 404, "Not found", "Resource not found.")
-	ErrLimitExceeded    = model.NewJSONError("limit_exceeded", 429, "Too Many Requests", "To many requests, please wait and submit again.")
-	ErrInternalServer   = model.NewJSONError("internal_server_error", 500, "Internal Server Error", "Something went wrong.")
-	ErrNotImplemented   = model.NewJSONError("not_implemented", 501, "Not Implemented", "The server does not support the functionality required to fulfill the request. It may not have been implemented yet")
-	ErrTimeout          = model.NewJSONError("timeout", 503, "Service Unavailable", "The request took longer than expected to process.")
+	ErrBadRequest          = newError("bad_request", 400, "Bad request", "Request body is not well-formed. It must be JSON.")
+	ErrInvalidParameter    = newError("invalid_parameter", 400, "Bad Request", "A request parameter is not valid.")
+	ErrInvalidCursor       = newError("invalid_cursor", 400, "Bad Request", "The cursor is not valid for this request, start again from the first page.")
+	ErrInvalidName         = newError("invalid_name", 400, "Bad Request", "The name is not a valid domain name.")
+	ErrUnauthorized        = newError("unauthorized", 401, "Unauthorized", "Access token is missing.")
+	ErrAPIKeyRequired      = newError("api_key_required", 401, "Unauthorized", "This endpoint needs an API key, send it in the X-API-Key header.")
+	ErrForbidden           = newError("forbidden", 403, "Forbidden", "Access token is invalid.")
+	ErrForbiddenZone       = newError("forbidden_zone", 403, "Forbidden", "The data of this zone may not be redistributed, only API keys approved for it may read its domains. Aggregate counts remain public.")
+	ErrRecordingForbidden  = newError("recording_forbidden", 403, "Forbidden", "Only requests with an API key or the admin token may be recorded, send the key in the X-API-Key header or remove record=1.")
+	ErrWatchlistLimit      = newError("watchlist_limit", 403, "Forbidden", "The API key has as many watchlists of this cost class as it may, delete one first. meta.limit is the limit.")
+	ErrNotFound            = newError("not_found", 404, "Not found", "Route not found.")
+	ErrResourceNotFound    = newError("resource_not_found", 404, "Not found", "Resource not found.")
+	ErrKeywordNotTracked   = newError("keyword_not_tracked", 404, "Not found", "The keyword is not tracked, ask the operators to track it. Its counts start from the new domains still in the feeds when it is added.")
+	ErrBeforeFirstImport   = newError("before_first_import", 404, "Not found", "The date is before the first import of the zone, there is no count as of it. meta.first_import is the first import, if any.")
+	ErrImportGap           = newError("import_gap", 404, "Not found", "The zone was not imported in the week up to the date, its count as of the date is unknown. meta.previous_import and meta.next_import are the nearest imports.")
+	ErrNoLabelStats        = newError("label_stats_not_found", 404, "Not found", "No label statistics of the zone were computed for an import at or before the date. meta.first_import is the first import they were computed for, if any.")
+	ErrASNNotRouted        = newError("asn_not_routed", 404, "Not found", "The ASN originates no prefix of the routing table, it is unknown or not announced.")
+	ErrSpecialUseName      = newError("special_use_name", 404, "Not found", "The name is under a special-use domain reserved for local or documentation use that no public zone delegates, so no zone file has it. meta.special_use is its kind and meta.rfc the RFC reserving it.")
+	ErrMethodNotAllowed    = newError("method_not_allowed", 405, "Method Not Allowed", "The route does not accept this method, the Allow header lists those it accepts.")
+	ErrDataChanged         = newError("data_changed", 409, "Conflict", "The data changed since the first page was requested, start again from the first page.")
+	ErrWatchlistExists     = newError("watchlist_exists", 409, "Conflict", "The API key already has a watchlist with this name.")
+	ErrIdempotencyBusy     = newError("idempotency_in_progress", 409, "Conflict", "A request with this Idempotency-Key is still being processed, retry it after meta.retry_after_seconds.")
+	ErrIdempotencyNotKept  = newError("idempotent_response_not_kept", 409, "Conflict", "The request with this Idempotency-Key was processed but its response was too large to keep. meta.status is its status.")
+	ErrGone                = newError("gone", 410, "Gone", "This endpoint was removed after its sunset date, use the successor named in meta.successor.")
+	ErrRequestTooLarge     = newError("request_too_large", 413, "Request Entity Too Large", "The request body is too large.")
+	ErrInvalidConfig       = newError("invalid_config", 422, "Unprocessable Entity", "The config file is not valid.")
+	ErrIdempotencyReused   = newError("idempotency_key_reused", 422, "Unprocessable Entity", "The Idempotency-Key was sent with another request, use a new key for every new request.")
+	ErrTooManyConcurrent   = newError("too_many_concurrent", 429, "Too Many Requests", "Too many concurrent requests, please wait for your other requests to finish.")
+	ErrLimitExceeded       = newError("limit_exceeded", 429, "Too Many Requests", "Too many requests, please wait for meta.retry_after_seconds and submit again.")
+	ErrBanned              = newError("banned", 429, "Too Many Requests", "Too many requests, temporarily blocked.")
+	ErrInternalServer      = newError("internal_server_error", 500, "Internal Server Error", "Something went wrong.")
+	ErrResponseTooLarge    = newError("response_too_large", 500, "Internal Server Error", "The response is too large, please narrow the request.")
+	ErrNotImplemented      = newError("not_implemented", 501, "Not Implemented", "The server does not support the functionality required to fulfill the request. It may not have been implemented yet")
+	ErrMethodNotSupported  = newError("method_not_supported", 501, "Not Implemented", "The request method is not supported by any route.")
+	ErrRouteDisabled       = newError("route_disabled", 503, "Service Unavailable", "The operators disabled this endpoint for now, please try again later. meta.route names it.")
+	ErrOverloaded          = newError("overloaded", 503, "Service Unavailable", "The server is handling too many requests, please try again shortly.")
+	ErrTimeout             = newError("timeout", 503, "Service Unavailable", "The request took longer than expected to process.")
+	ErrMaintenance         = newError("maintenance", 503, "Service Unavailable", "The service is down for maintenance, please try again later.")
+	ErrNoCheckpoints       = newError("checkpoints_unavailable", 503, "Service Unavailable", "The server has no cursor secret configured, feed checkpoints would not stay valid across restarts and instances.")
+	ErrNoRoutingTable      = newError("routing_table_unavailable", 503, "Service Unavailable", "The routing table is not loaded yet, please try again later.")
+	ErrDatabaseUnavailable = newError("database_unavailable", 503, "Service Unavailable", "The database is currently unavailable, please try again later.")
 )
+
+// WithMeta returns a copy of base with the meta entries added to those of base, later entries win
+// the errors above are shared by every request and must never be changed, what is specific to a response goes in a copy
+func WithMeta(base *model.JSONError, meta ...map[string]string) *model.JSONError {
+	jsonErr := *base
+	size := len(base.Meta)
+	for _, m := range meta {
+		size += len(m)
+	}
+	jsonErr.Meta = make(map[string]string, size)
+	for k, v := range base.Meta {
+		jsonErr.Meta[k] = v
+	}
+	for _, m := range meta {
+		for k, v := range m {
+			jsonErr.Meta[k] = v
+		}
+	}
+	return &jsonErr
+}
+
+// FieldError returns a copy of base naming the offending request field in its meta object
+func FieldError(base *model.JSONError, field, reason string) *model.JSONError {
+	jsonErr := *base
+	jsonErr.Detail = fmt.Sprintf("Parameter %s %s.", field, reason)
+	jsonErr.Meta = map[string]string{
+		"field":  field,
+		"reason": reason,
+	}
+	return &jsonErr
+}
+
+// NewFieldError returns an ErrInvalidParameter for an invalid request field
+func NewFieldError(field, reason string) *model.JSONError {
+	return FieldError(ErrInvalidParameter, field, reason)
+}
+
+// errorDocsTemplate renders the error documentation page
+var errorDocsTemplate = template.Must(template.New("errors").Parse(`<!DOCTYPE html>
+<html>
+<head><title>DNS Coffee API Errors</title></head>
+<body>
+<h1>API Errors</h1>
+<p>Error responses are <code>{"errors": [...]}</code>, branch on the <code>code</code> of each error rather than its detail.</p>
+<dl>
+{{range .}}<dt id="{{.ID}}"><a href="#{{.ID}}"><code>{{.ID}}</code></a> {{.Status}} {{.Title}}</dt>
+<dd>{{.Detail}}</dd>
+{{end}}</dl>
+</body>
+</html>
+`))
+
+// errorDocsHandler serves the error documentation page generated from errorList
+func errorDocsHandler(w http.ResponseWriter, r *http.Request) {
+	errs := append([]*model.JSONError(nil), errorList...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Status != errs[j].Status {
+			return errs[i].Status < errs[j].Status
+		}
+		return errs[i].ID < errs[j].ID
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := errorDocsTemplate.Execute(w, errs)
+	if err != nil {
+		writeFailed(w, err)
+	}
+}
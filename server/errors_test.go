@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+// TestErrorList checks every defined error has a unique code, an error status and a link to its documentation
+func TestErrorList(t *testing.T) {
+	codes := map[string]bool{}
+	for _, e := range errorList {
+		if codes[e.ID] {
+			t.Errorf("duplicate code %s", e.ID)
+		}
+		codes[e.ID] = true
+		if e.Status < 400 || e.Status > 599 || !strings.EqualFold(e.Title, http.StatusText(e.Status)) {
+			t.Errorf("%s: status %d %q", e.ID, e.Status, e.Title)
+		}
+		if e.DocumentationURL != errorDocsPath+"#"+e.ID {
+			t.Errorf("%s: documentation URL %q", e.ID, e.DocumentationURL)
+		}
+	}
+	for _, code := range []string{"invalid_name", "invalid_parameter", "request_too_large", "database_unavailable", "maintenance", "overloaded"} {
+		if !codes[code] {
+			t.Errorf("no error %s", code)
+		}
+	}
+}
+
+func TestNewErrorPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		status int
+		title  string
+	}{
+		{name: "duplicate code", code: "not_found", status: 404, title: "Not Found"},
+		{name: "success status", code: "test_ok", status: 200, title: "OK"},
+		{name: "title of another status", code: "test_title", status: 400, title: "Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := len(errorList)
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+				if len(errorList) != n {
+					t.Error("the error was added to the list")
+				}
+			}()
+			newError(tt.code, tt.status, tt.title, "detail")
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		jsonErr *model.JSONError
+		want    int
+		code    string
+		meta    map[string]string
+	}{
+		{name: "field error", jsonErr: NewFieldError("limit", "must be at most 100"), want: http.StatusBadRequest, code: "invalid_parameter", meta: map[string]string{"field": "limit", "reason": "must be at most 100"}},
+		{name: "invalid name", jsonErr: FieldError(ErrInvalidName, "domain", "is not a valid name"), want: http.StatusBadRequest, code: "invalid_name", meta: map[string]string{"field": "domain", "reason": "is not a valid name"}},
+		{name: "with meta", jsonErr: WithMeta(ErrLimitExceeded, map[string]string{"retry_after_seconds": "60"}), want: http.StatusTooManyRequests, code: "limit_exceeded", meta: map[string]string{"retry_after_seconds": "60"}},
+		{name: "not found", jsonErr: ErrNotFound, want: http.StatusNotFound, code: "not_found"},
+		{name: "database unavailable", jsonErr: ErrDatabaseUnavailable, want: http.StatusServiceUnavailable, code: "database_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WriteJSONError(rec, tt.jsonErr)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+			var body model.JSONErrors
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Errors) != 1 {
+				t.Fatalf("got %d errors, want 1", len(body.Errors))
+			}
+			got := body.Errors[0]
+			if got.ID != tt.code || got.Status != tt.want || got.DocumentationURL != errorDocsPath+"#"+tt.code {
+				t.Errorf("got %+v, want code %s", got, tt.code)
+			}
+			if len(got.Meta) != len(tt.meta) {
+				t.Errorf("got meta %v, want %v", got.Meta, tt.meta)
+			}
+			for k, v := range tt.meta {
+				if got.Meta[k] != v {
+					t.Errorf("meta %s is %q, want %q", k, got.Meta[k], v)
+				}
+			}
+		})
+	}
+
+	// the shared errors are left as they were
+	if ErrInvalidParameter.Meta != nil || ErrLimitExceeded.Meta != nil {
+		t.Error("a shared error was changed")
+	}
+}
+
+func TestErrorDocsHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	errorDocsHandler(rec, httptest.NewRequest(http.MethodGet, errorDocsPath, nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got status %d %s, want a 200 page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, e := range errorList {
+		if !strings.Contains(rec.Body.String(), `<dt id="`+e.ID+`">`) {
+			t.Errorf("no anchor for %s", e.ID)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+)
+
+// readinessCheck is a named check run by the readiness endpoint
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// AddReadinessCheck registers a check that must return nil for the server to report as ready
+// checks must be added before the server is started
+func (s *Server) AddReadinessCheck(name string, check func() error) {
+	s.readinessChecks = append(s.readinessChecks, readinessCheck{name, check})
+}
+
+// healthHandler always returns 200 while the process is able to serve requests
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := writeJSONBody(w, http.StatusOK, map[string]string{"status": "ok"}, 0)
+	if err != nil {
+		writeFailed(w, err)
+	}
+}
+
+// readyHandler runs the readiness checks and returns 503 if any of them fail
+// so that the load balancer can drain the instance
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ready, results := s.runReadinessChecks()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	resp := struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}{ready, results}
+
+	_, err := writeJSONBody(w, status, resp, 0)
+	if err != nil {
+		writeFailed(w, err)
+	}
+}
+
+// runReadinessChecks runs every readiness check and returns the result of each
+func (s *Server) runReadinessChecks() (bool, map[string]string) {
+	ready := true
+	results := make(map[string]string, len(s.readinessChecks))
+	for _, c := range s.readinessChecks {
+		if err := c.check(); err != nil {
+			ready = false
+			results[c.name] = err.Error()
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	return ready, results
+}
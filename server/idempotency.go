@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+)
+
+const (
+	// IdempotencyKeyHeader makes a POST request safe to retry, the retries with the same key get the first response
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on the responses replayed to a retry
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the header, a UUID is 36 characters
+	maxIdempotencyKeyLength = 255
+	// idempotencyMaxRequestBytes bounds the bodies read to fingerprint a request, above those of the POST routes
+	idempotencyMaxRequestBytes = 1 << 20
+	// idempotencyMinLease is the shortest time a request being processed holds its key before it is given up for dead
+	idempotencyMinLease = time.Minute
+	// idempotencyBusyRetry is the Retry-After of a retry sent while the first request is processed
+	idempotencyBusyRetry = time.Second
+)
+
+// idempotency counters
+var (
+	idempotencyStored   = expvar.NewInt("idempotency_keys_stored")
+	idempotencyReplayed = expvar.NewInt("idempotency_keys_replayed")
+	idempotencyConflict = expvar.NewInt("idempotency_keys_conflicts")
+	idempotencyFailed   = expvar.NewInt("idempotency_keys_failed")
+	idempotencyDeleted  = expvar.NewInt("idempotency_keys_deleted")
+)
+
+// IdempotencyStore stores the requests sent with an Idempotency-Key and their response
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey stores claim, which is not done, unless the client already has an unexpired entry for the key;
+	// it returns nil when claim was stored and the existing entry otherwise, so that only one of concurrent requests wins
+	ClaimIdempotencyKey(ctx context.Context, claim *model.IdempotencyKey) (*model.IdempotencyKey, error)
+	// SaveIdempotencyKey replaces the claim of the same request with entry, which is done
+	SaveIdempotencyKey(ctx context.Context, entry *model.IdempotencyKey) error
+	// ReleaseIdempotencyKey removes the claim of a request that failed, so that a retry runs it again
+	ReleaseIdempotencyKey(ctx context.Context, claim *model.IdempotencyKey) error
+	// DeleteIdempotencyKeys removes the entries that expired before before, returning how many
+	DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Idempotency bounds the responses kept for the retries of the POST requests with an Idempotency-Key
+type Idempotency struct {
+	// how long a response is replayed to the retries
+	TTL time.Duration
+	// bodies up to BodyMaxBytes are replayed, for larger ones only their status is kept and retries get
+	// ErrIdempotencyNotKept
+	BodyMaxBytes int
+	// how often the expired keys are removed
+	CleanupInterval time.Duration
+}
+
+// idempotency answers the retries of POST requests from its store
+type idempotency struct {
+	store IdempotencyStore
+	conf  Idempotency
+}
+
+// SetIdempotencyStore makes the public POST routes safe to retry with an Idempotency-Key header, the first response
+// is stored in store and replayed to the retries for conf.TTL; the idempotency_keys job removes the expired keys
+// it must be called before Start
+func (s *Server) SetIdempotencyStore(store IdempotencyStore, conf Idempotency) {
+	s.idempotency = &idempotency{store: store, conf: conf}
+	s.AddJob("idempotency_keys", conf.CleanupInterval, s.idempotency.cleanup)
+}
+
+// idempotent is a router middleware answering the POST requests with an Idempotency-Key sent again within the TTL with
+// the response of the first one, marked with Idempotent-Replayed; the key is scoped to the API key of the request, or
+// its address for anonymous ones, and must be sent with the same request, else ErrIdempotencyReused
+// a retry sent while the first request is processed gets ErrIdempotencyBusy, responses with a 5xx status are not kept
+// so that the request can be retried
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	if s.idempotency == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := r.Header[http.CanonicalHeaderKey(IdempotencyKeyHeader)]
+		if !ok || r.Method != http.MethodPost || strings.HasPrefix(r.URL.Path, adminPrefix) || strings.HasPrefix(r.URL.Path, internalPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) != 1 || !validIdempotencyKey(key[0]) {
+			WriteJSONError(w, FieldError(ErrBadRequest, IdempotencyKeyHeader,
+				"must be one header of 1 to "+strconv.Itoa(maxIdempotencyKeyLength)+" printable ASCII characters"))
+			return
+		}
+		// the body is read here to fingerprint the request, and given to the handler again
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyMaxRequestBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				WriteJSONError(w, ErrRequestTooLarge)
+				return
+			}
+			WriteJSONError(w, ErrBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		client := "ip:" + getIPAddress(r)
+		if name := RequestAPIKey(r.Context()); name != "" {
+			client = "key:" + name
+		}
+		claim := &model.IdempotencyKey{
+			Client:        client,
+			Key:           key[0],
+			RequestSHA256: requestFingerprint(r, body),
+			ExpiresAt:     time.Now().Add(s.idempotencyLease()),
+		}
+		existing, err := s.idempotency.store.ClaimIdempotencyKey(r.Context(), claim)
+		if err != nil {
+			idempotencyFailed.Add(1)
+			logging.Errorf("idempotency: claim: %s", err)
+			WriteJSONError(w, ErrInternalServer)
+			return
+		}
+		if existing != nil {
+			s.idempotency.replay(w, claim, existing)
+			return
+		}
+
+		// the claim is released if the handler panics, the panic goes on to the recovery handlers
+		saved := false
+		defer func() {
+			if !saved {
+				s.idempotency.release(claim)
+			}
+		}()
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK, hash: sha256.New(), limit: s.idempotency.conf.BodyMaxBytes}
+		next.ServeHTTP(rw, r)
+		if rw.status >= 500 {
+			return
+		}
+		saved = true
+		entry := *claim
+		entry.Done = true
+		entry.Status = rw.status
+		entry.ContentType = w.Header().Get("Content-Type")
+		entry.BodySHA256 = hex.EncodeToString(rw.hash.Sum(nil))
+		entry.BodyBytes = rw.size
+		if rw.size <= int64(rw.limit) {
+			entry.Body = rw.body.Bytes()
+		}
+		entry.ExpiresAt = time.Now().Add(s.idempotency.conf.TTL)
+		// the request may be canceled once answered, the response is stored regardless
+		ctx, cancel := context.WithTimeout(context.Background(), recordingSaveTimeout)
+		defer cancel()
+		err = s.idempotency.store.SaveIdempotencyKey(ctx, &entry)
+		if err != nil {
+			idempotencyFailed.Add(1)
+			logging.Errorf("idempotency: save: %s", err)
+			// the claim is kept until its lease ends, the request ran and must not run again at once
+			return
+		}
+		idempotencyStored.Add(1)
+	})
+}
+
+// idempotencyLease is how long a request being processed holds its key, past the API timeout so that a request still
+// running is never run a second time
+func (s *Server) idempotencyLease() time.Duration {
+	lease := 2 * time.Duration(s.apiConfig.APITimeout) * time.Second
+	if lease < idempotencyMinLease {
+		lease = idempotencyMinLease
+	}
+	return lease
+}
+
+// replay answers a retry with the entry of its key
+func (id *idempotency) replay(w http.ResponseWriter, claim, existing *model.IdempotencyKey) {
+	switch {
+	case existing.RequestSHA256 != claim.RequestSHA256:
+		idempotencyConflict.Add(1)
+		WriteJSONError(w, ErrIdempotencyReused)
+	case !existing.Done:
+		idempotencyConflict.Add(1)
+		WriteRetryError(w, ErrIdempotencyBusy, idempotencyBusyRetry)
+	case existing.Body == nil && existing.BodyBytes > 0:
+		WriteJSONError(w, ErrIdempotencyNotKept, map[string]string{"status": strconv.Itoa(existing.Status)})
+	default:
+		idempotencyReplayed.Add(1)
+		if existing.ContentType != "" {
+			w.Header().Set("Content-Type", existing.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(existing.Status)
+		_, _ = w.Write(existing.Body)
+	}
+}
+
+// release removes the claim of a request that failed
+func (id *idempotency) release(claim *model.IdempotencyKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), recordingSaveTimeout)
+	defer cancel()
+	err := id.store.ReleaseIdempotencyKey(ctx, claim)
+	if err != nil {
+		idempotencyFailed.Add(1)
+		logging.Errorf("idempotency: release: %s", err)
+	}
+}
+
+// cleanup removes the expired keys, it is the idempotency_keys job
+func (id *idempotency) cleanup(ctx context.Context) error {
+	n, err := id.store.DeleteIdempotencyKeys(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	idempotencyDeleted.Add(n)
+	if n > 0 {
+		logging.Infof("idempotency_keys: removed %d", n)
+	}
+	return nil
+}
+
+// validIdempotencyKey reports if key is 1 to maxIdempotencyKeyLength printable ASCII characters
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint returns the SHA-256 of the method, path, query and body of a request, in hex
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.URL.RawQuery} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IdempotencyMemoryStore keeps the idempotency keys in memory, for a single server
+type IdempotencyMemoryStore struct {
+	mu      sync.Mutex
+	entries map[[2]string]*model.IdempotencyKey
+}
+
+// NewIdempotencyMemoryStore returns an empty IdempotencyMemoryStore
+func NewIdempotencyMemoryStore() *IdempotencyMemoryStore {
+	return &IdempotencyMemoryStore{entries: make(map[[2]string]*model.IdempotencyKey)}
+}
+
+// ClaimIdempotencyKey stores claim unless the client has an unexpired entry for the key, which is returned
+func (ms *IdempotencyMemoryStore) ClaimIdempotencyKey(_ context.Context, claim *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	id := [2]string{claim.Client, claim.Key}
+	if existing, ok := ms.entries[id]; ok && existing.ExpiresAt.After(time.Now()) {
+		entry := *existing
+		return &entry, nil
+	}
+	entry := *claim
+	ms.entries[id] = &entry
+	return nil, nil
+}
+
+// SaveIdempotencyKey replaces the claim of the same request with entry
+func (ms *IdempotencyMemoryStore) SaveIdempotencyKey(_ context.Context, entry *model.IdempotencyKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	id := [2]string{entry.Client, entry.Key}
+	if existing, ok := ms.entries[id]; ok && existing.RequestSHA256 == entry.RequestSHA256 {
+		saved := *entry
+		ms.entries[id] = &saved
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the claim of a request that is not done
+func (ms *IdempotencyMemoryStore) ReleaseIdempotencyKey(_ context.Context, claim *model.IdempotencyKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	id := [2]string{claim.Client, claim.Key}
+	if existing, ok := ms.entries[id]; ok && !existing.Done && existing.RequestSHA256 == claim.RequestSHA256 {
+		delete(ms.entries, id)
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeys removes the entries that expired before before
+func (ms *IdempotencyMemoryStore) DeleteIdempotencyKeys(_ context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var n int64
+	for id, entry := range ms.entries {
+		if entry.ExpiresAt.Before(before) {
+			delete(ms.entries, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// idempotencyRequest returns a request from remoteAddr with the Idempotency-Key headers keys
+func idempotencyRequest(method, path, remoteAddr, body string, keys ...string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	for _, key := range keys {
+		r.Header.Add(IdempotencyKeyHeader, key)
+	}
+	return r
+}
+
+// idempotentHandler answers with the statuses in turn, the body names the call and echoes the request
+func idempotentHandler(statuses ...int) (http.Handler, *int) {
+	calls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		status := statuses[calls%len(statuses)]
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "call %d: %s", calls, body)
+	}), &calls
+}
+
+func idempotencyServer(t *testing.T, store IdempotencyStore, conf Idempotency) *Server {
+	s := testServer(t)
+	s.idempotency = &idempotency{store: store, conf: conf}
+	return s
+}
+
+func TestIdempotent(t *testing.T) {
+	type step struct {
+		method, path, remoteAddr, body string
+		keys                           []string
+		want                           int
+		code                           string
+		// the response of the handler, replayed or not, and the calls of the handler so far
+		wantBody     string
+		wantReplayed bool
+		wantCalls    int
+	}
+	post := func(key, body string) step {
+		return step{method: http.MethodPost, path: "/api/watchlists", remoteAddr: "192.0.2.1:1234", body: body, keys: []string{key}}
+	}
+	with := func(s step, want int, wantBody string, wantReplayed bool, wantCalls int) step {
+		s.want, s.wantBody, s.wantReplayed, s.wantCalls = want, wantBody, wantReplayed, wantCalls
+		return s
+	}
+	fails := func(s step, want int, code string, wantCalls int) step {
+		s.want, s.code, s.wantCalls = want, code, wantCalls
+		return s
+	}
+	tests := []struct {
+		name string
+		// the statuses the handler answers in turn
+		statuses []int
+		conf     Idempotency
+		steps    []step
+	}{
+		{name: "replay", statuses: []int{http.StatusCreated}, steps: []step{
+			with(post("k1", `{"name":"a"}`), http.StatusCreated, `call 1: {"name":"a"}`, false, 1),
+			with(post("k1", `{"name":"a"}`), http.StatusCreated, `call 1: {"name":"a"}`, true, 1),
+			// another key is another request
+			with(post("k2", `{"name":"a"}`), http.StatusCreated, `call 2: {"name":"a"}`, false, 2),
+		}},
+		{name: "client errors are replayed", statuses: []int{http.StatusBadRequest, http.StatusCreated}, steps: []step{
+			with(post("k1", `{}`), http.StatusBadRequest, "call 1: {}", false, 1),
+			with(post("k1", `{}`), http.StatusBadRequest, "call 1: {}", true, 1),
+		}},
+		{name: "key reused for another request", statuses: []int{http.StatusCreated}, steps: []step{
+			with(post("k1", `{"name":"a"}`), http.StatusCreated, `call 1: {"name":"a"}`, false, 1),
+			fails(post("k1", `{"name":"b"}`), http.StatusUnprocessableEntity, ErrIdempotencyReused.ID, 1),
+			fails(step{method: http.MethodPost, path: "/api/watchlists?dry_run=1", remoteAddr: "192.0.2.1:1234", body: `{"name":"a"}`, keys: []string{"k1"}},
+				http.StatusUnprocessableEntity, ErrIdempotencyReused.ID, 1),
+		}},
+		// a 5xx response is not kept and releases the key, the retry runs the request again
+		{name: "server error released", statuses: []int{http.StatusServiceUnavailable, http.StatusCreated}, steps: []step{
+			with(post("k1", `{}`), http.StatusServiceUnavailable, "call 1: {}", false, 1),
+			with(post("k1", `{}`), http.StatusCreated, "call 2: {}", false, 2),
+			with(post("k1", `{}`), http.StatusCreated, "call 2: {}", true, 2),
+		}},
+		{name: "keys of each client", statuses: []int{http.StatusCreated}, steps: []step{
+			with(post("k1", `{}`), http.StatusCreated, "call 1: {}", false, 1),
+			with(step{method: http.MethodPost, path: "/api/watchlists", remoteAddr: "192.0.2.2:1234", body: `{}`, keys: []string{"k1"}}, http.StatusCreated, "call 2: {}", false, 2),
+		}},
+		{name: "expired", statuses: []int{http.StatusCreated}, conf: Idempotency{TTL: time.Nanosecond}, steps: []step{
+			with(post("k1", `{}`), http.StatusCreated, "call 1: {}", false, 1),
+			with(post("k1", `{}`), http.StatusCreated, "call 2: {}", false, 2),
+		}},
+		{name: "response too large to keep", statuses: []int{http.StatusCreated}, conf: Idempotency{TTL: time.Hour, BodyMaxBytes: 4}, steps: []step{
+			with(post("k1", `{}`), http.StatusCreated, "call 1: {}", false, 1),
+			fails(post("k1", `{}`), http.StatusConflict, ErrIdempotencyNotKept.ID, 1),
+		}},
+		{name: "not idempotent", statuses: []int{http.StatusOK}, steps: []step{
+			with(step{method: http.MethodPost, path: "/api/watchlists", remoteAddr: "192.0.2.1:1234", body: `{}`}, http.StatusOK, "call 1: {}", false, 1),
+			with(step{method: http.MethodPost, path: "/api/watchlists", remoteAddr: "192.0.2.1:1234", body: `{}`}, http.StatusOK, "call 2: {}", false, 2),
+			with(step{method: http.MethodPut, path: "/api/watchlists/a", remoteAddr: "192.0.2.1:1234", body: `{}`, keys: []string{"k1"}}, http.StatusOK, "call 3: {}", false, 3),
+			with(step{method: http.MethodPut, path: "/api/watchlists/a", remoteAddr: "192.0.2.1:1234", body: `{}`, keys: []string{"k1"}}, http.StatusOK, "call 4: {}", false, 4),
+			with(step{method: http.MethodPost, path: "/api/admin/reload", remoteAddr: "192.0.2.1:1234", body: `{}`, keys: []string{"k1"}}, http.StatusOK, "call 5: {}", false, 5),
+			with(step{method: http.MethodPost, path: "/api/admin/reload", remoteAddr: "192.0.2.1:1234", body: `{}`, keys: []string{"k1"}}, http.StatusOK, "call 6: {}", false, 6),
+		}},
+		{name: "invalid keys", statuses: []int{http.StatusOK}, steps: []step{
+			fails(post("", `{}`), http.StatusBadRequest, ErrBadRequest.ID, 0),
+			fails(post("clé", `{}`), http.StatusBadRequest, ErrBadRequest.ID, 0),
+			fails(post(strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`), http.StatusBadRequest, ErrBadRequest.ID, 0),
+			fails(step{method: http.MethodPost, path: "/api/watchlists", remoteAddr: "192.0.2.1:1234", body: `{}`, keys: []string{"k1", "k2"}}, http.StatusBadRequest, ErrBadRequest.ID, 0),
+			with(post(strings.Repeat("k", maxIdempotencyKeyLength), `{}`), http.StatusOK, "call 1: {}", false, 1),
+		}},
+		{name: "request too large", statuses: []int{http.StatusOK}, steps: []step{
+			fails(post("k1", strings.Repeat(" ", idempotencyMaxRequestBytes+1)), http.StatusRequestEntityTooLarge, ErrRequestTooLarge.ID, 0),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := tt.conf
+			if conf.TTL == 0 {
+				conf.TTL = time.Hour
+			}
+			if conf.BodyMaxBytes == 0 {
+				conf.BodyMaxBytes = 1 << 10
+			}
+			s := idempotencyServer(t, NewIdempotencyMemoryStore(), conf)
+			next, calls := idempotentHandler(tt.statuses...)
+			h := s.idempotent(next)
+			for i, st := range tt.steps {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, idempotencyRequest(st.method, st.path, st.remoteAddr, st.body, st.keys...))
+				if w.Code != st.want {
+					t.Fatalf("step %d: got status %d %s, want %d", i, w.Code, w.Body, st.want)
+				}
+				if *calls != st.wantCalls {
+					t.Errorf("step %d: handler called %d times, want %d", i, *calls, st.wantCalls)
+				}
+				if replayed := w.Header().Get(IdempotentReplayedHeader) == "true"; replayed != st.wantReplayed {
+					t.Errorf("step %d: got %s %q, want replayed %t", i, IdempotentReplayedHeader, w.Header().Get(IdempotentReplayedHeader), st.wantReplayed)
+				}
+				if st.code != "" {
+					var body model.JSONErrors
+					if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+						t.Fatal(err)
+					}
+					if len(body.Errors) != 1 || body.Errors[0].ID != st.code {
+						t.Errorf("step %d: got %s, want the error %s", i, w.Body, st.code)
+					}
+					continue
+				}
+				if st.wantBody != "" && (w.Body.String() != st.wantBody || w.Header().Get("Content-Type") != "text/plain") {
+					t.Errorf("step %d: got %q %s, want %q", i, w.Header().Get("Content-Type"), w.Body, st.wantBody)
+				}
+			}
+		})
+	}
+}
+
+// TestIdempotentNotKeptStatus returns the status of the response too large to keep
+func TestIdempotentNotKeptStatus(t *testing.T) {
+	s := idempotencyServer(t, NewIdempotencyMemoryStore(), Idempotency{TTL: time.Hour, BodyMaxBytes: 1})
+	next, _ := idempotentHandler(http.StatusAccepted)
+	h := s.idempotent(next)
+	h.ServeHTTP(httptest.NewRecorder(), idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	var body model.JSONErrors
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Meta["status"] != "202" {
+		t.Errorf("got %s, want meta.status 202", w.Body)
+	}
+}
+
+// TestIdempotentBusy answers a retry sent while the first request is processed with a 409 and Retry-After,
+// and with the first response once it is done
+func TestIdempotentBusy(t *testing.T) {
+	s := idempotencyServer(t, NewIdempotencyMemoryStore(), Idempotency{TTL: time.Hour, BodyMaxBytes: 1 << 10})
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	h := s.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(first, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	busy := checkRetryError(t, w, ErrIdempotencyBusy)
+	checkRetryMeta(t, w, busy.Meta)
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want 1", got)
+	}
+	// another request with the key is still a reuse
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{"name":"b"}`, "k1"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("another request: got status %d %s, want 422", w.Code, w.Body)
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: got status %d %s", first.Code, first.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("after the first request: got status %d %s, want the replayed 201", w.Code, w.Body)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+// TestIdempotentPanic releases the key of a request whose handler panicked, the panic goes on
+func TestIdempotentPanic(t *testing.T) {
+	store := NewIdempotencyMemoryStore()
+	s := idempotencyServer(t, store, Idempotency{TTL: time.Hour, BodyMaxBytes: 1 << 10})
+	h := s.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic was recovered")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	}()
+	if len(store.entries) != 0 {
+		t.Errorf("kept %d keys, want the claim released", len(store.entries))
+	}
+}
+
+// failingIdempotencyStore fails every call
+type failingIdempotencyStore struct {
+	IdempotencyStore
+}
+
+func (failingIdempotencyStore) ClaimIdempotencyKey(ctx context.Context, claim *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func TestIdempotentStoreFails(t *testing.T) {
+	s := idempotencyServer(t, failingIdempotencyStore{}, Idempotency{TTL: time.Hour})
+	next, calls := idempotentHandler(http.StatusCreated)
+	w := httptest.NewRecorder()
+	before := idempotencyFailed.Value()
+	s.idempotent(next).ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+	if w.Code != http.StatusInternalServerError || *calls != 0 {
+		t.Errorf("got status %d after %d calls, want 500 without running the request", w.Code, *calls)
+	}
+	if idempotencyFailed.Value() != before+1 {
+		t.Errorf("counted %d failures, want 1", idempotencyFailed.Value()-before)
+	}
+}
+
+// TestIdempotentDisabled leaves the requests as they are without a store
+func TestIdempotentDisabled(t *testing.T) {
+	next, calls := idempotentHandler(http.StatusCreated)
+	h := testServer(t).idempotent(next)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, idempotencyRequest(http.MethodPost, "/api/watchlists", "192.0.2.1:1234", `{}`, "k1"))
+		if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("request %d: got status %d replayed %q", i, w.Code, w.Header().Get(IdempotentReplayedHeader))
+		}
+	}
+	if *calls != 2 {
+		t.Errorf("handler called %d times, want 2", *calls)
+	}
+}
+
+func TestIdempotencyMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	ms := NewIdempotencyMemoryStore()
+	claim := &model.IdempotencyKey{Client: "ip:192.0.2.1", Key: "k1", RequestSHA256: "a", ExpiresAt: time.Now().Add(time.Hour)}
+	if existing, err := ms.ClaimIdempotencyKey(ctx, claim); existing != nil || err != nil {
+		t.Fatalf("got %+v, %v, want the claim stored", existing, err)
+	}
+	// only one of concurrent claims wins
+	other := *claim
+	other.RequestSHA256 = "b"
+	if existing, _ := ms.ClaimIdempotencyKey(ctx, &other); existing == nil || existing.RequestSHA256 != "a" {
+		t.Errorf("got %+v, want the first claim", existing)
+	}
+	// another request does not save over or release the claim
+	done := other
+	done.Done = true
+	_ = ms.SaveIdempotencyKey(ctx, &done)
+	_ = ms.ReleaseIdempotencyKey(ctx, &other)
+	if existing, _ := ms.ClaimIdempotencyKey(ctx, claim); existing == nil || existing.Done || existing.RequestSHA256 != "a" {
+		t.Errorf("got %+v, want the first claim", existing)
+	}
+	// a done entry is not released
+	done = *claim
+	done.Done = true
+	_ = ms.SaveIdempotencyKey(ctx, &done)
+	_ = ms.ReleaseIdempotencyKey(ctx, claim)
+	if existing, _ := ms.ClaimIdempotencyKey(ctx, claim); existing == nil || !existing.Done {
+		t.Errorf("got %+v, want the done entry", existing)
+	}
+
+	expired := &model.IdempotencyKey{Client: "ip:192.0.2.1", Key: "k2", ExpiresAt: time.Now().Add(-time.Minute)}
+	ms.entries[[2]string{expired.Client, expired.Key}] = expired
+	id := &idempotency{store: ms}
+	before := idempotencyDeleted.Value()
+	if err := id.cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.entries) != 1 || idempotencyDeleted.Value() != before+1 {
+		t.Errorf("kept %d keys and deleted %d, want the expired one deleted", len(ms.entries), idempotencyDeleted.Value()-before)
+	}
+}
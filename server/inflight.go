@@ -0,0 +1,126 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// in-flight request counters
+var (
+	inflightRejectedGlobal = expvar.NewInt("inflight_rejected_global")
+	inflightRejectedClient = expvar.NewInt("inflight_rejected_client")
+)
+
+// inflightCurrent is the *inflightLimiter of the last server made, exported as the inflight_requests metric
+// the metric is published once, New may be called again
+var inflightCurrent atomic.Value
+
+func init() {
+	expvar.Publish("inflight_requests", expvar.Func(func() interface{} {
+		l, _ := inflightCurrent.Load().(*inflightLimiter)
+		if l == nil {
+			return nil
+		}
+		return l.stats()
+	}))
+}
+
+// inflightLimiter caps the number of requests being served at once, in total and per client IP
+// so that a slow database can not pile up unbounded goroutines
+type inflightLimiter struct {
+	// global semaphore, nil when there is no global limit
+	slots     chan struct{}
+	perClient int
+	current   int64
+
+	mu      sync.Mutex
+	clients map[string]int
+}
+
+func newInflightLimiter(max, perClient int) *inflightLimiter {
+	l := &inflightLimiter{
+		perClient: perClient,
+		clients:   make(map[string]int),
+	}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquireClient reserves one of the client's slots, returns false if the client is at its limit
+func (l *inflightLimiter) acquireClient(ip string) bool {
+	if l.perClient <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients[ip] >= l.perClient {
+		return false
+	}
+	l.clients[ip]++
+	return true
+}
+
+func (l *inflightLimiter) releaseClient(ip string) {
+	if l.perClient <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients[ip] <= 1 {
+		delete(l.clients, ip)
+		return
+	}
+	l.clients[ip]--
+}
+
+// handler rejects requests over the limits without waiting
+// the admin API and the operational routes are not limited
+func (l *inflightLimiter) handler(next http.Handler) http.Handler {
+	if l.slots == nil && l.perClient <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) || operationalRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := getIPAddress(r)
+		if !l.acquireClient(ip) {
+			inflightRejectedClient.Add(1)
+			WriteRetryError(w, ErrTooManyConcurrent, time.Second, map[string]string{"limit": strconv.Itoa(l.perClient)})
+			return
+		}
+		defer l.releaseClient(ip)
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				inflightRejectedGlobal.Add(1)
+				WriteRetryError(w, ErrOverloaded, time.Second)
+				return
+			}
+		}
+		atomic.AddInt64(&l.current, 1)
+		defer atomic.AddInt64(&l.current, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stats is exported as the inflight_requests metric, for the limiter in inflightCurrent
+func (l *inflightLimiter) stats() interface{} {
+	l.mu.Lock()
+	clients := len(l.clients)
+	l.mu.Unlock()
+	return map[string]interface{}{
+		"current": atomic.LoadInt64(&l.current),
+		"limit":   cap(l.slots),
+		"clients": clients,
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+// blockingHandler holds every request until release is closed
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (b *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.started <- struct{}{}
+	<-b.release
+}
+
+func inflightRequest(h http.Handler, remoteAddr, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// holdRequests starts a request for each of remoteAddrs and waits until all are being served
+func holdRequests(t *testing.T, h http.Handler, b *blockingHandler, wg *sync.WaitGroup, remoteAddrs ...string) {
+	t.Helper()
+	for _, addr := range remoteAddrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			inflightRequest(h, addr, "/api/domains/example.com")
+		}(addr)
+	}
+	for range remoteAddrs {
+		<-b.started
+	}
+}
+
+func checkRetryError(t *testing.T, w *httptest.ResponseRecorder, want *model.JSONError) *model.JSONError {
+	t.Helper()
+	if w.Code != want.Status {
+		t.Fatalf("got status %d, want %d", w.Code, want.Status)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	var body model.JSONErrors
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].ID != want.ID {
+		t.Fatalf("got errors %+v, want %s", body.Errors, want.ID)
+	}
+	if body.Errors[0].Meta[retryAfterMeta] == "" {
+		t.Errorf("no %s meta", retryAfterMeta)
+	}
+	return body.Errors[0]
+}
+
+func TestInflightPerClient(t *testing.T) {
+	l := newInflightLimiter(0, 2)
+	b := newBlockingHandler()
+	h := l.handler(b)
+	var wg sync.WaitGroup
+	holdRequests(t, h, b, &wg, "192.0.2.1:1", "192.0.2.1:2")
+
+	jsonErr := checkRetryError(t, inflightRequest(h, "192.0.2.1:3", "/api/domains/example.com"), ErrTooManyConcurrent)
+	if jsonErr.Meta["limit"] != "2" {
+		t.Errorf("got limit meta %q, want 2", jsonErr.Meta["limit"])
+	}
+	// another client still has its own slots
+	holdRequests(t, h, b, &wg, "192.0.2.2:1")
+	// the admin API and operational routes are not limited
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		inflightRequest(h, "192.0.2.1:4", "/api/admin/bans")
+	}()
+	<-b.started
+
+	close(b.release)
+	wg.Wait()
+	if w := inflightRequest(h, "192.0.2.1:5", "/api/domains/example.com"); w.Code != http.StatusOK {
+		t.Errorf("got status %d after the requests finished", w.Code)
+	}
+	if n := len(l.clients); n != 0 {
+		t.Errorf("%d clients left after the requests finished", n)
+	}
+}
+
+func TestInflightGlobal(t *testing.T) {
+	l := newInflightLimiter(3, 0)
+	b := newBlockingHandler()
+	h := l.handler(b)
+	var wg sync.WaitGroup
+	holdRequests(t, h, b, &wg, "192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1")
+
+	checkRetryError(t, inflightRequest(h, "192.0.2.4:1", "/api/domains/example.com"), ErrOverloaded)
+	if stats := l.stats().(map[string]interface{}); stats["current"] != int64(3) || stats["limit"] != 3 {
+		t.Errorf("got stats %v", stats)
+	}
+
+	close(b.release)
+	wg.Wait()
+	if w := inflightRequest(h, "192.0.2.4:1", "/api/domains/example.com"); w.Code != http.StatusOK {
+		t.Errorf("got status %d after the requests finished", w.Code)
+	}
+}
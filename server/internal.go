@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"dnscoffee/logging"
+)
+
+// internalPrefix is the path prefix of the routes called by other dnscoffee processes, such as the zone importer
+// they are served alongside the admin API and skip the same middleware
+const internalPrefix = "/api/internal"
+
+// Internal registers a route for other dnscoffee processes under /api/internal
+// requests need the import hook secret as a bearer token and may be limited to the internal allowlist and import hook CIDRs
+func (s *Server) Internal(method, path string, fn http.HandlerFunc) {
+	s.router.Handle(internalPrefix+path, s.internalAllow.handler(s.requireHookSecret(fn))).Methods(method)
+}
+
+// requireHookSecret rejects requests without the import hook secret or from outside the allowed networks
+func (s *Server) requireHookSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := getIPAddress(r)
+		if s.apiConfig.ImportHookSecret == "" {
+			logging.Warnf("internal: rejected %s %s from %s: import hook disabled", r.Method, r.URL.Path, ip)
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		if !s.hookAllowed(ip) {
+			logging.Warnf("internal: rejected %s %s from %s: address not allowed", r.Method, r.URL.Path, ip)
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="internal"`)
+			WriteJSONError(w, ErrUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiConfig.ImportHookSecret)) != 1 {
+			logging.Warnf("internal: rejected %s %s from %s: invalid secret", r.Method, r.URL.Path, ip)
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookAllowed returns true if ip is in one of the import hook networks, or there are none
+func (s *Server) hookAllowed(ip string) bool {
+	return len(s.hookNets) == 0 || containsIP(s.hookNets, ip)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// ParseIPParam parses the request parameter name as a single IP address in its canonical form
+// IPv4-mapped IPv6 addresses are unmapped to IPv4 and zone identifiers such as %eth0 are rejected,
+// so that every spelling of an address is the same key in the datastore, rate limiter and ban list
+func ParseIPParam(name, value string) (netip.Addr, *model.JSONError) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Addr{}, NewFieldError(name, "is not a valid IP address")
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, NewFieldError(name, "must not have a zone identifier")
+	}
+	return addr.Unmap(), nil
+}
+
+// ParseCIDRParam parses the request parameter name as a CIDR prefix in its canonical form, with the host bits masked
+// prefixes shorter than minV4 or minV6 bits are rejected to bound the addresses a query covers
+func ParseCIDRParam(name, value string, minV4, minV6 int) (netip.Prefix, *model.JSONError) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Prefix{}, NewFieldError(name, "is not a valid CIDR prefix")
+	}
+	addr := prefix.Addr()
+	if addr.Zone() != "" {
+		return netip.Prefix{}, NewFieldError(name, "must not have a zone identifier")
+	}
+	bits := prefix.Bits()
+	if addr.Is4In6() {
+		if bits < 96 {
+			return netip.Prefix{}, NewFieldError(name, "must not cover both IPv4-mapped and other IPv6 addresses")
+		}
+		addr = addr.Unmap()
+		bits -= 96
+	}
+	min := minV6
+	if addr.Is4() {
+		min = minV4
+	}
+	if bits < min {
+		return netip.Prefix{}, NewFieldError(name, fmt.Sprintf("must be at least a /%d", min))
+	}
+	return netip.PrefixFrom(addr, bits).Masked(), nil
+}
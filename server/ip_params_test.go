@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseIPParam(t *testing.T) {
+	tests := []struct {
+		value string
+		// the canonical address, empty when the value is rejected
+		want string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{" 192.0.2.1 ", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"2001:DB8:0::1", "2001:db8::1"},
+		{"fe80::1%eth0", ""},
+		{"192.0.2.256", ""},
+		{"192.0.2.0/24", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		addr, jsonErr := ParseIPParam("ip", tt.value)
+		if tt.want == "" {
+			if jsonErr == nil || jsonErr.Status != http.StatusBadRequest || jsonErr.Meta["field"] != "ip" {
+				t.Errorf("%q: got %s, %+v, want a 400 naming ip", tt.value, addr, jsonErr)
+			}
+			continue
+		}
+		if jsonErr != nil || addr.String() != tt.want {
+			t.Errorf("%q: got %s, %+v, want %s", tt.value, addr, jsonErr, tt.want)
+		}
+	}
+}
+
+func TestParseCIDRParam(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"192.0.2.0/24", "192.0.2.0/24"},
+		{"192.0.2.77/24", "192.0.2.0/24"},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"10.0.0.0/7", ""},
+		{"2001::/31", ""},
+		{"::ffff:0:0/95", ""},
+		{"fe80::%eth0/64", ""},
+		{"192.0.2.1", ""},
+		{"192.0.2.0/33", ""},
+	}
+	for _, tt := range tests {
+		prefix, jsonErr := ParseCIDRParam("cidr", tt.value, 8, 32)
+		if tt.want == "" {
+			if jsonErr == nil || jsonErr.Status != http.StatusBadRequest || jsonErr.Meta["field"] != "cidr" {
+				t.Errorf("%q: got %s, %+v, want a 400 naming cidr", tt.value, prefix, jsonErr)
+			}
+			continue
+		}
+		if jsonErr != nil || prefix.String() != tt.want {
+			t.Errorf("%q: got %s, %+v, want %s", tt.value, prefix, jsonErr, tt.want)
+		}
+	}
+}
+
+// TestAdminBanRemoveAddress lifts bans by any spelling of the banned address
+func TestAdminBanRemoveAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want int
+	}{
+		{name: "canonical", ip: "192.0.2.1", want: http.StatusOK},
+		{name: "IPv4-mapped", ip: "::ffff:192.0.2.1", want: http.StatusOK},
+		{name: "IPv6 spelled out", ip: "2001:DB8:0:0::1", want: http.StatusOK},
+		{name: "not banned", ip: "192.0.2.2", want: http.StatusNotFound},
+		{name: "zone", ip: "fe80::1%eth0", want: http.StatusBadRequest},
+		{name: "not an address", ip: "example.com", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			s.bans = newBanList(1, time.Minute, time.Minute, 100)
+			s.bans.strike("192.0.2.1")
+			s.bans.strike("2001:db8::1")
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/admin/bans/"+url.PathEscape(tt.ip), nil), map[string]string{"ip": tt.ip})
+			w := httptest.NewRecorder()
+			s.adminBanRemoveHandler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			want := 2
+			if tt.want == http.StatusOK {
+				want = 1
+			}
+			if len(s.bans.list()) != want {
+				t.Errorf("got %d bans, want %d", len(s.bans.list()), want)
+			}
+		})
+	}
+}
@@ -1,33 +1,103 @@
 package server
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
 )
 
-//custom vary by to use real remote IP without port
-type ipVaryBy struct{}
+// clientIPKey is the context key of the client address resolved by stripUntrustedProxyHeaders
+type clientIPKey struct{}
 
-func (ip ipVaryBy) Key(r *http.Request) string {
+// getIPAddress returns the address of the client of a request
+// that is the one resolved by stripUntrustedProxyHeaders, the peer address for requests that did not go through it
+func getIPAddress(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// ClientIP returns the address of the client of a request, as forwarded by the trusted proxies it came through
+func ClientIP(r *http.Request) string {
 	return getIPAddress(r)
 }
 
-func getIPAddress(r *http.Request) string {
-	hdr := r.Header
-	hdrRealIP := hdr.Get("X-Real-Ip")
-	hdrForwardedFor := hdr.Get("X-Forwarded-For")
-	if hdrRealIP == "" && hdrForwardedFor == "" {
-		hdrRealIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-		return hdrRealIP
+// remoteIP returns the address of the peer of a request, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// proxyHeaders are the forwarding headers set by reverse proxies
+var proxyHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded", "X-Forwarded-Proto", "X-Forwarded-Scheme", "X-Forwarded-Host"}
+
+// stripUntrustedProxyHeaders removes the forwarding headers from requests not sent by a trusted proxy
+// so that clients can not choose their own IP address, no peer is trusted when there are no trusted proxies
+// the client address is then resolved once and attached to the request context for getIPAddress
+func (s *Server) stripUntrustedProxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if s.isTrustedProxy(net.ParseIP(ip)) {
+			ip = s.forwardedIP(r, ip)
+		} else {
+			for _, h := range proxyHeaders {
+				r.Header.Del(h)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// forwardedIP returns the client address of a request sent by the trusted proxy peer
+// X-Forwarded-For is walked from the right, each proxy appends the address it received the request from,
+// and the first address that is not a trusted proxy is the client, everything left of it is client supplied
+// X-Real-Ip is used when there is no X-Forwarded-For, and peer when there is neither
+func (s *Server) forwardedIP(r *http.Request, peer string) string {
+	// X-Forwarded-For is potentially a list of addresses separated with ",", over several header lines
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				hops = append(hops, p)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ip != nil {
+			return ip.String()
+		}
+		return peer
+	}
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			// not an address a proxy appended, the last hop is the client as far as can be told
+			return ip
+		}
+		ip = hop.String()
+		if !s.isTrustedProxy(hop) {
+			return ip
+		}
+	}
+	// every hop is a trusted proxy, the leftmost one is the client
+	return ip
+}
+
+// isTrustedProxy returns true if ip is in one of the trusted proxy networks
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
 	}
-	if hdrForwardedFor != "" {
-		// X-Forwarded-For is potentially a list of addresses separated with ","
-		parts := strings.Split(hdrForwardedFor, ",")
-		for i, p := range parts {
-			parts[i] = strings.TrimSpace(p)
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
 		}
-		return parts[0]
 	}
-	return hdrRealIP
+	return false
 }
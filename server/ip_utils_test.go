@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{name: "peer", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "peer ipv6", remoteAddr: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		{name: "untrusted forwarded for", remoteAddr: "192.0.2.1:1234", forwardedFor: "198.51.100.1", want: "192.0.2.1"},
+		{name: "trusted forwarded for", trustedProxies: []string{"172.16.0.0/12"}, remoteAddr: "172.16.0.1:1234", forwardedFor: "198.51.100.1", want: "198.51.100.1"},
+		{name: "rightmost untrusted hop", trustedProxies: []string{"172.16.0.0/12"}, remoteAddr: "172.16.0.1:1234", forwardedFor: "203.0.113.1, 198.51.100.1, 172.16.0.2", want: "198.51.100.1"},
+		{name: "only trusted hops", trustedProxies: []string{"172.16.0.0/12"}, remoteAddr: "172.16.0.1:1234", forwardedFor: "172.16.0.3, 172.16.0.2", want: "172.16.0.3"},
+		{name: "garbage hop", trustedProxies: []string{"172.16.0.0/12"}, remoteAddr: "172.16.0.1:1234", forwardedFor: "garbage", want: "172.16.0.1"},
+		{name: "ipv6 hop", trustedProxies: []string{"2001:db8::/32"}, remoteAddr: "[2001:db8::1]:1234", forwardedFor: "2001:db9::1", want: "2001:db9::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t, tt.trustedProxies...)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			var got string
+			s.stripUntrustedProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// jobJitter is the fraction of the interval each run of a job is moved by at random
+// so that instances started together do not run their jobs at the same time
+const jobJitter = 0.1
+
+// job is a named function run periodically by the server
+// each job runs in a single goroutine so that it never overlaps with itself
+type job struct {
+	name     string
+	interval time.Duration
+	fn       func(context.Context) error
+	// signals the job's goroutine to run now
+	trigger chan struct{}
+
+	mu    sync.Mutex
+	state model.Job
+}
+
+// AddJob registers fn to run every interval until the server shuts down
+// the first run is shortly after Start, jobs must be added before Start
+func (s *Server) AddJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, &job{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+		trigger:  make(chan struct{}, 1),
+		state:    model.Job{Name: name, Interval: interval},
+	})
+}
+
+// jittered returns d moved by up to jobJitter of d in either direction
+func jittered(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*jobJitter*float64(d))
+}
+
+// startJobs starts the goroutine of every job, they are stopped by stopJobs
+func (s *Server) startJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopJobsCtx = cancel
+	for _, j := range s.jobs {
+		s.jobsRunning.Add(1)
+		go func(j *job) {
+			defer s.jobsRunning.Done()
+			j.loop(ctx)
+		}(j)
+	}
+}
+
+// stopJobs cancels the running jobs and waits for them to return until ctx is done
+func (s *Server) stopJobs(ctx context.Context) error {
+	if s.stopJobsCtx == nil {
+		return nil
+	}
+	s.stopJobsCtx()
+	done := make(chan struct{})
+	go func() {
+		s.jobsRunning.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *job) loop(ctx context.Context) {
+	// the first run is spread over the jitter window only
+	t := time.NewTimer(time.Duration(rand.Float64() * jobJitter * float64(j.interval)))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-j.trigger:
+			if !t.Stop() {
+				<-t.C
+			}
+		}
+		j.run(ctx)
+		t.Reset(jittered(j.interval))
+	}
+}
+
+func (j *job) run(ctx context.Context) {
+	j.mu.Lock()
+	j.state.Running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	err := j.fn(ctx)
+	took := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		logging.Debugf("job %s stopped by shutdown after %s: %s", j.name, took.Round(time.Millisecond), err)
+	} else if err != nil {
+		logging.Errorf("job %s failed after %s: %s", j.name, took.Round(time.Millisecond), err)
+	} else {
+		logging.Debugf("job %s finished in %s", j.name, took.Round(time.Millisecond))
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Running = false
+	j.state.Runs++
+	j.state.LastRun = model.NewTimestamp(start)
+	j.state.LastDuration = took
+	j.state.LastError = ""
+	if err != nil {
+		j.state.LastError = err.Error()
+	}
+}
+
+// status returns a copy of the job's state
+func (j *job) status() *model.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	state := j.state
+	return &state
+}
+
+// adminJobsHandler lists the background jobs and the result of their last run
+func (s *Server) adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	data := &model.Jobs{Jobs: make([]*model.Job, 0, len(s.jobs))}
+	for _, j := range s.jobs {
+		data.Jobs = append(data.Jobs, j.status())
+	}
+	sort.Slice(data.Jobs, func(i, k int) bool { return data.Jobs[i].Name < data.Jobs[k].Name })
+	WriteJSON(w, data)
+}
+
+// findJob returns the job named name, or nil
+func (s *Server) findJob(name string) *job {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// runNow makes the job run now, a job that is already running is run again once it finishes
+func (j *job) runNow() {
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// a run is already pending
+	}
+}
+
+// RunJob makes the named job run now without waiting for it
+// returns false if there is no such job
+func (s *Server) RunJob(name string) bool {
+	j := s.findJob(name)
+	if j == nil {
+		return false
+	}
+	j.runNow()
+	return true
+}
+
+// adminJobRunHandler runs a job now, a job that is already running is run again once it finishes
+func (s *Server) adminJobRunHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	j := s.findJob(name)
+	if j == nil {
+		WriteJSONError(w, ErrResourceNotFound)
+		return
+	}
+	j.runNow()
+	logging.Infof("admin: %s triggered job %s", getIPAddress(r), name)
+	WriteJSON(w, j.status())
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"dnscoffee/logging"
+)
+
+// maxBufferedResponse is the largest JSON response encoded into a pooled buffer
+// larger responses are streamed so that they neither grow the pooled buffers nor are held in memory whole
+const maxBufferedResponse = 1 << 20
+
+// jsonStreamBuffer is the size of the buffer between a streamed JSON response and the connection
+const jsonStreamBuffer = 32 << 10
+
+// jsonBufferPool holds the buffers JSON responses are encoded into
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// countingWriter counts the bytes written to it and drops them, past limit it fails with errResponseTooLarge
+type countingWriter struct {
+	// 0 is unlimited
+	limit int
+	size  int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.size += len(p)
+	if cw.limit > 0 && cw.size > cw.limit {
+		return 0, errResponseTooLarge
+	}
+	return len(p), nil
+}
+
+// writeJSONBody writes v as a JSON response with status and a Content-Length
+// returns the encoded size and errResponseTooLarge, without writing anything, if it is over limit
+// responses estimated at up to maxBufferedResponse are encoded into a pooled buffer and written at once, larger ones
+// are encoded twice without being kept, once to count their size and then to the response as they are encoded;
+// either way the size limit and encoding errors are known before anything is sent, so they are answered with an
+// error instead of a truncated response
+func writeJSONBody(w http.ResponseWriter, status int, v interface{}, limit int) (int, error) {
+	if estimateJSONSize(reflect.ValueOf(v)) > maxBufferedResponse {
+		return streamJSONBody(w, status, v, limit)
+	}
+	buf := jsonBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		// an estimate short of the size may still have grown the buffer
+		if buf.Cap() <= maxBufferedResponse {
+			jsonBufferPool.Put(buf)
+		}
+	}()
+
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return buf.Len(), err
+	}
+	size := buf.Len()
+	if limit > 0 && size > limit {
+		return size, errResponseTooLarge
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return size, err
+}
+
+// streamJSONBody writes v, a response larger than maxBufferedResponse, as it is encoded
+func streamJSONBody(w http.ResponseWriter, status int, v interface{}, limit int) (int, error) {
+	counter := &countingWriter{limit: limit}
+	if err := encodeJSON(counter, v); err != nil {
+		return counter.size, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(counter.size))
+	w.WriteHeader(status)
+	out := bufio.NewWriterSize(w, jsonStreamBuffer)
+	if err := encodeJSON(out, v); err != nil {
+		return counter.size, err
+	}
+	return counter.size, out.Flush()
+}
+
+// writeFailed handles an error from writeJSONBody
+// a client going away mid response is normal and only logged at debug,
+// a value that can not be marshaled is a bug, nothing has been sent yet so the client gets ErrInternalServer
+func writeFailed(w http.ResponseWriter, err error) {
+	route := "unknown route"
+	if sw, ok := findSizeWriter(w); ok {
+		route = sw.route
+	}
+	var unsupportedType *json.UnsupportedTypeError
+	var unsupportedValue *json.UnsupportedValueError
+	var marshaler *json.MarshalerError
+	switch {
+	case clientGone(err):
+		logging.Debugf("writing response for %s: %s", route, err)
+	case errors.As(err, &unsupportedType), errors.As(err, &unsupportedValue), errors.As(err, &marshaler):
+		logging.Errorf("encoding response for %s: %s", route, err)
+		WriteJSONError(w, ErrInternalServer)
+	default:
+		logging.Warnf("writing response for %s: %s", route, err)
+	}
+}
+
+// clientGone returns true for write errors caused by the client closing the connection or the request ending
+func clientGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, http.ErrHandlerTimeout)
+}
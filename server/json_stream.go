@@ -0,0 +1,368 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// encodeJSON writes v to w as json.NewEncoder(w).Encode(v) does, but a list at a time element rather than all at once
+// structs, pointers, interfaces, slices and arrays are walked, every other value and those with their own marshaler
+// are encoded with json.Marshal, so a response is never held in memory whole, only its largest element
+func encodeJSON(w io.Writer, v interface{}) error {
+	if err := encodeValue(w, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
+
+func encodeValue(w io.Writer, v reflect.Value) error {
+	if !v.IsValid() {
+		_, err := w.Write([]byte("null"))
+		return err
+	}
+	t := v.Type()
+	// marshalers with pointer receivers are used for addressable values, like encoding/json does
+	if t.Kind() != reflect.Ptr && v.CanAddr() && implementsMarshaler(reflect.PtrTo(t)) {
+		return marshalTo(w, v.Addr())
+	}
+	if implementsMarshaler(t) {
+		return marshalTo(w, v)
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			_, err := w.Write([]byte("null"))
+			return err
+		}
+		return encodeValue(w, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			_, err := w.Write([]byte("null"))
+			return err
+		}
+		// byte slices are base64 strings
+		if t.Elem().Kind() == reflect.Uint8 {
+			return marshalTo(w, v)
+		}
+		return encodeList(w, v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return marshalTo(w, v)
+		}
+		return encodeList(w, v)
+	case reflect.Struct:
+		return encodeStruct(w, v)
+	}
+	return marshalTo(w, v)
+}
+
+// estimatedListLength is the shortest list estimateJSONSize estimates from its first element rather than walks
+const estimatedListLength = 256
+
+// estimateJSONSize returns about how many bytes the lists reachable from v encode to, without encoding them:
+// a long list is estimated as its length times the size of its first element, short ones are walked, the rest of
+// the response is small and not counted
+func estimateJSONSize(v reflect.Value) int {
+	if !v.IsValid() {
+		return 0
+	}
+	t := v.Type()
+	if !mayHoldList(t) {
+		return 0
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return estimateJSONSize(v.Elem())
+	case reflect.Struct:
+		fields, _ := cachedJSONFields(t)
+		size := 0
+		for _, f := range fields {
+			if fv, ok := fieldByIndex(v, f.index); ok {
+				size += estimateJSONSize(fv)
+			}
+		}
+		return size
+	case reflect.Slice, reflect.Array:
+		n := v.Len()
+		if n == 0 {
+			return 0
+		}
+		if n >= estimatedListLength {
+			first, err := json.Marshal(v.Index(0).Interface())
+			if err != nil {
+				return 0
+			}
+			return n * (len(first) + 1)
+		}
+		size := 0
+		for i := 0; i < n; i++ {
+			size += estimateJSONSize(v.Index(i))
+		}
+		return size
+	}
+	return 0
+}
+
+// listTypes caches mayHoldList by type
+var listTypes sync.Map
+
+// mayHoldList returns false for the types whose values can not hold a list encodeJSON walks
+func mayHoldList(t reflect.Type) bool {
+	if holds, ok := listTypes.Load(t); ok {
+		return holds.(bool)
+	}
+	holds := typeMayHoldList(t, map[reflect.Type]bool{})
+	listTypes.Store(t, holds)
+	return holds
+}
+
+func typeMayHoldList(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if implementsMarshaler(t) || implementsMarshaler(reflect.PtrTo(t)) || visiting[t] {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() != reflect.Uint8
+	case reflect.Ptr:
+		return typeMayHoldList(t.Elem(), visiting)
+	case reflect.Struct:
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			if typeMayHoldList(t.Field(i).Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func implementsMarshaler(t reflect.Type) bool {
+	return t.Implements(marshalerType) || t.Implements(textMarshalerType)
+}
+
+func marshalTo(w io.Writer, v reflect.Value) error {
+	encoded, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+func encodeList(w io.Writer, v reflect.Value) error {
+	if _, err := w.Write([]byte{'['}); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		if err := encodeValue(w, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{']'})
+	return err
+}
+
+func encodeStruct(w io.Writer, v reflect.Value) error {
+	fields, ok := cachedJSONFields(v.Type())
+	if !ok {
+		return marshalTo(w, v)
+	}
+	if _, err := w.Write([]byte{'{'}); err != nil {
+		return err
+	}
+	first := true
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyJSONValue(fv) {
+			continue
+		}
+		sep := f.key
+		if !first {
+			sep = f.sepKey
+		}
+		first = false
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+		if err := encodeValue(w, fv); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{'}'})
+	return err
+}
+
+// fieldByIndex returns the field at index, false when it is reached through a nil embedded pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyJSONValue reports the values omitempty leaves out
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonField is a struct field encoding/json encodes, with its key ready to write
+type jsonField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	// the string option, which is left to encoding/json
+	quoted bool
+	// "name": and ,"name":
+	key, sepKey []byte
+}
+
+// jsonFieldCache holds the []jsonField of the struct types encoded so far, nil for those left to json.Marshal
+var jsonFieldCache sync.Map
+
+// cachedJSONFields returns the fields of t, false if a field has the string option and t is left to json.Marshal
+func cachedJSONFields(t reflect.Type) ([]jsonField, bool) {
+	fields, ok := jsonFieldCache.Load(t)
+	if !ok {
+		fields, _ = jsonFieldCache.LoadOrStore(t, jsonFields(t))
+	}
+	return fields.([]jsonField), fields.([]jsonField) != nil
+}
+
+// jsonFields returns the fields of t encoding/json encodes, in its order and with its rules: the fields of embedded
+// structs are promoted, and of the fields with the same name the least nested one wins, a tagged one among equals
+func jsonFields(t reflect.Type) []jsonField {
+	var all []jsonField
+	collectJSONFields(t, nil, map[reflect.Type]bool{}, &all)
+
+	byName := map[string][]jsonField{}
+	for _, f := range all {
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var fields []jsonField
+	for _, candidates := range byName {
+		if f, ok := dominantJSONField(candidates); ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return indexLess(fields[i].index, fields[j].index) })
+	for i := range fields {
+		if fields[i].quoted {
+			return nil
+		}
+		key, _ := json.Marshal(fields[i].name)
+		fields[i].key = append(key, ':')
+		fields[i].sepKey = append([]byte{','}, fields[i].key...)
+	}
+	return fields
+}
+
+func collectJSONFields(t reflect.Type, index []int, visiting map[reflect.Type]bool, out *[]jsonField) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous {
+			if !sf.IsExported() && ft.Kind() != reflect.Struct {
+				continue
+			}
+		} else if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int{}, index...), i)
+		if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+			collectJSONFields(ft, fieldIndex, visiting, out)
+			continue
+		}
+		f := jsonField{name: name, index: fieldIndex, tagged: name != ""}
+		if name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				f.omitEmpty = true
+			case "string":
+				f.quoted = true
+			}
+		}
+		*out = append(*out, f)
+	}
+}
+
+// dominantJSONField returns the field of a name that is encoded, false when none is because of an ambiguity
+func dominantJSONField(fields []jsonField) (jsonField, bool) {
+	sort.Slice(fields, func(i, j int) bool {
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return jsonField{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
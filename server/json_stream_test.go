@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// modelTypes are the struct types of the model package, the responses encodeJSON has to encode like encoding/json
+var modelTypes = []interface{}{
+	model.Metadata{},
+	model.JSONResponse{},
+	model.EnvelopedResponse{},
+	model.ResponseEnvelope{},
+	model.ResponseMeta{},
+	model.ResponseLinks{},
+	model.JSONErrors{},
+	model.JSONError{},
+	model.ImportProgress{},
+	model.ImportDate{},
+	model.ViewRefresh{},
+	model.RateLimit{},
+	model.CacheFlush{},
+	model.Maintenance{},
+	model.LoadShedding{},
+	model.LoadClassWindow{},
+	model.AdminStatus{},
+	model.ReferenceData{},
+	model.ConfigReload{},
+	model.Version{},
+	model.Ban{},
+	model.Bans{},
+	model.Activity{},
+	model.ActivityCounts{},
+	model.ActivityClient{},
+	model.ActivityRoute{},
+	model.Job{},
+	model.RouteStatus{},
+	model.Routes{},
+	model.Jobs{},
+	model.AuditRecord{},
+	model.Recording{},
+	model.IdempotencyKey{},
+	model.AuditLog{},
+	model.ProviderCount{},
+	model.ProviderCounts{},
+	model.DomainLifetimes{},
+	model.LifetimeBucket{},
+	model.CohortSample{},
+	model.CohortDomain{},
+	model.ZoneDomains{},
+	model.ZoneDomain{},
+	model.GlueInconsistencies{},
+	model.GlueInconsistency{},
+	model.Import{},
+	model.ImportCheck{},
+	model.ImportAlerts{},
+	model.ImportStage{},
+	model.RunningImports{},
+	model.Watchlist{},
+	model.WatchQuery{},
+	model.Watchlists{},
+	model.WatchlistMatches{},
+	model.WatchlistMatch{},
+	model.LabelZones{},
+	model.LabelZone{},
+	model.LiveDomain{},
+	model.ZoneDiff{},
+	model.ZoneChurn{},
+	model.ChurnFractions{},
+	model.ImportNotification{},
+	model.ZoneImportResults{},
+	model.ZoneImportResult{},
+	model.ZoneCount{},
+	model.ZoneCounts{},
+	model.NameServerStats{},
+	model.NameServerChanges{},
+	model.NameServerChange{},
+	model.NameServerSuffixStats{},
+	model.NameServerSuffixCount{},
+	model.NameServerCount{},
+	model.NameServerSet{},
+	model.AllZoneCounts{},
+	model.Zone{},
+	model.ZoneInfrastructure{},
+	model.ZoneDelegation{},
+	model.DelegationNameServer{},
+	model.ZoneInfrastructureHistory{},
+	model.DelegationChange{},
+	model.RootZone{},
+	model.Domain{},
+	model.FeedDelta{},
+	model.FeedDeltaDomain{},
+	model.ZoneCountAsOf{},
+	model.ZoneCountAsOfSeries{},
+	model.LabelStats{},
+	model.DomainStability{},
+	model.LabelBucket{},
+	model.BulkManifest{},
+	model.BulkArtifact{},
+	model.KeywordTimeseries{},
+	model.KeywordPeriod{},
+	model.Feed{},
+	model.NSFeed{},
+	model.NameServer{},
+	model.IP{},
+	model.IPRoute{},
+	model.IP4{},
+	model.IP6{},
+	model.ASN{},
+	model.Search{},
+	model.SearchResult{},
+	model.PrefixResult{},
+	model.PrefixList{},
+	model.TLDLife{},
+	model.FeedCountList{},
+	model.FeedCount{},
+}
+
+// fillValue sets every exported field reachable from v, strings to their field name, numbers to 1 and slices and maps
+// to two elements, so that no omitempty field is left out; types already being filled higher up are left empty
+func fillValue(v reflect.Value, name string, filling map[reflect.Type]bool) {
+	t := v.Type()
+	if t == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(name + " <&>")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(0.5)
+	case reflect.Ptr:
+		if filling[t.Elem()] {
+			return
+		}
+		p := reflect.New(t.Elem())
+		fillValue(p.Elem(), name, filling)
+		v.Set(p)
+	case reflect.Slice:
+		if filling[t.Elem()] {
+			return
+		}
+		s := reflect.MakeSlice(t, 2, 2)
+		fillValue(s.Index(0), name, filling)
+		fillValue(s.Index(1), name, filling)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillValue(v.Index(i), name, filling)
+		}
+	case reflect.Map:
+		if filling[t.Elem()] {
+			return
+		}
+		m := reflect.MakeMap(t)
+		for _, k := range []string{"b", "a"} {
+			key := reflect.New(t.Key()).Elem()
+			fillValue(key, k, filling)
+			elem := reflect.New(t.Elem()).Elem()
+			fillValue(elem, name, filling)
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			v.Set(reflect.ValueOf([]string{name, "x"}))
+		}
+	case reflect.Struct:
+		filling[t] = true
+		defer delete(filling, t)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				fillValue(v.Field(i), f.Name, filling)
+			}
+		}
+	}
+}
+
+// checkEncodeJSON compares encodeJSON with json.Encoder for v
+func checkEncodeJSON(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	var want, got bytes.Buffer
+	wantErr := json.NewEncoder(&want).Encode(v)
+	gotErr := encodeJSON(&got, v)
+	if (wantErr == nil) != (gotErr == nil) {
+		t.Errorf("%s: got error %v, want %v", name, gotErr, wantErr)
+		return
+	}
+	if wantErr == nil && !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("%s:\ngot  %s\nwant %s", name, got.Bytes(), want.Bytes())
+	}
+}
+
+func TestEncodeJSONModel(t *testing.T) {
+	for _, zero := range modelTypes {
+		typ := reflect.TypeOf(zero)
+		checkEncodeJSON(t, typ.Name()+" zero", zero)
+		filled := reflect.New(typ)
+		fillValue(filled.Elem(), "", map[reflect.Type]bool{})
+		checkEncodeJSON(t, typ.Name(), filled.Interface())
+		checkEncodeJSON(t, typ.Name()+" value", filled.Elem().Interface())
+		checkEncodeJSON(t, typ.Name()+" in a response", model.JSONResponse{Data: filled.Interface()})
+		checkEncodeJSON(t, typ.Name()+" in an envelope", &model.EnvelopedResponse{Data: filled.Interface()})
+	}
+}
+
+// types exercising the rules of encoding/json
+type (
+	jsonInner struct {
+		A string `json:"a"`
+		B string `json:"b,omitempty"`
+		C string
+	}
+	jsonOther struct {
+		A string `json:"a"`
+		D int
+	}
+	jsonTagged struct {
+		D int `json:"D"`
+	}
+	jsonUnexported struct {
+		E string `json:"e"`
+	}
+	jsonText     string
+	jsonPtrValue struct{ n int }
+	jsonOuter    struct {
+		jsonInner
+		*jsonOther
+		jsonTagged
+		jsonUnexported
+		// same depth as jsonInner.C through no embedding, wins
+		C       int              `json:"C,omitempty"`
+		Skipped string           `json:"-"`
+		Dash    string           `json:"-,"`
+		Quoted  string           `json:"quoted,omitempty"`
+		Bytes   []byte           `json:"bytes"`
+		Array   [2]int           `json:"array"`
+		Nil     []int            `json:"nil"`
+		Empty   []int            `json:"empty,omitempty"`
+		Text    jsonText         `json:"text"`
+		Texts   map[jsonText]int `json:"texts"`
+		IP      net.IP           `json:"ip"`
+		Ptr     jsonPtrValue     `json:"ptr"`
+		Ptrs    []jsonPtrValue   `json:"ptrs"`
+		Any     interface{}      `json:"any"`
+		Float   float64          `json:"float"`
+		Nested  map[string][]int `json:"nested"`
+		private string
+	}
+)
+
+func (t jsonText) MarshalText() ([]byte, error) { return []byte(strings.ToUpper(string(t))), nil }
+
+func (p *jsonPtrValue) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{ "n" : %d }`, p.n)), nil
+}
+
+// jsonQuoted has a field with the string option, which encodeJSON leaves to encoding/json
+type jsonQuoted struct {
+	N int `json:"n,string"`
+}
+
+// jsonCycle only holds itself through a pointer, it is not embedded
+type jsonCycle struct {
+	Next *jsonCycle `json:"next,omitempty"`
+}
+
+func TestEncodeJSONRules(t *testing.T) {
+	outer := &jsonOuter{
+		jsonInner:      jsonInner{A: "inner a", C: "inner c"},
+		jsonTagged:     jsonTagged{D: 4},
+		jsonUnexported: jsonUnexported{E: "e"},
+		Skipped:        "skipped",
+		Dash:           "dash",
+		Bytes:          []byte("bytes"),
+		Empty:          []int{},
+		Text:           "text",
+		Texts:          map[jsonText]int{"b": 2, "a": 1},
+		IP:             net.ParseIP("192.0.2.1"),
+		Ptr:            jsonPtrValue{n: 1},
+		Ptrs:           []jsonPtrValue{{n: 2}, {n: 3}},
+		Any:            []interface{}{1, "two", nil, map[string]int{"three": 3}},
+		Float:          1e21,
+		Nested:         map[string][]int{"x": {1}},
+		private:        "private",
+	}
+	checkEncodeJSON(t, "outer", outer)
+	checkEncodeJSON(t, "outer value", *outer)
+	outer.jsonOther = &jsonOther{A: "other a", D: 5}
+	checkEncodeJSON(t, "outer with the embedded pointer", outer)
+	checkEncodeJSON(t, "quoted", []jsonQuoted{{N: 1}})
+	checkEncodeJSON(t, "cycle", &jsonCycle{Next: &jsonCycle{}})
+	checkEncodeJSON(t, "nil", nil)
+	checkEncodeJSON(t, "unsupported", map[string]interface{}{"f": func() {}})
+}
+
+func TestEstimateJSONSize(t *testing.T) {
+	size := func(v interface{}) int {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+	tests := []struct {
+		name       string
+		v          interface{}
+		wantStream bool
+	}{
+		{name: "nil", v: nil},
+		{name: "no lists", v: model.JSONResponse{Data: &model.Ban{IP: "192.0.2.1"}}},
+		{name: "short list", v: model.JSONResponse{Data: testBans(100)}},
+		{name: "long list", v: model.JSONResponse{Data: testBans(estimatedListLength)}},
+		{name: "larger than the pooled buffers", v: model.JSONResponse{Data: testBans(20000)}, wantStream: true},
+		{name: "enveloped", v: model.EnvelopedResponse{Data: testBans(20000)}, wantStream: true},
+		{name: "short lists of long lists", v: []*model.Bans{testBans(10000), testBans(10000)}, wantStream: true},
+	}
+	for _, test := range tests {
+		got := estimateJSONSize(reflect.ValueOf(test.v))
+		if stream := got > maxBufferedResponse; stream != test.wantStream {
+			t.Errorf("%s: estimated %d bytes of %d, stream = %v, want %v", test.name, got, size(test.v), stream, test.wantStream)
+		}
+	}
+}
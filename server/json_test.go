@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// testBans returns a response of about n*150 bytes
+func testBans(n int) *model.Bans {
+	bans := &model.Bans{}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		bans.Bans = append(bans.Bans, &model.Ban{
+			IP:      "192.0.2." + strconv.Itoa(i%256),
+			Strikes: i,
+			Since:   model.NewTimestamp(now),
+			Until:   model.NewTimestamp(now.Add(time.Minute)),
+		})
+	}
+	return bans
+}
+
+func TestWriteJSONSizeLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		bans       int
+		limit      int
+		wantStatus int
+	}{
+		{name: "small", bans: 10, limit: 1 << 20, wantStatus: http.StatusOK},
+		{name: "larger than the pooled buffers", bans: 20000, limit: 8 << 20, wantStatus: http.StatusOK},
+		{name: "unlimited", bans: 20000, wantStatus: http.StatusOK},
+		{name: "small over the limit", bans: 10, limit: 100, wantStatus: ErrResponseTooLarge.Status},
+		{name: "larger than the pooled buffers over the limit", bans: 20000, limit: maxBufferedResponse + 1, wantStatus: ErrResponseTooLarge.Status},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WriteJSON(&sizeWriter{ResponseWriter: rec, route: "/test", limit: tt.limit}, testBans(tt.bans))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			checkContentLength(t, rec)
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON: %s", err)
+			}
+			if tt.wantStatus != http.StatusOK && body["errors"] == nil {
+				t.Error("no error in the response")
+			}
+		})
+	}
+}
+
+// streamProbe is a list item recording how much of the response was written when it was encoded
+type streamProbe struct {
+	rec     *httptest.ResponseRecorder
+	written *int
+}
+
+func (p streamProbe) MarshalJSON() ([]byte, error) {
+	if n := p.rec.Body.Len(); n > *p.written {
+		*p.written = n
+	}
+	return json.Marshal(strings.Repeat("x", 1000))
+}
+
+// TestWriteJSONStreams checks that a response larger than the pooled buffers is written while it is encoded
+func TestWriteJSONStreams(t *testing.T) {
+	rec := httptest.NewRecorder()
+	written := 0
+	items := make([]streamProbe, 2*maxBufferedResponse/1000)
+	for i := range items {
+		items[i] = streamProbe{rec: rec, written: &written}
+	}
+	size, err := writeJSONBody(rec, http.StatusOK, items, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written == 0 || written >= size {
+		t.Errorf("%d of %d bytes written when the last item was encoded, want part of them", written, size)
+	}
+	checkContentLength(t, rec)
+	var body []string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body) != len(items) {
+		t.Errorf("got %d items, %v", len(body), err)
+	}
+}
+
+func checkContentLength(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	got, err := strconv.Atoi(rec.Header().Get("Content-Length"))
+	if err != nil {
+		t.Fatalf("invalid Content-Length %q", rec.Header().Get("Content-Length"))
+	}
+	if got != rec.Body.Len() {
+		t.Errorf("got Content-Length %d, body of %d bytes", got, rec.Body.Len())
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+	}{
+		{"json", func(w http.ResponseWriter) { WriteJSON(w, testBans(3)) }},
+		{"empty list", func(w http.ResponseWriter) { WriteJSON(w, &model.Bans{}) }},
+		{"unicode", func(w http.ResponseWriter) {
+			WriteJSON(w, &model.Bans{Bans: []*model.Ban{{IP: "例え.テスト <&>"}}})
+		}},
+		{"error", func(w http.ResponseWriter) { WriteJSONError(w, ErrResourceNotFound) }},
+		{"retry error", func(w http.ResponseWriter) { WriteRetryError(w, ErrOverloaded, time.Second) }},
+		{"body", func(w http.ResponseWriter) { WriteBody(w, "text/csv", []byte("a,b\n1,2\n")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.write(rec)
+			checkContentLength(t, rec)
+		})
+	}
+}
+
+func TestWriteJSONUnsupportedValue(t *testing.T) {
+	rec := httptest.NewRecorder()
+	size, err := writeJSONBody(rec, http.StatusOK, map[string]interface{}{"f": func() {}}, 0)
+	if err == nil {
+		t.Fatal("no error for a value that can not be encoded")
+	}
+	if size != 0 || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "" {
+		t.Error("part of the response was written")
+	}
+	writeFailed(rec, err)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rec.Code)
+	}
+}
+
+// discardWriter is a ResponseWriter that allocates nothing
+type discardWriter struct{ h http.Header }
+
+func (d *discardWriter) Header() http.Header         { return d.h }
+func (d *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardWriter) WriteHeader(int)             {}
+
+// BenchmarkWriteJSON compares the pooled buffers of writeJSONBody with encoding straight to the response,
+// as WriteJSON did before, in allocations per op
+func BenchmarkWriteJSON(b *testing.B) {
+	for _, n := range []int{1, 100} {
+		data := model.JSONResponse{Data: testBans(n)}
+		b.Run("pooled/"+strconv.Itoa(n), func(b *testing.B) {
+			w := &discardWriter{h: make(http.Header)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := writeJSONBody(w, http.StatusOK, data, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("encoder/"+strconv.Itoa(n), func(b *testing.B) {
+			w := &discardWriter{h: make(http.Header)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// brokenWriter is a ResponseWriter whose writes fail with err, like those to a client that went away
+type brokenWriter struct {
+	h      http.Header
+	err    error
+	status int
+}
+
+func (b *brokenWriter) Header() http.Header         { return b.h }
+func (b *brokenWriter) Write(p []byte) (int, error) { return 0, b.err }
+func (b *brokenWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// unencodable is response data encoding/json can not encode
+type unencodable struct {
+	F func() `json:"f"`
+}
+
+func (u *unencodable) GenerateMetaData() {}
+
+// TestWriteErrors serves the JSON handlers to clients whose connection fails, none of them may panic
+func TestWriteErrors(t *testing.T) {
+	s := testServer(t)
+	s.AddReadinessCheck("database", func() error { return errors.New("down") })
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+		// status with a working connection
+		want int
+	}{
+		{name: "health", handler: s.healthHandler, want: http.StatusOK},
+		{name: "ready", handler: s.readyHandler, want: http.StatusServiceUnavailable},
+		{name: "json", handler: func(w http.ResponseWriter, r *http.Request) { WriteJSON(w, testBans(2)) }, want: http.StatusOK},
+		{name: "error", handler: func(w http.ResponseWriter, r *http.Request) { WriteJSONError(w, ErrNotFound) }, want: http.StatusNotFound},
+		{name: "unencodable", handler: func(w http.ResponseWriter, r *http.Request) { WriteJSON(w, &unencodable{F: func() {}}) }, want: http.StatusInternalServerError},
+	}
+	writeErrs := []error{syscall.EPIPE, syscall.ECONNRESET, http.ErrHandlerTimeout, errors.New("short write")}
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != h.want {
+				t.Errorf("got status %d, want %d", rec.Code, h.want)
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("invalid JSON %q", rec.Body.String())
+			}
+			if h.want == http.StatusInternalServerError && !strings.Contains(rec.Body.String(), `"internal_server_error"`) {
+				t.Errorf("got %q, want the internal server error", rec.Body.String())
+			}
+
+			for _, writeErr := range writeErrs {
+				w := &brokenWriter{h: http.Header{}, err: writeErr}
+				func() {
+					defer func() {
+						if p := recover(); p != nil {
+							t.Errorf("panicked on %v: %v", writeErr, p)
+						}
+					}()
+					h.handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				}()
+				if w.status != h.want {
+					t.Errorf("got status %d on %v, want %d", w.status, writeErr, h.want)
+				}
+			}
+		})
+	}
+}
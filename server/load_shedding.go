@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// loadSheddingStats counts the requests shed and the windows the shed probability rose or fell in
+var loadSheddingStats = expvar.NewMap("load_shedding")
+
+// route classes of the load shedder, only the expensive routes are shed
+const (
+	routeClassCheap     = "cheap"
+	routeClassExpensive = "expensive"
+)
+
+// states of the load shedder, see loadShedder.evaluate
+const (
+	sheddingOff     = "off"
+	sheddingOK      = "ok"
+	sheddingRising  = "rising"
+	sheddingHolding = "holding"
+	sheddingFalling = "falling"
+)
+
+// LoadShedding sets when the requests of the routes registered with Expensive are shed
+// the error and slow rates of the requests served in a window, of every class, decide how the shed probability changes after it
+type LoadShedding struct {
+	Enabled bool
+	// length of the windows the rates are measured over, the probability changes once a window
+	Window time.Duration
+	// windows serving fewer requests do not breach the thresholds
+	MinRequests int
+	// fraction of 5xx responses and timeouts, and of requests slower than SlowRequest, above which the probability rises
+	ErrorRate   float64
+	SlowRequest time.Duration
+	SlowRate    float64
+	// change of the probability per window, and the probability it stops rising at
+	Step           float64
+	MaxProbability float64
+}
+
+// DefaultLoadShedding are the thresholds of a server without load shedding settings
+var DefaultLoadShedding = LoadShedding{
+	Window:         10 * time.Second,
+	MinRequests:    20,
+	ErrorRate:      0.1,
+	SlowRequest:    5 * time.Second,
+	SlowRate:       0.25,
+	Step:           0.1,
+	MaxProbability: 0.9,
+}
+
+// classWindow counts the requests of a route class in a window
+type classWindow struct {
+	requests, errors, slow, shed int64
+}
+
+// loadShedder rejects a growing share of the expensive requests while the served requests fail or are slow,
+// so that the cheap lookups keep working on a degraded database
+// the probability moves by one Step per window: up while a rate is over its threshold, down once both are under half
+// of it and held in between, so that it ramps smoothly rather than flapping around a threshold
+type loadShedder struct {
+	// route templates of the expensive class, written while the routes are registered
+	expensive map[string]bool
+	// returns a number in [0, 1), math/rand outside of the simulations
+	random func() float64
+
+	mu          sync.Mutex
+	config      LoadShedding
+	probability float64
+	state       string
+	start       time.Time
+	current     map[string]*classWindow
+	last        map[string]*classWindow
+	updated     time.Time
+}
+
+func newLoadShedder(config LoadShedding) *loadShedder {
+	ls := &loadShedder{expensive: make(map[string]bool), random: rand.Float64}
+	ls.set(config)
+	loadSheddingStats.Set("probability", expvar.Func(func() interface{} { return ls.status().Probability }))
+	return ls
+}
+
+// set replaces the settings, disabling load shedding stops shedding at once
+func (ls *loadShedder) set(config LoadShedding) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.config = config
+	if !config.Enabled {
+		ls.probability = 0
+		ls.state = sheddingOff
+		ls.start = time.Time{}
+		ls.current, ls.last = nil, nil
+		return
+	}
+	if ls.state == "" || ls.state == sheddingOff {
+		ls.state = sheddingOK
+	}
+	if ls.probability > config.MaxProbability {
+		ls.probability = config.MaxProbability
+	}
+}
+
+// class returns the route class of a route template
+func (ls *loadShedder) class(route string) string {
+	if ls.expensive[route] {
+		return routeClassExpensive
+	}
+	return routeClassCheap
+}
+
+// admit returns false if a request of class must be shed at now, the request is counted in the window either way
+func (ls *loadShedder) admit(class string, now time.Time) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if !ls.config.Enabled {
+		return true
+	}
+	ls.advance(now)
+	if class != routeClassExpensive || ls.probability == 0 || ls.random() >= ls.probability {
+		return true
+	}
+	ls.window(class).shed++
+	loadSheddingStats.Add("shed", 1)
+	return false
+}
+
+// observe counts a request served at now, failed is true for 5xx responses and timeouts
+func (ls *loadShedder) observe(class string, took time.Duration, failed bool, now time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if !ls.config.Enabled {
+		return
+	}
+	ls.advance(now)
+	cw := ls.window(class)
+	cw.requests++
+	if failed {
+		cw.errors++
+	}
+	if took >= ls.config.SlowRequest {
+		cw.slow++
+	}
+}
+
+// window returns the counts of class in the current window
+func (ls *loadShedder) window(class string) *classWindow {
+	cw, ok := ls.current[class]
+	if !ok {
+		cw = &classWindow{}
+		ls.current[class] = cw
+	}
+	return cw
+}
+
+// advance evaluates the windows that ended by now, windows without requests lower the probability
+func (ls *loadShedder) advance(now time.Time) {
+	if ls.start.IsZero() {
+		ls.start = now
+		ls.current = make(map[string]*classWindow)
+		return
+	}
+	for now.Sub(ls.start) >= ls.config.Window {
+		ls.evaluate()
+		ls.start = ls.start.Add(ls.config.Window)
+		ls.updated = ls.start
+		ls.last, ls.current = ls.current, make(map[string]*classWindow)
+	}
+}
+
+// evaluate moves the probability after the current window
+func (ls *loadShedder) evaluate() {
+	var requests, errors, slow int64
+	for _, cw := range ls.current {
+		requests += cw.requests
+		errors += cw.errors
+		slow += cw.slow
+	}
+	c := ls.config
+	var errorRate, slowRate float64
+	if requests > 0 {
+		errorRate = float64(errors) / float64(requests)
+		slowRate = float64(slow) / float64(requests)
+	}
+	switch {
+	case requests >= int64(c.MinRequests) && (errorRate > c.ErrorRate || slowRate > c.SlowRate):
+		ls.probability = math.Min(c.MaxProbability, roundProbability(ls.probability+c.Step))
+		ls.state = sheddingRising
+		loadSheddingStats.Add("windows_rising", 1)
+	case ls.probability == 0:
+		ls.state = sheddingOK
+	case errorRate <= c.ErrorRate/2 && slowRate <= c.SlowRate/2:
+		ls.probability = math.Max(0, roundProbability(ls.probability-c.Step))
+		ls.state = sheddingFalling
+		if ls.probability == 0 {
+			ls.state = sheddingOK
+		}
+		loadSheddingStats.Add("windows_falling", 1)
+	default:
+		ls.state = sheddingHolding
+	}
+}
+
+// roundProbability rounds p to a thousandth so that as many steps down as up bring it back to 0
+func roundProbability(p float64) float64 {
+	return math.Round(p*1000) / 1000
+}
+
+// status returns the state of the load shedder and the counts of the last finished window
+func (ls *loadShedder) status() *model.LoadShedding {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	status := &model.LoadShedding{
+		Enabled:     ls.config.Enabled,
+		State:       ls.state,
+		Probability: ls.probability,
+		Classes:     make(map[string]*model.LoadClassWindow, 2),
+		UpdatedAt:   model.NewTimestamp(ls.updated),
+	}
+	for _, class := range []string{routeClassCheap, routeClassExpensive} {
+		cw := &classWindow{}
+		if last, ok := ls.last[class]; ok {
+			cw = last
+		}
+		status.Classes[class] = &model.LoadClassWindow{Requests: cw.requests, Errors: cw.errors, Slow: cw.slow, Shed: cw.shed}
+	}
+	return status
+}
+
+// retryAfter returns the time until the probability may next change, the Retry-After of the shed requests
+func (ls *loadShedder) retryAfter() time.Duration {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.config.Window
+}
+
+// shedLoad is a router middleware shedding the expensive requests with ErrOverloaded and measuring the served ones
+// the admin API and the operational routes are neither shed nor counted, and the streamed downloads, which run as long as
+// the client reads, are never counted as slow
+func (s *Server) shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) || operationalRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := routeName(r)
+		class := s.shedder.class(route)
+		start := time.Now()
+		if !s.shedder.admit(class, start) {
+			WriteRetryError(w, ErrOverloaded, s.shedder.retryAfter())
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		took := time.Since(start)
+		if s.streaming[route] {
+			took = 0
+		}
+		// the timeout handler answers the requests that ran out of time after they return
+		failed := sw.status >= 500 || errors.Is(r.Context().Err(), context.DeadlineExceeded)
+		s.shedder.observe(class, took, failed, time.Now())
+	})
+}
+
+// Expensive puts a route in the class of routes shed first under load, such as searches and exports
+func Expensive() RouteOption {
+	return func(o *routeOptions) {
+		o.expensive = true
+	}
+}
+
+// SetLoadShedding replaces the load shedding settings of the running server
+func (s *Server) SetLoadShedding(config LoadShedding) {
+	s.shedder.set(config)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// traffic is the requests of a simulated window, spread evenly over it
+type traffic struct {
+	cheap, expensive int
+	// fractions of the served requests failing and slow
+	errorRate, slowRate float64
+}
+
+var (
+	healthy  = traffic{cheap: 80, expensive: 20}
+	degraded = traffic{cheap: 80, expensive: 20, errorRate: 0.5}
+	slow     = traffic{cheap: 80, expensive: 20, slowRate: 0.5}
+	// between half the threshold and the threshold, the probability holds
+	recovering = traffic{cheap: 80, expensive: 20, errorRate: 0.08}
+)
+
+// testShedder returns an enabled load shedder with the default thresholds and a seeded random source
+func testShedder() *loadShedder {
+	config := DefaultLoadShedding
+	config.Enabled = true
+	ls := newLoadShedder(config)
+	ls.random = rand.New(rand.NewSource(1)).Float64
+	return ls
+}
+
+// simulate runs windows of traffic through ls starting at start
+// returns the probability and state after every window and the expensive requests shed in each
+func simulate(t *testing.T, ls *loadShedder, start time.Time, windows []traffic) (probabilities []float64, states []string, shed []int) {
+	window := ls.config.Window
+	for i, tr := range windows {
+		total := tr.cheap + tr.expensive
+		windowStart := start.Add(time.Duration(i) * window)
+		errors, slows := int(tr.errorRate*float64(total)), int(tr.slowRate*float64(total))
+		n := 0
+		for j := 0; j < total; j++ {
+			now := windowStart.Add(time.Duration(j) * window / time.Duration(total+1))
+			// the expensive requests are interleaved with the cheap ones
+			class := routeClassCheap
+			if (j+1)*tr.expensive/total > j*tr.expensive/total {
+				class = routeClassExpensive
+			}
+			if !ls.admit(class, now) {
+				if class != routeClassExpensive {
+					t.Fatalf("window %d: cheap request shed", i)
+				}
+				n++
+				continue
+			}
+			took := time.Millisecond
+			if j < slows {
+				took = ls.config.SlowRequest
+			}
+			ls.observe(class, took, j < errors, now)
+		}
+		shed = append(shed, n)
+		// the next request evaluates the window
+		ls.advance(windowStart.Add(window))
+		status := ls.status()
+		probabilities = append(probabilities, status.Probability)
+		states = append(states, status.State)
+	}
+	return probabilities, states, shed
+}
+
+// repeat returns n windows of tr
+func repeat(tr traffic, n int) []traffic {
+	windows := make([]traffic, n)
+	for i := range windows {
+		windows[i] = tr
+	}
+	return windows
+}
+
+func TestLoadSheddingHealthy(t *testing.T) {
+	ls := testShedder()
+	probabilities, states, shed := simulate(t, ls, time.Unix(0, 0), repeat(healthy, 20))
+	for i := range probabilities {
+		if probabilities[i] != 0 || states[i] != sheddingOK || shed[i] != 0 {
+			t.Fatalf("window %d: probability %g, state %s, %d shed", i, probabilities[i], states[i], shed[i])
+		}
+	}
+}
+
+func TestLoadSheddingRampsUpAndDown(t *testing.T) {
+	for name, bad := range map[string]traffic{"errors": degraded, "slow": slow} {
+		t.Run(name, func(t *testing.T) {
+			ls := testShedder()
+			windows := append(repeat(bad, 12), repeat(recovering, 3)...)
+			windows = append(windows, repeat(healthy, 12)...)
+			probabilities, states, _ := simulate(t, ls, time.Unix(0, 0), windows)
+
+			// rising by a step a window up to the maximum
+			for i := 0; i < 12; i++ {
+				want := math.Min(float64(i+1)*DefaultLoadShedding.Step, DefaultLoadShedding.MaxProbability)
+				if math.Abs(probabilities[i]-want) > 1e-9 || states[i] != sheddingRising {
+					t.Fatalf("window %d: got %g %s, want %g rising", i, probabilities[i], states[i], want)
+				}
+			}
+			// held between half the threshold and the threshold rather than flapping
+			for i := 12; i < 15; i++ {
+				if probabilities[i] != DefaultLoadShedding.MaxProbability || states[i] != sheddingHolding {
+					t.Fatalf("window %d: got %g %s, want holding", i, probabilities[i], states[i])
+				}
+			}
+			// falling by a step a window back to 0, in as many steps as it took to rise
+			for i := 15; i < len(windows); i++ {
+				p := probabilities[i]
+				if p > probabilities[i-1] {
+					t.Fatalf("window %d: probability rose to %g while healthy", i, p)
+				}
+			}
+			steps := int(math.Round(DefaultLoadShedding.MaxProbability / DefaultLoadShedding.Step))
+			if p := probabilities[15+steps-1]; p != 0 || states[15+steps-1] != sheddingOK {
+				t.Errorf("got %g %s after %d healthy windows, want 0 ok", p, states[15+steps-1], steps)
+			}
+			if p := probabilities[15+steps-2]; p == 0 {
+				t.Errorf("fell to 0 in fewer than %d windows", steps)
+			}
+		})
+	}
+}
+
+func TestLoadSheddingShedShare(t *testing.T) {
+	ls := testShedder()
+	windows := append(repeat(degraded, 5), repeat(traffic{cheap: 500, expensive: 500, errorRate: 0.5}, 1)...)
+	probabilities, _, shed := simulate(t, ls, time.Unix(0, 0), windows)
+	// the last window sheds at the probability the previous windows rose to
+	p := probabilities[len(probabilities)-2]
+	got := float64(shed[len(shed)-1]) / 500
+	if math.Abs(got-p) > 0.1 {
+		t.Errorf("shed %g of the expensive requests at probability %g", got, p)
+	}
+	status := ls.status()
+	if status.Classes[routeClassCheap].Shed != 0 || status.Classes[routeClassExpensive].Shed == 0 {
+		t.Errorf("got shed cheap %d, expensive %d", status.Classes[routeClassCheap].Shed, status.Classes[routeClassExpensive].Shed)
+	}
+}
+
+func TestLoadSheddingMinRequests(t *testing.T) {
+	ls := testShedder()
+	// every request fails, but too few are served to tell
+	probabilities, _, _ := simulate(t, ls, time.Unix(0, 0), repeat(traffic{cheap: 10, expensive: 5, errorRate: 1}, 5))
+	for i, p := range probabilities {
+		if p != 0 {
+			t.Fatalf("window %d: probability %g under MinRequests", i, p)
+		}
+	}
+}
+
+func TestLoadSheddingIdleWindows(t *testing.T) {
+	ls := testShedder()
+	start := time.Unix(0, 0)
+	probabilities, _, _ := simulate(t, ls, start, repeat(degraded, 5))
+	if probabilities[4] != 0.5 {
+		t.Fatalf("got probability %g, want 0.5", probabilities[4])
+	}
+	// windows without requests lower the probability as healthy ones do
+	ls.admit(routeClassCheap, start.Add(8*DefaultLoadShedding.Window))
+	if p := ls.status().Probability; p != 0.2 {
+		t.Errorf("got probability %g after 3 idle windows, want 0.2", p)
+	}
+}
+
+func TestLoadSheddingSet(t *testing.T) {
+	ls := testShedder()
+	start := time.Unix(0, 0)
+	simulate(t, ls, start, repeat(degraded, 5))
+
+	lower := ls.config
+	lower.MaxProbability = 0.3
+	ls.set(lower)
+	if p := ls.status().Probability; p != 0.3 {
+		t.Errorf("got probability %g, want it capped at 0.3", p)
+	}
+
+	off := ls.config
+	off.Enabled = false
+	ls.set(off)
+	if status := ls.status(); status.Probability != 0 || status.State != sheddingOff {
+		t.Errorf("got %g %s after disabling", status.Probability, status.State)
+	}
+	for i := 0; i < 100; i++ {
+		if !ls.admit(routeClassExpensive, start.Add(time.Hour)) {
+			t.Fatal("shed while disabled")
+		}
+	}
+}
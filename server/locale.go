@@ -0,0 +1,222 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// defaultLocale is the locale of the errors as declared with newError, used when no other locale is acceptable
+const defaultLocale = "en"
+
+// localeFiles holds the message catalog, one locales/<tag>.json file per locale mapping error codes to their messages
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// errorMessage is the title and detail of an error in one locale
+type errorMessage struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+// errorCatalog holds the messages of the errors by lower case locale tag and then by code
+// a locale may leave out codes, those errors are written in the default locale
+type errorCatalog map[string]map[string]errorMessage
+
+// catalog is loaded once every error of the package was created
+var catalog errorCatalog
+
+func init() {
+	var err error
+	catalog, err = loadErrorCatalog(localeFiles, errorList)
+	if err != nil {
+		panic(fmt.Sprintf("error catalog: %s", err))
+	}
+}
+
+// loadErrorCatalog reads the catalog files of fsys and checks them against errs
+// every error must have its English entry, the same as declared so that the file translators start from is current,
+// and the other locales may only hold codes that exist
+func loadErrorCatalog(fsys fs.FS, errs []*model.JSONError) (errorCatalog, error) {
+	names, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+	c := make(errorCatalog, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		messages := make(map[string]errorMessage)
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		c[strings.ToLower(strings.TrimSuffix(path.Base(name), ".json"))] = messages
+	}
+	english, ok := c[defaultLocale]
+	if !ok {
+		return nil, fmt.Errorf("no %s locale", defaultLocale)
+	}
+	codes := make(map[string]bool, len(errs))
+	for _, e := range errs {
+		codes[e.ID] = true
+		m, ok := english[e.ID]
+		if !ok {
+			return nil, fmt.Errorf("%s: no %s entry", e.ID, defaultLocale)
+		}
+		if m.Title != e.Title || m.Detail != e.Detail {
+			return nil, fmt.Errorf("%s: the %s entry does not match the error", e.ID, defaultLocale)
+		}
+	}
+	for locale, messages := range c {
+		for code, m := range messages {
+			if !codes[code] {
+				return nil, fmt.Errorf("%s: unknown code %s", locale, code)
+			}
+			if m.Title == "" || m.Detail == "" {
+				return nil, fmt.Errorf("%s: %s has no title or detail", locale, code)
+			}
+		}
+	}
+	return c, nil
+}
+
+// negotiateLocale returns the catalog locale best matching an Accept-Language header, RFC 9110 section 12.5.4
+// ranges are tried in decreasing q-value order, a range matches a locale of the same tag or, when it has subtags, one
+// of its primary language, ex: fr-CA matches fr; * and ranges of no catalog locale leave the default locale
+func (c errorCatalog) negotiateLocale(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				var err error
+				q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || q < 0 || q > 1 {
+					// a malformed weight makes the range unacceptable rather than preferred
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{tag, q})
+		}
+	}
+	// ranges of the same weight keep the client's order
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, r := range ranges {
+		if r.tag == "*" {
+			return defaultLocale
+		}
+		if _, ok := c[r.tag]; ok {
+			return r.tag
+		}
+		if primary, _, ok := strings.Cut(r.tag, "-"); ok {
+			if _, ok := c[primary]; ok {
+				return primary
+			}
+		}
+	}
+	return defaultLocale
+}
+
+// localize returns jsonErr in locale, the same error when the catalog has no entry for it
+// code, status and meta never change, a detail set for the response such as that of FieldError is kept
+func (c errorCatalog) localize(jsonErr *model.JSONError, locale string) *model.JSONError {
+	if locale == defaultLocale {
+		return jsonErr
+	}
+	m, ok := c[locale][jsonErr.ID]
+	if !ok {
+		return jsonErr
+	}
+	english := c[defaultLocale][jsonErr.ID]
+	localized := *jsonErr
+	localized.Title = m.Title
+	if jsonErr.Detail == english.Detail {
+		localized.Detail = m.Detail
+	}
+	return &localized
+}
+
+// localeWriter carries the negotiated locale of a response to WriteJSONError
+type localeWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+// Unwrap returns the wrapped writer
+func (lw *localeWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// Flush sends any buffered data to the client
+func (lw *localeWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// negotiateLocales is a middleware wrapping the response of requests with an Accept-Language header in a localeWriter
+// it runs before the limiters and again inside the router, since http.TimeoutHandler's writer hides the outer one
+func negotiateLocales(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Accept-Language")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&localeWriter{ResponseWriter: w, locale: catalog.negotiateLocale(header)}, r)
+	})
+}
+
+// responseLocale returns the locale of the response written to w, the default locale without a localeWriter
+func responseLocale(w http.ResponseWriter) (string, bool) {
+	for {
+		if lw, ok := w.(*localeWriter); ok {
+			return lw.locale, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return defaultLocale, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// localizeErrors returns jsonErrs in the locale of the response and sets its Content-Language
+// the headers are only set for requests that sent Accept-Language, the others are answered as before
+func localizeErrors(w http.ResponseWriter, jsonErrs []*model.JSONError) []*model.JSONError {
+	locale, negotiated := responseLocale(w)
+	if !negotiated {
+		return jsonErrs
+	}
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
+	localized := make([]*model.JSONError, len(jsonErrs))
+	for i, jsonErr := range jsonErrs {
+		localized[i] = catalog.localize(jsonErr, locale)
+	}
+	return localized
+}
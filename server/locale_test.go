@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"dnscoffee/model"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	c := errorCatalog{"en": {}, "fr": {}, "pt-br": {}}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "fr", want: "fr"},
+		{header: "FR", want: "fr"},
+		{header: "fr-CA", want: "fr"},
+		{header: "pt-BR", want: "pt-br"},
+		{header: "de", want: "en"},
+		{header: "de, fr;q=0.5", want: "fr"},
+		{header: "en;q=0.4, fr;q=0.8", want: "fr"},
+		// ranges of the same weight keep the client's order
+		{header: "fr, en", want: "fr"},
+		{header: "en, fr", want: "en"},
+		{header: "*", want: "en"},
+		{header: "*;q=0.9, fr", want: "fr"},
+		{header: "fr;q=0", want: "en"},
+		{header: "fr;q=2", want: "en"},
+		{header: "fr;q=high, de", want: "en"},
+		{header: " , ;q=1", want: "en"},
+	}
+	for _, tt := range tests {
+		if got := c.negotiateLocale(tt.header); got != tt.want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrorCatalog(t *testing.T) {
+	errs := []*model.JSONError{
+		{ID: "bad_request", Title: "Bad request", Detail: "Not JSON."},
+		{ID: "gone", Title: "Gone", Detail: "Gone for good."},
+	}
+	const english = `{"bad_request": {"title": "Bad request", "detail": "Not JSON."}, "gone": {"title": "Gone", "detail": "Gone for good."}}`
+	catalogFS := func(locales map[string]string) fstest.MapFS {
+		fsys := fstest.MapFS{}
+		for name, data := range locales {
+			fsys["locales/"+name+".json"] = &fstest.MapFile{Data: []byte(data)}
+		}
+		return fsys
+	}
+	tests := []struct {
+		name    string
+		locales map[string]string
+		wantErr string
+	}{
+		{name: "english only", locales: map[string]string{"en": english}},
+		{name: "partial locale", locales: map[string]string{"en": english, "FR": `{"gone": {"title": "Disparu", "detail": "Disparu pour de bon."}}`}},
+		{name: "no english", locales: map[string]string{"fr": `{}`}, wantErr: "no en locale"},
+		{name: "missing english entry", locales: map[string]string{"en": `{"bad_request": {"title": "Bad request", "detail": "Not JSON."}}`}, wantErr: "gone: no en entry"},
+		{name: "stale english entry", locales: map[string]string{"en": strings.Replace(english, "Gone for good.", "Gone.", 1)}, wantErr: "gone: the en entry does not match the error"},
+		{name: "unknown code", locales: map[string]string{"en": english, "fr": `{"teapot": {"title": "Théière", "detail": "Je suis une théière."}}`}, wantErr: "fr: unknown code teapot"},
+		{name: "no detail", locales: map[string]string{"en": english, "fr": `{"gone": {"title": "Disparu"}}`}, wantErr: "fr: gone has no title or detail"},
+		{name: "malformed", locales: map[string]string{"en": english, "fr": `{"gone": `}, wantErr: "locales/fr.json: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := loadErrorCatalog(catalogFS(tt.locales), errs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c) != len(tt.locales) {
+				t.Errorf("got locales %v, want %d", c, len(tt.locales))
+			}
+		})
+	}
+}
+
+// TestEmbeddedErrorCatalog checks that every locale shipped has every error
+func TestEmbeddedErrorCatalog(t *testing.T) {
+	for locale, messages := range catalog {
+		for _, e := range errorList {
+			if _, ok := messages[e.ID]; !ok {
+				t.Errorf("%s: no entry for %s", locale, e.ID)
+			}
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	fieldErr := NewFieldError("limit", "must be a number")
+	tests := []struct {
+		name     string
+		header   string
+		errs     []*model.JSONError
+		wantLang string
+		// title and detail of each error written
+		want []errorMessage
+	}{
+		{name: "no header", errs: []*model.JSONError{ErrResourceNotFound}, want: []errorMessage{{"Not found", "Resource not found."}}},
+		{name: "french", header: "fr-FR, en;q=0.5", errs: []*model.JSONError{ErrResourceNotFound}, wantLang: "fr", want: []errorMessage{{"Introuvable", "Ressource introuvable."}}},
+		{name: "unavailable locale", header: "de", errs: []*model.JSONError{ErrResourceNotFound}, wantLang: "en", want: []errorMessage{{"Not found", "Resource not found."}}},
+		// the detail of a field error is written for the response and stays as it is
+		{name: "field error", header: "fr", errs: []*model.JSONError{fieldErr}, wantLang: "fr", want: []errorMessage{{"Requête incorrecte", fieldErr.Detail}}},
+		{name: "several errors", header: "fr", errs: []*model.JSONError{ErrInvalidParameter, fieldErr}, wantLang: "fr",
+			want: []errorMessage{{"Requête incorrecte", "Un paramètre de la requête n'est pas valide."}, {"Requête incorrecte", fieldErr.Detail}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := negotiateLocales(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(tt.errs) == 1 {
+					WriteJSONError(w, tt.errs[0])
+				} else {
+					WriteJSONErrors(w, tt.errs)
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+			if tt.header != "" {
+				r.Header.Set("Accept-Language", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.errs[0].Status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.errs[0].Status)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantLang {
+				t.Errorf("got Content-Language %q, want %q", got, tt.wantLang)
+			}
+			if got, want := w.Header().Get("Vary") == "Accept-Language", tt.header != ""; got != want {
+				t.Errorf("got Vary %q", w.Header().Get("Vary"))
+			}
+			var body model.JSONErrors
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Errors) != len(tt.want) {
+				t.Fatalf("got %d errors, want %d", len(body.Errors), len(tt.want))
+			}
+			for i, got := range body.Errors {
+				if got.Title != tt.want[i].Title || got.Detail != tt.want[i].Detail {
+					t.Errorf("error %d: got %q %q, want %q %q", i, got.Title, got.Detail, tt.want[i].Title, tt.want[i].Detail)
+				}
+				// code, status and meta are the same in every locale
+				if e := tt.errs[i]; got.ID != e.ID || got.Status != e.Status || len(got.Meta) != len(e.Meta) {
+					t.Errorf("error %d: got %+v, want the code, status and meta of %+v", i, got, e)
+				}
+			}
+		})
+	}
+	// the shared errors are never changed by a translation
+	if ErrResourceNotFound.Title != "Not found" || ErrInvalidParameter.Detail != "A request parameter is not valid." {
+		t.Errorf("shared errors were changed: %+v %+v", ErrResourceNotFound, ErrInvalidParameter)
+	}
+}
+
+// TestLocaleBehindWrappers finds the locale of a response through the writers wrapping it
+func TestLocaleBehindWrappers(t *testing.T) {
+	h := negotiateLocales(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSONError(&statusWriter{ResponseWriter: w, status: http.StatusOK}, ErrResourceNotFound)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com", nil)
+	r.Header.Set("Accept-Language", "fr")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Language") != "fr" || !strings.Contains(w.Body.String(), "Ressource introuvable.") {
+		t.Errorf("got status %d, Content-Language %q %s", w.Code, w.Header().Get("Content-Language"), w.Body)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+)
+
+// defaultMaintenanceRetry is sent as Retry-After when maintenance has no ETA
+const defaultMaintenanceRetry = 5 * time.Minute
+
+// errMaintenance is reported by the readiness check while maintenance mode is enabled
+var errMaintenance = errors.New("maintenance mode enabled")
+
+// operationalRoutes keep working during maintenance and overload
+// so that orchestration does not restart the process and metrics keep flowing
+var operationalRoutes = map[string]bool{
+	"/health":     true,
+	"/ready":      true,
+	"/debug/vars": true,
+}
+
+// maintenance is the maintenance mode state, safe for concurrent use
+type maintenance struct {
+	mu    sync.RWMutex
+	state model.Maintenance
+}
+
+// get returns a copy of the current state
+func (m *maintenance) get() model.Maintenance {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// set enables or disables maintenance mode
+func (m *maintenance) set(enabled bool, message string, eta *time.Time) model.Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !enabled {
+		m.state = model.Maintenance{}
+		return m.state
+	}
+	since := m.state.Since
+	if since.IsZero() {
+		since = model.Now()
+	}
+	m.state = model.Maintenance{Enabled: true, Message: message, Since: since}
+	if eta != nil {
+		m.state.ETA = model.NewTimestamp(*eta)
+	}
+	return m.state
+}
+
+// check is the readiness check for maintenance mode
+func (m *maintenance) check() error {
+	if m.get().Enabled {
+		return errMaintenance
+	}
+	return nil
+}
+
+// handler answers all requests with ErrMaintenance while maintenance mode is enabled
+func (m *maintenance) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := m.get()
+		if !state.Enabled || operationalRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retry := defaultMaintenanceRetry
+		if !state.ETA.IsZero() {
+			retry = time.Until(state.ETA.Time)
+		}
+		jsonErr := ErrMaintenance
+		if state.Message != "" {
+			copied := *ErrMaintenance
+			copied.Detail = state.Message
+			jsonErr = &copied
+		}
+		WriteRetryError(w, jsonErr, retry)
+	})
+}
+
+// adminMaintenanceHandler toggles maintenance mode
+// the body is {"enabled": true, "message": "...", "eta": "2006-01-02T15:04:05Z"}, enabled defaults to true
+func (s *Server) adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool      `json:"enabled"`
+		Message string     `json:"message"`
+		ETA     *time.Time `json:"eta"`
+	}
+	// an empty body enables maintenance mode without a message
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		WriteJSONError(w, ErrRequestTooLarge)
+		return
+	}
+	if err != nil && err != io.EOF {
+		WriteJSONError(w, ErrBadRequest)
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	state := s.maintenance.set(enabled, req.Message, req.ETA)
+	if enabled {
+		logging.Infof("admin: maintenance mode enabled by %s: %q", getIPAddress(r), req.Message)
+	} else {
+		logging.Infof("admin: maintenance mode disabled by %s", getIPAddress(r))
+	}
+	WriteJSON(w, &state)
+}
+
+// adminStatusHandler reports the readiness checks, maintenance and load shedding state
+func (s *Server) adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	state := s.maintenance.get()
+	data := &model.AdminStatus{Maintenance: &state, LoadShedding: s.shedder.status(), ReferenceData: s.referenceDataStatus()}
+	data.Ready, data.Checks = s.runReadinessChecks()
+	WriteJSON(w, data)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// maintenanceResponse is the state returned by the maintenance admin route
+type maintenanceResponse struct {
+	Data struct {
+		Enabled bool    `json:"enabled"`
+		Message string  `json:"message"`
+		ETA     *string `json:"eta"`
+		Since   *string `json:"since"`
+	}
+}
+
+func TestAdminMaintenanceHandler(t *testing.T) {
+	s := &Server{maintenance: &maintenance{}}
+	api := s.maintenance.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	post := func(body string) (*httptest.ResponseRecorder, maintenanceResponse) {
+		w := httptest.NewRecorder()
+		s.adminMaintenanceHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/maintenance", strings.NewReader(body)))
+		var resp maintenanceResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, resp
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// the ETA is returned in UTC to the second
+	eta := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	w, resp := post(`{"message": "upgrading", "eta": "` + eta.In(time.FixedZone("", 2*60*60)).Format(time.RFC3339Nano) + `"}`)
+	if w.Code != http.StatusOK || !resp.Data.Enabled || resp.Data.Message != "upgrading" {
+		t.Fatalf("enable: got status %d %s", w.Code, w.Body)
+	}
+	if resp.Data.ETA == nil || *resp.Data.ETA != eta.UTC().Format(time.RFC3339) {
+		t.Errorf("got eta %v, want %s", resp.Data.ETA, eta.UTC().Format(time.RFC3339))
+	}
+	if resp.Data.Since == nil || !strings.HasSuffix(*resp.Data.Since, "Z") {
+		t.Fatalf("got since %v, want a UTC time", resp.Data.Since)
+	}
+	since := *resp.Data.Since
+
+	w = serve("/api/zones")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"detail":"upgrading"`) {
+		t.Errorf("during maintenance: got status %d %s, want a 503 with the message", w.Code, w.Body)
+	}
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 590 || retry > 600 {
+		t.Errorf("got Retry-After %q, want the 600s until the ETA", w.Header().Get("Retry-After"))
+	}
+	if w := serve("/health"); w.Code != http.StatusOK {
+		t.Errorf("health check during maintenance: got status %d", w.Code)
+	}
+
+	// enabling again keeps since, without an ETA the retry is the default
+	time.Sleep(time.Second)
+	w, resp = post(`{}`)
+	if w.Code != http.StatusOK || resp.Data.Since == nil || *resp.Data.Since != since || resp.Data.ETA != nil {
+		t.Errorf("enabled again: got %s, want since %s and no eta", w.Body, since)
+	}
+	if w := serve("/api/zones"); w.Header().Get("Retry-After") != strconv.Itoa(int(defaultMaintenanceRetry.Seconds())) || !strings.Contains(w.Body.String(), ErrMaintenance.Detail) {
+		t.Errorf("without an ETA: got Retry-After %q %s", w.Header().Get("Retry-After"), w.Body)
+	}
+
+	w, resp = post(`{"enabled": false}`)
+	if w.Code != http.StatusOK || resp.Data.Enabled || resp.Data.Since != nil || resp.Data.ETA != nil {
+		t.Errorf("disable: got %s, want null times", w.Body)
+	}
+	if w := serve("/api/zones"); w.Code != http.StatusOK {
+		t.Errorf("after maintenance: got status %d", w.Code)
+	}
+}
+
+func TestAdminMaintenanceHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+		code string
+	}{
+		{name: "not json", body: "enable", want: http.StatusBadRequest, code: "bad_request"},
+		{name: "eta without offset", body: `{"eta": "2023-07-04T15:04:05"}`, want: http.StatusBadRequest, code: "bad_request"},
+		{name: "eta a date", body: `{"eta": "2023-07-04"}`, want: http.StatusBadRequest, code: "bad_request"},
+		{name: "too large", body: `{"message": "` + strings.Repeat("a", maxAdminBody) + `"}`, want: http.StatusRequestEntityTooLarge, code: "request_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{maintenance: &maintenance{}}
+			w := httptest.NewRecorder()
+			s.adminMaintenanceHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/maintenance", strings.NewReader(tt.body)))
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got status %d %s, want %d %s", w.Code, w.Body, tt.want, tt.code)
+			}
+			if s.maintenance.get().Enabled {
+				t.Error("maintenance enabled by an invalid request")
+			}
+		})
+	}
+}
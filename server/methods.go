@@ -0,0 +1,69 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// rejectedMethods counts the requests rejected for their method, by method
+// the methods the server never supports are all counted as other_method so scanners can not add labels
+var rejectedMethods = expvar.NewMap("http_methods_rejected")
+
+// otherMethod is the label of the rejected requests with a method the server never supports
+const otherMethod = "other_method"
+
+// supportedMethods are the methods some route may be registered for, others such as TRACE, CONNECT and PROPFIND get a 501
+var supportedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// methodSupported returns true if method is one of supportedMethods
+func methodSupported(method string) bool {
+	for _, m := range supportedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectUnsupportedMethods answers requests with a method no route is registered for with ErrMethodNotSupported
+// before routing, so they are logged and carry the standard headers like every other request
+func rejectUnsupportedMethods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !methodSupported(r.Method) {
+			rejectedMethods.Add(otherMethod, 1)
+			WriteJSONError(w, ErrMethodNotSupported)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowed is the router's handler for a supported method on a route registered without it
+// the Allow header lists the methods the route accepts
+func (s *Server) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	rejectedMethods.Add(r.Method, 1)
+	var allowed []string
+	for _, method := range supportedMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if s.router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+	WriteJSONError(w, ErrMethodNotAllowed)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	s, err := New([]string{"127.0.0.1:0"}, testAPIConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	s.Get("/api/test/methods", ok)
+	s.Post("/api/test/methods", ok)
+	s.Get("/api/test/methods/get", ok)
+	public, _ := s.handlers()
+
+	tests := []struct {
+		method, path string
+		want         int
+		code         string
+		allow        string
+		// label of http_methods_rejected counted
+		counted string
+	}{
+		{method: http.MethodGet, path: "/api/test/methods", want: http.StatusNoContent},
+		{method: http.MethodPost, path: "/api/test/methods", want: http.StatusNoContent},
+		{method: http.MethodDelete, path: "/api/test/methods", want: http.StatusMethodNotAllowed, code: "method_not_allowed", allow: "GET, HEAD, POST", counted: http.MethodDelete},
+		{method: http.MethodPost, path: "/api/test/methods/get", want: http.StatusMethodNotAllowed, code: "method_not_allowed", allow: "GET, HEAD", counted: http.MethodPost},
+		{method: http.MethodTrace, path: "/api/test/methods", want: http.StatusNotImplemented, code: "method_not_supported", counted: otherMethod},
+		{method: http.MethodConnect, path: "/api/test/methods", want: http.StatusNotImplemented, code: "method_not_supported", counted: otherMethod},
+		{method: "PROPFIND", path: "/api/test/missing", want: http.StatusNotImplemented, code: "method_not_supported", counted: otherMethod},
+		// the path is not looked at for a supported method either
+		{method: http.MethodDelete, path: "/api/test/missing", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			counted := expvarInt(rejectedMethods, tt.counted)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			public.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("got %s, want the error %s", w.Body, tt.code)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("got Allow %q, want %q", got, tt.allow)
+			}
+			if tt.counted != "" {
+				if got := expvarInt(rejectedMethods, tt.counted) - counted; got != 1 {
+					t.Errorf("counted %d rejections as %s, want 1", got, tt.counted)
+				}
+			}
+		})
+	}
+}
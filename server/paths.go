@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// namePrefixes are the routes whose path parameters are case-insensitive names, IPs or zones
+// matching is case-sensitive, so their paths are lower cased before routing
+var namePrefixes = []string{
+	"/api/domains/",
+	"/api/nameservers/",
+	"/api/zones/",
+	"/api/ip/",
+	"/api/label/",
+	"/api/counts/zone/",
+	"/api/research/ipnszonecount/",
+	"/domains/",
+	"/nameservers/",
+	"/zones/",
+	"/ip/",
+	"/research/ipnszonecount/",
+}
+
+// canonicalPaths redirects paths with a trailing slash, repeated slashes or dot segments to their clean form
+// with a 308 so that the method and body are kept, and lower cases the paths of name-bearing routes
+// so that /api/domains/Example.COM/ and /api/v1/domains/Example.COM/ end at the same handler as /api/domains/example.com instead of a 404
+func canonicalPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p == "/" || strings.HasPrefix(p, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clean := path.Clean(p)
+		if clean != p {
+			u := *r.URL
+			u.Path = clean
+			u.RawPath = ""
+			w.Header().Set("Location", u.RequestURI())
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		lower := strings.ToLower(p)
+		// versioned API paths take their names at the same place as the unversioned ones
+		unversioned := unversionedPath(lower)
+		for _, prefix := range namePrefixes {
+			if strings.HasPrefix(unversioned, prefix) {
+				if lower != p {
+					// the raw path may hold escapes of the upper case form
+					r.URL.Path = lower
+					r.URL.RawPath = ""
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCanonicalPaths(t *testing.T) {
+	router := mux.NewRouter()
+	domain := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mux.Vars(r)["domain"]))
+	}
+	router.HandleFunc("/api/domains/{domain}", domain)
+	router.HandleFunc("/api/v1/domains/{domain}", domain)
+	router.HandleFunc("/api/stats/imports", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/api/watchlists", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost)
+	h := canonicalPaths(router)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		want     int
+		location string
+		// the domain the handler got
+		body string
+	}{
+		{name: "clean", target: "/api/domains/example.com", want: http.StatusOK, body: "example.com"},
+		{name: "mixed case name", target: "/api/domains/Example.COM", want: http.StatusOK, body: "example.com"},
+		{name: "mixed case versioned name", target: "/api/v1/domains/Example.COM", want: http.StatusOK, body: "example.com"},
+		{name: "escaped upper case", target: "/api/domains/%45xample.com", want: http.StatusOK, body: "example.com"},
+		{name: "trailing slash", target: "/api/domains/example.com/?cursor=AbC", want: http.StatusPermanentRedirect, location: "/api/domains/example.com?cursor=AbC"},
+		{name: "repeated slashes", target: "/api//stats///imports", want: http.StatusPermanentRedirect, location: "/api/stats/imports"},
+		{name: "dot segments", target: "/api/stats/./x/../imports", want: http.StatusPermanentRedirect, location: "/api/stats/imports"},
+		{name: "post redirected", method: http.MethodPost, target: "/api/watchlists/", want: http.StatusPermanentRedirect, location: "/api/watchlists"},
+		{name: "case of other routes kept", target: "/api/Stats/imports", want: http.StatusNotFound},
+		{name: "root", target: "/", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(method, tt.target, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("got Location %q, want %q", got, tt.location)
+			}
+			if tt.want == http.StatusPermanentRedirect && w.Body.Len() != 0 {
+				t.Errorf("redirect has the body %q", w.Body)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("handler got %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+)
+
+// rateLimitStoreStats exports the occupancy and evictions of every rate limiter store by name
+var rateLimitStoreStats = expvar.NewMap("ratelimit_store")
+
+// storeEvictionWarnPerMinute is how many buckets of clients still limited may be evicted in a minute before it is logged
+const storeEvictionWarnPerMinute = 10
+
+// DefaultRateLimitStoreShards is the number of shards of a rate limiter store without APIConfig.RateLimitStoreShards
+const DefaultRateLimitStoreShards = 16
+
+// rateLimitStore is an in-memory throttled.GCRAStore keeping at most size buckets, evicting the least recently used first
+// the buckets are split between shards by a hash of their key, each with its own lock and an equal part of the size,
+// so that concurrent clients rarely wait on each other; a key always lands in the same shard and is limited the same,
+// only the eviction order is per shard rather than across the store
+// an evicted bucket whose theoretical arrival time is still ahead gives its client a fresh quota,
+// these early evictions are counted apart and logged when they exceed storeEvictionWarnPerMinute
+type rateLimitStore struct {
+	name string
+	// 0 is unlimited
+	size   int
+	shards []*rateLimitShard
+
+	evictions      expvar.Int
+	earlyEvictions expvar.Int
+
+	// early evictions in the minute starting at windowStart
+	windowMu    sync.Mutex
+	windowStart time.Time
+	windowEarly int
+}
+
+// rateLimitShard holds the buckets of the keys hashed to it
+type rateLimitShard struct {
+	st *rateLimitStore
+	// 0 is unlimited
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+// rateLimitBucket is the GCRA theoretical arrival time of a key, in nanoseconds
+type rateLimitBucket struct {
+	key   string
+	value int64
+}
+
+// newRateLimitStore returns a store of at most size buckets in shards shards, exported under name
+// DefaultRateLimitStoreShards are used when shards is 0, a store smaller than the shard count has a shard per bucket
+func newRateLimitStore(name string, size, shards int) *rateLimitStore {
+	n := shards
+	if n == 0 {
+		n = DefaultRateLimitStoreShards
+	}
+	if n < 1 {
+		n = 1
+	}
+	if size > 0 && size < n {
+		n = size
+	}
+	st := &rateLimitStore{name: name, size: size, shards: make([]*rateLimitShard, n)}
+	for i := range st.shards {
+		shardSize := 0
+		if size > 0 {
+			// the first shards take the remainder so that the sizes add up to size
+			shardSize = size / n
+			if i < size%n {
+				shardSize++
+			}
+		}
+		st.shards[i] = &rateLimitShard{st: st, size: shardSize, entries: make(map[string]*list.Element), order: list.New()}
+	}
+	rateLimitStoreStats.Set(name+"_keys", expvar.Func(func() interface{} { return st.len() }))
+	rateLimitStoreStats.Set(name+"_size", expvar.Func(func() interface{} { return st.size }))
+	rateLimitStoreStats.Set(name+"_evictions", &st.evictions)
+	rateLimitStoreStats.Set(name+"_early_evictions", &st.earlyEvictions)
+	rateLimitStoreStats.Set(name+"_shard_keys", expvar.Func(func() interface{} { return st.shardLens() }))
+	return st
+}
+
+// shard returns the shard of key, by its hash
+func (st *rateLimitStore) shard(key string) *rateLimitShard {
+	if len(st.shards) == 1 {
+		return st.shards[0]
+	}
+	return st.shards[hashKey(key)%uint32(len(st.shards))]
+}
+
+// hashKey returns the 32-bit FNV-1a hash of key, without the allocations of hash/fnv
+func hashKey(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// len returns the number of buckets in the store
+func (st *rateLimitStore) len() int {
+	n := 0
+	for _, l := range st.shardLens() {
+		n += l
+	}
+	return n
+}
+
+// shardLens returns the number of buckets in every shard, an uneven spread shows in it
+func (st *rateLimitStore) shardLens() []int {
+	lens := make([]int, len(st.shards))
+	for i, sh := range st.shards {
+		sh.mu.Lock()
+		lens[i] = sh.order.Len()
+		sh.mu.Unlock()
+	}
+	return lens
+}
+
+// GetWithTime implements throttled.GCRAStore
+func (st *rateLimitStore) GetWithTime(key string) (int64, time.Time, error) {
+	now := time.Now()
+	sh := st.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	elem, ok := sh.entries[key]
+	if !ok {
+		return -1, now, nil
+	}
+	sh.order.MoveToFront(elem)
+	return elem.Value.(*rateLimitBucket).value, now, nil
+}
+
+// SetIfNotExistsWithTTL implements throttled.GCRAStore, buckets are evicted by size and the ttl is ignored
+func (st *rateLimitStore) SetIfNotExistsWithTTL(key string, value int64, _ time.Duration) (bool, error) {
+	sh := st.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.entries[key]; ok {
+		return false, nil
+	}
+	sh.entries[key] = sh.order.PushFront(&rateLimitBucket{key: key, value: value})
+	if sh.size > 0 {
+		for sh.order.Len() > sh.size {
+			sh.evictOldest()
+		}
+	}
+	return true, nil
+}
+
+// CompareAndSwapWithTTL implements throttled.GCRAStore, the ttl is ignored
+func (st *rateLimitStore) CompareAndSwapWithTTL(key string, old, new int64, _ time.Duration) (bool, error) {
+	sh := st.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	elem, ok := sh.entries[key]
+	if !ok {
+		return false, nil
+	}
+	bucket := elem.Value.(*rateLimitBucket)
+	if bucket.value != old {
+		return false, nil
+	}
+	bucket.value = new
+	sh.order.MoveToFront(elem)
+	return true, nil
+}
+
+// evictOldest removes the least recently used bucket of the shard, sh.mu must be held
+func (sh *rateLimitShard) evictOldest() {
+	oldest := sh.order.Back()
+	sh.order.Remove(oldest)
+	bucket := oldest.Value.(*rateLimitBucket)
+	delete(sh.entries, bucket.key)
+	sh.st.evictions.Add(1)
+
+	now := time.Now()
+	if bucket.value <= now.UnixNano() {
+		// the bucket was full again, a new one is the same
+		return
+	}
+	sh.st.earlyEviction(now)
+}
+
+// earlyEviction counts the eviction of a bucket still limiting its client, the early evictions of every shard add up
+func (st *rateLimitStore) earlyEviction(now time.Time) {
+	st.earlyEvictions.Add(1)
+	st.windowMu.Lock()
+	defer st.windowMu.Unlock()
+	if now.Sub(st.windowStart) >= time.Minute {
+		st.windowStart = now
+		st.windowEarly = 0
+	}
+	st.windowEarly++
+	if st.windowEarly == storeEvictionWarnPerMinute {
+		logging.Warnf("rate limiter %s: %d buckets of limited clients evicted within a minute, %d keys are too few for the clients, raise API.Requests_Max_History",
+			st.name, st.windowEarly, st.size)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/throttled/throttled.v2"
+)
+
+func TestRateLimitStoreShardSizes(t *testing.T) {
+	tests := []struct {
+		shards, size int
+		wantShards   int
+	}{
+		{shards: 16, size: 1000, wantShards: 16},
+		{shards: 16, size: 10, wantShards: 10},
+		{shards: 16, size: 0, wantShards: 16},
+		{shards: 0, size: 100, wantShards: DefaultRateLimitStoreShards},
+		{shards: 1, size: 100, wantShards: 1},
+		{shards: 3, size: 100, wantShards: 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d shards of %d", tt.shards, tt.size), func(t *testing.T) {
+			st := newRateLimitStore("test_sizes", tt.size, tt.shards)
+			if len(st.shards) != tt.wantShards {
+				t.Fatalf("got %d shards, want %d", len(st.shards), tt.wantShards)
+			}
+			total := 0
+			for _, sh := range st.shards {
+				total += sh.size
+			}
+			if total != tt.size {
+				t.Errorf("shard sizes add up to %d, want %d", total, tt.size)
+			}
+		})
+	}
+}
+
+// TestThrottleShards checks that every limiter keeps the shard count it was made with
+func TestThrottleShards(t *testing.T) {
+	route := func(*http.Request) string { return "/test/shards" }
+	a := makeThrottleHandler("test_shards_a", 1, 1, 100, 4, RateLimitEnforce, nil, route)
+	b := makeThrottleHandler("test_shards_b", 1, 1, 100, 8, RateLimitEnforce, nil, route)
+	if n := len(a.store.(*rateLimitStore).shards); n != 4 {
+		t.Errorf("first limiter has %d shards, want 4", n)
+	}
+	if n := len(b.store.(*rateLimitStore).shards); n != 8 {
+		t.Errorf("second limiter has %d shards, want 8", n)
+	}
+}
+
+func TestRateLimitStoreOperations(t *testing.T) {
+	st := newRateLimitStore("test_operations", 100, 0)
+	if v, _, err := st.GetWithTime("a"); v != -1 || err != nil {
+		t.Fatalf("got %d, %v for a missing key", v, err)
+	}
+	if ok, _ := st.SetIfNotExistsWithTTL("a", 5, time.Minute); !ok {
+		t.Fatal("new key not set")
+	}
+	if ok, _ := st.SetIfNotExistsWithTTL("a", 6, time.Minute); ok {
+		t.Error("existing key overwritten")
+	}
+	if ok, _ := st.CompareAndSwapWithTTL("a", 6, 7, time.Minute); ok {
+		t.Error("swapped a value that was not there")
+	}
+	if ok, _ := st.CompareAndSwapWithTTL("b", -1, 7, time.Minute); ok {
+		t.Error("swapped a missing key")
+	}
+	if ok, _ := st.CompareAndSwapWithTTL("a", 5, 7, time.Minute); !ok {
+		t.Error("swap failed")
+	}
+	if v, _, _ := st.GetWithTime("a"); v != 7 {
+		t.Errorf("got %d, want 7", v)
+	}
+}
+
+func TestRateLimitStoreEviction(t *testing.T) {
+	st := newRateLimitStore("test_eviction", 3, 1)
+	future := time.Now().Add(time.Hour).UnixNano()
+	st.SetIfNotExistsWithTTL("a", 0, 0)
+	st.SetIfNotExistsWithTTL("b", future, 0)
+	st.SetIfNotExistsWithTTL("c", 0, 0)
+	// a is used again, b is now the least recently used
+	st.GetWithTime("a")
+	st.SetIfNotExistsWithTTL("d", 0, 0)
+	if v, _, _ := st.GetWithTime("b"); v != -1 {
+		t.Error("least recently used key kept")
+	}
+	if v, _, _ := st.GetWithTime("a"); v != 0 {
+		t.Error("recently used key evicted")
+	}
+	if st.len() != 3 || st.evictions.Value() != 1 || st.earlyEvictions.Value() != 1 {
+		t.Errorf("got %d keys, %d evictions, %d early, want 3, 1, 1", st.len(), st.evictions.Value(), st.earlyEvictions.Value())
+	}
+	// a bucket that is full again is not an early eviction
+	st.SetIfNotExistsWithTTL("e", 0, 0)
+	if st.evictions.Value() != 2 || st.earlyEvictions.Value() != 1 {
+		t.Errorf("got %d evictions, %d early, want 2, 1", st.evictions.Value(), st.earlyEvictions.Value())
+	}
+}
+
+// TestRouteRateLimitStore fills the store of a route limiter over HTTP, a limited client evicted by new ones gets a fresh quota
+func TestRouteRateLimitStore(t *testing.T) {
+	conf := testAPIConfig
+	conf.APIMaxRequestHistory = 2
+	conf.RateLimitStoreShards = 1
+	s, err := New([]string{"127.0.0.1:0"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.RouteRateLimit("test_route_store", 1, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/domains/example.com/live", nil)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	stat := func(key string) int64 { return expvarInt(rateLimitStoreStats, "test_route_store_"+key) }
+	evictions, early := stat("evictions"), stat("early_evictions")
+
+	if w := serve("192.0.2.1"); w.Code != http.StatusNoContent {
+		t.Fatalf("first request: got status %d", w.Code)
+	}
+	w := serve("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"code":"limit_exceeded"`) {
+		t.Fatalf("second request: got status %d retry after %q %s, want 429 limit_exceeded", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	// two more clients fill the store of two buckets, the limited one is the least recently used
+	for _, client := range []string{"192.0.2.2", "192.0.2.3"} {
+		if w := serve(client); w.Code != http.StatusNoContent {
+			t.Fatalf("%s: got status %d", client, w.Code)
+		}
+	}
+	if w := serve("192.0.2.1"); w.Code != http.StatusNoContent {
+		t.Errorf("evicted client: got status %d, want a fresh quota", w.Code)
+	}
+
+	if got := stat("evictions") - evictions; got != 2 {
+		t.Errorf("counted %d evictions, want 2", got)
+	}
+	if got := stat("early_evictions") - early; got != 2 {
+		t.Errorf("counted %d early evictions, want 2", got)
+	}
+	keys := rateLimitStoreStats.Get("test_route_store_keys").String()
+	size := rateLimitStoreStats.Get("test_route_store_size").String()
+	if keys != "2" || size != "2" {
+		t.Errorf("exported %s keys of %s, want 2 of 2", keys, size)
+	}
+}
+
+// TestRateLimitStoreSameLimits checks that sharding does not change how a key is limited
+func TestRateLimitStoreSameLimits(t *testing.T) {
+	quota := throttled.RateQuota{MaxRate: throttled.PerMin(10), MaxBurst: 3}
+	decisions := func(shards int) []bool {
+		st := newRateLimitStore("test_same_limits", 0, shards)
+		limiter, err := throttled.NewGCRARateLimiter(st, quota)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []bool
+		for i := 0; i < 200; i++ {
+			limited, _, err := limiter.RateLimit("192.0.2."+strconv.Itoa(i%7), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, limited)
+		}
+		return got
+	}
+	single, sharded := decisions(1), decisions(16)
+	for i := range single {
+		if single[i] != sharded[i] {
+			t.Fatalf("request %d: limited %t with one shard, %t with 16", i, single[i], sharded[i])
+		}
+	}
+}
+
+// BenchmarkRateLimitStore compares the store of a single lock, as before sharding, with the sharded store
+// concurrent clients each use their own key through the GCRA limiter, as the rate limiter does
+func BenchmarkRateLimitStore(b *testing.B) {
+	for _, shards := range []int{1, DefaultRateLimitStoreShards, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			st := newRateLimitStore("bench", 10000, shards)
+			limiter, err := throttled.NewGCRARateLimiter(st, throttled.RateQuota{MaxRate: throttled.PerSec(1000000), MaxBurst: 1000})
+			if err != nil {
+				b.Fatal(err)
+			}
+			var client int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := atomic.AddInt64(&client, 1)
+				keys := make([]string, 16)
+				for i := range keys {
+					keys[i] = fmt.Sprintf("198.51.%d.%d", id, i)
+				}
+				i := 0
+				for pb.Next() {
+					limiter.RateLimit(keys[i%len(keys)], 1)
+					i++
+				}
+			})
+		})
+	}
+}
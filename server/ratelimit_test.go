@@ -0,0 +1,123 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// expvarInt returns the value of key in m, 0 if it is not set
+func expvarInt(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestRateLimitModes(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantLimited  int
+		wantHeaders  bool
+		wantEnforced int64
+		wantShadowed int64
+	}{
+		{mode: RateLimitEnforce, wantLimited: 3, wantHeaders: true, wantEnforced: 3},
+		{mode: RateLimitShadow, wantHeaders: true, wantShadowed: 3},
+		{mode: RateLimitOff},
+		// an empty mode is enforce
+		{mode: "", wantLimited: 3, wantHeaders: true, wantEnforced: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			route := "/test/modes/" + tt.mode
+			denied := 0
+			th := makeThrottleHandler("test_modes_"+tt.mode, 1, 1, 100, 0, tt.mode,
+				func(*http.Request) { denied++ }, func(*http.Request) string { return route })
+			enforced, shadowed := expvarInt(rateLimitEnforced, route), expvarInt(rateLimitShadowed, route)
+			served := 0
+			h := th.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+
+			limited := 0
+			for i := 0; i < 5; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, route, nil))
+				if w.Code == http.StatusTooManyRequests {
+					limited++
+					if w.Header().Get("Retry-After") == "" {
+						t.Error("429 without Retry-After")
+					}
+				}
+				if got := w.Header().Get("X-RateLimit-Limit") != ""; got != tt.wantHeaders {
+					t.Errorf("request %d: got X-RateLimit headers %t, want %t", i, got, tt.wantHeaders)
+				}
+			}
+			// the burst of 1 lets the first 2 requests through
+			if limited != tt.wantLimited || served != 5-tt.wantLimited || denied != tt.wantLimited {
+				t.Errorf("got %d limited, %d served, %d denied, want %d limited", limited, served, denied, tt.wantLimited)
+			}
+			if got := expvarInt(rateLimitEnforced, route) - enforced; got != tt.wantEnforced {
+				t.Errorf("got %d enforced denials, want %d", got, tt.wantEnforced)
+			}
+			if got := expvarInt(rateLimitShadowed, route) - shadowed; got != tt.wantShadowed {
+				t.Errorf("got %d shadow denials, want %d", got, tt.wantShadowed)
+			}
+		})
+	}
+}
+
+func TestRateLimitSetMode(t *testing.T) {
+	th := makeThrottleHandler("test_set_mode", 1, 0, 100, 0, RateLimitShadow,
+		func(*http.Request) {}, func(*http.Request) string { return "/test/set_mode" })
+	h := th.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("shadow request %d: got status %d", i, code)
+		}
+	}
+	// the buckets counted in shadow mode are enforced after the switch
+	if err := th.setMode(RateLimitEnforce); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Errorf("enforced: got status %d", code)
+	}
+	if err := th.setMode(RateLimitOff); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("off: got status %d", code)
+	}
+	if err := th.setMode("strict"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	if got := th.mode.Load(); got != RateLimitOff {
+		t.Errorf("unknown mode changed the mode to %v", got)
+	}
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		perMin, burst int
+		mode          string
+		wantErr       bool
+	}{
+		{name: "valid", perMin: 60, burst: 10, mode: RateLimitShadow},
+		{name: "default mode", perMin: 60},
+		{name: "zero per minute", perMin: 0, wantErr: true},
+		{name: "negative burst", perMin: 60, burst: -1, wantErr: true},
+		{name: "unknown mode", perMin: 60, mode: "strict", wantErr: true},
+	}
+	for _, test := range tests {
+		if err := CheckRateLimit(test.perMin, test.burst, test.mode); (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"expvar"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// RecordingHeader carries the ID of the recording of a response recorded with ?record=1
+const RecordingHeader = "X-Recording-ID"
+
+// recording counters
+var (
+	recordingsSaved   = expvar.NewInt("recordings_saved")
+	recordingsFailed  = expvar.NewInt("recordings_failed")
+	recordingsDeleted = expvar.NewInt("recordings_deleted")
+)
+
+// recordingSaveTimeout bounds storing a recording once its response is written
+const recordingSaveTimeout = 10 * time.Second
+
+// recordedHeaders are the request headers a response depends on beyond its URL, kept in its recording
+var recordedHeaders = []string{"Accept", "Accept-Language", EnvelopeHeader, "Host"}
+
+// RecordingStore stores the responses recorded with ?record=1
+type RecordingStore interface {
+	// SaveRecording stores rec and sets its data version
+	SaveRecording(ctx context.Context, rec *model.Recording) error
+	// GetRecording returns the recording of id, nil if there is none
+	GetRecording(ctx context.Context, id string) (*model.Recording, error)
+	// DeleteRecordings removes the recordings from before before and those beyond the keep most recent, returning how many
+	DeleteRecordings(ctx context.Context, before time.Time, keep int) (int64, error)
+}
+
+// Recordings bounds the responses recorded with ?record=1
+type Recordings struct {
+	// most recordings kept, and for how long, 0 keeps them however old
+	Max    int
+	MaxAge time.Duration
+	// bodies up to BodyMaxBytes are kept with their hash, larger ones only by hash, 0 keeps none
+	BodyMaxBytes int
+	// how often the recordings beyond Max and MaxAge are removed
+	CleanupInterval time.Duration
+}
+
+// recorder records responses to its store
+type recorder struct {
+	store RecordingStore
+	conf  Recordings
+}
+
+// SetRecordingStore lets requests with an API key or the admin token record their response with ?record=1, in store,
+// serves the recordings at GET /api/admin/recordings/{id} and removes those beyond conf with the recordings job
+// it must be called before Start
+func (s *Server) SetRecordingStore(store RecordingStore, conf Recordings) {
+	s.recordings = &recorder{store: store, conf: conf}
+	s.Admin(http.MethodGet, "/recordings/{id}", s.adminRecordingHandler)
+	s.AddJob("recordings", conf.CleanupInterval, s.recordings.cleanup)
+}
+
+// recordingWriter hashes the body written through it, and keeps it up to limit bytes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	hash   hash.Hash
+	size   int64
+	limit  int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write hashes and keeps b
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.hash.Write(b[:n])
+	rw.size += int64(n)
+	if rw.size <= int64(rw.limit) {
+		rw.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Unwrap returns the wrapped writer
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush sends any buffered data to the client
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// record is a router middleware recording the responses of the public requests with ?record=1 once they are written,
+// their recording ID is sent in X-Recording-ID and recording_id of the meta
+// only requests with an API key or the admin token may record, the recording names the key but never holds it
+func (s *Server) record(next http.Handler) http.Handler {
+	if s.recordings == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.URL.Query().Get("record")
+		if value == "" || strings.HasPrefix(r.URL.Path, adminPrefix) || strings.HasPrefix(r.URL.Path, internalPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if value != "1" {
+			WriteJSONError(w, NewFieldError("record", "must be 1"))
+			return
+		}
+		if RequestAPIKey(r.Context()) == "" && !s.adminTokenValid(r) {
+			WriteJSONError(w, ErrRecordingForbidden)
+			return
+		}
+		id, err := newRecordingID()
+		if err != nil {
+			logging.Errorf("recording: %s", err)
+			WriteJSONError(w, ErrInternalServer)
+			return
+		}
+		w.Header().Set(RecordingHeader, id)
+		SetResponseMeta(w, RecordingID(id))
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK, hash: sha256.New(), limit: s.recordings.conf.BodyMaxBytes}
+		next.ServeHTTP(rw, r)
+
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route, _ = cr.GetPathTemplate()
+		}
+		params := mux.Vars(r)
+		if len(params) == 0 {
+			params = nil
+		}
+		rec := &model.Recording{
+			ID:          id,
+			Time:        model.Now(),
+			Key:         RequestAPIKey(r.Context()),
+			RequestID:   RequestID(r.Context()),
+			Method:      r.Method,
+			Route:       route,
+			Path:        r.URL.Path,
+			Params:      params,
+			Query:       sanitizeQuery(r.URL.Query()),
+			Headers:     recordHeaders(r.Header, r.Host),
+			Status:      rw.status,
+			ContentType: w.Header().Get("Content-Type"),
+			BodySHA256:  hex.EncodeToString(rw.hash.Sum(nil)),
+			BodyBytes:   rw.size,
+		}
+		if rw.size <= int64(rw.limit) {
+			rec.Body = rw.body.Bytes()
+		}
+		// the request may be canceled once answered, the recording is stored regardless
+		ctx, cancel := context.WithTimeout(context.Background(), recordingSaveTimeout)
+		defer cancel()
+		err = s.recordings.store.SaveRecording(ctx, rec)
+		if err != nil {
+			recordingsFailed.Add(1)
+			logging.Errorf("recording %s: %s", id, err)
+			return
+		}
+		recordingsSaved.Add(1)
+	})
+}
+
+// adminTokenValid reports if the request carries the admin bearer token
+func (s *Server) adminTokenValid(r *http.Request) bool {
+	token := bearerToken(r)
+	return s.apiConfig.AdminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.apiConfig.AdminToken)) == 1
+}
+
+// newRecordingID returns a random recording ID of 32 hex digits
+func newRecordingID() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// recordHeaders returns the recordedHeaders of a request, Host is read from the request
+func recordHeaders(header http.Header, host string) map[string]string {
+	out := make(map[string]string, len(recordedHeaders))
+	for _, name := range recordedHeaders {
+		value := header.Get(name)
+		if name == "Host" {
+			value = host
+		}
+		if value != "" {
+			out[name] = value
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// cleanup removes the recordings beyond Max and MaxAge, it is the recordings job
+func (rc *recorder) cleanup(ctx context.Context) error {
+	var before time.Time
+	if rc.conf.MaxAge > 0 {
+		before = time.Now().Add(-rc.conf.MaxAge)
+	}
+	n, err := rc.store.DeleteRecordings(ctx, before, rc.conf.Max)
+	if err != nil {
+		return err
+	}
+	recordingsDeleted.Add(n)
+	if n > 0 {
+		logging.Infof("recordings: removed %d", n)
+	}
+	return nil
+}
+
+// adminRecordingHandler returns a recording with its request and, when it was kept, the body of its response
+func (s *Server) adminRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.recordings.store.GetRecording(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		logging.Errorf("recording: get: %s", err)
+		WriteJSONError(w, ErrInternalServer)
+		return
+	}
+	if rec == nil {
+		WriteJSONError(w, ErrResourceNotFound)
+		return
+	}
+	WriteJSON(w, rec)
+}
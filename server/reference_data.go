@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+)
+
+// referenceRefreshTimeout bounds a refresh of the reference data in the background
+const referenceRefreshTimeout = time.Minute
+
+// AddReferenceData registers a cached lookup, such as the list of zones, refreshed by RefreshReferenceData
+// when it was last refreshed is shown by GET /api/admin/status
+func (s *Server) AddReferenceData(v refcache.Refresher) {
+	s.references.Add(v)
+}
+
+// RefreshReferenceData reloads the reference data in the background, after an import or a config reload
+// the requests keep reading the previous values until the new ones are loaded
+func (s *Server) RefreshReferenceData() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), referenceRefreshTimeout)
+		defer cancel()
+		err := s.references.Refresh(ctx)
+		if err != nil {
+			logging.Errorf("reference data: %s", err)
+			return
+		}
+		logging.Debugf("reference data refreshed")
+	}()
+}
+
+// referenceDataStatus returns when every reference data was last refreshed
+func (s *Server) referenceDataStatus() []*model.ReferenceData {
+	statuses := s.references.Status()
+	data := make([]*model.ReferenceData, 0, len(statuses))
+	for _, status := range statuses {
+		data = append(data, &model.ReferenceData{
+			Name:        status.Name,
+			RefreshedAt: model.NewTimestamp(status.RefreshedAt),
+			Error:       status.Error,
+		})
+	}
+	return data
+}
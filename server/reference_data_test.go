@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+)
+
+// TestAdminStatusReferenceData shows when each reference data was last refreshed and the error of its last refresh
+func TestAdminStatusReferenceData(t *testing.T) {
+	s := &Server{maintenance: &maintenance{}, shedder: testShedder()}
+	fail := false
+	zones := refcache.New("zones", func(ctx context.Context) (int, error) {
+		if fail {
+			return 0, errors.New("database unavailable")
+		}
+		return 1, nil
+	})
+	s.AddReferenceData(zones)
+	s.AddReferenceData(refcache.New("labels", func(ctx context.Context) (int, error) { return 1, nil }))
+	if _, err := zones.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if err := zones.Refresh(context.Background()); err == nil {
+		t.Fatal("refreshed, want an error")
+	}
+
+	w := httptest.NewRecorder()
+	s.adminStatusHandler(w, httptest.NewRequest(http.MethodGet, "/api/admin/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s", w.Code, w.Body)
+	}
+	var resp struct{ Data model.AdminStatus }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		wantLoaded bool
+		wantErr    string
+	}{
+		// labels was never read, zones keeps the value of its first load
+		{name: "labels"},
+		{name: "zones", wantLoaded: true, wantErr: "database unavailable"},
+	}
+	if len(resp.Data.ReferenceData) != len(tests) {
+		t.Fatalf("got reference data %s, want %d", w.Body, len(tests))
+	}
+	for i, tt := range tests {
+		got := resp.Data.ReferenceData[i]
+		if got.Name != tt.name || got.RefreshedAt.IsZero() == tt.wantLoaded || got.Error != tt.wantErr {
+			t.Errorf("reference data %d: got %+v, want %s loaded %t with error %q", i, got, tt.name, tt.wantLoaded, tt.wantErr)
+		}
+	}
+}
+
+// TestRefreshReferenceData reloads every reference data in the background
+func TestRefreshReferenceData(t *testing.T) {
+	s := &Server{}
+	var loads [2]int32
+	for i := range loads {
+		i := i
+		s.AddReferenceData(refcache.New("test", func(ctx context.Context) (int32, error) {
+			return atomic.AddInt32(&loads[i], 1), nil
+		}))
+	}
+	s.RefreshReferenceData()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&loads[0]) == 0 || atomic.LoadInt32(&loads[1]) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("loaded %d and %d times, want both refreshed", atomic.LoadInt32(&loads[0]), atomic.LoadInt32(&loads[1]))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"dnscoffee/logging"
+
+	"github.com/gorilla/mux"
+)
+
+// error reporting counters
+var (
+	reportsSent    = expvar.NewInt("error_reports_sent")
+	reportsFailed  = expvar.NewInt("error_reports_failed")
+	reportsDropped = expvar.NewInt("error_reports_dropped")
+)
+
+// sensitiveParams are query parameters whose values are not sent to the error reporter
+var sensitiveParams = []string{"token", "key", "secret", "password", "auth", "signature"}
+
+// ErrorReport describes a request that failed with an internal server error
+type ErrorReport struct {
+	Time      time.Time         `json:"time"`
+	Error     string            `json:"error"`
+	Stack     string            `json:"stack,omitempty"`
+	Method    string            `json:"method"`
+	Route     string            `json:"route"`
+	Path      string            `json:"path"`
+	Query     map[string]string `json:"query,omitempty"`
+	ClientIP  string            `json:"client_ip"`
+	RequestID string            `json:"request_id"`
+}
+
+// ErrorReporter sends error reports to an error tracker
+type ErrorReporter interface {
+	Report(ctx context.Context, report *ErrorReport) error
+}
+
+// reportQueue sends reports in the background so that a slow reporter never delays a response
+// reports are dropped when the queue is full
+type reportQueue struct {
+	reporter ErrorReporter
+	queue    chan *ErrorReport
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newReportQueue(reporter ErrorReporter, size int) *reportQueue {
+	q := &reportQueue{
+		reporter: reporter,
+		queue:    make(chan *ErrorReport, size),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *reportQueue) run() {
+	defer close(q.done)
+	for report := range q.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := q.reporter.Report(ctx, report)
+		cancel()
+		if err != nil {
+			reportsFailed.Add(1)
+			logging.Warnf("error report for request %s failed: %s", report.RequestID, err)
+			continue
+		}
+		reportsSent.Add(1)
+	}
+}
+
+// add queues a report without blocking
+func (q *reportQueue) add(report *ErrorReport) {
+	select {
+	case q.queue <- report:
+	default:
+		reportsDropped.Add(1)
+	}
+}
+
+// close sends the queued reports and stops the queue, giving up when ctx is done
+func (q *reportQueue) close(ctx context.Context) error {
+	q.once.Do(func() { close(q.queue) })
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetErrorReporter reports panics and internal server errors to reporter through a queue of queueSize reports
+// it must be called before Start
+func (s *Server) SetErrorReporter(reporter ErrorReporter, queueSize int) {
+	s.reports = newReportQueue(reporter, queueSize)
+}
+
+// reportErrors is a router middleware that recovers panics in handlers, responding with ErrInternalServer,
+// and reports them and any other internal server error responses to the error reporter
+func (s *Server) reportErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				if sw.status == http.StatusInternalServerError {
+					s.report(r, fmt.Sprintf("%d %s", sw.status, http.StatusText(sw.status)), "")
+				}
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// the connection is aborted on purpose
+				panic(rec)
+			}
+			stack := string(debug.Stack())
+			logging.Errorf("panic: %v request: %s %s id: %s\n%s", rec, r.Method, r.URL.Path, RequestID(r.Context()), stack)
+			s.report(r, fmt.Sprint(rec), stack)
+			WriteJSONError(sw, ErrInternalServer)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// report queues an error report for the request if a reporter is set
+func (s *Server) report(r *http.Request, msg, stack string) {
+	if s.reports == nil {
+		return
+	}
+	route := ""
+	if cr := mux.CurrentRoute(r); cr != nil {
+		route, _ = cr.GetPathTemplate()
+	}
+	s.reports.add(&ErrorReport{
+		Time:      time.Now().UTC(),
+		Error:     msg,
+		Stack:     stack,
+		Method:    r.Method,
+		Route:     route,
+		Path:      r.URL.Path,
+		Query:     sanitizeQuery(r.URL.Query()),
+		ClientIP:  getIPAddress(r),
+		RequestID: RequestID(r.Context()),
+	})
+}
+
+// sanitizeQuery flattens the query parameters and hides the values of sensitive ones
+func sanitizeQuery(query url.Values) map[string]string {
+	if len(query) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(query))
+	for k, v := range query {
+		value := strings.Join(v, ",")
+		lower := strings.ToLower(k)
+		for _, p := range sensitiveParams {
+			if strings.Contains(lower, p) {
+				value = "[redacted]"
+				break
+			}
+		}
+		out[k] = value
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+
+	"dnscoffee/metrics"
+)
+
+// responseSizeBuckets are the upper bounds in bytes of the response size histograms
+var responseSizeBuckets = []float64{1 << 10, 16 << 10, 128 << 10, 1 << 20, 8 << 20, 64 << 20}
+
+// response size metrics
+var (
+	responseSizes     = metrics.NewHistogramMap("response_bytes", responseSizeBuckets)
+	responsesTooLarge = expvar.NewInt("responses_too_large")
+)
+
+// errResponseTooLarge is returned by writeJSONBody for responses over the response size limit
+var errResponseTooLarge = errors.New("response too large")
+
+// sizeWriter counts the bytes written to a response
+// and carries the route and response size limit for WriteJSON
+type sizeWriter struct {
+	http.ResponseWriter
+	route string
+	// maximum response size in bytes, 0 is unlimited
+	limit   int
+	written int
+}
+
+func (sw *sizeWriter) Write(p []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(p)
+	sw.written += n
+	return n, err
+}
+
+func (sw *sizeWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// findSizeWriter returns the sizeWriter of the response written to w, if it is measured
+func findSizeWriter(w http.ResponseWriter) (*sizeWriter, bool) {
+	for {
+		if sw, ok := w.(*sizeWriter); ok {
+			return sw, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// measureResponses is a router middleware that records the size of every response by route
+func (s *Server) measureResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &sizeWriter{ResponseWriter: w, route: routeName(r), limit: s.apiConfig.MaxResponseBytes}
+		next.ServeHTTP(sw, r)
+		responseSizes.Observe(sw.route, float64(sw.written))
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetrySeconds(t *testing.T) {
+	tests := []struct {
+		retry time.Duration
+		want  string
+	}{
+		{retry: 0, want: "1"},
+		{retry: -time.Second, want: "1"},
+		{retry: time.Millisecond, want: "1"},
+		{retry: 1500 * time.Millisecond, want: "2"},
+		{retry: time.Minute, want: "60"},
+	}
+	for _, tt := range tests {
+		if got := retrySeconds(tt.retry); got != tt.want {
+			t.Errorf("retrySeconds(%s) = %q, want %q", tt.retry, got, tt.want)
+		}
+	}
+}
+
+// checkRetryMeta checks that the retry_after_seconds meta of a retry error repeats its Retry-After header
+func checkRetryMeta(t *testing.T, w *httptest.ResponseRecorder, meta map[string]string) {
+	t.Helper()
+	if got, want := meta[retryAfterMeta], w.Header().Get("Retry-After"); got != want {
+		t.Errorf("got %s %q, want the Retry-After %q", retryAfterMeta, got, want)
+	}
+}
+
+func TestThrottleRetryError(t *testing.T) {
+	route := "/test/retry"
+	th := makeThrottleHandler("test_retry", 1, 0, 100, 0, RateLimitEnforce, func(*http.Request) {}, func(*http.Request) string { return route })
+	h := th.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		return inflightRequest(h, remoteAddr, route)
+	}
+
+	tests := []struct {
+		name          string
+		perMin, burst int
+		remoteAddr    string
+		// requests let through before the first 429
+		allowed  int
+		wantMeta map[string]string
+	}{
+		{name: "one a minute", perMin: 1, remoteAddr: "192.0.2.1:1", allowed: 1,
+			wantMeta: map[string]string{"limit": "1", "window_seconds": "60", "burst": "0", retryAfterMeta: "60"}},
+		// a new quota names itself in the denials after it
+		{name: "new quota", perMin: 30, burst: 2, remoteAddr: "192.0.2.2:1", allowed: 3,
+			wantMeta: map[string]string{"limit": "30", "window_seconds": "60", "burst": "2", retryAfterMeta: "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := th.setQuota(tt.perMin, tt.burst); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.allowed; i++ {
+				if w := serve(tt.remoteAddr); w.Code != http.StatusOK {
+					t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+				}
+			}
+			w := serve(tt.remoteAddr)
+			jsonErr := checkRetryError(t, w, ErrLimitExceeded)
+			checkRetryMeta(t, w, jsonErr.Meta)
+			for k, v := range tt.wantMeta {
+				if jsonErr.Meta[k] != v {
+					t.Errorf("got meta %s %q, want %q", k, jsonErr.Meta[k], v)
+				}
+			}
+		})
+	}
+	// the meta went into copies, the shared error is unchanged
+	if ErrLimitExceeded.Meta != nil {
+		t.Errorf("ErrLimitExceeded has the meta %v of a response", ErrLimitExceeded.Meta)
+	}
+}
+
+func TestRouteRateLimitRetryError(t *testing.T) {
+	s := testServer(t)
+	s.bans = newBanList(10, time.Minute, time.Minute, 100)
+	h := s.RouteRateLimit("test_retry_route", 2, 0, func(w http.ResponseWriter, r *http.Request) {})
+
+	if w := inflightRequest(h, "192.0.2.1:1", "/api/domains/example.com/live"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	w := inflightRequest(h, "192.0.2.1:1", "/api/domains/example.com/live")
+	jsonErr := checkRetryError(t, w, ErrLimitExceeded)
+	checkRetryMeta(t, w, jsonErr.Meta)
+	if jsonErr.Meta["limit"] != "2" || jsonErr.Meta["burst"] != "0" || jsonErr.Meta["window_seconds"] != "60" {
+		t.Errorf("got meta %v, want the route quota of 2 a minute", jsonErr.Meta)
+	}
+	if retry, _ := strconv.Atoi(jsonErr.Meta[retryAfterMeta]); retry < 1 || retry > 30 {
+		t.Errorf("got %s %q, want at most the 30s between requests", retryAfterMeta, jsonErr.Meta[retryAfterMeta])
+	}
+}
+
+func TestBannedRetryError(t *testing.T) {
+	s := testServer(t)
+	s.bans = newBanList(1, time.Minute, 90*time.Second, 100)
+	h := abuseChain(s)
+	abuseRequest(h, "192.0.2.10:1234", "")
+
+	w := abuseRequest(h, "192.0.2.10:1234", "")
+	jsonErr := checkRetryError(t, w, ErrBanned)
+	checkRetryMeta(t, w, jsonErr.Meta)
+	if retry, _ := strconv.Atoi(jsonErr.Meta[retryAfterMeta]); retry < 85 || retry > 90 {
+		t.Errorf("got %s %q, want the 90s left of the ban", retryAfterMeta, jsonErr.Meta[retryAfterMeta])
+	}
+}
+
+func TestShedLoadRetryError(t *testing.T) {
+	s := testServer(t)
+	s.shedder = testShedder()
+	s.shedder.expensive["/api/search"] = true
+	s.shedder.probability = 1
+	s.shedder.random = func() float64 { return 0 }
+	h := s.shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// the cheap routes are never shed
+	if w := inflightRequest(h, "192.0.2.1:1", "/api/domains/example.com"); w.Code != http.StatusOK {
+		t.Errorf("cheap route: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	w := inflightRequest(h, "192.0.2.1:1", "/api/search")
+	jsonErr := checkRetryError(t, w, ErrOverloaded)
+	checkRetryMeta(t, w, jsonErr.Meta)
+	if want := retrySeconds(DefaultLoadShedding.Window); jsonErr.Meta[retryAfterMeta] != want {
+		t.Errorf("got %s %q, want the window of %s", retryAfterMeta, jsonErr.Meta[retryAfterMeta], want)
+	}
+}
+
+func TestMaintenanceRetryError(t *testing.T) {
+	m := &maintenance{}
+	h := m.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	eta := time.Now().Add(5 * time.Minute)
+	tests := []struct {
+		name      string
+		message   string
+		eta       *time.Time
+		wantRetry func(int) bool
+	}{
+		{name: "default retry", wantRetry: func(s int) bool { return s == int(defaultMaintenanceRetry.Seconds()) }},
+		{name: "until the ETA", message: "upgrading", eta: &eta, wantRetry: func(s int) bool { return s > 290 && s <= 300 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.set(true, tt.message, tt.eta)
+			w := inflightRequest(h, "192.0.2.1:1", "/api/zones")
+			jsonErr := checkRetryError(t, w, ErrMaintenance)
+			checkRetryMeta(t, w, jsonErr.Meta)
+			if retry, _ := strconv.Atoi(jsonErr.Meta[retryAfterMeta]); !tt.wantRetry(retry) {
+				t.Errorf("got %s %q", retryAfterMeta, jsonErr.Meta[retryAfterMeta])
+			}
+			if tt.message != "" && jsonErr.Detail != tt.message {
+				t.Errorf("got detail %q, want %q", jsonErr.Detail, tt.message)
+			}
+		})
+	}
+	if ErrMaintenance.Detail == "upgrading" || ErrMaintenance.Meta != nil {
+		t.Errorf("ErrMaintenance was changed to %+v", ErrMaintenance)
+	}
+	// the health checks are served during maintenance
+	if w := inflightRequest(h, "192.0.2.1:1", "/health"); w.Code != http.StatusOK {
+		t.Errorf("health: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// route flag sources, who disabled a route
+const (
+	disabledByConfig = "config"
+	disabledByAdmin  = "admin"
+)
+
+// routeFlag is the enable flag of the paths of a named route, read on every request of the route
+type routeFlag struct {
+	name string
+	// 1 while the route is disabled
+	disabled int32
+
+	// guarded by routeFlags.mu
+	paths      []string
+	disabledBy string
+	since      model.Timestamp
+}
+
+// routeFlags holds the flags of the named routes, see Named
+type routeFlags struct {
+	mu    sync.Mutex
+	flags map[string]*routeFlag
+	// the names API.Disabled_Routes disabled at startup or on the last reload
+	configured map[string]bool
+}
+
+func newRouteFlags() *routeFlags {
+	return &routeFlags{flags: make(map[string]*routeFlag), configured: make(map[string]bool)}
+}
+
+// Named names a route so that it can be disabled at runtime, by API.Disabled_Routes or the admin API
+// the versions and methods of a route share its name, a name given to several routes disables them together
+func Named(name string) RouteOption {
+	return func(o *routeOptions) {
+		o.name = name
+	}
+}
+
+// add returns the flag of name, adding path to its paths
+func (rf *routeFlags) add(name, path string) *routeFlag {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	flag, ok := rf.flags[name]
+	if !ok {
+		flag = &routeFlag{name: name}
+		rf.flags[name] = flag
+	}
+	for _, p := range flag.paths {
+		if p == path {
+			return flag
+		}
+	}
+	flag.paths = append(flag.paths, path)
+	return flag
+}
+
+// set enables or disables the route name, false if there is no such route
+func (rf *routeFlags) set(name string, disabled bool, by string) (*model.RouteStatus, bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	flag, ok := rf.flags[name]
+	if !ok {
+		return nil, false
+	}
+	rf.setLocked(flag, disabled, by)
+	return flag.status(), true
+}
+
+func (rf *routeFlags) setLocked(flag *routeFlag, disabled bool, by string) {
+	if !disabled {
+		atomic.StoreInt32(&flag.disabled, 0)
+		flag.disabledBy, flag.since = "", model.Timestamp{}
+		return
+	}
+	if atomic.SwapInt32(&flag.disabled, 1) == 0 {
+		flag.since = model.Now()
+	}
+	flag.disabledBy = by
+}
+
+// configure applies the names of API.Disabled_Routes
+// only the routes added to or removed from the list since it was last applied change, so that a route toggled with
+// the admin API keeps its state across reloads that leave its entry alone; unknown names are logged and skipped
+func (rf *routeFlags) configure(names []string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	configured := make(map[string]bool, len(names))
+	for _, name := range names {
+		configured[name] = true
+		if rf.configured[name] {
+			continue
+		}
+		flag, ok := rf.flags[name]
+		if !ok {
+			logging.Warnf("API.Disabled_Routes: no route is named %s", name)
+			continue
+		}
+		rf.setLocked(flag, true, disabledByConfig)
+		logging.Infof("route %s disabled by the config", name)
+	}
+	for name := range rf.configured {
+		if flag, ok := rf.flags[name]; ok && !configured[name] {
+			rf.setLocked(flag, false, "")
+			logging.Infof("route %s enabled by the config", name)
+		}
+	}
+	rf.configured = configured
+}
+
+// list returns the status of every named route by name
+func (rf *routeFlags) list() []*model.RouteStatus {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	routes := make([]*model.RouteStatus, 0, len(rf.flags))
+	for _, flag := range rf.flags {
+		routes = append(routes, flag.status())
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	return routes
+}
+
+// names returns the name of every path of the named routes
+func (rf *routeFlags) names() map[string]string {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	names := make(map[string]string)
+	for _, flag := range rf.flags {
+		for _, path := range flag.paths {
+			names[path] = flag.name
+		}
+	}
+	return names
+}
+
+// status returns the state of the route, the caller holds routeFlags.mu
+func (flag *routeFlag) status() *model.RouteStatus {
+	return &model.RouteStatus{
+		Name:       flag.name,
+		Paths:      append([]string(nil), flag.paths...),
+		Enabled:    atomic.LoadInt32(&flag.disabled) == 0,
+		DisabledBy: flag.disabledBy,
+		Since:      flag.since,
+	}
+}
+
+// handler answers ErrRouteDisabled while the route is disabled
+func (flag *routeFlag) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&flag.disabled) == 1 {
+			WriteJSONError(w, ErrRouteDisabled, map[string]string{"route": flag.name})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SetDisabledRoutes applies a reloaded API.Disabled_Routes, see routeFlags.configure
+func (s *Server) SetDisabledRoutes(names []string) {
+	s.routeFlags.configure(names)
+}
+
+// adminRoutesHandler lists the named routes and whether they are enabled
+func (s *Server) adminRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, &model.Routes{Routes: s.routeFlags.list()})
+}
+
+// adminRouteHandler disables or enables a named route until the next toggle or restart
+func (s *Server) adminRouteHandler(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		status, ok := s.routeFlags.set(name, disabled, disabledByAdmin)
+		if !ok {
+			WriteJSONError(w, ErrResourceNotFound)
+			return
+		}
+		if disabled {
+			logging.Infof("admin: route %s disabled by %s", name, getIPAddress(r))
+		} else {
+			logging.Infof("admin: route %s enabled by %s", name, getIPAddress(r))
+		}
+		WriteJSON(w, status)
+	}
+}
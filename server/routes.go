@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteInfo is a path of the router and the methods it answers, see Routes
+type RouteInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+	// the name given with Named, empty for routes without one
+	Name string `json:"name,omitempty"`
+}
+
+// Routes returns the paths registered so far ordered by path, with the methods they answer
+// a route matching any method has no methods
+func (s *Server) Routes() ([]*RouteInfo, error) {
+	names := s.routeFlags.names()
+	byPath := make(map[string]*RouteInfo)
+	err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// routes matching on something other than the path, there are none
+			return nil
+		}
+		info, ok := byPath[path]
+		if !ok {
+			info = &RouteInfo{Path: path, Name: names[path]}
+			byPath[path] = info
+		}
+		methods, err := route.GetMethods()
+		if err == nil {
+			info.Methods = append(info.Methods, methods...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]*RouteInfo, 0, len(byPath))
+	for _, info := range byPath {
+		sort.Strings(info.Methods)
+		routes = append(routes, info)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	return routes, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	s, err := New([]string{"127.0.0.1:0"}, testAPIConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	s.Get("/api/zones/{zone}", ok, Named("zone"))
+	s.Get("/api/domains/{domain}", ok, Named("domain"))
+	s.Get("/api/ping", ok)
+	s.Admin(http.MethodDelete, "/things/{id}", ok)
+	s.Admin(http.MethodGet, "/things/{id}", ok)
+
+	routes, err := s.Routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]*RouteInfo)
+	var paths []string
+	for _, route := range routes {
+		byPath[route.Path] = route
+		paths = append(paths, route.Path)
+	}
+	for i := 1; i < len(paths); i++ {
+		if paths[i-1] >= paths[i] {
+			t.Errorf("routes %q and %q are not ordered by path", paths[i-1], paths[i])
+		}
+	}
+	tests := []struct {
+		path        string
+		wantMethods []string
+		wantName    string
+	}{
+		{path: "/api/zones/{zone}", wantMethods: []string{http.MethodGet, http.MethodHead}, wantName: "zone"},
+		{path: "/api/domains/{domain}", wantMethods: []string{http.MethodGet, http.MethodHead}, wantName: "domain"},
+		{path: "/api/ping", wantMethods: []string{http.MethodGet, http.MethodHead}},
+		// the methods of a path registered twice are merged
+		{path: "/api/admin/things/{id}", wantMethods: []string{http.MethodDelete, http.MethodGet}},
+	}
+	for _, tt := range tests {
+		route, ok := byPath[tt.path]
+		if !ok {
+			t.Errorf("%s not in the routes %q", tt.path, paths)
+			continue
+		}
+		if !reflect.DeepEqual(route.Methods, tt.wantMethods) || route.Name != tt.wantName {
+			t.Errorf("%s: got methods %v named %q, want %v named %q", tt.path, route.Methods, route.Name, tt.wantMethods, tt.wantName)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"dnscoffee/logging"
+)
+
+// selfTestTimeout bounds each request of the self-test
+const selfTestTimeout = 30 * time.Second
+
+// SelfTestCheck is the result of one check of the self-test
+// a skipped check could not run, such as a route query without seed data to answer it, and does not fail the self-test
+type SelfTestCheck struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"` // ok, failed or skipped
+	Error   string  `json:"error,omitempty"`
+	TookMS  float64 `json:"took_ms"`
+	Request string  `json:"request,omitempty"`
+}
+
+// self-test check statuses
+const (
+	SelfTestOK      = "ok"
+	SelfTestFailed  = "failed"
+	SelfTestSkipped = "skipped"
+)
+
+// SelfTestReport is the JSON report of the -selftest mode
+type SelfTestReport struct {
+	OK      bool             `json:"ok"`
+	Version string           `json:"version"`
+	Checks  []*SelfTestCheck `json:"checks"`
+}
+
+// Add records the result of a check run outside of the server, a nil err passes
+func (sr *SelfTestReport) Add(name string, took time.Duration, err error) {
+	check := &SelfTestCheck{Name: name, Status: SelfTestOK, TookMS: float64(took) / float64(time.Millisecond)}
+	if err != nil {
+		check.Status = SelfTestFailed
+		check.Error = err.Error()
+	}
+	sr.Checks = append(sr.Checks, check)
+}
+
+// Failed returns true if any check failed
+func (sr *SelfTestReport) Failed() bool {
+	for _, check := range sr.Checks {
+		if check.Status == SelfTestFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// selfTestRequest is a representative request of a route run by the self-test
+type selfTestRequest struct {
+	name string
+	path string
+}
+
+// AddSelfTest registers a GET of path the self-test sends to check a handler, it must answer 200
+// a 404 means the seed data it looks for is missing and skips the check
+func (s *Server) AddSelfTest(name, path string) {
+	s.selfTests = append(s.selfTests, selfTestRequest{name, path})
+}
+
+// SelfTest serves the routes on an ephemeral loopback port instead of the configured listeners,
+// runs the readiness checks and the self-test requests and adds their results to report
+// background jobs are not started, the server can not be started afterwards
+func (s *Server) SelfTest(ctx context.Context, report *SelfTestReport) error {
+	public, admin := s.handlers()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.outer(splitAdmin(admin, public))}
+	go srv.Serve(ln)
+	defer srv.Shutdown(ctx)
+
+	for _, c := range s.readinessChecks {
+		start := time.Now()
+		err := c.check()
+		report.Add("ready/"+c.name, time.Since(start), err)
+	}
+
+	client := &http.Client{Timeout: selfTestTimeout}
+	base := "http://" + ln.Addr().String()
+	for _, t := range s.selfTests {
+		check := &SelfTestCheck{Name: "route/" + t.name, Request: t.path}
+		start := time.Now()
+		status, err := selfTestGet(ctx, client, base+t.path)
+		check.TookMS = float64(time.Since(start)) / float64(time.Millisecond)
+		switch {
+		case err != nil:
+			check.Status = SelfTestFailed
+			check.Error = err.Error()
+		case status == http.StatusOK:
+			check.Status = SelfTestOK
+		case status == http.StatusNotFound:
+			check.Status = SelfTestSkipped
+			check.Error = "no seed data"
+		default:
+			check.Status = SelfTestFailed
+			check.Error = fmt.Sprintf("status %d", status)
+		}
+		logging.Debugf("selftest %s: %s %s", check.Name, check.Status, check.Error)
+		report.Checks = append(report.Checks, check)
+	}
+	return nil
+}
+
+// selfTestGet sends a GET of url and returns the response status once the whole body is read
+func selfTestGet(ctx context.Context, client *http.Client, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, err
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// TestSelfTest serves routes that pass, lack seed data and fail, and readiness checks that pass and fail
+func TestSelfTest(t *testing.T) {
+	s, err := New([]string{"127.0.0.1:0"}, testAPIConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Get("/api/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) })
+	s.Get("/api/domains/{domain}", func(w http.ResponseWriter, r *http.Request) { WriteJSONError(w, ErrResourceNotFound) })
+	s.Get("/api/broken", func(w http.ResponseWriter, r *http.Request) { WriteJSONError(w, ErrInternalServer) })
+	s.AddSelfTest("ok", "/api/ok")
+	s.AddSelfTest("domain", "/api/domains/example.com")
+	s.AddSelfTest("broken", "/api/broken")
+	s.AddReadinessCheck("database", func() error { return nil })
+	s.AddReadinessCheck("routing_table", func() error { return errors.New("not loaded") })
+
+	report := &SelfTestReport{}
+	if err := s.SelfTest(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name, status, err string
+	}{
+		{"ready/maintenance", SelfTestOK, ""},
+		{"ready/database", SelfTestOK, ""},
+		{"ready/routing_table", SelfTestFailed, "not loaded"},
+		{"route/ok", SelfTestOK, ""},
+		{"route/domain", SelfTestSkipped, "no seed data"},
+		{"route/broken", SelfTestFailed, "status 500"},
+	}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("got %d checks, want %d", len(report.Checks), len(want))
+	}
+	for i, w := range want {
+		got := report.Checks[i]
+		if got.Name != w.name || got.Status != w.status || got.Error != w.err {
+			t.Errorf("check %d: got %s %s %q, want %s %s %q", i, got.Name, got.Status, got.Error, w.name, w.status, w.err)
+		}
+	}
+	if !report.Failed() {
+		t.Error("the report did not fail")
+	}
+}
+
+func TestSelfTestReport(t *testing.T) {
+	report := &SelfTestReport{}
+	report.Add("config", 0, nil)
+	report.Checks = append(report.Checks, &SelfTestCheck{Name: "route/domain", Status: SelfTestSkipped})
+	if report.Failed() {
+		t.Error("passed and skipped checks failed the report")
+	}
+	report.Add("database", 0, errors.New("connection refused"))
+	if !report.Failed() || report.Checks[2].Error != "connection refused" {
+		t.Errorf("got %+v, want a failed database check", report.Checks[2])
+	}
+}
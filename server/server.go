@@ -2,19 +2,99 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
+	"net/netip"
+	"strings"
+	"sync"
 	"time"
 
+	"dnscoffee/cursor"
+	"dnscoffee/logging"
+	"dnscoffee/model"
+	"dnscoffee/refcache"
+
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
 type APIConfig struct {
+	// CIDRs of the reverse proxies whose forwarding headers are trusted, no peer's forwarding headers are trusted when empty
+	TrustedProxies       []string
 	APITimeout           int
 	APIRequestsPerMinute int
 	APIMaxRequestHistory int
 	APIRequestsBurst     int
+	// shards of the rate limiter stores, each with its own lock, the APIMaxRequestHistory buckets are split between them
+	RateLimitStoreShards int
+	// RateLimitEnforce, RateLimitShadow to only count and log rate limited requests, or RateLimitOff
+	RateLimitMode string
+	// clients rate limited BanThreshold times within BanWindow are blocked for BanDuration, 0 disables banning
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+	// maximum number of requests served at once in total and per client IP, 0 is unlimited
+	MaxInFlight          int
+	MaxInFlightPerClient int
+	// responses written with WriteJSON larger than this many bytes are replaced with ErrResponseTooLarge, 0 is unlimited
+	MaxResponseBytes int
+	// key signing pagination cursors, a random key is used when empty
+	CursorSecret string
+	// how long pagination cursors stay valid, 0 never expires
+	CursorTTL time.Duration
+	// keys granting scopes to the requests carrying them, and the zones whose per-domain data needs one of those scopes
+	APIKeys         []APIKey
+	RestrictedZones []RestrictedZone
+	// where clients can ask for access to restricted zones, included in ErrForbiddenZone
+	RestrictedZoneContact string
+	// bearer token for the /api/admin routes, the admin API is disabled when empty
+	AdminToken string
+	// optional separate ip:port for the /api/admin routes, they are then not served on the main listener
+	AdminListen string
+	// certificate and key to serve the admin listener over TLS
+	AdminTLSCert string
+	AdminTLSKey  string
+	// CA bundle for admin client certificates, clients presenting a certificate signed by it need no token
+	AdminClientCA string
+	// bearer token of the /api/internal routes called by the zone importer, they are disabled when empty
+	ImportHookSecret string
+	// CIDRs the /api/internal routes may be called from, any address is allowed when empty
+	ImportHookCIDRs []string
+	// 1 in AccessLogSampleRate successful requests is logged, errors and requests slower than SlowRequestThreshold always are,
+	// 404s too unless SampleNotFound
+	AccessLogSampleRate  int
+	SlowRequestThreshold time.Duration
+	SampleNotFound       bool
+	// CIDRs the /api/admin and /api/internal routes may be called from, checked before any token, any address is allowed when empty
+	AdminAllowCIDRs    []string
+	InternalAllowCIDRs []string
+	// DebugStatsOff, DebugStatsAdmin or DebugStatsAll, the requests sent the X-Debug-Stats header
+	DebugStats string
+	// sunset times of deprecated routes by path template, sent in the Sunset header
+	Sunsets map[string]time.Time
+	// deprecated routes answer 410 after their sunset
+	EnforceSunsets bool
+	// start in maintenance mode, it can be toggled at runtime with POST /api/admin/maintenance
+	Maintenance        bool
+	MaintenanceMessage string
+	// settings of the requests sent to other hosts, requests to unknown hosts get the settings above
+	Tenants []Tenant
+	// when the requests of the routes registered with Expensive are shed
+	LoadShedding LoadShedding
+	// names of the routes registered with Named that answer ErrRouteDisabled, see SetDisabledRoutes for reloads
+	DisabledRoutes []string
+	// how long kept-alive connections wait for their next request, the APITimeout when 0
+	IdleTimeout time.Duration
+	// HTTP/2 streams a client may have open at once on one connection, 0 is the default of golang.org/x/net/http2
+	MaxConcurrentStreams uint32
+	// serve cleartext HTTP/2 on the main listeners, for deployments where TLS terminates at a proxy
+	H2C bool
 }
 
 var DefaultAPIConfig = APIConfig{
@@ -22,6 +102,21 @@ var DefaultAPIConfig = APIConfig{
 	APIRequestsPerMinute: 60,
 	APIMaxRequestHistory: 16384,
 	APIRequestsBurst:     10,
+	RateLimitStoreShards: DefaultRateLimitStoreShards,
+	RateLimitMode:        RateLimitEnforce,
+	BanThreshold:         100,
+	BanWindow:            time.Minute,
+	BanDuration:          10 * time.Minute,
+	MaxInFlight:          512,
+	MaxInFlightPerClient: 16,
+	MaxResponseBytes:     64 << 20,
+	CursorTTL:            24 * time.Hour,
+	DebugStats:           DebugStatsOff,
+	AccessLogSampleRate:  1,
+	SlowRequestThreshold: time.Second,
+	LoadShedding:         DefaultLoadShedding,
+	IdleTimeout:          2 * time.Minute,
+	MaxConcurrentStreams: 250,
 }
 
 // Server struct for holding server resources
@@ -31,18 +126,136 @@ type Server struct {
 	// all communication with the server's router should be done with server methods
 	router *mux.Router
 
-	listenAddr string
+	// ip:port addresses of the main listeners, and the addresses they are bound to once started
+	listenAddrs []string
+	boundAddrs  []string
+
+	apiConfig      APIConfig
+	trustedProxies []*net.IPNet
+	hookNets       []netip.Prefix
+	adminAllow     *ipAllowlist
+	internalAllow  *ipAllowlist
+
+	readinessChecks []readinessCheck
+	selfTests       []selfTestRequest
+	cacheFlushers   []cacheFlusher
+	references      refcache.Group
+
+	throttle    *throttle
+	maintenance *maintenance
+	bans        *banList
+	inflight    *inflightLimiter
+	shedder     *loadShedder
+	routeFlags  *routeFlags
+	reloader    func() (*model.ConfigReload, error)
+	reports     *reportQueue
+	accessLog   *accessLogger
+	audits      *auditQueue
+	recordings  *recorder
+	idempotency *idempotency
+	cursors     *cursor.Codec
+	checkpoints *cursor.Codec
+	conns       *connTracker
+	activity    *activityTracker
+	zones       *ZoneAccess
+	tenants     *tenantSet
+	// path templates of the routes registered with Stream
+	streaming map[string]bool
+
+	jobs        []*job
+	stopJobsCtx context.CancelFunc
+	jobsRunning sync.WaitGroup
+
+	shutdownHooks []func()
 
-	apiConfig APIConfig
+	// mu guards the fields below and boundAddrs, Shutdown and ListenAddrs are called from other goroutines than Start
+	mu          sync.Mutex
+	httpServers []*http.Server
+	// set by Shutdown, a Start that has not registered its servers yet returns http.ErrServerClosed instead
+	closed bool
 }
 
 // New creates a new server object with the default (included) handlers
-func New(listenAddr string, apiConfig APIConfig) (*Server, error) {
+// the API is served on every one of listenAddrs
+func New(listenAddrs []string, apiConfig APIConfig) (*Server, error) {
+	if len(listenAddrs) == 0 {
+		return nil, errors.New("no listen address")
+	}
 	server := &Server{
-		listenAddr: listenAddr,
-		apiConfig:  apiConfig,
-		router:     mux.NewRouter().StrictSlash(true),
+		listenAddrs: listenAddrs,
+		apiConfig:   apiConfig,
+		// trailing slashes are redirected by canonicalPaths
+		router:      mux.NewRouter(),
+		maintenance: &maintenance{},
+		bans:        newBanList(apiConfig.BanThreshold, apiConfig.BanWindow, apiConfig.BanDuration, apiConfig.APIMaxRequestHistory),
+		inflight:    newInflightLimiter(apiConfig.MaxInFlight, apiConfig.MaxInFlightPerClient),
+		shedder:     newLoadShedder(apiConfig.LoadShedding),
+		routeFlags:  newRouteFlags(),
+		conns:       newConnTracker(),
+		activity:    newActivityTracker(),
+		zones:       newZoneAccess(apiConfig.APIKeys, apiConfig.RestrictedZones, apiConfig.RestrictedZoneContact),
+		accessLog:   newAccessLogger(logging.Writer(logging.Info), apiConfig.AccessLogSampleRate, apiConfig.SlowRequestThreshold, apiConfig.SampleNotFound),
+	}
+	inflightCurrent.Store(server.inflight)
+	// TODO add rate limiting after static handler and possible the main page
+	server.throttle = makeThrottleHandler(
+		"api",
+		apiConfig.APIRequestsPerMinute,
+		apiConfig.APIRequestsBurst,
+		apiConfig.APIMaxRequestHistory,
+		apiConfig.RateLimitStoreShards,
+		apiConfig.RateLimitMode,
+		func(r *http.Request) { server.bans.strike(getIPAddress(r)) },
+		server.matchRoute,
+	)
+	server.tenants = server.newTenantSet(apiConfig.Tenants)
+	for _, t := range server.tenants.all {
+		if t.restricted != nil {
+			server.zones.tenantRestricted = append(server.zones.tenantRestricted, t.restricted)
+		}
+	}
+	for _, cidr := range apiConfig.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		server.trustedProxies = append(server.trustedProxies, ipNet)
 	}
+	var err error
+	server.hookNets, err = parsePrefixes(apiConfig.ImportHookCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	server.adminAllow, err = newIPAllowlist("admin", apiConfig.AdminAllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	server.internalAllow, err = newIPAllowlist("internal", apiConfig.InternalAllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if apiConfig.Maintenance {
+		server.maintenance.set(true, apiConfig.MaintenanceMessage, nil)
+	}
+	server.AddReadinessCheck("maintenance", server.maintenance.check)
+	if apiConfig.CursorSecret == "" {
+		logging.Warnf("no cursor secret set, pagination cursors are only valid until restart and on this instance and feed checkpoints are disabled")
+	}
+	codec, err := cursor.NewCodec(apiConfig.CursorSecret, apiConfig.CursorTTL)
+	if err != nil {
+		return nil, err
+	}
+	server.cursors = codec
+	// feed checkpoints are kept by consumers between polls, they never expire and need a secret that survives restarts
+	if apiConfig.CursorSecret != "" {
+		server.checkpoints, err = cursor.NewCodec(apiConfig.CursorSecret, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// routes matching the path but not the method get a JSON 405
+	server.router.MethodNotAllowedHandler = http.HandlerFunc(server.methodNotAllowed)
 
 	// serve static content
 	static := http.StripPrefix("/static/", http.FileServer(http.Dir("static")))
@@ -57,15 +270,42 @@ func New(listenAddr string, apiConfig APIConfig) (*Server, error) {
 		http.ServeFile(w, r, "static/favicon.ico")
 	}).Methods(http.MethodGet)
 
+	// health and readiness probes
+	server.router.HandleFunc("/health", server.healthHandler).Methods(http.MethodGet)
+	server.router.HandleFunc("/ready", server.readyHandler).Methods(http.MethodGet)
+
+	// error documentation, linked from every error response
+	server.router.HandleFunc(errorDocsPath, errorDocsHandler).Methods(http.MethodGet)
+
+	// metrics
+	server.router.HandleFunc("/debug/vars", server.debugVars).Methods(http.MethodGet)
+
+	// admin
+	server.Admin(http.MethodGet, "/status", server.adminStatusHandler)
+	server.Admin(http.MethodPost, "/maintenance", server.adminMaintenanceHandler)
+	server.Admin(http.MethodPost, "/reload", server.adminReloadHandler)
+	server.Admin(http.MethodPost, "/cache/flush", server.adminCacheFlushHandler)
+	server.Admin(http.MethodGet, "/ratelimit/{ip}", server.adminRateLimitHandler)
+	server.Admin(http.MethodDelete, "/ratelimit/{ip}", server.adminRateLimitResetHandler)
+	server.Admin(http.MethodGet, "/bans", server.adminBansHandler)
+	server.Admin(http.MethodGet, "/activity", server.adminActivityHandler)
+	server.Admin(http.MethodDelete, "/bans/{ip}", server.adminBanRemoveHandler)
+	server.Admin(http.MethodGet, "/jobs", server.adminJobsHandler)
+	server.Admin(http.MethodPost, "/jobs/{name}/run", server.adminJobRunHandler)
+	server.Admin(http.MethodGet, "/routes", server.adminRoutesHandler)
+	server.Admin(http.MethodPost, "/routes/{name}/disable", server.adminRouteHandler(true))
+	server.Admin(http.MethodPost, "/routes/{name}/enable", server.adminRouteHandler(false))
+
 	return server, nil
 }
 
 // Get registers a HTTP GET to the router & handler
-func (s *Server) Get(path string, fn http.HandlerFunc) {
-	s.router.Handle(path, fn).Methods(http.MethodGet)
-	s.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+// opts such as Deprecated apply to both the GET and HEAD requests of the route
+func (s *Server) Get(path string, fn http.HandlerFunc, opts ...RouteOption) {
+	s.router.Handle(path, s.routeHandler(path, fn, opts)).Methods(http.MethodGet)
+	s.router.HandleFunc(path, s.routeHandler(path, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
-	}).Methods(http.MethodHead)
+	}, opts)).Methods(http.MethodHead)
 }
 
 // Post registers a HTTP POST to the router & handler
@@ -73,37 +313,320 @@ func (s *Server) Post(path string, fn http.HandlerFunc) {
 	s.router.Handle(path, fn).Methods(http.MethodPost)
 }
 
-// Start Starts the server, blocking function
-func (s *Server) Start() error {
+// handlers builds the middleware chains of the public routes and of the admin API
+// it must only be called once, by Start or SelfTest
+func (s *Server) handlers() (public, admin http.Handler) {
 	timeoutDuration := time.Duration(s.apiConfig.APITimeout) * time.Second
+	// every route is registered by now
+	s.routeFlags.configure(s.apiConfig.DisabledRoutes)
+	// responses are tracked so that nothing is written after they are committed or timed out
+	s.router.Use(trackCommits)
+	// errors are written in the locale of Accept-Language, negotiated again below the timeout handler
+	s.router.Use(negotiateLocales)
+	// the metadata envelope is opted in to per request, and the request URL kept for its links
+	s.router.Use(negotiateEnvelope)
+	// responses are recorded with ?record=1 inside the envelope, whose meta carries the recording ID
+	s.router.Use(s.record)
+	// the database and cache work of a request is collected for the X-Debug-Stats header
+	s.router.Use(s.debugStats)
+	// requests with an API key are written to the audit log, sharing the debug stats collector for their rows
+	s.router.Use(s.audit)
+	// retried POST requests with an Idempotency-Key are answered with the first response, audited like others
+	s.router.Use(s.idempotent)
+	// tracing spans are started after routing so they are named after the route
+	s.router.Use(traceRoutes)
+	// handler panics are recovered inside the router so the report includes the route
+	s.router.Use(s.reportErrors)
+	// response sizes are recorded by route and limited
+	s.router.Use(s.measureResponses)
+	// expensive requests are shed while the served ones fail or are slow, the class is known once routed
+	s.router.Use(s.shedLoad)
 	// prep proxy handler
-	h := handlers.ProxyHeaders(s.router)
+	// paths are cleaned and name-bearing paths lower cased before routing
+	// methods no route accepts are rejected before the path is looked at
+	h := handlers.ProxyHeaders(rejectUnsupportedMethods(canonicalPaths(s.router)))
 	h = SetProxyURLHost(h)
-	// setup logging
-	h = handlers.LoggingHandler(os.Stdout, h)
-	// add recovery
-	h = handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(h)
+	// add recovery, the panic logger includes the stack
+	h = handlers.RecoveryHandler(handlers.RecoveryLogger(logging.PanicLogger()))(h)
+	// admin requests skip the timeout, cors and rate limiting, admin operations may run long
+	admin = h
+	// maintenance mode
+	h = s.maintenance.handler(h)
+	// api keys
+	h = s.zones.handler(h)
 	// timeouts
-	h = http.TimeoutHandler(h, timeoutDuration, ErrTimeout.Error())
-	// cors
-	h = handlers.CORS(handlers.AllowedOrigins([]string{"http://127.0.0.1:5353"}))(h)
+	h = s.timeout(h, timeoutDuration)
+	// cors, the origins of the tenant
+	h = s.cors(h)
+	// rate limiting, with the quota of the tenant
+	h = s.rateLimit(h)
+	// the activity by client and route, the rate limited requests included
+	h = s.trackActivity(h)
+	// the tenant of the Host header, read by the middleware above for its settings
+	h = s.tenants.handler(h)
+	return h, admin
+}
 
-	// rate limiting
-	// TODO add rate limiting after static handler and possible the main page
-	// TODO use IP set by proxyheaders!
-	throttleHandler := makeThrottleHandler(
-		s.apiConfig.APIRequestsPerMinute,
-		s.apiConfig.APIRequestsBurst,
-		s.apiConfig.APIMaxRequestHistory,
-	)
-	h = throttleHandler(h)
+// outer wraps h in the middleware every listener runs first
+// untrusted forwarding headers are removed before anything, including the rate limiter, reads the client IP
+// every request then gets its ID and is access logged, including those rejected by the limiters below,
+// errors are written in the locale of Accept-Language from then on,
+// banned clients are rejected before any other work is done, followed by requests over the in-flight limits
+// and every other response, including errors, carries the version header
+func (s *Server) outer(h http.Handler) http.Handler {
+	return keepConnWriter(countProtocols(s.stripUntrustedProxyHeaders(requestID(s.accessLog.handler(negotiateLocales(s.bans.handler(s.inflight.handler(versionHeader(h)))))))))
+}
 
-	// run server
-	srv := &http.Server{
-		Handler:      h,
-		Addr:         s.listenAddr,
+// Start Starts the server, blocking function
+// every listen address is bound before anything is served, a failure to bind one is returned at once
+// returns the first error of any of its listeners, the other listeners are closed after a failure
+func (s *Server) Start() error {
+	timeoutDuration := time.Duration(s.apiConfig.APITimeout) * time.Second
+	h, admin := s.handlers()
+
+	listeners := make([]net.Listener, 0, len(s.listenAddrs))
+	bound := make([]string, 0, len(s.listenAddrs))
+	for _, addr := range s.listenAddrs {
+		ln, err := net.Listen(listenNetwork(addr), addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+		bound = append(bound, ln.Addr().String())
+		logging.Infof("listening on %s", ln.Addr())
+	}
+
+	// one server serves every listener, its Shutdown closes them all
+	mainServer := &http.Server{
 		WriteTimeout: timeoutDuration,
 		ReadTimeout:  timeoutDuration,
+		IdleTimeout:  s.idleTimeout(),
+		ConnState:    s.conns.hook,
+	}
+	servers := []*http.Server{mainServer}
+	if s.apiConfig.AdminListen == "" {
+		mainServer.Handler = s.outer(splitAdmin(admin, h))
+	} else {
+		mainServer.Handler = s.outer(splitAdmin(http.HandlerFunc(notFoundJSON), h))
+		adminServer, err := s.adminServer(s.outer(splitAdmin(admin, http.HandlerFunc(notFoundJSON))))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		servers = append(servers, adminServer)
+	}
+	// the main listeners serve cleartext, HTTP/2 needs h2c there
+	if s.apiConfig.H2C {
+		mainServer.Handler = s.serveH2C(mainServer.Handler)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		for _, l := range listeners {
+			l.Close()
+		}
+		return http.ErrServerClosed
+	}
+	s.boundAddrs = bound
+	s.httpServers = servers
+	// started while holding mu so that a Shutdown from now on sees the jobs to stop
+	s.startJobs()
+	s.mu.Unlock()
+
+	// run servers
+	errc := make(chan error, len(listeners)+len(servers))
+	for _, srv := range servers {
+		for _, fn := range s.shutdownHooks {
+			srv.RegisterOnShutdown(fn)
+		}
+	}
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errc <- mainServer.Serve(ln)
+		}(ln)
+	}
+	for _, srv := range servers[1:] {
+		go func(srv *http.Server) {
+			if srv.TLSConfig != nil {
+				errc <- srv.ListenAndServeTLS(s.apiConfig.AdminTLSCert, s.apiConfig.AdminTLSKey)
+				return
+			}
+			errc <- srv.ListenAndServe()
+		}(srv)
+	}
+	err := <-errc
+	if err != http.ErrServerClosed {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	return err
+}
+
+// listenNetwork returns tcp4 or tcp6 for the addresses of an IP literal and tcp otherwise
+// an IPv6 wildcard is then bound to IPv6 alone and an IPv4 address of the same port can be listened on next to it
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// ListenAddrs returns the addresses the main listeners are bound to, empty until Start bound them
+func (s *Server) ListenAddrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.boundAddrs == nil {
+		return []string{}
+	}
+	return s.boundAddrs
+}
+
+// adminServer creates the separate admin listener, served over TLS when a certificate is configured
+func (s *Server) adminServer(h http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Handler:     h,
+		Addr:        s.apiConfig.AdminListen,
+		ReadTimeout: time.Duration(s.apiConfig.APITimeout) * time.Second,
+		IdleTimeout: s.idleTimeout(),
+		ConnState:   s.conns.hook,
+	}
+	if s.apiConfig.AdminTLSCert == "" {
+		if s.apiConfig.AdminClientCA != "" {
+			return nil, errors.New("admin client CA requires an admin TLS certificate")
+		}
+		return srv, nil
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.apiConfig.AdminClientCA != "" {
+		pem, err := ioutil.ReadFile(s.apiConfig.AdminClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.apiConfig.AdminClientCA)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		// clients without a certificate may still use the bearer token
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	err := s.configureHTTP2(srv)
+	if err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// isAdminPath returns true for paths under /api/admin and /api/internal, which are served by the admin handler
+func isAdminPath(path string) bool {
+	return path == adminPrefix || strings.HasPrefix(path, adminPrefix+"/") ||
+		path == internalPrefix || strings.HasPrefix(path, internalPrefix+"/")
+}
+
+// splitAdmin sends requests under /api/admin and /api/internal to admin and everything else to public
+func splitAdmin(admin, public http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) {
+			admin.ServeHTTP(w, r)
+			return
+		}
+		public.ServeHTTP(w, r)
+	})
+}
+
+// Cursors returns the codec for the pagination cursors of list endpoints
+func (s *Server) Cursors() *cursor.Codec {
+	return s.cursors
+}
+
+// Checkpoints returns the codec for the feed checkpoints, nil without a cursor secret
+func (s *Server) Checkpoints() *cursor.Codec {
+	return s.checkpoints
+}
+
+// ZoneAccess returns the restricted zone checks for handlers serving per-domain data
+func (s *Server) ZoneAccess() *ZoneAccess {
+	return s.zones
+}
+
+// SetRateLimit changes the API rate limit quota of the running server, tenants with their own quota keep it
+func (s *Server) SetRateLimit(perMin, burst int) error {
+	return s.throttle.setQuota(perMin, burst)
+}
+
+// SetRateLimitMode changes the rate limiter mode of the running server, for every tenant
+func (s *Server) SetRateLimitMode(mode string) error {
+	for _, t := range s.tenants.throttles() {
+		if err := t.setMode(mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchRoute returns the path template of the route a request that has not been routed yet will match
+func (s *Server) matchRoute(r *http.Request) string {
+	var match mux.RouteMatch
+	if s.router.Match(r, &match) && match.Route != nil {
+		if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unrouted"
+}
+
+// SetReloader registers the function run by POST /api/admin/reload
+func (s *Server) SetReloader(fn func() (*model.ConfigReload, error)) {
+	s.reloader = fn
+}
+
+// OnShutdown registers a function to be called when the server is shut down
+// it must be called before Start
+func (s *Server) OnShutdown(fn func()) {
+	s.shutdownHooks = append(s.shutdownHooks, fn)
+}
+
+// Shutdown gracefully stops all listeners, Start returns http.ErrServerClosed once called
+// background jobs are stopped and queued error reports and audit records are sent before it returns
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	servers := s.httpServers
+	s.mu.Unlock()
+	var firstErr error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.stopJobs(ctx); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if s.reports != nil {
+		if err := s.reports.close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if s.audits != nil {
+		if err := s.audits.close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return srv.ListenAndServe()
+	return firstErr
 }
@@ -1,14 +1,20 @@
 package server
 
 import (
+	"dnscoffee/logging"
 	"dnscoffee/model"
-	"encoding/json"
-	"log"
+	"dnscoffee/version"
+	"expvar"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/mux"
 	"gopkg.in/throttled/throttled.v2"
-	"gopkg.in/throttled/throttled.v2/store/memstore"
 )
 
 // SetProxyURLHost
@@ -33,59 +39,343 @@ func neuterDirectoryListing(next http.Handler) http.Handler {
 	})
 }
 
-// creates a throttled handler using the perMin limit on requests
-func makeThrottleHandler(perMin, burst, storeSize int) func(http.Handler) http.Handler {
-	store, err := memstore.New(storeSize)
+// rate limiter modes
+const (
+	// rate limited requests are rejected
+	RateLimitEnforce = "enforce"
+	// rate limited requests are counted and logged but served
+	RateLimitShadow = "shadow"
+	// requests are not rate limited
+	RateLimitOff = "off"
+)
+
+// rate limited requests by route, enforced and in shadow mode
+var (
+	rateLimitEnforced = expvar.NewMap("ratelimit_enforced")
+	rateLimitShadowed = expvar.NewMap("ratelimit_shadow")
+)
+
+// ValidRateLimitMode returns true for the rate limiter modes
+func ValidRateLimitMode(mode string) bool {
+	return mode == RateLimitEnforce || mode == RateLimitShadow || mode == RateLimitOff
+}
+
+// throttle rate limits requests by client IP
+// the quota and mode can be changed at runtime, the bucket history is kept across changes
+type throttle struct {
+	store throttled.GCRAStore
+	// *rateQuota, the limiter of the current quota
+	limiter atomic.Value
+	// one of the RateLimit modes
+	mode atomic.Value
+	// called for every rejected request
+	onDenied func(*http.Request)
+	// names the route of a request for the metrics
+	routeOf func(*http.Request) string
+}
+
+// rateQuota is a quota and its limiter, replaced together so that a denial names the quota that denied it
+type rateQuota struct {
+	limiter       *throttled.GCRARateLimiter
+	perMin, burst int
+}
+
+// exceededMeta returns the meta entries of a denial, the quota in requests per window and the burst on top of it
+func (q *rateQuota) exceededMeta() map[string]string {
+	return map[string]string{
+		"limit":          strconv.Itoa(q.perMin),
+		"window_seconds": "60",
+		"burst":          strconv.Itoa(q.burst),
+	}
+}
+
+// creates a throttled handler using the perMin limit on requests, keeping the buckets of storeSize clients in shards shards
+// its store is exported under name, onDenied is called for every rejected request, routeOf names the route of a request
+func makeThrottleHandler(name string, perMin, burst, storeSize, shards int, mode string, onDenied func(*http.Request), routeOf func(*http.Request) string) *throttle {
+	t := &throttle{store: newRateLimitStore(name, storeSize, shards), onDenied: onDenied, routeOf: routeOf}
+	err := t.setQuota(perMin, burst)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("rate limiter: %s", err)
+	}
+	err = t.setMode(mode)
+	if err != nil {
+		logging.Fatalf("rate limiter: %s", err)
+	}
+	return t
+}
+
+// setMode changes the rate limiter mode, an empty mode is RateLimitEnforce
+func (t *throttle) setMode(mode string) error {
+	mode, err := checkRateLimitMode(mode)
+	if err != nil {
+		return err
+	}
+	t.mode.Store(mode)
+	return nil
+}
+
+// checkRateLimitMode returns mode, RateLimitEnforce if it is empty, or an error for an unknown mode
+func checkRateLimitMode(mode string) (string, error) {
+	if mode == "" {
+		return RateLimitEnforce, nil
+	}
+	if !ValidRateLimitMode(mode) {
+		return "", fmt.Errorf("unknown mode %q", mode)
+	}
+	return mode, nil
+}
+
+// CheckRateLimit returns the error SetRateLimit and SetRateLimitMode would return for the quota and mode,
+// without changing anything
+func CheckRateLimit(perMin, burst int, mode string) error {
+	if _, err := newRateLimiter(nil, perMin, burst); err != nil {
+		return err
+	}
+	_, err := checkRateLimitMode(mode)
+	return err
+}
+
+// decide counts the request against the bucket of its client IP, without the port, and returns whether it is over the quota
+func (t *throttle) decide(r *http.Request) (bool, throttled.RateLimitResult, error) {
+	return t.RateLimit(getIPAddress(r), 1)
+}
+
+// handler rate limits requests to next according to the mode
+// the X-RateLimit headers are sent in shadow mode too so that clients can test their backoff
+func (t *throttle) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := t.mode.Load().(string)
+		if mode == RateLimitOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limited, result, err := t.decide(r)
+		if err != nil {
+			logging.Errorf("rate limiter: %s", err)
+			WriteJSONError(w, ErrInternalServer)
+			return
+		}
+		setRateLimitHeaders(w, result)
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := t.routeOf(r)
+		if mode == RateLimitShadow {
+			rateLimitShadowed.Add(route, 1)
+			logging.Infof("rate limit shadow: would deny %s %s %s", getIPAddress(r), r.Method, route)
+			next.ServeHTTP(w, r)
+			return
+		}
+		rateLimitEnforced.Add(route, 1)
+		t.onDenied(r)
+		WriteRetryError(w, ErrLimitExceeded, result.RetryAfter, t.quota().exceededMeta())
+	})
+}
+
+// setRateLimitHeaders adds the X-RateLimit and Retry-After headers of result, as throttled.HTTPRateLimiter does
+func setRateLimitHeaders(w http.ResponseWriter, result throttled.RateLimitResult) {
+	if v := result.Limit; v >= 0 {
+		w.Header().Add("X-RateLimit-Limit", strconv.Itoa(v))
+	}
+	if v := result.Remaining; v >= 0 {
+		w.Header().Add("X-RateLimit-Remaining", strconv.Itoa(v))
+	}
+	if v := result.ResetAfter; v >= 0 {
+		w.Header().Add("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(v.Seconds()))))
+	}
+	if v := result.RetryAfter; v >= 0 {
+		w.Header().Add("Retry-After", strconv.Itoa(int(math.Ceil(v.Seconds()))))
+	}
+}
+
+// setQuota replaces the rate limit quota, requests already in progress keep the previous quota
+func (t *throttle) setQuota(perMin, burst int) error {
+	rateLimiter, err := newRateLimiter(t.store, perMin, burst)
+	if err != nil {
+		return err
+	}
+	t.limiter.Store(&rateQuota{limiter: rateLimiter, perMin: perMin, burst: burst})
+	return nil
+}
+
+// newRateLimiter returns a limiter of perMin requests a minute and burst on top of it keeping its buckets in store
+func newRateLimiter(store throttled.GCRAStore, perMin, burst int) (*throttled.GCRARateLimiter, error) {
+	// throttled.PerMin divides by perMin
+	if perMin <= 0 {
+		return nil, fmt.Errorf("requests per minute must be positive, not %d", perMin)
 	}
 	quota := throttled.RateQuota{
 		MaxRate:  throttled.PerMin(perMin),
 		MaxBurst: burst,
 	}
-	rateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return throttled.NewGCRARateLimiter(store, quota)
+}
+
+// quota returns the current quota
+func (t *throttle) quota() *rateQuota {
+	return t.limiter.Load().(*rateQuota)
+}
 
-	httpRateLimiter := throttled.HTTPRateLimiter{
-		RateLimiter: rateLimiter,
-		VaryBy:      new(ipVaryBy),
-		DeniedHandler: http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			WriteJSONError(w, ErrLimitExceeded)
-		})),
+// RateLimit implements throttled.RateLimiter with the current quota
+func (t *throttle) RateLimit(key string, quantity int) (bool, throttled.RateLimitResult, error) {
+	return t.quota().limiter.RateLimit(key, quantity)
+}
+
+// peek returns the current rate limit state for key without counting a request
+func (t *throttle) peek(key string) (bool, throttled.RateLimitResult, error) {
+	return t.RateLimit(key, 0)
+}
+
+// reset restores the full quota for key
+// returns false if the key had no rate limit state
+func (t *throttle) reset(key string) (bool, error) {
+	for {
+		tat, _, err := t.store.GetWithTime(key)
+		if err != nil || tat == -1 {
+			return false, err
+		}
+		// a theoretical arrival time in the past allows the full burst again
+		// retry if a request updated the bucket in between
+		swapped, err := t.store.CompareAndSwapWithTTL(key, tat, 0, time.Second)
+		if err != nil || swapped {
+			return swapped, err
+		}
 	}
+}
 
-	return httpRateLimiter.RateLimit
+// versionHeader adds the X-DNSCoffee-Version header to every response
+func versionHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-DNSCoffee-Version", version.Version)
+		next.ServeHTTP(w, r)
+	})
 }
 
 // 404 not found handler
-//func notFoundJSON(w http.ResponseWriter, r *http.Request) {
-//	WriteJSONError(w, ErrNotFound)
-//}
+func notFoundJSON(w http.ResponseWriter, r *http.Request) {
+	WriteJSONError(w, ErrNotFound)
+}
 
 // // HandlerNotImplemented returns ErrNotImplemented as JSON
 // func HandlerNotImplemented(w http.ResponseWriter, r *http.Request) {
 // 	WriteJSONError(w, ErrNotImplemented)
 // }
 
-// WriteJSONError returns an error as JSON
+// WriteJSONError returns an error as JSON, with the meta entries of this response added to a copy of it, see WithMeta
+// the title and detail are in the locale negotiated from Accept-Language, see localizeErrors
+// nothing is written when the response has already been committed or timed out
 // TODO make not all errors JSON
-func WriteJSONError(w http.ResponseWriter, jsonErr *model.JSONError) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(jsonErr.Status)
-	err := json.NewEncoder(w).Encode(model.JSONErrors{Errors: []*model.JSONError{jsonErr}})
+func WriteJSONError(w http.ResponseWriter, jsonErr *model.JSONError, meta ...map[string]string) {
+	if len(meta) > 0 {
+		jsonErr = WithMeta(jsonErr, meta...)
+	}
+	if committed(w) {
+		logging.Debugf("not writing error %s, response already committed", jsonErr.ID)
+		return
+	}
+	_, err := writeJSONBody(w, jsonErr.Status, model.JSONErrors{Errors: localizeErrors(w, []*model.JSONError{jsonErr})}, 0)
 	if err != nil {
-		panic(err)
+		writeFailed(w, err)
 	}
 }
 
+// WriteJSONErrors answers with several errors of the same status at once, such as every invalid field of a request body
+func WriteJSONErrors(w http.ResponseWriter, jsonErrs []*model.JSONError) {
+	if committed(w) {
+		logging.Debugf("not writing error %s, response already committed", jsonErrs[0].ID)
+		return
+	}
+	_, err := writeJSONBody(w, jsonErrs[0].Status, model.JSONErrors{Errors: localizeErrors(w, jsonErrs)}, 0)
+	if err != nil {
+		writeFailed(w, err)
+	}
+}
+
+// retryAfterMeta is the meta entry of WriteRetryError, the Retry-After header in seconds
+const retryAfterMeta = "retry_after_seconds"
+
+// retrySeconds returns retry rounded up to whole seconds, at least 1 so that clients never retry at once
+func retrySeconds(retry time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(retry.Seconds()))))
+}
+
+// WriteRetryError answers jsonErr with a Retry-After header of retry and the same figure in meta.retry_after_seconds
+// for the rate limit and overload errors, so that clients can back off without parsing the header
+func WriteRetryError(w http.ResponseWriter, jsonErr *model.JSONError, retry time.Duration, meta ...map[string]string) {
+	seconds := retrySeconds(retry)
+	w.Header().Set("Retry-After", seconds)
+	WriteJSONError(w, jsonErr, append(meta, map[string]string{retryAfterMeta: seconds})...)
+}
+
 // WriteJSON writes JSON from data to the response
+// responses over the response size limit are replaced with ErrResponseTooLarge
 func WriteJSON(w http.ResponseWriter, data model.APIData) {
+	WriteJSONStatus(w, http.StatusOK, data)
+}
+
+// WriteJSONStatus writes JSON from data to the response with a success status other than 200
+func WriteJSONStatus(w http.ResponseWriter, status int, data model.APIData) {
+	writeJSONStatus(w, status, data, nil)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, data model.APIData, opts []MetaOption) {
 	data.GenerateMetaData()
-	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(model.JSONResponse{Data: data})
-	if err != nil && err != http.ErrHandlerTimeout {
-		panic(err)
+	sw, measured := findSizeWriter(w)
+	limit := 0
+	if measured {
+		limit = sw.limit
+	}
+	size, err := writeJSONBody(w, status, responseBody(w, data, opts), limit)
+	if err == errResponseTooLarge {
+		responsesTooLarge.Add(1)
+		logging.Warnf("response for %s too large: %d bytes, limit %d", sw.route, size, limit)
+		WriteJSONError(w, ErrResponseTooLarge)
+		return
+	}
+	if err != nil {
+		writeFailed(w, err)
+	}
+}
+
+// WriteBody writes an encoded response body other than JSON, such as a CSV page, with a 200
+func WriteBody(w http.ResponseWriter, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		writeFailed(w, err)
+	}
+}
+
+// routeName returns the path template of the request's route, or the path if it was not routed
+func routeName(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tmpl, err := cr.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// RouteRateLimit limits every client IP to perMin requests a minute to next, with bursts of burst,
+// on top of the quota of the whole API, for routes that are expensive to serve or make outbound requests
+// it is always enforced, rejected requests count toward a ban like those over the API quota
+// the store of its buckets is exported under name
+func (s *Server) RouteRateLimit(name string, perMin, burst int, next http.HandlerFunc) http.HandlerFunc {
+	t := makeThrottleHandler(name, perMin, burst, s.apiConfig.APIMaxRequestHistory, s.apiConfig.RateLimitStoreShards, RateLimitEnforce, nil, routeName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		limited, result, err := t.decide(r)
+		if err != nil {
+			logging.Errorf("route rate limiter: %s", err)
+			WriteJSONError(w, ErrInternalServer)
+			return
+		}
+		if !limited {
+			next(w, r)
+			return
+		}
+		rateLimitEnforced.Add(t.routeOf(r), 1)
+		s.bans.strike(getIPAddress(r))
+		WriteRetryError(w, ErrLimitExceeded, result.RetryAfter, t.quota().exceededMeta())
 	}
 }
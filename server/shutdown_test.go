@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testAPIConfig is the smallest config New accepts
+var testAPIConfig = APIConfig{
+	APITimeout:           5,
+	APIRequestsPerMinute: 60,
+	APIRequestsBurst:     10,
+	APIMaxRequestHistory: 100,
+}
+
+// TestNewTwice builds two servers in one process, as tests and embeddings do
+func TestNewTwice(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		if _, err := New([]string{"127.0.0.1:0"}, testAPIConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestShutdownDuringStart shuts the server down while Start may still be setting it up
+// Start returns http.ErrServerClosed whether Shutdown came before or after its servers were registered
+func TestShutdownDuringStart(t *testing.T) {
+	s, err := New([]string{"127.0.0.1:0"}, testAPIConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Start()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != http.ErrServerClosed {
+			t.Errorf("Start returned %v, want %v", err, http.ErrServerClosed)
+		}
+	case <-ctx.Done():
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
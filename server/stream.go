@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+)
+
+// connWriterKey is the context key of the response writer of the connection, before any middleware wrapped it
+type connWriterKey struct{}
+
+// keepConnWriter keeps w in the request context for clearWriteDeadline
+// the writers of the access log can not be unwrapped, this must run before it
+func keepConnWriter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), connWriterKey{}, w)))
+	})
+}
+
+// clearWriteDeadline lifts the server's write timeout, the API timeout, off the response of r
+// with HTTP/2 only the deadline of the stream of r is cleared
+func clearWriteDeadline(r *http.Request) {
+	w, ok := r.Context().Value(connWriterKey{}).(http.ResponseWriter)
+	if !ok {
+		return
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logging.Warnf("stream %s: clearing the write deadline: %s", r.URL.Path, err)
+	}
+}
+
+// Stream registers a HTTP GET route whose response is sent as it is written
+// the timeout handler buffers whole responses, streamed routes skip it and the server's write timeout is lifted for them
+func (s *Server) Stream(path string, fn http.HandlerFunc, opts ...RouteOption) {
+	if s.streaming == nil {
+		s.streaming = make(map[string]bool)
+	}
+	s.streaming[path] = true
+	s.router.Handle(path, s.routeHandler(path, fn, opts)).Methods(http.MethodGet)
+}
+
+// timeout wraps h in a http.TimeoutHandler of d, except for the routes registered with Stream, which have no deadline
+// a DeadlineHeader shorter than d sets the deadline of the request instead, the timeout response names whose deadline passed
+func (s *Server) timeout(h http.Handler, d time.Duration) http.Handler {
+	body, err := json.Marshal(model.JSONErrors{Errors: []*model.JSONError{ErrTimeout}})
+	if err != nil {
+		panic(err)
+	}
+	th := http.TimeoutHandler(h, d, string(body))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.streaming[s.matchRoute(r)] {
+			clearWriteDeadline(r)
+			h.ServeHTTP(w, r)
+			return
+		}
+		deadline, jsonErr := requestDeadline(r, d)
+		if jsonErr != nil {
+			WriteJSONError(w, jsonErr)
+			return
+		}
+		dw := &deadlineWriter{ResponseWriter: w}
+		if deadline < d {
+			ctx, cancel := context.WithTimeout(r.Context(), deadline)
+			defer cancel()
+			dw.client = ctx
+			r = r.WithContext(ctx)
+		}
+		th.ServeHTTP(dw, r)
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+)
+
+// TestStreamWriteDeadline streams a response for longer than the server's write timeout
+func TestStreamWriteDeadline(t *testing.T) {
+	s := &Server{router: mux.NewRouter(), apiConfig: APIConfig{MaxConcurrentStreams: 10, APITimeout: 5}}
+	s.Stream("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("second"))
+	})
+	ts := httptest.NewUnstartedServer(s.serveH2C(keepConnWriter(s.timeout(s.router, time.Second))))
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	h2Client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	for name, client := range map[string]*http.Client{"HTTP/1": ts.Client(), "h2c": h2Client} {
+		resp, err := client.Get(ts.URL + "/stream")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "first second" {
+			t.Errorf("%s: got %q, %v", name, body, err)
+		}
+	}
+}
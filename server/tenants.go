@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/handlers"
+)
+
+// DefaultTenant is the name of the tenant of the requests whose Host matches no configured tenant
+const DefaultTenant = "default"
+
+// defaultCORSOrigins are the origins allowed by the default tenant
+var defaultCORSOrigins = []string{"http://127.0.0.1:5353"}
+
+// tenantRequests counts the requests served by tenant name
+var tenantRequests = expvar.NewMap("tenant_requests")
+
+// Tenant overrides settings of the API for the requests sent to its hosts, zero values keep the API's settings
+type Tenant struct {
+	Name string
+	// Host header values, without the port and case-insensitive
+	Hosts []string
+	// rate limit quota, with buckets kept apart from the other tenants
+	RequestsPerMinute int
+	RequestsBurst     int
+	// replace the restricted zones of the API when not nil, an empty list restricts no zone
+	RestrictedZones []RestrictedZone
+	// replace the allowed CORS origins when not nil
+	CORSOrigins []string
+	// DebugStatsOff, DebugStatsAdmin or DebugStatsAll, the debug stats mode of the API when empty
+	DebugStats string
+	// answers /debug/vars with a 404
+	HideDebugVars bool
+}
+
+// tenant is the resolved settings of a Tenant
+type tenant struct {
+	name     string
+	throttle *throttle
+	// nil uses the restricted zones of the ZoneAccess
+	restricted *restrictions
+	cors       []string
+	debugStats string
+	debugVars  bool
+}
+
+type tenantKey struct{}
+
+// tenantSet resolves the tenant of a request from its Host header
+type tenantSet struct {
+	byHost map[string]*tenant
+	def    *tenant
+	// every tenant, the default first
+	all []*tenant
+}
+
+// newTenantSet returns the default tenant running with the API settings, and the configured tenants
+// tenants without their own quota share the default rate limiter and its buckets
+func (s *Server) newTenantSet(tenants []Tenant) *tenantSet {
+	def := &tenant{
+		name:       DefaultTenant,
+		throttle:   s.throttle,
+		cors:       defaultCORSOrigins,
+		debugStats: s.apiConfig.DebugStats,
+		debugVars:  true,
+	}
+	ts := &tenantSet{byHost: make(map[string]*tenant), def: def, all: []*tenant{def}}
+	for _, cfg := range tenants {
+		t := &tenant{
+			name:       cfg.Name,
+			throttle:   def.throttle,
+			cors:       def.cors,
+			debugStats: def.debugStats,
+			debugVars:  !cfg.HideDebugVars,
+		}
+		if cfg.RequestsPerMinute > 0 {
+			burst := cfg.RequestsBurst
+			if burst == 0 {
+				burst = s.apiConfig.APIRequestsBurst
+			}
+			t.throttle = makeThrottleHandler("api_"+cfg.Name, cfg.RequestsPerMinute, burst, s.apiConfig.APIMaxRequestHistory,
+				s.apiConfig.RateLimitStoreShards, s.apiConfig.RateLimitMode, def.throttle.onDenied, def.throttle.routeOf)
+		}
+		if cfg.RestrictedZones != nil {
+			t.restricted = restrictedZones(cfg.RestrictedZones)
+		}
+		if cfg.CORSOrigins != nil {
+			t.cors = cfg.CORSOrigins
+		}
+		if cfg.DebugStats != "" {
+			t.debugStats = cfg.DebugStats
+		}
+		for _, host := range cfg.Hosts {
+			ts.byHost[normalizeHost(host)] = t
+		}
+		ts.all = append(ts.all, t)
+	}
+	return ts
+}
+
+// normalizeHost returns the host of a Host header without the port, lower cased and without a trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// resolve returns the tenant of host, the default tenant for unknown hosts
+func (ts *tenantSet) resolve(host string) *tenant {
+	if t, ok := ts.byHost[normalizeHost(host)]; ok {
+		return t
+	}
+	return ts.def
+}
+
+// handler attaches the tenant of the request's Host to its context for the middleware after it
+func (ts *tenantSet) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the forwarding headers left by stripUntrustedProxyHeaders come from a trusted proxy
+		host := r.Header.Get("X-Forwarded-Host")
+		if host == "" {
+			host = r.Host
+		}
+		t := ts.resolve(host)
+		tenantRequests.Add(t.name, 1)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	})
+}
+
+// throttles returns the distinct rate limiters of the tenants
+func (ts *tenantSet) throttles() []*throttle {
+	seen := make(map[*throttle]bool, len(ts.all))
+	var out []*throttle
+	for _, t := range ts.all {
+		if !seen[t.throttle] {
+			seen[t.throttle] = true
+			out = append(out, t.throttle)
+		}
+	}
+	return out
+}
+
+// tenantOf returns the tenant of the request, the default tenant outside of the tenant middleware such as on the admin API
+func (s *Server) tenantOf(ctx context.Context) *tenant {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return s.tenants.def
+}
+
+// TenantName returns the name of the tenant the request was sent to
+func (s *Server) TenantName(ctx context.Context) string {
+	return s.tenantOf(ctx).name
+}
+
+// rateLimit rate limits requests with the limiter of their tenant
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	handlers := make(map[*throttle]http.Handler)
+	for _, t := range s.tenants.throttles() {
+		handlers[t] = t.handler(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers[s.tenantOf(r.Context()).throttle].ServeHTTP(w, r)
+	})
+}
+
+// cors allows the cross-origin requests of the origins of the request's tenant
+func (s *Server) cors(next http.Handler) http.Handler {
+	byTenant := make(map[*tenant]http.Handler, len(s.tenants.all))
+	for _, t := range s.tenants.all {
+		byTenant[t] = handlers.CORS(handlers.AllowedOrigins(t.cors), handlers.AllowedHeaders([]string{DeadlineHeader, EnvelopeHeader}),
+			handlers.ExposedHeaders([]string{DeadlineExceededHeader, NextCursorHeader, CountHeader, RecordingHeader, "Link"}))(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byTenant[s.tenantOf(r.Context())].ServeHTTP(w, r)
+	})
+}
+
+// debugVars serves the expvar metrics unless the request's tenant hides them
+func (s *Server) debugVars(w http.ResponseWriter, r *http.Request) {
+	if !s.tenantOf(r.Context()).debugVars {
+		notFoundJSON(w, r)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
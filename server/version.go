@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// apiPrefix is the prefix of every API route
+const apiPrefix = "/api"
+
+// DefaultAPIVersion is the version served by the unversioned /api routes
+const DefaultAPIVersion = 1
+
+// versionPrefix matches the version segment of a versioned API path
+var versionPrefix = regexp.MustCompile(`^/api/v[0-9]+(/|$)`)
+
+type apiVersionKey struct{}
+
+// APIVersion registers the routes of a version of the API under /api/v{version}
+// version 1 is also served under /api for the clients written before versioning
+type APIVersion struct {
+	s       *Server
+	version int
+}
+
+// Version returns the registrar of the routes of an API version, versions start at 1
+func (s *Server) Version(version int) *APIVersion {
+	if version < 1 {
+		panic(fmt.Sprintf("api version %d", version))
+	}
+	return &APIVersion{s: s, version: version}
+}
+
+// Prefix returns the path prefix of the version, ex: /api/v2
+func (v *APIVersion) Prefix() string {
+	return fmt.Sprintf("%s/v%d", apiPrefix, v.version)
+}
+
+// paths returns the paths path, relative to /api, is served at
+func (v *APIVersion) paths(path string) []string {
+	paths := []string{v.Prefix() + path}
+	if v.version == DefaultAPIVersion {
+		paths = append(paths, apiPrefix+path)
+	}
+	return paths
+}
+
+// handler makes the version available to fn and the response writers
+func (v *APIVersion) handler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiVersionKey{}, v.version)
+		fn(&versionWriter{ResponseWriter: w, version: v.version}, r.WithContext(ctx))
+	}
+}
+
+// Get registers a HTTP GET of the version, path is relative to /api
+func (v *APIVersion) Get(path string, fn http.HandlerFunc, opts ...RouteOption) {
+	for _, p := range v.paths(path) {
+		v.s.Get(p, v.handler(fn), opts...)
+	}
+}
+
+// Stream registers a streaming HTTP GET of the version, path is relative to /api
+func (v *APIVersion) Stream(path string, fn http.HandlerFunc, opts ...RouteOption) {
+	for _, p := range v.paths(path) {
+		v.s.Stream(p, v.handler(fn), opts...)
+	}
+}
+
+// Handle registers a route of the version for another method than GET, path is relative to /api
+func (v *APIVersion) Handle(method, path string, fn http.HandlerFunc, opts ...RouteOption) {
+	for _, p := range v.paths(path) {
+		v.s.router.Handle(p, v.s.routeHandler(p, v.handler(fn), opts)).Methods(method)
+	}
+}
+
+// RequestAPIVersion returns the API version of the request's route, DefaultAPIVersion outside of the API
+func RequestAPIVersion(ctx context.Context) int {
+	if version, ok := ctx.Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return DefaultAPIVersion
+}
+
+// versionWriter carries the API version of a response to WriteJSON
+type versionWriter struct {
+	http.ResponseWriter
+	version int
+}
+
+// Unwrap returns the wrapped writer
+func (vw *versionWriter) Unwrap() http.ResponseWriter {
+	return vw.ResponseWriter
+}
+
+// Flush sends any buffered data to the client, streamed routes are versioned too
+func (vw *versionWriter) Flush() {
+	if f, ok := vw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// responseVersion returns the API version of the response written to w
+func responseVersion(w http.ResponseWriter) int {
+	for {
+		if vw, ok := w.(*versionWriter); ok {
+			return vw.version
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return DefaultAPIVersion
+		}
+		w = u.Unwrap()
+	}
+}
+
+// unversionedPath returns path with the version segment of a versioned API path removed
+// ex: /api/v2/domains/example.com is /api/domains/example.com
+func unversionedPath(path string) string {
+	loc := versionPrefix.FindStringIndex(path)
+	if loc == nil {
+		return path
+	}
+	rest := path[loc[1]:]
+	if rest == "" {
+		return apiPrefix
+	}
+	return apiPrefix + "/" + rest
+}
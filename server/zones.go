@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"sort"
+	"strings"
+
+	"dnscoffee/logging"
+	"dnscoffee/model"
+)
+
+// apiKeyHeader is the request header carrying an API key
+const apiKeyHeader = "X-API-Key"
+
+// APIKey grants its scopes to the requests carrying it in the X-API-Key header
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// RestrictedZone is a zone whose per-domain data is only served to API keys with one of its scopes
+// a Source in place of the Zone restricts every zone whose latest import came from that source, such as czds,
+// see SetSources; a rule naming the zone takes precedence over that of its source
+type RestrictedZone struct {
+	Zone   string
+	Source string
+	Scopes []string
+}
+
+// restrictions are the scopes required by upper case zone name and by source
+type restrictions struct {
+	zones   map[string][]string
+	sources map[string][]string
+}
+
+func (rs *restrictions) empty() bool {
+	return len(rs.zones) == 0 && len(rs.sources) == 0
+}
+
+// apiKey is a configured key, looked up by the hash of the key so the keys are compared in constant time
+type apiKey struct {
+	name   string
+	scopes map[string]bool
+}
+
+// scopesKey is the context key of the *apiKey authenticating the request
+type scopesKey struct{}
+
+// ZoneAccess decides which zones' per-domain data a request may read
+// every handler serving domain listings or lookups checks names through it
+type ZoneAccess struct {
+	keys       map[[sha256.Size]byte]*apiKey
+	restricted *restrictions
+	// the restricted zones of the tenants replacing them, see Restricted
+	tenantRestricted []*restrictions
+	// where to ask for access, added to ErrForbiddenZone
+	contact string
+	// the source of the latest import by upper case zone name, nil until SetSources
+	sources func(ctx context.Context) (map[string]string, error)
+}
+
+func newZoneAccess(keys []APIKey, zones []RestrictedZone, contact string) *ZoneAccess {
+	za := &ZoneAccess{
+		keys:       make(map[[sha256.Size]byte]*apiKey, len(keys)),
+		restricted: restrictedZones(zones),
+		contact:    contact,
+	}
+	for _, k := range keys {
+		key := &apiKey{name: k.Name, scopes: make(map[string]bool, len(k.Scopes))}
+		for _, s := range k.Scopes {
+			key.scopes[s] = true
+		}
+		za.keys[sha256.Sum256([]byte(k.Key))] = key
+	}
+	return za
+}
+
+// restrictedZones returns the scopes of the zones by normalized name, and of the sources
+func restrictedZones(zones []RestrictedZone) *restrictions {
+	rs := &restrictions{zones: make(map[string][]string, len(zones)), sources: make(map[string][]string)}
+	for _, z := range zones {
+		if z.Source != "" {
+			rs.sources[z.Source] = z.Scopes
+		} else {
+			rs.zones[normalizeZone(z.Zone)] = z.Scopes
+		}
+	}
+	return rs
+}
+
+// SetSources sets the lookup of the source of the latest import of every zone, for the restrictions by source
+// it is called once before the server starts; without it the sources restrict no zone
+func (za *ZoneAccess) SetSources(sources func(ctx context.Context) (map[string]string, error)) {
+	za.sources = sources
+}
+
+// normalizeZone returns the zone name as stored in the database, upper case without the trailing dot
+func normalizeZone(zone string) string {
+	return strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(zone), "."))
+}
+
+// handler authenticates the API key of the request and attaches it to the request context
+// requests without a key are anonymous, requests with an unknown key are rejected with ErrForbidden
+func (za *ZoneAccess) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(apiKeyHeader)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := za.keys[sha256.Sum256([]byte(token))]
+		if !ok {
+			WriteJSONError(w, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopesKey{}, key)))
+	})
+}
+
+// keyName returns the name of the API key of the request's header, empty for anonymous requests and unknown keys
+// it is for the middleware running before handler, which attaches the key to the context
+func (za *ZoneAccess) keyName(r *http.Request) string {
+	token := r.Header.Get(apiKeyHeader)
+	if token == "" {
+		return ""
+	}
+	if key, ok := za.keys[sha256.Sum256([]byte(token))]; ok {
+		return key.name
+	}
+	return ""
+}
+
+// restrictedFor returns the restricted zones of the request's tenant
+func (za *ZoneAccess) restrictedFor(ctx context.Context) *restrictions {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok && t.restricted != nil {
+		return t.restricted
+	}
+	return za.restricted
+}
+
+// zoneOf returns the zone of zones the name is in or below, and its value
+// the most specific zone wins, ex: EXAMPLE.CO.UK is checked against CO.UK before UK
+func zoneOf[V any](zones map[string]V, name string) (string, V, bool) {
+	var none V
+	if len(zones) == 0 {
+		return "", none, false
+	}
+	name = normalizeZone(name)
+	for name != "" {
+		if value, ok := zones[name]; ok {
+			return name, value, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return "", none, false
+}
+
+// restriction returns the restricted zone the name is in, the source restricting it if it is restricted by source,
+// and the scopes it requires
+// the name is restricted when the source of its zone can not be looked up while sources are restricted,
+// it is better refused than served against the terms of its source
+func (za *ZoneAccess) restriction(ctx context.Context, rs *restrictions, name string) (zone, source string, scopes []string, restricted bool) {
+	if rs == nil || rs.empty() {
+		return "", "", nil, false
+	}
+	if zone, scopes, ok := zoneOf(rs.zones, name); ok {
+		return zone, "", scopes, true
+	}
+	if len(rs.sources) == 0 || za.sources == nil {
+		return "", "", nil, false
+	}
+	sources, err := za.sources(ctx)
+	if err != nil {
+		logging.Warnf("zone sources: %s", err)
+		return "", "", nil, true
+	}
+	zone, source, ok := zoneOf(sources, name)
+	if !ok {
+		return "", "", nil, false
+	}
+	scopes, restricted = rs.sources[source]
+	return zone, source, scopes, restricted
+}
+
+// allowed reports if the request's API key has one of the scopes
+func allowed(ctx context.Context, scopes []string) bool {
+	key, _ := ctx.Value(scopesKey{}).(*apiKey)
+	if key == nil {
+		return false
+	}
+	for _, s := range scopes {
+		if key.scopes[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestAPIKey returns the name of the API key the request carries, empty for anonymous requests
+func RequestAPIKey(ctx context.Context) string {
+	if key, _ := ctx.Value(scopesKey{}).(*apiKey); key != nil {
+		return key.name
+	}
+	return ""
+}
+
+// Check returns ErrForbiddenZone if name is in a restricted zone the request's API key has no scope for
+// the restricted zones are those of the request's tenant, the meta names the zone and the source restricting it
+func (za *ZoneAccess) Check(r *http.Request, name string) *model.JSONError {
+	zone, source, scopes, restricted := za.restriction(r.Context(), za.restrictedFor(r.Context()), name)
+	if !restricted || allowed(r.Context(), scopes) {
+		return nil
+	}
+	jsonErr := *ErrForbiddenZone
+	jsonErr.Meta = map[string]string{}
+	if zone != "" {
+		jsonErr.Meta["zone"] = zone
+	}
+	if source != "" {
+		jsonErr.Meta["source"] = source
+	}
+	if za.contact != "" {
+		jsonErr.Meta["contact"] = za.contact
+	}
+	return &jsonErr
+}
+
+// Restricted returns true if name is in a restricted zone of any tenant, for data served outside of a request
+func (za *ZoneAccess) Restricted(name string) bool {
+	ctx := context.Background()
+	if _, _, _, restricted := za.restriction(ctx, za.restricted, name); restricted {
+		return true
+	}
+	for _, rs := range za.tenantRestricted {
+		if _, _, _, restricted := za.restriction(ctx, rs, name); restricted {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopes returns true if the request carries an API key with scopes or is sent to a tenant with its own restricted zones,
+// it may read other domains than anonymous requests of the default tenant
+func (za *ZoneAccess) HasScopes(r *http.Request) bool {
+	return cacheKey(r.Context()) != ""
+}
+
+// FilterDomains removes the domains in restricted zones the request's API key has no scope for
+func (za *ZoneAccess) FilterDomains(r *http.Request, domains []*model.Domain) []*model.Domain {
+	if za.restrictedFor(r.Context()).empty() {
+		return domains
+	}
+	out := domains[:0]
+	for _, d := range domains {
+		if za.Check(r, d.Name) == nil {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// cacheKey returns a suffix distinguishing cached responses by the scopes of the request's API key
+// and the tenant when it has its own restricted zones, responses filtered by FilterDomains differ between them
+func cacheKey(ctx context.Context) string {
+	prefix := ""
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok && t.restricted != nil {
+		prefix = "\x00tenant=" + t.name
+	}
+	key, _ := ctx.Value(scopesKey{}).(*apiKey)
+	if key == nil || len(key.scopes) == 0 {
+		return prefix
+	}
+	scopes := make([]string, 0, len(key.scopes))
+	for s := range key.scopes {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return prefix + "\x00" + strings.Join(scopes, ",")
+}
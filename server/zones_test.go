@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dnscoffee/model"
+
+	"github.com/gorilla/mux"
+)
+
+// zoneAccessHandler serves a domain lookup checked by za, as the app's domain handlers do
+func zoneAccessHandler(za *ZoneAccess) http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/domains/{domain}", func(w http.ResponseWriter, r *http.Request) {
+		if jsonErr := za.Check(r, mux.Vars(r)["domain"]); jsonErr != nil {
+			WriteJSONError(w, jsonErr)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return za.handler(router)
+}
+
+func TestZoneAccess(t *testing.T) {
+	za := newZoneAccess(
+		[]APIKey{{Name: "research", Key: "secret-research", Scopes: []string{"czds"}}, {Name: "other", Key: "secret-other", Scopes: []string{"other"}}},
+		[]RestrictedZone{{Zone: "com.", Scopes: []string{"czds"}}, {Source: "czds", Scopes: []string{"czds"}}},
+		"access@example.com",
+	)
+	za.SetSources(func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"NET": "czds", "ORG": "axfr"}, nil
+	})
+	h := zoneAccessHandler(za)
+	tests := []struct {
+		name   string
+		domain string
+		key    string
+		want   int
+		// the code and meta of an error
+		code string
+		meta map[string]string
+	}{
+		{name: "unrestricted", domain: "example.org", want: http.StatusOK},
+		{name: "restricted zone", domain: "example.com", want: http.StatusForbidden, code: "forbidden_zone", meta: map[string]string{"zone": "COM", "contact": "access@example.com"}},
+		{name: "restricted source", domain: "example.net", want: http.StatusForbidden, code: "forbidden_zone", meta: map[string]string{"zone": "NET", "source": "czds", "contact": "access@example.com"}},
+		{name: "scoped key", domain: "example.com", key: "secret-research", want: http.StatusOK},
+		{name: "scoped key by source", domain: "www.example.net", key: "secret-research", want: http.StatusOK},
+		{name: "key without the scope", domain: "example.com", key: "secret-other", want: http.StatusForbidden, code: "forbidden_zone"},
+		{name: "unknown key", domain: "example.org", key: "guess", want: http.StatusForbidden, code: "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/domains/"+tt.domain, nil)
+			if tt.key != "" {
+				r.Header.Set(apiKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body model.JSONErrors
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].ID != tt.code {
+				t.Fatalf("got %s, want the error %s", w.Body, tt.code)
+			}
+			for k, v := range tt.meta {
+				if body.Errors[0].Meta[k] != v {
+					t.Errorf("meta %s is %q, want %q", k, body.Errors[0].Meta[k], v)
+				}
+			}
+		})
+	}
+
+	// a source that can not be looked up restricts every zone rather than serve one against its terms
+	za.SetSources(func(ctx context.Context) (map[string]string, error) { return nil, errors.New("unavailable") })
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/domains/example.org", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d without the sources, want %d", w.Code, http.StatusForbidden)
+	}
+	if ErrForbiddenZone.Meta != nil {
+		t.Error("the shared error was changed")
+	}
+}
+
+func TestFilterDomains(t *testing.T) {
+	za := newZoneAccess([]APIKey{{Name: "research", Key: "secret", Scopes: []string{"czds"}}}, []RestrictedZone{{Zone: "COM", Scopes: []string{"czds"}}}, "")
+	domains := func() []*model.Domain {
+		return []*model.Domain{{Name: "A.COM"}, {Name: "B.ORG"}, {Name: "C.COM"}}
+	}
+	var names []string
+	h := za.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names = names[:0]
+		for _, d := range za.FilterDomains(r, domains()) {
+			names = append(names, d.Name)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/feeds/new/date/2023-07-04", nil))
+	if len(names) != 1 || names[0] != "B.ORG" {
+		t.Errorf("anonymous request got %v, want [B.ORG]", names)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/feeds/new/date/2023-07-04", nil)
+	r.Header.Set(apiKeyHeader, "secret")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if len(names) != 3 {
+		t.Errorf("scoped request got %v, want every domain", names)
+	}
+}
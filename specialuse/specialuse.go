@@ -0,0 +1,123 @@
+// Package specialuse classifies the names under the special-use domains reserved by RFC 6761 and the RFCs after it,
+// such as localhost, test or onion, which resolvers answer themselves or never resolve
+//
+// Names are matched on label boundaries, the longest reserved suffix wins, ex: WWW.EXAMPLE.COM is under EXAMPLE.COM
+// but NOTEXAMPLE.COM is not. The table is the IANA registry embedded from names.txt.
+package specialuse
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+// the kinds of special-use names
+const (
+	Localhost      = "localhost"
+	Example        = "example"
+	Test           = "test"
+	Invalid        = "invalid"
+	Onion          = "onion"
+	Alt            = "alt"
+	Local          = "local"
+	PrivateReverse = "private-reverse"
+	Reserved       = "reserved"
+)
+
+// kinds are the known kinds of the table
+var kinds = map[string]bool{
+	Localhost: true, Example: true, Test: true, Invalid: true, Onion: true, Alt: true, Local: true, PrivateReverse: true, Reserved: true,
+}
+
+//go:embed names.txt
+var registry string
+
+// Name is a reserved special-use domain
+type Name struct {
+	// upper case without the trailing dot, as names are stored in the database
+	Domain string
+	Kind   string
+	// the RFC reserving it, ex: RFC6761
+	RFC string
+	// false for the names no public zone delegates, so that no zone file has them
+	Public bool
+}
+
+// Classifier maps names to the special-use domain they are under
+type Classifier struct {
+	names map[string]Name
+}
+
+// normalize returns the name as stored in the database, upper case without the trailing dot
+func normalize(name string) string {
+	return strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// Parse reads a table in the format of names.txt: a name, its kind, its RFC and either never or public per line,
+// separated by tabs or spaces, empty lines and those starting with # being skipped
+func Parse(table string) (*Classifier, error) {
+	c := &Classifier{names: make(map[string]Name)}
+	for i, line := range strings.Split(table, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("special-use line %d: %q has %d fields, want name, kind, RFC and zones", i+1, line, len(fields))
+		}
+		name := Name{Domain: normalize(fields[0]), Kind: fields[1], RFC: fields[2]}
+		if !kinds[name.Kind] {
+			return nil, fmt.Errorf("special-use line %d: unknown kind %q", i+1, name.Kind)
+		}
+		switch fields[3] {
+		case "never":
+		case "public":
+			name.Public = true
+		default:
+			return nil, fmt.Errorf("special-use line %d: zones %q must be never or public", i+1, fields[3])
+		}
+		if _, dup := c.names[name.Domain]; dup {
+			return nil, fmt.Errorf("special-use line %d: duplicate name %q", i+1, fields[0])
+		}
+		c.names[name.Domain] = name
+	}
+	return c, nil
+}
+
+// Default is the classifier of the embedded IANA registry
+var Default = mustParse(registry)
+
+// mustParse returns the classifier of the embedded table, which is fixed at build time
+func mustParse(table string) *Classifier {
+	c, err := Parse(table)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Classify returns the special-use domain name is or is under, false for the other names
+func (c *Classifier) Classify(name string) (Name, bool) {
+	name = normalize(name)
+	for name != "" {
+		if n, ok := c.names[name]; ok {
+			return n, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return Name{}, false
+}
+
+// Names returns the reserved names of the classifier
+func (c *Classifier) Names() []Name {
+	names := make([]Name, 0, len(c.names))
+	for _, n := range c.names {
+		names = append(names, n)
+	}
+	return names
+}
@@ -0,0 +1,140 @@
+package specialuse
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rfcNames is the IANA Special-Use Domain Names registry by RFC, written out apart from names.txt
+var rfcNames = map[string][]string{
+	"RFC6761": append([]string{
+		"localhost", "example", "example.com", "example.net", "example.org", "test", "invalid",
+		"10.in-addr.arpa", "168.192.in-addr.arpa",
+	}, rfc1918Reverse()...),
+	"RFC6762": {"local", "254.169.in-addr.arpa", "8.e.f.ip6.arpa", "9.e.f.ip6.arpa", "a.e.f.ip6.arpa", "b.e.f.ip6.arpa"},
+	"RFC7686": {"onion"},
+	"RFC8375": {"home.arpa"},
+	"RFC8880": {"ipv4only.arpa", "170.0.0.192.in-addr.arpa", "171.0.0.192.in-addr.arpa"},
+	"RFC9031": {"6tisch.arpa"},
+	"RFC9140": {"eap-noob.arpa"},
+	"RFC9462": {"resolver.arpa"},
+	"RFC9476": {"alt"},
+	"RFC9665": {"service.arpa"},
+}
+
+// rfc1918Reverse returns the reverse zones of 172.16.0.0/12
+func rfc1918Reverse() []string {
+	var names []string
+	for i := 16; i <= 31; i++ {
+		names = append(names, strconv.Itoa(i)+".172.in-addr.arpa")
+	}
+	return names
+}
+
+// neverDelegated are the top-level names of the registry the root zone does not delegate
+var neverDelegated = map[string]bool{"localhost": true, "example": true, "test": true, "invalid": true, "local": true, "onion": true, "alt": true}
+
+func TestRegistry(t *testing.T) {
+	want := 0
+	for rfc, names := range rfcNames {
+		for _, name := range names {
+			want++
+			n, ok := Default.Classify(name)
+			if !ok {
+				t.Errorf("%s of %s not classified", name, rfc)
+				continue
+			}
+			if n.Domain != strings.ToUpper(name) || n.RFC != rfc {
+				t.Errorf("%s: got %s of %s, want it of %s", name, n.Domain, n.RFC, rfc)
+			}
+			if n.Public == neverDelegated[name] {
+				t.Errorf("%s: got public %t", name, n.Public)
+			}
+		}
+	}
+	if got := len(Default.Names()); got != want {
+		t.Errorf("the table has %d names, the registry %d", got, want)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		kind   string
+	}{
+		{"localhost", "LOCALHOST", Localhost},
+		{"LocalHost.", "LOCALHOST", Localhost},
+		{"  app.localhost ", "LOCALHOST", Localhost},
+		{"www.example.com", "EXAMPLE.COM", Example},
+		{"a.b.c.example", "EXAMPLE", Example},
+		{"foo.test", "TEST", Test},
+		{"x.invalid.", "INVALID", Invalid},
+		{"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", "ONION", Onion},
+		{"printer.local", "LOCAL", Local},
+		{"router.home.arpa", "HOME.ARPA", Local},
+		{"1.2.168.192.in-addr.arpa", "168.192.IN-ADDR.ARPA", PrivateReverse},
+		{"5.20.172.in-addr.arpa", "20.172.IN-ADDR.ARPA", PrivateReverse},
+		{"1.0.0.0.8.e.f.ip6.arpa", "8.E.F.IP6.ARPA", PrivateReverse},
+		{"ipv4only.arpa", "IPV4ONLY.ARPA", Reserved},
+		{"wallet.alt", "ALT", Alt},
+
+		// label boundaries
+		{"notexample.com", "", ""},
+		{"example.com.evil.net", "", ""},
+		{"example.co", "", ""},
+		{"localhost.com", "", ""},
+		{"mylocalhost", "", ""},
+		{"onion.to", "", ""},
+		{"testing", "", ""},
+		{"15.172.in-addr.arpa", "", ""},
+		{"32.172.in-addr.arpa", "", ""},
+		{"7.e.f.ip6.arpa", "", ""},
+		{"c.e.f.ip6.arpa", "", ""},
+		{"arpa", "", ""},
+		{"in-addr.arpa", "", ""},
+		{"", "", ""},
+		{".", "", ""},
+	}
+	for _, tt := range tests {
+		n, ok := Default.Classify(tt.name)
+		if ok != (tt.domain != "") || n.Domain != tt.domain || n.Kind != tt.kind {
+			t.Errorf("%q: got %+v, %t, want %s %s", tt.name, n, ok, tt.domain, tt.kind)
+		}
+	}
+}
+
+func TestLongestSuffix(t *testing.T) {
+	c, err := Parse("example example RFC6761 never\nexample.com example RFC6761 public\nsub.example.com reserved RFC0 public\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"a.sub.example.com": "SUB.EXAMPLE.COM",
+		"a.example.com":     "EXAMPLE.COM",
+		"com":               "",
+	} {
+		n, _ := c.Classify(name)
+		if n.Domain != want {
+			t.Errorf("%s: got %q, want %q", name, n.Domain, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, table := range map[string]string{
+		"fields":    "localhost localhost RFC6761",
+		"kind":      "localhost loopback RFC6761 never",
+		"zones":     "localhost localhost RFC6761 sometimes",
+		"duplicate": "localhost localhost RFC6761 never\nLOCALHOST. localhost RFC6761 never",
+	} {
+		if _, err := Parse(table); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	c, err := Parse("# comment\n\n  \t\nlocalhost\tlocalhost  RFC6761\tnever\n")
+	if err != nil || len(c.Names()) != 1 {
+		t.Errorf("got %v, %v", c, err)
+	}
+}
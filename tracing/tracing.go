@@ -0,0 +1,78 @@
+// Package tracing sets up optional OpenTelemetry tracing
+// spans are exported with OTLP over HTTP, when tracing is not set up the global tracer is a no-op
+// and the instrumented packages skip creating spans
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"dnscoffee/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// enabled is set once by Setup before the server starts
+var enabled bool
+
+// Enabled returns true if spans are exported
+func Enabled() bool {
+	return enabled
+}
+
+// Tracer returns the named tracer
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// UseProvider sends the spans to provider, for tests recording them with the tracetest package of the SDK
+func UseProvider(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	enabled = true
+}
+
+// Setup starts exporting spans to the OTLP HTTP endpoint, ex: http://localhost:4318
+// sampleRatio is the fraction of new traces that are sampled, requests continuing a sampled trace are always sampled
+// the returned function flushes and stops the exporter
+func Setup(ctx context.Context, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("tracing endpoint %q must be http or https", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "dnscoffee"),
+		attribute.String("service.version", version.Version),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	enabled = true
+	return provider.Shutdown, nil
+}
@@ -1,15 +1,64 @@
 // Package version contains build version information
 package version
 
-import "fmt"
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
 
 // Git version variables
+// set at build time with -ldflags "-X dnscoffee/version.GitHash=..."
 var (
+	Version   = "?"
 	GitDate   = "?"
 	GitHash   = "?"
 	GitBranch = "?"
+	BuildDate = "?"
 )
 
+// GoVersion is the version of the go compiler that built the binary
+var GoVersion = runtime.Version()
+
+// StartTime is the time the process started
+var StartTime = time.Now().UTC()
+
+// fill in the version from the module build information for binaries built without the Makefile
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if Version == "?" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		Version = info.Main.Version
+	}
+	modified := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if GitHash == "?" {
+				GitHash = s.Value
+			}
+		case "vcs.time":
+			if GitDate == "?" {
+				GitDate = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if Version == "?" && GitHash != "?" {
+		Version = GitHash
+		if len(Version) > 12 {
+			Version = Version[:12]
+		}
+		if modified {
+			Version += "-dirty"
+		}
+	}
+}
+
 // String returns the version string
 func String() string {
 	return fmt.Sprintf("%s/%s (%s)", GitBranch, GitHash, GitDate)